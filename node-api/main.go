@@ -4,11 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,20 +21,71 @@ import (
 )
 
 type NodeStatus struct {
-	NodeID string `json:"node_id"`
-	Status string `json:"status"`
+	NodeID       string `json:"node_id"`
+	Status       string `json:"status"`
+	InstanceType string `json:"instance_type,omitempty"`
+	Zone         string `json:"zone,omitempty"`
+	Address      string `json:"address,omitempty"`
+	Port         int    `json:"port,omitempty"`
+}
+
+type CreateNodeRequest struct {
+	InstanceType   string `json:"instance_type,omitempty"`
+	Zone           string `json:"zone,omitempty"` // Empty lets the API choose
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
 }
 
 type CreateNodeResponse struct {
 	ID string `json:"id"`
 }
 
+type CreateNodesRequest struct {
+	Count          int    `json:"count"`
+	InstanceType   string `json:"instance_type,omitempty"`
+	Zone           string `json:"zone,omitempty"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+}
+
+type CreateNodesResponse struct {
+	IDs []string `json:"ids"`
+}
+
+type ErrorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+}
+
+const maxBatchSize = 50
+
+// idempotencyTTL is how long the nodes created under an idempotency key are
+// returned for a request sent again with it
+const idempotencyTTL = time.Hour
+
+// creation is the outcome of a request made with an idempotency key
+type creation struct {
+	ids []string
+	at  time.Time
+}
+
+// placement is where a node runs
+type placement struct {
+	instanceType string
+	zone         string
+}
+
 type NodeManager struct {
-	redisClient *redis.Client
-	ctx         context.Context
-	nodes       map[string]string
+	redisClient    *redis.Client
+	ctx            context.Context
+	nodes          map[string]string
 	nodeStartTimes map[string]time.Time
-	mutex       sync.RWMutex
+	placements     map[string]placement
+	zones          []string        // Zones nodes are created in; empty accepts any
+	noCapacity     map[string]bool // Zones out of capacity
+	channelPrefix  string          // Prefix of the channels the provisioning service listens on
+	creations      map[string]creation
+	creationMu     sync.Mutex
+	mutex          sync.RWMutex
 }
 
 func NewNodeManager() *NodeManager {
@@ -44,39 +98,251 @@ func NewNodeManager() *NodeManager {
 		Addr: redisAddr,
 	})
 
+	noCapacity := make(map[string]bool)
+	for _, zone := range splitList(os.Getenv("NO_CAPACITY_ZONES")) {
+		noCapacity[zone] = true
+	}
+
 	return &NodeManager{
-		redisClient: rdb,
-		ctx:         context.Background(),
-		nodes:       make(map[string]string),
+		redisClient:    rdb,
+		ctx:            context.Background(),
+		nodes:          make(map[string]string),
 		nodeStartTimes: make(map[string]time.Time),
+		placements:     make(map[string]placement),
+		zones:          splitList(os.Getenv("ZONES")),
+		noCapacity:     noCapacity,
+		channelPrefix:  os.Getenv("CHANNEL_PREFIX"),
+		creations:      make(map[string]creation),
+	}
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// chooseZone returns the zone to create nodes in: the one asked for, or the
+// first configured zone with capacity. A zone out of capacity is returned
+// with ok false.
+func (nm *NodeManager) chooseZone(zone string) (string, bool) {
+	if zone != "" {
+		return zone, !nm.noCapacity[zone]
+	}
+	for _, z := range nm.zones {
+		if !nm.noCapacity[z] {
+			return z, true
+		}
 	}
+	if len(nm.zones) > 0 {
+		return nm.zones[0], false
+	}
+	return "", true
+}
+
+// idempotencyKey returns the key a creation request carries, in the header
+// or the body
+func idempotencyKey(r *http.Request, body string) string {
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		return key
+	}
+	return body
+}
+
+// createOnce starts count nodes, unless a request with the same key did
+// within idempotencyTTL, in which case the nodes it started are returned
+// with replayed true
+func (nm *NodeManager) createOnce(key string, at placement, count int) (ids []string, replayed bool) {
+	if key == "" {
+		return nm.startNodes(at, count), false
+	}
+
+	// Held while starting, so concurrent requests with one key start nodes once
+	nm.creationMu.Lock()
+	defer nm.creationMu.Unlock()
+
+	now := time.Now()
+	for k, c := range nm.creations {
+		if now.Sub(c.at) > idempotencyTTL {
+			delete(nm.creations, k)
+		}
+	}
+	if c, ok := nm.creations[key]; ok {
+		return c.ids, true
+	}
+	ids = nm.startNodes(at, count)
+	nm.creations[key] = creation{ids: ids, at: now}
+	return ids, false
+}
+
+func (nm *NodeManager) startNodes(at placement, count int) []string {
+	ids := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		ids = append(ids, nm.startNode(at))
+	}
+	return ids
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, code int, resp ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// place checks a zone asked for and chooses one when none was, writing the
+// error response and returning false if nodes cannot be created there
+func (nm *NodeManager) place(w http.ResponseWriter, instanceType, zone string) (placement, bool) {
+	if zone != "" && len(nm.zones) > 0 && !slices.Contains(nm.zones, zone) {
+		writeError(w, http.StatusBadRequest, ErrorResponse{Error: "unknown_zone", Message: fmt.Sprintf("zone %q is not offered", zone)})
+		return placement{}, false
+	}
+	zone, ok := nm.chooseZone(zone)
+	if !ok {
+		writeError(w, http.StatusServiceUnavailable, ErrorResponse{
+			Error:   "insufficient_capacity",
+			Message: fmt.Sprintf("no capacity in zone %s", zone),
+			Zone:    zone,
+		})
+		return placement{}, false
+	}
+	return placement{instanceType: instanceType, zone: zone}, true
 }
 
 func (nm *NodeManager) CreateNode(w http.ResponseWriter, r *http.Request) {
+	var req CreateNodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, ErrorResponse{Error: "invalid_request", Message: err.Error()})
+		return
+	}
+	at, ok := nm.place(w, req.InstanceType, req.Zone)
+	if !ok {
+		return
+	}
+
+	ids, replayed := nm.createOnce(idempotencyKey(r, req.IdempotencyKey), at, 1)
+	if replayed {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(CreateNodeResponse{ID: ids[0]})
+		log.Printf("Returned node %s created earlier with the same idempotency key", ids[0])
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(CreateNodeResponse{ID: ids[0]})
+
+	log.Printf("Created node: %s in zone %q", ids[0], at.zone)
+}
+
+func (nm *NodeManager) CreateNodes(w http.ResponseWriter, r *http.Request) {
+	var req CreateNodesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Count < 1 || req.Count > maxBatchSize {
+		writeError(w, http.StatusBadRequest, ErrorResponse{
+			Error: fmt.Sprintf("count must be between 1 and %d", maxBatchSize),
+		})
+		return
+	}
+	at, ok := nm.place(w, req.InstanceType, req.Zone)
+	if !ok {
+		return
+	}
+
+	ids, replayed := nm.createOnce(idempotencyKey(r, req.IdempotencyKey), at, req.Count)
+	if replayed {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(CreateNodesResponse{IDs: ids})
+		log.Printf("Returned %d nodes created earlier with the same idempotency key", len(ids))
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(CreateNodesResponse{IDs: ids})
+
+	log.Printf("Created %d nodes in zone %q: %v", len(ids), at.zone, ids)
+}
+
+func (nm *NodeManager) startNode(at placement) string {
 	nodeID := fmt.Sprintf("node-%s", uuid.New().String()[:8])
 
 	nm.mutex.Lock()
 	nm.nodes[nodeID] = "booting"
 	nm.nodeStartTimes[nodeID] = time.Now()
+	nm.placements[nodeID] = at
 	nm.mutex.Unlock()
 
 	statusMsg := NodeStatus{
-		NodeID: nodeID,
-		Status: "booting",
+		NodeID:       nodeID,
+		Status:       "booting",
+		InstanceType: at.instanceType,
+		Zone:         at.zone,
 	}
 
 	data, _ := json.Marshal(statusMsg)
-	err := nm.redisClient.Publish(nm.ctx, "node:status", data).Err()
+	err := nm.redisClient.Publish(nm.ctx, nm.channelPrefix+"node:status", data).Err()
 	if err != nil {
 		log.Printf("Failed to publish node status: %v", err)
 	}
 
 	go nm.simulateNodeBooting(nodeID)
 
-	w.WriteHeader(http.StatusAccepted)
-	json.NewEncoder(w).Encode(CreateNodeResponse{ID: nodeID})
+	return nodeID
+}
 
-	log.Printf("Created node: %s", nodeID)
+func (nm *NodeManager) GetNode(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	nodeID := vars["node_id"]
+
+	nm.mutex.RLock()
+	status, exists := nm.nodes[nodeID]
+	at := nm.placements[nodeID]
+	nm.mutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "node_not_found"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(NodeStatus{
+		NodeID:       nodeID,
+		Status:       status,
+		InstanceType: at.instanceType,
+		Zone:         at.zone,
+	})
+}
+
+func (nm *NodeManager) GetNodeDiagnostics(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	nodeID := vars["node_id"]
+
+	nm.mutex.RLock()
+	status, exists := nm.nodes[nodeID]
+	startTime := nm.nodeStartTimes[nodeID]
+	nm.mutex.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "node_not_found"})
+		return
+	}
+
+	message := fmt.Sprintf("node %s", status)
+	if status == "booting" && !startTime.IsZero() {
+		message = fmt.Sprintf("node booting for %s", time.Since(startTime).Round(time.Second))
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":         status,
+		"status_message": message,
+		"console_output": fmt.Sprintf("[    0.000000] Booting node %s\n[    0.120000] status: %s\n", nodeID, status),
+	})
 }
 
 func (nm *NodeManager) DeleteNode(w http.ResponseWriter, r *http.Request) {
@@ -109,7 +375,7 @@ func (nm *NodeManager) DeleteNode(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data, _ := json.Marshal(statusMsg)
-	err := nm.redisClient.Publish(nm.ctx, "node:status", data).Err()
+	err := nm.redisClient.Publish(nm.ctx, nm.channelPrefix+"node:status", data).Err()
 	if err != nil {
 		log.Printf("Failed to publish node status: %v", err)
 	}
@@ -126,15 +392,20 @@ func (nm *NodeManager) simulateNodeBooting(nodeID string) {
 	nm.mutex.Lock()
 	if status, exists := nm.nodes[nodeID]; exists && status != "terminated" {
 		nm.nodes[nodeID] = "ready"
+		at := nm.placements[nodeID]
 		nm.mutex.Unlock()
 
 		statusMsg := NodeStatus{
-			NodeID: nodeID,
-			Status: "ready",
+			NodeID:       nodeID,
+			Status:       "ready",
+			InstanceType: at.instanceType,
+			Zone:         at.zone,
+			Address:      fmt.Sprintf("10.0.%d.%d", rand.Intn(256), 1+rand.Intn(254)),
+			Port:         9000,
 		}
 
 		data, _ := json.Marshal(statusMsg)
-		err := nm.redisClient.Publish(nm.ctx, "node:status", data).Err()
+		err := nm.redisClient.Publish(nm.ctx, nm.channelPrefix+"node:status", data).Err()
 		if err != nil {
 			log.Printf("Failed to publish node status: %v", err)
 		} else {
@@ -218,6 +489,9 @@ func main() {
 	r := mux.NewRouter()
 	r.HandleFunc("/", nodeManager.HealthCheck).Methods("GET")
 	r.HandleFunc("/api/nodes", nodeManager.CreateNode).Methods("POST")
+	r.HandleFunc("/api/nodes/batch", nodeManager.CreateNodes).Methods("POST")
+	r.HandleFunc("/api/nodes/{node_id}", nodeManager.GetNode).Methods("GET")
+	r.HandleFunc("/api/nodes/{node_id}/diagnostics", nodeManager.GetNodeDiagnostics).Methods("GET")
 	r.HandleFunc("/api/nodes/{node_id}", nodeManager.DeleteNode).Methods("DELETE")
 	r.HandleFunc("/api/efficiency", nodeManager.GetEfficiency).Methods("GET")
 
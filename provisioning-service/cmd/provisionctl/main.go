@@ -0,0 +1,764 @@
+// Command provisionctl operates a running provisioning service through its
+// HTTP admin API.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/pkg/client"
+)
+
+const usage = `Usage: provisionctl [flags] <command> [args]
+
+Commands:
+  nodes list                 List all nodes
+  nodes show <node-id>       Show a node's state and history
+  nodes terminate <node-id> [--force]
+                             Terminate a node; --force if a user is on it
+  nodes cordon <node-id>     Exclude a node from new allocations
+  nodes uncordon <node-id>   Return a node to service
+  nodes drain <node-id>      Terminate a node once its user disconnects
+  users list                 List connected users
+  users show <user-id>       Show a user's activity, prediction and sessions
+  users activity <user-id> [window]
+                             Show a user's activity per minute, e.g. within 15m
+  users deallocate <user-id> Tear down a user's allocation
+  users reassign <user-id>   Move a user to another ready node
+  users migrate <user-id>    Migrate a user's session to another ready node
+  access list                Show the access mode and lists
+  access block <user-id>     Deny a user nodes
+  access unblock <user-id>   Take a user off the blocklist
+  access allow <user-id>     Add a user to the allowlist
+  access disallow <user-id>  Take a user off the allowlist
+  scale set-min <n>          Set the minimum number of ready nodes
+  scale set-max <n>          Set the maximum number of nodes
+  scale check [--dry-run]    Run a scaling evaluation now
+  decision last              Show the most recent scaling decision
+  prediction show            Show the prediction parameters in effect
+  prediction set <name>=<value>...
+                             Change prediction parameters until the next
+                             change or restart, e.g. min_ready_nodes=4
+                             prediction_window=5m
+  drain status               Show the progress of draining the service
+  drain start                Stop giving out and creating nodes, and
+                             terminate each node once its users leave
+  drain cancel               Stop draining and return nodes to service
+  schedule list              List upcoming scheduled sessions
+  schedule set <file>        Replace the pushed sessions with a JSON file's
+                             {"sessions": [...]}; - reads stdin
+  state export               Write the pool, allocations and tracked users as
+                             JSON to stdout
+  state import <file>        Replace the pool, allocations and tracked users
+                             with an export; - reads stdin
+  log level [<level>]        Show or set the log level (debug|info|warn|error)
+
+Flags:
+`
+
+func main() {
+	addr := flag.String("addr", envOr("PROVISIONCTL_ADDR", "http://localhost:8081"), "provisioning service base URL")
+	token := flag.String("token", os.Getenv("PROVISIONCTL_TOKEN"), "admin API bearer token")
+	timeout := flag.Duration("timeout", 10*time.Second, "request timeout")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	c := client.New(*addr, client.WithToken(*token), client.WithTimeout(*timeout))
+	defer c.Close()
+
+	if err := run(context.Background(), c, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, c *client.Client, args []string) error {
+	if len(args) < 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	switch args[0] + " " + args[1] {
+	case "nodes list":
+		return listNodes(ctx, c)
+	case "nodes show":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: provisionctl nodes show <node-id>")
+		}
+		return showNode(ctx, c, args[2])
+	case "nodes terminate":
+		force := len(args) == 4 && args[3] == "--force"
+		if len(args) < 3 || len(args) > 4 || (len(args) == 4 && !force) {
+			return fmt.Errorf("usage: provisionctl nodes terminate <node-id> [--force]")
+		}
+		return nodeAction(ctx, c, args[1], args[2], force)
+	case "nodes cordon", "nodes uncordon", "nodes drain":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: provisionctl nodes %s <node-id>", args[1])
+		}
+		return nodeAction(ctx, c, args[1], args[2], false)
+	case "users list":
+		return listUsers(ctx, c)
+	case "users show":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: provisionctl users show <user-id>")
+		}
+		return showUser(ctx, c, args[2])
+	case "users activity":
+		if len(args) != 3 && len(args) != 4 {
+			return fmt.Errorf("usage: provisionctl users activity <user-id> [window]")
+		}
+		var window time.Duration
+		if len(args) == 4 {
+			var err error
+			if window, err = time.ParseDuration(args[3]); err != nil {
+				return fmt.Errorf("invalid window: %w", err)
+			}
+		}
+		return showActivity(ctx, c, args[2], window)
+	case "users deallocate", "users reassign", "users migrate":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: provisionctl users %s <user-id>", args[1])
+		}
+		return userAction(ctx, c, args[1], args[2])
+	case "access list":
+		return listAccess(ctx, c)
+	case "access block", "access unblock", "access allow", "access disallow":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: provisionctl access %s <user-id>", args[1])
+		}
+		return accessAction(ctx, c, args[1], args[2])
+	case "scale set-min", "scale set-max":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: provisionctl scale %s <n>", args[1])
+		}
+		n, err := strconv.Atoi(args[2])
+		if err != nil {
+			return fmt.Errorf("invalid node count %q", args[2])
+		}
+		return setScale(ctx, c, args[1], n)
+	case "scale check":
+		dryRun := len(args) == 3 && args[2] == "--dry-run"
+		if len(args) > 3 || (len(args) == 3 && !dryRun) {
+			return fmt.Errorf("usage: provisionctl scale check [--dry-run]")
+		}
+		return checkScale(ctx, c, dryRun)
+	case "decision last":
+		return lastDecision(ctx, c)
+	case "prediction show":
+		return showPrediction(ctx, c)
+	case "prediction set":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: provisionctl prediction set <name>=<value>...")
+		}
+		return setPrediction(ctx, c, args[2:])
+	case "drain status", "drain start":
+		return drain(ctx, c, args[1] == "start")
+	case "drain cancel":
+		return cancelDrain(ctx, c)
+	case "schedule list":
+		return listSchedule(ctx, c)
+	case "schedule set":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: provisionctl schedule set <file>")
+		}
+		return setSchedule(ctx, c, args[2])
+	case "state export":
+		return exportState(ctx, c)
+	case "state import":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: provisionctl state import <file>")
+		}
+		return importState(ctx, c, args[2])
+	case "log level":
+		switch len(args) {
+		case 2:
+			return showLogLevel(ctx, c)
+		case 3:
+			return setLogLevel(ctx, c, args[2])
+		default:
+			return fmt.Errorf("usage: provisionctl log level [<level>]")
+		}
+	default:
+		return fmt.Errorf("unknown command %q", args[0]+" "+args[1])
+	}
+}
+
+func listNodes(ctx context.Context, c *client.Client) error {
+	nodes, err := c.Nodes(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tUSERS\tSLOTS\tADDRESS\tAGENT\tFLAGS\tAGE")
+	for _, n := range nodes {
+		var flags []string
+		if n.Draining {
+			flags = append(flags, "draining:"+n.TerminationReason)
+		} else if n.Cordoned {
+			flags = append(flags, "cordoned")
+		} else if n.TerminationReason != "" {
+			flags = append(flags, n.TerminationReason)
+		}
+		if n.Burst {
+			flags = append(flags, "burst")
+		}
+		address := "-"
+		if n.Address != "" {
+			address = fmt.Sprintf("%s:%d", n.Address, n.Port)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d/%d\t%s\t%s\t%s\t%s\n",
+			n.ID, n.Status, orDash(strings.Join(n.Users, ",")), len(n.Users), n.Capacity,
+			address, orDash(n.AgentVersion), orDash(strings.Join(flags, ",")), age(n.CreatedAt))
+	}
+	return w.Flush()
+}
+
+func showNode(ctx context.Context, c *client.Client, nodeID string) error {
+	history, err := c.NodeHistory(ctx, nodeID)
+	if err != nil {
+		return err
+	}
+
+	status := "left the pool"
+	if n := history.Node; n != nil {
+		status = n.Status
+		if n.TerminationReason != "" {
+			status += " (" + n.TerminationReason + ")"
+		}
+		if history.Archived {
+			status += ", purged from the pool"
+		}
+	}
+	p := history.Provisioning
+	fmt.Printf("node:     %s\nstatus:   %s\nprovider: %s\ntype:     %s\nattempt:  %d\nage:      %s\n",
+		history.ID, status, orDash(p.Provider), orDash(p.InstanceType), p.BootAttempt, age(p.CreatedAt))
+	if n := history.Node; n != nil {
+		fmt.Printf("users:    %s\n", orDash(strings.Join(n.Users, ",")))
+	}
+
+	if len(history.Events) == 0 {
+		return nil
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tEVENT\tUSER\tDETAIL")
+	for _, e := range history.Events {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			time.UnixMilli(e.Timestamp).Format(time.DateTime), e.Type, orDash(e.UserID), orDash(eventDetail(e)))
+	}
+	return w.Flush()
+}
+
+// eventDetail summarizes a journaled event's payload
+func eventDetail(e client.FeedEvent) string {
+	switch e.Type {
+	case client.EventNodeTransition:
+		var t client.NodeTransition
+		if e.Decode(&t) == nil {
+			return fmt.Sprintf("%s -> %s: %s", orDash(t.From), t.To, t.Reason)
+		}
+	case client.EventAllocation:
+		var a client.AllocationChange
+		if e.Decode(&a) == nil {
+			if a.PreviousNodeID != "" {
+				return fmt.Sprintf("%s (%s -> %s)", a.Action, a.PreviousNodeID, e.NodeID)
+			}
+			return a.Action
+		}
+	case client.EventBootFailure:
+		var f client.BootFailure
+		if e.Decode(&f) == nil {
+			return fmt.Sprintf("attempt %d: %s", f.Attempt, f.Reason)
+		}
+	}
+	return ""
+}
+
+func listUsers(ctx context.Context, c *client.Client) error {
+	users, err := c.Users(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "USER\tNODE\tSESSIONS\tACTIVITIES\tSCORE\tLAST ACTIVITY")
+	for _, u := range users {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.1f\t%s\n",
+			u.UserID, orDash(u.AllocatedNodeID), u.OpenSessions, u.ActivityCount, u.ActivityScore, age(u.LastActivity))
+	}
+	return w.Flush()
+}
+
+func showUser(ctx context.Context, c *client.Client, userID string) error {
+	history, err := c.UserHistory(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	node := "-"
+	switch {
+	case history.Connected:
+		node = history.AllocatedNodeID
+	case history.WaitingSince != 0:
+		node = "waiting for " + age(history.WaitingSince)
+	}
+	last := "never"
+	if history.Activity.LastActivity != 0 {
+		last = age(history.Activity.LastActivity) + " ago"
+	}
+	likely := "no"
+	if history.Prediction.Likely {
+		likely = "yes"
+	}
+	fmt.Printf("user:       %s\ntenant:     %s\nnode:       %s\nactivities: %d (score %.1f), last %s\nlikely:     %s, %s\n",
+		history.UserID, orDash(history.TenantID), node, history.Activity.Count, history.Activity.Score,
+		last, likely, history.Prediction.Reason)
+	if peak := history.Activity.Peak; peak.Count > 0 {
+		burst := ""
+		if history.Activity.Burst.Bursting {
+			burst = fmt.Sprintf(", bursting at %d/min", history.Activity.Burst.Count)
+		}
+		fmt.Printf("peak:       %d/min at %s%s\n", peak.Count, time.Unix(peak.Start, 0).Format(time.DateTime), burst)
+	}
+	if len(history.OpenSessions) > 0 {
+		ids := make([]string, len(history.OpenSessions))
+		for i, s := range history.OpenSessions {
+			ids[i] = orDash(s.SessionID)
+		}
+		fmt.Printf("sessions:   %s\n", strings.Join(ids, ", "))
+	}
+	if r := history.Recommendation; r != nil && r.Recommended != "" {
+		fmt.Printf("size:       %s -> %s, %s\n", orDash(r.InstanceType), r.Recommended, r.Reason)
+	}
+
+	if len(history.Sessions) == 0 {
+		return nil
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tSTARTED\tDURATION\tENDED BY")
+	for _, s := range history.Sessions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+			s.NodeID, time.UnixMilli(s.StartedAt).Format(time.DateTime),
+			(time.Duration(s.DurationSeconds) * time.Second).String(), orDash(s.EndReason))
+	}
+	return w.Flush()
+}
+
+// showActivity prints a user's activity per minute within window, with a
+// bar scaled to the busiest minute
+func showActivity(ctx context.Context, c *client.Client, userID string, window time.Duration) error {
+	activity, err := c.UserActivity(ctx, userID, window)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d activities in %s", activity.Count, time.Duration(activity.WindowSeconds)*time.Second)
+	if activity.Burst.Bursting {
+		fmt.Printf(", bursting at %d/min against %.1f/min", activity.Burst.Count, activity.Burst.Baseline)
+	}
+	fmt.Println()
+	if len(activity.Histogram) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MINUTE\tCOUNT\t")
+	for _, b := range activity.Histogram {
+		bar := strings.Repeat("#", max(1, b.Count*40/activity.Peak.Count))
+		fmt.Fprintf(w, "%s\t%d\t%s\n", time.Unix(b.Start, 0).Format("2006-01-02 15:04"), b.Count, bar)
+	}
+	return w.Flush()
+}
+
+func nodeAction(ctx context.Context, c *client.Client, action, nodeID string, force bool) error {
+	var err error
+	switch action {
+	case "terminate":
+		err = c.TerminateNode(ctx, nodeID, force)
+	case "cordon":
+		err = c.CordonNode(ctx, nodeID)
+	case "uncordon":
+		err = c.UncordonNode(ctx, nodeID)
+	case "drain":
+		err = c.DrainNode(ctx, nodeID)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("node %s: %s requested\n", nodeID, action)
+	return nil
+}
+
+func userAction(ctx context.Context, c *client.Client, action, userID string) error {
+	var result client.UserAction
+	var err error
+	switch action {
+	case "deallocate":
+		result, err = c.DeallocateUser(ctx, userID)
+	case "reassign":
+		result, err = c.ReassignUser(ctx, userID)
+	case "migrate":
+		result, err = c.MigrateUser(ctx, userID)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case result.MigrationID != "":
+		fmt.Printf("user %s: migrating from %s to %s (migration %s)\n", userID, result.PreviousNodeID, result.NodeID, result.MigrationID)
+	case result.NodeID != "":
+		fmt.Printf("user %s: moved from %s to %s\n", userID, result.PreviousNodeID, result.NodeID)
+	default:
+		fmt.Printf("user %s: released %s\n", userID, result.PreviousNodeID)
+	}
+	return nil
+}
+
+func listAccess(ctx context.Context, c *client.Client) error {
+	access, err := c.Access(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("mode:       %s\nauthorizer: %t\nblocklist:  %s\nallowlist:  %s\n",
+		access.Mode, access.Authorizer,
+		orDash(strings.Join(access.Blocklist, ",")), orDash(strings.Join(access.Allowlist, ",")))
+	return nil
+}
+
+func accessAction(ctx context.Context, c *client.Client, action, userID string) error {
+	var err error
+	switch action {
+	case "block":
+		_, err = c.AddToList(ctx, client.Blocklist, userID)
+	case "unblock":
+		_, err = c.RemoveFromList(ctx, client.Blocklist, userID)
+	case "allow":
+		_, err = c.AddToList(ctx, client.Allowlist, userID)
+	case "disallow":
+		_, err = c.RemoveFromList(ctx, client.Allowlist, userID)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("user %s: %s\n", userID, action)
+	return nil
+}
+
+func listSchedule(ctx context.Context, c *client.Client) error {
+	schedule, err := c.Schedule(ctx)
+	if err != nil {
+		return err
+	}
+	printSchedule(schedule)
+	return nil
+}
+
+func setSchedule(ctx context.Context, c *client.Client, path string) error {
+	data, err := readFile(path)
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Sessions []client.Session `json:"sessions"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("invalid sessions file: %w", err)
+	}
+
+	schedule, err := c.SetSessions(ctx, list.Sessions)
+	if err != nil {
+		return err
+	}
+	printSchedule(schedule)
+	return nil
+}
+
+func printSchedule(schedule client.Schedule) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tTITLE\tSTART\tEND\tATTENDEES\tTENANT\tSOURCE")
+	for _, s := range schedule.Sessions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			s.ID, orDash(s.Title), s.Start.Local().Format(time.DateTime), s.End.Local().Format(time.DateTime),
+			s.Attendees, orDash(s.TenantID), s.Source)
+	}
+	w.Flush()
+	fmt.Printf("\nexpected within the prediction window: %.0f attendees (%s)\n",
+		schedule.ExpectedAttendees, orDash(strings.Join(schedule.ExpectedSessions, ",")))
+}
+
+func exportState(ctx context.Context, c *client.Client) error {
+	state, err := c.ExportState(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Println(string(state))
+	return err
+}
+
+func importState(ctx context.Context, c *client.Client, path string) error {
+	data, err := readFile(path)
+	if err != nil {
+		return err
+	}
+	if !json.Valid(data) {
+		return fmt.Errorf("invalid state file: not JSON")
+	}
+
+	result, err := c.ImportState(ctx, data)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported schema version %d: %d nodes, %d users (%d connected), %d nodes replaced\n",
+		result.SchemaVersion, result.Nodes, result.Users, result.ConnectedUsers, result.ReplacedNodes)
+	return nil
+}
+
+// readFile reads a file, or stdin for -
+func readFile(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func setScale(ctx context.Context, c *client.Client, which string, n int) error {
+	var limits client.ScaleLimits
+	var err error
+	if which == "set-min" {
+		limits, err = c.SetMinReadyNodes(ctx, n)
+	} else {
+		limits, err = c.SetMaxReadyNodes(ctx, n)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("min_ready_nodes=%d max_ready_nodes=%d\n", limits.MinReadyNodes, limits.MaxReadyNodes)
+	return nil
+}
+
+func checkScale(ctx context.Context, c *client.Client, dryRun bool) error {
+	// A failed scale-up still reports the decision
+	result, checkErr := c.CheckScaling(ctx, dryRun)
+	var apiErr *client.Error
+	if checkErr != nil && !errors.As(checkErr, &apiErr) {
+		return checkErr
+	}
+
+	fmt.Printf("action:      %s\ntarget:      %d\nreason:      %s\n",
+		scalingAction(result.ShouldScaleUp, result.ShouldScaleDown), result.TargetNodes, orDash(result.Reason))
+	switch {
+	case dryRun:
+		fmt.Println("outcome:     dry run, nothing changed")
+	case result.Deferred:
+		fmt.Println("outcome:     deferred by scale-up cooldown")
+	default:
+		fmt.Printf("provisioned: %d\n", result.Provisioned)
+	}
+	if err := printTypeDecisions(result.Decision); err != nil {
+		return err
+	}
+	return checkErr
+}
+
+func lastDecision(ctx context.Context, c *client.Client) error {
+	decision, err := c.Decision(ctx)
+	if err != nil {
+		return err
+	}
+
+	if decision.DecidedAt == 0 {
+		fmt.Println("no scaling decision has been made yet")
+		return nil
+	}
+
+	fmt.Printf("action:  %s\ntarget:  %d\nreason:  %s\ndecided: %s ago\n",
+		scalingAction(decision.ShouldScaleUp, decision.ShouldScaleDown),
+		decision.TargetNodes, orDash(decision.Reason), age(decision.DecidedAt))
+	return printTypeDecisions(decision)
+}
+
+// printTypeDecisions lists per-instance-type decisions, if any
+func printTypeDecisions(decision client.Decision) error {
+	if len(decision.InstanceTypes) == 0 {
+		return nil
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tACTION\tTARGET\tREASON")
+	for _, t := range decision.InstanceTypes {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n",
+			t.InstanceType, scalingAction(t.ShouldScaleUp, t.ShouldScaleDown), t.TargetNodes, orDash(t.Reason))
+	}
+	return w.Flush()
+}
+
+func scalingAction(up, down bool) string {
+	switch {
+	case up:
+		return "scale up"
+	case down:
+		return "scale down"
+	default:
+		return "none"
+	}
+}
+
+func showPrediction(ctx context.Context, c *client.Client) error {
+	config, err := c.PredictionConfig(ctx)
+	if err != nil {
+		return err
+	}
+	printPrediction(config)
+	return nil
+}
+
+// predictionDurations are the prediction parameters given as durations,
+// sent in seconds
+var predictionDurations = map[string]bool{
+	"activity_window":          true,
+	"prediction_window":        true,
+	"idle_termination_timeout": true,
+}
+
+func setPrediction(ctx context.Context, c *client.Client, assignments []string) error {
+	changes := make(map[string]any, len(assignments))
+	for _, assignment := range assignments {
+		name, value, ok := strings.Cut(assignment, "=")
+		if !ok {
+			return fmt.Errorf("invalid assignment %q: want <name>=<value>", assignment)
+		}
+		if predictionDurations[name] {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid duration for %s: %q", name, value)
+			}
+			changes[name+"_seconds"] = d.Seconds()
+			continue
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number for %s: %q", name, value)
+		}
+		changes[name] = n
+	}
+
+	config, err := c.UpdatePredictionConfig(ctx, changes)
+	if err != nil {
+		return err
+	}
+	printPrediction(config)
+	return nil
+}
+
+func printPrediction(config client.PredictionConfig) {
+	seconds := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second))
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "activity_window\t%s\n", seconds(config.ActivityWindowSeconds))
+	fmt.Fprintf(w, "activity_threshold\t%d\n", config.ActivityThreshold)
+	fmt.Fprintf(w, "prediction_window\t%s\n", seconds(config.PredictionWindowSeconds))
+	fmt.Fprintf(w, "target_headroom\t%g\n", config.TargetHeadroom)
+	fmt.Fprintf(w, "min_ready_nodes\t%d\n", config.MinReadyNodes)
+	fmt.Fprintf(w, "max_ready_nodes\t%d\n", config.MaxReadyNodes)
+	fmt.Fprintf(w, "burst_max_nodes\t%d\n", config.BurstMaxNodes)
+	fmt.Fprintf(w, "idle_termination_timeout\t%s\n", seconds(config.IdleTerminationTimeoutSeconds))
+	fmt.Fprintf(w, "surplus_margin\t%g\n", config.SurplusMargin)
+	w.Flush()
+}
+
+func drain(ctx context.Context, c *client.Client, start bool) error {
+	get := c.Drain
+	if start {
+		get = c.StartDrain
+	}
+	drain, err := get(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !drain.Draining {
+		fmt.Println("not draining")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "started\t%s\n", time.Unix(drain.StartedAt, 0).Format(time.RFC3339))
+	fmt.Fprintf(w, "nodes\t%d of %d left\n", drain.Nodes, drain.NodesAtStart)
+	fmt.Fprintf(w, "occupied nodes\t%d\n", drain.OccupiedNodes)
+	fmt.Fprintf(w, "users\t%d\n", drain.Users)
+	fmt.Fprintf(w, "complete\t%t\n", drain.Complete)
+	return w.Flush()
+}
+
+func cancelDrain(ctx context.Context, c *client.Client) error {
+	restored, err := c.CancelDrain(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("drain canceled; %d nodes returned to service\n", restored)
+	return nil
+}
+
+func showLogLevel(ctx context.Context, c *client.Client) error {
+	level, err := c.LogLevel(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(level)
+	return nil
+}
+
+func setLogLevel(ctx context.Context, c *client.Client, level string) error {
+	level, err := c.SetLogLevel(ctx, level)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("log level: %s\n", level)
+	return nil
+}
+
+func age(unix int64) string {
+	if unix == 0 {
+		return "-"
+	}
+	return time.Since(time.Unix(unix, 0)).Round(time.Second).String()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
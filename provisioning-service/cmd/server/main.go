@@ -1,12 +1,37 @@
 package main
 
 import (
+	"flag"
+	"os"
+	"strings"
+
 	"github.com/aos-cc/provisioning-service/internal/app"
-	"go.uber.org/fx"
 )
 
+// configPaths collects repeated -config flags
+type configPaths []string
+
+func (p *configPaths) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *configPaths) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
 func main() {
-	fx.New(
-		app.Module,
-	).Run()
+	var paths configPaths
+	flag.Var(&paths, "config", "config file (JSON, YAML or TOML); repeat to layer files")
+	environment := flag.String("env", os.Getenv("APP_ENV"), "environment overlay applied after each config file")
+	profile := flag.String("profile", os.Getenv("APP_PROFILE"), "deployment profile (dev, staging or prod) whose defaults apply beneath the config files")
+	dev := flag.Bool("dev", false, "run with a fake node provider and an in-process event bus instead of the Node API and Redis")
+	flag.Parse()
+
+	app.New(app.ConfigSource{
+		Paths:       paths,
+		Environment: *environment,
+		Profile:     *profile,
+		Dev:         *dev,
+	}).Run()
 }
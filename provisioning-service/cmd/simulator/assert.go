@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// assertion compares a metric with a value once the run has settled
+type assertion struct {
+	text  string
+	path  string // Dotted path into /metrics, or sim.<result>
+	op    string
+	value float64
+}
+
+// operators, longest first so >= is not read as >
+var operators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// parseAssertion parses an assertion such as nodes.ready>=2
+func parseAssertion(s string) (assertion, error) {
+	for _, op := range operators {
+		path, valueText, ok := strings.Cut(s, op)
+		if !ok {
+			continue
+		}
+		path = strings.TrimSpace(path)
+		value, err := strconv.ParseFloat(strings.TrimSpace(valueText), 64)
+		if path == "" || err != nil {
+			break
+		}
+		return assertion{text: s, path: path, op: op, value: value}, nil
+	}
+	return assertion{}, fmt.Errorf("invalid assertion %q; want <path><op><number> with op one of %s", s, strings.Join(operators, " "))
+}
+
+// holds reports whether the assertion holds for a metric value
+func (a assertion) holds(got float64) bool {
+	switch a.op {
+	case ">=":
+		return got >= a.value
+	case "<=":
+		return got <= a.value
+	case "==":
+		return got == a.value
+	case "!=":
+		return got != a.value
+	case ">":
+		return got > a.value
+	default:
+		return got < a.value
+	}
+}
+
+// lookup finds a number by dotted path in decoded JSON
+func lookup(doc map[string]any, path string) (float64, bool) {
+	var current any = doc
+	for key := range strings.SplitSeq(path, ".") {
+		m, ok := current.(map[string]any)
+		if !ok {
+			return 0, false
+		}
+		if current, ok = m[key]; !ok {
+			return 0, false
+		}
+	}
+	switch v := current.(type) {
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
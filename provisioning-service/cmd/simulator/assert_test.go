@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAssertion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want assertion
+		err  bool
+	}{
+		{in: "nodes.ready>=2", want: assertion{path: "nodes.ready", op: ">=", value: 2}},
+		{in: " sim.wait_p95 <= 1.5 ", want: assertion{path: "sim.wait_p95", op: "<=", value: 1.5}},
+		{in: "nodes.ready==0", want: assertion{path: "nodes.ready", op: "==", value: 0}},
+		{in: "nodes.ready!=-1", want: assertion{path: "nodes.ready", op: "!=", value: -1}},
+		{in: "users.connected>3", want: assertion{path: "users.connected", op: ">", value: 3}},
+		{in: "users.connected<3", want: assertion{path: "users.connected", op: "<", value: 3}},
+		{in: "nodes.ready", err: true},
+		{in: "nodes.ready>=", err: true},
+		{in: ">=2", err: true},
+		{in: "nodes.ready=2", err: true},
+		{in: "nodes.ready>=two", err: true},
+		{in: "nodes.ready>=nodes.booting", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseAssertion(tt.in)
+		if tt.err {
+			if err == nil || !strings.Contains(err.Error(), "invalid assertion") {
+				t.Errorf("parseAssertion(%q) = %+v, %v; want an invalid assertion", tt.in, got, err)
+			}
+			continue
+		}
+		tt.want.text = tt.in
+		if err != nil || got != tt.want {
+			t.Errorf("parseAssertion(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestAssertionHolds(t *testing.T) {
+	tests := []struct {
+		op        string
+		below, at bool
+		above     bool
+	}{
+		{">=", false, true, true},
+		{"<=", true, true, false},
+		{"==", false, true, false},
+		{"!=", true, false, true},
+		{">", false, false, true},
+		{"<", true, false, false},
+	}
+	for _, tt := range tests {
+		a := assertion{op: tt.op, value: 2}
+		if a.holds(1) != tt.below || a.holds(2) != tt.at || a.holds(3) != tt.above {
+			t.Errorf("%s 2 holds for 1, 2, 3 = %v %v %v; want %v %v %v",
+				tt.op, a.holds(1), a.holds(2), a.holds(3), tt.below, tt.at, tt.above)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	doc := map[string]any{
+		"nodes":  map[string]any{"ready": 2.0, "draining": true},
+		"leader": false,
+		"name":   "sim",
+	}
+	tests := []struct {
+		path  string
+		want  float64
+		found bool
+	}{
+		{"nodes.ready", 2, true},
+		{"nodes.draining", 1, true},
+		{"leader", 0, true},
+		{"name", 0, false},
+		{"nodes", 0, false},
+		{"nodes.booting", 0, false},
+		{"nodes.ready.count", 0, false},
+	}
+	for _, tt := range tests {
+		if got, found := lookup(doc, tt.path); got != tt.want || found != tt.found {
+			t.Errorf("lookup(%q) = %g, %v; want %g, %v", tt.path, got, found, tt.want, tt.found)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Command simulator loads a provisioning service with synthetic users. It
+// publishes activity, connect and disconnect events on the service's Redis
+// at a configurable arrival rate, each user following a behavior profile,
+// and can stand in for the node agents by reporting booting nodes ready.
+// Once the run has settled it checks assertions against the pool metrics
+// and its own results, for capacity and regression testing of the
+// predictor.
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/infra/redis"
+	"github.com/aos-cc/provisioning-service/pkg/client"
+	"go.uber.org/zap"
+)
+
+const usage = `Usage: simulator [flags]
+
+Publishes synthetic user events on the service's Redis for -duration, waits
+-settle for the pool to catch up, then reports and checks each -assert.
+
+Profiles (-profile name or a weighted mix such as steady:3,churn:1):
+%s
+Assertions (-assert, repeatable) compare a number from the service's /metrics,
+by dotted path, or one of the simulator's own results under sim.:
+  -assert 'nodes.ready>=2' -assert 'sim.success_ratio>=0.95'
+  -assert 'sim.alloc_p95_seconds<5' -assert 'sim.peak_nodes<=20'
+
+Flags:
+`
+
+// assertions collects repeated -assert flags
+type assertions []string
+
+func (a *assertions) String() string {
+	return strings.Join(*a, ",")
+}
+
+func (a *assertions) Set(value string) error {
+	*a = append(*a, value)
+	return nil
+}
+
+func main() {
+	var asserts assertions
+	cfg := config{}
+	flag.StringVar(&cfg.redisAddr, "redis", envOr("SIMULATOR_REDIS_ADDR", "localhost:6379"), "Redis address the service subscribes on")
+	redisPassword := flag.String("redis-password", os.Getenv("SIMULATOR_REDIS_PASSWORD"), "Redis password")
+	flag.StringVar(&cfg.prefix, "channel-prefix", "", "prefix the service puts on its channel names (events.channel_prefix)")
+	flag.StringVar(&cfg.target, "addr", envOr("SIMULATOR_ADDR", "http://localhost:8081"), "provisioning service base URL, for metrics and node status")
+	token := flag.String("token", os.Getenv("SIMULATOR_TOKEN"), "admin API bearer token with the viewer role, for -node-agent")
+	flag.DurationVar(&cfg.duration, "duration", 5*time.Minute, "how long users keep arriving")
+	flag.DurationVar(&cfg.settle, "settle", 30*time.Second, "how long to wait after the run before checking assertions")
+	flag.IntVar(&cfg.users, "users", 100, "size of the user population; arrivals wait while every user is busy")
+	flag.Float64Var(&cfg.rate, "rate", 1, "users arriving per second")
+	flag.DurationVar(&cfg.burstEvery, "burst-every", 0, "release arrivals together at this interval instead of one by one")
+	mix := flag.String("profile", "steady", "user behavior profile, or a weighted mix of profiles")
+	flag.DurationVar(&cfg.replyTimeout, "reply-timeout", 30*time.Second, "how long a connect waits for its allocation result")
+	flag.BoolVar(&cfg.nodeAgent, "node-agent", false, "report nodes booting for -boot-time ready, as their agents would")
+	flag.DurationVar(&cfg.bootTime, "boot-time", 20*time.Second, "how long a node boots before -node-agent reports it ready")
+	flag.DurationVar(&cfg.sampleEvery, "sample-every", 5*time.Second, "how often pool metrics are sampled and progress is printed")
+	flag.Int64Var(&cfg.seed, "seed", 0, "random seed; 0 picks one")
+	flag.Var(&asserts, "assert", "assertion checked after the run, e.g. nodes.ready>=2; repeatable")
+	flag.StringVar(&cfg.sealMode, "seal-mode", os.Getenv("SIMULATOR_SEAL_MODE"), "seal events as the service's events.encryption.mode does: sign or encrypt; empty sends them plain")
+	flag.StringVar(&cfg.sealKey, "seal-key", os.Getenv("SIMULATOR_SEAL_KEY"), "key events are sealed with, as <key-id>=<base64 key> from events.encryption.keys")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, usage, describeProfiles())
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if err := cfg.parse(*mix, asserts); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rdb, err := redis.NewClient(redis.Options{Addr: cfg.redisAddr, Password: *redisPassword}, zap.NewNop())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error: connect to redis:", err)
+		os.Exit(1)
+	}
+	defer rdb.Close()
+
+	c := client.New(cfg.target, client.WithToken(*token))
+	defer c.Close()
+
+	namespace := events.Namespace{Prefix: cfg.prefix}
+	var publisher events.Publisher = redis.NewPublisher(rdb, namespace)
+	if cfg.keyring.Enabled() {
+		publisher = events.NewSealingPublisher(publisher, cfg.keyring, namespace)
+	}
+	sim := newSimulation(cfg, rdb, publisher, cfg.keyring, c)
+	failed, err := sim.run(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// config holds the simulation settings
+type config struct {
+	redisAddr    string
+	prefix       string
+	target       string
+	duration     time.Duration
+	settle       time.Duration
+	users        int
+	rate         float64
+	burstEvery   time.Duration
+	mix          []weightedProfile
+	replyTimeout time.Duration
+	nodeAgent    bool
+	bootTime     time.Duration
+	sampleEvery  time.Duration
+	seed         int64
+	asserts      []assertion
+	sealMode     string
+	sealKey      string
+	keyring      *events.Keyring // Nil unless -seal-mode is set
+}
+
+// parse checks the settings and parses the profile mix and assertions
+func (c *config) parse(mix string, asserts []string) error {
+	switch {
+	case c.duration <= 0:
+		return fmt.Errorf("invalid duration: %s", c.duration)
+	case c.settle < 0:
+		return fmt.Errorf("invalid settle: %s", c.settle)
+	case c.users < 1:
+		return fmt.Errorf("invalid users: %d", c.users)
+	case c.rate <= 0:
+		return fmt.Errorf("invalid rate: %g", c.rate)
+	case c.burstEvery < 0:
+		return fmt.Errorf("invalid burst interval: %s", c.burstEvery)
+	case c.replyTimeout <= 0:
+		return fmt.Errorf("invalid reply timeout: %s", c.replyTimeout)
+	case c.bootTime < 0:
+		return fmt.Errorf("invalid boot time: %s", c.bootTime)
+	case c.sampleEvery <= 0:
+		return fmt.Errorf("invalid sample interval: %s", c.sampleEvery)
+	}
+
+	var err error
+	if c.mix, err = parseMix(mix); err != nil {
+		return err
+	}
+	for _, s := range asserts {
+		a, err := parseAssertion(s)
+		if err != nil {
+			return err
+		}
+		c.asserts = append(c.asserts, a)
+	}
+	if c.seed == 0 {
+		c.seed = time.Now().UnixNano()
+	}
+	return c.parseSealing()
+}
+
+// parseSealing builds the keyring events are sealed with; replies that are
+// not sealed are still read
+func (c *config) parseSealing() error {
+	if c.sealMode == "" {
+		return nil
+	}
+	id, encoded, ok := strings.Cut(c.sealKey, "=")
+	if !ok || id == "" {
+		return fmt.Errorf("invalid seal key; want <key-id>=<base64 key>")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("invalid seal key %s: %w", id, err)
+	}
+	c.keyring, err = events.NewKeyring(c.sealMode, map[string][]byte{id: key}, id, false, events.DefaultSealMaxSkew)
+	return err
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+)
+
+// profile is how a simulated user behaves from arriving to leaving
+type profile struct {
+	name        string
+	description string
+	lead        time.Duration // Active for this long before connecting, or leaving
+	every       time.Duration // Between activity events while active
+	activity    string        // Activity type reported before connecting
+	connects    float64       // Share of arriving users who go on to connect
+	session     time.Duration // Mean session length; sessions are exponentially distributed
+}
+
+// profiles are the built-in user behaviors
+var profiles = []profile{
+	{
+		name:        "steady",
+		description: "browses for 2m, most connect for ~10m sessions",
+		lead:        2 * time.Minute,
+		every:       15 * time.Second,
+		activity:    events.ActivityTypeEditorOpen,
+		connects:    0.8,
+		session:     10 * time.Minute,
+	},
+	{
+		name:        "eager",
+		description: "connects 10s after arriving, ~20m sessions",
+		lead:        10 * time.Second,
+		every:       5 * time.Second,
+		activity:    events.ActivityTypeJobSubmit,
+		connects:    1,
+		session:     20 * time.Minute,
+	},
+	{
+		name:        "churn",
+		description: "connects after 30s for ~1m sessions",
+		lead:        30 * time.Second,
+		every:       10 * time.Second,
+		activity:    events.ActivityTypeEditorOpen,
+		connects:    1,
+		session:     time.Minute,
+	},
+	{
+		name:        "browsing",
+		description: "active for 3m, few connect; tests false positives",
+		lead:        3 * time.Minute,
+		every:       20 * time.Second,
+		activity:    events.ActivityTypePageView,
+		connects:    0.1,
+		session:     5 * time.Minute,
+	},
+}
+
+// weightedProfile is a profile and its share of arrivals
+type weightedProfile struct {
+	profile
+	weight float64
+}
+
+// parseMix parses a profile name or a weighted mix such as steady:3,churn:1
+func parseMix(mix string) ([]weightedProfile, error) {
+	var result []weightedProfile
+	for part := range strings.SplitSeq(mix, ",") {
+		name, weightText, weighted := strings.Cut(strings.TrimSpace(part), ":")
+		i := slices.IndexFunc(profiles, func(p profile) bool { return p.name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown profile %q", name)
+		}
+		weight := 1.0
+		if weighted {
+			var err error
+			if weight, err = strconv.ParseFloat(weightText, 64); err != nil || weight <= 0 {
+				return nil, fmt.Errorf("invalid weight for profile %s: %q", name, weightText)
+			}
+		}
+		result = append(result, weightedProfile{profile: profiles[i], weight: weight})
+	}
+	return result, nil
+}
+
+// pick draws a profile by weight
+func pick(mix []weightedProfile, rng *rand.Rand) profile {
+	total := 0.0
+	for _, p := range mix {
+		total += p.weight
+	}
+	draw := rng.Float64() * total
+	for _, p := range mix {
+		if draw < p.weight {
+			return p.profile
+		}
+		draw -= p.weight
+	}
+	return mix[len(mix)-1].profile
+}
+
+// describeProfiles lists the built-in profiles for the usage message
+func describeProfiles() string {
+	var b strings.Builder
+	for _, p := range profiles {
+		fmt.Fprintf(&b, "  %-10s %s\n", p.name, p.description)
+	}
+	return b.String()
+}
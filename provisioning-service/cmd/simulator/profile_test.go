@@ -0,0 +1,86 @@
+package main
+
+import (
+	"math/rand/v2"
+	"strings"
+	"testing"
+)
+
+func TestParseMix(t *testing.T) {
+	tests := []struct {
+		mix  string
+		want map[string]float64
+		err  string
+	}{
+		{mix: "steady", want: map[string]float64{"steady": 1}},
+		{mix: "steady:3, churn:0.5", want: map[string]float64{"steady": 3, "churn": 0.5}},
+		{mix: " eager , browsing:2", want: map[string]float64{"eager": 1, "browsing": 2}},
+		{mix: "lazy", err: `unknown profile "lazy"`},
+		{mix: "steady,", err: `unknown profile ""`},
+		{mix: "steady:0", err: `invalid weight for profile steady: "0"`},
+		{mix: "churn:-1", err: `invalid weight for profile churn: "-1"`},
+		{mix: "churn:x", err: `invalid weight for profile churn: "x"`},
+	}
+	for _, tt := range tests {
+		got, err := parseMix(tt.mix)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("parseMix(%q) = %v, want %s", tt.mix, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMix(%q): %v", tt.mix, err)
+			continue
+		}
+		weights := make(map[string]float64)
+		for _, p := range got {
+			weights[p.name] = p.weight
+		}
+		if len(got) != len(tt.want) || len(weights) != len(tt.want) {
+			t.Errorf("parseMix(%q) = %v, want %v", tt.mix, weights, tt.want)
+			continue
+		}
+		for name, weight := range tt.want {
+			if weights[name] != weight {
+				t.Errorf("parseMix(%q) weighs %s %g, want %g", tt.mix, name, weights[name], weight)
+			}
+		}
+	}
+}
+
+// fixedSource makes rand.Float64 return the fraction it is set to
+type fixedSource float64
+
+func (f fixedSource) Uint64() uint64 {
+	return uint64(float64(f) * (1 << 53))
+}
+
+func TestPick(t *testing.T) {
+	mix, err := parseMix("steady:1,eager:2,churn:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[float64]string{
+		0:     "steady",
+		0.24:  "steady",
+		0.25:  "eager",
+		0.74:  "eager",
+		0.75:  "churn",
+		0.999: "churn",
+	}
+	for draw, want := range tests {
+		if got := pick(mix, rand.New(fixedSource(draw))); got.name != want {
+			t.Errorf("pick at %g = %s, want %s", draw, got.name, want)
+		}
+	}
+
+	counts := make(map[string]int)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 4000 {
+		counts[pick(mix, rng).name]++
+	}
+	if counts["eager"] < 1800 || counts["eager"] > 2200 {
+		t.Errorf("drew %v, want about half eager", counts)
+	}
+}
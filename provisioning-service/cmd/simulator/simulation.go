@@ -0,0 +1,515 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/infra/redis"
+	"github.com/aos-cc/provisioning-service/pkg/client"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// nodeAgentPoll is how often -node-agent looks for booting nodes
+const nodeAgentPoll = 2 * time.Second
+
+// simulation drives the synthetic users against one service
+type simulation struct {
+	cfg       config
+	rdb       *redis.Client
+	publisher events.Publisher
+	keyring   *events.Keyring // Opens sealed replies; nil when events are not sealed
+	client    *client.Client
+	http      *http.Client
+
+	rng          *rand.Rand // Used by the arrival loop only
+	replyChannel string
+	idle         chan string // Users free to arrive
+
+	mu      sync.Mutex
+	waiting map[string]chan events.AllocationResultEvent // By correlation ID
+	results results
+}
+
+// results are what the simulation saw, reported under sim. in assertions
+type results struct {
+	arrivals      int
+	saturated     int // Arrivals dropped as every user was busy
+	activity      int
+	connects      int
+	allocated     int
+	failed        int
+	failures      map[string]int // By error code
+	unanswered    int
+	disconnects   int
+	nodeEvents    int
+	publishErrors int
+	connected     int
+	peakConnected int
+	peakNodes     int // Booting, ready and occupied nodes at the busiest sample
+	latencies     []time.Duration
+}
+
+func newSimulation(cfg config, rdb *redis.Client, publisher events.Publisher, keyring *events.Keyring, c *client.Client) *simulation {
+	s := &simulation{
+		cfg:          cfg,
+		rdb:          rdb,
+		publisher:    publisher,
+		keyring:      keyring,
+		client:       c,
+		http:         &http.Client{Timeout: 10 * time.Second},
+		rng:          rand.New(rand.NewPCG(uint64(cfg.seed), 0)),
+		replyChannel: fmt.Sprintf("simulator:replies:%d", cfg.seed),
+		idle:         make(chan string, cfg.users),
+		waiting:      make(map[string]chan events.AllocationResultEvent),
+		results:      results{failures: make(map[string]int)},
+	}
+	for i := range cfg.users {
+		s.idle <- fmt.Sprintf("sim-user-%04d", i+1)
+	}
+	return s
+}
+
+// run lets users arrive for the configured duration, sends them all away,
+// waits for the pool to settle and checks the assertions, returning how
+// many failed
+func (s *simulation) run(ctx context.Context) (int, error) {
+	pubsub := s.rdb.Subscribe(ctx, s.replyChannel)
+	defer pubsub.Close()
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return 0, fmt.Errorf("subscribe to %s: %w", s.replyChannel, err)
+	}
+	go s.routeReplies(pubsub.Channel())
+
+	fmt.Printf("simulating %s for %s at %g users/s (seed %d)\n", s.describeMix(), s.cfg.duration, s.cfg.rate, s.cfg.seed)
+
+	// Pool watchers run until the pool has settled
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	var watchers sync.WaitGroup
+	watchers.Go(func() { s.sample(watchCtx) })
+	if s.cfg.nodeAgent {
+		watchers.Go(func() { s.nodeAgent(watchCtx) })
+	}
+
+	usersCtx, stopUsers := context.WithTimeout(ctx, s.cfg.duration)
+	var users sync.WaitGroup
+	s.arrive(usersCtx, &users)
+	users.Wait()
+	stopUsers()
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	fmt.Printf("run over; settling for %s\n", s.cfg.settle)
+	select {
+	case <-time.After(s.cfg.settle):
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+	stopWatching()
+	watchers.Wait()
+
+	metrics, err := s.fetchMetrics(ctx)
+	if err != nil {
+		return 0, err
+	}
+	s.report()
+	return s.check(metrics), nil
+}
+
+// arrive releases users at the configured rate until ctx ends, one at a
+// time with exponential gaps or together every burst interval
+func (s *simulation) arrive(ctx context.Context, users *sync.WaitGroup) {
+	if s.cfg.burstEvery > 0 {
+		ticker := time.NewTicker(s.cfg.burstEvery)
+		defer ticker.Stop()
+		carry := 0.0
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			carry += s.cfg.rate * s.cfg.burstEvery.Seconds()
+			n := math.Floor(carry)
+			carry -= n
+			for range int(n) {
+				s.startUser(ctx, users)
+			}
+		}
+	}
+
+	for {
+		gap := time.Duration(s.rng.ExpFloat64() / s.cfg.rate * float64(time.Second))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(gap):
+		}
+		s.startUser(ctx, users)
+	}
+}
+
+// startUser starts a free user on a profile drawn from the mix
+func (s *simulation) startUser(ctx context.Context, users *sync.WaitGroup) {
+	var userID string
+	select {
+	case userID = <-s.idle:
+	default:
+		s.count(func(r *results) { r.saturated++ })
+		return
+	}
+
+	p := pick(s.cfg.mix, s.rng)
+	rng := rand.New(rand.NewPCG(s.rng.Uint64(), s.rng.Uint64()))
+	s.count(func(r *results) { r.arrivals++ })
+	users.Go(func() {
+		s.simulateUser(ctx, userID, p, rng)
+		s.idle <- userID
+	})
+}
+
+// simulateUser plays one visit of a user: activity, then possibly a
+// connect, a session with heartbeats and a disconnect. A user still
+// connected when the run ends disconnects then.
+func (s *simulation) simulateUser(ctx context.Context, userID string, p profile, rng *rand.Rand) {
+	if !s.beActive(ctx, userID, p.activity, p.every, p.lead) || rng.Float64() >= p.connects {
+		return
+	}
+
+	correlationID := fmt.Sprintf("%s-%d", userID, rng.Uint32())
+	replies := make(chan events.AllocationResultEvent, 1)
+	s.mu.Lock()
+	s.waiting[correlationID] = replies
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.waiting, correlationID)
+		s.mu.Unlock()
+	}()
+
+	// Disconnecting after a failed or unanswered connect withdraws any
+	// queued request, as a client giving up would
+	defer s.publish(context.WithoutCancel(ctx), events.ChannelUserDisconnect, events.UserDisconnectEvent{UserID: userID})
+	defer s.count(func(r *results) { r.disconnects++ })
+
+	s.count(func(r *results) { r.connects++ })
+	began := time.Now()
+	s.publish(ctx, events.ChannelUserConnect, events.UserConnectEvent{
+		UserID:        userID,
+		ReplyChannel:  s.replyChannel,
+		CorrelationID: correlationID,
+	})
+
+	var reply events.AllocationResultEvent
+	select {
+	case reply = <-replies:
+	case <-time.After(s.cfg.replyTimeout):
+		s.count(func(r *results) { r.unanswered++ })
+		return
+	case <-ctx.Done():
+		return
+	}
+
+	switch reply.Status {
+	case events.AllocationStatusAllocated, events.AllocationStatusAlreadyAllocated, events.AllocationStatusUnconfirmed:
+	default:
+		s.count(func(r *results) {
+			r.failed++
+			r.failures[strings.ToLower(cmp.Or(reply.Code, "unknown"))]++
+		})
+		return
+	}
+	if reply.Status == events.AllocationStatusUnconfirmed {
+		s.publish(ctx, events.ChannelUserConfirm, events.UserConfirmEvent{UserID: userID, NodeID: reply.NodeID})
+	}
+	s.count(func(r *results) {
+		r.allocated++
+		r.latencies = append(r.latencies, time.Since(began))
+		r.connected++
+		r.peakConnected = max(r.peakConnected, r.connected)
+	})
+	defer s.count(func(r *results) { r.connected-- })
+
+	session := time.Duration(rng.ExpFloat64() * float64(p.session))
+	s.beActive(ctx, userID, events.ActivityTypeHeartbeat, p.every, session)
+}
+
+// beActive publishes activity of a type every interval for a while,
+// reporting false if the run ended first
+func (s *simulation) beActive(ctx context.Context, userID, activity string, every, duration time.Duration) bool {
+	until := time.Now().Add(duration)
+	for {
+		s.publish(ctx, events.ChannelUserActivity, events.UserActivityEvent{
+			UserID:    userID,
+			Timestamp: time.Now().Unix(),
+			Type:      activity,
+		})
+		s.count(func(r *results) { r.activity++ })
+
+		wait := min(every, time.Until(until))
+		if wait <= 0 {
+			return true
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// routeReplies hands allocation results to the users waiting for them
+func (s *simulation) routeReplies(messages <-chan *goredis.Message) {
+	for msg := range messages {
+		var reply events.AllocationResultEvent
+		payload, err := s.keyring.Open(s.replyChannel, "", []byte(msg.Payload))
+		if err != nil || json.Unmarshal(payload, &reply) != nil {
+			continue
+		}
+		s.mu.Lock()
+		replies, ok := s.waiting[reply.CorrelationID]
+		s.mu.Unlock()
+		if ok {
+			select {
+			case replies <- reply:
+			default:
+			}
+		}
+	}
+}
+
+// nodeAgent reports each node booting for the boot time ready, as its
+// agent would, with made-up connection details
+func (s *simulation) nodeAgent(ctx context.Context) {
+	firstSeen := make(map[string]time.Time)
+	reported := make(map[string]bool)
+	ticker := time.NewTicker(nodeAgentPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		nodes, err := s.client.Nodes(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintln(os.Stderr, "node agent: list nodes:", err)
+			}
+			continue
+		}
+		for _, n := range nodes {
+			if n.Status != "booting" || reported[n.ID] {
+				continue
+			}
+			seen, ok := firstSeen[n.ID]
+			if !ok {
+				firstSeen[n.ID] = time.Now()
+				seen = time.Now()
+			}
+			if time.Since(seen) < s.cfg.bootTime {
+				continue
+			}
+			s.publish(ctx, events.ChannelNodeStatus, events.NodeStatusEvent{
+				NodeID:   n.ID,
+				Status:   "ready",
+				Hostname: n.ID + ".sim.internal",
+				Port:     9000,
+			})
+			reported[n.ID] = true
+			s.count(func(r *results) { r.nodeEvents++ })
+		}
+	}
+}
+
+// sample tracks the busiest pool and prints progress
+func (s *simulation) sample(ctx context.Context) {
+	began := time.Now()
+	ticker := time.NewTicker(s.cfg.sampleEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		metrics, err := s.fetchMetrics(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintln(os.Stderr, "sample:", err)
+			}
+			continue
+		}
+		booting, _ := lookup(metrics, "nodes.booting")
+		ready, _ := lookup(metrics, "nodes.ready")
+		allocated, _ := lookup(metrics, "nodes.allocated")
+		unconfirmed, _ := lookup(metrics, "nodes.unconfirmed")
+		live := int(booting + ready + allocated + unconfirmed)
+
+		s.mu.Lock()
+		s.results.peakNodes = max(s.results.peakNodes, live)
+		connected, arrivals := s.results.connected, s.results.arrivals
+		s.mu.Unlock()
+		fmt.Printf("%6s  arrivals=%d connected=%d nodes: booting=%d ready=%d allocated=%d\n",
+			time.Since(began).Truncate(time.Second), arrivals, connected, int(booting), int(ready), int(allocated+unconfirmed))
+	}
+}
+
+// fetchMetrics reads the service's /metrics
+func (s *simulation) fetchMetrics(ctx context.Context) (map[string]any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.cfg.target+"/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch metrics: %s", resp.Status)
+	}
+	var metrics map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&metrics); err != nil {
+		return nil, fmt.Errorf("decode metrics: %w", err)
+	}
+	return metrics, nil
+}
+
+// publish sends an event on an inbound channel, counting failures
+func (s *simulation) publish(ctx context.Context, channel string, event any) {
+	payload, err := json.Marshal(event)
+	if err == nil {
+		err = s.publisher.Publish(ctx, channel, string(payload))
+	}
+	if err != nil && ctx.Err() == nil {
+		s.count(func(r *results) { r.publishErrors++ })
+	}
+}
+
+// count updates the results
+func (s *simulation) count(update func(r *results)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	update(&s.results)
+}
+
+// outcome returns the results by the names assertions use
+func (s *simulation) outcome() map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.results
+
+	successRatio := 1.0
+	if r.connects > 0 {
+		successRatio = float64(r.allocated) / float64(r.connects)
+	}
+	latencies := slices.Sorted(slices.Values(r.latencies))
+	outcome := map[string]any{
+		"arrivals":          float64(r.arrivals),
+		"saturated":         float64(r.saturated),
+		"activity_events":   float64(r.activity),
+		"connects":          float64(r.connects),
+		"allocated":         float64(r.allocated),
+		"failed":            float64(r.failed),
+		"unanswered":        float64(r.unanswered),
+		"disconnects":       float64(r.disconnects),
+		"node_events":       float64(r.nodeEvents),
+		"publish_errors":    float64(r.publishErrors),
+		"success_ratio":     successRatio,
+		"peak_connected":    float64(r.peakConnected),
+		"peak_nodes":        float64(r.peakNodes),
+		"alloc_p50_seconds": percentile(latencies, 0.50).Seconds(),
+		"alloc_p95_seconds": percentile(latencies, 0.95).Seconds(),
+		"alloc_max_seconds": percentile(latencies, 1).Seconds(),
+	}
+	for reason, n := range r.failures {
+		outcome["failed_"+reason] = float64(n)
+	}
+	return outcome
+}
+
+// percentile returns the q quantile of sorted durations, or 0 if empty
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(i, 0)]
+}
+
+// report prints the simulation's results
+func (s *simulation) report() {
+	outcome := s.outcome()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RESULT\tVALUE")
+	for _, name := range slices.Sorted(maps.Keys(outcome)) {
+		fmt.Fprintf(w, "sim.%s\t%g\n", name, outcome[name])
+	}
+	w.Flush()
+}
+
+// check evaluates the assertions, printing each, and returns how many failed
+func (s *simulation) check(metrics map[string]any) int {
+	if len(s.cfg.asserts) == 0 {
+		return 0
+	}
+	metrics["sim"] = s.outcome()
+
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ASSERTION\tVALUE\tRESULT")
+	for _, a := range s.cfg.asserts {
+		got, ok := lookup(metrics, a.path)
+		switch {
+		case !ok:
+			failed++
+			fmt.Fprintf(w, "%s\t-\tFAIL (no such metric)\n", a.text)
+		case !a.holds(got):
+			failed++
+			fmt.Fprintf(w, "%s\t%g\tFAIL\n", a.text, got)
+		default:
+			fmt.Fprintf(w, "%s\t%g\tok\n", a.text, got)
+		}
+	}
+	w.Flush()
+	if failed > 0 {
+		fmt.Printf("%d of %d assertions failed\n", failed, len(s.cfg.asserts))
+	}
+	return failed
+}
+
+// describeMix names the profiles in the mix with their shares
+func (s *simulation) describeMix() string {
+	if len(s.cfg.mix) == 1 {
+		return s.cfg.mix[0].name + " users"
+	}
+	total := 0.0
+	for _, p := range s.cfg.mix {
+		total += p.weight
+	}
+	desc := ""
+	for i, p := range s.cfg.mix {
+		if i > 0 {
+			desc += ", "
+		}
+		desc += fmt.Sprintf("%.0f%% %s", 100*p.weight/total, p.name)
+	}
+	return desc + " users"
+}
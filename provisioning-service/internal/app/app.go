@@ -1,18 +1,63 @@
 package app
 
 import (
+	"cmp"
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
 	"time"
 
+	"github.com/aos-cc/provisioning-service/internal/buildinfo"
+	"github.com/aos-cc/provisioning-service/internal/domain/access"
+	"github.com/aos-cc/provisioning-service/internal/domain/accuracy"
 	"github.com/aos-cc/provisioning-service/internal/domain/allocator"
+	"github.com/aos-cc/provisioning-service/internal/domain/boottime"
+	"github.com/aos-cc/provisioning-service/internal/domain/budget"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/forecast"
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/history"
+	"github.com/aos-cc/provisioning-service/internal/domain/journal"
+	"github.com/aos-cc/provisioning-service/internal/domain/lifecycle"
 	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/policy"
 	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+	"github.com/aos-cc/provisioning-service/internal/domain/purchasing"
+	"github.com/aos-cc/provisioning-service/internal/domain/ratelimit"
+	"github.com/aos-cc/provisioning-service/internal/domain/replication"
+	"github.com/aos-cc/provisioning-service/internal/domain/rightsizing"
+	"github.com/aos-cc/provisioning-service/internal/domain/safety"
+	"github.com/aos-cc/provisioning-service/internal/domain/schedule"
+	"github.com/aos-cc/provisioning-service/internal/domain/service"
+	"github.com/aos-cc/provisioning-service/internal/domain/session"
+	"github.com/aos-cc/provisioning-service/internal/domain/slo"
+	"github.com/aos-cc/provisioning-service/internal/domain/startup"
 	"github.com/aos-cc/provisioning-service/internal/domain/user"
+	"github.com/aos-cc/provisioning-service/internal/infra/authz"
+	"github.com/aos-cc/provisioning-service/internal/infra/calendar"
+	"github.com/aos-cc/provisioning-service/internal/infra/chaos"
 	"github.com/aos-cc/provisioning-service/internal/infra/config"
+	"github.com/aos-cc/provisioning-service/internal/infra/etcd"
+	"github.com/aos-cc/provisioning-service/internal/infra/fake"
+	"github.com/aos-cc/provisioning-service/internal/infra/file"
+	"github.com/aos-cc/provisioning-service/internal/infra/health"
+	"github.com/aos-cc/provisioning-service/internal/infra/hooks"
 	"github.com/aos-cc/provisioning-service/internal/infra/http"
+	"github.com/aos-cc/provisioning-service/internal/infra/jwt"
+	"github.com/aos-cc/provisioning-service/internal/infra/kafka"
+	"github.com/aos-cc/provisioning-service/internal/infra/kms"
+	"github.com/aos-cc/provisioning-service/internal/infra/logging"
+	"github.com/aos-cc/provisioning-service/internal/infra/memory"
+	"github.com/aos-cc/provisioning-service/internal/infra/metrics"
+	"github.com/aos-cc/provisioning-service/internal/infra/nats"
 	"github.com/aos-cc/provisioning-service/internal/infra/nodeapi"
 	"github.com/aos-cc/provisioning-service/internal/infra/redis"
-	"github.com/aos-cc/provisioning-service/internal/service"
+	"github.com/aos-cc/provisioning-service/internal/infra/s3"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
@@ -26,55 +71,535 @@ var Module = fx.Options(
 	// Domain
 	fx.Provide(provideNodePool),
 	fx.Provide(provideUserTracker),
+	fx.Provide(provideUserStore),
+	fx.Provide(provideHandoffStore),
 	fx.Provide(provideNodeAllocator),
+	fx.Provide(provideForecaster),
 	fx.Provide(providePredictor),
+	fx.Provide(provideHistory),
+	fx.Provide(provideSLOTracker),
+	fx.Provide(provideAccuracyTracker),
+	fx.Provide(provideLifecycleManager),
+	fx.Provide(provideGuard),
+	fx.Provide(provideBudget),
+	fx.Provide(providePurchasing),
+	fx.Provide(provideAccess),
+	fx.Provide(feed.NewHub),
+	fx.Provide(provideJournal),
+	fx.Provide(provideRightsizing),
+	fx.Provide(provideRateLimiter),
 
 	// Infrastructure
+	fx.Provide(providePrometheus),
 	fx.Provide(provideRedisClient),
+	fx.Provide(provideEtcdCluster),
+	fx.Provide(provideReplication),
+	fx.Provide(memory.NewBus),
+	fx.Provide(provideNamespace),
+	fx.Provide(provideKeyring),
+	fx.Provide(providePublisher),
 	fx.Provide(provideNodeAPIClient),
 	fx.Provide(provideNodeManager),
+	fx.Provide(provideChaos),
+	fx.Provide(provideNodeProviders),
+	fx.Provide(provideSessionRecorder),
+	fx.Provide(provideHealthChecker),
+	fx.Provide(provideEventHandler),
 	fx.Provide(provideHTTPServer),
 
 	// Service
+	fx.Provide(provideStartup),
 	fx.Provide(provideProvisioner),
 	fx.Provide(provideSubscriber),
+	fx.Invoke(promoteOnElection),
+	fx.Invoke(runStartup),
 )
 
-func provideConfig() (*config.Config, error) {
-	return config.Load("")
+// New creates the application. The config is loaded up front for its
+// lifecycle timeouts; one that does not load is reported when the
+// application is built, like any other constructor error.
+func New(src ConfigSource, opts ...fx.Option) *fx.App {
+	options := []fx.Option{fx.Supply(src), Module}
+	if cfg, err := provideConfig(src); err == nil {
+		options = append(options,
+			fx.StartTimeout(cfg.Startup.StartTimeout),
+			fx.StopTimeout(cfg.Startup.StopTimeout),
+		)
+	}
+	return fx.New(append(options, opts...)...)
+}
+
+// ConfigSource selects the config files loaded at startup
+type ConfigSource struct {
+	Paths       []string // Loaded in order, later files overriding earlier ones
+	Environment string   // Applies <name>.<environment><ext> overlays when set
+	Profile     string   // dev|staging|prod defaults beneath the config files; empty for none
+	Dev         bool     // Enables dev mode regardless of the config files
+}
+
+func provideConfig(src ConfigSource) (*config.Config, error) {
+	cfg, err := config.Load(src.Profile, src.Environment, src.Paths...)
+	if err != nil {
+		return nil, err
+	}
+	if src.Dev {
+		cfg.EnableDevMode()
+	}
+	return cfg, nil
+}
+
+func provideLogger(lc fx.Lifecycle, cfg *config.Config) (*zap.Logger, zap.AtomicLevel, error) {
+	logger, level, stop, err := logging.New(logging.Config{
+		Level:              cfg.Log.Level,
+		Format:             cfg.Log.Format,
+		Sampling:           cfg.Log.Sampling,
+		SamplingInitial:    cfg.Log.SamplingInitial,
+		SamplingThereafter: cfg.Log.SamplingThereafter,
+		File:               cfg.Log.File,
+		LokiURL:            cfg.Log.LokiURL,
+		LokiLabels:         cfg.Log.LokiLabels,
+		LokiBatchWait:      cfg.Log.LokiBatchWait,
+	})
+	if err != nil {
+		return nil, level, err
+	}
+
+	build := buildinfo.Get()
+	logger.Info("starting provisioning service",
+		zap.String("version", build.Version),
+		zap.String("commit", build.Commit),
+		zap.String("build_time", build.BuildTime),
+		zap.String("go_version", build.GoVersion),
+		zap.String("config_hash", cfg.Hash()),
+		zap.String("profile", cfg.Profile),
+	)
+
+	// Appended first, so it stops last and flushes everything logged on shutdown
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			stop()
+			return nil
+		},
+	})
+
+	return logger, level, nil
 }
 
-func provideLogger() (*zap.Logger, error) {
-	return zap.NewProduction()
+func provideNodePool(cfg *config.Config) *node.NodePool {
+	return node.NewNodePool(node.AgentCompatibility{
+		MinVersion:      cfg.Agent.MinVersion,
+		BlockedVersions: cfg.Agent.BlockedVersions,
+	})
 }
 
-func provideNodePool() *node.NodePool {
-	return node.NewNodePool()
+func provideUserTracker(lc fx.Lifecycle, cfg *config.Config, prom *metrics.Prometheus, logger *zap.Logger) *user.UserTracker {
+	tracker := user.NewUserTracker(cfg.Prediction.ActivityWindow, user.Config{
+		Retention:       cfg.Prediction.UserRetention,
+		MaxUsers:        cfg.Prediction.MaxTrackedUsers,
+		CleanupInterval: cfg.Prediction.UserCleanupInterval,
+		Weights:         cfg.Prediction.ActivityWeights,
+		Horizon:         cfg.Prediction.ActivityHorizon,
+		BurstFactor:     cfg.Prediction.ActivityBurstFactor,
+	}, prom)
+	prom.RegisterUserTracker(tracker)
+
+	appendBackgroundHook(lc, logger, "user tracker janitor", tracker.Run)
+
+	return tracker
 }
 
-func provideUserTracker(cfg *config.Config) *user.UserTracker {
-	return user.NewUserTracker(cfg.Prediction.ActivityWindow)
+func provideUserStore(cfg *config.Config, client *redis.Client) (user.Store, error) {
+	switch cfg.Allocation.UserStore {
+	case "", "none":
+		return user.NopStore{}, nil
+	case "redis":
+		return redis.NewUserStore(client, cfg.Allocation.UserStoreKey), nil
+	default:
+		return nil, fmt.Errorf("unknown user store %q", cfg.Allocation.UserStore)
+	}
 }
 
-func provideNodeAllocator(nodePool *node.NodePool, userTracker *user.UserTracker) *allocator.NodeAllocator {
-	return allocator.NewNodeAllocator(nodePool, userTracker)
+// provideHandoffStore returns the store the pool is handed off in. The
+// Redis key is put after events.channel_prefix, so deployments sharing a
+// server each take over their own.
+func provideHandoffStore(cfg *config.Config, client *redis.Client) (handoff.Store, error) {
+	var store handoff.Store
+	switch cfg.Allocation.Handoff {
+	case "", "none":
+		return handoff.NopStore{}, nil
+	case "file":
+		store = file.NewHandoffStore(cfg.Allocation.HandoffFile)
+	case "redis":
+		store = redis.NewHandoffStore(client, cfg.Events.ChannelPrefix+cfg.Allocation.HandoffKey, cfg.Allocation.HandoffMaxAge)
+	default:
+		return nil, fmt.Errorf("unknown handoff store %q", cfg.Allocation.Handoff)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(cfg.Allocation.HandoffSecret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid handoff secret: %w", err)
+	}
+	return handoff.NewSecretStore(store, key)
 }
 
-func providePredictor(cfg *config.Config, userTracker *user.UserTracker, nodePool *node.NodePool) *predictor.Predictor {
+func provideNodeAllocator(cfg *config.Config, nodePool *node.NodePool, userTracker *user.UserTracker, client *redis.Client, logger *zap.Logger) (*allocator.NodeAllocator, error) {
+	var claims allocator.Claims
+	switch cfg.Allocation.Claims {
+	case "", "local":
+		claims = allocator.LocalClaims{}
+	case "redis":
+		claims = redis.NewClaims(client, cfg.Allocation.ClaimKeyPrefix, cfg.Allocation.ClaimTTL)
+	default:
+		return nil, fmt.Errorf("unknown allocation claims %q", cfg.Allocation.Claims)
+	}
+	var offSpotTiers []string
+	if cfg.Purchasing.Enabled {
+		offSpotTiers = cfg.Purchasing.OnDemandTiers
+	}
+	return allocator.NewNodeAllocator(nodePool, userTracker, claims, cfg.Allocation.ConfirmTTL, offSpotTiers, logger), nil
+}
+
+func provideRateLimiter(cfg *config.Config, client *redis.Client, prom *metrics.Prometheus) (*ratelimit.Limiter, error) {
+	rl := cfg.Allocation.RateLimit
+	var store ratelimit.Store
+	switch rl.Store {
+	case "", "local":
+		store = ratelimit.NewLocalStore()
+	case "redis":
+		store = redis.NewRateLimits(client, rl.KeyPrefix)
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", rl.Store)
+	}
+	return ratelimit.NewLimiter(store, ratelimit.Limit{PerMinute: rl.PerMinute, Burst: rl.Burst}, prom), nil
+}
+
+func provideForecaster(cfg *config.Config) *forecast.Forecaster {
+	return forecast.NewForecaster(forecast.Config{
+		BucketSize: cfg.Prediction.ForecastBucket,
+		Season:     cfg.Prediction.ForecastSeason,
+		Alpha:      cfg.Prediction.ForecastAlpha,
+	})
+}
+
+func providePredictor(lc fx.Lifecycle, cfg *config.Config, userTracker *user.UserTracker, nodePool *node.NodePool, forecaster *forecast.Forecaster, guard *safety.Guard, prom *metrics.Prometheus, logger *zap.Logger) (*predictor.Predictor, error) {
 	predConfig := predictor.PredictionConfig{
+		ScalingMode:            predictor.ScalingMode(cfg.Prediction.ScalingMode),
+		TargetHeadroom:         cfg.Prediction.TargetHeadroom,
 		ActivityWindow:         cfg.Prediction.ActivityWindow,
 		ActivityThreshold:      cfg.Prediction.ActivityThreshold,
 		PredictionWindow:       cfg.Prediction.PredictionWindow,
+		ActivityHorizon:        cfg.Prediction.ActivityHorizon,
+		UserRetention:          cfg.Prediction.UserRetention,
+		LeadTimeEnabled:        cfg.Prediction.LeadTimeEnabled,
 		MinReadyNodes:          cfg.Prediction.MinReadyNodes,
 		MaxReadyNodes:          cfg.Prediction.MaxReadyNodes,
+		BurstMaxNodes:          cfg.Prediction.BurstMaxNodes,
+		BurstIdleTimeout:       cfg.Prediction.BurstIdleTimeout,
 		IdleTerminationTimeout: cfg.Prediction.IdleTerminationTimeout,
 		BootingNodeTimeout:     cfg.Prediction.BootingNodeTimeout,
+		UsersPerNode:           cfg.Prediction.UsersPerNode,
+		ForecastEnabled:        cfg.Prediction.ForecastEnabled,
+		SurplusScaleDown:       cfg.Prediction.SurplusScaleDown,
+		SurplusMargin:          cfg.Prediction.SurplusMargin,
+		SurplusStep:            cfg.Prediction.SurplusStep,
+		DefaultInstanceType:    cfg.Prediction.DefaultInstanceType,
+	}
+
+	for _, userID := range cfg.Allocation.DedicatedUsers {
+		predConfig.Dedicated = append(predConfig.Dedicated, node.Dedication{UserID: userID})
+	}
+	for _, tenantID := range cfg.Allocation.DedicatedTenants {
+		predConfig.Dedicated = append(predConfig.Dedicated, node.Dedication{TenantID: tenantID})
+	}
+
+	if len(cfg.Prediction.InstanceTypes) > 0 {
+		predConfig.InstanceTypes = make(map[string]predictor.InstanceTypePolicy, len(cfg.Prediction.InstanceTypes))
+		for instanceType, typeCfg := range cfg.Prediction.InstanceTypes {
+			// Start from the shared settings and apply the type's overrides
+			policy := predConfig.PolicyFor("")
+			if typeCfg.MinReadyNodes != nil {
+				policy.MinReadyNodes = *typeCfg.MinReadyNodes
+			}
+			if typeCfg.MaxReadyNodes != nil {
+				policy.MaxReadyNodes = *typeCfg.MaxReadyNodes
+			}
+			if typeCfg.BurstMaxNodes != nil {
+				policy.BurstMaxNodes = *typeCfg.BurstMaxNodes
+			}
+			if typeCfg.BurstIdleTimeout > 0 {
+				policy.BurstIdleTimeout = typeCfg.BurstIdleTimeout
+			}
+			if typeCfg.IdleTerminationTimeout > 0 {
+				policy.IdleTerminationTimeout = typeCfg.IdleTerminationTimeout
+			}
+			if typeCfg.BootingNodeTimeout > 0 {
+				policy.BootingNodeTimeout = typeCfg.BootingNodeTimeout
+			}
+			if typeCfg.UsersPerNode > 0 {
+				policy.UsersPerNode = typeCfg.UsersPerNode
+			}
+			predConfig.InstanceTypes[instanceType] = policy
+		}
+	}
+
+	if len(cfg.Prediction.ScalingPolicy) > 0 {
+		rules := make([]policy.Rule, len(cfg.Prediction.ScalingPolicy))
+		for i, rule := range cfg.Prediction.ScalingPolicy {
+			rules[i] = rule.Rule()
+		}
+		scalingPolicy, err := policy.Compile(rules)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prediction.scaling_policy: %w", err)
+		}
+		predConfig.ScalingPolicy = scalingPolicy
+	}
+	loc, err := time.LoadLocation(cfg.Prediction.ScalingPolicyTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prediction.scaling_policy_timezone: %w", err)
+	}
+	predConfig.PolicyLocation = loc
+
+	if err := predConfig.Validate(); err != nil {
+		return nil, err
+	}
+	pred := predictor.NewPredictor(predConfig, userTracker, nodePool, forecaster, guard, boottime.NewTracker(prom))
+
+	if cfg.Prediction.Plugin.Address != "" {
+		plugin, err := predictor.NewPlugin(predictor.PluginConfig{
+			Address: cfg.Prediction.Plugin.Address,
+			Timeout: cfg.Prediction.Plugin.Timeout,
+			TLS:     cfg.Prediction.Plugin.TLS,
+		}, logger, prom)
+		if err != nil {
+			return nil, err
+		}
+		pred.SetPlugin(plugin)
+		logger.Info("predictor plugin configured",
+			zap.String("address", cfg.Prediction.Plugin.Address),
+			zap.Duration("timeout", cfg.Prediction.Plugin.Timeout),
+		)
+
+		lc.Append(fx.Hook{
+			OnStop: func(ctx context.Context) error {
+				return plugin.Close()
+			},
+		})
+	}
+
+	if sc := cfg.Prediction.Schedule; sc.Enabled {
+		sched := schedule.New(schedule.Config{
+			Lead:       sc.Lead,
+			Attendance: sc.Attendance,
+		})
+		pred.SetSchedule(sched)
+		prom.RegisterSchedule(sched)
+		if sc.URL != "" {
+			calendarFeed := calendar.NewFeed(calendar.Options{
+				URL:              sc.URL,
+				Format:           sc.Format,
+				RefreshInterval:  sc.RefreshInterval,
+				Timeout:          sc.Timeout,
+				Horizon:          sc.Horizon,
+				DefaultAttendees: sc.DefaultAttendees,
+			}, sched, prom, logger)
+			appendBackgroundHook(lc, logger, "calendar feed", calendarFeed.Run)
+		}
+		logger.Info("scheduled sessions planned for",
+			zap.String("url", sc.URL),
+			zap.Duration("lead", sc.Lead),
+			zap.Float64("attendance", sc.Attendance),
+		)
+	}
+	return pred, nil
+}
+
+func provideHistory(cfg *config.Config) *history.History {
+	return history.NewHistory(cfg.Metrics.HistoryRetention, cfg.Prediction.ScalingCheckInterval)
+}
+
+func provideJournal(lc fx.Lifecycle, cfg *config.Config, hub *feed.Hub, logger *zap.Logger) *journal.Journal {
+	j := journal.New(journal.Config{
+		Retention: cfg.Metrics.JournalRetention,
+		MaxEvents: cfg.Metrics.JournalMaxEvents,
+	})
+	sub := hub.Subscribe(feed.Filter{})
+	appendBackgroundHook(lc, logger, "journal", func(ctx context.Context) error {
+		return j.Run(ctx, sub)
+	})
+	return j
+}
+
+func provideRightsizing(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger) *rightsizing.Engine {
+	rs := cfg.Allocation.Rightsizing
+	engine := rightsizing.New(rightsizing.Config{
+		Sizes:             rs.Sizes,
+		Window:            rs.Window,
+		MinSamples:        rs.MinSamples,
+		DownsizeBelow:     rs.DownsizeBelow,
+		UpsizeAbove:       rs.UpsizeAbove,
+		MemoryUpsizeAbove: rs.MemoryUpsizeAbove,
+		ActivitySizes:     rs.ActivitySizes,
+	})
+	if engine.Enabled() {
+		appendBackgroundHook(lc, logger, "rightsizing", engine.Run)
+	}
+	return engine
+}
+
+func provideAccuracyTracker(cfg *config.Config, prom *metrics.Prometheus) *accuracy.Tracker {
+	tracker := accuracy.NewTracker(cfg.Prediction.PredictionWindow, cfg.Metrics.AccuracyWindow, prom)
+	prom.RegisterAccuracy(tracker)
+	return tracker
+}
+
+func provideSLOTracker(cfg *config.Config, prom *metrics.Prometheus) *slo.Tracker {
+	tracker := slo.NewTracker(cfg.Metrics.SLOWindow, cfg.Metrics.SLOTarget, prom)
+	prom.RegisterSLO(tracker)
+	return tracker
+}
+
+func provideGuard(userTracker *user.UserTracker, prom *metrics.Prometheus, logger *zap.Logger) *safety.Guard {
+	return safety.NewGuard(userTracker, prom, logger)
+}
+
+func provideBudget(cfg *config.Config, nodePool *node.NodePool, prom *metrics.Prometheus) (*budget.Tracker, error) {
+	budgetConfig := budget.Config{
+		Prices:       cfg.Budget.Prices,
+		DefaultPrice: cfg.Budget.HourlyPrice,
+		MaxHourly:    cfg.Budget.MaxHourlySpend,
+		MaxDaily:     cfg.Budget.MaxDailySpend,
 	}
-	return predictor.NewPredictor(predConfig, userTracker, nodePool)
+	if err := budgetConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	tracker := budget.NewTracker(budgetConfig, nodePool, prom)
+	prom.RegisterBudget(tracker)
+	return tracker, nil
+}
+
+func providePurchasing(cfg *config.Config, nodePool *node.NodePool, userTracker *user.UserTracker, prom *metrics.Prometheus) (*purchasing.Strategy, error) {
+	purchasingConfig := purchasing.Config{
+		Enabled:             cfg.Purchasing.Enabled,
+		TargetSpotRatio:     cfg.Purchasing.TargetSpotRatio,
+		Reserved:            cfg.Purchasing.Reserved,
+		MaxInterruptionRate: cfg.Purchasing.MaxInterruptionRate,
+		InterruptionWindow:  cfg.Purchasing.InterruptionWindow,
+		OnDemandTiers:       cfg.Purchasing.OnDemandTiers,
+	}
+	if err := purchasingConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	strategy := purchasing.NewStrategy(purchasingConfig, nodePool, userTracker, prom)
+	prom.RegisterPurchasing(strategy)
+	return strategy, nil
 }
 
+func provideAccess(cfg *config.Config, prom *metrics.Prometheus) (*access.Controller, error) {
+	accessConfig := access.Config{
+		Mode:      cfg.Access.Mode,
+		Blocklist: cfg.Access.Blocklist,
+		Allowlist: cfg.Access.Allowlist,
+		FailOpen:  cfg.Access.AuthzFailOpen,
+	}
+	if err := accessConfig.Validate(); err != nil {
+		return nil, err
+	}
+
+	var authorizer access.Authorizer
+	if cfg.Access.AuthzURL != "" {
+		authorizer = authz.NewClient(cfg.Access.AuthzURL, cfg.Access.AuthzTimeout)
+	}
+	return access.NewController(accessConfig, authorizer, prom), nil
+}
+
+func provideLifecycleManager(cfg *config.Config, logger *zap.Logger) (*lifecycle.Manager, error) {
+	stages := map[lifecycle.Stage][]config.HookConfig{
+		lifecycle.StagePreReady:     cfg.Hooks.PreReady,
+		lifecycle.StagePostAllocate: cfg.Hooks.PostAllocate,
+		lifecycle.StagePreTerminate: cfg.Hooks.PreTerminate,
+	}
+
+	configured := make(map[lifecycle.Stage][]lifecycle.Hook, len(stages))
+	for stage, hookConfigs := range stages {
+		specs := make([]hooks.Spec, 0, len(hookConfigs))
+		for _, h := range hookConfigs {
+			specs = append(specs, hooks.Spec{
+				Name:    h.Name,
+				Type:    h.Type,
+				URL:     h.URL,
+				Command: h.Command,
+				Timeout: h.Timeout,
+			})
+		}
+
+		built, err := hooks.Build(specs)
+		if err != nil {
+			return nil, fmt.Errorf("%s hooks: %w", stage, err)
+		}
+		configured[stage] = built
+	}
+
+	// The probe runs first, so custom checks only see nodes whose agent is up
+	if probe := cfg.Hooks.Probe; probe.Enabled {
+		if probe.Scheme != "http" && probe.Scheme != "https" {
+			return nil, fmt.Errorf("unknown probe scheme %q", probe.Scheme)
+		}
+		configured[lifecycle.StagePreReady] = append([]lifecycle.Hook{hooks.NewProbeHook(hooks.ProbeSpec{
+			Scheme:   probe.Scheme,
+			Path:     probe.Path,
+			Port:     probe.Port,
+			Attempts: probe.Attempts,
+			Interval: probe.Interval,
+			Timeout:  probe.Timeout,
+		})}, configured[lifecycle.StagePreReady]...)
+	}
+
+	return lifecycle.NewManager(configured, logger), nil
+}
+
+func providePrometheus() *metrics.Prometheus {
+	return metrics.NewPrometheus()
+}
+
+// provideRedisClient connects to Redis, or returns nil if nothing uses it so
+// that dev mode works without a server
 func provideRedisClient(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger) (*redis.Client, error) {
-	client, err := redis.NewClient(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB, logger)
+	if cfg.Events.Transport == "memory" && cfg.Sessions.Sink != "redis" && cfg.Allocation.Claims != "redis" && cfg.Allocation.RateLimit.Store != "redis" &&
+		cfg.Allocation.UserStore != "redis" && cfg.Allocation.Handoff != "redis" && !cfg.Events.Keyspace.Enabled {
+		return nil, nil
+	}
+
+	opts := redis.Options{
+		Mode:             cfg.Redis.Mode,
+		Addr:             cfg.Redis.Addr,
+		Addrs:            cfg.Redis.Addrs,
+		MasterName:       cfg.Redis.MasterName,
+		Password:         cfg.Redis.Password,
+		SentinelPassword: cfg.Redis.SentinelPassword,
+		DB:               cfg.Redis.DB,
+		PoolSize:         cfg.Redis.PoolSize,
+		MinIdleConns:     cfg.Redis.MinIdleConns,
+		DialTimeout:      cfg.Redis.DialTimeout,
+		ReadTimeout:      cfg.Redis.ReadTimeout,
+		WriteTimeout:     cfg.Redis.WriteTimeout,
+		PoolTimeout:      cfg.Redis.PoolTimeout,
+		MaxRetries:       cfg.Redis.MaxRetries,
+		MinRetryBackoff:  cfg.Redis.MinRetryBackoff,
+		MaxRetryBackoff:  cfg.Redis.MaxRetryBackoff,
+	}
+	if cfg.Redis.TLS.Enabled {
+		opts.TLS = &redis.TLSOptions{
+			CAFile:             cfg.Redis.TLS.CAFile,
+			CertFile:           cfg.Redis.TLS.CertFile,
+			KeyFile:            cfg.Redis.TLS.KeyFile,
+			ServerName:         cfg.Redis.TLS.ServerName,
+			InsecureSkipVerify: cfg.Redis.TLS.InsecureSkipVerify,
+		}
+	}
+
+	client, err := redis.NewClient(opts, logger)
 	if err != nil {
 		return nil, err
 	}
@@ -93,16 +618,407 @@ func provideRedisClient(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger)
 	return client, nil
 }
 
+// provideEtcdCluster connects to etcd and campaigns for leadership, or
+// returns nil without replication
+func provideEtcdCluster(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger) (*etcd.Cluster, error) {
+	if cfg.HA.Mode != "etcd" {
+		return nil, nil
+	}
+
+	id := cfg.HA.ReplicaID
+	if id == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("ha.replica_id not set and hostname unavailable: %w", err)
+		}
+		id = hostname
+	}
+
+	cluster, err := etcd.NewCluster(etcd.Options{
+		Endpoints:   cfg.HA.Endpoints,
+		Username:    cfg.HA.Username,
+		Password:    cfg.HA.Password,
+		DialTimeout: cfg.HA.DialTimeout,
+		Prefix:      cfg.HA.Prefix,
+		ID:          id,
+		LeaseTTL:    cfg.HA.LeaseTTL,
+	}, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// Appended before the election's hook, so the connection is closed
+	// after leadership is resigned
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			if err := cluster.Close(); err != nil {
+				logger.Error("error closing etcd client", zap.Error(err))
+				return err
+			}
+			logger.Info("etcd client closed")
+			return nil
+		},
+	})
+	appendBackgroundHook(lc, logger, "leader election", cluster.Run)
+
+	return cluster, nil
+}
+
+// promoteOnElection has an elected replica take over the replicated state
+// before it leads
+func promoteOnElection(cluster *etcd.Cluster, provisioner *service.Provisioner) {
+	if cluster != nil {
+		cluster.OnElected(provisioner.Promote)
+	}
+}
+
+// provideReplication returns the etcd cluster, or a standalone replica that
+// always leads
+func provideReplication(cluster *etcd.Cluster) replication.Cluster {
+	if cluster == nil {
+		return replication.Standalone{}
+	}
+	return cluster
+}
+
 func provideNodeAPIClient(cfg *config.Config, logger *zap.Logger) *nodeapi.Client {
-	return nodeapi.NewClient(cfg.NodeAPI.BaseURL, cfg.NodeAPI.Timeout, logger)
+	return nodeapi.NewClient(cfg.NodeAPI.BaseURL, cfg.NodeAPI.Timeout, nodeAPICreateOptions(cfg), logger)
+}
+
+// nodeAPICreateOptions returns the creation settings shared by every Node
+// API client
+func nodeAPICreateOptions(cfg *config.Config) nodeapi.CreateOptions {
+	return nodeapi.CreateOptions{
+		KeyTTL: cfg.NodeAPI.CreateKeyTTL,
+	}
 }
 
 func provideNodeManager(client *nodeapi.Client, logger *zap.Logger) *nodeapi.NodeManager {
 	return nodeapi.NewNodeManager(client, logger)
 }
 
-func provideHTTPServer(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger, nodePool *node.NodePool, userTracker *user.UserTracker) *http.Server {
-	server := http.NewServer(cfg.Server.Port, logger, nodePool, userTracker)
+// provideNamespace returns the names channels have on Redis
+func provideNamespace(cfg *config.Config) (events.Namespace, error) {
+	namespace, err := events.NewNamespace(cfg.Events.ChannelPrefix, cfg.Events.TenantChannelPattern)
+	if err != nil {
+		return events.Namespace{}, fmt.Errorf("invalid events.tenant_channel_pattern: %w", err)
+	}
+	return namespace, nil
+}
+
+// provideKeyring returns the keyring events are sealed with, or nil unless
+// events.encryption is enabled. Keys from KMS are decrypted here, so the
+// service does not start with keys it cannot use.
+func provideKeyring(cfg *config.Config, logger *zap.Logger) (*events.Keyring, error) {
+	e := cfg.Events.Encryption
+	if e.Mode == "off" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var decrypter *kms.Decrypter
+	if e.KeySource == "kms" {
+		var err error
+		if decrypter, err = kms.NewDecrypter(ctx, e.KMSRegion); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make(map[string][]byte, len(e.Keys))
+	for id, encoded := range e.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid event sealing key %s: %w", id, err)
+		}
+		if decrypter != nil {
+			if key, err = decrypter.Decrypt(ctx, key); err != nil {
+				return nil, fmt.Errorf("failed to decrypt event sealing key %s: %w", id, err)
+			}
+		}
+		keys[id] = key
+	}
+
+	keyring, err := events.NewKeyring(e.Mode, keys, e.ActiveKey, e.Require, e.MaxSkew)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("event sealing enabled",
+		zap.String("mode", e.Mode),
+		zap.String("active_key", e.ActiveKey),
+		zap.Int("keys", len(keys)),
+		zap.Bool("require", e.Require),
+		zap.Duration("max_skew", e.MaxSkew),
+	)
+	return keyring, nil
+}
+
+// providePublisher publishes outbound events on Redis, or on the in-process
+// bus with the memory transport, sealed when events.encryption is enabled
+func providePublisher(cfg *config.Config, redisClient *redis.Client, bus *memory.Bus, namespace events.Namespace, keyring *events.Keyring) service.EventPublisher {
+	var publisher service.EventPublisher = bus
+	if cfg.Events.Transport != "memory" {
+		publisher = redis.NewPublisher(redisClient, namespace)
+	}
+	if keyring.Enabled() {
+		return events.NewSealingPublisher(publisher, keyring, namespace)
+	}
+	return publisher
+}
+
+// provideChaos returns the fault injector, or nil unless chaos is enabled
+func provideChaos(cfg *config.Config, prom *metrics.Prometheus, logger *zap.Logger) (*chaos.Injector, error) {
+	c := cfg.Chaos
+	if !c.Enabled {
+		return nil, nil
+	}
+
+	for name, rate := range map[string]float64{
+		"node_api_delay_rate":   c.NodeAPIDelayRate,
+		"node_api_failure_rate": c.NodeAPIFailureRate,
+		"event_drop_rate":       c.EventDropRate,
+		"status_flip_rate":      c.StatusFlipRate,
+	} {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("chaos.%s must be between 0 and 1, got %v", name, rate)
+		}
+	}
+
+	seed := c.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+
+	logger.Warn("CHAOS: fault injection enabled",
+		zap.Uint64("seed", seed),
+		zap.Float64("node_api_delay_rate", c.NodeAPIDelayRate),
+		zap.Duration("node_api_max_delay", c.NodeAPIMaxDelay),
+		zap.Float64("node_api_failure_rate", c.NodeAPIFailureRate),
+		zap.Float64("event_drop_rate", c.EventDropRate),
+		zap.Float64("status_flip_rate", c.StatusFlipRate),
+	)
+
+	return chaos.NewInjector(chaos.Config{
+		Seed:               seed,
+		NodeAPIDelayRate:   c.NodeAPIDelayRate,
+		NodeAPIMaxDelay:    c.NodeAPIMaxDelay,
+		NodeAPIFailureRate: c.NodeAPIFailureRate,
+		EventDropRate:      c.EventDropRate,
+		StatusFlipRate:     c.StatusFlipRate,
+	}, prom, logger), nil
+}
+
+// provideNodeProviders returns the node providers in fallback order, each
+// exposed to fault injection when chaos is enabled
+func provideNodeProviders(lc fx.Lifecycle, cfg *config.Config, client *nodeapi.Client, nodeManager *nodeapi.NodeManager, publisher service.EventPublisher, injector *chaos.Injector, prom *metrics.Prometheus, logger *zap.Logger) ([]service.NamedProvider, error) {
+	primary, err := newNodeProvider(lc, cfg, cfg.NodeAPI.Provider, nodeManager, cfg.NodeAPI.FakeCapacity, cfg.NodeAPI.FakeNoCapacity, publisher, logger)
+	if err != nil {
+		return nil, err
+	}
+	providers := []service.NamedProvider{{Name: cfg.NodeAPI.Name, Provider: primary}}
+	clients := []metrics.PendingCreationSource{client}
+
+	for i, fallback := range cfg.NodeAPI.Fallbacks {
+		if fallback.Name == "" {
+			return nil, fmt.Errorf("node_api.fallbacks[%d] needs a name", i)
+		}
+		if slices.ContainsFunc(providers, func(p service.NamedProvider) bool { return p.Name == fallback.Name }) {
+			return nil, fmt.Errorf("duplicate node provider name %q", fallback.Name)
+		}
+
+		kind := cmp.Or(fallback.Provider, "http")
+		var manager *nodeapi.NodeManager
+		if kind == "http" {
+			if fallback.BaseURL == "" {
+				return nil, fmt.Errorf("node provider %q needs a base_url", fallback.Name)
+			}
+			fallbackLogger := logger.With(zap.String("provider", fallback.Name))
+			fallbackClient := nodeapi.NewClient(fallback.BaseURL, cmp.Or(fallback.Timeout, cfg.NodeAPI.Timeout), nodeAPICreateOptions(cfg), fallbackLogger)
+			manager = nodeapi.NewNodeManager(fallbackClient, fallbackLogger)
+			clients = append(clients, fallbackClient)
+		}
+
+		provider, err := newNodeProvider(lc, cfg, kind, manager, fallback.FakeCapacity, fallback.FakeNoCapacity, publisher, logger)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, service.NamedProvider{Name: fallback.Name, Provider: provider})
+	}
+	prom.RegisterNodeAPI(clients...)
+
+	if injector != nil {
+		for i := range providers {
+			providers[i].Provider = injector.WrapProvider(providers[i].Provider)
+		}
+	}
+	return providers, nil
+}
+
+func newNodeProvider(lc fx.Lifecycle, cfg *config.Config, kind string, nodeManager *nodeapi.NodeManager, fakeCapacity int, fakeNoCapacity []string, publisher service.EventPublisher, logger *zap.Logger) (service.NodeProvider, error) {
+	switch kind {
+	case "", "http":
+		return nodeManager, nil
+	case "fake":
+		// Fake nodes report status on the publisher, which NATS does not read
+		if cfg.Events.Transport == "nats" {
+			return nil, errors.New("the fake node provider requires the redis or memory event transport")
+		}
+		provider := fake.NewProvider(publisher, fake.Options{
+			BootDelay:      cfg.NodeAPI.FakeBootDelay,
+			BootJitter:     cfg.NodeAPI.FakeBootJitter,
+			Capacity:       fakeCapacity,
+			ExhaustedZones: fakeNoCapacity,
+		}, logger)
+		lc.Append(fx.Hook{
+			OnStop: func(ctx context.Context) error {
+				provider.Stop()
+				return nil
+			},
+		})
+		logger.Warn("using the fake node provider; no real nodes will be created")
+		return provider, nil
+	default:
+		return nil, fmt.Errorf("unknown node provider %q", kind)
+	}
+}
+
+func provideSessionRecorder(lc fx.Lifecycle, cfg *config.Config, client *redis.Client, logger *zap.Logger) (*session.Recorder, error) {
+	var sink session.Sink
+	switch cfg.Sessions.Sink {
+	case "", "none":
+		sink = session.NopSink{}
+	case "redis":
+		sink = redis.NewSessionSink(client, cfg.Sessions.RedisStream, cfg.Sessions.RedisMaxLen)
+	case "kafka":
+		if len(cfg.Sessions.KafkaBrokers) == 0 {
+			return nil, fmt.Errorf("kafka session sink requires at least one broker")
+		}
+		kafkaSink := kafka.NewSessionSink(cfg.Sessions.KafkaBrokers, cfg.Sessions.KafkaTopic)
+		lc.Append(fx.Hook{
+			OnStop: func(ctx context.Context) error {
+				return kafkaSink.Close()
+			},
+		})
+		sink = kafkaSink
+	case "s3":
+		if cfg.Sessions.S3Bucket == "" {
+			return nil, fmt.Errorf("s3 session sink requires a bucket")
+		}
+		s3Sink, err := s3.NewSessionSink(context.Background(), cfg.Sessions.S3Bucket, cfg.Sessions.S3Prefix, cfg.Sessions.S3Region)
+		if err != nil {
+			return nil, err
+		}
+		sink = s3Sink
+	default:
+		return nil, fmt.Errorf("unknown session sink %q", cfg.Sessions.Sink)
+	}
+
+	recorder := session.NewRecorder(sink, session.Config{
+		FlushInterval: cfg.Sessions.FlushInterval,
+		BatchSize:     cfg.Sessions.BatchSize,
+		MaxBuffered:   cfg.Sessions.MaxBuffered,
+		History:       cfg.Metrics.JournalRetention,
+	}, logger)
+
+	// Appended after the sink's own hooks so the final flush runs before they stop
+	appendBackgroundHook(lc, logger, "session recorder", recorder.Run)
+
+	return recorder, nil
+}
+
+func provideHealthChecker(cfg *config.Config, redisClient *redis.Client, etcdCluster *etcd.Cluster, nodeAPIClient *nodeapi.Client, subscriber http.SubscriptionStatus, provisioner *service.Provisioner, seq *startup.Sequence) *health.Checker {
+	maxCheckAge := cfg.Health.MaxCheckAge
+	if maxCheckAge <= 0 {
+		maxCheckAge = 3 * cfg.Prediction.ScalingCheckInterval
+	}
+
+	var checks []health.Check
+	// Redis is not connected in dev mode unless something else needs it, and
+	// the fake provider has nothing to check
+	if redisClient != nil {
+		checks = append(checks, health.Check{
+			Name: "redis",
+			Run:  redisClient.Ping,
+		})
+	}
+	if etcdCluster != nil {
+		checks = append(checks, health.Check{
+			Name: "etcd",
+			Run:  etcdCluster.Ping,
+		})
+	}
+	if cfg.NodeAPI.Provider != "fake" {
+		checks = append(checks, health.Check{
+			Name:     "node_api",
+			Run:      nodeAPIClient.Ping,
+			CacheTTL: cfg.Health.NodeAPICacheTTL,
+		})
+	}
+
+	checks = append(checks,
+		health.Check{
+			Name: "subscription",
+			Run: func(context.Context) error {
+				if !subscriber.Subscribed() {
+					return errors.New("event subscription is down")
+				}
+				return nil
+			},
+		},
+		health.Check{
+			Name: "scaling",
+			Run: func(context.Context) error {
+				// Measure from the start of the scaling loop until the
+				// first check succeeds
+				last := provisioner.LastSuccessfulCheck()
+				if last.IsZero() {
+					phase, _ := seq.Phase(startup.PhaseScaling)
+					if phase.StartedAt.IsZero() {
+						return nil
+					}
+					last = phase.StartedAt
+				}
+				if age := time.Since(last); age > maxCheckAge {
+					return fmt.Errorf("no successful scaling check for %s", age.Round(time.Second))
+				}
+				return nil
+			},
+		},
+	)
+
+	return health.NewChecker(cfg.Health.Timeout, checks...)
+}
+
+func provideHTTPServer(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger, logLevel zap.AtomicLevel, nodePool *node.NodePool, userTracker *user.UserTracker, provisioner *service.Provisioner, subscriber http.SubscriptionStatus, seq *startup.Sequence, checker *health.Checker, hub *feed.Hub, j *journal.Journal, publisher service.EventPublisher, prom *metrics.Prometheus, handler events.Handler, namespace events.Namespace) (*http.Server, error) {
+	server := http.NewServer(cfg.Server.Port, cfg.Server.AdminToken, logger, logLevel, nodePool, userTracker, provisioner, subscriber, seq, checker, hub, j, prom, cfg.Hash(), cfg.Profile)
+	if j := cfg.Server.JWT; j.Enabled() {
+		verifier, err := jwt.NewVerifier(jwt.Config{
+			Secret:        j.Secret,
+			PublicKeyFile: j.PublicKeyFile,
+			Issuer:        j.Issuer,
+			Audience:      j.Audience,
+			RoleClaim:     j.RoleClaim,
+			TenantClaim:   j.TenantClaim,
+			Leeway:        j.Leeway,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("admin API JWT: %w", err)
+		}
+		server.EnableJWT(verifier)
+		logger.Info("admin API JWT authentication enabled",
+			zap.String("role_claim", j.RoleClaim),
+			zap.String("tenant_claim", j.TenantClaim),
+		)
+	}
+	server.EnableEventIngestion(handler, namespace)
+	if cfg.Events.WebhookSecret != "" {
+		server.EnableNodeStatusWebhook(handler, cfg.Events.WebhookSecret, cfg.Events.WebhookTolerance)
+	}
+	if cfg.Events.Transport == "memory" {
+		// Through the publisher, so injected events are sealed like any other
+		server.EnableEventInjection(publisher)
+	}
 
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
@@ -126,7 +1042,7 @@ func provideHTTPServer(lc fx.Lifecycle, cfg *config.Config, logger *zap.Logger,
 		},
 	})
 
-	return server
+	return server, nil
 }
 
 func provideProvisioner(
@@ -135,7 +1051,27 @@ func provideProvisioner(
 	userTracker *user.UserTracker,
 	alloc *allocator.NodeAllocator,
 	pred *predictor.Predictor,
-	nodeManager *nodeapi.NodeManager,
+	nodeProviders []service.NamedProvider,
+	publisher service.EventPublisher,
+	hist *history.History,
+	forecaster *forecast.Forecaster,
+	sloTracker *slo.Tracker,
+	lifecycleManager *lifecycle.Manager,
+	sessions *session.Recorder,
+	guard *safety.Guard,
+	hub *feed.Hub,
+	budgetTracker *budget.Tracker,
+	accessController *access.Controller,
+	accuracyTracker *accuracy.Tracker,
+	rightsizer *rightsizing.Engine,
+	purchaser *purchasing.Strategy,
+	limiter *ratelimit.Limiter,
+	userStore user.Store,
+	handoffStore handoff.Store,
+	cluster replication.Cluster,
+	j *journal.Journal,
+	seq *startup.Sequence,
+	prom *metrics.Prometheus,
 	cfg *config.Config,
 	logger *zap.Logger,
 ) *service.Provisioner {
@@ -144,41 +1080,283 @@ func provideProvisioner(
 		userTracker,
 		alloc,
 		pred,
-		nodeManager,
+		nodeProviders,
+		publisher,
+		hist,
+		forecaster,
+		sloTracker,
+		lifecycleManager,
+		sessions,
+		guard,
+		prom,
+		hub,
+		budgetTracker,
+		accessController,
+		accuracyTracker,
+		rightsizer,
+		purchaser,
+		limiter,
+		prom,
+		prom,
+		prom,
+		prom,
+		j,
+		userStore,
+		handoffStore,
+		cluster,
 		logger,
-		cfg.Prediction.ScalingCheckInterval,
+		service.Config{
+			CheckInterval:           cfg.Prediction.ScalingCheckInterval,
+			ScaleUpCooldown:         cfg.Prediction.ScaleUpCooldown,
+			ScaleDownCooldown:       cfg.Prediction.ScaleDownCooldown,
+			MaxTerminationsPerTick:  cfg.Prediction.MaxTerminationsPerTick,
+			ReservationsEnabled:     cfg.Prediction.ReservationEnabled,
+			MaxNodeAge:              cfg.Prediction.MaxNodeAge,
+			TerminatedNodeRetention: cfg.Prediction.TerminatedNodeRetention,
+			BootRetryBudget:         cfg.Prediction.BootRetryBudget,
+			BootFailureThreshold:    cfg.Prediction.BootFailureThreshold,
+			BootFailureBackoff:      cfg.Prediction.BootFailureBackoff,
+			BootFailureMaxBackoff:   cfg.Prediction.BootFailureMaxBackoff,
+			MigrationTimeout:        cfg.Allocation.MigrationTimeout,
+			MigrateOnDrain:          cfg.Allocation.MigrateOnDrain,
+			CloudEvents: events.CloudEvents{
+				Enabled:    cfg.Events.CloudEvents,
+				Source:     cfg.Events.CloudEventsSource,
+				TypePrefix: cfg.Events.CloudEventsTypePrefix,
+				Version:    buildinfo.Get().Version,
+			},
+			LatencyTiers: latencyTiers(cfg.Allocation.Tiers),
+			DefaultTier:  cfg.Allocation.DefaultTier,
+			TierMembers:  tierMembers(cfg.Allocation.TierMembers),
+			SessionLimit: service.SessionLimit{
+				MaxPerUser: cfg.Allocation.SessionLimit.MaxPerUser,
+				Tiers:      cfg.Allocation.SessionLimit.Tiers,
+			},
+			DryRun: cfg.Prediction.DryRun,
+			IdleReclaim: service.IdleReclaim{
+				Timeout:         cfg.Allocation.IdleReclaim.Timeout,
+				BusyThreshold:   cfg.Allocation.IdleReclaim.BusyThreshold,
+				Warnings:        cfg.Allocation.IdleReclaim.Warnings,
+				WarningInterval: cfg.Allocation.IdleReclaim.WarningInterval,
+				SampleMaxAge:    cfg.Allocation.IdleReclaim.SampleMaxAge,
+			},
+			RightsizingAutoSelect: cfg.Allocation.Rightsizing.AutoSelect,
+			Spillover: service.Spillover{
+				Zones:         cfg.NodeAPI.Spillover.Zones,
+				InstanceTypes: cfg.NodeAPI.Spillover.InstanceTypes,
+				ZoneCooldown:  cfg.NodeAPI.Spillover.ZoneCooldown,
+			},
+			Consistency: service.Consistency{
+				Interval: cfg.Allocation.Consistency.Interval,
+				Repair:   cfg.Allocation.Consistency.Policy == "repair",
+			},
+			HandoffMaxAge:    cfg.Allocation.HandoffMaxAge,
+			ReplicateChanges: cfg.HA.Mode == "etcd",
+		},
 	)
 
+	prom.RegisterBootFailures(provisioner)
+	prom.RegisterLeader(provisioner)
+
+	seq.Attach(startup.PhaseHydrate, provisioner.Hydrate)
+	seq.Attach(startup.PhaseReconcile, provisioner.Reconcile)
+
+	// Appended before the subscriber's hooks, so the pool is replicated,
+	// saved and handed off after events stop and the provisioner loop ends
 	lc.Append(fx.Hook{
-		OnStart: func(ctx context.Context) error {
-			go func() {
-				if err := provisioner.Start(context.Background()); err != nil {
-					logger.Error("provisioner error", zap.Error(err))
-				}
-			}()
-			logger.Info("provisioner started")
+		OnStop: func(ctx context.Context) error {
+			// Saving now would overwrite the state not yet loaded
+			if !seq.Ended(startup.PhaseHydrate) {
+				logger.Warn("stopped before state was hydrated; not persisting")
+				return nil
+			}
+			if err := provisioner.Replicate(ctx); err != nil {
+				logger.Error("failed to replicate pool", zap.Error(err))
+			}
+			if err := provisioner.SaveUsers(ctx); err != nil {
+				logger.Error("failed to persist connected users", zap.Error(err))
+			}
+			if err := provisioner.HandOff(ctx); err != nil {
+				logger.Error("failed to hand off pool", zap.Error(err))
+			}
 			return nil
 		},
 	})
+	appendGatedHook(lc, logger, "provisioner", seq.Started(startup.PhaseScaling), provisioner.Start)
+	if cfg.Allocation.Consistency.Interval > 0 {
+		appendGatedHook(lc, logger, "consistency checks", seq.Started(startup.PhaseScaling), provisioner.RunConsistencyChecks)
+	}
 
 	return provisioner
 }
 
-func provideSubscriber(lc fx.Lifecycle, client *redis.Client, provisioner *service.Provisioner, logger *zap.Logger) *redis.Subscriber {
-	subscriber := redis.NewSubscriber(client, provisioner, logger)
+// tierMembers converts the configured tier members for the provisioner
+func tierMembers(members map[string]config.TierMembersConfig) map[string]service.TierMembers {
+	if len(members) == 0 {
+		return nil
+	}
+	result := make(map[string]service.TierMembers, len(members))
+	for tier, m := range members {
+		result[tier] = service.TierMembers{Users: m.Users, Tenants: m.Tenants}
+	}
+	return result
+}
+
+// latencyTiers converts the configured tiers to provisioner latency budgets
+func latencyTiers(tiers map[string]config.TierConfig) map[string]service.LatencyTier {
+	if len(tiers) == 0 {
+		return nil
+	}
+	result := make(map[string]service.LatencyTier, len(tiers))
+	for name, tier := range tiers {
+		result[name] = service.LatencyTier{
+			MaxWait:      tier.MaxWait,
+			Escalation:   tier.Escalation,
+			InstanceType: tier.InstanceType,
+		}
+	}
+	return result
+}
+
+// eventSubscriber is implemented by every inbound event transport
+type eventSubscriber interface {
+	http.SubscriptionStatus
+	metrics.LagSource
+	Start(ctx context.Context) error
+}
+
+// provideEventHandler returns the handler inbound events are passed to,
+// acting on the pool only while this replica leads, with faults injected
+// when chaos is enabled
+func provideEventHandler(provisioner *service.Provisioner, injector *chaos.Injector) events.Handler {
+	handler := provisioner.LeaderOnly(provisioner)
+	if injector != nil {
+		return injector.WrapHandler(handler)
+	}
+	return handler
+}
+
+func provideSubscriber(lc fx.Lifecycle, cfg *config.Config, client *redis.Client, bus *memory.Bus, handler events.Handler, namespace events.Namespace, keyring *events.Keyring, seq *startup.Sequence, prom *metrics.Prometheus, logger *zap.Logger) (http.SubscriptionStatus, error) {
+	var subscriber eventSubscriber
+	guard := events.NewPoisonGuard(cfg.Events.PoisonThreshold, cfg.Events.PoisonTTL, prom, prom)
+
+	// Node state keys are read rather than published, so only the event
+	// transports open sealed payloads
+	var dispatcher events.Dispatcher = guard
+	if keyring.Enabled() {
+		dispatcher = events.NewOpeningDispatcher(guard, keyring, prom)
+	}
+
+	switch cfg.Events.Transport {
+	case "", "redis":
+		subscriber = redis.NewSubscriber(client, handler, dispatcher, namespace, logger)
+	case "nats":
+		subscriber = nats.NewSubscriber(nats.Options{
+			URL:           cfg.NATS.URL,
+			Stream:        cfg.NATS.Stream,
+			Durable:       cfg.NATS.Durable,
+			SubjectPrefix: cfg.NATS.SubjectPrefix,
+			MaxDeliver:    cfg.NATS.MaxDeliver,
+		}, handler, dispatcher, logger)
+	case "memory":
+		subscriber = memory.NewSubscriber(bus, handler, dispatcher, logger)
+	default:
+		return nil, fmt.Errorf("unknown event transport %q", cfg.Events.Transport)
+	}
+
+	prom.RegisterSubscriberLag(subscriber)
+	appendGatedHook(lc, logger, "subscriber", seq.Started(startup.PhaseSubscribe), subscriber.Start)
+	seq.Attach(startup.PhaseSubscribe, func(ctx context.Context) error {
+		return waitSubscribed(ctx, subscriber)
+	})
+
+	if ks := cfg.Events.Keyspace; ks.Enabled {
+		watcher, err := redis.NewKeyspaceWatcher(client, handler, guard, redis.KeyspaceOptions{
+			Pattern:   ks.Pattern,
+			DB:        cfg.Redis.DB,
+			Configure: ks.Configure,
+		}, logger)
+		if err != nil {
+			return nil, err
+		}
+		appendGatedHook(lc, logger, "node state key watcher", seq.Started(startup.PhaseSubscribe), watcher.Start)
+	}
+
+	return subscriber, nil
+}
+
+// waitSubscribed returns once the event subscription is up
+func waitSubscribed(ctx context.Context, subscriber http.SubscriptionStatus) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for !subscriber.Subscribed() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// provideStartup creates the startup phases; their work is attached by the
+// components they start
+func provideStartup(cfg *config.Config, logger *zap.Logger) *startup.Sequence {
+	return startup.NewSequence(logger,
+		startup.Phase{Name: startup.PhaseHydrate, Timeout: cfg.Startup.HydrateTimeout},
+		startup.Phase{Name: startup.PhaseReconcile, Timeout: cfg.Startup.ReconcileTimeout},
+		startup.Phase{Name: startup.PhaseSubscribe, Timeout: cfg.Startup.SubscribeTimeout},
+		startup.Phase{Name: startup.PhaseScaling},
+	)
+}
+
+// runStartup runs the startup phases once every component has started. It
+// depends on the HTTP server, and through it on everything else, so its
+// hook is appended last: /readyz is served while the phases run.
+func runStartup(lc fx.Lifecycle, seq *startup.Sequence, _ *http.Server, logger *zap.Logger) {
+	appendBackgroundHook(lc, logger, "startup", seq.Run)
+}
+
+// appendBackgroundHook runs a long-lived component in its own goroutine and
+// wires an OnStop hook that cancels it and waits for in-flight work to drain
+func appendBackgroundHook(lc fx.Lifecycle, logger *zap.Logger, name string, run func(ctx context.Context) error) {
+	appendGatedHook(lc, logger, name, nil, run)
+}
+
+// appendGatedHook is appendBackgroundHook for a component that waits for
+// gate to close before it runs; a nil gate runs it at once
+func appendGatedHook(lc fx.Lifecycle, logger *zap.Logger, name string, gate <-chan struct{}, run func(ctx context.Context) error) {
+	runCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
 
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
 			go func() {
-				if err := subscriber.Start(context.Background()); err != nil {
-					logger.Error("subscriber error", zap.Error(err))
+				defer close(done)
+				if gate != nil {
+					select {
+					case <-gate:
+					case <-runCtx.Done():
+						return
+					}
+				}
+				logger.Info(name + " started")
+				if err := run(runCtx); err != nil && !errors.Is(err, context.Canceled) {
+					logger.Error(name+" error", zap.Error(err))
 				}
 			}()
-			logger.Info("subscriber started")
 			return nil
 		},
+		OnStop: func(ctx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				logger.Info(name + " stopped")
+				return nil
+			case <-ctx.Done():
+				logger.Warn(name+" did not drain before shutdown deadline", zap.Error(ctx.Err()))
+				return ctx.Err()
+			}
+		},
 	})
-
-	return subscriber
 }
-
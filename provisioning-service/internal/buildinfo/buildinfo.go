@@ -0,0 +1,65 @@
+// Package buildinfo describes the running build. Release builds set the
+// variables with -ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/aos-cc/provisioning-service/internal/buildinfo.Version=v1.4.0 \
+//	  -X github.com/aos-cc/provisioning-service/internal/buildinfo.Commit=$(git rev-parse HEAD) \
+//	  -X github.com/aos-cc/provisioning-service/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/server
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Set at build time with -ldflags -X
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildTime = ""
+)
+
+// started is when the process started, near enough
+var started = time.Now()
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build info. The commit and build time fall back to the
+// VCS details the Go toolchain stamps into binaries built from a checkout.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = s.Value
+			}
+		}
+	}
+
+	return info
+}
+
+// StartedAt returns when the process started
+func StartedAt() time.Time {
+	return started
+}
+
+// Uptime returns how long the process has been running
+func Uptime() time.Duration {
+	return time.Since(started)
+}
@@ -0,0 +1,210 @@
+package access
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+)
+
+// Modes decide who may be allocated a node
+const (
+	ModeOpen      = "open"      // Everyone except blocklisted users
+	ModeAllowlist = "allowlist" // Only allowlisted users that are not blocklisted
+)
+
+// Lists a user can be added to
+const (
+	ListBlock = "blocklist"
+	ListAllow = "allowlist"
+)
+
+// Reasons a user is denied
+const (
+	ReasonBlocklisted    = "blocklisted"
+	ReasonNotAllowlisted = "not_allowlisted"
+	ReasonAuthzDenied    = "authz_denied"
+	ReasonAuthzError     = "authz_error"
+)
+
+// ErrUnknownList is returned when editing a list that does not exist
+var ErrUnknownList = errcode.New(errcode.NotFound, "unknown access list")
+
+// Authorizer is an external service asked about users the lists allow
+type Authorizer interface {
+	Authorize(ctx context.Context, userID, tenantID string) (bool, error)
+}
+
+// Observer is notified of denied users
+type Observer interface {
+	ObserveAccessDenied(reason string)
+}
+
+// Config sets the mode and the initial lists
+type Config struct {
+	Mode      string
+	Blocklist []string
+	Allowlist []string
+
+	// FailOpen allows users when the authorizer cannot be reached instead
+	// of denying them
+	FailOpen bool
+}
+
+// Validate checks the mode
+func (c Config) Validate() error {
+	switch c.Mode {
+	case "", ModeOpen, ModeAllowlist:
+		return nil
+	default:
+		return fmt.Errorf("invalid access mode: %q (must be %s or %s)", c.Mode, ModeOpen, ModeAllowlist)
+	}
+}
+
+// Decision is the outcome of checking a user
+type Decision struct {
+	Allowed bool
+	Reason  string // Why the user was denied
+	Err     error  // Authorizer error behind ReasonAuthzError
+}
+
+// Snapshot describes the mode and lists
+type Snapshot struct {
+	Mode       string
+	Blocklist  []string
+	Allowlist  []string
+	Authorizer bool
+}
+
+// Controller decides whether users may be allocated a node. The blocklist
+// always wins; in allowlist mode only allowlisted users pass. Users the lists
+// let through are then checked with the authorizer, if one is set. Edits to
+// the lists are kept in memory and last until restart.
+type Controller struct {
+	mode       string
+	authorizer Authorizer
+	failOpen   bool
+	observer   Observer
+
+	mu      sync.RWMutex
+	blocked map[string]struct{}
+	allowed map[string]struct{}
+}
+
+// NewController creates an access controller; authorizer may be nil
+func NewController(config Config, authorizer Authorizer, observer Observer) *Controller {
+	mode := config.Mode
+	if mode == "" {
+		mode = ModeOpen
+	}
+
+	c := &Controller{
+		mode:       mode,
+		authorizer: authorizer,
+		failOpen:   config.FailOpen,
+		observer:   observer,
+		blocked:    make(map[string]struct{}, len(config.Blocklist)),
+		allowed:    make(map[string]struct{}, len(config.Allowlist)),
+	}
+	for _, userID := range config.Blocklist {
+		c.blocked[userID] = struct{}{}
+	}
+	for _, userID := range config.Allowlist {
+		c.allowed[userID] = struct{}{}
+	}
+	return c
+}
+
+// Check decides whether a user may be allocated a node
+func (c *Controller) Check(ctx context.Context, userID, tenantID string) Decision {
+	decision := c.check(ctx, userID, tenantID)
+	if !decision.Allowed {
+		c.observer.ObserveAccessDenied(decision.Reason)
+	}
+	return decision
+}
+
+func (c *Controller) check(ctx context.Context, userID, tenantID string) Decision {
+	c.mu.RLock()
+	_, blocked := c.blocked[userID]
+	_, allowed := c.allowed[userID]
+	c.mu.RUnlock()
+
+	if blocked {
+		return Decision{Reason: ReasonBlocklisted}
+	}
+	if c.mode == ModeAllowlist && !allowed {
+		return Decision{Reason: ReasonNotAllowlisted}
+	}
+	if c.authorizer == nil {
+		return Decision{Allowed: true}
+	}
+
+	ok, err := c.authorizer.Authorize(ctx, userID, tenantID)
+	if err != nil {
+		return Decision{Allowed: c.failOpen, Reason: ReasonAuthzError, Err: err}
+	}
+	if !ok {
+		return Decision{Reason: ReasonAuthzDenied}
+	}
+	return Decision{Allowed: true}
+}
+
+// Add puts a user on a list
+func (c *Controller) Add(list, userID string) error {
+	users, err := c.list(list)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	users[userID] = struct{}{}
+	return nil
+}
+
+// Remove takes a user off a list
+func (c *Controller) Remove(list, userID string) error {
+	users, err := c.list(list)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(users, userID)
+	return nil
+}
+
+func (c *Controller) list(list string) (map[string]struct{}, error) {
+	switch list {
+	case ListBlock:
+		return c.blocked, nil
+	case ListAllow:
+		return c.allowed, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownList, list)
+	}
+}
+
+// Snapshot returns the mode and sorted lists
+func (c *Controller) Snapshot() Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return Snapshot{
+		Mode:       c.mode,
+		Blocklist:  sortedKeys(c.blocked),
+		Allowlist:  sortedKeys(c.allowed),
+		Authorizer: c.authorizer != nil,
+	}
+}
+
+func sortedKeys(m map[string]struct{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
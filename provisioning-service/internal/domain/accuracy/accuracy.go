@@ -0,0 +1,185 @@
+package accuracy
+
+import (
+	"sync"
+	"time"
+)
+
+// Outcomes of a prediction
+const (
+	OutcomeHit         = "hit"         // Predicted user connected within the prediction window
+	OutcomeExpired     = "expired"     // Predicted user did not connect in time
+	OutcomeUnpredicted = "unpredicted" // User connected without being predicted
+)
+
+// maxRecords caps the outcomes kept for inspection and the rolling figures
+const maxRecords = 1000
+
+// Observer is notified of resolved predictions
+type Observer interface {
+	ObservePredictionOutcome(outcome string)
+}
+
+// Record is a resolved prediction, or a connect nobody predicted
+type Record struct {
+	UserID      string
+	Outcome     string
+	PredictedAt time.Time // Zero for unpredicted connects
+	ResolvedAt  time.Time // Connect time, or the check that found the prediction expired
+}
+
+// Snapshot describes prediction accuracy over the rolling window
+type Snapshot struct {
+	Window      time.Duration
+	Open        int // Predictions still waiting for a connect
+	Hits        int
+	Expired     int
+	Unpredicted int
+	Precision   float64 // Hits over resolved predictions; 0 when there were none
+	Recall      float64 // Hits over connects; 0 when there were none
+}
+
+// Tracker checks predictions of who will connect against who does. A user
+// predicted to connect opens a prediction that is a hit if they connect
+// within the prediction window and expires otherwise; a user still predicted
+// after expiry opens a new one. A connect with no open prediction is a miss.
+type Tracker struct {
+	predictionWindow time.Duration
+	window           time.Duration
+	observer         Observer
+
+	mu        sync.Mutex
+	open      map[string]time.Time // User ID -> when the open prediction was made
+	connected map[string]time.Time // Users counted as connected, until they disconnect
+	records   []Record
+}
+
+// NewTracker creates a tracker resolving predictions after predictionWindow
+// and computing accuracy over a rolling window
+func NewTracker(predictionWindow, window time.Duration, observer Observer) *Tracker {
+	return &Tracker{
+		predictionWindow: predictionWindow,
+		window:           window,
+		observer:         observer,
+		open:             make(map[string]time.Time),
+		connected:        make(map[string]time.Time),
+	}
+}
+
+// Predicted records the users currently predicted to connect, expiring
+// predictions whose window has passed
+func (t *Tracker) Predicted(userIDs []string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for userID, at := range t.open {
+		if now.Sub(at) > t.predictionWindow {
+			delete(t.open, userID)
+			t.record(Record{UserID: userID, Outcome: OutcomeExpired, PredictedAt: at, ResolvedAt: now})
+		}
+	}
+	for userID, at := range t.connected {
+		if now.Sub(at) > t.window {
+			delete(t.connected, userID)
+		}
+	}
+
+	for _, userID := range userIDs {
+		if _, ok := t.open[userID]; !ok {
+			t.open[userID] = now
+		}
+	}
+	t.prune(now)
+}
+
+// Connected resolves a connect against the open predictions. Repeated
+// connects by a user who has not disconnected are not counted again.
+func (t *Tracker) Connected(userID string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.connected[userID]; ok {
+		return
+	}
+	t.connected[userID] = now
+
+	if at, ok := t.open[userID]; ok {
+		delete(t.open, userID)
+		t.record(Record{UserID: userID, Outcome: OutcomeHit, PredictedAt: at, ResolvedAt: now})
+		return
+	}
+	t.record(Record{UserID: userID, Outcome: OutcomeUnpredicted, ResolvedAt: now})
+}
+
+// Disconnected lets a user's next connect be counted
+func (t *Tracker) Disconnected(userID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.connected, userID)
+}
+
+// Snapshot returns accuracy figures over the rolling window
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(time.Now())
+
+	snap := Snapshot{
+		Window: t.window,
+		Open:   len(t.open),
+	}
+	for _, r := range t.records {
+		switch r.Outcome {
+		case OutcomeHit:
+			snap.Hits++
+		case OutcomeExpired:
+			snap.Expired++
+		case OutcomeUnpredicted:
+			snap.Unpredicted++
+		}
+	}
+	if resolved := snap.Hits + snap.Expired; resolved > 0 {
+		snap.Precision = float64(snap.Hits) / float64(resolved)
+	}
+	if connects := snap.Hits + snap.Unpredicted; connects > 0 {
+		snap.Recall = float64(snap.Hits) / float64(connects)
+	}
+	return snap
+}
+
+// Recent returns up to limit outcomes within the window, newest first
+func (t *Tracker) Recent(limit int) []Record {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.prune(time.Now())
+
+	n := min(limit, len(t.records))
+	recent := make([]Record, 0, n)
+	for i := len(t.records) - 1; i >= len(t.records)-n; i-- {
+		recent = append(recent, t.records[i])
+	}
+	return recent
+}
+
+// record appends an outcome; caller must hold the lock
+func (t *Tracker) record(r Record) {
+	t.records = append(t.records, r)
+	if len(t.records) > maxRecords {
+		t.records = append(t.records[:0], t.records[len(t.records)-maxRecords:]...)
+	}
+	t.observer.ObservePredictionOutcome(r.Outcome)
+}
+
+// prune drops outcomes that fell out of the rolling window; caller must hold the lock
+func (t *Tracker) prune(now time.Time) {
+	cutoff := now.Add(-t.window)
+	i := 0
+	for i < len(t.records) && t.records[i].ResolvedAt.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		t.records = append(t.records[:0], t.records[i:]...)
+	}
+}
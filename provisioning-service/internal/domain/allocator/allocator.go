@@ -1,51 +1,110 @@
 package allocator
 
 import (
-	"errors"
+	"context"
+	"fmt"
+	"slices"
+	"time"
 
-	"github.com/your-org/provisioning-service/internal/domain/node"
-	"github.com/your-org/provisioning-service/internal/domain/user"
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/purchasing"
+	"github.com/aos-cc/provisioning-service/internal/domain/user"
+	"go.uber.org/zap"
 )
 
+// maxClaimAttempts bounds the nodes tried when other replicas hold the slots
+// this replica's pool offers
+const maxClaimAttempts = 3
+
 var (
-	ErrNoReadyNode      = errors.New("no ready node available")
-	ErrUserNotFound     = errors.New("user not found")
-	ErrNodeNotFound     = errors.New("node not found")
-	ErrNodeNotReady     = errors.New("node is not ready")
-	ErrAlreadyAllocated = errors.New("user already has allocated node")
+	ErrNoReadyNode      = errcode.New(errcode.NoCapacity, "no ready node available")
+	ErrUserNotFound     = errcode.New(errcode.NotFound, "user not found")
+	ErrNodeNotFound     = errcode.New(errcode.NotFound, "node not found")
+	ErrNodeNotReady     = errcode.New(errcode.InvalidTransition, "node is not ready")
+	ErrAlreadyAllocated = errcode.New(errcode.AlreadyAllocated, "user already has allocated node")
+	ErrNotPending       = errcode.New(errcode.InvalidTransition, "allocation is not awaiting confirmation")
 )
 
 // NodeAllocator handles the allocation of nodes to users
 type NodeAllocator struct {
 	nodePool    *node.NodePool
 	userTracker *user.UserTracker
+	claims      Claims
+	logger      *zap.Logger
+
+	// confirmTTL is how long a connecting user has to confirm attaching
+	// before their slot is released; zero allocates outright
+	confirmTTL time.Duration
+
+	// offSpotTiers are the tiers whose users are never placed on spot nodes
+	offSpotTiers []string
 }
 
 // NewNodeAllocator creates a new node allocator
-func NewNodeAllocator(nodePool *node.NodePool, userTracker *user.UserTracker) *NodeAllocator {
+func NewNodeAllocator(nodePool *node.NodePool, userTracker *user.UserTracker, claims Claims, confirmTTL time.Duration, offSpotTiers []string, logger *zap.Logger) *NodeAllocator {
 	return &NodeAllocator{
-		nodePool:    nodePool,
-		userTracker: userTracker,
+		nodePool:     nodePool,
+		userTracker:  userTracker,
+		claims:       claims,
+		logger:       logger,
+		confirmTTL:   confirmTTL,
+		offSpotTiers: offSpotTiers,
+	}
+}
+
+// spotNodes returns the spot nodes that may take a user when the user's
+// tier is kept off spot, so they can be excluded; nil otherwise
+func (a *NodeAllocator) spotNodes(userID string) []string {
+	if len(a.offSpotTiers) == 0 {
+		return nil
+	}
+	tier := a.userTracker.TierOf(userID)
+	if tier == "" || !slices.Contains(a.offSpotTiers, tier) {
+		return nil
+	}
+
+	isSpot := func(n *node.Node) bool { return n.Purchase == purchasing.OptionSpot }
+	var nodeIDs []string
+	for _, status := range []node.NodeStatus{node.NodeStatusReady, node.NodeStatusAllocated, node.NodeStatusUnconfirmed} {
+		for _, n := range a.nodePool.GetAllByStatusWhere(status, isSpot) {
+			nodeIDs = append(nodeIDs, n.ID)
+		}
+	}
+	return nodeIDs
+}
+
+// connectUntil returns when a slot taken for a connecting user lapses
+// without confirmation, or zero when connects are allocated outright
+func (a *NodeAllocator) connectUntil() time.Time {
+	if a.confirmTTL <= 0 {
+		return time.Time{}
 	}
+	return time.Now().Add(a.confirmTTL)
 }
 
-// AllocateNodeToUser allocates a ready node to a user
-func (a *NodeAllocator) AllocateNodeToUser(userID string) (string, error) {
+// AllocateNodeToUser takes a slot for a user, packing them onto a shared
+// node with room before using a free ready node. The slot is claimed across
+// replicas first; nodes whose slots other replicas hold are skipped. Only
+// nodes whose labels match the selector recorded for the user are used, and
+// those of the instance type preferred for them are tried first. With a
+// confirmation TTL the slot is only reserved until ConfirmAllocation.
+func (a *NodeAllocator) AllocateNodeToUser(ctx context.Context, userID string) (string, error) {
 	// Check if user already has a node
 	state, exists := a.userTracker.GetUserState(userID)
 	if exists && state.IsConnected && state.AllocatedNodeID != "" {
 		return state.AllocatedNodeID, ErrAlreadyAllocated
 	}
 
-	// Get a ready node
-	node := a.nodePool.GetReadyNode()
-	if node == nil {
-		return "", ErrNoReadyNode
+	node, err := a.claimReadyNode(ctx, userID)
+	if err != nil {
+		return "", err
 	}
 
 	// Allocate the node
-	success := a.nodePool.AllocateNode(node.ID, userID)
+	success := a.nodePool.AllocatePending(node.ID, userID, a.userTracker.TenantOf(userID), a.connectUntil())
 	if !success {
+		a.release(ctx, node.ID, userID)
 		return "", ErrNodeNotReady
 	}
 
@@ -55,8 +114,180 @@ func (a *NodeAllocator) AllocateNodeToUser(userID string) (string, error) {
 	return node.ID, nil
 }
 
+// AllocateSharedNode places a user on a node already hosting other users,
+// whatever labels the user asked for, though never a spot node for a tier
+// kept off spot. It is the last resort for users who waited past their
+// latency budget.
+func (a *NodeAllocator) AllocateSharedNode(ctx context.Context, userID string) (string, error) {
+	state, exists := a.userTracker.GetUserState(userID)
+	if exists && state.IsConnected && state.AllocatedNodeID != "" {
+		return state.AllocatedNodeID, ErrAlreadyAllocated
+	}
+
+	taken := a.spotNodes(userID)
+	for range maxClaimAttempts {
+		n := a.nodePool.GetSharedNode(userID, taken...)
+		if n == nil {
+			return "", ErrNoReadyNode
+		}
+
+		claimed, err := a.claims.Claim(ctx, n.ID, userID, n.Slots())
+		if err != nil {
+			return "", fmt.Errorf("failed to claim node %s: %w", n.ID, err)
+		}
+		if !claimed {
+			taken = append(taken, n.ID)
+			continue
+		}
+
+		if !a.nodePool.AllocatePending(n.ID, userID, a.userTracker.TenantOf(userID), a.connectUntil()) {
+			a.release(ctx, n.ID, userID)
+			return "", ErrNodeNotReady
+		}
+		a.userTracker.MarkConnected(userID, n.ID)
+		return n.ID, nil
+	}
+	return "", ErrNoReadyNode
+}
+
+// claimReadyNode finds a node with a free slot for a user, matching the
+// labels the user asked for, and claims the slot across replicas. The node
+// the user last had is preferred while it is ready, for its warm caches and
+// local state, then a node held for the user or their tenant, then one of
+// the instance type preferred for them; excluded nodes are skipped, as are
+// spot nodes for a user whose tier is kept off spot.
+func (a *NodeAllocator) claimReadyNode(ctx context.Context, userID string, exclude ...string) (*node.Node, error) {
+	selector := a.userTracker.SelectorOf(userID)
+	tenantID := a.userTracker.TenantOf(userID)
+	instanceType := a.userTracker.InstanceTypeOf(userID)
+	lastNodeID := a.userTracker.LastNodeOf(userID)
+
+	taken := append(slices.Clone(exclude), a.spotNodes(userID)...)
+	for range maxClaimAttempts {
+		n := a.nodePool.GetReadyNodePreferring(userID, tenantID, selector, instanceType, lastNodeID, taken...)
+		if n == nil {
+			return nil, ErrNoReadyNode
+		}
+
+		claimed, err := a.claims.Claim(ctx, n.ID, userID, n.Slots())
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim node %s: %w", n.ID, err)
+		}
+		if claimed {
+			if n.ID == lastNodeID {
+				a.logger.Debug("returning user given their last node",
+					zap.String("node_id", n.ID),
+					zap.String("user_id", userID),
+				)
+			}
+			return n, nil
+		}
+
+		a.logger.Info("node slot claimed by another replica",
+			zap.String("node_id", n.ID),
+			zap.String("user_id", userID),
+		)
+		taken = append(taken, n.ID)
+	}
+	return nil, ErrNoReadyNode
+}
+
+// release gives up a user's claim on a node. A claim left behind only makes
+// the slot look taken to other replicas until the node is terminated, so
+// failures are logged rather than returned.
+func (a *NodeAllocator) release(ctx context.Context, nodeID, userID string) {
+	if err := a.claims.Release(ctx, nodeID, userID); err != nil {
+		a.logger.Warn("failed to release node claim",
+			zap.String("node_id", nodeID),
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+	}
+}
+
+// ForgetNode drops the claims on a terminated node
+func (a *NodeAllocator) ForgetNode(ctx context.Context, nodeID string) {
+	if err := a.claims.Forget(ctx, nodeID); err != nil {
+		a.logger.Warn("failed to drop node claims",
+			zap.String("node_id", nodeID),
+			zap.Error(err),
+		)
+	}
+}
+
+// RestoreClaims replaces the claims on nodes with claims for the users on
+// them, for a pool replaced wholesale
+func (a *NodeAllocator) RestoreClaims(ctx context.Context, nodes []node.Node) {
+	for _, n := range nodes {
+		a.ForgetNode(ctx, n.ID)
+		for _, userID := range n.Users {
+			if _, err := a.claims.Claim(ctx, n.ID, userID, n.Slots()); err != nil {
+				a.logger.Warn("failed to restore node claim",
+					zap.String("node_id", n.ID),
+					zap.String("user_id", userID),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// RenewClaims refreshes the claims of every user on a node in the pool, so
+// they outlast the claim TTL while the claims of a replica that died before
+// allocating, which nobody renews, lapse
+func (a *NodeAllocator) RenewClaims(ctx context.Context) {
+	for _, n := range a.nodePool.Snapshot() {
+		if len(n.Users) == 0 || n.Status == node.NodeStatusTerminated {
+			continue
+		}
+		if err := a.claims.Renew(ctx, n.ID, n.Users); err != nil {
+			a.logger.Warn("failed to renew node claims",
+				zap.String("node_id", n.ID),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// ReserveNodeForUser soft-reserves a ready node for a user predicted to
+// connect, reporting whether the reservation is new rather than extended
+func (a *NodeAllocator) ReserveNodeForUser(userID string, until time.Time) (string, bool, error) {
+	node, created := a.nodePool.Reserve(userID, until)
+	if node == nil {
+		return "", false, ErrNoReadyNode
+	}
+	return node.ID, created, nil
+}
+
+// ConfirmAllocation completes the allocation of a user who attached to the
+// node reserved for them. ErrNotPending is returned when the user holds no
+// unconfirmed slot on that node.
+func (a *NodeAllocator) ConfirmAllocation(userID, nodeID string) error {
+	current, ok := a.GetAllocation(userID)
+	if !ok {
+		return ErrUserNotFound
+	}
+	if current != nodeID || !a.nodePool.Confirm(nodeID, userID) {
+		return ErrNotPending
+	}
+	return nil
+}
+
+// ExpireReservations releases the slots of users who did not confirm
+// attaching in time and returns the node each was released from by user ID
+func (a *NodeAllocator) ExpireReservations(ctx context.Context) map[string]string {
+	expired := a.nodePool.ExpirePending(time.Now())
+	for userID, nodeID := range expired {
+		a.release(ctx, nodeID, userID)
+		if current, ok := a.GetAllocation(userID); ok && current == nodeID {
+			a.userTracker.MarkDisconnected(userID)
+		}
+	}
+	return expired
+}
+
 // DeallocateNodeFromUser deallocates a node from a user
-func (a *NodeAllocator) DeallocateNodeFromUser(userID string) error {
+func (a *NodeAllocator) DeallocateNodeFromUser(ctx context.Context, userID string) error {
 	// Get user state
 	state, exists := a.userTracker.GetUserState(userID)
 	if !exists || !state.IsConnected {
@@ -68,8 +299,9 @@ func (a *NodeAllocator) DeallocateNodeFromUser(userID string) error {
 		return ErrNodeNotFound
 	}
 
-	// Deallocate the node
-	a.nodePool.DeallocateNode(nodeID)
+	// Release the user's slot
+	a.nodePool.DeallocateNode(nodeID, userID)
+	a.release(ctx, nodeID, userID)
 
 	// Mark user as disconnected
 	a.userTracker.MarkDisconnected(userID)
@@ -77,6 +309,107 @@ func (a *NodeAllocator) DeallocateNodeFromUser(userID string) error {
 	return nil
 }
 
+// ForceDeallocate releases a user's node and returns its ID, draining it
+// for the given reason. The node is drained rather than returned to the
+// pool, since the state of the session left on it is unknown; other users
+// on a shared node keep their slots until they leave.
+func (a *NodeAllocator) ForceDeallocate(ctx context.Context, userID string, reason node.TerminationReason) (string, error) {
+	nodeID, ok := a.GetAllocation(userID)
+	if !ok {
+		return "", ErrUserNotFound
+	}
+
+	a.nodePool.DeallocateAndDrain(nodeID, userID, reason)
+	a.release(ctx, nodeID, userID)
+	a.userTracker.MarkDisconnected(userID)
+
+	return nodeID, nil
+}
+
+// ReassignUser moves a connected user to another node and returns the
+// previous and new node IDs. The previous node is drained; if no ready node is
+// free the user keeps their current allocation.
+func (a *NodeAllocator) ReassignUser(ctx context.Context, userID string) (string, string, error) {
+	fromID, ok := a.GetAllocation(userID)
+	if !ok {
+		return "", "", ErrUserNotFound
+	}
+
+	target, err := a.claimReadyNode(ctx, userID)
+	if err != nil {
+		return fromID, "", err
+	}
+
+	if !a.nodePool.AllocateNode(target.ID, userID, a.userTracker.TenantOf(userID)) {
+		a.release(ctx, target.ID, userID)
+		return fromID, "", ErrNodeNotReady
+	}
+
+	a.nodePool.DeallocateAndDrain(fromID, userID, node.TerminationAdmin)
+	a.release(ctx, fromID, userID)
+	a.userTracker.MarkConnected(userID, target.ID)
+
+	return fromID, target.ID, nil
+}
+
+// AllocateMigrationTarget takes a slot on another node for a connected user
+// while they keep their current one, returning the current and new node IDs.
+// The user stays connected to the current node until CompleteMigration.
+func (a *NodeAllocator) AllocateMigrationTarget(ctx context.Context, userID string) (string, string, error) {
+	fromID, ok := a.GetAllocation(userID)
+	if !ok {
+		return "", "", ErrUserNotFound
+	}
+
+	target, err := a.claimReadyNode(ctx, userID, fromID)
+	if err != nil {
+		return fromID, "", err
+	}
+
+	if !a.nodePool.AllocateNode(target.ID, userID, a.userTracker.TenantOf(userID)) {
+		a.release(ctx, target.ID, userID)
+		return fromID, "", ErrNodeNotReady
+	}
+
+	return fromID, target.ID, nil
+}
+
+// CompleteMigration moves a user's allocation to the migration target and
+// releases their slot on the previous node. A clean handover returns the
+// previous node to the pool; otherwise it is drained, since the state of
+// the session left on it is unknown.
+func (a *NodeAllocator) CompleteMigration(ctx context.Context, userID, fromID, toID string, clean bool) {
+	if clean {
+		a.nodePool.DeallocateNode(fromID, userID)
+	} else {
+		a.nodePool.DeallocateAndDrain(fromID, userID, node.TerminationMigrated)
+	}
+	a.release(ctx, fromID, userID)
+	a.userTracker.MarkConnected(userID, toID)
+}
+
+// AbortMigration gives up the slot taken on a migration target; the user
+// keeps their current allocation
+func (a *NodeAllocator) AbortMigration(ctx context.Context, userID, toID string) {
+	a.nodePool.DeallocateNode(toID, userID)
+	a.release(ctx, toID, userID)
+}
+
+// ReleaseSlot gives up a slot a node holds for a user the user tracker does
+// not have on it, returning the node to the pool once it is empty. The
+// user's tracked allocation is left alone.
+func (a *NodeAllocator) ReleaseSlot(ctx context.Context, nodeID, userID string) {
+	a.nodePool.DeallocateNode(nodeID, userID)
+	a.release(ctx, nodeID, userID)
+}
+
+// DrainSlot is ReleaseSlot draining the node for the given reason, since
+// the state of the session left on it is unknown
+func (a *NodeAllocator) DrainSlot(ctx context.Context, nodeID, userID string, reason node.TerminationReason) {
+	a.nodePool.DeallocateAndDrain(nodeID, userID, reason)
+	a.release(ctx, nodeID, userID)
+}
+
 // GetAllocation returns the current allocation for a user
 func (a *NodeAllocator) GetAllocation(userID string) (string, bool) {
 	state, exists := a.userTracker.GetUserState(userID)
@@ -86,11 +419,11 @@ func (a *NodeAllocator) GetAllocation(userID string) (string, bool) {
 	return state.AllocatedNodeID, true
 }
 
-// GetNodeAllocation returns the user allocated to a node
-func (a *NodeAllocator) GetNodeAllocation(nodeID string) (string, bool) {
+// GetNodeAllocation returns the users allocated to a node
+func (a *NodeAllocator) GetNodeAllocation(nodeID string) ([]string, bool) {
 	n, exists := a.nodePool.Get(nodeID)
-	if !exists || n.Status != node.NodeStatusAllocated {
-		return "", false
+	if !exists || !n.Occupied() {
+		return nil, false
 	}
-	return n.UserID, true
+	return n.Users, true
 }
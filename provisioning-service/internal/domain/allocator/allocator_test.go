@@ -0,0 +1,69 @@
+package allocator
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/purchasing"
+	"github.com/aos-cc/provisioning-service/internal/domain/user"
+	"go.uber.org/zap"
+)
+
+type nopObserver struct{}
+
+func (nopObserver) ObserveUserEviction(string) {}
+
+func TestAllocateKeepsOffSpotTiersOffSpot(t *testing.T) {
+	pool := node.NewNodePool(node.AgentCompatibility{})
+	pool.Add(&node.Node{ID: "spot", Status: node.NodeStatusReady, Purchase: purchasing.OptionSpot})
+	users := user.NewUserTracker(time.Minute, user.Config{}, nopObserver{})
+	a := NewNodeAllocator(pool, users, LocalClaims{}, 0, []string{"premium"}, zap.NewNop())
+
+	users.SetTier("u1", "premium")
+	if _, err := a.AllocateNodeToUser(t.Context(), "u1"); err != ErrNoReadyNode {
+		t.Fatalf("AllocateNodeToUser = %v, want ErrNoReadyNode", err)
+	}
+
+	pool.Add(&node.Node{ID: "on-demand", Status: node.NodeStatusReady, Purchase: purchasing.OptionOnDemand})
+	nodeID, err := a.AllocateNodeToUser(t.Context(), "u1")
+	if err != nil || nodeID != "on-demand" {
+		t.Fatalf("AllocateNodeToUser = %q, %v, want on-demand", nodeID, err)
+	}
+
+	users.SetTier("u2", "standard")
+	nodeID, err = a.AllocateNodeToUser(t.Context(), "u2")
+	if err != nil || nodeID != "spot" {
+		t.Fatalf("AllocateNodeToUser = %q, %v, want spot", nodeID, err)
+	}
+}
+
+// renewals records the users whose claims each node renews
+type renewals struct {
+	LocalClaims
+	renewed map[string][]string
+}
+
+func (r *renewals) Renew(ctx context.Context, nodeID string, userIDs []string) error {
+	r.renewed[nodeID] = userIDs
+	return nil
+}
+
+func TestRenewClaims(t *testing.T) {
+	pool := node.NewNodePool(node.AgentCompatibility{})
+	pool.Replace([]node.Node{
+		{ID: "shared", Status: node.NodeStatusAllocated, Users: []string{"u1", "u2"}, Capacity: 2},
+		{ID: "idle", Status: node.NodeStatusReady},
+		{ID: "gone", Status: node.NodeStatusTerminated, Users: []string{"u3"}},
+	})
+	claims := &renewals{renewed: make(map[string][]string)}
+	a := NewNodeAllocator(pool, user.NewUserTracker(time.Minute, user.Config{}, nopObserver{}), claims, 0, nil, zap.NewNop())
+
+	a.RenewClaims(t.Context())
+
+	if len(claims.renewed) != 1 || !slices.Equal(claims.renewed["shared"], []string{"u1", "u2"}) {
+		t.Errorf("renewed %v, want only shared's u1 and u2", claims.renewed)
+	}
+}
@@ -0,0 +1,42 @@
+package allocator
+
+import "context"
+
+// Claims coordinates node slots between service replicas, so that replicas
+// handling the same events cannot hand one slot to different users. Claims
+// are idempotent per user: replicas allocating the same user agree.
+type Claims interface {
+	// Claim takes one of a node's capacity slots for a user, reporting false
+	// if all of them are held by other users
+	Claim(ctx context.Context, nodeID, userID string, capacity int) (bool, error)
+
+	// Release gives up a user's slot on a node
+	Release(ctx context.Context, nodeID, userID string) error
+
+	// Forget drops every claim on a node that no longer exists
+	Forget(ctx context.Context, nodeID string) error
+
+	// Renew refreshes the claims of the users on a node, so they outlast
+	// the claim TTL; claims nobody renews lapse
+	Renew(ctx context.Context, nodeID string, userIDs []string) error
+}
+
+// LocalClaims is used by a single replica, whose node pool already
+// serialises allocations
+type LocalClaims struct{}
+
+func (LocalClaims) Claim(ctx context.Context, nodeID, userID string, capacity int) (bool, error) {
+	return true, nil
+}
+
+func (LocalClaims) Release(ctx context.Context, nodeID, userID string) error {
+	return nil
+}
+
+func (LocalClaims) Forget(ctx context.Context, nodeID string) error {
+	return nil
+}
+
+func (LocalClaims) Renew(ctx context.Context, nodeID string, userIDs []string) error {
+	return nil
+}
@@ -0,0 +1,98 @@
+package boottime
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxSamples caps the boot durations kept per instance type
+const maxSamples = 100
+
+// MinSamples is the number of boots of a type observed before its
+// percentiles are reported
+const MinSamples = 5
+
+// Observer is notified of every observed boot
+type Observer interface {
+	ObserveBootDuration(instanceType string, d time.Duration)
+}
+
+// Stats summarizes the recent boot durations of an instance type
+type Stats struct {
+	Samples int
+	P50     time.Duration
+	P90     time.Duration
+	P99     time.Duration
+}
+
+// Tracker keeps the most recent booting-to-ready durations of each instance
+// type and reports rolling percentiles over them
+type Tracker struct {
+	observer Observer
+
+	mu      sync.Mutex
+	samples map[string][]time.Duration
+}
+
+// NewTracker creates an empty tracker
+func NewTracker(observer Observer) *Tracker {
+	return &Tracker{
+		observer: observer,
+		samples:  make(map[string][]time.Duration),
+	}
+}
+
+// Observe records the time a node of an instance type took to become ready
+func (t *Tracker) Observe(instanceType string, d time.Duration) {
+	t.observer.ObserveBootDuration(instanceType, d)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	samples := append(t.samples[instanceType], d)
+	if len(samples) > maxSamples {
+		samples = samples[len(samples)-maxSamples:]
+	}
+	t.samples[instanceType] = samples
+}
+
+// Percentile returns the q-th percentile (0 < q <= 1) of an instance type's
+// recent boot durations, or false until MinSamples boots were observed
+func (t *Tracker) Percentile(instanceType string, q float64) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	samples := t.samples[instanceType]
+	if len(samples) < MinSamples {
+		return 0, false
+	}
+	return percentile(slices.Sorted(slices.Values(samples)), q), true
+}
+
+// Snapshot returns the stats of every instance type with observed boots;
+// percentiles stay zero until MinSamples boots were observed
+func (t *Tracker) Snapshot() map[string]Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := make(map[string]Stats, len(t.samples))
+	for instanceType, samples := range t.samples {
+		s := Stats{Samples: len(samples)}
+		if len(samples) >= MinSamples {
+			sorted := slices.Sorted(slices.Values(samples))
+			s.P50 = percentile(sorted, 0.5)
+			s.P90 = percentile(sorted, 0.9)
+			s.P99 = percentile(sorted, 0.99)
+		}
+		stats[instanceType] = s
+	}
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	return sorted[max(0, min(rank, len(sorted)-1))]
+}
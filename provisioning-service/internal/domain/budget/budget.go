@@ -0,0 +1,226 @@
+package budget
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+// Limits a scale-up can be blocked by
+const (
+	LimitHourly = "hourly"
+	LimitDaily  = "daily"
+)
+
+// Config sets node prices and spend limits; a zero limit is not enforced
+type Config struct {
+	Prices       map[string]float64 // Hourly price by instance type
+	DefaultPrice float64            // Hourly price of types without their own
+	MaxHourly    float64            // Cap on the hourly run rate of running nodes
+	MaxDaily     float64            // Cap on spend per UTC day
+}
+
+// Enabled reports whether any limit is set
+func (c Config) Enabled() bool {
+	return c.MaxHourly > 0 || c.MaxDaily > 0
+}
+
+// Price returns the hourly price of an instance type
+func (c Config) Price(instanceType string) float64 {
+	if price, ok := c.Prices[instanceType]; ok {
+		return price
+	}
+	return c.DefaultPrice
+}
+
+// Validate checks that prices and limits are usable
+func (c Config) Validate() error {
+	if c.MaxHourly < 0 || c.MaxDaily < 0 {
+		return fmt.Errorf("invalid spend limits: hourly=%g daily=%g", c.MaxHourly, c.MaxDaily)
+	}
+	if c.DefaultPrice < 0 {
+		return fmt.Errorf("invalid hourly price: %g", c.DefaultPrice)
+	}
+	priced := c.DefaultPrice > 0
+	for instanceType, price := range c.Prices {
+		if price < 0 {
+			return fmt.Errorf("invalid hourly price for instance type %q: %g", instanceType, price)
+		}
+		priced = priced || price > 0
+	}
+	if c.Enabled() && !priced {
+		return fmt.Errorf("spend limits require an hourly price")
+	}
+	return nil
+}
+
+// Observer is notified of scale-ups the budget blocked
+type Observer interface {
+	ObserveBudgetBlock(limit string)
+}
+
+// Decision is the outcome of checking a scale-up against the budget
+type Decision struct {
+	Allowed int    // Nodes that may be provisioned
+	Limit   string // Limit that blocked the rest, if any
+
+	// Alert is set the first time scale-ups of a type are blocked by a limit,
+	// and again only after they fit once more
+	Alert bool
+}
+
+// Snapshot describes spend against the limits
+type Snapshot struct {
+	Enabled       bool
+	HourlyRunRate float64 // Hourly price of every running node
+	SpentToday    float64 // Accrued since UTC midnight
+	MaxHourly     float64
+	MaxDaily      float64
+	Blocked       int64 // Nodes not provisioned because of the budget
+}
+
+// Tracker accrues spend from running node hours and caps scale-ups so that
+// the hourly run rate, and spend today plus the next hour at the resulting
+// run rate, stay within the limits. Spend is kept in memory, so it restarts
+// from zero with the service.
+type Tracker struct {
+	config   Config
+	nodePool *node.NodePool
+	observer Observer
+
+	mu          sync.Mutex
+	day         time.Time // UTC midnight of the day being accrued
+	spentToday  float64
+	lastAccrued time.Time
+	blocked     int64
+	blocking    map[string]string // Limit currently blocking each instance type
+}
+
+// NewTracker creates a budget tracker
+func NewTracker(config Config, nodePool *node.NodePool, observer Observer) *Tracker {
+	return &Tracker{
+		config:   config,
+		nodePool: nodePool,
+		observer: observer,
+		blocking: make(map[string]string),
+	}
+}
+
+// runRate returns the hourly price of every node still running
+func (t *Tracker) runRate() float64 {
+	rate := 0.0
+	for _, n := range t.nodePool.GetAll() {
+		if n.Status != node.NodeStatusTerminated {
+			rate += t.config.Price(n.InstanceType)
+		}
+	}
+	return rate
+}
+
+// Accrue adds the spend of running nodes since the last call, starting over
+// at UTC midnight
+func (t *Tracker) Accrue(now time.Time) {
+	rate := t.runRate()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	day := now.UTC().Truncate(24 * time.Hour)
+	from := t.lastAccrued
+	if !day.Equal(t.day) {
+		t.day = day
+		t.spentToday = 0
+		if !from.IsZero() && from.Before(day) {
+			from = day
+		}
+	}
+	if !from.IsZero() && now.After(from) {
+		t.spentToday += rate * now.Sub(from).Hours()
+	}
+	t.lastAccrued = now
+}
+
+// Allow returns how many of count nodes of an instance type fit the budget
+func (t *Tracker) Allow(instanceType string, count int) Decision {
+	price := t.config.Price(instanceType)
+	if !t.config.Enabled() || price <= 0 || count <= 0 {
+		return Decision{Allowed: count}
+	}
+
+	rate := t.runRate()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	decision := Decision{Allowed: count}
+	if t.config.MaxHourly > 0 {
+		if fit := nodesWithin(t.config.MaxHourly-rate, price); fit < decision.Allowed {
+			decision.Allowed = fit
+			decision.Limit = LimitHourly
+		}
+	}
+	if t.config.MaxDaily > 0 {
+		if fit := nodesWithin(t.config.MaxDaily-t.spentToday-rate, price); fit < decision.Allowed {
+			decision.Allowed = fit
+			decision.Limit = LimitDaily
+		}
+	}
+
+	if decision.Limit == "" {
+		delete(t.blocking, instanceType)
+		return decision
+	}
+
+	t.blocked += int64(count - decision.Allowed)
+	t.observer.ObserveBudgetBlock(decision.Limit)
+	if t.blocking[instanceType] != decision.Limit {
+		t.blocking[instanceType] = decision.Limit
+		decision.Alert = true
+	}
+	return decision
+}
+
+// Price returns the hourly price of an instance type
+func (t *Tracker) Price(instanceType string) float64 {
+	return t.config.Price(instanceType)
+}
+
+// Overspend returns how far the hourly run rate exceeds MaxHourly, or zero.
+// Scale-ups are kept within it, so the rate only exceeds it through nodes
+// added without a check, such as nodes of a pricier type spilled over to,
+// nodes reporting in unannounced, or a pool handed off to a deploy with a
+// lower limit.
+func (t *Tracker) Overspend() float64 {
+	if t.config.MaxHourly <= 0 {
+		return 0
+	}
+	return max(t.runRate()-t.config.MaxHourly, 0)
+}
+
+// nodesWithin returns how many nodes at price fit in the remaining amount
+func nodesWithin(remaining, price float64) int {
+	if remaining <= 0 {
+		return 0
+	}
+	return int(math.Floor(remaining / price))
+}
+
+// Snapshot returns spend against the limits
+func (t *Tracker) Snapshot() Snapshot {
+	rate := t.runRate()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return Snapshot{
+		Enabled:       t.config.Enabled(),
+		HourlyRunRate: rate,
+		SpentToday:    t.spentToday,
+		MaxHourly:     t.config.MaxHourly,
+		MaxDaily:      t.config.MaxDaily,
+		Blocked:       t.blocked,
+	}
+}
@@ -0,0 +1,44 @@
+package budget
+
+import (
+	"testing"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+type nopObserver struct{}
+
+func (nopObserver) ObserveBudgetBlock(string) {}
+
+func TestOverspend(t *testing.T) {
+	pool := node.NewNodePool(node.AgentCompatibility{})
+	pool.Replace([]node.Node{
+		{ID: "n1", InstanceType: "a100", Status: node.NodeStatusReady},
+		{ID: "n2", InstanceType: "a100", Status: node.NodeStatusAllocated},
+		{ID: "n3", InstanceType: "t4", Status: node.NodeStatusBooting},
+		{ID: "n4", InstanceType: "a100", Status: node.NodeStatusTerminated},
+	})
+	config := Config{Prices: map[string]float64{"a100": 3}, DefaultPrice: 1}
+
+	tests := map[float64]float64{
+		0:  0, // Not enforced
+		5:  2,
+		7:  0,
+		10: 0,
+	}
+	for limit, want := range tests {
+		config.MaxHourly = limit
+		tracker := NewTracker(config, pool, nopObserver{})
+		if got := tracker.Overspend(); got != want {
+			t.Errorf("Overspend with max_hourly %g = %g, want %g", limit, got, want)
+		}
+	}
+
+	tracker := NewTracker(config, pool, nopObserver{})
+	if got := tracker.Price("a100"); got != 3 {
+		t.Errorf("Price(a100) = %g, want 3", got)
+	}
+	if got := tracker.Price("t4"); got != 1 {
+		t.Errorf("Price(t4) = %g, want the default 1", got)
+	}
+}
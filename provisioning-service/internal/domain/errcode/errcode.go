@@ -0,0 +1,77 @@
+package errcode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Code classifies an error for clients and alerting
+type Code string
+
+const (
+	NoCapacity          Code = "NO_CAPACITY"          // No node is free, and providers have none to create
+	AlreadyAllocated    Code = "ALREADY_ALLOCATED"    // The user already holds a node
+	ProviderUnavailable Code = "PROVIDER_UNAVAILABLE" // A node provider call failed
+	InvalidTransition   Code = "INVALID_TRANSITION"   // The node's status does not allow the operation
+	NotFound            Code = "NOT_FOUND"            // The node, user or list does not exist
+	InvalidRequest      Code = "INVALID_REQUEST"      // The request or event is malformed or out of range
+	AccessDenied        Code = "ACCESS_DENIED"        // The user is not allowed a node
+	BudgetExceeded      Code = "BUDGET_EXCEEDED"      // The spend limits block the nodes needed
+	RateLimited         Code = "RATE_LIMITED"         // The user connects more often than their rate limit allows
+	Unauthorized        Code = "UNAUTHORIZED"         // The admin token is missing or wrong
+	NotLeader           Code = "NOT_LEADER"           // The replica is a standby; changes go to the leader
+	Draining            Code = "DRAINING"             // The service is draining and gives out no more nodes
+	SessionLimit        Code = "SESSION_LIMIT"        // The user holds as many concurrent sessions as allowed
+	DryRun              Code = "DRY_RUN"              // The service runs in dry-run mode and provisions or terminates no node
+	Internal            Code = "INTERNAL"             // Any error without a code
+)
+
+// Error is an error carrying a code. Sentinel errors are created with New and
+// matched with errors.Is as before; Of reads the code from anywhere in a chain.
+type Error struct {
+	Code    Code
+	Message string
+	Err     error // Wrapped cause, if any
+}
+
+// New returns an error with a code and message
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Errorf returns an error with a code and a formatted message
+func Errorf(code Code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Wrap gives err a code unless it already carries one
+func Wrap(code Code, err error) error {
+	if err == nil || Of(err) != Internal {
+		return err
+	}
+	return &Error{Code: code, Err: err}
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Err == nil:
+		return e.Message
+	case e.Message == "":
+		return e.Err.Error()
+	default:
+		return e.Message + ": " + e.Err.Error()
+	}
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Of returns the code of the first coded error in err's chain, or Internal
+func Of(err error) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return Internal
+}
@@ -0,0 +1,140 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CloudEventsSpecVersion is the CloudEvents version read and written
+const CloudEventsSpecVersion = "1.0"
+
+// ErrInvalidEnvelope is returned for malformed CloudEvents envelopes
+var ErrInvalidEnvelope = errors.New("invalid cloudevents envelope")
+
+// Envelope is a CloudEvents structured-mode JSON envelope
+type Envelope struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+
+	// ServiceVersion is an extension attribute carrying the producer's build
+	ServiceVersion string `json:"serviceversion,omitempty"`
+}
+
+// CloudEvents configures the envelope put around outbound events
+type CloudEvents struct {
+	Enabled    bool   // Wrap outbound events; inbound envelopes are always detected
+	Source     string // Envelope source attribute
+	TypePrefix string // Prepended to the channel to form the type attribute
+	Version    string // Producer build version; empty leaves it out
+}
+
+// Type returns the CloudEvents type for events of a channel, e.g.
+// <prefix>.user.allocation for user:allocation
+func (c CloudEvents) Type(channel string) string {
+	t := strings.ReplaceAll(channel, ":", ".")
+	if c.TypePrefix == "" {
+		return t
+	}
+	return c.TypePrefix + "." + t
+}
+
+// Encode marshals an outbound event of a channel, wrapping it in an envelope
+// when enabled. Replies sent to a caller's own channel use the type of the
+// channel they stand in for. The producer version goes in the serviceversion
+// attribute of envelopes and the service_version field of plain events.
+func (c CloudEvents) Encode(channel string, event any) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	if !c.Enabled {
+		return withServiceVersion(data, c.Version)
+	}
+
+	return json.Marshal(Envelope{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              uuid.NewString(),
+		Source:          c.Source,
+		Type:            c.Type(channel),
+		Time:            time.Now().UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            data,
+		ServiceVersion:  c.Version,
+	})
+}
+
+// withServiceVersion adds a service_version field to a JSON object
+func withServiceVersion(data []byte, version string) ([]byte, error) {
+	if version == "" || len(data) < 2 || data[0] != '{' {
+		return data, nil
+	}
+
+	field, err := json.Marshal(version)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(data)+len(field)+20)
+	out = append(out, `{"service_version":`...)
+	out = append(out, field...)
+	if rest := data[1:]; !bytes.Equal(rest, []byte("}")) {
+		out = append(out, ',')
+	}
+	return append(out, data[1:]...), nil
+}
+
+// unwrapEnvelope returns the data of a CloudEvents envelope, or the payload
+// itself if it is not one. Envelopes are recognised by their specversion
+// attribute, which no plain event carries.
+func unwrapEnvelope(payload []byte) ([]byte, error) {
+	var probe struct {
+		SpecVersion *string `json:"specversion"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil || probe.SpecVersion == nil {
+		// Plain events get their own strict decoding errors
+		return payload, nil
+	}
+
+	// Extension attributes are allowed, so unknown fields are not rejected
+	var env Envelope
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+	}
+
+	switch {
+	case env.SpecVersion != CloudEventsSpecVersion:
+		return nil, fmt.Errorf("%w: unsupported specversion %q", ErrInvalidEnvelope, env.SpecVersion)
+	case env.ID == "":
+		return nil, fmt.Errorf("%w: missing id", ErrInvalidEnvelope)
+	case env.Source == "":
+		return nil, fmt.Errorf("%w: missing source", ErrInvalidEnvelope)
+	case env.Type == "":
+		return nil, fmt.Errorf("%w: missing type", ErrInvalidEnvelope)
+	case env.DataBase64 != "":
+		return nil, fmt.Errorf("%w: data_base64 is not supported", ErrInvalidEnvelope)
+	case env.DataContentType != "" && !isJSONContentType(env.DataContentType):
+		return nil, fmt.Errorf("%w: unsupported datacontenttype %q", ErrInvalidEnvelope, env.DataContentType)
+	case len(bytes.TrimSpace(env.Data)) == 0 || bytes.Equal(env.Data, []byte("null")):
+		return nil, fmt.Errorf("%w: missing data", ErrInvalidEnvelope)
+	}
+
+	return env.Data, nil
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,257 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUnknownChannel is returned by Dispatch for channels without a decoder
+var ErrUnknownChannel = errors.New("unknown channel")
+
+// Handler handles decoded inbound events
+type Handler interface {
+	HandleUserActivity(ctx context.Context, event UserActivityEvent) error
+	HandleUserActivityBatch(ctx context.Context, event UserActivityBatchEvent) error
+	HandleUserConnect(ctx context.Context, event UserConnectEvent) error
+	HandleUserDisconnect(ctx context.Context, event UserDisconnectEvent) error
+	HandleNodeStatus(ctx context.Context, event NodeStatusEvent) error
+	HandleUserMigrateAck(ctx context.Context, event UserMigrateAckEvent) error
+	HandleUserConfirm(ctx context.Context, event UserConfirmEvent) error
+	HandleNodeUtilization(ctx context.Context, event NodeUtilizationEvent) error
+}
+
+// DecodeError wraps a payload that failed decoding or validation, so
+// transports can route it to their dead-letter destination
+type DecodeError struct {
+	Channel string
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("invalid %s event: %v", e.Channel, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Dispatch decodes a payload received on channel and passes it to the
+// matching handler method. Decoding failures are returned as *DecodeError.
+func Dispatch(ctx context.Context, h Handler, channel string, payload []byte) error {
+	switch channel {
+	case ChannelUserActivity:
+		var event UserActivityEvent
+		if err := Decode(payload, &event); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		if err := bindTenant(ctx, &event.TenantID); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		return h.HandleUserActivity(ctx, event)
+
+	case ChannelUserActivityBatch:
+		var event UserActivityBatchEvent
+		if err := Decode(payload, &event); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		for i := range event.Activities {
+			if err := bindTenant(ctx, &event.Activities[i].TenantID); err != nil {
+				return &DecodeError{Channel: channel, Err: err}
+			}
+		}
+		return h.HandleUserActivityBatch(ctx, event)
+
+	case ChannelUserConnect:
+		var event UserConnectEvent
+		if err := Decode(payload, &event); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		if err := bindTenant(ctx, &event.TenantID); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		if err := checkReplyChannel(ctx, event.ReplyChannel, event.TenantID); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		return h.HandleUserConnect(ctx, event)
+
+	case ChannelUserDisconnect:
+		var event UserDisconnectEvent
+		if err := Decode(payload, &event); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		if err := bindTenant(ctx, &event.TenantID); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		return h.HandleUserDisconnect(ctx, event)
+
+	case ChannelNodeStatus:
+		var event NodeStatusEvent
+		if err := Decode(payload, &event); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		if err := rejectTenant(ctx); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		return h.HandleNodeStatus(ctx, event)
+
+	case ChannelUserMigrateAck:
+		var event UserMigrateAckEvent
+		if err := Decode(payload, &event); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		if err := bindTenant(ctx, &event.TenantID); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		return h.HandleUserMigrateAck(ctx, event)
+
+	case ChannelUserConfirm:
+		var event UserConfirmEvent
+		if err := Decode(payload, &event); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		if err := bindTenant(ctx, &event.TenantID); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		return h.HandleUserConfirm(ctx, event)
+
+	case ChannelNodeUtilization:
+		var event NodeUtilizationEvent
+		if err := Decode(payload, &event); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		if err := rejectTenant(ctx); err != nil {
+			return &DecodeError{Channel: channel, Err: err}
+		}
+		return h.HandleNodeUtilization(ctx, event)
+
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownChannel, channel)
+	}
+}
+
+// bindTenant makes an event received on a tenant's channel belong to that
+// tenant, as if it carried tenant_id; one naming another tenant is rejected
+func bindTenant(ctx context.Context, tenantID *string) error {
+	channelTenant := TenantFrom(ctx)
+	if channelTenant == "" {
+		return nil
+	}
+	if *tenantID != "" && *tenantID != channelTenant {
+		return fmt.Errorf("tenant_id %q does not match the channel's tenant %q", *tenantID, channelTenant)
+	}
+	*tenantID = channelTenant
+	return nil
+}
+
+// checkReplyChannel refuses a reply channel the allocation result, auth
+// token included, must not be published on: one of the service's own
+// channels, or with tenant channels enabled, a name outside the connecting
+// tenant's namespace
+func checkReplyChannel(ctx context.Context, reply, tenantID string) error {
+	if reply == "" {
+		return nil
+	}
+	n := NamespaceFrom(ctx)
+	if channel, _, ok := n.Parse(reply, append(InboundChannels(), OutboundChannels()...)); ok {
+		return fmt.Errorf("reply_channel %q is the service's %s channel", reply, channel)
+	}
+	if n.TenantPattern != "" && tenantID != "" && !n.Within(reply, tenantID) {
+		return fmt.Errorf("reply_channel %q is outside the channels of tenant %q", reply, tenantID)
+	}
+	return nil
+}
+
+// rejectTenant refuses node events received on a tenant's channel: nodes
+// are shared by every tenant, so no tenant may report on them
+func rejectTenant(ctx context.Context) error {
+	if tenantID := TenantFrom(ctx); tenantID != "" {
+		return fmt.Errorf("node events are not accepted on the channels of tenant %q", tenantID)
+	}
+	return nil
+}
+
+// Outcomes of an inbound message reported to an Observer
+const (
+	OutcomeHandled        = "handled"         // The handler succeeded
+	OutcomeInvalid        = "invalid"         // The payload failed decoding or validation
+	OutcomeUnknownChannel = "unknown_channel" // No decoder exists for the channel
+	OutcomeFailed         = "failed"          // The handler returned an error
+)
+
+// Observer is notified of every inbound message a subscriber dispatches
+type Observer interface {
+	ObserveMessage(channel, outcome string, d time.Duration)
+}
+
+// ObservedDispatch dispatches a payload like Dispatch and reports the
+// outcome and handling time to observer
+func ObservedDispatch(ctx context.Context, observer Observer, h Handler, channel string, payload []byte) error {
+	start := time.Now()
+	err := Dispatch(ctx, h, channel, payload)
+	observer.ObserveMessage(channel, Outcome(err), time.Since(start))
+	return err
+}
+
+// Outcome classifies an error returned by Dispatch
+func Outcome(err error) string {
+	var decodeErr *DecodeError
+	switch {
+	case err == nil:
+		return OutcomeHandled
+	case errors.As(err, &decodeErr):
+		return OutcomeInvalid
+	case errors.Is(err, ErrUnknownChannel):
+		return OutcomeUnknownChannel
+	default:
+		return OutcomeFailed
+	}
+}
+
+// ActivityChannel returns the channel an activity payload belongs on:
+// ChannelUserActivityBatch when it carries an activities list, and
+// ChannelUserActivity otherwise
+func ActivityChannel(payload []byte) string {
+	var probe struct {
+		Activities json.RawMessage `json:"activities"`
+	}
+	data, err := unwrapEnvelope(payload)
+	if err == nil && json.Unmarshal(data, &probe) == nil && probe.Activities != nil {
+		return ChannelUserActivityBatch
+	}
+	return ChannelUserActivity
+}
+
+// InboundChannels lists the channels the service consumes
+func InboundChannels() []string {
+	return []string{
+		ChannelUserActivity,
+		ChannelUserActivityBatch,
+		ChannelUserConnect,
+		ChannelUserDisconnect,
+		ChannelNodeStatus,
+		ChannelUserMigrateAck,
+		ChannelUserConfirm,
+		ChannelNodeUtilization,
+	}
+}
+
+// OutboundChannels lists the channels the service publishes on, besides
+// the reply channels connects name
+func OutboundChannels() []string {
+	return []string{
+		ChannelAllocationResult,
+		ChannelAllocationFailed,
+		ChannelNodeReady,
+		ChannelUserMigrate,
+		ChannelUserIdleWarning,
+		ChannelInstanceRecommendation,
+		ChannelUserThrottled,
+		ChannelBudgetAlert,
+		ChannelBootFailureAlert,
+		ChannelNodeTerminated,
+		ChannelNodeArchived,
+	}
+}
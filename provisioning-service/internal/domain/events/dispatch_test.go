@@ -0,0 +1,150 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// recordingHandler keeps the tenant of the last event handled
+type recordingHandler struct {
+	tenantID string
+	handled  int
+}
+
+func (h *recordingHandler) handle(tenantID string) error {
+	h.tenantID = tenantID
+	h.handled++
+	return nil
+}
+
+func (h *recordingHandler) HandleUserActivity(ctx context.Context, event UserActivityEvent) error {
+	return h.handle(event.TenantID)
+}
+
+func (h *recordingHandler) HandleUserActivityBatch(ctx context.Context, event UserActivityBatchEvent) error {
+	return h.handle(event.Activities[0].TenantID)
+}
+
+func (h *recordingHandler) HandleUserConnect(ctx context.Context, event UserConnectEvent) error {
+	return h.handle(event.TenantID)
+}
+
+func (h *recordingHandler) HandleUserDisconnect(ctx context.Context, event UserDisconnectEvent) error {
+	return h.handle(event.TenantID)
+}
+
+func (h *recordingHandler) HandleNodeStatus(ctx context.Context, event NodeStatusEvent) error {
+	return h.handle("")
+}
+
+func (h *recordingHandler) HandleUserMigrateAck(ctx context.Context, event UserMigrateAckEvent) error {
+	return h.handle(event.TenantID)
+}
+
+func (h *recordingHandler) HandleUserConfirm(ctx context.Context, event UserConfirmEvent) error {
+	return h.handle(event.TenantID)
+}
+
+func (h *recordingHandler) HandleNodeUtilization(ctx context.Context, event NodeUtilizationEvent) error {
+	return h.handle("")
+}
+
+func TestDispatchBindsTenant(t *testing.T) {
+	userEvents := map[string]string{
+		ChannelUserActivity:      `{"user_id":"u1","timestamp":1700000000}`,
+		ChannelUserActivityBatch: `{"activities":[{"user_id":"u1","timestamp":1700000000}]}`,
+		ChannelUserConnect:       `{"user_id":"u1"}`,
+		ChannelUserDisconnect:    `{"user_id":"u1"}`,
+		ChannelUserMigrateAck:    `{"migration_id":"m1","user_id":"u1","status":"completed"}`,
+		ChannelUserConfirm:       `{"user_id":"u1","node_id":"n1"}`,
+	}
+	otherTenant := map[string]string{
+		ChannelUserActivity:      `{"user_id":"u1","timestamp":1700000000,"tenant_id":"globex"}`,
+		ChannelUserActivityBatch: `{"activities":[{"user_id":"u1","timestamp":1700000000,"tenant_id":"globex"}]}`,
+		ChannelUserConnect:       `{"user_id":"u1","tenant_id":"globex"}`,
+		ChannelUserDisconnect:    `{"user_id":"u1","tenant_id":"globex"}`,
+		ChannelUserMigrateAck:    `{"migration_id":"m1","user_id":"u1","status":"completed","tenant_id":"globex"}`,
+		ChannelUserConfirm:       `{"user_id":"u1","node_id":"n1","tenant_id":"globex"}`,
+	}
+	ctx := WithTenant(context.Background(), "acme")
+
+	for channel, payload := range userEvents {
+		t.Run(channel, func(t *testing.T) {
+			h := &recordingHandler{}
+			if err := Dispatch(ctx, h, channel, []byte(payload)); err != nil {
+				t.Fatalf("Dispatch: %v", err)
+			}
+			if h.tenantID != "acme" {
+				t.Errorf("event on acme's channel has tenant %q", h.tenantID)
+			}
+
+			h = &recordingHandler{}
+			var decodeErr *DecodeError
+			if err := Dispatch(ctx, h, channel, []byte(otherTenant[channel])); !errors.As(err, &decodeErr) || h.handled != 0 {
+				t.Errorf("event naming another tenant: err=%v handled=%d", err, h.handled)
+			}
+
+			h = &recordingHandler{}
+			if err := Dispatch(context.Background(), h, channel, []byte(payload)); err != nil || h.tenantID != "" {
+				t.Errorf("event on a plain channel: err=%v tenant=%q", err, h.tenantID)
+			}
+		})
+	}
+}
+
+func TestDispatchRejectsNodeEventsOnTenantChannels(t *testing.T) {
+	nodeEvents := map[string]string{
+		ChannelNodeStatus:      `{"node_id":"n1","status":"ready"}`,
+		ChannelNodeUtilization: `{"node_id":"n1","gpu_percent":50}`,
+	}
+	for channel, payload := range nodeEvents {
+		t.Run(channel, func(t *testing.T) {
+			h := &recordingHandler{}
+			var decodeErr *DecodeError
+			if err := Dispatch(WithTenant(context.Background(), "acme"), h, channel, []byte(payload)); !errors.As(err, &decodeErr) || h.handled != 0 {
+				t.Errorf("node event on a tenant's channel: err=%v handled=%d", err, h.handled)
+			}
+			if err := Dispatch(context.Background(), h, channel, []byte(payload)); err != nil || h.handled != 1 {
+				t.Errorf("node event on a plain channel: err=%v handled=%d", err, h.handled)
+			}
+		})
+	}
+}
+
+func TestDispatchChecksReplyChannel(t *testing.T) {
+	namespace, err := NewNamespace("staging:", "{tenant}:{channel}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		tenant string
+		reply  string
+		ok     bool
+	}{
+		{"own reply channel", "", "replies:u1", true},
+		{"service channel", "", "staging:user:connect", false},
+		{"outbound service channel", "", "staging:user:allocation", false},
+		{"tenant service channel", "", "staging:acme:user:disconnect", false},
+		{"within the tenant", "acme", "staging:acme:replies:u1", true},
+		{"another tenant", "acme", "staging:globex:replies:u1", false},
+		{"outside every tenant", "acme", "replies:u1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := WithNamespace(WithTenant(context.Background(), tt.tenant), namespace)
+			h := &recordingHandler{}
+			err := Dispatch(ctx, h, ChannelUserConnect, []byte(`{"user_id":"u1","reply_channel":"`+tt.reply+`"}`))
+
+			var decodeErr *DecodeError
+			if tt.ok && err != nil {
+				t.Errorf("Dispatch: %v", err)
+			}
+			if !tt.ok && (!errors.As(err, &decodeErr) || h.handled != 0) {
+				t.Errorf("reply_channel %q accepted: %v", tt.reply, err)
+			}
+		})
+	}
+}
@@ -6,26 +6,388 @@ const (
 	ChannelUserConnect    = "user:connect"
 	ChannelUserDisconnect = "user:disconnect"
 	ChannelNodeStatus     = "node:status"
+
+	// ChannelUserActivityBatch carries many activity records in one message
+	ChannelUserActivityBatch = "user:activity:batch"
+
+	// ChannelAllocationResult is the default reply channel for connect requests
+	// that carry a correlation ID but no explicit reply channel
+	ChannelAllocationResult = "user:allocation"
+
+	// ChannelAllocationFailed carries structured failures for every connect
+	// that could not be served
+	ChannelAllocationFailed = "user:allocation_failed"
+
+	// ChannelNodeReady tells a waiting or predicted user that a node is held for them
+	ChannelNodeReady = "user:node_ready"
+
+	// ChannelUserMigrate tells a client to move a user's session to another node
+	ChannelUserMigrate = "user:migrate"
+
+	// ChannelUserMigrateAck carries the client's reply to a migration
+	ChannelUserMigrateAck = "user:migrate_ack"
+
+	// ChannelUserConfirm carries a client's confirmation that a user attached
+	// to the node reserved for them
+	ChannelUserConfirm = "user:confirm"
+
+	// ChannelNodeUtilization carries resource usage samples reported by nodes
+	ChannelNodeUtilization = "node:utilization"
+
+	// ChannelUserIdleWarning warns a connected user that their idle node
+	// will be reclaimed
+	ChannelUserIdleWarning = "user:idle_warning"
+
+	// ChannelInstanceRecommendation suggests a different instance type for a
+	// user's next allocation
+	ChannelInstanceRecommendation = "user:instance_recommendation"
+
+	// ChannelUserThrottled tells a client that a user's connects are being
+	// refused for going over their rate limit
+	ChannelUserThrottled = "user:throttled"
+
+	// ChannelBudgetAlert carries alerts for scale-ups blocked by the spend limits
+	ChannelBudgetAlert = "provisioning:budget_alert"
+
+	// ChannelBootFailureAlert carries alerts for nodes that failed to boot,
+	// with the diagnostics their provider reported
+	ChannelBootFailureAlert = "provisioning:boot_failure_alert"
+
+	// ChannelNodeTerminated records every node termination and its reason
+	ChannelNodeTerminated = "provisioning:node_terminated"
+
+	// ChannelNodeArchived carries the final record of every terminated node
+	// purged from the pool, for audit stores to keep
+	ChannelNodeArchived = "provisioning:node_archived"
 )
 
+// Allocation result statuses
+const (
+	AllocationStatusAllocated        = "allocated"
+	AllocationStatusAlreadyAllocated = "already_allocated"
+	AllocationStatusFailed           = "failed"
+
+	// Published on ChannelAllocationResult when an operator moves or tears
+	// down an allocation
+	AllocationStatusDeallocated = "deallocated"
+	AllocationStatusReassigned  = "reassigned"
+
+	// Published on ChannelAllocationResult when a migration completes
+	AllocationStatusMigrated = "migrated"
+
+	// Replied to a connect when the node is held until the user confirms
+	// attaching, and published on ChannelAllocationResult when the hold lapses
+	AllocationStatusUnconfirmed = "unconfirmed"
+	AllocationStatusExpired     = "expired"
+
+	// Published on ChannelAllocationResult when an idle user's node is reclaimed
+	AllocationStatusReclaimed = "reclaimed"
+)
+
+// Activity types with default weights; other types are accepted and weigh
+// what prediction.activity_weights gives them
+const (
+	ActivityTypePageView   = "page_view"
+	ActivityTypeEditorOpen = "editor_open"
+	ActivityTypeJobSubmit  = "job_submit"
+	ActivityTypeHeartbeat  = "heartbeat"
+)
+
+// MaxActivityTypeLength bounds an activity type
+const MaxActivityTypeLength = 64
+
 // UserActivityEvent represents a user activity message
 type UserActivityEvent struct {
-	UserID    string `json:"user_id"`
-	Timestamp int64  `json:"timestamp"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	UserID        string `json:"user_id"`
+	Timestamp     int64  `json:"timestamp"`
+	Type          string `json:"type,omitempty"`      // What the user did, weighting the activity as a connect signal
+	TenantID      string `json:"tenant_id,omitempty"` // Tenant the user belongs to; set from the channel on a tenant's channels
+}
+
+// MaxActivityBatch bounds the activity records in one batch
+const MaxActivityBatch = 1000
+
+// UserActivityBatchEvent carries many user activity records at once
+type UserActivityBatchEvent struct {
+	SchemaVersion int                 `json:"schema_version,omitempty"`
+	Activities    []UserActivityEvent `json:"activities"`
 }
 
 // UserConnectEvent represents a user connect message
 type UserConnectEvent struct {
-	UserID string `json:"user_id"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	UserID        string `json:"user_id"`
+	ReplyChannel  string `json:"reply_channel,omitempty"`  // Channel to publish the allocation result on
+	CorrelationID string `json:"correlation_id,omitempty"` // Echoed back in the allocation result
+	TenantID      string `json:"tenant_id,omitempty"`      // Tenant the user belongs to, for the operations feed
+	Tier          string `json:"tier,omitempty"`           // Service tier bounding how long the user waits for a node
+	SessionID     string `json:"session_id,omitempty"`     // Device session; sessions of one user share their node
+
+	// Selector limits allocation to nodes with these labels, e.g. {"region": "eu"}
+	Selector map[string]string `json:"selector,omitempty"`
+}
+
+// AllocationResultEvent is published in reply to a user connect request
+type AllocationResultEvent struct {
+	SchemaVersion  int    `json:"schema_version"`
+	CorrelationID  string `json:"correlation_id,omitempty"`
+	UserID         string `json:"user_id"`
+	NodeID         string `json:"node_id,omitempty"`
+	PreviousNodeID string `json:"previous_node_id,omitempty"` // Set when the user is deallocated, reassigned or migrated
+	Address        string `json:"address,omitempty"`
+	Hostname       string `json:"hostname,omitempty"`
+	Port           int    `json:"port,omitempty"`
+	AuthToken      string `json:"auth_token,omitempty"`
+	Status         string `json:"status"`                   // allocated|unconfirmed|already_allocated|failed|deallocated|reassigned|migrated|expired|reclaimed
+	Reason         string `json:"reason,omitempty"`         // Failure reason when status is failed
+	Code           string `json:"code,omitempty"`           // Error code when status is failed, e.g. NO_CAPACITY
+	ReservedUntil  int64  `json:"reserved_until,omitempty"` // Confirm on ChannelUserConfirm before this when status is unconfirmed
+}
+
+// Allocation failure reasons
+const (
+	FailureNoReadyNode        = "no_ready_node"       // A node is being provisioned; retry shortly
+	FailureProvisioningFailed = "provisioning_failed" // No node is ready and emergency provisioning failed
+	FailureAllocationError    = "allocation_error"    // Transient allocation error; retry immediately
+	FailureBudgetExceeded     = "budget_exceeded"     // No node is ready and the spend limits block provisioning one
+	FailureAccessDenied       = "access_denied"       // The user is not allowed a node; do not retry
+	FailureRateLimited        = "rate_limited"        // The user connects too often; retry after the given time
+	FailureDraining           = "draining"            // The service is draining; connect to its replacement
+	FailureSessionLimit       = "session_limit"       // The user holds as many sessions as allowed; close one first
+)
+
+// AllocationFailedEvent is published when a user connect cannot be served
+type AllocationFailedEvent struct {
+	SchemaVersion     int    `json:"schema_version"`
+	CorrelationID     string `json:"correlation_id,omitempty"`
+	UserID            string `json:"user_id"`
+	Reason            string `json:"reason"`  // no_ready_node|provisioning_failed|allocation_error|budget_exceeded|access_denied|rate_limited|draining|session_limit
+	Message           string `json:"message"` // Human-readable error
+	Code              string `json:"code"`    // Error code, e.g. NO_CAPACITY or ACCESS_DENIED
+	RetryAfterSeconds int    `json:"retry_after_seconds"`
+	NodesBooting      int    `json:"nodes_booting"`
+	Timestamp         int64  `json:"timestamp"`
+}
+
+// UserThrottledEvent is published when a user first goes over their rate
+// limit, and again each time they do after coming back within it
+type UserThrottledEvent struct {
+	SchemaVersion     int     `json:"schema_version"`
+	UserID            string  `json:"user_id"`
+	Event             string  `json:"event"`               // connect|disconnect
+	RetryAfterSeconds int     `json:"retry_after_seconds"` // Until the next connect is accepted
+	PerMinute         float64 `json:"per_minute"`
+	Burst             int     `json:"burst"`
+	Timestamp         int64   `json:"timestamp"`
+}
+
+// BudgetAlertEvent is published when the spend limits first block
+// scale-ups of an instance type
+type BudgetAlertEvent struct {
+	SchemaVersion int     `json:"schema_version"`
+	InstanceType  string  `json:"instance_type,omitempty"`
+	Limit         string  `json:"limit"` // hourly|daily
+	Requested     int     `json:"requested"`
+	Allowed       int     `json:"allowed"`
+	HourlyRunRate float64 `json:"hourly_run_rate"`
+	SpentToday    float64 `json:"spent_today"`
+	MaxHourly     float64 `json:"max_hourly,omitempty"`
+	MaxDaily      float64 `json:"max_daily,omitempty"`
+	Timestamp     int64   `json:"timestamp"`
+}
+
+// BootFailureAlertEvent is published for every node terminated without
+// becoming ready
+type BootFailureAlertEvent struct {
+	SchemaVersion       int     `json:"schema_version"`
+	NodeID              string  `json:"node_id"`
+	InstanceType        string  `json:"instance_type,omitempty"`
+	Provider            string  `json:"provider,omitempty"`
+	Reason              string  `json:"reason"` // stuck booting|failed pre-ready checks
+	Attempt             int     `json:"attempt"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	BackoffSeconds      float64 `json:"backoff_seconds,omitempty"` // Provisioning backoff the failure triggered
+	ProviderStatus      string  `json:"provider_status,omitempty"`
+	StatusMessage       string  `json:"status_message,omitempty"`
+	ConsoleOutput       string  `json:"console_output,omitempty"` // Tail of the node's console output
+	Timestamp           int64   `json:"timestamp"`
+}
+
+// NodeTerminatedEvent records why a node was terminated
+type NodeTerminatedEvent struct {
+	SchemaVersion  int      `json:"schema_version"`
+	NodeID         string   `json:"node_id"`
+	Reason         string   `json:"reason"`          // idle|scale_down|stuck|failed_checks|incompatible|rotated|admin|idle_reclaim|migrated|interruption|drift|vanished|decommission|import
+	PreviousStatus string   `json:"previous_status"` // Status before termination began
+	InstanceType   string   `json:"instance_type,omitempty"`
+	Provider       string   `json:"provider,omitempty"`
+	UserIDs        []string `json:"user_ids,omitempty"` // Users still on the node, if it was force-terminated or interrupted
+	AgeSeconds     float64  `json:"age_seconds"`
+	Timestamp      int64    `json:"timestamp"`
+}
+
+// NodeArchivedEvent is the final record of a terminated node purged from
+// the pool
+type NodeArchivedEvent struct {
+	SchemaVersion     int               `json:"schema_version"`
+	NodeID            string            `json:"node_id"`
+	InstanceType      string            `json:"instance_type,omitempty"`
+	PoolType          string            `json:"pool_type,omitempty"`
+	Provider          string            `json:"provider,omitempty"`
+	Zone              string            `json:"zone,omitempty"`
+	Purchase          string            `json:"purchase,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	AgentVersion      string            `json:"agent_version,omitempty"`
+	BootAttempt       int               `json:"boot_attempt,omitempty"`
+	TerminationReason string            `json:"termination_reason,omitempty"`
+	CreatedAt         int64             `json:"created_at"`              // Unix seconds
+	TerminatedAt      int64             `json:"terminated_at,omitempty"` // Unix seconds
+	Timestamp         int64             `json:"timestamp"`
+}
+
+// Node ready reasons
+const (
+	NodeReadyReasonQueued    = "queued"    // The user's connect found no ready node
+	NodeReadyReasonPredicted = "predicted" // The user is predicted to connect
+)
+
+// NodeReadyEvent is published when a node is reserved for a user
+type NodeReadyEvent struct {
+	SchemaVersion int    `json:"schema_version"`
+	UserID        string `json:"user_id"`
+	NodeID        string `json:"node_id"`
+	Address       string `json:"address,omitempty"`
+	Hostname      string `json:"hostname,omitempty"`
+	Port          int    `json:"port,omitempty"`
+	AuthToken     string `json:"auth_token,omitempty"`
+	Reason        string `json:"reason"`         // queued|predicted
+	ReservedUntil int64  `json:"reserved_until"` // Connect before this to get the node
+	Timestamp     int64  `json:"timestamp"`
+}
+
+// Migration reasons
+const (
+	MigrationReasonOperator = "operator" // An operator asked for the user to be moved
+	MigrationReasonDrain    = "drain"    // The user's node is draining or being rotated
+)
+
+// Migration acknowledgment statuses
+const (
+	MigrationStatusCompleted = "completed" // The session moved to the new node
+	MigrationStatusFailed    = "failed"    // The session stays on the old node
+)
+
+// MigrationEndpoint is one end of a migration
+type MigrationEndpoint struct {
+	NodeID    string `json:"node_id"`
+	Address   string `json:"address,omitempty"`
+	Hostname  string `json:"hostname,omitempty"`
+	Port      int    `json:"port,omitempty"`
+	AuthToken string `json:"auth_token,omitempty"` // Only sent for the new node
+}
+
+// UserMigrateEvent asks a client to move a user's session from one node to
+// another and acknowledge it on ChannelUserMigrateAck
+type UserMigrateEvent struct {
+	SchemaVersion int               `json:"schema_version"`
+	MigrationID   string            `json:"migration_id"`
+	UserID        string            `json:"user_id"`
+	From          MigrationEndpoint `json:"from"`
+	To            MigrationEndpoint `json:"to"`
+	Reason        string            `json:"reason"`   // operator|drain
+	Deadline      int64             `json:"deadline"` // The old node is released at this time without an acknowledgment
+	Timestamp     int64             `json:"timestamp"`
+}
+
+// UserMigrateAckEvent is a client's reply to a UserMigrateEvent
+type UserMigrateAckEvent struct {
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	MigrationID   string `json:"migration_id"`
+	UserID        string `json:"user_id"`
+	Status        string `json:"status"`              // completed|failed
+	Reason        string `json:"reason,omitempty"`    // Why a migration failed
+	TenantID      string `json:"tenant_id,omitempty"` // Tenant the user belongs to; set from the channel on a tenant's channels
+}
+
+// UserConfirmEvent confirms that a user attached to the node a connect
+// reserved for them, completing the allocation
+type UserConfirmEvent struct {
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	UserID        string `json:"user_id"`
+	NodeID        string `json:"node_id"`
+	TenantID      string `json:"tenant_id,omitempty"` // Tenant the user belongs to; set from the channel on a tenant's channels
+}
+
+// NodeUtilizationEvent is a resource usage sample reported by a node
+type NodeUtilizationEvent struct {
+	SchemaVersion int     `json:"schema_version,omitempty"`
+	NodeID        string  `json:"node_id"`
+	GPUPercent    float64 `json:"gpu_percent"`              // GPU utilization, 0-100
+	MemoryPercent float64 `json:"memory_percent,omitempty"` // GPU memory in use, 0-100
+	Timestamp     int64   `json:"timestamp,omitempty"`      // When the sample was taken; defaults to receipt
+}
+
+// IdleWarningEvent warns a connected user that their node has been idle
+// and will be reclaimed unless it is used again
+type IdleWarningEvent struct {
+	SchemaVersion int     `json:"schema_version"`
+	UserID        string  `json:"user_id"`
+	NodeID        string  `json:"node_id"`
+	Warning       int     `json:"warning"`      // 1 for the first warning
+	Warnings      int     `json:"warnings"`     // Warnings sent before the node is reclaimed
+	IdleSeconds   int64   `json:"idle_seconds"` // Time since the node was last busy
+	GPUPercent    float64 `json:"gpu_percent"`  // Latest utilization sample
+	ReclaimAt     int64   `json:"reclaim_at"`   // The node is reclaimed at this time if still idle
+	Timestamp     int64   `json:"timestamp"`
+}
+
+// InstanceRecommendationEvent carries the instance type recommended for a
+// user's next allocation whenever the recommendation changes
+type InstanceRecommendationEvent struct {
+	SchemaVersion int     `json:"schema_version"`
+	UserID        string  `json:"user_id"`
+	InstanceType  string  `json:"instance_type"`       // Of the node the user was last sampled on
+	Recommended   string  `json:"recommended"`         // For the next allocation
+	Direction     string  `json:"direction,omitempty"` // keep|smaller|larger
+	Reason        string  `json:"reason"`              // Why the type was recommended
+	AutoSelect    bool    `json:"auto_select"`         // Whether the next allocation prefers it
+	AverageGPU    float64 `json:"average_gpu_percent"` // Over the rightsizing window
+	PeakMemory    float64 `json:"peak_memory_percent"` // Over the rightsizing window
+	Timestamp     int64   `json:"timestamp"`
 }
 
 // UserDisconnectEvent represents a user disconnect message
 type UserDisconnectEvent struct {
-	UserID string `json:"user_id"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	UserID        string `json:"user_id"`
+	SessionID     string `json:"session_id,omitempty"` // Session ending; empty ends every session of the user
+	TenantID      string `json:"tenant_id,omitempty"`  // Tenant the user belongs to; set from the channel on a tenant's channels
 }
 
 // NodeStatusEvent represents a node status change message
 type NodeStatusEvent struct {
-	NodeID string `json:"node_id"`
-	Status string `json:"status"` // booting|ready|terminated
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	NodeID        string `json:"node_id"`
+	Status        string `json:"status,omitempty"`        // booting|ready|terminated; empty only updates the fields set of a known node
+	AgentVersion  string `json:"agent_version,omitempty"` // Version of the node agent, if reported
+	InstanceType  string `json:"instance_type,omitempty"` // Instance type the node runs on, if reported
+	Capacity      int    `json:"capacity,omitempty"`      // Users the node hosts at once, in place of its instance type's policy
+	Address       string `json:"address,omitempty"`       // IP address the node is reachable on
+	Hostname      string `json:"hostname,omitempty"`      // DNS name of the node, if assigned
+	Port          int    `json:"port,omitempty"`          // Port the node agent listens on
+	AuthToken     string `json:"auth_token,omitempty"`    // Token users present when connecting
+
+	// Labels replace the node's labels when set, e.g. {"region": "eu", "driver": "535"}
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// HasFields reports whether the event carries anything besides the status
+func (e NodeStatusEvent) HasFields() bool {
+	return e.AgentVersion != "" || e.InstanceType != "" || e.Capacity != 0 || len(e.Labels) > 0 || e.HasEndpoint()
+}
+
+// HasEndpoint reports whether the event carries connection details
+func (e NodeStatusEvent) HasEndpoint() bool {
+	return e.Address != "" || e.Hostname != "" || e.Port != 0 || e.AuthToken != ""
 }
@@ -0,0 +1,152 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Placeholders of a tenant channel pattern
+const (
+	PlaceholderTenant  = "{tenant}"
+	PlaceholderChannel = "{channel}"
+)
+
+// Namespace maps channels to their names on the transport: every channel
+// under a static prefix, so environments can share a server, and the
+// channels of a tenant's users through a pattern naming the tenant, e.g.
+// "{tenant}:{channel}" for acme:user:connect. The zero value leaves
+// channel names as they are.
+type Namespace struct {
+	Prefix        string
+	TenantPattern string // Holds {tenant} and {channel} once each; empty disables tenant channels
+
+	tenantRe *regexp.Regexp
+}
+
+// NewNamespace validates a tenant pattern and returns the namespace
+func NewNamespace(prefix, tenantPattern string) (Namespace, error) {
+	n := Namespace{Prefix: prefix, TenantPattern: tenantPattern}
+	if tenantPattern == "" {
+		return n, nil
+	}
+	if strings.Count(tenantPattern, PlaceholderTenant) != 1 || strings.Count(tenantPattern, PlaceholderChannel) != 1 {
+		return n, fmt.Errorf("tenant channel pattern %q must hold %s and %s once each", tenantPattern, PlaceholderTenant, PlaceholderChannel)
+	}
+
+	// The channel is matched only as one of the service's own, so a tenant
+	// holding the separator is still told apart from it
+	var channels []string
+	for _, channel := range append(InboundChannels(), OutboundChannels()...) {
+		channels = append(channels, regexp.QuoteMeta(channel))
+	}
+	expr := regexp.QuoteMeta(prefix + tenantPattern)
+	expr = strings.Replace(expr, regexp.QuoteMeta(PlaceholderTenant), `(?P<tenant>.+)`, 1)
+	expr = strings.Replace(expr, regexp.QuoteMeta(PlaceholderChannel), `(?P<channel>`+strings.Join(channels, "|")+`)`, 1)
+	n.tenantRe = regexp.MustCompile(`\A` + expr + `\z`)
+	return n, nil
+}
+
+// Name returns the name a channel has on the transport, for a tenant's
+// users when tenantID is set and tenant channels are enabled
+func (n Namespace) Name(channel, tenantID string) string {
+	if tenantID == "" || n.TenantPattern == "" {
+		return n.Prefix + channel
+	}
+	name := strings.Replace(n.TenantPattern, PlaceholderTenant, tenantID, 1)
+	return n.Prefix + strings.Replace(name, PlaceholderChannel, channel, 1)
+}
+
+// Tenant returns the tenant the transport name of a channel carries:
+// tenantID for the service's own channels when tenant channels are
+// enabled, otherwise none
+func (n Namespace) Tenant(channel, tenantID string) string {
+	if n.TenantPattern == "" || !n.Named(channel) {
+		return ""
+	}
+	return tenantID
+}
+
+// Named reports whether a channel is one of the service's own, which are
+// named through the namespace; reply channels named by clients are not
+func (n Namespace) Named(channel string) bool {
+	return slices.Contains(OutboundChannels(), channel) || slices.Contains(InboundChannels(), channel)
+}
+
+// Within reports whether a transport name falls within a tenant's
+// namespace: the tenant pattern naming the tenant, with any channel
+func (n Namespace) Within(name, tenantID string) bool {
+	pattern := strings.Replace(n.TenantPattern, PlaceholderTenant, tenantID, 1)
+	before, after, _ := strings.Cut(pattern, PlaceholderChannel)
+	before = n.Prefix + before
+	return len(name) > len(before)+len(after) && strings.HasPrefix(name, before) && strings.HasSuffix(name, after)
+}
+
+// Subscriptions returns the channel names to subscribe to for channels,
+// and the glob patterns matching them on every tenant's channels
+func (n Namespace) Subscriptions(channels []string) (names, patterns []string) {
+	for _, channel := range channels {
+		names = append(names, n.Prefix+channel)
+		if n.TenantPattern != "" {
+			pattern := strings.Replace(globEscape(n.TenantPattern), globEscape(PlaceholderTenant), "*", 1)
+			pattern = strings.Replace(pattern, globEscape(PlaceholderChannel), globEscape(channel), 1)
+			patterns = append(patterns, globEscape(n.Prefix)+pattern)
+		}
+	}
+	return names, patterns
+}
+
+// Parse returns the channel a transport name stands for among channels,
+// and the tenant it names; false if it stands for none of them
+func (n Namespace) Parse(name string, channels []string) (channel, tenantID string, ok bool) {
+	if channel, ok := strings.CutPrefix(name, n.Prefix); ok && slices.Contains(channels, channel) {
+		return channel, "", true
+	}
+	if n.tenantRe == nil {
+		return "", "", false
+	}
+	m := n.tenantRe.FindStringSubmatch(name)
+	if m == nil {
+		return "", "", false
+	}
+	channel = m[n.tenantRe.SubexpIndex("channel")]
+	tenantID = m[n.tenantRe.SubexpIndex("tenant")]
+	return channel, tenantID, slices.Contains(channels, channel)
+}
+
+// globEscape escapes the characters Redis glob patterns give a meaning
+func globEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`).Replace(s)
+}
+
+type (
+	tenantKey    struct{}
+	namespaceKey struct{}
+)
+
+// WithTenant returns a context carrying the tenant an event was received
+// for, or is published for; "" for none
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFrom returns the tenant carried by ctx, or ""
+func TenantFrom(ctx context.Context) string {
+	tenantID, _ := ctx.Value(tenantKey{}).(string)
+	return tenantID
+}
+
+// WithNamespace returns a context carrying the namespace an event was
+// received in
+func WithNamespace(ctx context.Context, n Namespace) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, n)
+}
+
+// NamespaceFrom returns the namespace carried by ctx, or the zero value,
+// which leaves channel names as they are
+func NamespaceFrom(ctx context.Context) Namespace {
+	n, _ := ctx.Value(namespaceKey{}).(Namespace)
+	return n
+}
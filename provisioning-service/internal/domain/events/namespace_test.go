@@ -0,0 +1,44 @@
+package events
+
+import "testing"
+
+func TestNamespaceParse(t *testing.T) {
+	n, err := NewNamespace("staging:", "{tenant}:{channel}")
+	if err != nil {
+		t.Fatalf("NewNamespace: %v", err)
+	}
+	channels := InboundChannels()
+
+	tests := []struct {
+		name    string
+		channel string
+		tenant  string
+		ok      bool
+	}{
+		{name: "staging:user:connect", channel: ChannelUserConnect, ok: true},
+		{name: "staging:acme:user:connect", channel: ChannelUserConnect, tenant: "acme", ok: true},
+		{name: "staging:acme:user:activity:batch", channel: ChannelUserActivityBatch, tenant: "acme", ok: true},
+		{name: "staging:acme:eu:node:status", channel: ChannelNodeStatus, tenant: "acme:eu", ok: true},
+		{name: "staging:acme:user:allocation", ok: false},
+		{name: "staging:acme:unknown", ok: false},
+		{name: "prod:acme:user:connect", ok: false},
+		{name: "staging::user:connect", ok: false},
+		{name: "staging:acme:user:connect:extra", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			channel, tenant, ok := n.Parse(tt.name, channels)
+			if ok != tt.ok || ok && (channel != tt.channel || tenant != tt.tenant) {
+				t.Errorf("Parse = %q, %q, %v, want %q, %q, %v", channel, tenant, ok, tt.channel, tt.tenant, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNewNamespaceValidates(t *testing.T) {
+	for _, pattern := range []string{"{tenant}", "{channel}", "{tenant}:{channel}:{tenant}"} {
+		if _, err := NewNamespace("", pattern); err == nil {
+			t.Errorf("pattern %q accepted", pattern)
+		}
+	}
+}
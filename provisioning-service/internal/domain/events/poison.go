@@ -0,0 +1,182 @@
+package events
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxTrackedPayloads bounds the failing and poison payloads a PoisonGuard
+// remembers; beyond it one payload is forgotten for each new one
+const maxTrackedPayloads = 10000
+
+// PoisonObserver is notified of handler failures and poison messages
+type PoisonObserver interface {
+	ObserveConsecutiveFailures(channel string, n int)
+	ObservePoisonMessage(channel string, repeat bool)
+}
+
+// PoisonError is returned by PoisonGuard.Dispatch for a payload rejected as
+// invalid threshold times. Repeat is false the time the payload is found
+// to be poison, when transports dead-letter it, and true for every copy
+// received afterwards, which is dropped without being dispatched.
+type PoisonError struct {
+	Channel  string
+	Failures int
+	Repeat   bool
+	Err      error // The last failure
+}
+
+func (e *PoisonError) Error() string {
+	return fmt.Sprintf("poison %s message after %d failures: %v", e.Channel, e.Failures, e.Err)
+}
+
+func (e *PoisonError) Unwrap() error {
+	return e.Err
+}
+
+type payloadKey struct {
+	channel string
+	sum     [sha256.Size]byte
+}
+
+type poisoned struct {
+	failures int
+	err      error
+	until    time.Time
+}
+
+// PoisonGuard dispatches payloads, tracking consecutive failures per
+// channel, and invalid payloads. A payload that fails decoding or
+// validation threshold times, however far apart, is poison: it is
+// dead-lettered once and further copies are dropped until ttl has passed,
+// so one broken producer cannot flood the logs with the same error. A
+// handler failing, as when a dependency is briefly down, never makes a
+// payload poison; the transport retries or dead-letters it.
+type PoisonGuard struct {
+	threshold int // 0 disables detection
+	ttl       time.Duration
+	observer  Observer
+	poison    PoisonObserver
+
+	mu          sync.Mutex
+	consecutive map[string]int     // Channel -> failures since its last success
+	failures    map[payloadKey]int // Invalid payloads -> times rejected
+	poisoned    map[payloadKey]poisoned
+}
+
+// NewPoisonGuard creates a guard treating a payload as poison once it has
+// been rejected as invalid threshold times, or never if threshold is 0
+func NewPoisonGuard(threshold int, ttl time.Duration, observer Observer, poison PoisonObserver) *PoisonGuard {
+	return &PoisonGuard{
+		threshold:   threshold,
+		ttl:         ttl,
+		observer:    observer,
+		poison:      poison,
+		consecutive: make(map[string]int),
+		failures:    make(map[payloadKey]int),
+		poisoned:    make(map[payloadKey]poisoned),
+	}
+}
+
+// Dispatch dispatches a payload like ObservedDispatch unless it is known
+// poison. Failures are returned as from Dispatch, except that the failure
+// making a payload poison, and every copy of it received afterwards, are
+// returned as *PoisonError.
+func (g *PoisonGuard) Dispatch(ctx context.Context, h Handler, channel string, payload []byte) error {
+	key := payloadKey{channel: channel, sum: sha256.Sum256(payload)}
+
+	if p, ok := g.known(key); ok {
+		g.poison.ObservePoisonMessage(channel, true)
+		return &PoisonError{Channel: channel, Failures: p.failures, Repeat: true, Err: p.err}
+	}
+
+	err := ObservedDispatch(ctx, g.observer, h, channel, payload)
+	return g.record(key, err)
+}
+
+// known reports whether a payload is poison, forgetting it once its ttl
+// has passed
+func (g *PoisonGuard) known(key payloadKey) (poisoned, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	p, ok := g.poisoned[key]
+	if ok && time.Now().After(p.until) {
+		delete(g.poisoned, key)
+		return poisoned{}, false
+	}
+	return p, ok
+}
+
+// record counts a dispatch outcome, returning a *PoisonError if it makes
+// the payload poison
+func (g *PoisonGuard) record(key payloadKey, err error) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	outcome := Outcome(err)
+	switch outcome {
+	case OutcomeHandled:
+		g.consecutive[key.channel] = 0
+		delete(g.failures, key)
+		g.poison.ObserveConsecutiveFailures(key.channel, 0)
+		return nil
+	case OutcomeUnknownChannel:
+		// No handler ran; the channel itself is misrouted
+		return err
+	}
+
+	g.consecutive[key.channel]++
+	g.poison.ObserveConsecutiveFailures(key.channel, g.consecutive[key.channel])
+	if g.threshold <= 0 || outcome != OutcomeInvalid {
+		return err
+	}
+
+	if _, ok := g.failures[key]; !ok && len(g.failures) >= maxTrackedPayloads {
+		evictOne(g.failures)
+	}
+	g.failures[key]++
+	failures := g.failures[key]
+	if failures < g.threshold {
+		return err
+	}
+
+	delete(g.failures, key)
+	if _, ok := g.poisoned[key]; !ok && len(g.poisoned) >= maxTrackedPayloads {
+		g.prune()
+	}
+	g.poisoned[key] = poisoned{failures: failures, err: err, until: time.Now().Add(g.ttl)}
+	g.poison.ObservePoisonMessage(key.channel, false)
+	return &PoisonError{Channel: key.channel, Failures: failures, Err: err}
+}
+
+// prune forgets expired poison payloads, or the one expiring first if none
+// expired; the caller holds the lock
+func (g *PoisonGuard) prune() {
+	now := time.Now()
+	var first payloadKey
+	var firstUntil time.Time
+	for key, p := range g.poisoned {
+		if now.After(p.until) {
+			delete(g.poisoned, key)
+			continue
+		}
+		if firstUntil.IsZero() || p.until.Before(firstUntil) {
+			first, firstUntil = key, p.until
+		}
+	}
+	if len(g.poisoned) >= maxTrackedPayloads {
+		delete(g.poisoned, first)
+	}
+}
+
+// evictOne forgets one invalid payload's count to make room for another
+func evictOne(failures map[payloadKey]int) {
+	for key := range failures {
+		delete(failures, key)
+		return
+	}
+}
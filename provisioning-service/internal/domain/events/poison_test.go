@@ -0,0 +1,76 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type nopObserver struct{}
+
+func (nopObserver) ObserveMessage(channel, outcome string, d time.Duration) {}
+
+func (nopObserver) ObserveConsecutiveFailures(channel string, failures int) {}
+
+func (nopObserver) ObservePoisonMessage(channel string, repeat bool) {}
+
+// failingHandler fails every user:connect event
+type failingHandler struct {
+	recordingHandler
+}
+
+func (h *failingHandler) HandleUserConnect(ctx context.Context, event UserConnectEvent) error {
+	return errors.New("dependency down")
+}
+
+func TestPoisonGuardIgnoresHandlerFailures(t *testing.T) {
+	g := NewPoisonGuard(2, time.Hour, nopObserver{}, nopObserver{})
+	h := &failingHandler{}
+	payload := []byte(`{"user_id":"u1"}`)
+
+	for i := 0; i < 5; i++ {
+		err := g.Dispatch(context.Background(), h, ChannelUserConnect, payload)
+		var poisonErr *PoisonError
+		if err == nil || errors.As(err, &poisonErr) {
+			t.Fatalf("dispatch %d: err = %v, want the handler failure", i, err)
+		}
+	}
+}
+
+func TestPoisonGuardPoisonsInvalidPayloads(t *testing.T) {
+	g := NewPoisonGuard(2, time.Hour, nopObserver{}, nopObserver{})
+	h := &recordingHandler{}
+	payload := []byte(`not json`)
+
+	var decodeErr *DecodeError
+	if err := g.Dispatch(context.Background(), h, ChannelUserConnect, payload); !errors.As(err, &decodeErr) {
+		t.Fatalf("first dispatch: err = %v, want a decode error", err)
+	}
+
+	var poisonErr *PoisonError
+	err := g.Dispatch(context.Background(), h, ChannelUserConnect, payload)
+	if !errors.As(err, &poisonErr) || poisonErr.Repeat {
+		t.Fatalf("second dispatch: err = %v, want the payload found poison", err)
+	}
+	err = g.Dispatch(context.Background(), h, ChannelUserConnect, payload)
+	if !errors.As(err, &poisonErr) || !poisonErr.Repeat {
+		t.Fatalf("third dispatch: err = %v, want a dropped copy", err)
+	}
+}
+
+func TestPoisonGuardEvictsOneFailure(t *testing.T) {
+	g := NewPoisonGuard(2, time.Hour, nopObserver{}, nopObserver{})
+	h := &recordingHandler{}
+	for i := 0; i < maxTrackedPayloads; i++ {
+		g.failures[payloadKey{channel: ChannelUserDisconnect, sum: [32]byte{byte(i), byte(i >> 8)}}] = 1
+	}
+
+	// A new invalid payload makes room by forgetting a single count
+	if err := g.Dispatch(context.Background(), h, ChannelUserConnect, []byte(`also not json`)); err == nil {
+		t.Fatal("dispatch succeeded, want a decode error")
+	}
+	if got := len(g.failures); got != maxTrackedPayloads {
+		t.Fatalf("tracked %d failing payloads, want %d", got, maxTrackedPayloads)
+	}
+}
@@ -0,0 +1,315 @@
+package events
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Sealing modes
+const (
+	SealSign    = "sign"    // HMAC-SHA256 over the payload, which stays readable
+	SealEncrypt = "encrypt" // AES-256-GCM, hiding and authenticating the payload
+)
+
+// Seal algorithms, named as in JOSE
+const (
+	algHS256   = "HS256"
+	algA256GCM = "A256GCM"
+)
+
+// SealKeySize is the size of every sealing key in bytes
+const SealKeySize = 32
+
+// sealVersion is the version of the sealed payload format
+const sealVersion = 2
+
+// DefaultSealMaxSkew is how far the issue time of a sealed payload may be
+// from the time it is opened, unless configured otherwise
+const DefaultSealMaxSkew = 5 * time.Minute
+
+var (
+	// ErrUnsealed is returned for a plain payload when sealing is required
+	ErrUnsealed = errors.New("payload is not sealed")
+
+	// ErrUnknownKey is returned for a payload sealed with a key not in the keyring
+	ErrUnknownKey = errors.New("payload sealed with an unknown key")
+
+	// ErrBadSeal is returned for a sealed payload that is malformed, was
+	// tampered with, or was sealed for another channel or tenant
+	ErrBadSeal = errors.New("invalid sealed payload")
+
+	// ErrStaleSeal is returned for a sealed payload issued further from now
+	// than the keyring's skew allows, as a replayed one would be
+	ErrStaleSeal = errors.New("sealed payload issued outside the accepted time window")
+)
+
+// Sealed is the form a sealed payload takes on the wire. The channel the
+// payload was published on, without prefix or tenant, the tenant its name
+// carries and the time it was issued are authenticated along with it, so a
+// payload cannot be replayed on another channel or tenant's channel, or
+// once the skew window has passed.
+type Sealed struct {
+	Version   int    `json:"sealed"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`             // HS256 or A256GCM
+	IssuedAt  int64  `json:"iat"`             // Unix milliseconds
+	Nonce     string `json:"nonce,omitempty"` // Base64 GCM nonce
+	Payload   string `json:"payload"`         // Base64 payload, encrypted with A256GCM
+	Signature string `json:"sig,omitempty"`   // Base64 HMAC of the seal context and payload with HS256
+}
+
+// Keyring seals outbound payloads with its active key and opens inbound
+// ones sealed with any of its keys, so keys can be rotated by adding the
+// new key everywhere before making it active. The zero value is disabled
+// and passes payloads through.
+type Keyring struct {
+	mode    string
+	keys    map[string][]byte
+	active  string
+	require bool
+	maxSkew time.Duration
+	now     func() time.Time
+}
+
+// NewKeyring creates a keyring sealing in mode with the active key. With
+// require, inbound payloads that are not sealed are rejected; otherwise
+// they pass, while producers are moved over. Sealed payloads issued more
+// than maxSkew before or after they are opened are rejected.
+func NewKeyring(mode string, keys map[string][]byte, active string, require bool, maxSkew time.Duration) (*Keyring, error) {
+	if mode != SealSign && mode != SealEncrypt {
+		return nil, fmt.Errorf("unknown sealing mode %q", mode)
+	}
+	for id, key := range keys {
+		if len(key) != SealKeySize {
+			return nil, fmt.Errorf("sealing key %s is %d bytes, want %d", id, len(key), SealKeySize)
+		}
+	}
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active sealing key %q is not in the keyring", active)
+	}
+	if maxSkew <= 0 {
+		return nil, fmt.Errorf("sealing skew must be positive, got %s", maxSkew)
+	}
+	return &Keyring{mode: mode, keys: keys, active: active, require: require, maxSkew: maxSkew, now: time.Now}, nil
+}
+
+// Enabled reports whether the keyring seals payloads
+func (k *Keyring) Enabled() bool {
+	return k != nil && k.mode != ""
+}
+
+// Seal seals a payload published on channel, named for tenantID, with the
+// active key
+func (k *Keyring) Seal(channel, tenantID string, payload []byte) ([]byte, error) {
+	if !k.Enabled() {
+		return payload, nil
+	}
+
+	key := k.keys[k.active]
+	sealed := Sealed{Version: sealVersion, KeyID: k.active, IssuedAt: k.now().UnixMilli()}
+	aad := sealContext(channel, tenantID, sealed.IssuedAt)
+	switch k.mode {
+	case SealSign:
+		sealed.Algorithm = algHS256
+		sealed.Payload = base64.StdEncoding.EncodeToString(payload)
+		sealed.Signature = base64.StdEncoding.EncodeToString(sign(key, aad, sealed.Payload))
+	default:
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		sealed.Algorithm = algA256GCM
+		sealed.Nonce = base64.StdEncoding.EncodeToString(nonce)
+		sealed.Payload = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, payload, aad))
+	}
+	return json.Marshal(sealed)
+}
+
+// Open returns the payload a sealed payload received on channel, named for
+// tenantID, carries. Either algorithm is accepted whatever the keyring's
+// mode, so the mode can change without losing events in flight. Plain
+// payloads are returned as they are unless sealing is required.
+func (k *Keyring) Open(channel, tenantID string, payload []byte) ([]byte, error) {
+	if !k.Enabled() {
+		return payload, nil
+	}
+
+	var sealed Sealed
+	if err := json.Unmarshal(payload, &sealed); err != nil || sealed.Version == 0 {
+		if k.require {
+			return nil, ErrUnsealed
+		}
+		return payload, nil
+	}
+	if sealed.Version != sealVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrBadSeal, sealed.Version)
+	}
+	key, ok := k.keys[sealed.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, sealed.KeyID)
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: payload: %v", ErrBadSeal, err)
+	}
+
+	// The issue time is checked once authenticated, below; a forged one
+	// fails either way
+	opened, err := k.open(sealed, key, sealContext(channel, tenantID, sealed.IssuedAt), data)
+	if err != nil {
+		return nil, err
+	}
+	if skew := k.now().Sub(time.UnixMilli(sealed.IssuedAt)).Abs(); skew > k.maxSkew {
+		return nil, fmt.Errorf("%w: issued %s away", ErrStaleSeal, skew.Round(time.Second))
+	}
+	return opened, nil
+}
+
+// open authenticates a sealed payload with key and returns its plain data
+func (k *Keyring) open(sealed Sealed, key, aad, data []byte) ([]byte, error) {
+	switch sealed.Algorithm {
+	case algHS256:
+		signature, err := base64.StdEncoding.DecodeString(sealed.Signature)
+		if err != nil || !hmac.Equal(signature, sign(key, aad, sealed.Payload)) {
+			return nil, fmt.Errorf("%w: signature mismatch", ErrBadSeal)
+		}
+		return data, nil
+	case algA256GCM:
+		nonce, err := base64.StdEncoding.DecodeString(sealed.Nonce)
+		if err != nil {
+			return nil, fmt.Errorf("%w: nonce: %v", ErrBadSeal, err)
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(nonce) != aead.NonceSize() {
+			return nil, fmt.Errorf("%w: nonce is %d bytes", ErrBadSeal, len(nonce))
+		}
+		plain, err := aead.Open(nil, nonce, data, aad)
+		if err != nil {
+			return nil, fmt.Errorf("%w: decryption failed", ErrBadSeal)
+		}
+		return plain, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrBadSeal, sealed.Algorithm)
+	}
+}
+
+// sealContext is what a seal authenticates besides the payload: the
+// channel, the tenant and the issue time, separated by NUL bytes, which
+// none of them can hold
+func sealContext(channel, tenantID string, issuedAt int64) []byte {
+	return []byte(channel + "\x00" + tenantID + "\x00" + strconv.FormatInt(issuedAt, 10))
+}
+
+func sign(key, aad []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(aad)
+	mac.Write([]byte{0})
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Publisher publishes messages on a channel
+type Publisher interface {
+	Publish(ctx context.Context, channel, message string) error
+}
+
+// SealingPublisher seals every message with a keyring before publishing it
+type SealingPublisher struct {
+	next      Publisher
+	keyring   *Keyring
+	namespace Namespace
+}
+
+// NewSealingPublisher creates a publisher sealing messages for next, which
+// names channels through namespace
+func NewSealingPublisher(next Publisher, keyring *Keyring, namespace Namespace) *SealingPublisher {
+	return &SealingPublisher{next: next, keyring: keyring, namespace: namespace}
+}
+
+// Publish seals a message for the channel and the tenant its name carries,
+// and publishes it
+func (p *SealingPublisher) Publish(ctx context.Context, channel, message string) error {
+	sealed, err := p.keyring.Seal(channel, p.namespace.Tenant(channel, TenantFrom(ctx)), []byte(message))
+	if err != nil {
+		return fmt.Errorf("failed to seal %s event: %w", channel, err)
+	}
+	return p.next.Publish(ctx, channel, string(sealed))
+}
+
+// Dispatcher dispatches inbound payloads to a handler
+type Dispatcher interface {
+	Dispatch(ctx context.Context, h Handler, channel string, payload []byte) error
+}
+
+// SealObserver is notified of inbound payloads a keyring rejects
+type SealObserver interface {
+	ObserveSealRejected(channel, reason string)
+}
+
+// Seal rejection reasons
+const (
+	SealRejectUnsealed   = "unsealed"
+	SealRejectUnknownKey = "unknown_key"
+	SealRejectStale      = "stale"
+	SealRejectInvalid    = "invalid"
+)
+
+// OpeningDispatcher opens sealed payloads before passing them on, for the
+// tenant the context carries, as the channel name the transport received
+// them on named it. Payloads
+// the keyring rejects are returned as *DecodeError, so transports
+// dead-letter them like malformed events.
+type OpeningDispatcher struct {
+	next     Dispatcher
+	keyring  *Keyring
+	observer SealObserver
+}
+
+// NewOpeningDispatcher creates a dispatcher opening payloads for next
+func NewOpeningDispatcher(next Dispatcher, keyring *Keyring, observer SealObserver) *OpeningDispatcher {
+	return &OpeningDispatcher{next: next, keyring: keyring, observer: observer}
+}
+
+// Dispatch opens a payload and dispatches what it carries
+func (d *OpeningDispatcher) Dispatch(ctx context.Context, h Handler, channel string, payload []byte) error {
+	opened, err := d.keyring.Open(channel, TenantFrom(ctx), payload)
+	if err != nil {
+		reason := SealRejectInvalid
+		switch {
+		case errors.Is(err, ErrUnsealed):
+			reason = SealRejectUnsealed
+		case errors.Is(err, ErrUnknownKey):
+			reason = SealRejectUnknownKey
+		case errors.Is(err, ErrStaleSeal):
+			reason = SealRejectStale
+		}
+		d.observer.ObserveSealRejected(channel, reason)
+		return &DecodeError{Channel: channel, Err: err}
+	}
+	return d.next.Dispatch(ctx, h, channel, opened)
+}
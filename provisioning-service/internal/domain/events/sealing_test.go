@@ -0,0 +1,274 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, SealKeySize)
+}
+
+func newTestKeyring(t *testing.T, mode string, keys map[string][]byte, active string, require bool) *Keyring {
+	t.Helper()
+
+	k, err := NewKeyring(mode, keys, active, require, time.Minute)
+	if err != nil {
+		t.Fatalf("NewKeyring: %v", err)
+	}
+	return k
+}
+
+func TestKeyringRoundTrip(t *testing.T) {
+	payload := []byte(`{"user_id":"u1"}`)
+
+	for _, mode := range []string{SealSign, SealEncrypt} {
+		t.Run(mode, func(t *testing.T) {
+			k := newTestKeyring(t, mode, map[string][]byte{"k1": testKey(1)}, "k1", true)
+
+			sealed, err := k.Seal(ChannelUserConnect, "acme", payload)
+			if err != nil {
+				t.Fatalf("Seal: %v", err)
+			}
+			if mode == SealEncrypt && bytes.Contains(sealed, []byte("u1")) {
+				t.Error("encrypted payload is readable")
+			}
+
+			opened, err := k.Open(ChannelUserConnect, "acme", sealed)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			if !bytes.Equal(opened, payload) {
+				t.Errorf("opened %s, want %s", opened, payload)
+			}
+		})
+	}
+}
+
+func TestKeyringRejects(t *testing.T) {
+	keys := map[string][]byte{"k1": testKey(1)}
+	payload := []byte(`{"user_id":"u1"}`)
+
+	// tamper changes a field of the sealed form
+	tamper := func(f func(s *Sealed)) func(t *testing.T, sealed []byte) []byte {
+		return func(t *testing.T, sealed []byte) []byte {
+			var s Sealed
+			if err := json.Unmarshal(sealed, &s); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			f(&s)
+			data, _ := json.Marshal(s)
+			return data
+		}
+	}
+
+	tests := []struct {
+		name    string
+		channel string
+		tenant  string
+		change  func(t *testing.T, sealed []byte) []byte
+		want    error
+	}{
+		{
+			name:    "wrong channel",
+			channel: ChannelUserDisconnect,
+			tenant:  "acme",
+			want:    ErrBadSeal,
+		},
+		{
+			name:    "wrong tenant",
+			channel: ChannelUserConnect,
+			tenant:  "globex",
+			want:    ErrBadSeal,
+		},
+		{
+			name:    "tampered payload",
+			channel: ChannelUserConnect,
+			tenant:  "acme",
+			change: tamper(func(s *Sealed) {
+				data, _ := base64.StdEncoding.DecodeString(s.Payload)
+				data[0] ^= 0xff
+				s.Payload = base64.StdEncoding.EncodeToString(data)
+			}),
+			want: ErrBadSeal,
+		},
+		{
+			name:    "forged issue time",
+			channel: ChannelUserConnect,
+			tenant:  "acme",
+			change:  tamper(func(s *Sealed) { s.IssuedAt += 1000 }),
+			want:    ErrBadSeal,
+		},
+		{
+			name:    "unknown key",
+			channel: ChannelUserConnect,
+			tenant:  "acme",
+			change:  tamper(func(s *Sealed) { s.KeyID = "k9" }),
+			want:    ErrUnknownKey,
+		},
+		{
+			name:    "old format",
+			channel: ChannelUserConnect,
+			tenant:  "acme",
+			change:  tamper(func(s *Sealed) { s.Version = 1 }),
+			want:    ErrBadSeal,
+		},
+	}
+
+	for _, mode := range []string{SealSign, SealEncrypt} {
+		k := newTestKeyring(t, mode, keys, "k1", true)
+		for _, tt := range tests {
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				sealed, err := k.Seal(ChannelUserConnect, "acme", payload)
+				if err != nil {
+					t.Fatalf("Seal: %v", err)
+				}
+				if tt.change != nil {
+					sealed = tt.change(t, sealed)
+				}
+				if _, err := k.Open(tt.channel, tt.tenant, sealed); !errors.Is(err, tt.want) {
+					t.Errorf("Open = %v, want %v", err, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestKeyringSkew(t *testing.T) {
+	k := newTestKeyring(t, SealSign, map[string][]byte{"k1": testKey(1)}, "k1", true)
+	issued := time.Now()
+	k.now = func() time.Time { return issued }
+	sealed, err := k.Seal(ChannelNodeStatus, "", []byte(`{}`))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		opened time.Time
+		want   error
+	}{
+		{name: "within skew", opened: issued.Add(30 * time.Second)},
+		{name: "replayed later", opened: issued.Add(2 * time.Minute), want: ErrStaleSeal},
+		{name: "from the future", opened: issued.Add(-2 * time.Minute), want: ErrStaleSeal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k.now = func() time.Time { return tt.opened }
+			if _, err := k.Open(ChannelNodeStatus, "", sealed); !errors.Is(err, tt.want) {
+				t.Errorf("Open = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKeys := map[string][]byte{"k1": testKey(1)}
+	bothKeys := map[string][]byte{"k1": testKey(1), "k2": testKey(2)}
+
+	producer := newTestKeyring(t, SealEncrypt, oldKeys, "k1", true)
+	rotated := newTestKeyring(t, SealSign, bothKeys, "k2", true)
+
+	// A service that added the new key still opens events sealed with the
+	// old one, whatever the mode
+	sealed, err := producer.Seal(ChannelUserConnect, "", []byte("a"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if _, err := rotated.Open(ChannelUserConnect, "", sealed); err != nil {
+		t.Errorf("event sealed with the previous key: %v", err)
+	}
+
+	// A producer still on the old keys rejects the new key until it has it
+	sealed, err = rotated.Seal(ChannelUserConnect, "", []byte("b"))
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if _, err := producer.Open(ChannelUserConnect, "", sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("Open with the new key = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestKeyringRequire(t *testing.T) {
+	keys := map[string][]byte{"k1": testKey(1)}
+	plain := []byte(`{"user_id":"u1"}`)
+
+	lenient := newTestKeyring(t, SealSign, keys, "k1", false)
+	if opened, err := lenient.Open(ChannelUserConnect, "", plain); err != nil || !bytes.Equal(opened, plain) {
+		t.Errorf("plain payload without require = %s, %v", opened, err)
+	}
+
+	strict := newTestKeyring(t, SealSign, keys, "k1", true)
+	if _, err := strict.Open(ChannelUserConnect, "", plain); !errors.Is(err, ErrUnsealed) {
+		t.Errorf("plain payload with require = %v, want ErrUnsealed", err)
+	}
+
+	var disabled *Keyring
+	if opened, err := disabled.Open(ChannelUserConnect, "", plain); err != nil || !bytes.Equal(opened, plain) {
+		t.Errorf("disabled keyring = %s, %v", opened, err)
+	}
+}
+
+func TestNewKeyringValidates(t *testing.T) {
+	keys := map[string][]byte{"k1": testKey(1)}
+	tests := []struct {
+		name    string
+		mode    string
+		keys    map[string][]byte
+		active  string
+		maxSkew time.Duration
+	}{
+		{name: "unknown mode", mode: "rot13", keys: keys, active: "k1", maxSkew: time.Minute},
+		{name: "short key", mode: SealSign, keys: map[string][]byte{"k1": []byte("short")}, active: "k1", maxSkew: time.Minute},
+		{name: "missing active key", mode: SealSign, keys: keys, active: "k2", maxSkew: time.Minute},
+		{name: "no skew", mode: SealSign, keys: keys, active: "k1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewKeyring(tt.mode, tt.keys, tt.active, false, tt.maxSkew); err == nil {
+				t.Error("NewKeyring accepted an invalid keyring")
+			}
+		})
+	}
+}
+
+// capturePublisher keeps the last message published
+type capturePublisher struct {
+	channel, message string
+}
+
+func (p *capturePublisher) Publish(ctx context.Context, channel, message string) error {
+	p.channel, p.message = channel, message
+	return nil
+}
+
+func TestSealingPublisherBindsNamedTenant(t *testing.T) {
+	k := newTestKeyring(t, SealSign, map[string][]byte{"k1": testKey(1)}, "k1", true)
+	namespace, err := NewNamespace("", "{tenant}:{channel}")
+	if err != nil {
+		t.Fatalf("NewNamespace: %v", err)
+	}
+	next := &capturePublisher{}
+	publisher := NewSealingPublisher(next, k, namespace)
+	ctx := WithTenant(context.Background(), "acme")
+
+	if err := publisher.Publish(ctx, ChannelAllocationResult, "{}"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if _, err := k.Open(ChannelAllocationResult, "acme", []byte(next.message)); err != nil {
+		t.Errorf("service channel not sealed for the tenant its name carries: %v", err)
+	}
+
+	// Reply channels are named by the client, without the tenant
+	if err := publisher.Publish(ctx, "replies:sim", "{}"); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	if _, err := k.Open("replies:sim", "", []byte(next.message)); err != nil {
+		t.Errorf("reply channel sealed for a tenant: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CurrentSchemaVersion is the newest event schema this service understands.
+// Events without a schema_version are treated as version 1.
+const CurrentSchemaVersion = 1
+
+// MaxClockSkew bounds how far in the future an event timestamp may be
+const MaxClockSkew = 5 * time.Minute
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+	ErrMissingField       = errors.New("missing required field")
+	ErrInvalidField       = errors.New("invalid field value")
+)
+
+// Event is implemented by every inbound event type
+type Event interface {
+	Version() int
+	Validate() error
+}
+
+// Decode strictly parses an event payload: unknown fields are rejected, the
+// schema version must be supported, and the event must pass validation.
+// Payloads wrapped in a CloudEvents envelope are unwrapped first.
+func Decode(data []byte, event Event) error {
+	data, err := unwrapEnvelope(data)
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(event); err != nil {
+		return fmt.Errorf("malformed payload: %w", err)
+	}
+	if dec.More() {
+		return errors.New("malformed payload: trailing data")
+	}
+
+	if v := event.Version(); v < 0 || v > CurrentSchemaVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, v)
+	}
+
+	return event.Validate()
+}
+
+// Version implements Event
+func (e *UserActivityEvent) Version() int { return e.SchemaVersion }
+
+// Validate implements Event
+func (e *UserActivityEvent) Validate() error {
+	if e.UserID == "" {
+		return fmt.Errorf("%w: user_id", ErrMissingField)
+	}
+	if e.Timestamp <= 0 {
+		return fmt.Errorf("%w: timestamp", ErrMissingField)
+	}
+	if time.Unix(e.Timestamp, 0).After(time.Now().Add(MaxClockSkew)) {
+		return fmt.Errorf("%w: timestamp %d is in the future", ErrInvalidField, e.Timestamp)
+	}
+	if len(e.Type) > MaxActivityTypeLength {
+		return fmt.Errorf("%w: type exceeds %d characters", ErrInvalidField, MaxActivityTypeLength)
+	}
+	return nil
+}
+
+// Version implements Event
+func (e *UserActivityBatchEvent) Version() int { return e.SchemaVersion }
+
+// Validate implements Event. A batch with any invalid record is rejected
+// as a whole.
+func (e *UserActivityBatchEvent) Validate() error {
+	if len(e.Activities) == 0 {
+		return fmt.Errorf("%w: activities", ErrMissingField)
+	}
+	if len(e.Activities) > MaxActivityBatch {
+		return fmt.Errorf("%w: %d activities exceed the limit of %d", ErrInvalidField, len(e.Activities), MaxActivityBatch)
+	}
+	for i := range e.Activities {
+		activity := &e.Activities[i]
+		if v := activity.Version(); v < 0 || v > CurrentSchemaVersion {
+			return fmt.Errorf("activities[%d]: %w: %d", i, ErrUnsupportedVersion, v)
+		}
+		if err := activity.Validate(); err != nil {
+			return fmt.Errorf("activities[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Version implements Event
+func (e *UserConnectEvent) Version() int { return e.SchemaVersion }
+
+// Validate implements Event
+func (e *UserConnectEvent) Validate() error {
+	if e.UserID == "" {
+		return fmt.Errorf("%w: user_id", ErrMissingField)
+	}
+	return validateLabels("selector", e.Selector)
+}
+
+// Version implements Event
+func (e *UserDisconnectEvent) Version() int { return e.SchemaVersion }
+
+// Validate implements Event
+func (e *UserDisconnectEvent) Validate() error {
+	if e.UserID == "" {
+		return fmt.Errorf("%w: user_id", ErrMissingField)
+	}
+	return nil
+}
+
+// Version implements Event
+func (e *UserMigrateAckEvent) Version() int { return e.SchemaVersion }
+
+// Validate implements Event
+func (e *UserMigrateAckEvent) Validate() error {
+	if e.MigrationID == "" {
+		return fmt.Errorf("%w: migration_id", ErrMissingField)
+	}
+	if e.UserID == "" {
+		return fmt.Errorf("%w: user_id", ErrMissingField)
+	}
+	switch e.Status {
+	case "":
+		return fmt.Errorf("%w: status", ErrMissingField)
+	case MigrationStatusCompleted, MigrationStatusFailed:
+	default:
+		return fmt.Errorf("%w: status %q", ErrInvalidField, e.Status)
+	}
+	return nil
+}
+
+// Version implements Event
+func (e *UserConfirmEvent) Version() int { return e.SchemaVersion }
+
+// Validate implements Event
+func (e *UserConfirmEvent) Validate() error {
+	if e.UserID == "" {
+		return fmt.Errorf("%w: user_id", ErrMissingField)
+	}
+	if e.NodeID == "" {
+		return fmt.Errorf("%w: node_id", ErrMissingField)
+	}
+	return nil
+}
+
+// Version implements Event
+func (e *NodeUtilizationEvent) Version() int { return e.SchemaVersion }
+
+// Validate implements Event
+func (e *NodeUtilizationEvent) Validate() error {
+	if e.NodeID == "" {
+		return fmt.Errorf("%w: node_id", ErrMissingField)
+	}
+	if e.GPUPercent < 0 || e.GPUPercent > 100 {
+		return fmt.Errorf("%w: gpu_percent %g", ErrInvalidField, e.GPUPercent)
+	}
+	if e.MemoryPercent < 0 || e.MemoryPercent > 100 {
+		return fmt.Errorf("%w: memory_percent %g", ErrInvalidField, e.MemoryPercent)
+	}
+	if e.Timestamp < 0 {
+		return fmt.Errorf("%w: timestamp %d", ErrInvalidField, e.Timestamp)
+	}
+	if time.Unix(e.Timestamp, 0).After(time.Now().Add(MaxClockSkew)) {
+		return fmt.Errorf("%w: timestamp %d is in the future", ErrInvalidField, e.Timestamp)
+	}
+	return nil
+}
+
+// Version implements Event
+func (e *NodeStatusEvent) Version() int { return e.SchemaVersion }
+
+// Validate implements Event
+func (e *NodeStatusEvent) Validate() error {
+	if e.NodeID == "" {
+		return fmt.Errorf("%w: node_id", ErrMissingField)
+	}
+	switch e.Status {
+	case "":
+		if !e.HasFields() {
+			return fmt.Errorf("%w: status", ErrMissingField)
+		}
+	case "booting", "ready", "terminated":
+	default:
+		return fmt.Errorf("%w: status %q", ErrInvalidField, e.Status)
+	}
+	if e.Port < 0 || e.Port > 65535 {
+		return fmt.Errorf("%w: port %d", ErrInvalidField, e.Port)
+	}
+	if e.Capacity < 0 {
+		return fmt.Errorf("%w: capacity %d", ErrInvalidField, e.Capacity)
+	}
+	return validateLabels("labels", e.Labels)
+}
+
+// validateLabels rejects label maps with empty keys
+func validateLabels(field string, labels map[string]string) error {
+	if _, ok := labels[""]; ok {
+		return fmt.Errorf("%w: %s has an empty key", ErrInvalidField, field)
+	}
+	return nil
+}
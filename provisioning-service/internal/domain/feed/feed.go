@@ -0,0 +1,262 @@
+package feed
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+// Event types streamed to the operations console
+const (
+	TypeScalingDecision = "scaling_decision"
+	TypeAllocation      = "allocation"
+	TypeNodeTransition  = "node_transition"
+	TypeBootFailure     = "boot_failure"
+	TypeLatencyBreach   = "latency_breach"
+	TypeEscalation      = "latency_escalation"
+	TypeIdleWarning     = "idle_warning"
+)
+
+// Allocation actions
+const (
+	ActionAllocated   = "allocated"   // A connect was served
+	ActionReleased    = "released"    // The user disconnected
+	ActionDeallocated = "deallocated" // An operator tore down the allocation
+	ActionReassigned  = "reassigned"  // An operator moved the user to another node
+	ActionMigrated    = "migrated"    // The user's session was migrated to another node
+	ActionUnconfirmed = "unconfirmed" // A connect was served pending the user's confirmation
+	ActionConfirmed   = "confirmed"   // The user attached to the node reserved for them
+	ActionExpired     = "expired"     // The user did not confirm in time and the node was released
+	ActionReclaimed   = "reclaimed"   // The user's node was reclaimed after staying idle
+	ActionRepaired    = "repaired"    // The consistency check released an allocation the pool and user tracker disagreed on
+)
+
+// subscriberBuffer is how many events a slow subscriber may fall behind by
+// before further events are dropped for it
+const subscriberBuffer = 256
+
+// Event is a single entry in the live feed
+type Event struct {
+	Type      string `json:"type"`
+	NodeID    string `json:"node_id,omitempty"`
+	UserID    string `json:"user_id,omitempty"`
+	TenantID  string `json:"tenant_id,omitempty"`
+	Timestamp int64  `json:"timestamp"` // Unix milliseconds
+	Data      any    `json:"data"`
+}
+
+// Allocation is the payload of an allocation event
+type Allocation struct {
+	Action         string `json:"action"` // allocated|unconfirmed|confirmed|expired|released|deallocated|reassigned|migrated|reclaimed
+	PreviousNodeID string `json:"previous_node_id,omitempty"`
+}
+
+// NodeTransition is the payload of a node transition event
+type NodeTransition struct {
+	From         string `json:"from,omitempty"` // Empty for a node new to the pool
+	To           string `json:"to"`
+	InstanceType string `json:"instance_type,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+}
+
+// BootFailure is the payload of a boot failure event
+type BootFailure struct {
+	InstanceType string           `json:"instance_type,omitempty"`
+	Provider     string           `json:"provider,omitempty"`
+	Attempt      int              `json:"attempt"` // Nodes tried in a row, counting this one
+	Reason       string           `json:"reason"`
+	Diagnostics  node.Diagnostics `json:"diagnostics"`
+}
+
+// IdleWarning is the payload of an idle warning event
+type IdleWarning struct {
+	Warning     int     `json:"warning"`
+	Warnings    int     `json:"warnings"`
+	IdleSeconds float64 `json:"idle_seconds"`
+	GPUPercent  float64 `json:"gpu_percent"`
+	ReclaimAt   int64   `json:"reclaim_at"` // Unix seconds
+}
+
+// LatencyBreach is the payload of latency breach and escalation events
+type LatencyBreach struct {
+	Tier           string  `json:"tier"`
+	MaxWaitSeconds float64 `json:"max_wait_seconds"`
+	WaitedSeconds  float64 `json:"waited_seconds"`
+	Step           string  `json:"step,omitempty"` // Escalation step taken; empty on the breach
+	InstanceType   string  `json:"instance_type,omitempty"`
+	Error          string  `json:"error,omitempty"` // Why the step failed
+}
+
+// Decision is the payload of a scaling decision event
+type Decision struct {
+	ShouldScaleUp   bool              `json:"should_scale_up"`
+	ShouldScaleDown bool              `json:"should_scale_down"`
+	TargetNodes     int               `json:"target_nodes"`
+	Reason          string            `json:"reason"`
+	InstanceType    string            `json:"instance_type,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Types           []Decision        `json:"instance_types,omitempty"`
+	Deferred        bool              `json:"deferred,omitempty"`
+	DryRun          bool              `json:"dry_run,omitempty"` // Made in dry-run mode and not acted on
+	Provisioned     int               `json:"provisioned,omitempty"`
+	BudgetBlocked   int               `json:"budget_blocked,omitempty"`
+	Error           string            `json:"error,omitempty"`
+}
+
+// Filter selects the events a subscriber receives; empty fields match everything
+type Filter struct {
+	Types    []string `json:"types,omitempty"`
+	TenantID string   `json:"tenant_id,omitempty"`
+	NodeID   string   `json:"node_id,omitempty"`
+}
+
+// Matches reports whether an event passes the filter. Scaling decisions
+// are pool-wide and pass tenant and node filters.
+func (f Filter) Matches(e Event) bool {
+	if len(f.Types) > 0 {
+		found := false
+		for _, t := range f.Types {
+			if t == e.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if e.Type == TypeScalingDecision {
+		return true
+	}
+	if f.TenantID != "" && f.TenantID != e.TenantID {
+		return false
+	}
+	if f.NodeID != "" && f.NodeID != e.NodeID {
+		// A reassignment concerns the node the user left as well
+		a, ok := e.Data.(Allocation)
+		return ok && a.PreviousNodeID == f.NodeID
+	}
+	return true
+}
+
+// Subscription receives feed events matching its filter
+type Subscription struct {
+	hub     *Hub
+	events  chan Event
+	dropped atomic.Int64
+
+	mu     sync.Mutex
+	filter Filter
+}
+
+// Events returns the channel events are delivered on; it is closed when the
+// subscription is closed or the hub shuts down
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// SetFilter replaces the subscription's filter
+func (s *Subscription) SetFilter(f Filter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filter = f
+}
+
+// Filter returns the subscription's current filter
+func (s *Subscription) Filter() Filter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.filter
+}
+
+// Dropped returns how many events were dropped because the subscriber fell behind
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close unsubscribes from the hub
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// Hub fans feed events out to subscribers. Publishing never blocks: events
+// are dropped for subscribers that are not keeping up.
+type Hub struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewHub creates a new feed hub
+func NewHub() *Hub {
+	return &Hub{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe registers a subscriber with the given filter
+func (h *Hub) Subscribe(f Filter) *Subscription {
+	s := &Subscription{
+		hub:    h,
+		events: make(chan Event, subscriberBuffer),
+		filter: f,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(s.events)
+		return s
+	}
+	h.subs[s] = struct{}{}
+	return s
+}
+
+func (h *Hub) unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.events)
+	}
+}
+
+// Publish delivers an event to every matching subscriber
+func (h *Hub) Publish(e Event) {
+	if e.Timestamp == 0 {
+		e.Timestamp = time.Now().UnixMilli()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for s := range h.subs {
+		if !s.Filter().Matches(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribers returns the number of active subscribers
+func (h *Hub) Subscribers() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.subs)
+}
+
+// Close ends every subscription and rejects new ones
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.events)
+	}
+}
@@ -0,0 +1,124 @@
+package forecast
+
+import (
+	"sync"
+	"time"
+)
+
+// Config holds forecaster tuning parameters
+type Config struct {
+	// BucketSize is the width of each time-of-season bucket
+	BucketSize time.Duration
+
+	// Season is the period over which demand repeats (e.g. 24h or 168h)
+	Season time.Duration
+
+	// Alpha is the smoothing factor; higher values favour recent seasons
+	Alpha float64
+}
+
+// DefaultConfig returns default forecaster configuration
+func DefaultConfig() Config {
+	return Config{
+		BucketSize: 15 * time.Minute,
+		Season:     24 * time.Hour,
+		Alpha:      0.3,
+	}
+}
+
+// Estimate is the smoothed demand for a bucket
+type Estimate struct {
+	Concurrent  float64 // Peak concurrent connections
+	ConnectRate float64 // Connects per minute
+	Samples     int     // Seasons folded into the estimate; 0 means no history
+}
+
+// Forecaster maintains an exponentially weighted moving average of peak
+// concurrency and connect rate for each bucket of the season, so demand can
+// be anticipated from what happened at the same time in previous seasons
+type Forecaster struct {
+	mu      sync.Mutex
+	config  Config
+	buckets map[int]*Estimate
+
+	// Observations for the bucket in progress, folded in when it ends
+	currentStart time.Time
+	peak         int
+	connects     int
+}
+
+// NewForecaster creates a new forecaster
+func NewForecaster(config Config) *Forecaster {
+	return &Forecaster{
+		config:  config,
+		buckets: make(map[int]*Estimate),
+	}
+}
+
+// ObserveConcurrent records the number of connected users at a point in time
+func (f *Forecaster) ObserveConcurrent(now time.Time, connected int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rollLocked(now)
+	if connected > f.peak {
+		f.peak = connected
+	}
+}
+
+// RecordConnect counts a connect request
+func (f *Forecaster) RecordConnect(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rollLocked(now)
+	f.connects++
+}
+
+// Forecast returns the estimate for the bucket containing at
+func (f *Forecaster) Forecast(at time.Time) Estimate {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if est, ok := f.buckets[f.bucketIndex(at)]; ok {
+		return *est
+	}
+	return Estimate{}
+}
+
+// rollLocked folds the bucket in progress into its average once now has
+// moved past it; caller must hold the lock
+func (f *Forecaster) rollLocked(now time.Time) {
+	start := now.Truncate(f.config.BucketSize)
+	if start.Equal(f.currentStart) {
+		return
+	}
+
+	if !f.currentStart.IsZero() {
+		f.fold(f.bucketIndex(f.currentStart), float64(f.peak),
+			float64(f.connects)/f.config.BucketSize.Minutes())
+	}
+
+	f.currentStart = start
+	f.peak = 0
+	f.connects = 0
+}
+
+func (f *Forecaster) fold(index int, concurrent, rate float64) {
+	est, ok := f.buckets[index]
+	if !ok {
+		f.buckets[index] = &Estimate{Concurrent: concurrent, ConnectRate: rate, Samples: 1}
+		return
+	}
+
+	alpha := f.config.Alpha
+	est.Concurrent = alpha*concurrent + (1-alpha)*est.Concurrent
+	est.ConnectRate = alpha*rate + (1-alpha)*est.ConnectRate
+	est.Samples++
+}
+
+// bucketIndex returns the position of t's bucket within the season
+func (f *Forecaster) bucketIndex(t time.Time) int {
+	offset := t.UTC().Sub(t.UTC().Truncate(f.config.Season))
+	return int(offset / f.config.BucketSize)
+}
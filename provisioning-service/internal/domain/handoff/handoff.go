@@ -0,0 +1,226 @@
+// Package handoff carries the pool and its allocations from a stopping
+// service to the one replacing it, so a deploy does not forget who owns
+// which node.
+package handoff
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+	"github.com/aos-cc/provisioning-service/internal/domain/schedule"
+	"github.com/aos-cc/provisioning-service/internal/domain/user"
+)
+
+// Version is the snapshot format written; snapshots of any other version
+// are ignored
+const Version = 1
+
+// Snapshot is the state handed from one service to the next
+type Snapshot struct {
+	Version    int
+	WrittenAt  time.Time
+	Nodes      []node.Node      // Every node not terminated or terminating
+	Users      []user.UserState // Connected users and the nodes they hold
+	Migrations []Migration      `json:",omitempty"` // Migrations awaiting acknowledgment
+	Drain      *Drain           `json:",omitempty"` // Set while the service drains
+
+	// Sessions are the scheduled sessions pushed through the admin API;
+	// feed sessions are fetched again instead
+	Sessions []schedule.Session `json:",omitempty"`
+
+	// Tuning holds the prediction parameters changed at runtime, nil if
+	// none were. A replica taking over keeps them, while a deploy applies
+	// its own configuration.
+	Tuning *predictor.Tuning `json:",omitempty"`
+
+	// SealedTokens is set when the node auth tokens are encrypted
+	SealedTokens bool `json:",omitempty"`
+}
+
+// Drain is the state of a service being drained
+type Drain struct {
+	StartedAt    time.Time
+	NodesAtStart int
+}
+
+// Migration is a user's move to another node awaiting the client's
+// acknowledgment. The user holds a slot on both nodes until it completes.
+type Migration struct {
+	ID         string
+	UserID     string
+	FromNodeID string
+	ToNodeID   string
+	Reason     string
+	StartedAt  time.Time
+	Deadline   time.Time
+}
+
+// WithoutSecrets returns a copy of the snapshot without the node auth
+// tokens, for stores other processes can read
+func (s Snapshot) WithoutSecrets() Snapshot {
+	s.Nodes = slices.Clone(s.Nodes)
+	for i := range s.Nodes {
+		s.Nodes[i].Endpoint.AuthToken = ""
+	}
+	return s
+}
+
+// Store holds a snapshot between a service stopping and its replacement
+// starting
+type Store interface {
+	// Save replaces the stored snapshot
+	Save(ctx context.Context, snapshot Snapshot) error
+
+	// Take returns the stored snapshot and removes it, so it is taken over
+	// once, or false if there is none
+	Take(ctx context.Context) (Snapshot, bool, error)
+}
+
+// NopStore keeps nothing, so every start reconciles from scratch
+type NopStore struct{}
+
+func (NopStore) Save(ctx context.Context, snapshot Snapshot) error {
+	return nil
+}
+
+func (NopStore) Take(ctx context.Context) (Snapshot, bool, error) {
+	return Snapshot{}, false, nil
+}
+
+// TokenKeySize is the size of the key node auth tokens are encrypted with
+const TokenKeySize = 32
+
+// SecretStore keeps node auth tokens out of the snapshots another store
+// holds in plain text. With a key they are encrypted with AES-256-GCM, bound
+// to their node; without one they are left out, and nodes get them back
+// from their next status events.
+type SecretStore struct {
+	next Store
+	aead cipher.AEAD
+}
+
+// NewSecretStore wraps a store, encrypting tokens with key, or leaving them
+// out if key is empty
+func NewSecretStore(next Store, key []byte) (*SecretStore, error) {
+	s := &SecretStore{next: next}
+	if len(key) == 0 {
+		return s, nil
+	}
+	if len(key) != TokenKeySize {
+		return nil, fmt.Errorf("handoff key is %d bytes, want %d", len(key), TokenKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if s.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save stores the snapshot with its tokens encrypted or left out
+func (s *SecretStore) Save(ctx context.Context, snapshot Snapshot) error {
+	if s.aead == nil {
+		return s.next.Save(ctx, snapshot.WithoutSecrets())
+	}
+
+	snapshot.Nodes = slices.Clone(snapshot.Nodes)
+	for i := range snapshot.Nodes {
+		n := &snapshot.Nodes[i]
+		if n.Endpoint.AuthToken == "" {
+			continue
+		}
+		nonce := make([]byte, s.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		sealed := s.aead.Seal(nonce, nonce, []byte(n.Endpoint.AuthToken), []byte(n.ID))
+		n.Endpoint.AuthToken = base64.StdEncoding.EncodeToString(sealed)
+	}
+	snapshot.SealedTokens = true
+	return s.next.Save(ctx, snapshot)
+}
+
+// Take returns the stored snapshot with its tokens decrypted. Tokens that
+// cannot be decrypted without a key are left out.
+func (s *SecretStore) Take(ctx context.Context) (Snapshot, bool, error) {
+	snapshot, ok, err := s.next.Take(ctx)
+	if err != nil || !ok || !snapshot.SealedTokens {
+		return snapshot, ok, err
+	}
+	if s.aead == nil {
+		return snapshot.WithoutSecrets(), true, nil
+	}
+
+	for i := range snapshot.Nodes {
+		n := &snapshot.Nodes[i]
+		if n.Endpoint.AuthToken == "" {
+			continue
+		}
+		sealed, err := base64.StdEncoding.DecodeString(n.Endpoint.AuthToken)
+		if err != nil || len(sealed) < s.aead.NonceSize() {
+			return Snapshot{}, false, fmt.Errorf("invalid auth token of node %s in handoff", n.ID)
+		}
+		nonce, data := sealed[:s.aead.NonceSize()], sealed[s.aead.NonceSize():]
+		token, err := s.aead.Open(nil, nonce, data, []byte(n.ID))
+		if err != nil {
+			return Snapshot{}, false, fmt.Errorf("failed to decrypt auth token of node %s in handoff: %w", n.ID, err)
+		}
+		n.Endpoint.AuthToken = string(token)
+	}
+	snapshot.SealedTokens = false
+	return snapshot, true, nil
+}
+
+// Encode serializes a snapshot for a store
+func Encode(snapshot Snapshot) ([]byte, error) {
+	return json.Marshal(snapshot)
+}
+
+// Decode reads a snapshot written by Encode
+func Decode(data []byte) (Snapshot, error) {
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return Snapshot{}, fmt.Errorf("invalid handoff: %w", err)
+	}
+	if snapshot.Version != Version {
+		return Snapshot{}, fmt.Errorf("unsupported handoff version %d", snapshot.Version)
+	}
+	return snapshot, nil
+}
+
+// ExportVersion is the schema of exported state; imports of any other
+// version are rejected
+const ExportVersion = 1
+
+// Export is the full state of a service, exported through the admin API for
+// another service to import, as in a blue/green cutover or a disaster
+// recovery drill
+type Export struct {
+	SchemaVersion int              `json:"schema_version"`
+	ExportedAt    time.Time        `json:"exported_at"`
+	Nodes         []node.Node      `json:"nodes"` // Every node not terminated or terminating
+	Users         []user.UserState `json:"users"` // Every tracked user, connected or not
+}
+
+// DecodeExport reads state written by an export
+func DecodeExport(data []byte) (Export, error) {
+	var export Export
+	if err := json.Unmarshal(data, &export); err != nil {
+		return Export{}, fmt.Errorf("invalid state: %w", err)
+	}
+	if export.SchemaVersion != ExportVersion {
+		return Export{}, fmt.Errorf("unsupported state schema version %d; expected %d", export.SchemaVersion, ExportVersion)
+	}
+	return export, nil
+}
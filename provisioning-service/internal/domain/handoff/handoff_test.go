@@ -0,0 +1,111 @@
+package handoff
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+// memoryStore keeps the encoded snapshot, as a store outside the process
+// would
+type memoryStore struct {
+	data []byte
+}
+
+func (m *memoryStore) Save(ctx context.Context, snapshot Snapshot) error {
+	data, err := Encode(snapshot)
+	m.data = data
+	return err
+}
+
+func (m *memoryStore) Take(ctx context.Context) (Snapshot, bool, error) {
+	if m.data == nil {
+		return Snapshot{}, false, nil
+	}
+	snapshot, err := Decode(m.data)
+	m.data = nil
+	return snapshot, err == nil, err
+}
+
+func testSnapshot() Snapshot {
+	return Snapshot{
+		Version:   Version,
+		WrittenAt: time.Now(),
+		Nodes: []node.Node{
+			{ID: "n1", Endpoint: node.Endpoint{Address: "10.0.0.1", AuthToken: "secret-n1"}},
+			{ID: "n2"},
+		},
+	}
+}
+
+func TestSecretStoreEncryptsTokens(t *testing.T) {
+	ctx := context.Background()
+	inner := &memoryStore{}
+	store, err := NewSecretStore(inner, bytes.Repeat([]byte{7}, TokenKeySize))
+	if err != nil {
+		t.Fatalf("NewSecretStore: %v", err)
+	}
+
+	snapshot := testSnapshot()
+	if err := store.Save(ctx, snapshot); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if bytes.Contains(inner.data, []byte("secret-n1")) {
+		t.Fatalf("stored handoff holds the plain auth token: %s", inner.data)
+	}
+	if snapshot.Nodes[0].Endpoint.AuthToken != "secret-n1" {
+		t.Fatal("Save changed the caller's snapshot")
+	}
+
+	taken, ok, err := store.Take(ctx)
+	if err != nil || !ok {
+		t.Fatalf("Take = %v, %v", ok, err)
+	}
+	if got := taken.Nodes[0].Endpoint.AuthToken; got != "secret-n1" {
+		t.Fatalf("token = %q, want secret-n1", got)
+	}
+	if _, ok, _ := store.Take(ctx); ok {
+		t.Fatal("handoff taken over twice")
+	}
+}
+
+func TestSecretStoreWithoutKeyLeavesTokensOut(t *testing.T) {
+	ctx := context.Background()
+	inner := &memoryStore{}
+	store, err := NewSecretStore(inner, nil)
+	if err != nil {
+		t.Fatalf("NewSecretStore: %v", err)
+	}
+
+	if err := store.Save(ctx, testSnapshot()); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if bytes.Contains(inner.data, []byte("secret-n1")) {
+		t.Fatalf("stored handoff holds the plain auth token: %s", inner.data)
+	}
+	taken, ok, err := store.Take(ctx)
+	if err != nil || !ok {
+		t.Fatalf("Take = %v, %v", ok, err)
+	}
+	if got := taken.Nodes[0].Endpoint; got.AuthToken != "" || got.Address != "10.0.0.1" {
+		t.Fatalf("endpoint = %+v, want the address without a token", got)
+	}
+}
+
+func TestSecretStoreRejectsTamperedTokens(t *testing.T) {
+	ctx := context.Background()
+	key := bytes.Repeat([]byte{7}, TokenKeySize)
+	inner := &memoryStore{}
+	store, _ := NewSecretStore(inner, key)
+	if err := store.Save(ctx, testSnapshot()); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	other, _ := NewSecretStore(inner, bytes.Repeat([]byte{8}, TokenKeySize))
+	if _, _, err := other.Take(ctx); err == nil {
+		t.Fatal("Take with another key succeeded")
+	}
+}
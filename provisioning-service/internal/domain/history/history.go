@@ -0,0 +1,73 @@
+package history
+
+import (
+	"sync"
+	"time"
+)
+
+// Sample is a point-in-time snapshot of the node pool
+type Sample struct {
+	Timestamp      time.Time
+	Booting        int
+	Ready          int
+	Allocated      int
+	Terminated     int
+	Demand         int // Users predicted to connect
+	ConnectedUsers int
+}
+
+// History keeps pool samples in a fixed-size ring buffer
+type History struct {
+	mu      sync.RWMutex
+	samples []Sample
+	next    int
+	full    bool
+}
+
+// NewHistory creates a history holding enough samples to cover retention
+// when recorded every interval
+func NewHistory(retention, interval time.Duration) *History {
+	capacity := 1
+	if interval > 0 {
+		capacity = int(retention / interval)
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &History{
+		samples: make([]Sample, capacity),
+	}
+}
+
+// Record appends a sample, overwriting the oldest once full
+func (h *History) Record(sample Sample) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % len(h.samples)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Since returns samples recorded after the given time, oldest first
+func (h *History) Since(since time.Time) []Sample {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	start, count := 0, h.next
+	if h.full {
+		start, count = h.next, len(h.samples)
+	}
+
+	result := make([]Sample, 0, count)
+	for i := 0; i < count; i++ {
+		sample := h.samples[(start+i)%len(h.samples)]
+		if sample.Timestamp.After(since) {
+			result = append(result, sample)
+		}
+	}
+	return result
+}
@@ -0,0 +1,173 @@
+// Package journal keeps the recent operations feed events of each node and
+// user, so their history can be inspected after the fact.
+package journal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+// Config holds journal retention parameters
+type Config struct {
+	// Retention is how long events are kept
+	Retention time.Duration
+
+	// MaxEvents bounds the events kept per node and per user; the oldest are
+	// dropped beyond it
+	MaxEvents int
+}
+
+// Journal indexes feed events by node and by user, and keeps the final
+// records of nodes purged from the pool. It holds them in memory only, so
+// history starts afresh on restart.
+type Journal struct {
+	config Config
+
+	mu       sync.RWMutex
+	nodes    map[string][]feed.Event
+	users    map[string][]feed.Event
+	archived map[string]archivedNode
+}
+
+// archivedNode is a node's final record and when it was archived
+type archivedNode struct {
+	node       node.Node
+	archivedAt time.Time
+}
+
+// New creates an empty journal
+func New(config Config) *Journal {
+	return &Journal{
+		config:   config,
+		nodes:    make(map[string][]feed.Event),
+		users:    make(map[string][]feed.Event),
+		archived: make(map[string]archivedNode),
+	}
+}
+
+// Record journals an event under its node and user. An allocation moving a
+// user off a node is journaled under that node too.
+func (j *Journal) Record(e feed.Event) {
+	if e.Timestamp == 0 {
+		e.Timestamp = time.Now().UnixMilli()
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if e.NodeID != "" {
+		j.nodes[e.NodeID] = j.append(j.nodes[e.NodeID], e)
+	}
+	if a, ok := e.Data.(feed.Allocation); ok && a.PreviousNodeID != "" && a.PreviousNodeID != e.NodeID {
+		j.nodes[a.PreviousNodeID] = j.append(j.nodes[a.PreviousNodeID], e)
+	}
+	if e.UserID != "" {
+		j.users[e.UserID] = j.append(j.users[e.UserID], e)
+	}
+}
+
+func (j *Journal) append(events []feed.Event, e feed.Event) []feed.Event {
+	events = append(events, e)
+	if over := len(events) - j.config.MaxEvents; j.config.MaxEvents > 0 && over > 0 {
+		events = events[over:]
+	}
+	return events
+}
+
+// Node returns the events journaled for a node, oldest first
+func (j *Journal) Node(nodeID string) []feed.Event {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.since(j.nodes[nodeID])
+}
+
+// User returns the events journaled for a user, oldest first
+func (j *Journal) User(userID string) []feed.Event {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.since(j.users[userID])
+}
+
+// ArchiveNode keeps the final record of a node purged from the pool
+func (j *Journal) ArchiveNode(n node.Node) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.archived[n.ID] = archivedNode{node: n, archivedAt: time.Now()}
+}
+
+// ArchivedNode returns the final record of a node purged from the pool, if
+// it is still within retention
+func (j *Journal) ArchivedNode(nodeID string) (node.Node, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	a, ok := j.archived[nodeID]
+	if !ok || !a.archivedAt.After(time.Now().Add(-j.config.Retention)) {
+		return node.Node{}, false
+	}
+	return a.node, true
+}
+
+// since copies the events still within retention
+func (j *Journal) since(events []feed.Event) []feed.Event {
+	cutoff := time.Now().Add(-j.config.Retention).UnixMilli()
+	result := make([]feed.Event, 0, len(events))
+	for _, e := range events {
+		if e.Timestamp > cutoff {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Run journals the events delivered to sub until ctx is cancelled, pruning
+// expired events every minute. The caller subscribes, so no event published
+// before Run starts is missed.
+func (j *Journal) Run(ctx context.Context, sub *feed.Subscription) error {
+	defer sub.Close()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-sub.Events():
+			if !ok {
+				return nil
+			}
+			j.Record(e)
+		case <-ticker.C:
+			j.prune()
+		}
+	}
+}
+
+// prune drops events and archived nodes past retention, and the nodes and
+// users left with no events
+func (j *Journal) prune() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	cutoff := time.Now().Add(-j.config.Retention)
+	for id, a := range j.archived {
+		if !a.archivedAt.After(cutoff) {
+			delete(j.archived, id)
+		}
+	}
+
+	for _, index := range []map[string][]feed.Event{j.nodes, j.users} {
+		for id, events := range index {
+			if kept := j.since(events); len(kept) > 0 {
+				index[id] = kept
+			} else {
+				delete(index, id)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Stage identifies a point in a node's lifecycle at which hooks run
+type Stage string
+
+const (
+	// StagePreReady runs before a booted node is offered to users; a failure
+	// keeps the node out of the pool and terminates it
+	StagePreReady Stage = "pre_ready"
+
+	// StagePostAllocate runs after a node is allocated, before the user is
+	// told where to connect
+	StagePostAllocate Stage = "post_allocate"
+
+	// StagePreTerminate runs before a node is terminated
+	StagePreTerminate Stage = "pre_terminate"
+)
+
+// Target describes the node a hook runs against
+type Target struct {
+	NodeID   string `json:"node_id"`
+	UserID   string `json:"user_id,omitempty"`
+	Address  string `json:"address,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	Port     int    `json:"port,omitempty"`
+}
+
+// Hook is a single custom bootstrap or cleanup step
+type Hook interface {
+	Name() string
+	Run(ctx context.Context, stage Stage, target Target) error
+}
+
+// Manager runs the hooks configured for each lifecycle stage
+type Manager struct {
+	hooks  map[Stage][]Hook
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	pending map[string]struct{} // Nodes with pre-ready hooks in progress
+}
+
+// NewManager creates a lifecycle manager for the given hooks
+func NewManager(hooks map[Stage][]Hook, logger *zap.Logger) *Manager {
+	return &Manager{
+		hooks:   hooks,
+		logger:  logger,
+		pending: make(map[string]struct{}),
+	}
+}
+
+// Has reports whether any hooks are configured for a stage
+func (m *Manager) Has(stage Stage) bool {
+	return len(m.hooks[stage]) > 0
+}
+
+// Run executes a stage's hooks in order, stopping at the first failure
+func (m *Manager) Run(ctx context.Context, stage Stage, target Target) error {
+	for _, hook := range m.hooks[stage] {
+		m.logger.Debug("running lifecycle hook",
+			zap.String("stage", string(stage)),
+			zap.String("hook", hook.Name()),
+			zap.String("node_id", target.NodeID),
+		)
+
+		if err := hook.Run(ctx, stage, target); err != nil {
+			return fmt.Errorf("%s hook %q: %w", stage, hook.Name(), err)
+		}
+	}
+	return nil
+}
+
+// BeginPreReady marks a node's pre-ready hooks as started, returning false if
+// they are already running so repeated ready events don't start them twice
+func (m *Manager) BeginPreReady(nodeID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.pending[nodeID]; ok {
+		return false
+	}
+	m.pending[nodeID] = struct{}{}
+	return true
+}
+
+// EndPreReady marks a node's pre-ready hooks as finished
+func (m *Manager) EndPreReady(nodeID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.pending, nodeID)
+}
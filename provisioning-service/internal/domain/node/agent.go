@@ -0,0 +1,72 @@
+package node
+
+import (
+	"strconv"
+	"strings"
+)
+
+// AgentCompatibility describes which node agent versions may serve sessions
+type AgentCompatibility struct {
+	// MinVersion is the lowest supported agent version (empty disables the check)
+	MinVersion string
+
+	// BlockedVersions lists specific agent versions known to be incompatible
+	BlockedVersions []string
+}
+
+// IsCompatible reports whether an agent version can be allocated to users.
+// Nodes that have not reported a version are treated as compatible.
+func (c AgentCompatibility) IsCompatible(version string) bool {
+	if version == "" {
+		return true
+	}
+
+	for _, blocked := range c.BlockedVersions {
+		if normalizeVersion(blocked) == normalizeVersion(version) {
+			return false
+		}
+	}
+
+	if c.MinVersion == "" {
+		return true
+	}
+
+	return compareVersions(version, c.MinVersion) >= 0
+}
+
+func normalizeVersion(v string) string {
+	return strings.TrimPrefix(strings.TrimSpace(v), "v")
+}
+
+// compareVersions compares dotted numeric versions, returning -1, 0 or 1
+func compareVersions(a, b string) int {
+	as := strings.Split(normalizeVersion(a), ".")
+	bs := strings.Split(normalizeVersion(b), ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var av, bv int
+		if i < len(as) {
+			av = versionPart(as[i])
+		}
+		if i < len(bs) {
+			bv = versionPart(bs[i])
+		}
+		if av < bv {
+			return -1
+		}
+		if av > bv {
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionPart parses the leading numeric portion of a version segment
+func versionPart(s string) int {
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+	n, _ := strconv.Atoi(s[:end])
+	return n
+}
@@ -0,0 +1,80 @@
+package node
+
+import "time"
+
+// Dedication names the user or tenant a node is held for. Unlike a soft
+// reservation it does not expire: the node waits until a user it covers
+// takes it. The zero value holds a node for no one.
+type Dedication struct {
+	UserID   string
+	TenantID string
+}
+
+// IsZero reports whether the dedication names no one
+func (d Dedication) IsZero() bool {
+	return d.UserID == "" && d.TenantID == ""
+}
+
+// String returns the dedication as "user:<id>" or "tenant:<id>"
+func (d Dedication) String() string {
+	switch {
+	case d.UserID != "":
+		return "user:" + d.UserID
+	case d.TenantID != "":
+		return "tenant:" + d.TenantID
+	default:
+		return ""
+	}
+}
+
+// Covers reports whether a user of a tenant may take a node held under the
+// dedication
+func (d Dedication) Covers(userID, tenantID string) bool {
+	if d.UserID != "" {
+		return d.UserID == userID
+	}
+	return d.TenantID != "" && d.TenantID == tenantID
+}
+
+// Dedicate holds a free ready node for a dedicated user or tenant,
+// returning it or nil if none is free. Nodes soft-reserved for a user are
+// left alone.
+func (p *NodePool) Dedicate(d Dedication) *Node {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for node := range p.withStatus(NodeStatusReady) {
+		if p.isReservable(node) && !node.isReserved(now) {
+			node.Dedicated = d
+			node.UpdatedAt = now
+			return node
+		}
+	}
+	return nil
+}
+
+// SetDedicated holds a node for a dedicated user or tenant, typically one
+// provisioned for them that is still booting
+func (p *NodePool) SetDedicated(nodeID string, d Dedication) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if node, ok := p.nodes[nodeID]; ok {
+		node.Dedicated = d
+	}
+}
+
+// HasDedicated reports whether a schedulable ready node or a booting node
+// is held for a dedicated user or tenant
+func (p *NodePool) HasDedicated(d Dedication) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for node := range p.withStatus(NodeStatusReady, NodeStatusBooting) {
+		if node.Dedicated == d && (node.Status == NodeStatusBooting || p.isSchedulable(node)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,14 @@
+package node
+
+// Diagnostics is what a provider reports about a node, collected when it
+// fails to boot so image problems can be told apart from capacity problems
+type Diagnostics struct {
+	Status        string `json:"status,omitempty"`         // The provider's view of the node, e.g. pending or running
+	StatusMessage string `json:"status_message,omitempty"` // Last status message, e.g. a scheduling or capacity error
+	ConsoleOutput string `json:"console_output,omitempty"` // Tail of the node's console output
+}
+
+// IsZero reports whether no diagnostics were collected
+func (d Diagnostics) IsZero() bool {
+	return d == Diagnostics{}
+}
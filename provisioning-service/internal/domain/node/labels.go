@@ -0,0 +1,52 @@
+package node
+
+import (
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Labels are key/value pairs describing a node, such as its region or
+// driver version. The same type selects nodes on connect.
+type Labels map[string]string
+
+// Matches reports whether every key in the selector has the same value in
+// the labels; an empty selector matches any node
+func (l Labels) Matches(selector Labels) bool {
+	for key, value := range selector {
+		if got, ok := l[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the labels as sorted key=value pairs, e.g. "driver=535,region=eu"
+func (l Labels) String() string {
+	keys := slices.Sorted(maps.Keys(l))
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+l[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Selector returns a filter matching nodes whose labels match the selector
+func Selector(selector Labels) Filter {
+	if len(selector) == 0 {
+		return nil
+	}
+	return func(n *Node) bool {
+		return n.Labels.Matches(selector)
+	}
+}
+
+// SetLabels replaces a node's labels
+func (p *NodePool) SetLabels(nodeID string, labels Labels) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if node, ok := p.nodes[nodeID]; ok {
+		node.Labels = maps.Clone(labels)
+	}
+}
@@ -1,10 +1,50 @@
 package node
 
 import (
+	"cmp"
+	"iter"
+	"maps"
+	"slices"
 	"sync"
 	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
 )
 
+// ErrNoCapacity is returned by a node provider that has no capacity left
+// for the nodes asked for
+var ErrNoCapacity = errcode.New(errcode.NoCapacity, "no capacity for node")
+
+// ErrNotFound is returned by a node provider asked about a node it does not
+// have, because it never created it or the node is gone
+var ErrNotFound = errcode.New(errcode.NotFound, "provider has no such node")
+
+// CapacityError is a no-capacity error naming where capacity ran out, as far
+// as the provider reports it. It matches ErrNoCapacity.
+type CapacityError struct {
+	InstanceType string // Empty if not reported
+	Zone         string // Empty if not reported
+	Message      string
+}
+
+func (e *CapacityError) Error() string {
+	msg := "no capacity"
+	if e.InstanceType != "" {
+		msg += " for " + e.InstanceType
+	}
+	if e.Zone != "" {
+		msg += " in zone " + e.Zone
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+func (e *CapacityError) Unwrap() error {
+	return ErrNoCapacity
+}
+
 // NodeStatus represents the state of a node
 type NodeStatus string
 
@@ -13,27 +53,189 @@ const (
 	NodeStatusReady      NodeStatus = "ready"
 	NodeStatusAllocated  NodeStatus = "allocated"
 	NodeStatusTerminated NodeStatus = "terminated"
+
+	// NodeStatusUnconfirmed is held while every user on a node has yet to
+	// confirm attaching to it
+	NodeStatusUnconfirmed NodeStatus = "unconfirmed"
+
+	// NodeStatusTerminating is held while a termination request is in flight
+	NodeStatusTerminating NodeStatus = "terminating"
 )
 
+// Endpoint holds the connection details a user needs to reach a node
+type Endpoint struct {
+	Address   string // IP address
+	Hostname  string // DNS name, if assigned
+	Port      int
+	AuthToken string
+}
+
+// TerminationReason is why a node was terminated, or is being drained
+type TerminationReason string
+
+const (
+	TerminationIdle         TerminationReason = "idle"          // Ready past its idle timeout
+	TerminationBurstIdle    TerminationReason = "burst_idle"    // A burst node ready past its shorter idle timeout
+	TerminationScaleDown    TerminationReason = "scale_down"    // Retired as surplus to forecast demand
+	TerminationBudget       TerminationReason = "budget"        // Retired while the pool ran above max_hourly_spend
+	TerminationStuck        TerminationReason = "stuck"         // Still booting past its boot timeout
+	TerminationFailedChecks TerminationReason = "failed_checks" // Failed its pre-ready hooks
+	TerminationIncompatible TerminationReason = "incompatible"  // Runs an agent version that is not allowed
+	TerminationRotated      TerminationReason = "rotated"       // Reached the maximum node age
+	TerminationAdmin        TerminationReason = "admin"         // Terminated or drained by an operator, or left by a user they deallocated or reassigned
+	TerminationIdleReclaim  TerminationReason = "idle_reclaim"  // Left by a user whose idle allocation was reclaimed
+	TerminationMigrated     TerminationReason = "migrated"      // Left by a user whose migration was not acknowledged
+	TerminationInterruption TerminationReason = "interruption"  // Reported terminated without being asked, e.g. a spot reclaim
+	TerminationDrift        TerminationReason = "drift"         // Left by a user the consistency check found allocated another node
+	TerminationVanished     TerminationReason = "vanished"      // Gone from its provider while the service was down
+	TerminationDecommission TerminationReason = "decommission"  // Drained with the whole service
+	TerminationImport       TerminationReason = "import"        // Left out of an imported state
+)
+
+// Utilization is a resource usage sample reported by a node
+type Utilization struct {
+	GPUPercent    float64
+	MemoryPercent float64
+	ReportedAt    time.Time
+}
+
 // Node represents a GPU node in the system
 type Node struct {
-	ID        string
-	Status    NodeStatus
-	UserID    string // Empty if not allocated
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID           string
+	Status       NodeStatus
+	Users        []string // Users on the node in allocation order; empty if not allocated
+	Capacity     int      // Users the node hosts at once; 0 or 1 for a dedicated node
+	AgentVersion string   // Empty if not reported
+	InstanceType string   // Empty if not reported
+	PoolType     string   // Instance type whose pool asked for the node when it spilled over to another; empty otherwise
+	Labels       Labels   // Reported by the node, or requested when it was provisioned
+	Provider     string   // Provider that created the node; empty if first seen in a status event
+	Zone         string   // Zone the node was requested in; empty if the provider chose
+	Purchase     string   // reserved|spot|on_demand as requested; empty if the provider chose
+	Endpoint     Endpoint
+	Cordoned     bool // Excluded from new allocations
+	Draining     bool // Terminate once the last user disconnects
+	BootAttempt  int  // 1 for a fresh node, incremented for each replacement of a node that failed to boot
+	Burst        bool // Provisioned above its pool's max ready nodes during a demand spike
+
+	// Capacity was reported by the node rather than taken from its
+	// instance type's policy, and is kept when the node reports its type
+	CapacityReported bool
+
+	// Held for a dedicated user or tenant until one of their users takes it
+	Dedicated Dedication
+
+	// Users on the node yet to confirm attaching, with when their slot lapses
+	Pending map[string]time.Time
+
+	// Latest utilization sample; zero if the node reports none
+	Utilization Utilization
+
+	// When the node last took a user or reported a busy sample, from which
+	// an allocated node is judged idle
+	BusyAt time.Time
+
+	// Soft reservation for a user predicted to connect; expires harmlessly
+	ReservedFor   string
+	ReservedUntil time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+
+	// Why the node was terminated, or is draining; empty otherwise
+	TerminationReason TerminationReason
+	TerminatedAt      time.Time
+}
+
+// Slots returns the number of users the node can host at once
+func (n *Node) Slots() int {
+	if n.Capacity < 1 {
+		return 1
+	}
+	return n.Capacity
+}
+
+// FreeSlots returns the number of further users the node can host
+func (n *Node) FreeSlots() int {
+	if free := n.Slots() - len(n.Users); free > 0 {
+		return free
+	}
+	return 0
+}
+
+// User returns the first user on the node, or "" if it has none
+func (n *Node) User() string {
+	if len(n.Users) == 0 {
+		return ""
+	}
+	return n.Users[0]
+}
+
+// HasUser reports whether a user is on the node
+func (n *Node) HasUser(userID string) bool {
+	return slices.Contains(n.Users, userID)
+}
+
+// IsPending reports whether a user on a node has yet to confirm attaching
+func (n *Node) IsPending(userID string) bool {
+	_, ok := n.Pending[userID]
+	return ok
 }
 
-// NodePool manages the collection of nodes
+// Occupied reports whether the node hosts users, confirmed or not
+func (n *Node) Occupied() bool {
+	return n.Status == NodeStatusAllocated || n.Status == NodeStatusUnconfirmed
+}
+
+// occupiedStatus returns the status of a node with users: unconfirmed until one
+// of them confirms, allocated from then on
+func (n *Node) occupiedStatus() NodeStatus {
+	if len(n.Pending) == len(n.Users) {
+		return NodeStatusUnconfirmed
+	}
+	return NodeStatusAllocated
+}
+
+// NodePool manages the collection of nodes. Nodes are also indexed by
+// status and by status and instance type, kept in step as nodes change, so
+// counts are constant time and queries for a status only visit its nodes.
+// Nodes not terminated are further counted by instance type and purchase
+// option.
 type NodePool struct {
-	mu    sync.RWMutex
-	nodes map[string]*Node
+	mu         sync.RWMutex
+	nodes      map[string]*Node
+	byStatus   map[NodeStatus]map[string]*Node
+	byType     map[typeKey]map[string]*Node
+	byPurchase map[purchaseKey]int
+	compat     AgentCompatibility
+}
+
+// typeKey buckets the nodes of a status by reported instance type
+type typeKey struct {
+	status       NodeStatus
+	instanceType string
 }
 
+// purchaseKey counts the nodes not terminated of an instance type bought
+// with a purchase option
+type purchaseKey struct {
+	instanceType string
+	purchase     string
+}
+
+// Statuses of nodes that may take a user
+var (
+	schedulableStatuses = []NodeStatus{NodeStatusReady, NodeStatusAllocated, NodeStatusUnconfirmed}
+	occupiedStatuses    = []NodeStatus{NodeStatusAllocated, NodeStatusUnconfirmed}
+)
+
 // NewNodePool creates a new node pool
-func NewNodePool() *NodePool {
+func NewNodePool(compat AgentCompatibility) *NodePool {
 	return &NodePool{
-		nodes: make(map[string]*Node),
+		nodes:      make(map[string]*Node),
+		byStatus:   make(map[NodeStatus]map[string]*Node),
+		byType:     make(map[typeKey]map[string]*Node),
+		byPurchase: make(map[purchaseKey]int),
+		compat:     compat,
 	}
 }
 
@@ -41,7 +243,101 @@ func NewNodePool() *NodePool {
 func (p *NodePool) Add(node *Node) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.put(node)
+}
+
+// put adds a node to the pool and its indexes, replacing any node with the
+// same ID; caller must hold the lock
+func (p *NodePool) put(node *Node) {
+	if old, ok := p.nodes[node.ID]; ok {
+		p.unindex(old)
+	}
 	p.nodes[node.ID] = node
+	p.index(node)
+}
+
+// index adds a node to the buckets of its status and instance type; caller
+// must hold the lock
+func (p *NodePool) index(node *Node) {
+	addToBucket(p.byStatus, node.Status, node)
+	addToBucket(p.byType, typeKey{node.Status, node.InstanceType}, node)
+	if node.Status != NodeStatusTerminated && node.Purchase != "" {
+		p.byPurchase[purchaseKey{node.InstanceType, node.Purchase}]++
+	}
+}
+
+// unindex removes a node from the buckets of its status and instance type;
+// caller must hold the lock
+func (p *NodePool) unindex(node *Node) {
+	removeFromBucket(p.byStatus, node.Status, node.ID)
+	removeFromBucket(p.byType, typeKey{node.Status, node.InstanceType}, node.ID)
+	if node.Status != NodeStatusTerminated && node.Purchase != "" {
+		key := purchaseKey{node.InstanceType, node.Purchase}
+		if p.byPurchase[key]--; p.byPurchase[key] <= 0 {
+			delete(p.byPurchase, key)
+		}
+	}
+}
+
+// setStatus changes a node's status and moves it to the matching buckets;
+// caller must hold the lock
+func (p *NodePool) setStatus(node *Node, status NodeStatus) {
+	if node.Status == status {
+		return
+	}
+	p.unindex(node)
+	node.Status = status
+	p.index(node)
+}
+
+func addToBucket[K comparable](index map[K]map[string]*Node, key K, node *Node) {
+	bucket, ok := index[key]
+	if !ok {
+		bucket = make(map[string]*Node)
+		index[key] = bucket
+	}
+	bucket[node.ID] = node
+}
+
+func removeFromBucket[K comparable](index map[K]map[string]*Node, key K, nodeID string) {
+	bucket, ok := index[key]
+	if !ok {
+		return
+	}
+	delete(bucket, nodeID)
+	if len(bucket) == 0 {
+		delete(index, key)
+	}
+}
+
+// withStatus yields the nodes of the given statuses; caller must hold the
+// lock and not change node statuses while iterating
+func (p *NodePool) withStatus(statuses ...NodeStatus) iter.Seq[*Node] {
+	return func(yield func(*Node) bool) {
+		for _, status := range statuses {
+			for _, node := range p.byStatus[status] {
+				if !yield(node) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// live yields the nodes not terminated; caller must hold the lock
+func (p *NodePool) live() iter.Seq[*Node] {
+	return func(yield func(*Node) bool) {
+		for status, bucket := range p.byStatus {
+			if status == NodeStatusTerminated {
+				continue
+			}
+			for _, node := range bucket {
+				if !yield(node) {
+					return
+				}
+			}
+		}
+	}
 }
 
 // Get retrieves a node by ID
@@ -56,62 +352,370 @@ func (p *NodePool) Get(nodeID string) (*Node, bool) {
 func (p *NodePool) Remove(nodeID string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	delete(p.nodes, nodeID)
+	if node, ok := p.nodes[nodeID]; ok {
+		p.unindex(node)
+		delete(p.nodes, nodeID)
+	}
+}
+
+// PurgeTerminated removes the terminated nodes last updated before before,
+// returning copies of them
+func (p *NodePool) PurgeTerminated(before time.Time) []Node {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var purged []Node
+	for _, node := range p.byStatus[NodeStatusTerminated] {
+		if node.UpdatedAt.Before(before) {
+			purged = append(purged, *node)
+		}
+	}
+	for i := range purged {
+		p.unindex(p.nodes[purged[i].ID])
+		delete(p.nodes, purged[i].ID)
+	}
+	return purged
 }
 
 // GetAllByStatus returns all nodes with a specific status
 func (p *NodePool) GetAllByStatus(status NodeStatus) []*Node {
+	return p.GetAllByStatusWhere(status, nil)
+}
+
+// GetReadyNode returns a node with a free slot for a user of a tenant,
+// preferring one reserved for them, then one held for them as a dedicated
+// user or tenant, then the fullest shared node so users are packed onto as
+// few nodes as possible. It never returns a node actively reserved or held
+// for someone else, or one the user is already on.
+func (p *NodePool) GetReadyNode(userID, tenantID string) *Node {
+	return p.GetReadyNodeMatching(userID, tenantID, nil)
+}
+
+// GetReadyNodeMatching is GetReadyNode limited to nodes whose labels match
+// the selector, skipping the given nodes
+func (p *NodePool) GetReadyNodeMatching(userID, tenantID string, selector Labels, exclude ...string) *Node {
+	return p.GetReadyNodePreferring(userID, tenantID, selector, "", "", exclude...)
+}
+
+// GetReadyNodePreferring is GetReadyNodeMatching preferring shared and free
+// nodes of an instance type over those of any other. Nodes reserved for the
+// user come first whatever their type, then lastNodeID, the node the user
+// last had, then nodes held for them.
+func (p *NodePool) GetReadyNodePreferring(userID, tenantID string, selector Labels, instanceType, lastNodeID string, exclude ...string) *Node {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var last, dedicated, shared, fallback *Node
+	var preferredShared, preferredFallback *Node
+	for node := range p.withStatus(schedulableStatuses...) {
+		if !p.isSchedulable(node) || node.HasUser(userID) || slices.Contains(exclude, node.ID) ||
+			!node.Labels.Matches(selector) {
+			continue
+		}
+		if node.isReservedFor(userID, now) {
+			return node
+		}
+		if node.isReserved(now) {
+			continue
+		}
+		if node.ID == lastNodeID && (node.Dedicated.IsZero() || node.Dedicated.Covers(userID, tenantID)) {
+			last = node
+			continue
+		}
+		if !node.Dedicated.IsZero() {
+			if dedicated == nil && node.Dedicated.Covers(userID, tenantID) {
+				dedicated = node
+			}
+			continue
+		}
+		sharedPick, fallbackPick := &shared, &fallback
+		if instanceType != "" && node.InstanceType == instanceType {
+			sharedPick, fallbackPick = &preferredShared, &preferredFallback
+		}
+		if len(node.Users) > 0 {
+			if *sharedPick == nil || node.FreeSlots() < (*sharedPick).FreeSlots() {
+				*sharedPick = node
+			}
+		} else if *fallbackPick == nil {
+			*fallbackPick = node
+		}
+	}
+	if last != nil {
+		return last
+	}
+	if dedicated != nil {
+		return dedicated
+	}
+	if preferredShared != nil {
+		return preferredShared
+	}
+	if preferredFallback != nil {
+		return preferredFallback
+	}
+	if shared != nil {
+		return shared
+	}
+	return fallback
+}
+
+// GetSharedNode returns the fullest occupied node with a free slot, whatever
+// its labels, skipping the given nodes and any the user is already on
+func (p *NodePool) GetSharedNode(userID string, exclude ...string) *Node {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var shared *Node
+	for node := range p.withStatus(occupiedStatuses...) {
+		if !p.isSchedulable(node) || node.HasUser(userID) ||
+			slices.Contains(exclude, node.ID) {
+			continue
+		}
+		if shared == nil || node.FreeSlots() < shared.FreeSlots() {
+			shared = node
+		}
+	}
+	return shared
+}
+
+// AllocateNode takes a slot on a node for a user of a tenant. A node held
+// for a dedicated user or tenant is only taken by a user it covers, and is
+// no longer held once taken.
+func (p *NodePool) AllocateNode(nodeID, userID, tenantID string) bool {
+	return p.AllocatePending(nodeID, userID, tenantID, time.Time{})
+}
+
+// AllocatePending is AllocateNode for a user who must Confirm attaching
+// before until, or be released by ExpirePending. A zero until takes the slot
+// outright.
+func (p *NodePool) AllocatePending(nodeID, userID, tenantID string, until time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node, ok := p.nodes[nodeID]
+	if !ok || !p.isSchedulable(node) || node.HasUser(userID) {
+		return false
+	}
+
+	now := time.Now()
+	if node.isReserved(now) && !node.isReservedFor(userID, now) {
+		return false
+	}
+	if !node.Dedicated.IsZero() && !node.Dedicated.Covers(userID, tenantID) {
+		return false
+	}
+
+	// Replaced rather than appended to, so callers holding the node see a
+	// consistent list
+	node.Users = append(slices.Clip(node.Users), userID)
+	if !until.IsZero() {
+		pending := maps.Clone(node.Pending)
+		if pending == nil {
+			pending = make(map[string]time.Time, 1)
+		}
+		pending[userID] = until
+		node.Pending = pending
+	}
+	p.setStatus(node, node.occupiedStatus())
+	node.ReservedFor = ""
+	node.ReservedUntil = time.Time{}
+	node.Dedicated = Dedication{}
+	node.BusyAt = now
+	node.UpdatedAt = now
+	return true
+}
+
+// Reserve soft-reserves a free ready node for a user until the given time,
+// returning the reserved node or nil if none is available. An existing
+// reservation for the user is extended rather than duplicated; the boolean
+// reports whether the reservation is new. Only nodes without users are
+// reserved.
+func (p *NodePool) Reserve(userID string, until time.Time) (*Node, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var candidate *Node
+	for node := range p.withStatus(NodeStatusReady) {
+		if !p.isReservable(node) {
+			continue
+		}
+		if node.isReservedFor(userID, now) {
+			node.ReservedUntil = until
+			return node, false
+		}
+		if candidate == nil && !node.isReserved(now) {
+			candidate = node
+		}
+	}
+
+	if candidate == nil {
+		return nil, false
+	}
+	candidate.ReservedFor = userID
+	candidate.ReservedUntil = until
+	return candidate, true
+}
+
+// ReserveNode soft-reserves a specific free ready node for a user, reporting
+// whether it did
+func (p *NodePool) ReserveNode(nodeID, userID string, until time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node, ok := p.nodes[nodeID]
+	if !ok || !p.isReservable(node) || node.isReserved(time.Now()) {
+		return false
+	}
+
+	node.ReservedFor = userID
+	node.ReservedUntil = until
+	return true
+}
+
+// HasReservation reports whether a user holds an active reservation
+func (p *NodePool) HasReservation(userID string) bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	var result []*Node
-	for _, node := range p.nodes {
-		if node.Status == status {
-			result = append(result, node)
+	now := time.Now()
+	for node := range p.withStatus(NodeStatusReady) {
+		if p.isReservable(node) && node.isReservedFor(userID, now) {
+			return true
 		}
 	}
-	return result
+	return false
+}
+
+// CountReserved returns the number of ready nodes with an active reservation
+func (p *NodePool) CountReserved() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	now := time.Now()
+	count := 0
+	for node := range p.withStatus(NodeStatusReady) {
+		if node.isReserved(now) {
+			count++
+		}
+	}
+	return count
+}
+
+// IsReserved reports whether the node holds an unexpired reservation
+func (n *Node) IsReserved() bool {
+	return n.isReserved(time.Now())
+}
+
+func (n *Node) isReserved(now time.Time) bool {
+	return n.ReservedFor != "" && now.Before(n.ReservedUntil)
+}
+
+func (n *Node) isReservedFor(userID string, now time.Time) bool {
+	return n.ReservedFor == userID && n.isReserved(now)
 }
 
-// GetReadyNode returns a ready node and marks it as allocated
-func (p *NodePool) GetReadyNode() *Node {
+// DeallocateNode releases a user's slot on a node, returning the node to the
+// ready pool once its last user has left
+func (p *NodePool) DeallocateNode(nodeID, userID string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for _, node := range p.nodes {
-		if node.Status == NodeStatusReady {
-			return node
-		}
+	// A node being terminated is not returned to the pool
+	if node, ok := p.nodes[nodeID]; ok && node.Occupied() {
+		p.release(node, userID)
+		node.UpdatedAt = time.Now()
 	}
-	return nil
 }
 
-// AllocateNode allocates a node to a user
-func (p *NodePool) AllocateNode(nodeID, userID string) bool {
+// DeallocateAndDrain releases a user's slot on a node and flags the node for
+// draining in one step, so the slot cannot be handed to another user in
+// between. Other users on a shared node keep their slots until they leave.
+func (p *NodePool) DeallocateAndDrain(nodeID, userID string, reason TerminationReason) bool {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	node, ok := p.nodes[nodeID]
-	if !ok || node.Status != NodeStatusReady {
+	if !ok {
 		return false
 	}
 
-	node.Status = NodeStatusAllocated
-	node.UserID = userID
+	if node.Occupied() {
+		p.release(node, userID)
+	}
+	node.drain(reason)
+	return true
+}
+
+// release removes a user from an occupied node, marking it ready once it is
+// empty; caller must hold the pool lock
+func (p *NodePool) release(n *Node, userID string) {
+	n.Users = slices.DeleteFunc(slices.Clone(n.Users), func(u string) bool {
+		return u == userID
+	})
+	if _, ok := n.Pending[userID]; ok {
+		n.Pending = maps.Clone(n.Pending)
+		delete(n.Pending, userID)
+	}
+	if len(n.Pending) == 0 {
+		n.Pending = nil
+	}
+	if len(n.Users) == 0 {
+		n.Users = nil
+		p.setStatus(n, NodeStatusReady)
+		return
+	}
+	p.setStatus(n, n.occupiedStatus())
+}
+
+// Confirm marks a pending user as attached to a node, reporting whether the
+// user was pending there
+func (p *NodePool) Confirm(nodeID, userID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node, ok := p.nodes[nodeID]
+	if !ok || !node.Occupied() {
+		return false
+	}
+	if _, pending := node.Pending[userID]; !pending {
+		return false
+	}
+
+	pending := maps.Clone(node.Pending)
+	delete(pending, userID)
+	if len(pending) == 0 {
+		pending = nil
+	}
+	node.Pending = pending
+	p.setStatus(node, NodeStatusAllocated)
 	node.UpdatedAt = time.Now()
 	return true
 }
 
-// DeallocateNode deallocates a node from a user
-func (p *NodePool) DeallocateNode(nodeID string) {
+// ExpirePending releases the slots of users who did not confirm in time and
+// returns the node each was released from by user ID. A node left without
+// users returns to the ready pool.
+func (p *NodePool) ExpirePending(now time.Time) map[string]string {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if node, ok := p.nodes[nodeID]; ok {
-		node.Status = NodeStatusReady
-		node.UserID = ""
-		node.UpdatedAt = time.Now()
+	// Collected first, as releasing users moves nodes between buckets
+	var expired map[string]string
+	for _, node := range slices.Collect(p.withStatus(NodeStatusUnconfirmed, NodeStatusAllocated)) {
+		for userID, until := range node.Pending {
+			if now.Before(until) {
+				continue
+			}
+			if expired == nil {
+				expired = make(map[string]string)
+			}
+			expired[userID] = node.ID
+			p.release(node, userID)
+			node.UpdatedAt = now
+		}
 	}
+	return expired
 }
 
 // UpdateStatus updates the status of a node
@@ -120,11 +724,234 @@ func (p *NodePool) UpdateStatus(nodeID string, status NodeStatus) {
 	defer p.mu.Unlock()
 
 	if node, ok := p.nodes[nodeID]; ok {
-		node.Status = status
+		p.setStatus(node, status)
 		node.UpdatedAt = time.Now()
 	}
 }
 
+// UpdateStatusIf updates the status of a node only if it is currently in the
+// given status, reporting whether it did
+func (p *NodePool) UpdateStatusIf(nodeID string, from, to NodeStatus) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node, ok := p.nodes[nodeID]
+	if !ok || node.Status != from {
+		return false
+	}
+
+	p.setStatus(node, to)
+	node.UpdatedAt = time.Now()
+	return true
+}
+
+// isSchedulable reports whether a node can accept a new user, either as a
+// ready node or as an occupied node with a free slot; caller must hold the lock
+func (p *NodePool) isSchedulable(node *Node) bool {
+	return (node.Status == NodeStatusReady ||
+		node.Occupied() && node.FreeSlots() > 0) &&
+		!node.Cordoned &&
+		p.compat.IsCompatible(node.AgentVersion)
+}
+
+// isReservable reports whether a node can be reserved; only nodes without
+// users that are not held for a dedicated user or tenant are. Caller must
+// hold the lock.
+func (p *NodePool) isReservable(node *Node) bool {
+	return node.Status == NodeStatusReady && p.isSchedulable(node) && node.Dedicated.IsZero()
+}
+
+// CountSchedulable returns the number of ready nodes that can accept a new user
+func (p *NodePool) CountSchedulable() int {
+	return p.CountSchedulableWhere(nil)
+}
+
+// SetCordoned marks a node as cordoned (or not), returning false if the node is unknown
+func (p *NodePool) SetCordoned(nodeID string, cordoned bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node, ok := p.nodes[nodeID]
+	if !ok {
+		return false
+	}
+
+	node.Cordoned = cordoned
+	if !cordoned {
+		node.Draining = false
+		node.TerminationReason = ""
+	}
+	node.UpdatedAt = time.Now()
+	return true
+}
+
+// MarkDraining cordons a node and flags it for termination once it is free,
+// returning false if the node is unknown
+func (p *NodePool) MarkDraining(nodeID string, reason TerminationReason) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node, ok := p.nodes[nodeID]
+	if !ok {
+		return false
+	}
+
+	node.drain(reason)
+	return true
+}
+
+// drain cordons the node and flags it for termination, keeping the reason
+// it was first drained for; caller must hold the pool lock
+func (n *Node) drain(reason TerminationReason) {
+	if !n.Draining || n.TerminationReason == "" {
+		n.TerminationReason = reason
+	}
+	n.Cordoned = true
+	n.Draining = true
+	n.UpdatedAt = time.Now()
+}
+
+// MarkTerminated records a node as terminated and why
+func (p *NodePool) MarkTerminated(nodeID string, reason TerminationReason) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if node, ok := p.nodes[nodeID]; ok {
+		now := time.Now()
+		p.setStatus(node, NodeStatusTerminated)
+		node.TerminationReason = reason
+		node.TerminatedAt = now
+		node.UpdatedAt = now
+	}
+}
+
+// GetDrainingNodes returns non-terminated nodes flagged for draining
+func (p *NodePool) GetDrainingNodes() []*Node {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var result []*Node
+	for node := range p.live() {
+		if node.Draining {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// SetAgentVersion records the agent version reported by a node
+func (p *NodePool) SetAgentVersion(nodeID, version string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if node, ok := p.nodes[nodeID]; ok {
+		node.AgentVersion = version
+	}
+}
+
+// MarkBurst records that a node was provisioned above its pool's max ready
+// nodes, so it is retired before the rest of the pool
+func (p *NodePool) MarkBurst(nodeID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if node, ok := p.nodes[nodeID]; ok {
+		node.Burst = true
+	}
+}
+
+// ClearBurst records that a node's pool is back within its max ready nodes,
+// so the node is no longer retired before the rest
+func (p *NodePool) ClearBurst(nodeID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if node, ok := p.nodes[nodeID]; ok {
+		node.Burst = false
+	}
+}
+
+// SetInstanceType records the instance type reported by a node
+func (p *NodePool) SetInstanceType(nodeID, instanceType string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if node, ok := p.nodes[nodeID]; ok && node.InstanceType != instanceType {
+		p.unindex(node)
+		node.InstanceType = instanceType
+		p.index(node)
+	}
+}
+
+// MergeEndpoint records the connection details reported by a node, keeping
+// those it left empty
+func (p *NodePool) MergeEndpoint(nodeID string, endpoint Endpoint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if node, ok := p.nodes[nodeID]; ok {
+		node.Endpoint.Address = cmp.Or(endpoint.Address, node.Endpoint.Address)
+		node.Endpoint.Hostname = cmp.Or(endpoint.Hostname, node.Endpoint.Hostname)
+		node.Endpoint.Port = cmp.Or(endpoint.Port, node.Endpoint.Port)
+		node.Endpoint.AuthToken = cmp.Or(endpoint.AuthToken, node.Endpoint.AuthToken)
+	}
+}
+
+// SetUtilization records a node's latest utilization sample, marking the
+// node busy as of the sample if busy is set. Samples older than the one
+// recorded are ignored. It reports whether the node exists.
+func (p *NodePool) SetUtilization(nodeID string, u Utilization, busy bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	node, ok := p.nodes[nodeID]
+	if !ok {
+		return false
+	}
+	if u.ReportedAt.Before(node.Utilization.ReportedAt) {
+		return true
+	}
+	node.Utilization = u
+	if busy && u.ReportedAt.After(node.BusyAt) {
+		node.BusyAt = u.ReportedAt
+	}
+	return true
+}
+
+// GetIncompatibleNodes returns non-terminated nodes running an unsupported agent version
+func (p *NodePool) GetIncompatibleNodes() []*Node {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var result []*Node
+	for node := range p.live() {
+		if !p.compat.IsCompatible(node.AgentVersion) {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// CountIncompatible returns the count of non-terminated nodes running an unsupported agent version
+func (p *NodePool) CountIncompatible() int {
+	return len(p.GetIncompatibleNodes())
+}
+
+// CountBurst returns the count of non-terminated nodes provisioned above
+// their pool's max ready nodes
+func (p *NodePool) CountBurst() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	count := 0
+	for node := range p.live() {
+		if node.Burst {
+			count++
+		}
+	}
+	return count
+}
+
 // Count returns the total number of nodes
 func (p *NodePool) Count() int {
 	p.mu.RLock()
@@ -136,14 +963,46 @@ func (p *NodePool) Count() int {
 func (p *NodePool) CountByStatus(status NodeStatus) int {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
+	return len(p.byStatus[status])
+}
 
-	count := 0
-	for _, node := range p.nodes {
-		if node.Status == status {
-			count++
+// CountByStatusOfType returns the count of nodes with a status that
+// reported an instance type; "" counts those that reported none
+func (p *NodePool) CountByStatusOfType(status NodeStatus, instanceType string) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.byType[typeKey{status, instanceType}])
+}
+
+// CountByPurchase returns the nodes not terminated by instance type and
+// purchase option, leaving out nodes whose option the provider chose
+func (p *NodePool) CountByPurchase() map[string]map[string]int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	counts := make(map[string]map[string]int)
+	for key, count := range p.byPurchase {
+		if counts[key.instanceType] == nil {
+			counts[key.instanceType] = make(map[string]int)
 		}
+		counts[key.instanceType][key.purchase] = count
 	}
-	return count
+	return counts
+}
+
+// InstanceTypes returns the instance types reported by nodes not
+// terminated, in order, with "" for nodes that reported none
+func (p *NodePool) InstanceTypes() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for key := range p.byType {
+		if key.status != NodeStatusTerminated {
+			seen[key.instanceType] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(seen))
 }
 
 // GetAll returns all nodes
@@ -157,3 +1016,161 @@ func (p *NodePool) GetAll() []*Node {
 	}
 	return result
 }
+
+// Snapshot returns copies of every node
+func (p *NodePool) Snapshot() []Node {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	result := make([]Node, 0, len(p.nodes))
+	for _, node := range p.nodes {
+		n := *node
+		n.Users = slices.Clone(node.Users)
+		n.Labels = maps.Clone(node.Labels)
+		n.Pending = maps.Clone(node.Pending)
+		result = append(result, n)
+	}
+	return result
+}
+
+// Restore adds the given nodes, skipping any the pool already knows, and
+// returns how many were added
+func (p *NodePool) Restore(nodes []Node) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	added := 0
+	for _, n := range nodes {
+		if _, exists := p.nodes[n.ID]; exists {
+			continue
+		}
+		p.put(&n)
+		added++
+	}
+	return added
+}
+
+// Replace makes the pool hold exactly the given nodes, as when mirroring
+// the pool replicated by the leading replica
+func (p *NodePool) Replace(nodes []Node) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nodes = make(map[string]*Node, len(nodes))
+	p.byStatus = make(map[NodeStatus]map[string]*Node)
+	p.byType = make(map[typeKey]map[string]*Node)
+	p.byPurchase = make(map[purchaseKey]int)
+	for _, n := range nodes {
+		p.put(&n)
+	}
+}
+
+// Filter selects a subset of nodes; a nil filter matches every node
+type Filter func(*Node) bool
+
+func (f Filter) matches(node *Node) bool {
+	return f == nil || f(node)
+}
+
+// GetAllByStatusWhere returns all nodes with a specific status that match the filter
+func (p *NodePool) GetAllByStatusWhere(status NodeStatus, filter Filter) []*Node {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var result []*Node
+	for node := range p.withStatus(status) {
+		if filter.matches(node) {
+			result = append(result, node)
+		}
+	}
+	return result
+}
+
+// CountByStatusWhere returns the count of nodes with a status that match the filter
+func (p *NodePool) CountByStatusWhere(status NodeStatus, filter Filter) int {
+	if filter == nil {
+		return p.CountByStatus(status)
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	count := 0
+	for node := range p.withStatus(status) {
+		if filter(node) {
+			count++
+		}
+	}
+	return count
+}
+
+// CountSchedulableWhere returns the number of schedulable ready nodes that
+// match the filter; allocated nodes with free slots are not counted
+func (p *NodePool) CountSchedulableWhere(filter Filter) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	count := 0
+	for node := range p.withStatus(NodeStatusReady) {
+		if p.isSchedulable(node) && filter.matches(node) {
+			count++
+		}
+	}
+	return count
+}
+
+// FreeSlotsWhere returns the number of users the schedulable nodes matching
+// the filter can still take, counting free slots on shared allocated nodes
+func (p *NodePool) FreeSlotsWhere(filter Filter) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	slots := 0
+	for node := range p.withStatus(schedulableStatuses...) {
+		if p.isSchedulable(node) && filter.matches(node) {
+			slots += node.FreeSlots()
+		}
+	}
+	return slots
+}
+
+// CountOccupiedWhere returns the number of allocated and unconfirmed nodes that
+// match the filter
+func (p *NodePool) CountOccupiedWhere(filter Filter) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	count := 0
+	for node := range p.withStatus(occupiedStatuses...) {
+		if filter.matches(node) {
+			count++
+		}
+	}
+	return count
+}
+
+// CountUsersWhere returns the number of users on occupied nodes that match the filter
+func (p *NodePool) CountUsersWhere(filter Filter) int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	count := 0
+	for node := range p.withStatus(occupiedStatuses...) {
+		if filter.matches(node) {
+			count += len(node.Users)
+		}
+	}
+	return count
+}
+
+// SetCapacity sets the number of users a node hosts at once, and whether
+// the node reported it
+func (p *NodePool) SetCapacity(nodeID string, capacity int, reported bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if node, ok := p.nodes[nodeID]; ok {
+		node.Capacity = capacity
+		node.CapacityReported = reported
+	}
+}
@@ -0,0 +1,159 @@
+package node
+
+import (
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// checkIndexes compares the pool's status, type and purchase counts with a
+// scan of every node
+func checkIndexes(t *testing.T, pool *NodePool, step string) {
+	t.Helper()
+	byStatus := make(map[NodeStatus]int)
+	byType := make(map[typeKey]int)
+	byPurchase := make(map[string]map[string]int)
+	for _, n := range pool.Snapshot() {
+		byStatus[n.Status]++
+		byType[typeKey{n.Status, n.InstanceType}]++
+		if n.Status != NodeStatusTerminated && n.Purchase != "" {
+			if byPurchase[n.InstanceType] == nil {
+				byPurchase[n.InstanceType] = make(map[string]int)
+			}
+			byPurchase[n.InstanceType][n.Purchase]++
+		}
+	}
+
+	statuses := []NodeStatus{NodeStatusBooting, NodeStatusReady, NodeStatusAllocated, NodeStatusUnconfirmed, NodeStatusTerminating, NodeStatusTerminated}
+	for _, status := range statuses {
+		if got := pool.CountByStatus(status); got != byStatus[status] {
+			t.Errorf("%s: CountByStatus(%s) = %d, scan has %d", step, status, got, byStatus[status])
+		}
+		for _, instanceType := range []string{"", "a100", "h100"} {
+			if got, want := pool.CountByStatusOfType(status, instanceType), byType[typeKey{status, instanceType}]; got != want {
+				t.Errorf("%s: CountByStatusOfType(%s, %q) = %d, scan has %d", step, status, instanceType, got, want)
+			}
+		}
+	}
+	got := pool.CountByPurchase()
+	if !maps.EqualFunc(got, byPurchase, maps.Equal) {
+		t.Errorf("%s: CountByPurchase = %v, scan has %v", step, got, byPurchase)
+	}
+
+	// Every indexed node is the one the pool holds under its ID
+	for status, bucket := range pool.byStatus {
+		for id, n := range bucket {
+			if held := pool.nodes[id]; held != n || n.Status != status {
+				t.Errorf("%s: %s indexed as %s is stale", step, id, status)
+			}
+		}
+	}
+}
+
+func TestIndexesFollowMutations(t *testing.T) {
+	now := time.Now()
+	pool := NewNodePool(AgentCompatibility{})
+
+	steps := []struct {
+		name   string
+		mutate func()
+	}{
+		{"add", func() {
+			pool.Add(&Node{ID: "n1", Status: NodeStatusBooting, InstanceType: "a100", Purchase: "spot"})
+			pool.Add(&Node{ID: "n2", Status: NodeStatusReady, InstanceType: "a100", Capacity: 2})
+			pool.Add(&Node{ID: "n3", Status: NodeStatusReady, Purchase: "on_demand"})
+		}},
+		{"add replacing", func() {
+			pool.Add(&Node{ID: "n1", Status: NodeStatusReady, InstanceType: "h100", Purchase: "on_demand"})
+		}},
+		{"update status", func() { pool.UpdateStatus("n3", NodeStatusBooting) }},
+		{"update status if", func() { pool.UpdateStatusIf("n3", NodeStatusBooting, NodeStatusReady) }},
+		{"set instance type", func() { pool.SetInstanceType("n3", "a100") }},
+		{"allocate", func() { pool.AllocateNode("n2", "u1", "") }},
+		{"allocate pending", func() { pool.AllocatePending("n2", "u2", "", now.Add(-time.Second)) }},
+		{"expire pending", func() { pool.ExpirePending(now) }},
+		{"allocate pending again", func() { pool.AllocatePending("n1", "u3", "", now.Add(time.Minute)) }},
+		{"confirm", func() { pool.Confirm("n1", "u3") }},
+		{"deallocate", func() { pool.DeallocateNode("n2", "u1") }},
+		{"reserve", func() { pool.Reserve("u4", now.Add(time.Minute)) }},
+		{"deallocate and drain", func() { pool.DeallocateAndDrain("n1", "u3", TerminationIdle) }},
+		{"terminate", func() { pool.MarkTerminated("n1", TerminationIdle) }},
+		{"restore", func() {
+			pool.Restore([]Node{{ID: "n1", Status: NodeStatusReady}, {ID: "n4", Status: NodeStatusBooting, InstanceType: "h100", Purchase: "spot"}})
+		}},
+		{"remove", func() { pool.Remove("n3") }},
+		{"purge", func() { pool.PurgeTerminated(time.Now().Add(time.Minute)) }},
+		{"replace", func() {
+			pool.Replace([]Node{
+				{ID: "n5", Status: NodeStatusAllocated, InstanceType: "a100", Users: []string{"u5"}, Purchase: "spot"},
+				{ID: "n6", Status: NodeStatusTerminated, InstanceType: "h100", Purchase: "spot"},
+			})
+		}},
+	}
+	for _, step := range steps {
+		step.mutate()
+		checkIndexes(t, pool, step.name)
+	}
+
+	if got := pool.CountByStatus(NodeStatusAllocated); got != 1 {
+		t.Errorf("CountByStatus(allocated) = %d after the replace, want 1", got)
+	}
+	if got := pool.InstanceTypes(); !slices.Equal(got, []string{"a100"}) {
+		t.Errorf("InstanceTypes = %v, want the live node's", got)
+	}
+}
+
+func TestUnconfirmedIsNotASoftReservation(t *testing.T) {
+	until := time.Now().Add(time.Minute)
+	pool := NewNodePool(AgentCompatibility{})
+	pool.Add(&Node{ID: "held", Status: NodeStatusReady})
+	pool.Add(&Node{ID: "earmarked", Status: NodeStatusReady})
+
+	if !pool.AllocatePending("held", "u1", "", until) || !pool.ReserveNode("earmarked", "u2", until) {
+		t.Fatal("allocation or reservation refused")
+	}
+
+	held, _ := pool.Get("held")
+	if held.Status != "unconfirmed" {
+		t.Errorf("unconfirmed node status = %q, want unconfirmed", held.Status)
+	}
+	if got := pool.CountReserved(); got != 1 {
+		t.Errorf("soft reservations = %d, want 1", got)
+	}
+	if got := pool.CountByStatus(NodeStatusUnconfirmed); got != 1 {
+		t.Errorf("unconfirmed nodes = %d, want 1", got)
+	}
+}
+
+func TestPurgeTerminated(t *testing.T) {
+	now := time.Now()
+	pool := NewNodePool(AgentCompatibility{})
+	pool.Replace([]Node{
+		{ID: "old", Status: NodeStatusTerminated, UpdatedAt: now.Add(-2 * time.Hour), TerminationReason: TerminationIdle},
+		{ID: "recent", Status: NodeStatusTerminated, UpdatedAt: now.Add(-time.Minute)},
+		{ID: "stale-ready", Status: NodeStatusReady, UpdatedAt: now.Add(-2 * time.Hour)},
+	})
+
+	purged := pool.PurgeTerminated(now.Add(-time.Hour))
+	if len(purged) != 1 || purged[0].ID != "old" || purged[0].TerminationReason != TerminationIdle {
+		t.Fatalf("purged = %+v, want the old terminated node", purged)
+	}
+	if _, ok := pool.Get("old"); ok {
+		t.Error("purged node still in the pool")
+	}
+	var left []string
+	for _, n := range pool.Snapshot() {
+		left = append(left, n.ID)
+	}
+	slices.Sort(left)
+	if !slices.Equal(left, []string{"recent", "stale-ready"}) {
+		t.Errorf("pool = %v, want the recent and ready nodes", left)
+	}
+	if got := pool.CountByStatus(NodeStatusTerminated); got != 1 {
+		t.Errorf("CountByStatus(terminated) = %d, want 1", got)
+	}
+	if purged := pool.PurgeTerminated(now.Add(-time.Hour)); len(purged) != 0 {
+		t.Errorf("second purge = %+v, want none", purged)
+	}
+}
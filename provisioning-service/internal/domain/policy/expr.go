@@ -0,0 +1,415 @@
+package policy
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expr is a compiled expression; booleans evaluate to 1 and 0
+type expr interface {
+	eval(env Env) float64
+}
+
+type number float64
+
+func (n number) eval(Env) float64 { return float64(n) }
+
+type variable string
+
+func (v variable) eval(env Env) float64 { return env[string(v)] }
+
+type unary struct {
+	op string
+	x  expr
+}
+
+func (u unary) eval(env Env) float64 {
+	x := u.x.eval(env)
+	if u.op == "not" {
+		return truth(x == 0)
+	}
+	return -x
+}
+
+type binary struct {
+	op   string
+	x, y expr
+}
+
+func (b binary) eval(env Env) float64 {
+	x := b.x.eval(env)
+	// and/or short-circuit
+	switch b.op {
+	case "and":
+		return truth(x != 0 && b.y.eval(env) != 0)
+	case "or":
+		return truth(x != 0 || b.y.eval(env) != 0)
+	}
+
+	y := b.y.eval(env)
+	switch b.op {
+	case "+":
+		return x + y
+	case "-":
+		return x - y
+	case "*":
+		return x * y
+	case "/":
+		if y == 0 {
+			return 0
+		}
+		return x / y
+	case ">":
+		return truth(x > y)
+	case ">=":
+		return truth(x >= y)
+	case "<":
+		return truth(x < y)
+	case "<=":
+		return truth(x <= y)
+	case "==":
+		return truth(x == y)
+	default: // !=
+		return truth(x != y)
+	}
+}
+
+// inRange matches values from one bound up to, not including, the other,
+// wrapping around when the first exceeds the second, as in hour in 22-06
+type inRange struct {
+	x        expr
+	from, to float64
+}
+
+func (r inRange) eval(env Env) float64 {
+	x := r.x.eval(env)
+	if r.from <= r.to {
+		return truth(x >= r.from && x < r.to)
+	}
+	return truth(x >= r.from || x < r.to)
+}
+
+type call struct {
+	name string
+	args []expr
+}
+
+func (c call) eval(env Env) float64 {
+	args := make([]float64, len(c.args))
+	for i, arg := range c.args {
+		args[i] = arg.eval(env)
+	}
+	switch c.name {
+	case "min":
+		return slices.Min(args)
+	case "max":
+		return slices.Max(args)
+	case "ceil":
+		return math.Ceil(args[0])
+	case "floor":
+		return math.Floor(args[0])
+	default: // nodes
+		slots := max(env[VarSlotsPerNode], 1)
+		return math.Ceil(args[0] / slots)
+	}
+}
+
+// functions maps each function to its number of arguments, or -1 for any
+// number of at least one
+var functions = map[string]int{
+	"min":   -1,
+	"max":   -1,
+	"ceil":  1,
+	"floor": 1,
+	"nodes": 1,
+}
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// token kinds
+const (
+	tokenEOF = iota
+	tokenNumber
+	tokenIdent
+	tokenOp
+)
+
+type token struct {
+	kind int
+	text string
+}
+
+// lex splits src into numbers, identifiers and operators
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(src) && (unicode.IsDigit(rune(src[j])) || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokenNumber, src[i:j]})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(src) && (unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j])) || src[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, token{tokenIdent, src[i:j]})
+			i = j
+		default:
+			op := src[i : i+1]
+			if i+1 < len(src) && slices.Contains([]string{">=", "<=", "==", "!="}, src[i:i+2]) {
+				op = src[i : i+2]
+			}
+			if !strings.Contains("+-*/()<>=!,", op[:1]) || op == "!" {
+				return nil, fmt.Errorf("unexpected %q", op)
+			}
+			tokens = append(tokens, token{tokenOp, op})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF}), nil
+}
+
+// parser is a recursive descent parser over the tokens of one expression:
+//
+//	or      = and {"or" and}
+//	and     = not {"and" not}
+//	not     = "not" not | compare
+//	compare = sum [("<" | "<=" | ">" | ">=" | "==" | "!=") sum | "in" number "-" number]
+//	sum     = product {("+" | "-") product}
+//	product = unary {("*" | "/") unary}
+//	unary   = "-" unary | number | "true" | "false" | name | name "(" or {"," or} ")" | "(" or ")"
+type parser struct {
+	tokens []token
+	pos    int
+	vars   []string
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the given operator or keyword
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); (t.kind == tokenOp || t.kind == tokenIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	if t := p.peek(); t.kind != tokenEOF {
+		return fmt.Errorf("unexpected %q", t.text)
+	}
+	return fmt.Errorf("unexpected end")
+}
+
+// parseExpr parses a whole expression
+func parseExpr(src string, vars []string) (expr, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, vars: vars}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected()
+	}
+	return e, nil
+}
+
+func (p *parser) or() (expr, error) {
+	return p.chain(p.and, "or")
+}
+
+func (p *parser) and() (expr, error) {
+	return p.chain(p.not, "and")
+}
+
+func (p *parser) sum() (expr, error) {
+	return p.chain(p.product, "+", "-")
+}
+
+func (p *parser) product() (expr, error) {
+	return p.chain(p.unary, "*", "/")
+}
+
+// chain parses operands joined by left-associative operators
+func (p *parser) chain(operand func() (expr, error), ops ...string) (expr, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, candidate := range ops {
+			if p.accept(candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return x, nil
+		}
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		x = binary{op: op, x: x, y: y}
+	}
+}
+
+func (p *parser) not() (expr, error) {
+	if p.accept("not") {
+		x, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op: "not", x: x}, nil
+	}
+	return p.compare()
+}
+
+func (p *parser) compare() (expr, error) {
+	x, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{">=", "<=", "==", "!=", ">", "<"} {
+		if p.accept(op) {
+			y, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			return binary{op: op, x: x, y: y}, nil
+		}
+	}
+	if p.accept("in") {
+		from, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("-"); err != nil {
+			return nil, err
+		}
+		to, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+		return inRange{x: x, from: from, to: to}, nil
+	}
+	return x, nil
+}
+
+func (p *parser) number() (float64, error) {
+	t := p.peek()
+	if t.kind != tokenNumber {
+		return 0, p.unexpected()
+	}
+	p.next()
+	n, err := strconv.ParseFloat(t.text, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", t.text)
+	}
+	return n, nil
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.accept("-") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unary{op: "-", x: x}, nil
+	}
+	if p.accept("(") {
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == tokenNumber:
+		n, err := p.number()
+		return number(n), err
+	case t.kind != tokenIdent:
+		return nil, p.unexpected()
+	}
+	p.next()
+
+	switch {
+	case t.text == "true":
+		return number(1), nil
+	case t.text == "false":
+		return number(0), nil
+	case p.accept("("):
+		return p.call(t.text)
+	case slices.Contains(p.vars, t.text):
+		return variable(t.text), nil
+	default:
+		return nil, fmt.Errorf("unknown variable %q", t.text)
+	}
+}
+
+// call parses the arguments of a function call, the name and "(" consumed
+func (p *parser) call(name string) (expr, error) {
+	arity, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q", name)
+	}
+	var args []expr
+	for {
+		arg, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if arity >= 0 && len(args) != arity {
+		return nil, fmt.Errorf("%s takes %d argument(s), got %d", name, arity, len(args))
+	}
+	return call{name: name, args: args}, nil
+}
@@ -0,0 +1,191 @@
+// Package policy evaluates declarative scaling rules: an ordered list of
+// conditions over a pool's state, each with the action taken when it holds.
+package policy
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// Variables describing a pool to the rules; counts are nodes unless noted
+const (
+	VarReady        = "ready"          // Schedulable ready nodes
+	VarBooting      = "booting"        // Booting nodes
+	VarAllocated    = "allocated"      // Nodes hosting users
+	VarUsers        = "users"          // Connected users
+	VarDemand       = "demand"         // Slots predicted to be needed
+	VarAvailable    = "available"      // Free slots on ready nodes plus every slot of booting ones
+	VarSlotsPerNode = "slots_per_node" // Users a node hosts at once
+	VarMinReady     = "min_ready"      // Assignable
+	VarMaxReady     = "max_ready"      // Assignable
+	VarBurstMax     = "burst_max"      // Assignable; 0 when the pool does not burst
+	VarHour         = "hour"           // 0-23, in the policy's zone
+	VarWeekday      = "weekday"        // 0-6 from Sunday, in the policy's zone
+)
+
+// Variables lists every variable rules may read
+var Variables = []string{
+	VarReady, VarBooting, VarAllocated, VarUsers, VarDemand, VarAvailable,
+	VarSlotsPerNode, VarMinReady, VarMaxReady, VarBurstMax, VarHour, VarWeekday,
+}
+
+// assignable lists the variables a rule may set for the rules after it
+var assignable = []string{VarMinReady, VarMaxReady, VarBurstMax}
+
+// Actions a rule may take
+const (
+	ActionSet       = "set"        // Assign a limit and go on to the next rule
+	ActionScaleUp   = "scale_up"   // Provision nodes, up to max_ready
+	ActionBurst     = "burst"      // Provision nodes, up to burst_max
+	ActionScaleDown = "scale_down" // Release ready nodes, down to min_ready
+	ActionHold      = "hold"       // Neither provision nor release
+)
+
+// Rule is one configured rule: when If holds, Then is done, e.g.
+//
+//	if: "demand > available"
+//	then: "scale_up nodes(demand - available)"
+type Rule struct {
+	If            string
+	Then          string
+	Reason        string   // Given for the decision; defaults to the condition
+	InstanceTypes []string // Pools the rule applies to; empty for all
+}
+
+// Env holds the values of the variables for one evaluation
+type Env map[string]float64
+
+// Outcome is the decision of the first rule that decided
+type Outcome struct {
+	Action string
+	Nodes  int
+	Reason string
+	Rule   int // Index of the deciding rule
+}
+
+type compiled struct {
+	Rule
+	cond   expr
+	action string
+	target string // Variable assigned by ActionSet
+	value  expr   // Nodes for scaling actions, or the value assigned
+}
+
+// Policy is a compiled list of rules
+type Policy struct {
+	rules []compiled
+}
+
+// Compile parses rules, reporting the first invalid one by its index
+func Compile(rules []Rule) (*Policy, error) {
+	policy := &Policy{}
+	for i, rule := range rules {
+		c, err := compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		policy.rules = append(policy.rules, c)
+	}
+	return policy, nil
+}
+
+// MustCompile is Compile for rules known to be valid
+func MustCompile(rules []Rule) *Policy {
+	policy, err := Compile(rules)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+func compile(rule Rule) (compiled, error) {
+	c := compiled{Rule: rule}
+	if strings.TrimSpace(rule.If) == "" {
+		return c, fmt.Errorf("if: is empty")
+	}
+	cond, err := parseExpr(rule.If, Variables)
+	if err != nil {
+		return c, fmt.Errorf("if: %w", err)
+	}
+	c.cond = cond
+
+	then := strings.TrimSpace(rule.Then)
+	verb, rest, _ := strings.Cut(then, " ")
+	switch verb {
+	case ActionHold:
+		if strings.TrimSpace(rest) != "" {
+			return c, fmt.Errorf("then: hold takes no argument")
+		}
+		c.action = ActionHold
+		return c, nil
+	case ActionScaleUp, ActionBurst, ActionScaleDown:
+		c.action = verb
+		rest = strings.TrimSpace(rest)
+		if after, ok := strings.CutPrefix(rest, "by "); ok {
+			rest = after
+		}
+	default:
+		target, value, ok := strings.Cut(then, "=")
+		target = strings.TrimSpace(target)
+		if !ok || !slices.Contains(assignable, target) {
+			return c, fmt.Errorf("then: %q is not hold, scale_up, burst, scale_down or an assignment to %s",
+				then, strings.Join(assignable, ", "))
+		}
+		c.action, c.target, rest = ActionSet, target, value
+	}
+
+	if c.value, err = parseExpr(rest, Variables); err != nil {
+		return c, fmt.Errorf("then: %w", err)
+	}
+	return c, nil
+}
+
+// Len returns the number of rules
+func (p *Policy) Len() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.rules)
+}
+
+// Evaluate runs the rules applying to a pool in order against env. Rules
+// assigning a limit update env for the rules after them. The first scaling
+// rule whose condition holds decides, unless its node count is not
+// positive, when the next rule is tried; false is returned if none decides.
+func (p *Policy) Evaluate(instanceType string, env Env) (Outcome, bool) {
+	if p == nil {
+		return Outcome{}, false
+	}
+	for i, rule := range p.rules {
+		if len(rule.InstanceTypes) > 0 && !slices.Contains(rule.InstanceTypes, instanceType) {
+			continue
+		}
+		if rule.cond.eval(env) == 0 {
+			continue
+		}
+
+		switch rule.action {
+		case ActionSet:
+			env[rule.target] = math.Max(math.Round(rule.value.eval(env)), 0)
+			continue
+		case ActionHold:
+			return Outcome{Action: ActionHold, Reason: rule.reason(), Rule: i}, true
+		}
+
+		nodes := int(math.Ceil(rule.value.eval(env)))
+		if nodes <= 0 {
+			continue
+		}
+		return Outcome{Action: rule.action, Nodes: nodes, Reason: rule.reason(), Rule: i}, true
+	}
+	return Outcome{}, false
+}
+
+func (c compiled) reason() string {
+	if c.Reason != "" {
+		return c.Reason
+	}
+	return "policy: " + strings.TrimSpace(c.If)
+}
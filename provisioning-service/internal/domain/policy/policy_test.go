@@ -0,0 +1,164 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExprEvaluates(t *testing.T) {
+	env := Env{VarReady: 3, VarBooting: 1, VarDemand: 10, VarAvailable: 4, VarSlotsPerNode: 4, VarHour: 23}
+
+	tests := map[string]float64{
+		"1 + 2 * 3":                  7,
+		"(1 + 2) * 3":                9,
+		"10 - 4 - 3":                 3,
+		"12 / 3 / 2":                 2,
+		"ready / 0":                  0,
+		"-ready + 5":                 2,
+		"- - ready":                  3,
+		"2.5 * 2":                    5,
+		"ready >= 3":                 1,
+		"ready != 3":                 0,
+		"ready + booting == 4":       1,
+		"ready < 2 or booting > 0":   1,
+		"ready < 2 or not true":      0,
+		"not ready > 5 and demand":   1,
+		"true and false or true":     1,
+		"min(ready, booting, 2)":     1,
+		"max(ready, demand / 2)":     5,
+		"ceil(2.1) + floor(2.9)":     5,
+		"nodes(demand - available)":  2,
+		"hour in 22-06":              1,
+		"hour in 8-18":               0,
+		"hour + 1 in 0-24":           0,
+		"(hour - 23) in 0-1":         1,
+		"min(ready > 1, booting)":    1,
+		"demand > available + ready": 1,
+	}
+	for src, want := range tests {
+		e, err := parseExpr(src, Variables)
+		if err != nil {
+			t.Errorf("parseExpr(%q): %v", src, err)
+			continue
+		}
+		if got := e.eval(env); got != want {
+			t.Errorf("%s = %g, want %g", src, got, want)
+		}
+	}
+}
+
+func TestParseExprRejects(t *testing.T) {
+	tests := map[string]string{
+		"":                "unexpected end",
+		"ready >":         "unexpected end",
+		"ready > > 1":     `unexpected ">"`,
+		"(ready":          "unexpected end",
+		"ready)":          `unexpected ")"`,
+		"ready ! 1":       `unexpected "!"`,
+		"ready % 2":       `unexpected "%"`,
+		"queue > 1":       `unknown variable "queue"`,
+		"avg(ready)":      `unknown function "avg"`,
+		"ceil(1, 2)":      "ceil takes 1 argument(s), got 2",
+		"min()":           `unexpected ")"`,
+		"1.2.3":           `invalid number "1.2.3"`,
+		"hour in 22":      "unexpected end",
+		"hour in a-b":     `unexpected "a"`,
+		"ready 1":         `unexpected "1"`,
+		"nodes(demand,)":  `unexpected ")"`,
+		"ready == == 1":   `unexpected "=="`,
+		"ready and or 1":  `unknown variable "or"`,
+		"hour in 22 - 6x": `unexpected "x"`,
+	}
+	for src, want := range tests {
+		_, err := parseExpr(src, Variables)
+		if err == nil {
+			t.Errorf("parseExpr(%q) succeeded", src)
+			continue
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("parseExpr(%q) = %v, want %s", src, err, want)
+		}
+	}
+}
+
+func TestCompileRejects(t *testing.T) {
+	tests := []struct {
+		rule Rule
+		want string
+	}{
+		{Rule{If: " ", Then: "hold"}, "if: is empty"},
+		{Rule{If: "true", Then: "hold 2"}, "hold takes no argument"},
+		{Rule{If: "true", Then: "scale_up"}, "then: unexpected end"},
+		{Rule{If: "true", Then: "ready = 2"}, "is not hold, scale_up, burst, scale_down or an assignment"},
+		{Rule{If: "true", Then: "provision 2"}, "is not hold"},
+	}
+	for _, tt := range tests {
+		_, err := Compile([]Rule{{If: "true", Then: "hold"}, tt.rule})
+		if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.HasPrefix(err.Error(), "rule 1: ") {
+			t.Errorf("Compile(%+v) = %v, want rule 1: ...%s", tt.rule, err, tt.want)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	p := MustCompile([]Rule{
+		{If: "hour in 22-06", Then: "max_ready = max_ready / 2", Reason: "overnight"},
+		{If: "true", Then: "min_ready = 1.6"},
+		{If: "demand > available", Then: "scale_up by nodes(demand - available)", InstanceTypes: []string{"gpu"}},
+		{If: "ready > max_ready", Then: "scale_down ready - max_ready"},
+		{If: "demand > available", Then: "burst 0"},
+		{If: "weekday == 0", Then: "hold"},
+	})
+
+	tests := []struct {
+		name         string
+		instanceType string
+		env          Env
+		want         Outcome
+		maxReady     float64
+	}{
+		{
+			name:         "scale up for its pool",
+			instanceType: "gpu",
+			env:          Env{VarDemand: 9, VarAvailable: 1, VarSlotsPerNode: 4, VarMaxReady: 10, VarHour: 12, VarWeekday: 1},
+			want:         Outcome{Action: ActionScaleUp, Nodes: 2, Reason: "policy: demand > available", Rule: 2},
+			maxReady:     10,
+		},
+		{
+			name:         "rule of another pool skipped",
+			instanceType: "cpu",
+			env:          Env{VarDemand: 9, VarAvailable: 1, VarReady: 3, VarMaxReady: 4, VarHour: 23, VarWeekday: 1},
+			want:         Outcome{Action: ActionScaleDown, Nodes: 1, Reason: "policy: ready > max_ready", Rule: 3},
+			maxReady:     2,
+		},
+		{
+			name:         "zero nodes falls through",
+			instanceType: "cpu",
+			env:          Env{VarDemand: 2, VarAvailable: 1, VarMaxReady: 4, VarHour: 12, VarWeekday: 0},
+			want:         Outcome{Action: ActionHold, Reason: "policy: weekday == 0", Rule: 5},
+			maxReady:     4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := p.Evaluate(tt.instanceType, tt.env)
+			if !ok || got != tt.want {
+				t.Errorf("Evaluate = %+v, %v; want %+v", got, ok, tt.want)
+			}
+			if tt.env[VarMaxReady] != tt.maxReady {
+				t.Errorf("max_ready = %g, want %g", tt.env[VarMaxReady], tt.maxReady)
+			}
+			if tt.env[VarMinReady] != 2 {
+				t.Errorf("min_ready = %g, want the assignment rounded", tt.env[VarMinReady])
+			}
+		})
+	}
+
+	if _, ok := p.Evaluate("cpu", Env{VarWeekday: 1}); ok {
+		t.Error("a pool no rule decides for was decided")
+	}
+	var none *Policy
+	if _, ok := none.Evaluate("cpu", Env{}); ok || none.Len() != 0 {
+		t.Error("nil policy decided")
+	}
+}
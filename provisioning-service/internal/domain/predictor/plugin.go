@@ -0,0 +1,321 @@
+package predictor
+
+//go:generate protoc -I ../../../proto --go_out=../../.. --go_opt=module=github.com/aos-cc/provisioning-service --go-grpc_out=../../.. --go-grpc_opt=module=github.com/aos-cc/provisioning-service predictor/v1/plugin.proto
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/predictor/pluginpb"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Plugin request outcomes reported to the PluginObserver
+const (
+	PluginOK      = "ok"
+	PluginError   = "error"
+	PluginTimeout = "timeout"
+)
+
+// PluginObserver is notified of every snapshot sent to the plugin
+type PluginObserver interface {
+	ObservePluginRequest(outcome string, d time.Duration)
+}
+
+// PluginConfig configures an out-of-process predictor plugin
+type PluginConfig struct {
+	Address string        // host:port of the plugin's gRPC server
+	Timeout time.Duration // How long a scaling check waits for decisions
+	TLS     bool          // Dial with TLS instead of plaintext
+}
+
+// Plugin streams snapshots to a PredictorPlugin and waits for its decisions.
+// The stream is opened on first use and reopened after it fails.
+type Plugin struct {
+	address  string
+	timeout  time.Duration
+	conn     *grpc.ClientConn
+	client   pluginpb.PredictorPluginClient
+	logger   *zap.Logger
+	observer PluginObserver
+
+	mu       sync.Mutex // Serializes snapshots; one is outstanding at a time
+	stream   *pluginStream
+	sequence uint64
+	failing  bool
+}
+
+// pluginStream is an open Predict stream and the decisions read from it
+type pluginStream struct {
+	send    func(*pluginpb.Snapshot) error
+	cancel  context.CancelFunc
+	replies chan *pluginpb.Decision
+	done    chan struct{} // Closed when the stream stops receiving
+	err     error         // Set before done is closed
+}
+
+// pluginDecision is a plugin's decision for one pool
+type pluginDecision struct {
+	action pluginpb.Action
+	nodes  int
+	reason string
+}
+
+// NewPlugin creates a plugin client; no connection is made until the first
+// snapshot is sent
+func NewPlugin(config PluginConfig, logger *zap.Logger, observer PluginObserver) (*Plugin, error) {
+	if config.Timeout <= 0 {
+		config.Timeout = time.Second
+	}
+	creds := insecure.NewCredentials()
+	if config.TLS {
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+
+	conn, err := grpc.NewClient(config.Address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("predictor plugin %s: %w", config.Address, err)
+	}
+	return &Plugin{
+		address:  config.Address,
+		timeout:  config.Timeout,
+		conn:     conn,
+		client:   pluginpb.NewPredictorPluginClient(conn),
+		logger:   logger,
+		observer: observer,
+	}, nil
+}
+
+// Close ends the stream and the connection
+func (c *Plugin) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset()
+	return c.conn.Close()
+}
+
+// Predict sends a snapshot and returns the decisions the plugin answered it
+// with by instance type. Errors are logged once until the plugin recovers and
+// return nil, leaving every pool to the built-in prediction.
+func (c *Plugin) Predict(snapshot *pluginpb.Snapshot) map[string]pluginDecision {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := time.Now()
+	decision, err := c.predict(snapshot)
+	outcome := PluginOK
+	if errors.Is(err, context.DeadlineExceeded) {
+		outcome = PluginTimeout
+	} else if err != nil {
+		outcome = PluginError
+	}
+	if c.observer != nil {
+		c.observer.ObservePluginRequest(outcome, time.Since(start))
+	}
+
+	if err != nil {
+		if !c.failing {
+			c.logger.Warn("predictor plugin unavailable, using built-in prediction",
+				zap.String("address", c.address),
+				zap.Error(err),
+			)
+		}
+		c.failing = true
+		return nil
+	}
+	if c.failing {
+		c.logger.Info("predictor plugin recovered", zap.String("address", c.address))
+	}
+	c.failing = false
+
+	decisions := make(map[string]pluginDecision, len(decision.GetPools()))
+	for _, pool := range decision.GetPools() {
+		decisions[pool.GetInstanceType()] = pluginDecision{
+			action: pool.GetAction(),
+			nodes:  int(pool.GetNodes()),
+			reason: pool.GetReason(),
+		}
+	}
+	return decisions
+}
+
+// predict sends a snapshot on the stream and waits for the decision carrying
+// its sequence, discarding late answers to earlier snapshots
+func (c *Plugin) predict(snapshot *pluginpb.Snapshot) (*pluginpb.Decision, error) {
+	if c.stream == nil {
+		if err := c.open(); err != nil {
+			return nil, err
+		}
+	}
+
+	c.sequence++
+	snapshot.Sequence = c.sequence
+	stream := c.stream
+	if err := stream.send(snapshot); err != nil {
+		c.reset()
+		return nil, fmt.Errorf("send snapshot: %w", err)
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case decision := <-stream.replies:
+			if decision.GetSequence() == snapshot.Sequence {
+				return decision, nil
+			}
+		case <-stream.done:
+			c.reset()
+			return nil, fmt.Errorf("receive decision: %w", stream.err)
+		case <-timer.C:
+			return nil, fmt.Errorf("no decision within %s: %w", c.timeout, context.DeadlineExceeded)
+		}
+	}
+}
+
+// open starts a Predict stream and the goroutine reading its decisions
+func (c *Plugin) open() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := c.client.Predict(ctx)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("open stream: %w", err)
+	}
+
+	s := &pluginStream{
+		send:    stream.Send,
+		cancel:  cancel,
+		replies: make(chan *pluginpb.Decision, 1),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		for {
+			decision, err := stream.Recv()
+			if err != nil {
+				s.err = err
+				return
+			}
+			// Drop an unread late answer rather than block the stream
+			select {
+			case <-s.replies:
+			default:
+			}
+			s.replies <- decision
+		}
+	}()
+	c.stream = s
+	return nil
+}
+
+// reset cancels the current stream so the next snapshot opens a new one
+func (c *Plugin) reset() {
+	if c.stream != nil {
+		c.stream.cancel()
+		c.stream = nil
+	}
+}
+
+// pluginDecisions asks the plugin, if any, to decide for the pools
+func (p *Predictor) pluginDecisions(cfg PredictionConfig) map[string]pluginDecision {
+	p.mu.RLock()
+	plugin := p.plugin
+	p.mu.RUnlock()
+	if plugin == nil {
+		return nil
+	}
+	return plugin.Predict(p.snapshot(cfg))
+}
+
+// snapshot describes the pools and tracked users to the plugin
+func (p *Predictor) snapshot(cfg PredictionConfig) *pluginpb.Snapshot {
+	instanceTypes := []string{""}
+	if len(cfg.InstanceTypes) > 0 {
+		instanceTypes = cfg.instanceTypes()
+	}
+
+	snapshot := &pluginpb.Snapshot{Time: timestamppb.Now()}
+	for _, instanceType := range instanceTypes {
+		policy := cfg.PolicyFor(instanceType)
+		filter := cfg.filter(instanceType)
+
+		pool := &pluginpb.PoolSnapshot{
+			InstanceType:     instanceType,
+			ReadyNodes:       int32(p.nodePool.CountSchedulableWhere(filter)),
+			BootingNodes:     int32(p.nodePool.CountByStatusWhere(node.NodeStatusBooting, filter)),
+			AllocatedNodes:   int32(p.nodePool.CountOccupiedWhere(filter)),
+			FreeSlots:        int32(p.nodePool.FreeSlotsWhere(filter)),
+			ConnectedUsers:   int32(p.nodePool.CountUsersWhere(filter)),
+			UsersPerNode:     int32(policy.slots()),
+			MinReadyNodes:    int32(policy.MinReadyNodes),
+			MaxReadyNodes:    int32(policy.MaxReadyNodes),
+			PredictionWindow: durationpb.New(p.window(cfg, instanceType)),
+		}
+		if cfg.receivesDemand(instanceType) {
+			demand, _ := p.demand(cfg, instanceType, filter)
+			pool.BuiltinDemand = int32(demand)
+		}
+		snapshot.Pools = append(snapshot.Pools, pool)
+	}
+
+	cutoff := time.Now().Add(-cfg.ActivityWindow)
+	for _, state := range p.userTracker.GetActiveUsers(cutoff) {
+		snapshot.Users = append(snapshot.Users, &pluginpb.UserSnapshot{
+			UserId:        state.UserID,
+			TenantId:      state.TenantID,
+			Connected:     state.IsConnected,
+			ActivityCount: int32(p.userTracker.ActivityCountOf(state.UserID, cfg.ActivityWindow)),
+			LastActivity:  timestamppb.New(state.LastActivityTime),
+			Selector:      state.Selector,
+		})
+	}
+	return snapshot
+}
+
+// applyPluginDecision scales a pool as the plugin decided, keeping it within
+// the policy's ready node limits. It reports false when the plugin left the
+// pool to the built-in prediction.
+func applyPluginDecision(decision *ScalingDecision, d pluginDecision, policy InstanceTypePolicy, readyCount, bootingCount, allocatedCount int) bool {
+	reason := "plugin"
+	if d.reason != "" {
+		reason = "plugin: " + d.reason
+	}
+
+	switch d.action {
+	case pluginpb.Action_ACTION_HOLD:
+	case pluginpb.Action_ACTION_SCALE_UP:
+		if d.nodes > 0 {
+			decision.ShouldScaleUp = true
+			decision.TargetNodes = d.nodes
+			decision.Reason = reason
+		}
+	case pluginpb.Action_ACTION_SCALE_DOWN:
+		if excess := min(d.nodes, readyCount-policy.MinReadyNodes); excess > 0 {
+			decision.ShouldScaleDown = true
+			decision.TargetNodes = excess
+			decision.Reason = reason
+		}
+	default:
+		return false
+	}
+
+	// The minimum holds whatever the plugin decided
+	if !decision.ShouldScaleUp && readyCount+bootingCount < policy.MinReadyNodes {
+		decision.ShouldScaleUp = true
+		decision.ShouldScaleDown = false
+		decision.TargetNodes = policy.MinReadyNodes - (readyCount + bootingCount)
+		decision.Reason = "maintaining minimum ready nodes"
+	}
+	capScaleUp(decision, policy, readyCount+bootingCount+allocatedCount, d.action == pluginpb.Action_ACTION_SCALE_UP)
+	return true
+}
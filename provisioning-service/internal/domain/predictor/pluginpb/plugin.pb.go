@@ -0,0 +1,611 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.29.3
+// source: predictor/v1/plugin.proto
+
+// Contract for out-of-process predictor plugins. The provisioning service
+// dials the plugin and opens one Predict stream; every scaling check sends a
+// snapshot of the pools and tracked users, and the plugin answers each with
+// the scaling decisions it wants for the pools.
+
+package pluginpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Action int32
+
+const (
+	// Leave the pool to the built-in prediction
+	Action_ACTION_UNSPECIFIED Action = 0
+	// Neither provision nor release nodes
+	Action_ACTION_HOLD       Action = 1
+	Action_ACTION_SCALE_UP   Action = 2
+	Action_ACTION_SCALE_DOWN Action = 3
+)
+
+// Enum value maps for Action.
+var (
+	Action_name = map[int32]string{
+		0: "ACTION_UNSPECIFIED",
+		1: "ACTION_HOLD",
+		2: "ACTION_SCALE_UP",
+		3: "ACTION_SCALE_DOWN",
+	}
+	Action_value = map[string]int32{
+		"ACTION_UNSPECIFIED": 0,
+		"ACTION_HOLD":        1,
+		"ACTION_SCALE_UP":    2,
+		"ACTION_SCALE_DOWN":  3,
+	}
+)
+
+func (x Action) Enum() *Action {
+	p := new(Action)
+	*p = x
+	return p
+}
+
+func (x Action) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Action) Descriptor() protoreflect.EnumDescriptor {
+	return file_predictor_v1_plugin_proto_enumTypes[0].Descriptor()
+}
+
+func (Action) Type() protoreflect.EnumType {
+	return &file_predictor_v1_plugin_proto_enumTypes[0]
+}
+
+func (x Action) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Action.Descriptor instead.
+func (Action) EnumDescriptor() ([]byte, []int) {
+	return file_predictor_v1_plugin_proto_rawDescGZIP(), []int{0}
+}
+
+type Snapshot struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Sequence increases with every snapshot sent on the stream
+	Sequence      uint64                 `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,2,opt,name=time,proto3" json:"time,omitempty"`
+	Pools         []*PoolSnapshot        `protobuf:"bytes,3,rep,name=pools,proto3" json:"pools,omitempty"`
+	Users         []*UserSnapshot        `protobuf:"bytes,4,rep,name=users,proto3" json:"users,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Snapshot) Reset() {
+	*x = Snapshot{}
+	mi := &file_predictor_v1_plugin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Snapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Snapshot) ProtoMessage() {}
+
+func (x *Snapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_predictor_v1_plugin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Snapshot.ProtoReflect.Descriptor instead.
+func (*Snapshot) Descriptor() ([]byte, []int) {
+	return file_predictor_v1_plugin_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Snapshot) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *Snapshot) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Snapshot) GetPools() []*PoolSnapshot {
+	if x != nil {
+		return x.Pools
+	}
+	return nil
+}
+
+func (x *Snapshot) GetUsers() []*UserSnapshot {
+	if x != nil {
+		return x.Users
+	}
+	return nil
+}
+
+// PoolSnapshot describes one instance type's pool. Without instance types
+// configured there is a single pool with an empty instance_type.
+type PoolSnapshot struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	InstanceType   string                 `protobuf:"bytes,1,opt,name=instance_type,json=instanceType,proto3" json:"instance_type,omitempty"`
+	ReadyNodes     int32                  `protobuf:"varint,2,opt,name=ready_nodes,json=readyNodes,proto3" json:"ready_nodes,omitempty"`
+	BootingNodes   int32                  `protobuf:"varint,3,opt,name=booting_nodes,json=bootingNodes,proto3" json:"booting_nodes,omitempty"`
+	AllocatedNodes int32                  `protobuf:"varint,4,opt,name=allocated_nodes,json=allocatedNodes,proto3" json:"allocated_nodes,omitempty"`
+	// Free slots on schedulable ready nodes
+	FreeSlots      int32 `protobuf:"varint,5,opt,name=free_slots,json=freeSlots,proto3" json:"free_slots,omitempty"`
+	ConnectedUsers int32 `protobuf:"varint,6,opt,name=connected_users,json=connectedUsers,proto3" json:"connected_users,omitempty"`
+	UsersPerNode   int32 `protobuf:"varint,7,opt,name=users_per_node,json=usersPerNode,proto3" json:"users_per_node,omitempty"`
+	MinReadyNodes  int32 `protobuf:"varint,8,opt,name=min_ready_nodes,json=minReadyNodes,proto3" json:"min_ready_nodes,omitempty"`
+	MaxReadyNodes  int32 `protobuf:"varint,9,opt,name=max_ready_nodes,json=maxReadyNodes,proto3" json:"max_ready_nodes,omitempty"`
+	// How far ahead the pool's demand is predicted
+	PredictionWindow *durationpb.Duration `protobuf:"bytes,10,opt,name=prediction_window,json=predictionWindow,proto3" json:"prediction_window,omitempty"`
+	// Slots the built-in prediction would provision for; zero for pools that
+	// don't receive demand
+	BuiltinDemand int32 `protobuf:"varint,11,opt,name=builtin_demand,json=builtinDemand,proto3" json:"builtin_demand,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PoolSnapshot) Reset() {
+	*x = PoolSnapshot{}
+	mi := &file_predictor_v1_plugin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PoolSnapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PoolSnapshot) ProtoMessage() {}
+
+func (x *PoolSnapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_predictor_v1_plugin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PoolSnapshot.ProtoReflect.Descriptor instead.
+func (*PoolSnapshot) Descriptor() ([]byte, []int) {
+	return file_predictor_v1_plugin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *PoolSnapshot) GetInstanceType() string {
+	if x != nil {
+		return x.InstanceType
+	}
+	return ""
+}
+
+func (x *PoolSnapshot) GetReadyNodes() int32 {
+	if x != nil {
+		return x.ReadyNodes
+	}
+	return 0
+}
+
+func (x *PoolSnapshot) GetBootingNodes() int32 {
+	if x != nil {
+		return x.BootingNodes
+	}
+	return 0
+}
+
+func (x *PoolSnapshot) GetAllocatedNodes() int32 {
+	if x != nil {
+		return x.AllocatedNodes
+	}
+	return 0
+}
+
+func (x *PoolSnapshot) GetFreeSlots() int32 {
+	if x != nil {
+		return x.FreeSlots
+	}
+	return 0
+}
+
+func (x *PoolSnapshot) GetConnectedUsers() int32 {
+	if x != nil {
+		return x.ConnectedUsers
+	}
+	return 0
+}
+
+func (x *PoolSnapshot) GetUsersPerNode() int32 {
+	if x != nil {
+		return x.UsersPerNode
+	}
+	return 0
+}
+
+func (x *PoolSnapshot) GetMinReadyNodes() int32 {
+	if x != nil {
+		return x.MinReadyNodes
+	}
+	return 0
+}
+
+func (x *PoolSnapshot) GetMaxReadyNodes() int32 {
+	if x != nil {
+		return x.MaxReadyNodes
+	}
+	return 0
+}
+
+func (x *PoolSnapshot) GetPredictionWindow() *durationpb.Duration {
+	if x != nil {
+		return x.PredictionWindow
+	}
+	return nil
+}
+
+func (x *PoolSnapshot) GetBuiltinDemand() int32 {
+	if x != nil {
+		return x.BuiltinDemand
+	}
+	return 0
+}
+
+type UserSnapshot struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	UserId    string                 `protobuf:"bytes,1,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	TenantId  string                 `protobuf:"bytes,2,opt,name=tenant_id,json=tenantId,proto3" json:"tenant_id,omitempty"`
+	Connected bool                   `protobuf:"varint,3,opt,name=connected,proto3" json:"connected,omitempty"`
+	// Activities within the activity window
+	ActivityCount int32                  `protobuf:"varint,4,opt,name=activity_count,json=activityCount,proto3" json:"activity_count,omitempty"`
+	LastActivity  *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=last_activity,json=lastActivity,proto3" json:"last_activity,omitempty"`
+	// Node labels the user's last connect asked for
+	Selector      map[string]string `protobuf:"bytes,6,rep,name=selector,proto3" json:"selector,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UserSnapshot) Reset() {
+	*x = UserSnapshot{}
+	mi := &file_predictor_v1_plugin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UserSnapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UserSnapshot) ProtoMessage() {}
+
+func (x *UserSnapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_predictor_v1_plugin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UserSnapshot.ProtoReflect.Descriptor instead.
+func (*UserSnapshot) Descriptor() ([]byte, []int) {
+	return file_predictor_v1_plugin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *UserSnapshot) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+func (x *UserSnapshot) GetTenantId() string {
+	if x != nil {
+		return x.TenantId
+	}
+	return ""
+}
+
+func (x *UserSnapshot) GetConnected() bool {
+	if x != nil {
+		return x.Connected
+	}
+	return false
+}
+
+func (x *UserSnapshot) GetActivityCount() int32 {
+	if x != nil {
+		return x.ActivityCount
+	}
+	return 0
+}
+
+func (x *UserSnapshot) GetLastActivity() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastActivity
+	}
+	return nil
+}
+
+func (x *UserSnapshot) GetSelector() map[string]string {
+	if x != nil {
+		return x.Selector
+	}
+	return nil
+}
+
+type Decision struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Sequence of the snapshot answered
+	Sequence      uint64          `protobuf:"varint,1,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Pools         []*PoolDecision `protobuf:"bytes,2,rep,name=pools,proto3" json:"pools,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Decision) Reset() {
+	*x = Decision{}
+	mi := &file_predictor_v1_plugin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Decision) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Decision) ProtoMessage() {}
+
+func (x *Decision) ProtoReflect() protoreflect.Message {
+	mi := &file_predictor_v1_plugin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Decision.ProtoReflect.Descriptor instead.
+func (*Decision) Descriptor() ([]byte, []int) {
+	return file_predictor_v1_plugin_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Decision) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *Decision) GetPools() []*PoolDecision {
+	if x != nil {
+		return x.Pools
+	}
+	return nil
+}
+
+// PoolDecision scales one pool. The service still keeps the pool between its
+// min and max ready nodes, and pools left out keep the built-in prediction.
+type PoolDecision struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	InstanceType string                 `protobuf:"bytes,1,opt,name=instance_type,json=instanceType,proto3" json:"instance_type,omitempty"`
+	Action       Action                 `protobuf:"varint,2,opt,name=action,proto3,enum=aoscc.predictor.v1.Action" json:"action,omitempty"`
+	// Nodes to provision or release
+	Nodes         int32  `protobuf:"varint,3,opt,name=nodes,proto3" json:"nodes,omitempty"`
+	Reason        string `protobuf:"bytes,4,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PoolDecision) Reset() {
+	*x = PoolDecision{}
+	mi := &file_predictor_v1_plugin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PoolDecision) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PoolDecision) ProtoMessage() {}
+
+func (x *PoolDecision) ProtoReflect() protoreflect.Message {
+	mi := &file_predictor_v1_plugin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PoolDecision.ProtoReflect.Descriptor instead.
+func (*PoolDecision) Descriptor() ([]byte, []int) {
+	return file_predictor_v1_plugin_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *PoolDecision) GetInstanceType() string {
+	if x != nil {
+		return x.InstanceType
+	}
+	return ""
+}
+
+func (x *PoolDecision) GetAction() Action {
+	if x != nil {
+		return x.Action
+	}
+	return Action_ACTION_UNSPECIFIED
+}
+
+func (x *PoolDecision) GetNodes() int32 {
+	if x != nil {
+		return x.Nodes
+	}
+	return 0
+}
+
+func (x *PoolDecision) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+var File_predictor_v1_plugin_proto protoreflect.FileDescriptor
+
+const file_predictor_v1_plugin_proto_rawDesc = "" +
+	"\n" +
+	"\x19predictor/v1/plugin.proto\x12\x12aoscc.predictor.v1\x1a\x1egoogle/protobuf/duration.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\xc6\x01\n" +
+	"\bSnapshot\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence\x12.\n" +
+	"\x04time\x18\x02 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x126\n" +
+	"\x05pools\x18\x03 \x03(\v2 .aoscc.predictor.v1.PoolSnapshotR\x05pools\x126\n" +
+	"\x05users\x18\x04 \x03(\v2 .aoscc.predictor.v1.UserSnapshotR\x05users\"\xcf\x03\n" +
+	"\fPoolSnapshot\x12#\n" +
+	"\rinstance_type\x18\x01 \x01(\tR\finstanceType\x12\x1f\n" +
+	"\vready_nodes\x18\x02 \x01(\x05R\n" +
+	"readyNodes\x12#\n" +
+	"\rbooting_nodes\x18\x03 \x01(\x05R\fbootingNodes\x12'\n" +
+	"\x0fallocated_nodes\x18\x04 \x01(\x05R\x0eallocatedNodes\x12\x1d\n" +
+	"\n" +
+	"free_slots\x18\x05 \x01(\x05R\tfreeSlots\x12'\n" +
+	"\x0fconnected_users\x18\x06 \x01(\x05R\x0econnectedUsers\x12$\n" +
+	"\x0eusers_per_node\x18\a \x01(\x05R\fusersPerNode\x12&\n" +
+	"\x0fmin_ready_nodes\x18\b \x01(\x05R\rminReadyNodes\x12&\n" +
+	"\x0fmax_ready_nodes\x18\t \x01(\x05R\rmaxReadyNodes\x12F\n" +
+	"\x11prediction_window\x18\n" +
+	" \x01(\v2\x19.google.protobuf.DurationR\x10predictionWindow\x12%\n" +
+	"\x0ebuiltin_demand\x18\v \x01(\x05R\rbuiltinDemand\"\xd3\x02\n" +
+	"\fUserSnapshot\x12\x17\n" +
+	"\auser_id\x18\x01 \x01(\tR\x06userId\x12\x1b\n" +
+	"\ttenant_id\x18\x02 \x01(\tR\btenantId\x12\x1c\n" +
+	"\tconnected\x18\x03 \x01(\bR\tconnected\x12%\n" +
+	"\x0eactivity_count\x18\x04 \x01(\x05R\ractivityCount\x12?\n" +
+	"\rlast_activity\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\flastActivity\x12J\n" +
+	"\bselector\x18\x06 \x03(\v2..aoscc.predictor.v1.UserSnapshot.SelectorEntryR\bselector\x1a;\n" +
+	"\rSelectorEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"^\n" +
+	"\bDecision\x12\x1a\n" +
+	"\bsequence\x18\x01 \x01(\x04R\bsequence\x126\n" +
+	"\x05pools\x18\x02 \x03(\v2 .aoscc.predictor.v1.PoolDecisionR\x05pools\"\x95\x01\n" +
+	"\fPoolDecision\x12#\n" +
+	"\rinstance_type\x18\x01 \x01(\tR\finstanceType\x122\n" +
+	"\x06action\x18\x02 \x01(\x0e2\x1a.aoscc.predictor.v1.ActionR\x06action\x12\x14\n" +
+	"\x05nodes\x18\x03 \x01(\x05R\x05nodes\x12\x16\n" +
+	"\x06reason\x18\x04 \x01(\tR\x06reason*]\n" +
+	"\x06Action\x12\x16\n" +
+	"\x12ACTION_UNSPECIFIED\x10\x00\x12\x0f\n" +
+	"\vACTION_HOLD\x10\x01\x12\x13\n" +
+	"\x0fACTION_SCALE_UP\x10\x02\x12\x15\n" +
+	"\x11ACTION_SCALE_DOWN\x10\x032\\\n" +
+	"\x0fPredictorPlugin\x12I\n" +
+	"\aPredict\x12\x1c.aoscc.predictor.v1.Snapshot\x1a\x1c.aoscc.predictor.v1.Decision(\x010\x01BKZIgithub.com/aos-cc/provisioning-service/internal/domain/predictor/pluginpbb\x06proto3"
+
+var (
+	file_predictor_v1_plugin_proto_rawDescOnce sync.Once
+	file_predictor_v1_plugin_proto_rawDescData []byte
+)
+
+func file_predictor_v1_plugin_proto_rawDescGZIP() []byte {
+	file_predictor_v1_plugin_proto_rawDescOnce.Do(func() {
+		file_predictor_v1_plugin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_predictor_v1_plugin_proto_rawDesc), len(file_predictor_v1_plugin_proto_rawDesc)))
+	})
+	return file_predictor_v1_plugin_proto_rawDescData
+}
+
+var file_predictor_v1_plugin_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_predictor_v1_plugin_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_predictor_v1_plugin_proto_goTypes = []any{
+	(Action)(0),                   // 0: aoscc.predictor.v1.Action
+	(*Snapshot)(nil),              // 1: aoscc.predictor.v1.Snapshot
+	(*PoolSnapshot)(nil),          // 2: aoscc.predictor.v1.PoolSnapshot
+	(*UserSnapshot)(nil),          // 3: aoscc.predictor.v1.UserSnapshot
+	(*Decision)(nil),              // 4: aoscc.predictor.v1.Decision
+	(*PoolDecision)(nil),          // 5: aoscc.predictor.v1.PoolDecision
+	nil,                           // 6: aoscc.predictor.v1.UserSnapshot.SelectorEntry
+	(*timestamppb.Timestamp)(nil), // 7: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),   // 8: google.protobuf.Duration
+}
+var file_predictor_v1_plugin_proto_depIdxs = []int32{
+	7, // 0: aoscc.predictor.v1.Snapshot.time:type_name -> google.protobuf.Timestamp
+	2, // 1: aoscc.predictor.v1.Snapshot.pools:type_name -> aoscc.predictor.v1.PoolSnapshot
+	3, // 2: aoscc.predictor.v1.Snapshot.users:type_name -> aoscc.predictor.v1.UserSnapshot
+	8, // 3: aoscc.predictor.v1.PoolSnapshot.prediction_window:type_name -> google.protobuf.Duration
+	7, // 4: aoscc.predictor.v1.UserSnapshot.last_activity:type_name -> google.protobuf.Timestamp
+	6, // 5: aoscc.predictor.v1.UserSnapshot.selector:type_name -> aoscc.predictor.v1.UserSnapshot.SelectorEntry
+	5, // 6: aoscc.predictor.v1.Decision.pools:type_name -> aoscc.predictor.v1.PoolDecision
+	0, // 7: aoscc.predictor.v1.PoolDecision.action:type_name -> aoscc.predictor.v1.Action
+	1, // 8: aoscc.predictor.v1.PredictorPlugin.Predict:input_type -> aoscc.predictor.v1.Snapshot
+	4, // 9: aoscc.predictor.v1.PredictorPlugin.Predict:output_type -> aoscc.predictor.v1.Decision
+	9, // [9:10] is the sub-list for method output_type
+	8, // [8:9] is the sub-list for method input_type
+	8, // [8:8] is the sub-list for extension type_name
+	8, // [8:8] is the sub-list for extension extendee
+	0, // [0:8] is the sub-list for field type_name
+}
+
+func init() { file_predictor_v1_plugin_proto_init() }
+func file_predictor_v1_plugin_proto_init() {
+	if File_predictor_v1_plugin_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_predictor_v1_plugin_proto_rawDesc), len(file_predictor_v1_plugin_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_predictor_v1_plugin_proto_goTypes,
+		DependencyIndexes: file_predictor_v1_plugin_proto_depIdxs,
+		EnumInfos:         file_predictor_v1_plugin_proto_enumTypes,
+		MessageInfos:      file_predictor_v1_plugin_proto_msgTypes,
+	}.Build()
+	File_predictor_v1_plugin_proto = out.File
+	file_predictor_v1_plugin_proto_goTypes = nil
+	file_predictor_v1_plugin_proto_depIdxs = nil
+}
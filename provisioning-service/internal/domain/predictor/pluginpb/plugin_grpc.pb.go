@@ -0,0 +1,126 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: predictor/v1/plugin.proto
+
+// Contract for out-of-process predictor plugins. The provisioning service
+// dials the plugin and opens one Predict stream; every scaling check sends a
+// snapshot of the pools and tracked users, and the plugin answers each with
+// the scaling decisions it wants for the pools.
+
+package pluginpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	PredictorPlugin_Predict_FullMethodName = "/aoscc.predictor.v1.PredictorPlugin/Predict"
+)
+
+// PredictorPluginClient is the client API for PredictorPlugin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PredictorPluginClient interface {
+	// Predict answers each snapshot with a decision carrying its sequence.
+	// Decisions arriving after the service stopped waiting are discarded and
+	// the service falls back to its built-in prediction for that check.
+	Predict(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Snapshot, Decision], error)
+}
+
+type predictorPluginClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPredictorPluginClient(cc grpc.ClientConnInterface) PredictorPluginClient {
+	return &predictorPluginClient{cc}
+}
+
+func (c *predictorPluginClient) Predict(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[Snapshot, Decision], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PredictorPlugin_ServiceDesc.Streams[0], PredictorPlugin_Predict_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Snapshot, Decision]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PredictorPlugin_PredictClient = grpc.BidiStreamingClient[Snapshot, Decision]
+
+// PredictorPluginServer is the server API for PredictorPlugin service.
+// All implementations must embed UnimplementedPredictorPluginServer
+// for forward compatibility.
+type PredictorPluginServer interface {
+	// Predict answers each snapshot with a decision carrying its sequence.
+	// Decisions arriving after the service stopped waiting are discarded and
+	// the service falls back to its built-in prediction for that check.
+	Predict(grpc.BidiStreamingServer[Snapshot, Decision]) error
+	mustEmbedUnimplementedPredictorPluginServer()
+}
+
+// UnimplementedPredictorPluginServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedPredictorPluginServer struct{}
+
+func (UnimplementedPredictorPluginServer) Predict(grpc.BidiStreamingServer[Snapshot, Decision]) error {
+	return status.Errorf(codes.Unimplemented, "method Predict not implemented")
+}
+func (UnimplementedPredictorPluginServer) mustEmbedUnimplementedPredictorPluginServer() {}
+func (UnimplementedPredictorPluginServer) testEmbeddedByValue()                         {}
+
+// UnsafePredictorPluginServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PredictorPluginServer will
+// result in compilation errors.
+type UnsafePredictorPluginServer interface {
+	mustEmbedUnimplementedPredictorPluginServer()
+}
+
+func RegisterPredictorPluginServer(s grpc.ServiceRegistrar, srv PredictorPluginServer) {
+	// If the following call pancis, it indicates UnimplementedPredictorPluginServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&PredictorPlugin_ServiceDesc, srv)
+}
+
+func _PredictorPlugin_Predict_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PredictorPluginServer).Predict(&grpc.GenericServerStream[Snapshot, Decision]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PredictorPlugin_PredictServer = grpc.BidiStreamingServer[Snapshot, Decision]
+
+// PredictorPlugin_ServiceDesc is the grpc.ServiceDesc for PredictorPlugin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var PredictorPlugin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "aoscc.predictor.v1.PredictorPlugin",
+	HandlerType: (*PredictorPluginServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Predict",
+			Handler:       _PredictorPlugin_Predict_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "predictor/v1/plugin.proto",
+}
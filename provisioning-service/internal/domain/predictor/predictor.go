@@ -1,167 +1,1099 @@
 package predictor
 
 import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/your-org/provisioning-service/internal/domain/node"
-	"github.com/your-org/provisioning-service/internal/domain/user"
+	"github.com/aos-cc/provisioning-service/internal/domain/boottime"
+	"github.com/aos-cc/provisioning-service/internal/domain/forecast"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/policy"
+	"github.com/aos-cc/provisioning-service/internal/domain/safety"
+	"github.com/aos-cc/provisioning-service/internal/domain/schedule"
+	"github.com/aos-cc/provisioning-service/internal/domain/user"
+)
+
+// ScalingMode selects how the predictor sizes the ready pool
+type ScalingMode string
+
+const (
+	// ScalingModeDemand sizes the pool from likely-to-connect users
+	ScalingModeDemand ScalingMode = "demand"
+
+	// ScalingModeTargetUtilization keeps ready nodes at a fixed ratio of allocated nodes
+	ScalingModeTargetUtilization ScalingMode = "target_utilization"
 )
 
 // PredictionConfig holds configuration for the predictive algorithm
 type PredictionConfig struct {
+	// ScalingMode selects the scaling strategy (defaults to demand)
+	ScalingMode ScalingMode
+
+	// TargetHeadroom is the ratio of ready to allocated nodes maintained
+	// in target-utilization mode (e.g. 0.2 keeps 20% headroom)
+	TargetHeadroom float64
+
 	// ActivityWindow is the time window to consider for user activity
 	ActivityWindow time.Duration
 
-	// ActivityThreshold is the number of activities within the window
-	// that suggests a user is likely to connect
+	// ActivityThreshold is the weighted activity score that, with activity
+	// within the window, suggests a user is likely to connect
 	ActivityThreshold int
 
 	// PredictionWindow is how far ahead we predict connections
 	PredictionWindow time.Duration
 
+	// ActivityHorizon is how long activity is kept per minute, and
+	// UserRetention how long disconnected users are remembered; the
+	// activity window may not exceed either
+	ActivityHorizon time.Duration
+	UserRetention   time.Duration
+
+	// LeadTimeEnabled stretches a pool's prediction window to the p90 of its
+	// observed boot times when boots take longer than the window
+	LeadTimeEnabled bool
+
 	// MinReadyNodes is the minimum number of ready nodes to maintain
 	MinReadyNodes int
 
 	// MaxReadyNodes is the maximum number of ready nodes to maintain
 	MaxReadyNodes int
 
+	// BurstMaxNodes lets demand-driven scale-ups take a pool past
+	// MaxReadyNodes up to this hard cap; a pool does not burst while it is
+	// 0 or at most MaxReadyNodes
+	BurstMaxNodes int
+
+	// BurstIdleTimeout is the idle timeout of nodes provisioned above
+	// MaxReadyNodes, shorter than IdleTerminationTimeout so they go first
+	// once the spike subsides
+	BurstIdleTimeout time.Duration
+
 	// IdleTerminationTimeout is how long a ready node can be idle before termination
 	IdleTerminationTimeout time.Duration
 
 	// BootingNodeTimeout is the timeout for booting nodes
 	BootingNodeTimeout time.Duration
+
+	// UsersPerNode is the number of users a node hosts at once; demand is
+	// counted in these slots (0 or 1 gives every user a dedicated node)
+	UsersPerNode int
+
+	// ForecastEnabled raises demand to the seasonal forecast of concurrent
+	// connections when it exceeds the likely-user count
+	ForecastEnabled bool
+
+	// SurplusScaleDown retires ready nodes beyond forecast demand plus
+	// SurplusMargin without waiting for their idle timeout
+	SurplusScaleDown bool
+
+	// SurplusMargin is the fraction of demand kept as extra slots on top of
+	// it when retiring surplus nodes
+	SurplusMargin float64
+
+	// SurplusStep is the most surplus nodes retired at once
+	SurplusStep int
+
+	// InstanceTypes gives each instance type its own pool limits and
+	// timeouts; when empty all nodes form a single pool governed by the
+	// fields above
+	InstanceTypes map[string]InstanceTypePolicy
+
+	// DefaultInstanceType receives predicted demand and emergency
+	// provisioning, and owns nodes that report no configured type
+	DefaultInstanceType string
+
+	// ScalingPolicy holds rules run before the built-in demand rules, able to
+	// change the ready node limits those see or to decide in their place;
+	// nil leaves demand mode to the built-in rules
+	ScalingPolicy *policy.Policy
+
+	// PolicyLocation is the zone the scaling policy reads the hour and
+	// weekday in; nil for UTC
+	PolicyLocation *time.Location
+
+	// Dedicated users and tenants always have a warm node held for them.
+	// Held nodes are outside every pool: they neither serve demand nor
+	// count towards the pool limits.
+	Dedicated []node.Dedication
+}
+
+// InstanceTypePolicy holds the pool limits and timeouts for one instance type
+type InstanceTypePolicy struct {
+	MinReadyNodes          int
+	MaxReadyNodes          int
+	BurstMaxNodes          int
+	BurstIdleTimeout       time.Duration
+	IdleTerminationTimeout time.Duration
+	BootingNodeTimeout     time.Duration
+	UsersPerNode           int
+}
+
+// slots returns the number of users a node of the type hosts at once
+func (p InstanceTypePolicy) slots() int {
+	if p.UsersPerNode < 1 {
+		return 1
+	}
+	return p.UsersPerNode
+}
+
+// limit returns the most nodes a scale-up may bring the pool to: the burst
+// cap for demand-driven scale-ups when bursting is enabled, MaxReadyNodes
+// otherwise
+func (p InstanceTypePolicy) limit(burst bool) int {
+	if burst && p.BurstMaxNodes > p.MaxReadyNodes {
+		return p.BurstMaxNodes
+	}
+	return p.MaxReadyNodes
+}
+
+// idleTimeout returns how long a ready node of the pool may stay idle
+func (p InstanceTypePolicy) idleTimeout(n *node.Node) time.Duration {
+	if n.Burst && p.BurstIdleTimeout > 0 {
+		return p.BurstIdleTimeout
+	}
+	return p.IdleTerminationTimeout
+}
+
+// PolicyFor returns the limits applying to an instance type
+func (c PredictionConfig) PolicyFor(instanceType string) InstanceTypePolicy {
+	if policy, ok := c.InstanceTypes[instanceType]; ok {
+		return policy
+	}
+	return InstanceTypePolicy{
+		MinReadyNodes:          c.MinReadyNodes,
+		MaxReadyNodes:          c.MaxReadyNodes,
+		BurstMaxNodes:          c.BurstMaxNodes,
+		BurstIdleTimeout:       c.BurstIdleTimeout,
+		IdleTerminationTimeout: c.IdleTerminationTimeout,
+		BootingNodeTimeout:     c.BootingNodeTimeout,
+		UsersPerNode:           c.UsersPerNode,
+	}
+}
+
+// TypeOf returns the instance type whose pool a node belongs to, or "" when
+// instance types are not configured
+func (c PredictionConfig) TypeOf(n *node.Node) string {
+	if len(c.InstanceTypes) == 0 {
+		return ""
+	}
+	if _, ok := c.InstanceTypes[n.InstanceType]; ok {
+		return n.InstanceType
+	}
+	return c.DefaultInstanceType
+}
+
+// PoolOf returns the instance type whose pool a node is counted in: the
+// one that asked for it when it spilled over to another type, or else its
+// own
+func (c PredictionConfig) PoolOf(n *node.Node) string {
+	if _, ok := c.InstanceTypes[n.PoolType]; ok {
+		return n.PoolType
+	}
+	return c.TypeOf(n)
+}
+
+// instanceTypes returns the configured types in a stable order, or a single
+// untyped pool
+func (c PredictionConfig) instanceTypes() []string {
+	if len(c.InstanceTypes) == 0 {
+		return []string{""}
+	}
+	types := make([]string, 0, len(c.InstanceTypes))
+	for instanceType := range c.InstanceTypes {
+		types = append(types, instanceType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// filter matches the nodes in an instance type's pool; nodes held for a
+// dedicated user or tenant are in none
+func (c PredictionConfig) filter(instanceType string) node.Filter {
+	return func(n *node.Node) bool {
+		return n.Dedicated.IsZero() && (len(c.InstanceTypes) == 0 || c.PoolOf(n) == instanceType)
+	}
+}
+
+// receivesDemand reports whether predicted demand is served from a type's pool
+func (c PredictionConfig) receivesDemand(instanceType string) bool {
+	return len(c.InstanceTypes) == 0 || instanceType == c.DefaultInstanceType
+}
+
+// Validate checks that the instance type configuration is usable
+func (c PredictionConfig) Validate() error {
+	if c.UsersPerNode < 0 {
+		return fmt.Errorf("invalid users per node: %d", c.UsersPerNode)
+	}
+	for _, d := range c.Dedicated {
+		if d.IsZero() || d.UserID != "" && d.TenantID != "" {
+			return fmt.Errorf("dedication must name one user or one tenant: %+v", d)
+		}
+	}
+	if len(c.InstanceTypes) == 0 {
+		return nil
+	}
+	if _, ok := c.InstanceTypes[c.DefaultInstanceType]; !ok {
+		return fmt.Errorf("default instance type %q has no policy", c.DefaultInstanceType)
+	}
+	for instanceType, policy := range c.InstanceTypes {
+		if policy.MinReadyNodes < 0 || policy.MaxReadyNodes < 1 || policy.MinReadyNodes > policy.MaxReadyNodes {
+			return fmt.Errorf("invalid ready node limits for instance type %q: min=%d max=%d",
+				instanceType, policy.MinReadyNodes, policy.MaxReadyNodes)
+		}
+		if policy.UsersPerNode < 0 {
+			return fmt.Errorf("invalid users per node for instance type %q: %d", instanceType, policy.UsersPerNode)
+		}
+	}
+	return nil
 }
 
 // DefaultPredictionConfig returns default prediction configuration
 func DefaultPredictionConfig() PredictionConfig {
 	return PredictionConfig{
+		ScalingMode:            ScalingModeDemand,
+		TargetHeadroom:         0.2,
 		ActivityWindow:         2 * time.Minute,
 		ActivityThreshold:      3,
 		PredictionWindow:       1 * time.Minute,
+		ActivityHorizon:        time.Hour,
+		UserRetention:          time.Hour,
 		MinReadyNodes:          1,
 		MaxReadyNodes:          5,
 		IdleTerminationTimeout: 5 * time.Minute,
 		BootingNodeTimeout:     2 * time.Minute,
+		SurplusMargin:          0.2,
+		SurplusStep:            1,
 	}
 }
 
 // Predictor implements the predictive scaling algorithm
 type Predictor struct {
+	mu          sync.RWMutex
 	config      PredictionConfig
 	userTracker *user.UserTracker
 	nodePool    *node.NodePool
+	forecaster  *forecast.Forecaster
+	guard       *safety.Guard
+	bootTimes   *boottime.Tracker
+	plugin      *Plugin            // Nil unless an out-of-process predictor is configured
+	schedule    *schedule.Schedule // Nil unless scheduled sessions are planned for
+
+	tuned bool // Set once the tuning is changed at runtime
+
+	floorsMu sync.Mutex
+	floors   map[string]int // Minimum ready nodes the scaling policy left at the last scaling check, by instance type
 }
 
 // NewPredictor creates a new predictor
-func NewPredictor(config PredictionConfig, userTracker *user.UserTracker, nodePool *node.NodePool) *Predictor {
+func NewPredictor(config PredictionConfig, userTracker *user.UserTracker, nodePool *node.NodePool, forecaster *forecast.Forecaster, guard *safety.Guard, bootTimes *boottime.Tracker) *Predictor {
 	return &Predictor{
 		config:      config,
 		userTracker: userTracker,
 		nodePool:    nodePool,
+		forecaster:  forecaster,
+		guard:       guard,
+		bootTimes:   bootTimes,
+		floors:      make(map[string]int),
 	}
 }
 
+// SetPlugin hands scaling decisions to an out-of-process predictor; pools
+// it leaves undecided, or every pool while it is unreachable, keep the
+// built-in prediction
+func (p *Predictor) SetPlugin(plugin *Plugin) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.plugin = plugin
+}
+
+// SetSchedule adds the attendees of scheduled sessions to predicted demand
+func (p *Predictor) SetSchedule(s *schedule.Schedule) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.schedule = s
+}
+
+// Schedule returns the scheduled sessions planned for, or nil if none are
+func (p *Predictor) Schedule() *schedule.Schedule {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.schedule
+}
+
+// Config returns a copy of the current prediction configuration
+func (p *Predictor) Config() PredictionConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.config
+}
+
+// SetReadyNodeLimits updates the minimum and maximum pool sizes at runtime.
+// With instance types configured they apply to the default type's pool.
+func (p *Predictor) SetReadyNodeLimits(minReady, maxReady int) error {
+	_, err := p.UpdateTuning(func(t *Tuning) {
+		t.MinReadyNodes, t.MaxReadyNodes = minReady, maxReady
+	})
+	return err
+}
+
+// ReadyNodeLimits returns the limits changed by SetReadyNodeLimits
+func (p *Predictor) ReadyNodeLimits() (int, int) {
+	cfg := p.Config()
+	policy := cfg.PolicyFor(cfg.DefaultInstanceType)
+	return policy.MinReadyNodes, policy.MaxReadyNodes
+}
+
+// ObserveBootTime records the time a node took from provisioning to ready
+// under its pool's instance type
+func (p *Predictor) ObserveBootTime(n *node.Node, d time.Duration) {
+	p.bootTimes.Observe(p.Config().TypeOf(n), d)
+}
+
+// BootTimes returns the recent boot time percentiles of each pool
+func (p *Predictor) BootTimes() map[string]boottime.Stats {
+	return p.bootTimes.Snapshot()
+}
+
+// PredictionWindow returns how far ahead demand for an instance type's pool
+// is predicted
+func (p *Predictor) PredictionWindow(instanceType string) time.Duration {
+	return p.window(p.Config(), instanceType)
+}
+
+// DemandWindow returns the prediction window of the pool serving predicted
+// demand
+func (p *Predictor) DemandWindow() time.Duration {
+	cfg := p.Config()
+	instanceType := ""
+	if len(cfg.InstanceTypes) > 0 {
+		instanceType = cfg.DefaultInstanceType
+	}
+	return p.window(cfg, instanceType)
+}
+
+// window returns the prediction window of a pool. With lead time modeling
+// a window shorter than the p90 boot time is stretched to it, so nodes are
+// provisioned for the demand expected when they will actually be ready.
+func (p *Predictor) window(cfg PredictionConfig, instanceType string) time.Duration {
+	if !cfg.LeadTimeEnabled {
+		return cfg.PredictionWindow
+	}
+	lead, ok := p.bootTimes.Percentile(instanceType, 0.9)
+	if !ok {
+		return cfg.PredictionWindow
+	}
+	return max(cfg.PredictionWindow, lead)
+}
+
+// Capacity returns the number of users a node hosts at once under its
+// instance type's policy
+func (p *Predictor) Capacity(n *node.Node) int {
+	cfg := p.Config()
+	return cfg.PolicyFor(cfg.TypeOf(n)).slots()
+}
+
 // ScalingDecision represents a decision to scale nodes
 type ScalingDecision struct {
 	ShouldScaleUp   bool
 	ShouldScaleDown bool
 	TargetNodes     int
 	Reason          string
+
+	// InstanceType is the pool a per-type decision applies to
+	InstanceType string
+
+	// Labels are requested for the nodes a scale-up provisions, to serve
+	// users waiting for nodes matching them
+	Labels node.Labels
+
+	// BurstNodes is how many of a scale-up's nodes take the pool past its
+	// MaxReadyNodes; they are provisioned last and retired first
+	BurstNodes int
+
+	// Types holds the per-type decisions this one aggregates when instance
+	// types are configured; TargetNodes is then the total to provision, or
+	// to release when nothing needs provisioning
+	Types []ScalingDecision
+}
+
+// ScaleUps returns the pools a decision provisions nodes for
+func (d ScalingDecision) ScaleUps() []ScalingDecision {
+	if len(d.Types) == 0 {
+		if d.ShouldScaleUp {
+			return []ScalingDecision{d}
+		}
+		return nil
+	}
+
+	var ups []ScalingDecision
+	for _, t := range d.Types {
+		if t.ShouldScaleUp {
+			ups = append(ups, t)
+		}
+	}
+	return ups
 }
 
 // CalculateScaling determines if we need to scale up or down
 func (p *Predictor) CalculateScaling() ScalingDecision {
-	// Get current node counts
-	readyCount := p.nodePool.CountByStatus(node.NodeStatusReady)
-	bootingCount := p.nodePool.CountByStatus(node.NodeStatusBooting)
-	allocatedCount := p.nodePool.CountByStatus(node.NodeStatusAllocated)
+	cfg := p.Config()
+	plugged := p.pluginDecisions(cfg)
+	if len(cfg.InstanceTypes) == 0 {
+		return p.calculateScaling(cfg, "", plugged)
+	}
+
+	var decision ScalingDecision
+	var upReasons, downReasons []string
+	scaleDownNodes := 0
+	for _, instanceType := range cfg.instanceTypes() {
+		d := p.calculateScaling(cfg, instanceType, plugged)
+		decision.Types = append(decision.Types, d)
+
+		if d.ShouldScaleUp {
+			decision.ShouldScaleUp = true
+			decision.TargetNodes += d.TargetNodes
+			upReasons = append(upReasons, instanceType+": "+d.Reason)
+		}
+		if d.ShouldScaleDown {
+			decision.ShouldScaleDown = true
+			scaleDownNodes += d.TargetNodes
+			downReasons = append(downReasons, instanceType+": "+d.Reason)
+		}
+	}
 
-	// Get likely-to-connect users
-	likelyUsers := p.userTracker.GetLikelyToConnect(
-		p.config.ActivityThreshold,
-		p.config.ActivityWindow,
-	)
+	if decision.ShouldScaleUp {
+		decision.Reason = strings.Join(upReasons, "; ")
+	} else if decision.ShouldScaleDown {
+		decision.TargetNodes = scaleDownNodes
+		decision.Reason = strings.Join(downReasons, "; ")
+	}
+	return decision
+}
 
-	// Calculate demand: number of users likely to connect
-	demand := len(likelyUsers)
+// calculateScaling determines scaling for one instance type's pool, as the
+// plugin decided when it decided for the pool, and otherwise by the
+// strategy of the scaling mode: the scaling rules in demand mode
+func (p *Predictor) calculateScaling(cfg PredictionConfig, instanceType string, plugged map[string]pluginDecision) ScalingDecision {
+	policy := cfg.PolicyFor(instanceType)
+	filter := cfg.filter(instanceType)
 
-	// Calculate available capacity (ready + booting nodes)
-	availableCapacity := readyCount + bootingCount
+	// Get current node counts; only schedulable ready nodes count as capacity
+	readyCount := p.nodePool.CountSchedulableWhere(filter)
+	bootingCount := p.nodePool.CountByStatusWhere(node.NodeStatusBooting, filter)
+	allocatedCount := p.nodePool.CountOccupiedWhere(filter)
 
-	// Decision logic
-	decision := ScalingDecision{}
+	if d, ok := plugged[instanceType]; ok {
+		decision := ScalingDecision{InstanceType: instanceType}
+		if applyPluginDecision(&decision, d, policy, readyCount, bootingCount, allocatedCount) {
+			return decision
+		}
+	}
+
+	if cfg.ScalingMode == ScalingModeTargetUtilization {
+		decision := p.calculateTargetUtilization(cfg, policy, filter, readyCount, bootingCount, allocatedCount)
+		decision.InstanceType = instanceType
+		return decision
+	}
 
-	// Scale up if:
-	// 1. Demand exceeds available capacity
-	// 2. Ready nodes are below minimum threshold
-	if demand > availableCapacity {
+	env, demandReason := p.scalingEnv(cfg, instanceType)
+	outcome, _ := evaluatePolicy(cfg, instanceType, env)
+	limits := limitsOf(policy, env)
+	p.setFloor(instanceType, limits.MinReadyNodes)
+
+	decision := ScalingDecision{InstanceType: instanceType}
+	reason := strings.ReplaceAll(outcome.Reason, demandReasonPlaceholder, demandReason)
+	switch outcome.Action {
+	case actionScaleUp, actionBurst:
 		decision.ShouldScaleUp = true
-		decision.TargetNodes = demand - availableCapacity
-		decision.Reason = "demand exceeds capacity"
-	} else if readyCount < p.config.MinReadyNodes && (readyCount+bootingCount) < p.config.MinReadyNodes {
+		decision.TargetNodes = outcome.Nodes
+		decision.Reason = reason
+	case actionScaleDown:
+		// Only ready nodes beyond the minimum can be released
+		if excess := min(outcome.Nodes, readyCount-limits.MinReadyNodes); excess > 0 {
+			decision.ShouldScaleDown = true
+			decision.TargetNodes = excess
+			decision.Reason = reason
+		}
+	}
+
+	// The minimum holds whatever the rules decided
+	if !decision.ShouldScaleUp && readyCount+bootingCount < limits.MinReadyNodes {
 		decision.ShouldScaleUp = true
-		decision.TargetNodes = p.config.MinReadyNodes - (readyCount + bootingCount)
+		decision.ShouldScaleDown = false
+		decision.TargetNodes = limits.MinReadyNodes - (readyCount + bootingCount)
 		decision.Reason = "maintaining minimum ready nodes"
 	}
+	capScaleUp(&decision, limits, readyCount+bootingCount+allocatedCount, outcome.Action == actionBurst)
 
-	// Cap scale-up to max ready nodes
-	if decision.ShouldScaleUp {
-		totalNodes := readyCount + bootingCount + allocatedCount + decision.TargetNodes
-		if totalNodes > p.config.MaxReadyNodes {
-			decision.TargetNodes = p.config.MaxReadyNodes - (readyCount + bootingCount + allocatedCount)
-			if decision.TargetNodes <= 0 {
-				decision.ShouldScaleUp = false
-			}
+	return decision
+}
+
+// demand returns the slots a demand-receiving pool needs for users likely to
+// connect, or for the forecast when that is higher, plus the attendees of
+// scheduled sessions beyond the users already connected, with the reason to
+// give for scaling up to it. The forecast needs the expected concurrency
+// beyond the users already connected, and at least a slot for each connect
+// expected within the window.
+func (p *Predictor) demand(cfg PredictionConfig, instanceType string, filter node.Filter) (int, string) {
+	demand := len(p.userTracker.GetLikelyToConnect(
+		cfg.ActivityThreshold,
+		cfg.ActivityWindow,
+	))
+	reason := "demand exceeds capacity"
+
+	if cfg.ForecastEnabled {
+		window := p.window(cfg, instanceType)
+		est := p.forecaster.Forecast(time.Now().Add(window))
+		connected := p.nodePool.CountUsersWhere(filter)
+		arrivals := est.ConnectRate * window.Minutes()
+		forecastDemand := max(int(math.Ceil(est.Concurrent))-connected, int(math.Ceil(arrivals)))
+		if est.Samples > 0 && forecastDemand > demand {
+			demand = forecastDemand
+			reason = fmt.Sprintf("forecast demand exceeds capacity (%.1f concurrent, %.1f connects expected)", est.Concurrent, arrivals)
+		}
+	}
+
+	p.mu.RLock()
+	sched := p.schedule
+	p.mu.RUnlock()
+	if sched != nil {
+		est := sched.Expected(time.Now().Add(p.window(cfg, instanceType)))
+		connected := p.nodePool.CountUsersWhere(filter)
+		if scheduled := int(math.Ceil(est.Attendees)) - connected; scheduled > 0 {
+			demand += scheduled
+			reason = fmt.Sprintf("%s, with %d scheduled attendees expected (%s)", reason, scheduled, sessionNames(est.Sessions))
+		}
+	}
+
+	return demand, reason
+}
+
+// sessionNames names the first few sessions for a scaling reason
+func sessionNames(sessions []schedule.Session) string {
+	const shown = 3
+	names := make([]string, 0, shown)
+	for _, s := range sessions[:min(len(sessions), shown)] {
+		name := s.Title
+		if name == "" {
+			name = s.ID
+		}
+		names = append(names, name)
+	}
+	if len(sessions) > shown {
+		names = append(names, fmt.Sprintf("%d more", len(sessions)-shown))
+	}
+	return strings.Join(names, ", ")
+}
+
+// AddQueuedDemand adds scale-ups for users waiting for a node whose labels
+// match their selector, when the ready and booting nodes matching it lack
+// free slots for them. Those nodes are of the default instance type, count
+// against its max_ready_nodes and are asked for with the selector as labels.
+func (p *Predictor) AddQueuedDemand(decision ScalingDecision, waiting []string) ScalingDecision {
+	cfg := p.Config()
+	instanceType := ""
+	if len(cfg.InstanceTypes) > 0 {
+		instanceType = cfg.DefaultInstanceType
+	}
+	policy := cfg.PolicyFor(instanceType)
+	typeFilter := cfg.filter(instanceType)
+
+	// Group waiting users by the labels they asked for
+	queued := make(map[string]int)
+	selectors := make(map[string]node.Labels)
+	for _, userID := range waiting {
+		selector := node.Labels(p.userTracker.SelectorOf(userID))
+		if len(selector) == 0 {
+			continue
+		}
+		key := selector.String()
+		queued[key]++
+		selectors[key] = selector
+	}
+	if len(queued) == 0 {
+		return decision
+	}
+
+	// Nodes already planned for the type count against its maximum
+	planned := p.nodePool.CountSchedulableWhere(typeFilter) +
+		p.nodePool.CountByStatusWhere(node.NodeStatusBooting, typeFilter) +
+		p.nodePool.CountOccupiedWhere(typeFilter)
+	for _, up := range decision.ScaleUps() {
+		if up.InstanceType == instanceType {
+			planned += up.TargetNodes
 		}
 	}
 
-	// Scale down if:
-	// 1. Ready nodes exceed max threshold
-	// 2. Too many ready nodes for current demand
-	excessNodes := readyCount - p.config.MinReadyNodes
-	if excessNodes > 0 && demand == 0 {
+	var ups []ScalingDecision
+	for _, key := range slices.Sorted(maps.Keys(queued)) {
+		selector := selectors[key]
+		filter := func(n *node.Node) bool {
+			return typeFilter(n) && n.Labels.Matches(selector)
+		}
+		available := p.nodePool.FreeSlotsWhere(filter) +
+			p.nodePool.CountByStatusWhere(node.NodeStatusBooting, filter)*policy.slots()
+		if queued[key] <= available {
+			continue
+		}
+
+		up := ScalingDecision{
+			ShouldScaleUp: true,
+			TargetNodes:   nodesFor(queued[key]-available, policy),
+			Reason:        fmt.Sprintf("%d users queued for nodes with %s", queued[key], key),
+			InstanceType:  instanceType,
+			Labels:        selector,
+		}
+		capScaleUp(&up, policy, planned, true)
+		if !up.ShouldScaleUp {
+			continue
+		}
+		planned += up.TargetNodes
+		ups = append(ups, up)
+	}
+	if len(ups) == 0 {
+		return decision
+	}
+
+	// A single-pool decision becomes the first of several
+	if len(decision.Types) == 0 {
+		decision.Types = []ScalingDecision{decision}
+	}
+	decision.Types = append(decision.Types, ups...)
+
+	var reasons []string
+	decision.TargetNodes = 0
+	for _, up := range decision.ScaleUps() {
+		decision.TargetNodes += up.TargetNodes
+		if up.InstanceType != "" {
+			reasons = append(reasons, up.InstanceType+": "+up.Reason)
+		} else {
+			reasons = append(reasons, up.Reason)
+		}
+	}
+	decision.ShouldScaleUp = true
+	decision.Reason = strings.Join(reasons, "; ")
+	return decision
+}
+
+// nodesFor returns the number of nodes needed to host the given number of users
+func nodesFor(users int, policy InstanceTypePolicy) int {
+	return (users + policy.slots() - 1) / policy.slots()
+}
+
+// calculateTargetUtilization keeps free slots proportional to connected
+// users; with dedicated nodes this is ready nodes proportional to allocated
+// nodes
+func (p *Predictor) calculateTargetUtilization(cfg PredictionConfig, policy InstanceTypePolicy, filter node.Filter, readyCount, bootingCount, allocatedCount int) ScalingDecision {
+	desired := desiredReadyNodes(cfg, policy, p.nodePool.CountUsersWhere(filter))
+	desiredSlots := desired * policy.slots()
+	freeSlots := p.nodePool.FreeSlotsWhere(filter)
+	availableSlots := freeSlots + bootingCount*policy.slots()
+
+	decision := ScalingDecision{}
+
+	if availableSlots < desiredSlots {
+		decision.ShouldScaleUp = true
+		decision.TargetNodes = nodesFor(desiredSlots-availableSlots, policy)
+		decision.Reason = fmt.Sprintf("below target headroom (%d/%d ready)", readyCount+bootingCount, desired)
+		capScaleUp(&decision, policy, readyCount+bootingCount+allocatedCount, true)
+	} else if excess := min(readyCount, (freeSlots-desiredSlots)/policy.slots()); excess > 0 {
+		// Only empty ready nodes can be released
 		decision.ShouldScaleDown = true
-		decision.TargetNodes = excessNodes
-		decision.Reason = "excess capacity with no demand"
+		decision.TargetNodes = excess
+		decision.Reason = fmt.Sprintf("above target headroom (%d/%d ready)", readyCount, desired)
 	}
 
 	return decision
 }
 
-// GetIdleNodes returns nodes that have been idle for too long
+// desiredReadyNodes returns the ready pool size targeted for the given number
+// of connected users in target-utilization mode
+func desiredReadyNodes(cfg PredictionConfig, policy InstanceTypePolicy, users int) int {
+	desired := nodesFor(int(math.Ceil(float64(users)*cfg.TargetHeadroom)), policy)
+	if desired < policy.MinReadyNodes {
+		desired = policy.MinReadyNodes
+	}
+	return desired
+}
+
+// readyFloor returns the number of ready nodes of a type that idle cleanup
+// must keep, as lowered or raised by the scaling policy at the last scaling
+// check. The policy is only evaluated here for a pool no check has seen yet.
+func (p *Predictor) readyFloor(cfg PredictionConfig, instanceType string) int {
+	policy := cfg.PolicyFor(instanceType)
+	if cfg.ScalingMode == ScalingModeTargetUtilization {
+		users := p.nodePool.CountUsersWhere(cfg.filter(instanceType))
+		return desiredReadyNodes(cfg, policy, users)
+	}
+	if cfg.ScalingPolicy.Len() == 0 {
+		return policy.MinReadyNodes
+	}
+
+	p.floorsMu.Lock()
+	floor, ok := p.floors[instanceType]
+	p.floorsMu.Unlock()
+	if ok {
+		return floor
+	}
+	env, _ := p.scalingEnv(cfg, instanceType)
+	evaluatePolicy(cfg, instanceType, env)
+	floor = limitsOf(policy, env).MinReadyNodes
+	p.setFloor(instanceType, floor)
+	return floor
+}
+
+// setFloor records the minimum ready nodes the scaling policy left a pool at
+func (p *Predictor) setFloor(instanceType string, floor int) {
+	p.floorsMu.Lock()
+	defer p.floorsMu.Unlock()
+	p.floors[instanceType] = floor
+}
+
+// Room returns how many more nodes the pool of an instance type may take
+// before reaching the cap a demand-driven scale-up may burst to, for nodes
+// provisioned outside the scaling check
+func (p *Predictor) Room(instanceType string) int {
+	cfg := p.Config()
+	if len(cfg.InstanceTypes) == 0 {
+		instanceType = ""
+	}
+	filter := cfg.filter(instanceType)
+	current := p.nodePool.CountSchedulableWhere(filter) +
+		p.nodePool.CountByStatusWhere(node.NodeStatusBooting, filter) +
+		p.nodePool.CountOccupiedWhere(filter)
+	return max(cfg.PolicyFor(instanceType).limit(true)-current, 0)
+}
+
+// capScaleUp limits a scale-up decision so the pool does not exceed
+// MaxReadyNodes or, for a demand-driven scale-up that may burst, the burst
+// cap. The nodes above MaxReadyNodes are counted in BurstNodes.
+func capScaleUp(decision *ScalingDecision, policy InstanceTypePolicy, currentNodes int, burst bool) {
+	if !decision.ShouldScaleUp {
+		return
+	}
+	if limit := policy.limit(burst); currentNodes+decision.TargetNodes > limit {
+		decision.TargetNodes = limit - currentNodes
+		if decision.TargetNodes <= 0 {
+			decision.ShouldScaleUp = false
+			return
+		}
+	}
+	if over := currentNodes + decision.TargetNodes - policy.MaxReadyNodes; over > 0 {
+		decision.BurstNodes = min(over, decision.TargetNodes)
+		decision.Reason = fmt.Sprintf("%s, bursting %d above max ready nodes", decision.Reason, decision.BurstNodes)
+	}
+}
+
+// MissingDedications returns the dedicated users and tenants without a ready
+// or booting node held for them. A dedicated user who is connected already
+// has a node and is not given a second one.
+func (p *Predictor) MissingDedications() []node.Dedication {
+	var missing []node.Dedication
+	for _, d := range p.Config().Dedicated {
+		if d.UserID != "" {
+			if state, ok := p.userTracker.GetUserState(d.UserID); ok && state.IsConnected {
+				continue
+			}
+		}
+		if !p.nodePool.HasDedicated(d) {
+			missing = append(missing, d)
+		}
+	}
+	return missing
+}
+
+// LikelyToConnect returns users predicted to connect within the prediction window
+func (p *Predictor) LikelyToConnect() []*user.UserState {
+	cfg := p.Config()
+	return p.userTracker.GetLikelyToConnect(cfg.ActivityThreshold, cfg.ActivityWindow)
+}
+
+// UserPrediction is whether a user counts towards predicted demand, and why
+type UserPrediction struct {
+	Likely    bool
+	Reason    string
+	Score     float64 // Weighted activity score
+	Threshold int     // Score a user needs to be counted
+	Window    time.Duration
+}
+
+// PredictUser explains whether a user is predicted to connect, applying
+// the same rules as LikelyToConnect
+func (p *Predictor) PredictUser(userID string) UserPrediction {
+	cfg := p.Config()
+	prediction := UserPrediction{
+		Threshold: cfg.ActivityThreshold,
+		Window:    cfg.ActivityWindow,
+	}
+
+	state, ok := p.userTracker.StateOf(userID)
+	switch {
+	case !ok:
+		prediction.Reason = "no activity recorded"
+		return prediction
+	case state.IsConnected:
+		prediction.Score = state.ActivityScore
+		prediction.Reason = "already connected"
+		return prediction
+	}
+
+	prediction.Score = state.ActivityScore
+	since := time.Since(state.LastActivityTime)
+	switch {
+	case state.LastActivityTime.IsZero():
+		prediction.Reason = "no activity with a positive weight recorded"
+	case since > cfg.ActivityWindow:
+		prediction.Reason = fmt.Sprintf("last activity %s ago, outside the %s activity window",
+			since.Round(time.Second), cfg.ActivityWindow)
+	case state.ActivityScore < float64(cfg.ActivityThreshold):
+		prediction.Reason = fmt.Sprintf("activity score %.1f below the threshold of %d",
+			state.ActivityScore, cfg.ActivityThreshold)
+	default:
+		prediction.Likely = true
+		prediction.Reason = fmt.Sprintf("activity score %.1f reaches the threshold of %d within the %s activity window",
+			state.ActivityScore, cfg.ActivityThreshold, cfg.ActivityWindow)
+	}
+	return prediction
+}
+
+// GetIdleNodes returns nodes that have been idle for too long, using each
+// instance type's idle timeout and ready floor
 func (p *Predictor) GetIdleNodes() []*node.Node {
-	readyNodes := p.nodePool.GetAllByStatus(node.NodeStatusReady)
-	cutoff := time.Now().Add(-p.config.IdleTerminationTimeout)
+	cfg := p.Config()
+
+	var idleNodes []*node.Node
+	for _, instanceType := range cfg.instanceTypes() {
+		idleNodes = append(idleNodes, p.idleNodes(cfg, instanceType)...)
+	}
+	return idleNodes
+}
+
+func (p *Predictor) idleNodes(cfg PredictionConfig, instanceType string) []*node.Node {
+	readyNodes := p.nodePool.GetAllByStatusWhere(node.NodeStatusReady, cfg.filter(instanceType))
+	policy := cfg.PolicyFor(instanceType)
+	now := time.Now()
 
 	var idleNodes []*node.Node
 	for _, n := range readyNodes {
-		if n.UpdatedAt.Before(cutoff) {
-			idleNodes = append(idleNodes, n)
+		if !n.UpdatedAt.Before(now.Add(-policy.idleTimeout(n))) || n.IsReserved() {
+			continue
 		}
+		// A ready node with a user on it means state is inconsistent; never
+		// offer it for termination
+		if userID, inUse := p.guard.InUse(n); inUse {
+			p.guard.Violation(safety.CheckIdleInUse, n, userID)
+			continue
+		}
+		idleNodes = append(idleNodes, n)
 	}
 
 	// Ensure we don't terminate below minimum
 	readyCount := len(readyNodes)
-	maxTerminations := readyCount - p.config.MinReadyNodes
+	maxTerminations := readyCount - p.readyFloor(cfg, instanceType)
 	if maxTerminations < 0 {
 		maxTerminations = 0
 	}
 
 	if len(idleNodes) > maxTerminations {
+		// Burst nodes are the first to go
+		slices.SortStableFunc(idleNodes, burstFirst)
 		idleNodes = idleNodes[:maxTerminations]
 	}
 
 	return idleNodes
 }
 
+// SurplusNodes returns up to SurplusStep ready nodes whose free slots exceed
+// what each pool needs: forecast demand plus SurplusMargin, or the target
+// ready pool size in target-utilization mode and for pools that serve no
+// predicted demand. Pools never drop below their ready floor, and the nodes
+// idle longest go first.
+func (p *Predictor) SurplusNodes() []*node.Node {
+	cfg := p.Config()
+	if !cfg.SurplusScaleDown || cfg.SurplusStep < 1 {
+		return nil
+	}
+
+	var surplus []*node.Node
+	for _, instanceType := range cfg.instanceTypes() {
+		surplus = append(surplus, p.surplusNodes(cfg, instanceType)...)
+		if len(surplus) >= cfg.SurplusStep {
+			return surplus[:cfg.SurplusStep]
+		}
+	}
+	return surplus
+}
+
+func (p *Predictor) surplusNodes(cfg PredictionConfig, instanceType string) []*node.Node {
+	filter := cfg.filter(instanceType)
+	slots := cfg.PolicyFor(instanceType).slots()
+	floor := p.readyFloor(cfg, instanceType)
+
+	required := floor * slots
+	if cfg.ScalingMode != ScalingModeTargetUtilization && cfg.receivesDemand(instanceType) {
+		demand, _ := p.demand(cfg, instanceType, filter)
+		required = max(required, int(math.Ceil(float64(demand)*(1+cfg.SurplusMargin))))
+	}
+
+	available := p.nodePool.FreeSlotsWhere(filter) +
+		p.nodePool.CountByStatusWhere(node.NodeStatusBooting, filter)*slots
+	excess := available - required
+	if excess <= 0 {
+		return nil
+	}
+
+	readyNodes := p.nodePool.GetAllByStatusWhere(node.NodeStatusReady, filter)
+	slices.SortFunc(readyNodes, func(a, b *node.Node) int {
+		if c := burstFirst(a, b); c != 0 {
+			return c
+		}
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	})
+	retirable := len(readyNodes) - floor
+
+	var surplus []*node.Node
+	for _, n := range readyNodes {
+		if len(surplus) >= retirable {
+			break
+		}
+		if n.Cordoned || n.Draining || n.IsReserved() || n.FreeSlots() > excess {
+			continue
+		}
+		if userID, inUse := p.guard.InUse(n); inUse {
+			p.guard.Violation(safety.CheckIdleInUse, n, userID)
+			continue
+		}
+		surplus = append(surplus, n)
+		excess -= n.FreeSlots()
+	}
+
+	return surplus
+}
+
+// burstFirst orders burst nodes before the rest of their pool
+func burstFirst(a, b *node.Node) int {
+	switch {
+	case a.Burst == b.Burst:
+		return 0
+	case a.Burst:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// SettledBurstNodes returns the burst nodes a pool has beyond its nodes
+// above max ready nodes, which settle into the pool once it shrinks back.
+// Occupied nodes settle first, then those used most recently, so the nodes
+// idle longest stay burst nodes and are retired first.
+func (p *Predictor) SettledBurstNodes() []*node.Node {
+	cfg := p.Config()
+
+	var settled []*node.Node
+	for _, instanceType := range cfg.instanceTypes() {
+		filter := cfg.filter(instanceType)
+		var burst []*node.Node
+		for _, status := range []node.NodeStatus{node.NodeStatusBooting, node.NodeStatusReady, node.NodeStatusAllocated, node.NodeStatusUnconfirmed} {
+			for _, n := range p.nodePool.GetAllByStatusWhere(status, filter) {
+				if n.Burst {
+					burst = append(burst, n)
+				}
+			}
+		}
+
+		current := p.nodePool.CountSchedulableWhere(filter) +
+			p.nodePool.CountByStatusWhere(node.NodeStatusBooting, filter) +
+			p.nodePool.CountOccupiedWhere(filter)
+		keep := max(current-cfg.PolicyFor(instanceType).MaxReadyNodes, 0)
+		if len(burst) <= keep {
+			continue
+		}
+
+		slices.SortFunc(burst, func(a, b *node.Node) int {
+			switch {
+			case a.Occupied() && !b.Occupied():
+				return -1
+			case b.Occupied() && !a.Occupied():
+				return 1
+			}
+			return b.UpdatedAt.Compare(a.UpdatedAt)
+		})
+		settled = append(settled, burst[:len(burst)-keep]...)
+	}
+	return settled
+}
+
+// SkippedTermination records an idle node that was kept alive by the thrash guard
+type SkippedTermination struct {
+	Node   *node.Node
+	Reason string
+}
+
+// SimulateTermination evaluates the post-termination state of each pool
+// against the current likely-to-connect users (or the target ready pool size
+// in target-utilization mode, and the ready floor for pools that serve no
+// predicted demand). Candidates whose termination would leave fewer free and
+// booting slots than that demand are skipped, since they would immediately be
+// re-provisioned.
+func (p *Predictor) SimulateTermination(candidates []*node.Node) ([]*node.Node, []SkippedTermination) {
+	cfg := p.Config()
+
+	remaining := make(map[string]int)
+	demand := make(map[string]int)
+	for _, instanceType := range cfg.instanceTypes() {
+		filter := cfg.filter(instanceType)
+		slots := cfg.PolicyFor(instanceType).slots()
+		remaining[instanceType] = p.nodePool.FreeSlotsWhere(filter) +
+			p.nodePool.CountByStatusWhere(node.NodeStatusBooting, filter)*slots
+
+		if cfg.ScalingMode == ScalingModeTargetUtilization || !cfg.receivesDemand(instanceType) {
+			demand[instanceType] = p.readyFloor(cfg, instanceType) * slots
+		} else {
+			demand[instanceType] = len(p.userTracker.GetLikelyToConnect(
+				cfg.ActivityThreshold,
+				cfg.ActivityWindow,
+			))
+		}
+	}
+
+	var approved []*node.Node
+	var skipped []SkippedTermination
+
+	for _, n := range candidates {
+		instanceType := cfg.PoolOf(n)
+		left := remaining[instanceType] - n.FreeSlots()
+		if left < demand[instanceType] {
+			skipped = append(skipped, SkippedTermination{
+				Node: n,
+				Reason: fmt.Sprintf("would leave %d free slots for %d likely users",
+					left, demand[instanceType]),
+			})
+			continue
+		}
+		remaining[instanceType] = left
+		approved = append(approved, n)
+	}
+
+	return approved, skipped
+}
+
 // GetStuckBootingNodes returns nodes that have been booting for too long
 func (p *Predictor) GetStuckBootingNodes() []*node.Node {
+	cfg := p.Config()
 	bootingNodes := p.nodePool.GetAllByStatus(node.NodeStatusBooting)
-	cutoff := time.Now().Add(-p.config.BootingNodeTimeout)
+	now := time.Now()
 
 	var stuckNodes []*node.Node
 	for _, n := range bootingNodes {
-		if n.CreatedAt.Before(cutoff) {
+		timeout := cfg.PolicyFor(cfg.TypeOf(n)).BootingNodeTimeout
+		if n.CreatedAt.Before(now.Add(-timeout)) {
 			stuckNodes = append(stuckNodes, n)
 		}
 	}
@@ -0,0 +1,174 @@
+package predictor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/policy"
+)
+
+func TestPoolOf(t *testing.T) {
+	cfg := PredictionConfig{
+		InstanceTypes: map[string]InstanceTypePolicy{
+			"a100": {MaxReadyNodes: 2},
+			"h100": {MaxReadyNodes: 2},
+		},
+		DefaultInstanceType: "a100",
+	}
+
+	tests := []struct {
+		name string
+		node node.Node
+		pool string
+		kind string
+	}{
+		{name: "own type", node: node.Node{InstanceType: "h100"}, pool: "h100", kind: "h100"},
+		{name: "spilled over", node: node.Node{InstanceType: "h100", PoolType: "a100"}, pool: "a100", kind: "h100"},
+		{name: "unknown type", node: node.Node{InstanceType: "t4"}, pool: "a100", kind: "a100"},
+		{name: "unknown pool", node: node.Node{InstanceType: "h100", PoolType: "t4"}, pool: "h100", kind: "h100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.PoolOf(&tt.node); got != tt.pool {
+				t.Errorf("PoolOf = %q, want %q", got, tt.pool)
+			}
+			if got := cfg.TypeOf(&tt.node); got != tt.kind {
+				t.Errorf("TypeOf = %q, want %q", got, tt.kind)
+			}
+			if !cfg.filter(tt.pool)(&tt.node) {
+				t.Errorf("node not in the %s pool", tt.pool)
+			}
+		})
+	}
+}
+
+func TestReadyFloor(t *testing.T) {
+	zone := time.FixedZone("UTC+13", 13*60*60)
+	hour := time.Now().In(zone).Hour()
+	cfg := PredictionConfig{
+		InstanceTypes: map[string]InstanceTypePolicy{
+			"a100": {MinReadyNodes: 1, MaxReadyNodes: 5},
+			"h100": {MinReadyNodes: 1, MaxReadyNodes: 5},
+		},
+		DefaultInstanceType: "a100",
+		ScalingPolicy: policy.MustCompile([]policy.Rule{
+			{If: fmt.Sprintf("hour == %d", hour), Then: "min_ready = 3"},
+		}),
+		PolicyLocation: zone,
+	}
+	p := NewPredictor(cfg, nil, node.NewNodePool(node.AgentCompatibility{}), nil, nil, nil)
+
+	if got := p.readyFloor(cfg, "h100"); got != 3 {
+		t.Fatalf("readyFloor = %d, want the policy's floor read in its zone", got)
+	}
+	// The floor of the last scaling check holds until the next one
+	p.setFloor("h100", 2)
+	if got := p.readyFloor(cfg, "h100"); got != 2 {
+		t.Errorf("readyFloor = %d, want the cached floor", got)
+	}
+}
+
+func TestSettledBurstNodes(t *testing.T) {
+	cfg := PredictionConfig{
+		InstanceTypes: map[string]InstanceTypePolicy{
+			"a100": {MaxReadyNodes: 3, BurstMaxNodes: 6},
+			"h100": {MaxReadyNodes: 3, BurstMaxNodes: 6},
+		},
+		DefaultInstanceType: "a100",
+	}
+	pool := node.NewNodePool(node.AgentCompatibility{})
+	p := NewPredictor(cfg, nil, pool, nil, nil, nil)
+
+	now := time.Now()
+	nodeOf := func(id, instanceType string, status node.NodeStatus, burst bool, idle time.Duration) node.Node {
+		n := node.Node{ID: id, InstanceType: instanceType, Status: status, Burst: burst, UpdatedAt: now.Add(-idle)}
+		if status == node.NodeStatusAllocated {
+			n.Users = []string{"u-" + id}
+		}
+		return n
+	}
+	pool.Replace([]node.Node{
+		nodeOf("plain-1", "a100", node.NodeStatusReady, false, 0),
+		nodeOf("plain-2", "a100", node.NodeStatusReady, false, 0),
+		nodeOf("busy", "a100", node.NodeStatusAllocated, true, 0),
+		nodeOf("idle-long", "a100", node.NodeStatusReady, true, 2*time.Hour),
+		nodeOf("idle-short", "a100", node.NodeStatusReady, true, time.Minute),
+		nodeOf("alone", "h100", node.NodeStatusReady, true, 0),
+	})
+
+	ids := func(nodes []*node.Node) string {
+		var ids []string
+		for _, n := range nodes {
+			ids = append(ids, n.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+
+	// Two nodes above the a100 limit keep two of its three burst nodes; the
+	// h100 pool is within its limit
+	if got := ids(p.SettledBurstNodes()); got != "busy,alone" {
+		t.Errorf("settled %s, want busy,alone", got)
+	}
+
+	for _, id := range []string{"busy", "alone"} {
+		pool.ClearBurst(id)
+	}
+	pool.Remove("plain-1")
+	if got := ids(p.SettledBurstNodes()); got != "idle-short" {
+		t.Errorf("settled %s once a node left, want idle-short", got)
+	}
+
+	pool.ClearBurst("idle-short")
+	if got := ids(p.SettledBurstNodes()); got != "" {
+		t.Errorf("settled %s, want none", got)
+	}
+}
+
+func TestUpdateTuning(t *testing.T) {
+	single := DefaultPredictionConfig()
+	typed := DefaultPredictionConfig()
+	typed.InstanceTypes = map[string]InstanceTypePolicy{"a100": {MinReadyNodes: 1, MaxReadyNodes: 5, IdleTerminationTimeout: time.Minute}}
+	typed.DefaultInstanceType = "a100"
+
+	tests := []struct {
+		name   string
+		config PredictionConfig
+		change func(t *Tuning)
+		want   string // Expected problem; empty if the change applies
+	}{
+		{name: "burst below max", config: single, change: func(t *Tuning) { t.BurstMaxNodes = 5 }, want: "burst_max_nodes: 5 must exceed max_ready_nodes (5)"},
+		{name: "window beyond retention", config: single, change: func(t *Tuning) { t.ActivityWindow = 2 * time.Hour }, want: "activity_window: 2h0m0s exceeds user_retention (1h0m0s)"},
+		{name: "window beyond horizon", config: single, change: func(t *Tuning) { t.ActivityWindow, t.ActivityHorizon = 90*time.Minute, 2*time.Hour }, want: "activity_window: 1h30m0s exceeds activity_horizon (1h0m0s)"},
+		{name: "retention is fixed", config: single, change: func(t *Tuning) { t.ActivityWindow, t.UserRetention = 2*time.Hour, 3*time.Hour }, want: "exceeds user_retention (1h0m0s)"},
+		{name: "no idle timeout", config: single, change: func(t *Tuning) { t.IdleTerminationTimeout = 0 }, want: "idle_termination_timeout: must be positive"},
+		{name: "pool idle timeout falls back", config: typed, change: func(t *Tuning) { t.IdleTerminationTimeout = 0 }},
+		{name: "min above max", config: single, change: func(t *Tuning) { t.MinReadyNodes = 6 }, want: "min_ready_nodes: 6 exceeds max_ready_nodes (5)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPredictor(tt.config, nil, node.NewNodePool(node.AgentCompatibility{}), nil, nil, nil)
+			tuning, err := p.UpdateTuning(tt.change)
+			if tt.want != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.want) {
+					t.Fatalf("UpdateTuning = %v, want %s", err, tt.want)
+				}
+				if _, tuned := p.Overrides(); tuned {
+					t.Error("rejected change counted as an override")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UpdateTuning: %v", err)
+			}
+			if tuning.IdleTerminationTimeout != tt.config.IdleTerminationTimeout {
+				t.Errorf("idle timeout = %s, want the global %s", tuning.IdleTerminationTimeout, tt.config.IdleTerminationTimeout)
+			}
+			if overrides, tuned := p.Overrides(); !tuned || overrides != tuning {
+				t.Errorf("Overrides = %+v, %v; want the change", overrides, tuned)
+			}
+		})
+	}
+}
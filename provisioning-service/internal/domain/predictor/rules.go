@@ -0,0 +1,91 @@
+package predictor
+
+import (
+	"cmp"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/policy"
+)
+
+// demandReasonPlaceholder in a rule's reason stands for what predicted
+// demand is made of, e.g. forecast or scheduled attendees
+const demandReasonPlaceholder = "{demand_reason}"
+
+// Scaling rule actions a decision is made from
+const (
+	actionScaleUp   = policy.ActionScaleUp
+	actionBurst     = policy.ActionBurst
+	actionScaleDown = policy.ActionScaleDown
+)
+
+// builtinRules are demand mode's scaling rules, run after any configured
+// ones. Pools that serve no predicted demand see a demand of 0 and so only
+// keep their minimum.
+var builtinRules = policy.MustCompile([]policy.Rule{
+	{
+		// Demand beyond capacity may burst past the maximum
+		If:     "demand > available",
+		Then:   "burst nodes(demand - available)",
+		Reason: demandReasonPlaceholder,
+	},
+	{
+		If:     "ready < min_ready and ready + booting < min_ready",
+		Then:   "scale_up min_ready - ready - booting",
+		Reason: "maintaining minimum ready nodes",
+	},
+	{
+		If:     "ready > min_ready and demand == 0",
+		Then:   "scale_down ready - min_ready",
+		Reason: "excess capacity with no demand",
+	},
+})
+
+// evaluatePolicy runs the configured scaling rules against env, then the
+// built-in ones if none decided
+func evaluatePolicy(cfg PredictionConfig, instanceType string, env policy.Env) (policy.Outcome, bool) {
+	if outcome, ok := cfg.ScalingPolicy.Evaluate(instanceType, env); ok {
+		return outcome, true
+	}
+	return builtinRules.Evaluate(instanceType, env)
+}
+
+// scalingEnv describes an instance type's pool to the scaling rules, with
+// the reason to give for scaling up to its demand
+func (p *Predictor) scalingEnv(cfg PredictionConfig, instanceType string) (policy.Env, string) {
+	limits := cfg.PolicyFor(instanceType)
+	filter := cfg.filter(instanceType)
+	bootingCount := p.nodePool.CountByStatusWhere(node.NodeStatusBooting, filter)
+
+	demand, reason := 0, ""
+	if cfg.receivesDemand(instanceType) {
+		demand, reason = p.demand(cfg, instanceType, filter)
+	}
+
+	now := time.Now().In(cmp.Or(cfg.PolicyLocation, time.UTC))
+	return policy.Env{
+		policy.VarReady:     float64(p.nodePool.CountSchedulableWhere(filter)),
+		policy.VarBooting:   float64(bootingCount),
+		policy.VarAllocated: float64(p.nodePool.CountOccupiedWhere(filter)),
+		policy.VarUsers:     float64(p.nodePool.CountUsersWhere(filter)),
+		policy.VarDemand:    float64(demand),
+		// Free slots on schedulable nodes, including shared nodes with room,
+		// plus every slot of booting nodes
+		policy.VarAvailable:    float64(p.nodePool.FreeSlotsWhere(filter) + bootingCount*limits.slots()),
+		policy.VarSlotsPerNode: float64(limits.slots()),
+		policy.VarMinReady:     float64(limits.MinReadyNodes),
+		policy.VarMaxReady:     float64(limits.MaxReadyNodes),
+		policy.VarBurstMax:     float64(limits.BurstMaxNodes),
+		policy.VarHour:         float64(now.Hour()),
+		policy.VarWeekday:      float64(now.Weekday()),
+	}, reason
+}
+
+// limitsOf returns a pool's policy with the ready node limits the scaling
+// rules left in env
+func limitsOf(p InstanceTypePolicy, env policy.Env) InstanceTypePolicy {
+	p.MinReadyNodes = int(env[policy.VarMinReady])
+	p.MaxReadyNodes = int(env[policy.VarMaxReady])
+	p.BurstMaxNodes = int(env[policy.VarBurstMax])
+	return p
+}
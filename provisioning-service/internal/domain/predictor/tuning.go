@@ -0,0 +1,184 @@
+package predictor
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+)
+
+// Tuning holds the prediction parameters that can be changed while the
+// service runs. With instance types configured, the pool limits and idle
+// timeout are those of the default type's pool.
+type Tuning struct {
+	// InstanceType is the pool the limits and idle timeout are of; empty
+	// without instance types. Changing it has no effect.
+	InstanceType string
+
+	// ActivityHorizon and UserRetention bound ActivityWindow and are fixed
+	// at startup; changing them has no effect
+	ActivityHorizon time.Duration
+	UserRetention   time.Duration
+
+	ActivityWindow         time.Duration
+	ActivityThreshold      int
+	PredictionWindow       time.Duration
+	TargetHeadroom         float64
+	MinReadyNodes          int
+	MaxReadyNodes          int
+	BurstMaxNodes          int
+	IdleTerminationTimeout time.Duration
+	SurplusMargin          float64
+}
+
+// Validate checks that the tuning is usable, reporting every problem
+func (t Tuning) Validate() error {
+	if problems := t.Problems(); len(problems) > 0 {
+		return errcode.New(errcode.InvalidRequest, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Problems returns every rule the tuning breaks, each prefixed with the
+// name of its setting. The configuration is checked with it at startup, so
+// runtime changes are held to the same rules.
+func (t Tuning) Problems() []string {
+	var problems []string
+	add := func(key, format string, args ...any) {
+		problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	if t.ActivityWindow <= 0 {
+		add("activity_window", "must be positive, got %s", t.ActivityWindow)
+	}
+	if t.ActivityThreshold < 1 {
+		add("activity_threshold", "must be at least 1, got %d", t.ActivityThreshold)
+	}
+	if t.ActivityHorizon < t.ActivityWindow {
+		add("activity_window", "%s exceeds activity_horizon (%s), so activity older than the horizon would go uncounted",
+			t.ActivityWindow, t.ActivityHorizon)
+	}
+	if t.UserRetention < t.ActivityWindow {
+		add("activity_window", "%s exceeds user_retention (%s), so users would be forgotten before they count as likely to connect",
+			t.ActivityWindow, t.UserRetention)
+	}
+	if t.PredictionWindow <= 0 {
+		add("prediction_window", "must be positive, got %s", t.PredictionWindow)
+	}
+	if t.TargetHeadroom <= 0 {
+		add("target_headroom", "must be positive, got %g", t.TargetHeadroom)
+	}
+
+	if t.MinReadyNodes < 0 {
+		add("min_ready_nodes", "must be at least 0, got %d", t.MinReadyNodes)
+	}
+	if t.MaxReadyNodes < 1 {
+		add("max_ready_nodes", "must be at least 1, got %d", t.MaxReadyNodes)
+	}
+	if t.MinReadyNodes > t.MaxReadyNodes {
+		add("min_ready_nodes", "%d exceeds max_ready_nodes (%d)", t.MinReadyNodes, t.MaxReadyNodes)
+	}
+	if t.BurstMaxNodes < 0 {
+		add("burst_max_nodes", "must be at least 0, got %d", t.BurstMaxNodes)
+	}
+	if t.BurstMaxNodes > 0 && t.BurstMaxNodes <= t.MaxReadyNodes {
+		add("burst_max_nodes", "%d must exceed max_ready_nodes (%d)", t.BurstMaxNodes, t.MaxReadyNodes)
+	}
+
+	// An instance type's pool takes the global timeout for 0
+	switch {
+	case t.InstanceType == "" && t.IdleTerminationTimeout <= 0:
+		add("idle_termination_timeout", "must be positive, got %s", t.IdleTerminationTimeout)
+	case t.IdleTerminationTimeout < 0:
+		add("idle_termination_timeout", "must not be negative, got %s", t.IdleTerminationTimeout)
+	}
+	if t.SurplusMargin < 0 {
+		add("surplus_margin", "must not be negative, got %g", t.SurplusMargin)
+	}
+	return problems
+}
+
+// Tuning returns the prediction parameters in effect
+func (p *Predictor) Tuning() Tuning {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return tuningOf(p.config)
+}
+
+// Overrides returns the prediction parameters in effect if they were changed
+// since startup, or restored from a replica that changed them
+func (p *Predictor) Overrides() (Tuning, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return tuningOf(p.config), p.tuned
+}
+
+// UpdateTuning changes the prediction parameters in effect, applying them
+// from the next scaling decision if the result is valid
+func (p *Predictor) UpdateTuning(change func(t *Tuning)) (Tuning, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tuning := tuningOf(p.config)
+	fixed := tuning
+	change(&tuning)
+	tuning.InstanceType, tuning.ActivityHorizon, tuning.UserRetention = fixed.InstanceType, fixed.ActivityHorizon, fixed.UserRetention
+	if err := tuning.Validate(); err != nil {
+		return Tuning{}, err
+	}
+	p.tuned = true
+	// The floors the policy left were drawn from the old limits
+	p.floorsMu.Lock()
+	clear(p.floors)
+	p.floorsMu.Unlock()
+
+	p.config.ActivityWindow = tuning.ActivityWindow
+	p.config.ActivityThreshold = tuning.ActivityThreshold
+	p.config.PredictionWindow = tuning.PredictionWindow
+	p.config.TargetHeadroom = tuning.TargetHeadroom
+	p.config.SurplusMargin = tuning.SurplusMargin
+
+	if policy, ok := p.config.InstanceTypes[p.config.DefaultInstanceType]; ok {
+		policy.MinReadyNodes = tuning.MinReadyNodes
+		policy.MaxReadyNodes = tuning.MaxReadyNodes
+		policy.BurstMaxNodes = tuning.BurstMaxNodes
+		policy.IdleTerminationTimeout = cmp.Or(tuning.IdleTerminationTimeout, p.config.IdleTerminationTimeout)
+
+		// Copy so earlier Config() snapshots are not mutated
+		types := maps.Clone(p.config.InstanceTypes)
+		types[p.config.DefaultInstanceType] = policy
+		p.config.InstanceTypes = types
+		return tuningOf(p.config), nil
+	}
+	p.config.MinReadyNodes = tuning.MinReadyNodes
+	p.config.MaxReadyNodes = tuning.MaxReadyNodes
+	p.config.BurstMaxNodes = tuning.BurstMaxNodes
+	p.config.IdleTerminationTimeout = tuning.IdleTerminationTimeout
+	return tuning, nil
+}
+
+// tuningOf returns the tunable parameters of a configuration
+func tuningOf(cfg PredictionConfig) Tuning {
+	policy := cfg.PolicyFor(cfg.DefaultInstanceType)
+	instanceType := ""
+	if _, ok := cfg.InstanceTypes[cfg.DefaultInstanceType]; ok {
+		instanceType = cfg.DefaultInstanceType
+	}
+	return Tuning{
+		InstanceType:           instanceType,
+		ActivityHorizon:        cfg.ActivityHorizon,
+		UserRetention:          cfg.UserRetention,
+		ActivityWindow:         cfg.ActivityWindow,
+		ActivityThreshold:      cfg.ActivityThreshold,
+		PredictionWindow:       cfg.PredictionWindow,
+		TargetHeadroom:         cfg.TargetHeadroom,
+		MinReadyNodes:          policy.MinReadyNodes,
+		MaxReadyNodes:          policy.MaxReadyNodes,
+		BurstMaxNodes:          policy.BurstMaxNodes,
+		IdleTerminationTimeout: policy.IdleTerminationTimeout,
+		SurplusMargin:          cfg.SurplusMargin,
+	}
+}
@@ -0,0 +1,285 @@
+// Package purchasing decides how each node is bought: from reserved
+// capacity, as spot or on demand. The spot share of the pool is kept near a
+// target, while tiers that cannot afford an interruption and instance types
+// whose spot nodes are being interrupted often are bought on demand.
+package purchasing
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+// Purchase options, in the order they are preferred
+const (
+	OptionReserved = "reserved"
+	OptionSpot     = "spot"
+	OptionOnDemand = "on_demand"
+)
+
+// Options lists every purchase option
+var Options = []string{OptionReserved, OptionSpot, OptionOnDemand}
+
+// Reasons an option was chosen
+const (
+	ReasonReserved      = "reserved"      // Reserved capacity of the instance type was unused
+	ReasonTier          = "tier"          // The user's tier is kept off spot
+	ReasonInterruptions = "interruptions" // Spot nodes of the instance type are interrupted too often
+	ReasonTarget        = "target"        // Keeps the spot share near the target
+)
+
+// Config sets the mix of purchase options
+type Config struct {
+	Enabled bool
+
+	// TargetSpotRatio is the share of nodes not on reserved capacity bought
+	// as spot, from 0 to 1
+	TargetSpotRatio float64
+
+	// Reserved is the reserved capacity held, as nodes by instance type
+	Reserved map[string]int
+
+	// MaxInterruptionRate is the interrupted spot nodes per spot node
+	// launched over InterruptionWindow above which an instance type is
+	// bought on demand; zero never backs off spot
+	MaxInterruptionRate float64
+	InterruptionWindow  time.Duration
+
+	// OnDemandTiers are never placed on spot nodes
+	OnDemandTiers []string
+}
+
+// Validate checks that the ratio, capacity and rates are usable
+func (c Config) Validate() error {
+	if c.TargetSpotRatio < 0 || c.TargetSpotRatio > 1 {
+		return fmt.Errorf("invalid target spot ratio: %g", c.TargetSpotRatio)
+	}
+	for instanceType, count := range c.Reserved {
+		if count < 0 {
+			return fmt.Errorf("invalid reserved capacity for instance type %q: %d", instanceType, count)
+		}
+	}
+	if c.MaxInterruptionRate < 0 {
+		return fmt.Errorf("invalid max interruption rate: %g", c.MaxInterruptionRate)
+	}
+	if c.MaxInterruptionRate > 0 && c.InterruptionWindow <= 0 {
+		return fmt.Errorf("max interruption rate requires an interruption window")
+	}
+	return nil
+}
+
+// Users reports the connected users in any of some tiers and in all
+type Users interface {
+	ConnectedInTiers(tiers []string) (int, int)
+}
+
+// Observer is notified of nodes bought with each option
+type Observer interface {
+	ObservePurchases(option, reason string, count int)
+}
+
+// Choice is an option chosen for some of the nodes asked for
+type Choice struct {
+	Option string
+	Reason string
+	Count  int
+}
+
+// Snapshot describes the achieved mix of purchase options
+type Snapshot struct {
+	Enabled         bool
+	TargetSpotRatio float64
+	SpotRatio       float64            // Spot share of nodes not on reserved capacity; 0 without any
+	Nodes           map[string]int     // Nodes not terminated, by option; nodes bought before purchasing was enabled are left out
+	Reserved        map[string]int     // Reserved capacity held, by instance type
+	Interruptions   map[string]float64 // Interruption rate of spot nodes over the window, by instance type
+}
+
+// Strategy chooses the purchase option of each node provisioned
+type Strategy struct {
+	config   Config
+	nodePool *node.NodePool
+	users    Users
+	observer Observer
+
+	mu            sync.Mutex
+	launches      map[string][]time.Time // Spot nodes launched within the window, by instance type
+	interruptions map[string][]time.Time // Spot nodes interrupted within the window, by instance type
+}
+
+// NewStrategy creates a strategy choosing options for nodes added to
+// nodePool, for the users tracked by users
+func NewStrategy(config Config, nodePool *node.NodePool, users Users, observer Observer) *Strategy {
+	return &Strategy{
+		config:        config,
+		nodePool:      nodePool,
+		users:         users,
+		observer:      observer,
+		launches:      make(map[string][]time.Time),
+		interruptions: make(map[string][]time.Time),
+	}
+}
+
+// Enabled reports whether nodes are bought with a chosen option; when not,
+// the provider chooses
+func (s *Strategy) Enabled() bool {
+	return s.config.Enabled
+}
+
+// Plan splits count nodes of an instance type, provisioned for a user of
+// the given tier or for the pool if tier is empty, between the options:
+// unused reserved capacity first, then on demand for tiers kept off spot and
+// instance types interrupted too often, and otherwise spot while the spot
+// share is below the target. Of nodes for the pool, the share of connected
+// users in tiers kept off spot is kept off spot too, so those users find
+// nodes they may be given.
+func (s *Strategy) Plan(instanceType, tier string, count int) []Choice {
+	if !s.config.Enabled || count <= 0 {
+		return nil
+	}
+
+	nodes := s.nodePool.CountByPurchase()
+	reservedInUse := nodes[instanceType][OptionReserved]
+	spot, onDemand := 0, 0
+	for _, byOption := range nodes {
+		spot += byOption[OptionSpot]
+		onDemand += byOption[OptionOnDemand]
+	}
+	interrupted := s.interruptionRate(instanceType, time.Now()) > s.config.MaxInterruptionRate && s.config.MaxInterruptionRate > 0
+	offSpot := tier != "" && slices.Contains(s.config.OnDemandTiers, tier)
+	offSpotPool := 0
+	if tier == "" && len(s.config.OnDemandTiers) > 0 {
+		if in, total := s.users.ConnectedInTiers(s.config.OnDemandTiers); in > 0 {
+			offSpotPool = (count*in + total - 1) / total
+		}
+	}
+
+	var plan []Choice
+	for range count {
+		var option, reason string
+		switch {
+		case reservedInUse < s.config.Reserved[instanceType]:
+			option, reason = OptionReserved, ReasonReserved
+			reservedInUse++
+			offSpotPool--
+		case offSpot || offSpotPool > 0:
+			option, reason = OptionOnDemand, ReasonTier
+			onDemand++
+			offSpotPool--
+		case interrupted:
+			option, reason = OptionOnDemand, ReasonInterruptions
+			onDemand++
+		case float64(spot) < s.config.TargetSpotRatio*float64(spot+onDemand+1):
+			option, reason = OptionSpot, ReasonTarget
+			spot++
+		default:
+			option, reason = OptionOnDemand, ReasonTarget
+			onDemand++
+		}
+
+		if i := slices.IndexFunc(plan, func(c Choice) bool { return c.Option == option && c.Reason == reason }); i >= 0 {
+			plan[i].Count++
+		} else {
+			plan = append(plan, Choice{Option: option, Reason: reason, Count: 1})
+		}
+	}
+	return plan
+}
+
+// Launched records nodes bought with a chosen option
+func (s *Strategy) Launched(instanceType string, choice Choice, count int) {
+	if count <= 0 || choice.Option == "" {
+		return
+	}
+	s.observer.ObservePurchases(choice.Option, choice.Reason, count)
+	if choice.Option != OptionSpot || s.config.MaxInterruptionRate == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for range count {
+		s.launches[instanceType] = append(s.launches[instanceType], now)
+	}
+}
+
+// Interrupted records a node the provider took back, counting it against
+// its instance type if it was bought as spot
+func (s *Strategy) Interrupted(n *node.Node) {
+	if n.Purchase != OptionSpot || s.config.MaxInterruptionRate == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interruptions[n.InstanceType] = append(s.interruptions[n.InstanceType], time.Now())
+}
+
+// Snapshot returns the achieved mix
+func (s *Strategy) Snapshot() Snapshot {
+	snapshot := Snapshot{
+		Enabled:         s.config.Enabled,
+		TargetSpotRatio: s.config.TargetSpotRatio,
+		Nodes:           make(map[string]int, len(Options)),
+		Reserved:        maps.Clone(s.config.Reserved),
+		Interruptions:   make(map[string]float64),
+	}
+	for _, byOption := range s.nodePool.CountByPurchase() {
+		for option, count := range byOption {
+			snapshot.Nodes[option] += count
+		}
+	}
+	if bought := snapshot.Nodes[OptionSpot] + snapshot.Nodes[OptionOnDemand]; bought > 0 {
+		snapshot.SpotRatio = float64(snapshot.Nodes[OptionSpot]) / float64(bought)
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	instanceTypes := make([]string, 0, len(s.interruptions))
+	for instanceType := range s.interruptions {
+		instanceTypes = append(instanceTypes, instanceType)
+	}
+	s.mu.Unlock()
+	for _, instanceType := range instanceTypes {
+		if rate := s.interruptionRate(instanceType, now); rate > 0 {
+			snapshot.Interruptions[instanceType] = rate
+		}
+	}
+	return snapshot
+}
+
+// interruptionRate returns the spot nodes of an instance type interrupted
+// per spot node launched within the window, forgetting older ones.
+// Interruptions of nodes launched before the window, or before a restart,
+// count against at least one launch.
+func (s *Strategy) interruptionRate(instanceType string, now time.Time) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := now.Add(-s.config.InterruptionWindow)
+	interruptions := prune(s.interruptions, instanceType, cutoff)
+	launches := prune(s.launches, instanceType, cutoff)
+	if interruptions == 0 {
+		return 0
+	}
+	return float64(interruptions) / float64(max(launches, 1))
+}
+
+// prune drops the times of an instance type before cutoff, returning how
+// many are left; the caller holds the lock
+func prune(times map[string][]time.Time, instanceType string, cutoff time.Time) int {
+	kept := slices.DeleteFunc(times[instanceType], func(t time.Time) bool {
+		return t.Before(cutoff)
+	})
+	if len(kept) == 0 {
+		delete(times, instanceType)
+		return 0
+	}
+	times[instanceType] = kept
+	return len(kept)
+}
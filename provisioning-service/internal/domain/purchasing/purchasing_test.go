@@ -0,0 +1,59 @@
+package purchasing
+
+import (
+	"testing"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+type nopObserver struct{}
+
+func (nopObserver) ObservePurchases(string, string, int) {}
+
+// tierUsers reports fixed counts of connected users
+type tierUsers struct{ in, total int }
+
+func (u tierUsers) ConnectedInTiers([]string) (int, int) { return u.in, u.total }
+
+func TestPlanKeepsPoolShareOffSpot(t *testing.T) {
+	pool := node.NewNodePool(node.AgentCompatibility{})
+	config := Config{Enabled: true, TargetSpotRatio: 1, OnDemandTiers: []string{"premium"}}
+	s := NewStrategy(config, pool, tierUsers{in: 1, total: 4}, nopObserver{})
+
+	plan := s.Plan("a100", "", 4)
+	want := []Choice{
+		{Option: OptionOnDemand, Reason: ReasonTier, Count: 1},
+		{Option: OptionSpot, Reason: ReasonTarget, Count: 3},
+	}
+	if len(plan) != len(want) {
+		t.Fatalf("Plan = %+v, want %+v", plan, want)
+	}
+	for i := range want {
+		if plan[i] != want[i] {
+			t.Fatalf("Plan = %+v, want %+v", plan, want)
+		}
+	}
+
+	if plan := s.Plan("a100", "premium", 2); len(plan) != 1 || plan[0].Option != OptionOnDemand || plan[0].Count != 2 {
+		t.Fatalf("Plan for premium = %+v, want 2 on demand", plan)
+	}
+}
+
+func TestSnapshotCountsNodesNotTerminated(t *testing.T) {
+	pool := node.NewNodePool(node.AgentCompatibility{})
+	pool.Add(&node.Node{ID: "n1", Status: node.NodeStatusReady, InstanceType: "a100", Purchase: OptionSpot})
+	pool.Add(&node.Node{ID: "n2", Status: node.NodeStatusBooting, InstanceType: "a100", Purchase: OptionOnDemand})
+	pool.Add(&node.Node{ID: "n3", Status: node.NodeStatusTerminated, InstanceType: "a100", Purchase: OptionSpot})
+	pool.Add(&node.Node{ID: "n4", Status: node.NodeStatusReady, InstanceType: "a100"})
+	s := NewStrategy(Config{Enabled: true}, pool, tierUsers{}, nopObserver{})
+
+	snapshot := s.Snapshot()
+	if snapshot.Nodes[OptionSpot] != 1 || snapshot.Nodes[OptionOnDemand] != 1 || snapshot.SpotRatio != 0.5 {
+		t.Fatalf("Snapshot = %+v, want one spot and one on-demand node", snapshot)
+	}
+
+	pool.UpdateStatus("n1", node.NodeStatusTerminated)
+	if nodes := s.Snapshot().Nodes; nodes[OptionSpot] != 0 {
+		t.Fatalf("spot nodes = %d after termination, want 0", nodes[OptionSpot])
+	}
+}
@@ -0,0 +1,163 @@
+// Package ratelimit limits how often each user's connects and disconnects
+// are acted on, so a client flapping its connection cannot thrash
+// allocations and drive pointless provisioning.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Events counted against a user's limit
+const (
+	EventConnect    = "connect"
+	EventDisconnect = "disconnect"
+)
+
+// Limit is a token bucket per user and event: each event takes a token from
+// the bucket of its kind, a full bucket holds Burst of them, and they are
+// refilled at PerMinute. Disconnects thus never use up the tokens a user's
+// connects need.
+type Limit struct {
+	PerMinute float64 // Zero disables limiting
+	Burst     int
+}
+
+// Enabled reports whether events are limited at all
+func (l Limit) Enabled() bool {
+	return l.PerMinute > 0
+}
+
+// Store keeps the token buckets
+type Store interface {
+	// Take takes a token from the bucket at key, reporting false and when
+	// the next token is due if there is none
+	Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// Observer is notified of events over a user's limit
+type Observer interface {
+	ObserveThrottled(event string)
+}
+
+// Decision is whether an event is within a user's limit
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration // Until the next token is due; zero if allowed
+	Tripped    bool          // First event over the limit since the user was last within it
+}
+
+// Limiter counts users' events against their limits
+type Limiter struct {
+	store    Store
+	limit    Limit
+	observer Observer
+
+	mu      sync.Mutex
+	tripped map[string]time.Time // Buckets over their limit, with when they were tripped
+}
+
+// NewLimiter creates a limiter keeping its buckets in store
+func NewLimiter(store Store, limit Limit, observer Observer) *Limiter {
+	return &Limiter{
+		store:    store,
+		limit:    limit,
+		observer: observer,
+		tripped:  make(map[string]time.Time),
+	}
+}
+
+// Limit returns the limit each user is held to
+func (l *Limiter) Limit() Limit {
+	return l.limit
+}
+
+// Take counts a user's event against their limit for its kind. If the store
+// fails the event is allowed, and the error returned with the decision.
+func (l *Limiter) Take(ctx context.Context, userID, event string) (Decision, error) {
+	if !l.limit.Enabled() {
+		return Decision{Allowed: true}, nil
+	}
+
+	key := userID + ":" + event
+	allowed, retryAfter, err := l.store.Take(ctx, key, l.limit)
+	if err != nil {
+		return Decision{Allowed: true}, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if allowed {
+		delete(l.tripped, key)
+		return Decision{Allowed: true}, nil
+	}
+
+	l.observer.ObserveThrottled(event)
+	_, already := l.tripped[key]
+	if !already {
+		l.forgetStale()
+		l.tripped[key] = time.Now()
+	}
+	return Decision{RetryAfter: retryAfter, Tripped: !already}, nil
+}
+
+// forgetStale forgets buckets tripped long enough ago to have refilled, so
+// users who never come back are not kept; the caller holds the lock
+func (l *Limiter) forgetStale() {
+	refill := time.Duration(float64(l.limit.Burst) / l.limit.PerMinute * float64(time.Minute))
+	cutoff := time.Now().Add(-refill)
+	for key, at := range l.tripped {
+		if at.Before(cutoff) {
+			delete(l.tripped, key)
+		}
+	}
+}
+
+// LocalStore keeps token buckets in memory, for a single replica
+type LocalStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time // When tokens was last refilled
+}
+
+// NewLocalStore creates an empty in-memory store
+func NewLocalStore() *LocalStore {
+	return &LocalStore{buckets: make(map[string]*bucket)}
+}
+
+// Take takes a token from the bucket at key
+func (s *LocalStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	perSecond := limit.PerMinute / 60
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), at: now}
+		s.buckets[key] = b
+	}
+	b.tokens = min(float64(limit.Burst), b.tokens+now.Sub(b.at).Seconds()*perSecond)
+	b.at = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second)), nil
+	}
+	b.tokens--
+
+	// A full bucket is the same as none. One other bucket is looked at per
+	// take, so refilled buckets are dropped over time without a sweep.
+	for k, other := range s.buckets {
+		if other.tokens+now.Sub(other.at).Seconds()*perSecond >= float64(limit.Burst) && k != key {
+			delete(s.buckets, k)
+		}
+		break
+	}
+	return true, 0, nil
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+)
+
+type countingObserver map[string]int
+
+func (o countingObserver) ObserveThrottled(event string) {
+	o[event]++
+}
+
+func TestDisconnectsKeepConnectTokens(t *testing.T) {
+	ctx := context.Background()
+	observed := countingObserver{}
+	limiter := NewLimiter(NewLocalStore(), Limit{PerMinute: 1, Burst: 2}, observed)
+
+	for i := range 3 {
+		decision, err := limiter.Take(ctx, "u1", EventDisconnect)
+		if err != nil {
+			t.Fatalf("Take: %v", err)
+		}
+		if decision.Allowed != (i < 2) {
+			t.Errorf("disconnect %d allowed = %v", i, decision.Allowed)
+		}
+	}
+	for i := range 2 {
+		if decision, _ := limiter.Take(ctx, "u1", EventConnect); !decision.Allowed {
+			t.Errorf("connect %d refused after disconnects emptied their own bucket", i)
+		}
+	}
+
+	decision, _ := limiter.Take(ctx, "u1", EventConnect)
+	if decision.Allowed || !decision.Tripped || decision.RetryAfter <= 0 {
+		t.Errorf("third connect = %+v, want refused, tripped, with a retry", decision)
+	}
+	if decision, _ := limiter.Take(ctx, "u1", EventConnect); decision.Tripped {
+		t.Error("a bucket already over its limit tripped again")
+	}
+	if decision, _ := limiter.Take(ctx, "u2", EventConnect); !decision.Allowed {
+		t.Error("another user's connect refused")
+	}
+	if observed[EventConnect] != 2 || observed[EventDisconnect] != 1 {
+		t.Errorf("observed %v, want 2 connects and 1 disconnect", observed)
+	}
+}
+
+func TestDisabledLimitAllows(t *testing.T) {
+	limiter := NewLimiter(nil, Limit{Burst: 1}, countingObserver{})
+	for range 3 {
+		if decision, err := limiter.Take(context.Background(), "u1", EventConnect); !decision.Allowed || err != nil {
+			t.Fatalf("Take = %+v, %v; want allowed", decision, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package rbac
+
+import "github.com/aos-cc/provisioning-service/internal/domain/errcode"
+
+var (
+	// ErrForbidden is returned when the caller's role does not allow an action
+	ErrForbidden = errcode.New(errcode.AccessDenied, "role does not allow this action")
+
+	// ErrOutsideTenant is returned when a tenant's caller acts on another
+	// tenant's users or nodes, or on the whole pool
+	ErrOutsideTenant = errcode.New(errcode.AccessDenied, "outside the caller's tenant")
+)
+
+// Role grants a set of admin API actions; each role includes the ones below it
+type Role int
+
+const (
+	RoleNone     Role = iota
+	RoleViewer        // Reads pool state and the operations feed
+	RoleOperator      // Manages nodes and users
+	RoleAdmin         // Changes pool limits, access lists and log level
+)
+
+var roleNames = map[string]Role{
+	"viewer":   RoleViewer,
+	"operator": RoleOperator,
+	"admin":    RoleAdmin,
+}
+
+// ParseRole returns the role with the given name
+func ParseRole(name string) (Role, bool) {
+	role, ok := roleNames[name]
+	return role, ok
+}
+
+// String returns the role's name, or "none"
+func (r Role) String() string {
+	for name, role := range roleNames {
+		if role == r {
+			return name
+		}
+	}
+	return "none"
+}
+
+// Principal is an authenticated caller of the admin API
+type Principal struct {
+	Subject string
+	Role    Role
+
+	// TenantID limits a tenant's callers to their own users and nodes;
+	// empty for operators of the whole pool
+	TenantID string
+}
+
+// Scoped reports whether the principal is limited to one tenant
+func (p Principal) Scoped() bool {
+	return p.TenantID != ""
+}
+
+// Allows reports whether the principal's role includes the given one
+func (p Principal) Allows(role Role) bool {
+	return p.Role >= role
+}
+
+// CanAccessTenant reports whether the principal may see and manage the
+// users and nodes of a tenant
+func (p Principal) CanAccessTenant(tenantID string) bool {
+	return !p.Scoped() || p.TenantID == tenantID
+}
@@ -0,0 +1,55 @@
+// Package replication lets several replicas share one pool: a single
+// leader scales and allocates, and replicates its state so a standby can
+// take over when it fails.
+package replication
+
+import (
+	"context"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+)
+
+// Cluster elects the leading replica and holds the state it replicates.
+// Save fails once this replica no longer leads, so a deposed leader cannot
+// overwrite its successor's state.
+type Cluster interface {
+	// Save replaces the replicated state
+	Save(ctx context.Context, snapshot handoff.Snapshot) error
+
+	// Load returns the replicated state, or false if there is none
+	Load(ctx context.Context) (handoff.Snapshot, bool, error)
+
+	// IsLeader reports whether this replica currently leads
+	IsLeader() bool
+
+	// ID identifies this replica among the others
+	ID() string
+
+	// Fence confirms that this replica still leads, failing once it has
+	// been deposed even if it has not noticed yet
+	Fence(ctx context.Context) error
+}
+
+// Standalone is a cluster of one replica, which always leads and
+// replicates nothing
+type Standalone struct{}
+
+func (Standalone) Save(ctx context.Context, snapshot handoff.Snapshot) error {
+	return nil
+}
+
+func (Standalone) Load(ctx context.Context) (handoff.Snapshot, bool, error) {
+	return handoff.Snapshot{}, false, nil
+}
+
+func (Standalone) IsLeader() bool {
+	return true
+}
+
+func (Standalone) ID() string {
+	return ""
+}
+
+func (Standalone) Fence(ctx context.Context) error {
+	return nil
+}
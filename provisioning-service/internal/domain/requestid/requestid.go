@@ -0,0 +1,48 @@
+// Package requestid carries the ID of the request that caused an operation,
+// so its logs and the downstream calls it makes can be correlated across
+// services.
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// Header carries the request ID on inbound and outbound HTTP requests
+const Header = "X-Request-ID"
+
+// maxLen bounds IDs accepted from callers, which end up in every log line
+const maxLen = 128
+
+type contextKey struct{}
+
+// New returns a fresh request ID
+func New() string {
+	return uuid.NewString()
+}
+
+// Valid reports whether an ID received from a caller is safe to log and
+// forward: non-empty, bounded and printable ASCII without spaces
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// With returns a context carrying the request ID
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// From returns the request ID ctx carries, or "" if none
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
@@ -0,0 +1,296 @@
+// Package rightsizing recommends the instance type of each user's next
+// allocation from how hard they drove the nodes they were on and what they
+// were doing on them.
+package rightsizing
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxSamples bounds the utilization samples kept per user; the oldest are
+// dropped beyond it
+const maxSamples = 1000
+
+// Directions of a recommendation relative to the user's instance type
+const (
+	DirectionKeep    = "keep"
+	DirectionSmaller = "smaller"
+	DirectionLarger  = "larger"
+)
+
+// Config holds the rightsizing thresholds
+type Config struct {
+	// Sizes lists the instance types from smallest to largest. Users are
+	// only moved between listed types.
+	Sizes []string
+
+	// Window is how far back utilization samples and activities count
+	Window time.Duration
+
+	// MinSamples is how many samples a user needs before a change of type
+	// is recommended from utilization
+	MinSamples int
+
+	// DownsizeBelow is the average GPU utilization percent below which the
+	// next smaller type is recommended
+	DownsizeBelow float64
+
+	// UpsizeAbove is the average GPU utilization percent at or above which
+	// the next larger type is recommended
+	UpsizeAbove float64
+
+	// MemoryUpsizeAbove is the peak GPU memory percent at or above which the
+	// next larger type is recommended and a smaller one never is; 0 ignores
+	// memory
+	MemoryUpsizeAbove float64
+
+	// ActivitySizes maps activity types to the smallest type they need, so
+	// a user who recently did one is never recommended less
+	ActivitySizes map[string]string
+}
+
+// Recommendation is the instance type suggested for a user's next allocation
+type Recommendation struct {
+	InstanceType string // Of the node the user was last sampled on; "" if none
+	Recommended  string // For the next allocation; "" when there is nothing to go on
+	Direction    string // Relative to InstanceType; "" when that is not a listed size
+	Reason       string
+
+	Samples    int // Utilization samples within the window
+	AverageGPU float64
+	PeakGPU    float64
+	PeakMemory float64
+}
+
+type sample struct {
+	at          time.Time
+	gpu, memory float64
+}
+
+// workload holds the hints recorded for one user
+type workload struct {
+	instanceType string
+	samples      []sample
+	activities   map[string]time.Time // Last time of each activity type with a size
+	reported     Recommendation       // Last returned by Update
+}
+
+// Engine records per-user workload hints and turns them into
+// recommendations. It holds them in memory only.
+type Engine struct {
+	config Config
+
+	mu    sync.Mutex
+	users map[string]*workload
+}
+
+// New creates an engine with no hints recorded
+func New(config Config) *Engine {
+	return &Engine{
+		config: config,
+		users:  make(map[string]*workload),
+	}
+}
+
+// Enabled reports whether any sizes are configured
+func (e *Engine) Enabled() bool {
+	return len(e.config.Sizes) > 0
+}
+
+// RecordUtilization records a utilization sample of a node the user is on.
+// Samples from an earlier instance type are forgotten when the user moves
+// to another, as they say little about how the new one is used.
+func (e *Engine) RecordUtilization(userID, instanceType string, gpuPercent, memoryPercent float64, at time.Time) {
+	if !e.Enabled() || instanceType == "" {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w := e.get(userID)
+	if w.instanceType != instanceType {
+		w.instanceType = instanceType
+		w.samples = nil
+		w.reported = Recommendation{}
+	}
+	w.samples = append(w.samples, sample{at: at, gpu: gpuPercent, memory: memoryPercent})
+	if over := len(w.samples) - maxSamples; over > 0 {
+		w.samples = w.samples[over:]
+	}
+}
+
+// RecordActivity records a user activity; only types with a configured size
+// are kept
+func (e *Engine) RecordActivity(userID, activityType string, at time.Time) {
+	if _, ok := e.config.ActivitySizes[activityType]; !ok || !e.Enabled() {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w := e.get(userID)
+	if at.After(w.activities[activityType]) {
+		w.activities[activityType] = at
+	}
+}
+
+func (e *Engine) get(userID string) *workload {
+	w, ok := e.users[userID]
+	if !ok {
+		w = &workload{activities: make(map[string]time.Time)}
+		e.users[userID] = w
+	}
+	return w
+}
+
+// Recommend returns the recommendation for a user, and false if no hints
+// are recorded for them
+func (e *Engine) Recommend(userID string) (Recommendation, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w, ok := e.users[userID]
+	if !ok {
+		return Recommendation{}, false
+	}
+	return e.recommend(w, time.Now()), true
+}
+
+// Update returns a user's recommendation if it names a type and differs
+// from the one Update last returned for them, so each change is announced
+// once. Keeping the type the user is on is only announced when it reverses
+// an earlier change.
+func (e *Engine) Update(userID string) (Recommendation, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	w, ok := e.users[userID]
+	if !ok {
+		return Recommendation{}, false
+	}
+	r := e.recommend(w, time.Now())
+	reported := w.reported
+	if reported.Recommended == "" {
+		reported = Recommendation{Recommended: w.instanceType, Direction: DirectionKeep}
+	}
+	if r.Recommended == "" || (r.Recommended == reported.Recommended && r.Direction == reported.Direction) {
+		return Recommendation{}, false
+	}
+	w.reported = r
+	return r, true
+}
+
+// recommend applies the thresholds to a user's hints within the window;
+// the caller holds the lock
+func (e *Engine) recommend(w *workload, now time.Time) Recommendation {
+	cutoff := now.Add(-e.config.Window)
+	r := Recommendation{InstanceType: w.instanceType}
+
+	var total float64
+	for _, s := range w.samples {
+		if s.at.Before(cutoff) {
+			continue
+		}
+		r.Samples++
+		total += s.gpu
+		r.PeakGPU = max(r.PeakGPU, s.gpu)
+		r.PeakMemory = max(r.PeakMemory, s.memory)
+	}
+	if r.Samples > 0 {
+		r.AverageGPU = total / float64(r.Samples)
+	}
+
+	sizes := e.config.Sizes
+	current := slices.Index(sizes, w.instanceType)
+	target := current
+	switch {
+	case current < 0 && w.instanceType != "":
+		r.Reason = fmt.Sprintf("instance type %s is not a listed size", w.instanceType)
+	case current < 0:
+		r.Reason = "no utilization recorded"
+	case r.Samples < e.config.MinSamples:
+		r.Reason = fmt.Sprintf("%d of %d samples recorded", r.Samples, e.config.MinSamples)
+	case e.config.MemoryUpsizeAbove > 0 && r.PeakMemory >= e.config.MemoryUpsizeAbove:
+		target = min(current+1, len(sizes)-1)
+		r.Reason = fmt.Sprintf("peak GPU memory %.0f%% at or above %.0f%%", r.PeakMemory, e.config.MemoryUpsizeAbove)
+	case r.AverageGPU >= e.config.UpsizeAbove:
+		target = min(current+1, len(sizes)-1)
+		r.Reason = fmt.Sprintf("average GPU utilization %.0f%% at or above %.0f%%", r.AverageGPU, e.config.UpsizeAbove)
+	case r.AverageGPU < e.config.DownsizeBelow:
+		target = max(current-1, 0)
+		r.Reason = fmt.Sprintf("average GPU utilization %.0f%% below %.0f%%", r.AverageGPU, e.config.DownsizeBelow)
+	default:
+		r.Reason = fmt.Sprintf("average GPU utilization %.0f%% within %.0f%%-%.0f%%", r.AverageGPU, e.config.DownsizeBelow, e.config.UpsizeAbove)
+	}
+
+	// Recent activities raise the target to the sizes they need
+	for _, activityType := range slices.Sorted(maps.Keys(w.activities)) {
+		if w.activities[activityType].Before(cutoff) {
+			continue
+		}
+		size := e.config.ActivitySizes[activityType]
+		if floor := slices.Index(sizes, size); floor > target {
+			target = floor
+			r.Reason = fmt.Sprintf("recent %s activity needs at least %s", activityType, size)
+		}
+	}
+
+	if target >= 0 {
+		r.Recommended = sizes[target]
+	}
+	switch {
+	case current < 0:
+	case target > current:
+		r.Direction = DirectionLarger
+	case target < current:
+		r.Direction = DirectionSmaller
+	default:
+		r.Direction = DirectionKeep
+	}
+	return r
+}
+
+// Run prunes users without hints in the window every minute until ctx is
+// cancelled
+func (e *Engine) Run(ctx context.Context) error {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			e.prune(time.Now())
+		}
+	}
+}
+
+// prune drops samples and activities past the window, and users left with
+// neither
+func (e *Engine) prune(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cutoff := now.Add(-e.config.Window)
+	for userID, w := range e.users {
+		w.samples = slices.DeleteFunc(w.samples, func(s sample) bool {
+			return s.at.Before(cutoff)
+		})
+		for activityType, at := range w.activities {
+			if at.Before(cutoff) {
+				delete(w.activities, activityType)
+			}
+		}
+		if len(w.samples) == 0 && len(w.activities) == 0 {
+			delete(e.users, userID)
+		}
+	}
+}
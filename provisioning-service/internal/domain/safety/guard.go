@@ -0,0 +1,130 @@
+package safety
+
+import (
+	"sync"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/user"
+	"go.uber.org/zap"
+)
+
+// Invariants checked by the guard
+const (
+	// CheckTerminateInUse fires when a termination targets a node that is not
+	// marked allocated but still has a user on it
+	CheckTerminateInUse = "terminate_in_use"
+
+	// CheckIdleInUse fires when an idle candidate still has a user on it
+	CheckIdleInUse = "idle_in_use"
+
+	// CheckUserOnTerminatedNode fires when the user tracker has a user
+	// connected to a node that is terminated, terminating or unknown
+	CheckUserOnTerminatedNode = "user_on_terminated_node"
+
+	// CheckAllocatedWithoutUser fires when a node holds a slot for a user
+	// the user tracker does not have connected to it, or is allocated with
+	// no users at all
+	CheckAllocatedWithoutUser = "allocated_without_user"
+
+	// CheckDuplicateAllocation fires when a user holds slots on a node other
+	// than the one the user tracker has them connected to
+	CheckDuplicateAllocation = "duplicate_allocation"
+)
+
+// DriftChecks are the checks comparing the pool with the user tracker
+var DriftChecks = []string{CheckUserOnTerminatedNode, CheckAllocatedWithoutUser, CheckDuplicateAllocation}
+
+// Observer is notified of invariant violations
+type Observer interface {
+	ObserveViolation(check string)
+
+	// ObserveDrifts reports how many drifts a check found unresolved
+	ObserveDrifts(check string, open int)
+}
+
+// Guard enforces invariants that protect live user sessions from
+// inconsistent node state, e.g. a stray status event marking an allocated
+// node ready
+type Guard struct {
+	userTracker *user.UserTracker
+	observer    Observer
+	logger      *zap.Logger
+
+	mu         sync.Mutex
+	violations map[string]int64
+}
+
+// NewGuard creates a new guard
+func NewGuard(userTracker *user.UserTracker, observer Observer, logger *zap.Logger) *Guard {
+	return &Guard{
+		userTracker: userTracker,
+		observer:    observer,
+		logger:      logger,
+		violations:  make(map[string]int64),
+	}
+}
+
+// InUse reports whether a user is on a node according to either the node
+// record or the user tracker, returning the first user if known
+func (g *Guard) InUse(n *node.Node) (string, bool) {
+	if userID := n.User(); userID != "" {
+		return userID, true
+	}
+	return g.userTracker.UserOnNode(n.ID)
+}
+
+// Violation records an invariant violation and raises an alert
+func (g *Guard) Violation(check string, n *node.Node, userID string) {
+	g.mu.Lock()
+	g.violations[check]++
+	g.mu.Unlock()
+
+	g.observer.ObserveViolation(check)
+	g.logger.Error("ALERT: safety invariant violated",
+		zap.String("check", check),
+		zap.String("node_id", n.ID),
+		zap.String("status", string(n.Status)),
+		zap.String("user_id", userID),
+	)
+}
+
+// Drift records a mismatch between the pool and the user tracker that
+// persisted across consistency checks. One left in place raises an alert;
+// a repaired one is only logged.
+func (g *Guard) Drift(check, nodeID, userID, detail string, repaired bool) {
+	g.mu.Lock()
+	g.violations[check]++
+	g.mu.Unlock()
+
+	g.observer.ObserveViolation(check)
+	fields := []zap.Field{
+		zap.String("check", check),
+		zap.String("node_id", nodeID),
+		zap.String("user_id", userID),
+		zap.String("detail", detail),
+	}
+	if repaired {
+		g.logger.Warn("repaired pool and user tracker drift", fields...)
+		return
+	}
+	g.logger.Error("ALERT: pool and user tracker drift", fields...)
+}
+
+// OpenDrifts reports how many drifts each check found left in place
+func (g *Guard) OpenDrifts(open map[string]int) {
+	for _, check := range DriftChecks {
+		g.observer.ObserveDrifts(check, open[check])
+	}
+}
+
+// Violations returns the number of violations per check
+func (g *Guard) Violations() map[string]int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	counts := make(map[string]int64, len(g.violations))
+	for check, n := range g.violations {
+		counts[check] = n
+	}
+	return counts
+}
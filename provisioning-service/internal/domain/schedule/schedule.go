@@ -0,0 +1,136 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Sources sessions are loaded from; each replaces only its own sessions
+const (
+	SourceFeed = "feed" // The polled calendar feed
+	SourceAPI  = "api"  // Sessions pushed through the admin API
+)
+
+// Session is a scheduled class or workshop expected to bring its attendees
+// online together
+type Session struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title,omitempty"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Attendees int       `json:"attendees"`
+	TenantID  string    `json:"tenant_id,omitempty"`
+	Source    string    `json:"source,omitempty"`
+}
+
+// Validate checks that a session can be planned for
+func (s Session) Validate() error {
+	switch {
+	case s.ID == "":
+		return errors.New("missing session id")
+	case s.Start.IsZero() || s.End.IsZero():
+		return fmt.Errorf("session %s: missing start or end", s.ID)
+	case !s.End.After(s.Start):
+		return fmt.Errorf("session %s: end must be after start", s.ID)
+	case s.Attendees < 0:
+		return fmt.Errorf("session %s: negative attendees", s.ID)
+	}
+	return nil
+}
+
+// Config holds how scheduled sessions turn into demand
+type Config struct {
+	// Lead is how long before a session starts its attendees count as
+	// demand, on top of the prediction window
+	Lead time.Duration
+
+	// Attendance is the fraction of a session's attendees expected to
+	// connect
+	Attendance float64
+}
+
+// Estimate is the demand scheduled sessions bring at a point in time
+type Estimate struct {
+	Attendees float64   // Attendees expected to be connected, scaled by Attendance
+	Sessions  []Session // Sessions contributing, in start order
+}
+
+// Schedule holds the upcoming sessions from every source
+type Schedule struct {
+	mu       sync.RWMutex
+	config   Config
+	sessions map[string][]Session // By source, in start order
+}
+
+// New creates an empty schedule
+func New(config Config) *Schedule {
+	return &Schedule{
+		config:   config,
+		sessions: make(map[string][]Session),
+	}
+}
+
+// Replace swaps a source's sessions for the given ones, which must be valid
+func (s *Schedule) Replace(source string, sessions []Session) error {
+	sorted := make([]Session, 0, len(sessions))
+	for _, session := range sessions {
+		if err := session.Validate(); err != nil {
+			return err
+		}
+		session.Source = source
+		sorted = append(sorted, session)
+	}
+	slices.SortFunc(sorted, func(a, b Session) int {
+		return a.Start.Compare(b.Start)
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[source] = sorted
+	return nil
+}
+
+// Sessions returns a source's sessions, in start order
+func (s *Schedule) Sessions(source string) []Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.sessions[source])
+}
+
+// Upcoming returns the sessions that have not ended by now, in start order
+func (s *Schedule) Upcoming(now time.Time) []Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var upcoming []Session
+	for _, sessions := range s.sessions {
+		for _, session := range sessions {
+			if session.End.After(now) {
+				upcoming = append(upcoming, session)
+			}
+		}
+	}
+	slices.SortFunc(upcoming, func(a, b Session) int {
+		return a.Start.Compare(b.Start)
+	})
+	return upcoming
+}
+
+// Expected returns the attendees of the sessions running at the given time,
+// counting each from Lead before it starts until it ends
+func (s *Schedule) Expected(at time.Time) Estimate {
+	var est Estimate
+	attendees := 0
+	for _, session := range s.Upcoming(at) {
+		if at.Before(session.Start.Add(-s.config.Lead)) {
+			continue
+		}
+		attendees += session.Attendees
+		est.Sessions = append(est.Sessions, session)
+	}
+	est.Attendees = float64(attendees) * s.config.Attendance
+	return est
+}
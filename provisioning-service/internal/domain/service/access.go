@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/access"
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"go.uber.org/zap"
+)
+
+// ErrAccessDenied is returned when a user is not allowed a node
+var ErrAccessDenied = errcode.New(errcode.AccessDenied, "access denied")
+
+// ErrOtherTenant is returned for an event received for a user of another
+// tenant than the one the event names
+var ErrOtherTenant = errcode.New(errcode.AccessDenied, "user belongs to another tenant")
+
+// AccessSnapshot returns the access mode and lists
+func (p *Provisioner) AccessSnapshot() access.Snapshot {
+	return p.access.Snapshot()
+}
+
+// GrantAccess puts a user on an access list
+func (p *Provisioner) GrantAccess(list, userID string) error {
+	if err := p.access.Add(list, userID); err != nil {
+		return err
+	}
+	p.logger.Info("user added to access list",
+		zap.String("list", list),
+		zap.String("user_id", userID),
+	)
+	return nil
+}
+
+// RevokeAccess takes a user off an access list
+func (p *Provisioner) RevokeAccess(list, userID string) error {
+	if err := p.access.Remove(list, userID); err != nil {
+		return err
+	}
+	p.logger.Info("user removed from access list",
+		zap.String("list", list),
+		zap.String("user_id", userID),
+	)
+	return nil
+}
+
+// checkTenant rejects an event naming a tenant, such as one received on a
+// tenant's channels, for a user known to belong to another
+func (p *Provisioner) checkTenant(userID, tenantID string) error {
+	known := p.userTracker.TenantOf(userID)
+	if tenantID == "" || known == "" || known == tenantID {
+		return nil
+	}
+
+	p.logger.Warn("event for a user of another tenant rejected",
+		zap.String("user_id", userID),
+		zap.String("tenant_id", tenantID),
+		zap.String("user_tenant_id", known),
+	)
+	return fmt.Errorf("%w: user %s is not in tenant %s", ErrOtherTenant, userID, tenantID)
+}
+
+// denyUnauthorized checks a connecting user against their tenant, the
+// access lists and authorizer, rejecting the connect if they are not
+// allowed a node
+func (p *Provisioner) denyUnauthorized(ctx context.Context, event events.UserConnectEvent) bool {
+	if err := p.checkTenant(event.UserID, event.TenantID); err != nil {
+		p.replyAllocation(ctx, event, events.AllocationResultEvent{
+			Status: events.AllocationStatusFailed,
+			Reason: err.Error(),
+			Code:   string(errcode.AccessDenied),
+		})
+		p.publishAllocationFailed(ctx, event, events.FailureAccessDenied, errcode.AccessDenied, err, 0)
+		return true
+	}
+
+	decision := p.access.Check(ctx, event.UserID, event.TenantID)
+	if decision.Err != nil {
+		p.logger.Error("authorization service failed",
+			zap.String("user_id", event.UserID),
+			zap.Bool("allowed", decision.Allowed),
+			zap.Error(decision.Err),
+		)
+	}
+	if decision.Allowed {
+		return false
+	}
+
+	p.logger.Warn("user denied access",
+		zap.String("user_id", event.UserID),
+		zap.String("tenant_id", event.TenantID),
+		zap.String("reason", decision.Reason),
+	)
+
+	err := fmt.Errorf("%w: %s", ErrAccessDenied, decision.Reason)
+	p.replyAllocation(ctx, event, events.AllocationResultEvent{
+		Status: events.AllocationStatusFailed,
+		Reason: err.Error(),
+		Code:   string(errcode.AccessDenied),
+	})
+	p.publishAllocationFailed(ctx, event, events.FailureAccessDenied, errcode.AccessDenied, err, 0)
+	return true
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+func TestEventsOfAnotherTenantRejected(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().Unix()
+	p := newTestProvisioner(Config{})
+	p.pool.Replace([]node.Node{*readyNode("n1", "u1")})
+	p.users.MarkConnected("u1", "n1")
+	p.users.SetTenant("u1", "acme")
+
+	err := p.HandleUserDisconnect(ctx, events.UserDisconnectEvent{UserID: "u1", TenantID: "globex"})
+	if !errors.Is(err, ErrOtherTenant) {
+		t.Errorf("disconnect from another tenant = %v, want ErrOtherTenant", err)
+	}
+	if n, _ := p.pool.Get("n1"); !n.HasUser("u1") {
+		t.Fatal("another tenant released the user's node")
+	}
+
+	p.HandleUserConnect(ctx, events.UserConnectEvent{UserID: "u1", TenantID: "globex"})
+	failed := p.publisher.on(events.ChannelAllocationFailed)
+	if len(failed) != 1 || !strings.Contains(failed[0], string(events.FailureAccessDenied)) {
+		t.Errorf("connect from another tenant published %v, want an access denial", failed)
+	}
+
+	err = p.HandleUserActivityBatch(ctx, events.UserActivityBatchEvent{Activities: []events.UserActivityEvent{
+		{UserID: "u1", TenantID: "globex", Timestamp: now},
+		{UserID: "u2", TenantID: "globex", Timestamp: now},
+	}})
+	if err != nil {
+		t.Fatalf("HandleUserActivityBatch: %v", err)
+	}
+	if p.users.ActivityCountOf("u1", time.Hour) != 0 {
+		t.Error("activity for the user recorded from another tenant")
+	}
+	if p.users.ActivityCountOf("u2", time.Hour) != 1 {
+		t.Error("activity of a user without a known tenant dropped")
+	}
+
+	// Events of the user's own tenant, or naming none, pass
+	if err := p.HandleUserDisconnect(ctx, events.UserDisconnectEvent{UserID: "u1", TenantID: "acme"}); err != nil {
+		t.Errorf("disconnect from the user's tenant: %v", err)
+	}
+	if err := p.HandleUserActivity(ctx, events.UserActivityEvent{UserID: "u1", Timestamp: now}); err != nil {
+		t.Errorf("activity without a tenant: %v", err)
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+)
+
+type allocationReplyKey struct{}
+
+// Allocate serves a connect as HandleUserConnect does, but returns the
+// allocation result, with the node's connection details, to the caller
+// instead of publishing it. A refused connect returns a failed result and
+// the error, if any, it failed with.
+func (p *Provisioner) Allocate(ctx context.Context, event events.UserConnectEvent) (events.AllocationResultEvent, error) {
+	event.ReplyChannel, event.CorrelationID = "", ""
+
+	var result events.AllocationResultEvent
+	err := p.HandleUserConnect(context.WithValue(ctx, allocationReplyKey{}, &result), event)
+	return result, err
+}
+
+// awaitsReply reports whether ctx belongs to an Allocate call
+func awaitsReply(ctx context.Context) bool {
+	_, ok := ctx.Value(allocationReplyKey{}).(*events.AllocationResultEvent)
+	return ok
+}
+
+// replyToAllocate stores result as the reply of the Allocate call ctx
+// belongs to, reporting whether there is one
+func (p *Provisioner) replyToAllocate(ctx context.Context, result events.AllocationResultEvent) bool {
+	reply, ok := ctx.Value(allocationReplyKey{}).(*events.AllocationResultEvent)
+	if !ok {
+		return false
+	}
+	*reply = p.withEndpoint(result)
+	return true
+}
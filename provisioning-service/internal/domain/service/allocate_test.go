@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/allocator"
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/lifecycle"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+	"go.uber.org/zap"
+)
+
+func TestAllocateReturnsResult(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvisioner(Config{})
+	p.pool.Replace([]node.Node{*readyNode("n1")})
+
+	result, err := p.Allocate(ctx, events.UserConnectEvent{UserID: "u1", ReplyChannel: "reply:u1"})
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if result.Status != events.AllocationStatusUnconfirmed || result.NodeID != "n1" || result.AuthToken != "secret-n1" || result.ReservedUntil == 0 {
+		t.Errorf("result = %+v, want n1 reserved with its token", result)
+	}
+	if replies := p.publisher.on("reply:u1"); len(replies) != 0 {
+		t.Errorf("published %d replies for a synchronous allocation", len(replies))
+	}
+
+	result, err = p.Allocate(ctx, events.UserConnectEvent{UserID: "u2"})
+	if errcode.Of(err) != errcode.NoCapacity {
+		t.Errorf("err = %v, want no capacity", err)
+	}
+	if result.Status != events.AllocationStatusFailed || result.Code != string(errcode.NoCapacity) {
+		t.Errorf("result = %+v, want a failure with its code", result)
+	}
+}
+
+// blockingHook holds every hook run until released
+type blockingHook struct {
+	release chan struct{}
+}
+
+func (h blockingHook) Name() string { return "warmup" }
+
+func (h blockingHook) Run(ctx context.Context, stage lifecycle.Stage, target lifecycle.Target) error {
+	<-h.release
+	return nil
+}
+
+func TestPostAllocateHooksRunOffEventPath(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvisioner(Config{})
+	p.allocator = allocator.NewNodeAllocator(p.pool, p.users, allocator.LocalClaims{}, 0, nil, zap.NewNop())
+	hook := blockingHook{release: make(chan struct{})}
+	p.lifecycle = lifecycle.NewManager(map[lifecycle.Stage][]lifecycle.Hook{lifecycle.StagePostAllocate: {hook}}, zap.NewNop())
+	p.pool.Replace([]node.Node{*readyNode("n1"), *readyNode("n2")})
+
+	handled := make(chan error, 1)
+	go func() {
+		handled <- p.HandleUserConnect(ctx, events.UserConnectEvent{UserID: "u1", ReplyChannel: "reply:u1"})
+	}()
+	select {
+	case err := <-handled:
+		if err != nil {
+			t.Fatalf("HandleUserConnect: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("HandleUserConnect blocked on the post-allocate hooks")
+	}
+	if replies := p.publisher.on("reply:u1"); len(replies) != 0 {
+		t.Fatalf("replied %s before the post-allocate hooks finished", replies)
+	}
+
+	// The reply follows the hooks
+	close(hook.release)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(p.publisher.on("reply:u1")) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no reply once the post-allocate hooks finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reply := p.publisher.on("reply:u1")[0]; !strings.Contains(reply, `"status":"allocated"`) {
+		t.Errorf("reply = %s, want allocated", reply)
+	}
+
+	// Allocate answers its caller after the hooks, on its own goroutine
+	result, err := p.Allocate(ctx, events.UserConnectEvent{UserID: "u2"})
+	if err != nil || result.Status != events.AllocationStatusAllocated {
+		t.Errorf("Allocate = %+v, %v; want allocated", result, err)
+	}
+}
+
+func TestReservationsFollowTunedPredictionWindow(t *testing.T) {
+	p := newTestProvisioner(Config{})
+	p.predictor = predictor.NewPredictor(predictor.DefaultPredictionConfig(), p.users, p.pool, nil, nil, nil)
+	p.pool.Replace([]node.Node{*readyNode("n1")})
+	p.slo.ConnectMissed("u1")
+
+	ctx := context.Background()
+	if _, err := p.UpdatePredictionTuning(ctx, func(t *predictor.Tuning) { t.PredictionWindow = time.Hour }); err != nil {
+		t.Fatalf("update tuning: %v", err)
+	}
+	p.offerToWaitingUser(ctx, "n1")
+
+	n, _ := p.pool.Get("n1")
+	if n.ReservedFor != "u1" {
+		t.Fatalf("reserved for %q, want u1", n.ReservedFor)
+	}
+	if left := time.Until(n.ReservedUntil); left < 59*time.Minute {
+		t.Errorf("reservation lasts %s, want the tuned hour", left)
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/boottime"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+const (
+	// defaultBootTime is assumed until a node has been seen booting
+	defaultBootTime = 30 * time.Second
+
+	// bootTimeWeight is the weight of each new observation in the boot time
+	// moving average
+	bootTimeWeight = 0.2
+
+	// minRetryAfter is the shortest retry hint given to a waiting user
+	minRetryAfter = 5 * time.Second
+)
+
+// EstimatedBootTime returns the moving average of observed boot times
+func (p *Provisioner) EstimatedBootTime() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.bootTimeAvg == 0 {
+		return defaultBootTime
+	}
+	return p.bootTimeAvg
+}
+
+// BootTimeStats are the recent boot times of an instance type pool and the
+// prediction window they give it
+type BootTimeStats struct {
+	boottime.Stats
+	PredictionWindow time.Duration
+}
+
+// BootTimes returns the recent boot times of each pool with observed boots
+func (p *Provisioner) BootTimes() map[string]BootTimeStats {
+	result := make(map[string]BootTimeStats)
+	for instanceType, stats := range p.predictor.BootTimes() {
+		result[instanceType] = BootTimeStats{
+			Stats:            stats,
+			PredictionWindow: p.predictor.PredictionWindow(instanceType),
+		}
+	}
+	return result
+}
+
+// PredictionWindow returns how far ahead predicted demand is currently
+// looked for
+func (p *Provisioner) PredictionWindow() time.Duration {
+	return p.predictor.DemandWindow()
+}
+
+// recordBootTime folds a node's time from provisioning to ready into the
+// estimate and the predictor's boot time percentiles; a successful boot also
+// ends any run of boot failures
+func (p *Provisioner) recordBootTime(n *node.Node, d time.Duration) {
+	p.predictor.ObserveBootTime(n, d)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.bootFailures = 0
+	p.bootBackoffUntil = time.Time{}
+
+	if p.bootTimeAvg == 0 {
+		p.bootTimeAvg = d
+		return
+	}
+	p.bootTimeAvg = time.Duration(float64(p.bootTimeAvg)*(1-bootTimeWeight) + float64(d)*bootTimeWeight)
+}
+
+// retryAfter estimates how long until a ready node is likely to be available,
+// based on the booting node closest to ready
+func (p *Provisioner) retryAfter() time.Duration {
+	estimate := p.EstimatedBootTime()
+
+	wait := estimate
+	for _, n := range p.nodePool.GetAllByStatus(node.NodeStatusBooting) {
+		if left := estimate - time.Since(n.CreatedAt); left < wait {
+			wait = left
+		}
+	}
+	return max(wait, minRetryAfter)
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// BootObserver is notified of nodes that failed to boot
+type BootObserver interface {
+	ObserveBootFailure(instanceType string)
+}
+
+// BootFailureState describes repeated boot failures and the provisioning
+// backoff they trigger
+type BootFailureState struct {
+	Consecutive      int
+	BackoffRemaining time.Duration
+}
+
+// BootFailureState returns the consecutive boot failure count and backoff
+func (p *Provisioner) BootFailureState() BootFailureState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return BootFailureState{
+		Consecutive:      p.bootFailures,
+		BackoffRemaining: remaining(p.bootBackoffUntil, time.Now()),
+	}
+}
+
+// handleBootFailure accounts for a node that was terminated without ever
+// becoming ready and provisions a replacement while the node's retry budget
+// lasts. Once consecutive failures reach the threshold, provisioning backs
+// off exponentially, since the cause is likely the image or capacity rather
+// than the node. The failure is recorded on the operations feed with the
+// diagnostics collected from the provider.
+func (p *Provisioner) handleBootFailure(ctx context.Context, n *node.Node, reason string, diag node.Diagnostics) {
+	p.bootObserver.ObserveBootFailure(n.InstanceType)
+	p.emitBootFailure(n, reason, diag)
+
+	p.logger.Warn("node failed to boot",
+		zap.String("node_id", n.ID),
+		zap.String("provider", n.Provider),
+		zap.String("instance_type", n.InstanceType),
+		zap.String("reason", reason),
+		zap.String("provider_status", diag.Status),
+		zap.String("status_message", diag.StatusMessage),
+		zap.String("console_output", diag.ConsoleOutput),
+	)
+
+	p.mu.Lock()
+	p.bootFailures++
+	consecutive := p.bootFailures
+	var backoff time.Duration
+	if consecutive >= p.config.BootFailureThreshold {
+		backoff = p.config.BootFailureBackoff << (consecutive - p.config.BootFailureThreshold)
+		if backoff <= 0 || backoff > p.config.BootFailureMaxBackoff {
+			backoff = p.config.BootFailureMaxBackoff
+		}
+		p.bootBackoffUntil = time.Now().Add(backoff)
+	}
+	p.mu.Unlock()
+
+	p.alertBootFailure(ctx, n, reason, diag, consecutive, backoff)
+	if backoff > 0 {
+		p.logger.Error("ALERT: repeated node boot failures, backing off provisioning",
+			zap.Int("consecutive_failures", consecutive),
+			zap.Duration("backoff", backoff),
+			zap.String("instance_type", n.InstanceType),
+			zap.String("last_reason", reason),
+			zap.String("last_status_message", diag.StatusMessage),
+		)
+	}
+
+	if n.BootAttempt >= p.config.BootRetryBudget {
+		p.logger.Error("giving up on node after exhausting boot retry budget",
+			zap.String("node_id", n.ID),
+			zap.Int("attempts", n.BootAttempt),
+			zap.String("reason", reason),
+			zap.String("status_message", diag.StatusMessage),
+		)
+		return
+	}
+
+	if p.Draining() {
+		p.logger.Debug("replacement for failed node skipped while draining", zap.String("node_id", n.ID))
+		return
+	}
+
+	if left := p.BootFailureState().BackoffRemaining; left > 0 {
+		p.logger.Warn("replacement for failed node deferred by boot failure backoff",
+			zap.String("node_id", n.ID),
+			zap.Duration("remaining", left),
+		)
+		return
+	}
+
+	// A node that spilled over is replaced from its pool's instance type
+	instanceType := cmp.Or(n.PoolType, n.InstanceType)
+	if p.budgetAllows(ctx, instanceType, 1) == 0 {
+		p.logger.Warn("replacement for failed node blocked by budget",
+			zap.String("node_id", n.ID),
+		)
+		return
+	}
+
+	nodeIDs, err := p.provisionNodes(ctx, instanceType, n.Labels, "", 1, n.BootAttempt+1)
+	if errors.Is(err, ErrDryRun) {
+		return
+	}
+	if err != nil {
+		p.logger.Error("failed to provision replacement node",
+			zap.String("failed_node_id", n.ID),
+			zap.String("code", string(errcode.Of(err))),
+			zap.Error(err),
+		)
+		return
+	}
+	if n.Burst {
+		p.nodePool.MarkBurst(nodeIDs[0])
+	}
+
+	p.logger.Info("provisioned replacement for failed node",
+		zap.String("node_id", nodeIDs[0]),
+		zap.String("failed_node_id", n.ID),
+		zap.Int("attempt", n.BootAttempt+1),
+		zap.Int("budget", p.config.BootRetryBudget),
+	)
+}
+
+// alertBootFailure publishes a node's boot failure and its diagnostics on
+// the boot failure alert channel
+func (p *Provisioner) alertBootFailure(ctx context.Context, n *node.Node, reason string, diag node.Diagnostics, consecutive int, backoff time.Duration) {
+	data, err := p.config.CloudEvents.Encode(events.ChannelBootFailureAlert, events.BootFailureAlertEvent{
+		SchemaVersion:       events.CurrentSchemaVersion,
+		NodeID:              n.ID,
+		InstanceType:        n.InstanceType,
+		Provider:            n.Provider,
+		Reason:              reason,
+		Attempt:             n.BootAttempt,
+		ConsecutiveFailures: consecutive,
+		BackoffSeconds:      backoff.Seconds(),
+		ProviderStatus:      diag.Status,
+		StatusMessage:       diag.StatusMessage,
+		ConsoleOutput:       diag.ConsoleOutput,
+		Timestamp:           time.Now().Unix(),
+	})
+	if err != nil {
+		p.logger.Error("failed to marshal boot failure alert", zap.Error(err))
+		return
+	}
+
+	if err := p.publisher.Publish(ctx, events.ChannelBootFailureAlert, string(data)); err != nil {
+		p.logger.Error("failed to publish boot failure alert",
+			zap.String("node_id", n.ID),
+			zap.Error(err),
+		)
+	}
+}
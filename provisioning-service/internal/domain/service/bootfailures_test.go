@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// recordingPublisher keeps every message published, by channel
+type recordingPublisher struct {
+	mu       sync.Mutex
+	messages map[string][]string
+}
+
+func (r *recordingPublisher) Publish(ctx context.Context, channel, message string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.messages == nil {
+		r.messages = make(map[string][]string)
+	}
+	r.messages[channel] = append(r.messages[channel], message)
+	return nil
+}
+
+func (r *recordingPublisher) on(channel string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.messages[channel]
+}
+
+func TestAlertBootFailure(t *testing.T) {
+	pub := &recordingPublisher{}
+	p := &Provisioner{publisher: pub, logger: zap.NewNop()}
+
+	n := &node.Node{ID: "n1", InstanceType: "m5.large", Provider: "a", BootAttempt: 2}
+	diag := node.Diagnostics{Status: "pending", StatusMessage: "InsufficientInstanceCapacity", ConsoleOutput: "kernel panic"}
+	p.alertBootFailure(t.Context(), n, "stuck booting", diag, 3, 30*time.Second)
+
+	msgs := pub.on(events.ChannelBootFailureAlert)
+	if len(msgs) != 1 {
+		t.Fatalf("published %d alerts, want 1", len(msgs))
+	}
+
+	var alert events.BootFailureAlertEvent
+	if err := json.Unmarshal([]byte(msgs[0]), &alert); err != nil {
+		t.Fatal(err)
+	}
+	want := events.BootFailureAlertEvent{
+		SchemaVersion:       events.CurrentSchemaVersion,
+		NodeID:              "n1",
+		InstanceType:        "m5.large",
+		Provider:            "a",
+		Reason:              "stuck booting",
+		Attempt:             2,
+		ConsecutiveFailures: 3,
+		BackoffSeconds:      30,
+		ProviderStatus:      "pending",
+		StatusMessage:       "InsufficientInstanceCapacity",
+		ConsoleOutput:       "kernel panic",
+		Timestamp:           alert.Timestamp,
+	}
+	if alert != want {
+		t.Errorf("alert = %+v, want %+v", alert, want)
+	}
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/budget"
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// ErrBudgetExceeded is returned when the spend limits block provisioning
+var ErrBudgetExceeded = errcode.New(errcode.BudgetExceeded, "spend limit reached")
+
+// BudgetSnapshot returns spend against the limits
+func (p *Provisioner) BudgetSnapshot() budget.Snapshot {
+	return p.budget.Snapshot()
+}
+
+// budgetAllows returns how many of count nodes of an instance type may be
+// provisioned within the spend limits, alerting when they first block
+func (p *Provisioner) budgetAllows(ctx context.Context, instanceType string, count int) int {
+	decision := p.budget.Allow(instanceType, count)
+	if decision.Allowed >= count {
+		return count
+	}
+
+	p.logger.Warn("scale-up limited by budget",
+		zap.String("instance_type", instanceType),
+		zap.Int("requested", count),
+		zap.Int("allowed", decision.Allowed),
+		zap.String("limit", decision.Limit),
+	)
+	if decision.Alert {
+		p.alertBudget(ctx, instanceType, count, decision)
+	}
+	return decision.Allowed
+}
+
+// retireOverBudget terminates free ready nodes while the hourly run rate is
+// above max_hourly_spend, the priciest first and burst nodes before others
+// of a price. Nodes reserved, held, cordoned or draining are left alone. The
+// spend limits win over min_ready_nodes, as they do for scale-ups.
+func (p *Provisioner) retireOverBudget(ctx context.Context) {
+	over := p.budget.Overspend()
+	if over <= 0 {
+		return
+	}
+
+	var candidates []*node.Node
+	for _, n := range p.nodePool.GetAllByStatus(node.NodeStatusReady) {
+		if n.Cordoned || n.Draining || n.IsReserved() || !n.Dedicated.IsZero() || p.budget.Price(n.InstanceType) <= 0 {
+			continue
+		}
+		candidates = append(candidates, n)
+	}
+	slices.SortFunc(candidates, func(a, b *node.Node) int {
+		if c := cmp.Compare(p.budget.Price(b.InstanceType), p.budget.Price(a.InstanceType)); c != 0 {
+			return c
+		}
+		switch {
+		case a.Burst && !b.Burst:
+			return -1
+		case b.Burst && !a.Burst:
+			return 1
+		}
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	})
+
+	p.logger.Warn("hourly run rate above spend limit, retiring ready nodes",
+		zap.Float64("overspend", over),
+		zap.Int("candidates", len(candidates)),
+	)
+	for _, n := range candidates {
+		if over <= 0 {
+			return
+		}
+		if p.terminationLimited() {
+			p.deferTermination(n, node.TerminationBudget)
+			continue
+		}
+
+		terminated, err := p.terminateNode(ctx, n.ID, node.TerminationBudget, false, node.NodeStatusReady)
+		if errors.Is(err, ErrDryRun) {
+			continue
+		}
+		if err != nil {
+			p.logger.Error("failed to retire node over budget",
+				zap.String("node_id", n.ID),
+				zap.Error(err),
+			)
+			continue
+		}
+		if terminated {
+			over -= p.budget.Price(n.InstanceType)
+			p.terminations++
+		}
+	}
+}
+
+// alertBudget raises an alert and publishes it on the budget alert channel
+func (p *Provisioner) alertBudget(ctx context.Context, instanceType string, requested int, decision budget.Decision) {
+	snapshot := p.budget.Snapshot()
+	p.logger.Error("ALERT: spend limit is blocking scale-ups",
+		zap.String("instance_type", instanceType),
+		zap.String("limit", decision.Limit),
+		zap.Int("requested", requested),
+		zap.Int("allowed", decision.Allowed),
+		zap.Float64("hourly_run_rate", snapshot.HourlyRunRate),
+		zap.Float64("spent_today", snapshot.SpentToday),
+	)
+
+	data, err := p.config.CloudEvents.Encode(events.ChannelBudgetAlert, events.BudgetAlertEvent{
+		SchemaVersion: events.CurrentSchemaVersion,
+		InstanceType:  instanceType,
+		Limit:         decision.Limit,
+		Requested:     requested,
+		Allowed:       decision.Allowed,
+		HourlyRunRate: snapshot.HourlyRunRate,
+		SpentToday:    snapshot.SpentToday,
+		MaxHourly:     snapshot.MaxHourly,
+		MaxDaily:      snapshot.MaxDaily,
+		Timestamp:     time.Now().Unix(),
+	})
+	if err != nil {
+		p.logger.Error("failed to marshal budget alert", zap.Error(err))
+		return
+	}
+
+	if err := p.publisher.Publish(ctx, events.ChannelBudgetAlert, string(data)); err != nil {
+		p.logger.Error("failed to publish budget alert", zap.Error(err))
+	}
+}
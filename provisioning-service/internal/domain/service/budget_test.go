@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/budget"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+)
+
+func TestRetireOverBudget(t *testing.T) {
+	provider := &stubProvider{}
+	p := newTestProvisioner(Config{}, NamedProvider{Name: "a", Provider: provider})
+	p.budget = budget.NewTracker(budget.Config{
+		Prices:    map[string]float64{"a100": 3, "t4": 1},
+		MaxHourly: 8,
+	}, p.pool, nopObserver{})
+
+	typed := func(n *node.Node, instanceType string, age time.Duration) node.Node {
+		n.InstanceType = instanceType
+		n.UpdatedAt = time.Now().Add(-age)
+		return *n
+	}
+	burst := typed(readyNode("burst"), "a100", 0)
+	burst.Burst = true
+	reserved := typed(readyNode("reserved"), "a100", 3*time.Hour)
+	reserved.ReservedFor, reserved.ReservedUntil = "u2", time.Now().Add(time.Minute)
+	p.pool.Replace([]node.Node{
+		typed(readyNode("cheap"), "t4", 4*time.Hour),
+		typed(readyNode("old"), "a100", 2*time.Hour),
+		typed(readyNode("new"), "a100", time.Hour),
+		burst,
+		reserved,
+		typed(readyNode("busy", "u1"), "a100", 0),
+	})
+
+	// 16 an hour against a limit of 8: the burst node and the oldest of the
+	// priciest free nodes go, then one more a100 as 2 is still over
+	p.retireOverBudget(context.Background())
+
+	if !slices.Equal(provider.terminated, []string{"burst", "old", "new"}) {
+		t.Errorf("terminated %v, want [burst old new]", provider.terminated)
+	}
+	n, _ := p.pool.Get("burst")
+	if n.Status != node.NodeStatusTerminated || n.TerminationReason != node.TerminationBudget {
+		t.Errorf("burst node = %s (%s), want terminated for budget", n.Status, n.TerminationReason)
+	}
+	if over := p.budget.Overspend(); over != 0 {
+		t.Errorf("overspend = %g after retiring, want 0", over)
+	}
+
+	// Back within the limit, nothing more is retired
+	p.retireOverBudget(context.Background())
+	if len(provider.terminated) != 3 {
+		t.Errorf("terminated %v within the limit", provider.terminated)
+	}
+}
+
+func TestStuckNodeKeepsTerminationReason(t *testing.T) {
+	provider := &stubProvider{}
+	p := newTestProvisioner(Config{}, NamedProvider{Name: "a", Provider: provider})
+	cfg := predictor.DefaultPredictionConfig()
+	p.predictor = predictor.NewPredictor(cfg, p.users, p.pool, nil, nil, nil)
+
+	stuck := *readyNode("stuck")
+	stuck.Status = node.NodeStatusBooting
+	stuck.CreatedAt = time.Now().Add(-2 * cfg.BootingNodeTimeout)
+	p.pool.Replace([]node.Node{stuck})
+
+	p.cleanupStuckNodes(context.Background())
+
+	n, ok := p.pool.Get("stuck")
+	if !ok {
+		t.Fatal("stuck node removed from the pool")
+	}
+	if n.Status != node.NodeStatusTerminated || n.TerminationReason != node.TerminationStuck {
+		t.Errorf("stuck node = %s (%s), want terminated as stuck", n.Status, n.TerminationReason)
+	}
+}
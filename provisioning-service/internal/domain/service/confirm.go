@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/allocator"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/lifecycle"
+	"go.uber.org/zap"
+)
+
+// HandleUserConfirm completes the allocation of a user who attached to the
+// node their connect reserved. A repeated confirmation is ignored.
+func (p *Provisioner) HandleUserConfirm(ctx context.Context, event events.UserConfirmEvent) error {
+	if err := p.checkTenant(event.UserID, event.TenantID); err != nil {
+		return err
+	}
+
+	unlock := p.locks.lock(event.NodeID)
+	defer unlock()
+
+	if err := p.allocator.ConfirmAllocation(event.UserID, event.NodeID); err != nil {
+		if errors.Is(err, allocator.ErrNotPending) {
+			if n, ok := p.nodePool.Get(event.NodeID); ok && n.HasUser(event.UserID) {
+				return nil
+			}
+		}
+		p.logger.Warn("allocation confirmation rejected",
+			zap.String("user_id", event.UserID),
+			zap.String("node_id", event.NodeID),
+			zap.Error(err),
+		)
+		return err
+	}
+
+	p.logger.Info("allocation confirmed",
+		zap.String("user_id", event.UserID),
+		zap.String("node_id", event.NodeID),
+	)
+	p.emitAllocation(feed.ActionConfirmed, event.UserID, event.NodeID, "")
+	p.startAllocation(ctx, event.UserID, event.NodeID, nil)
+	return nil
+}
+
+// expireReservations returns the nodes of users who did not confirm
+// attaching in time to the pool and tells their clients
+func (p *Provisioner) expireReservations(ctx context.Context) {
+	for userID, nodeID := range p.allocator.ExpireReservations(ctx) {
+		p.logger.Warn("allocation not confirmed in time, node released",
+			zap.String("user_id", userID),
+			zap.String("node_id", nodeID),
+		)
+		p.emitAllocation(feed.ActionExpired, userID, nodeID, "")
+		p.publishAllocation(ctx, events.ChannelAllocationResult, events.AllocationResultEvent{
+			UserID:         userID,
+			PreviousNodeID: nodeID,
+			Status:         events.AllocationStatusExpired,
+		})
+	}
+}
+
+// reservedUntil returns when a user's unconfirmed slot on a node lapses,
+// reporting false once the user holds the slot outright
+func (p *Provisioner) reservedUntil(nodeID, userID string) (time.Time, bool) {
+	n, ok := p.nodePool.Get(nodeID)
+	if !ok || !n.IsPending(userID) {
+		return time.Time{}, false
+	}
+	return n.Pending[userID], true
+}
+
+// startAllocation starts the session of a user holding a node, then runs
+// the post-allocate hooks and calls reply, if any, once they finish. Hooks
+// may take their whole timeout, so they run on their own goroutine and
+// event dispatch goes on meanwhile, except for an Allocate call, which
+// answers its caller after them.
+func (p *Provisioner) startAllocation(ctx context.Context, userID, nodeID string, reply func(ctx context.Context)) {
+	if reply == nil {
+		reply = func(context.Context) {}
+	}
+	n, ok := p.nodePool.Get(nodeID)
+	if !ok {
+		reply(ctx)
+		return
+	}
+	p.sessions.Start(userID, nodeID, n.InstanceType, time.Now())
+
+	target := hookTarget(n, userID)
+	if !p.lifecycle.Has(lifecycle.StagePostAllocate) || awaitsReply(ctx) {
+		p.runPostAllocateHooks(ctx, target)
+		reply(ctx)
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		p.runPostAllocateHooks(ctx, target)
+		reply(ctx)
+	}()
+}
+
+// runPostAllocateHooks runs the post-allocate hooks, whose failures are
+// only logged
+func (p *Provisioner) runPostAllocateHooks(ctx context.Context, target lifecycle.Target) {
+	if err := p.lifecycle.Run(ctx, lifecycle.StagePostAllocate, target); err != nil {
+		p.logger.Warn("post-allocate hook failed",
+			zap.String("user_id", target.UserID),
+			zap.String("node_id", target.NodeID),
+			zap.Error(err),
+		)
+	}
+}
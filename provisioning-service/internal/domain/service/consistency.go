@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/safety"
+	"github.com/aos-cc/provisioning-service/internal/domain/session"
+	"github.com/aos-cc/provisioning-service/internal/domain/user"
+)
+
+// Consistency compares the pool with the user tracker on an interval
+type Consistency struct {
+	// Interval is how often the pool and the user tracker are compared;
+	// zero disables the checks
+	Interval time.Duration
+
+	// Repair releases the allocations a drift leaves behind instead of
+	// only raising an alert
+	Repair bool
+}
+
+// drift is a mismatch between the pool and the user tracker
+type drift struct {
+	check  string
+	nodeID string
+	userID string // Empty for a node allocated with no users
+}
+
+// RunConsistencyChecks compares the pool with the user tracker every
+// Consistency.Interval until ctx is cancelled
+func (p *Provisioner) RunConsistencyChecks(ctx context.Context) error {
+	ticker := time.NewTicker(p.config.Consistency.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			p.checkConsistency(context.WithoutCancel(ctx))
+		}
+	}
+}
+
+// checkConsistency reports drifts seen by this check and the one before,
+// so allocations still being made or released are not taken for drift, and
+// repairs them if configured to. A drift left in place is reported once.
+// Standbys mirror the leader's pool and are not checked. Only called from
+// RunConsistencyChecks.
+func (p *Provisioner) checkConsistency(ctx context.Context) {
+	if !p.IsLeader() {
+		p.drifts = nil
+		p.guard.OpenDrifts(nil)
+		return
+	}
+
+	open := make(map[string]int)
+	seen := make(map[drift]bool)
+	for d, detail := range p.findDrifts() {
+		reported, ok := p.drifts[d]
+		switch {
+		case !ok:
+			seen[d] = false
+			continue
+		case reported:
+		case p.config.Consistency.Repair:
+			present, repaired := p.repairDrift(ctx, d)
+			if !present {
+				continue
+			}
+			p.guard.Drift(d.check, d.nodeID, d.userID, detail, repaired)
+			if repaired {
+				continue
+			}
+		default:
+			p.guard.Drift(d.check, d.nodeID, d.userID, detail, false)
+		}
+		seen[d] = true
+		open[d.check]++
+	}
+	p.drifts = seen
+	p.guard.OpenDrifts(open)
+}
+
+// driftSource looks up the state drifts are judged from
+type driftSource struct {
+	node      func(nodeID string) (node.Node, bool)
+	connected func(userID string) (user.UserState, bool) // Only users who are connected
+	migration func(userID string) (Migration, bool)
+}
+
+// findDrifts compares every node with the users the user tracker has
+// connected, returning each mismatch with a description
+func (p *Provisioner) findDrifts() map[drift]string {
+	nodes := make(map[string]node.Node)
+	for _, n := range p.nodePool.Snapshot() {
+		nodes[n.ID] = n
+	}
+	connected := make(map[string]user.UserState)
+	for _, s := range p.userTracker.ConnectedStates() {
+		connected[s.UserID] = s
+	}
+	migrating := make(map[string]Migration)
+	for _, m := range p.Migrations() {
+		migrating[m.UserID] = m
+	}
+	src := driftSource{
+		node:      lookup(nodes),
+		connected: lookup(connected),
+		migration: lookup(migrating),
+	}
+
+	found := make(map[drift]string)
+	for _, s := range connected {
+		src.userDrifts(s, found)
+	}
+	for _, n := range nodes {
+		src.nodeDrifts(n, found)
+	}
+	return found
+}
+
+// driftPresent reports whether a drift found earlier is still there,
+// looking only at the node and user it concerns
+func (p *Provisioner) driftPresent(d drift) bool {
+	src := driftSource{
+		node: func(nodeID string) (node.Node, bool) {
+			n, ok := p.nodePool.Get(nodeID)
+			if !ok {
+				return node.Node{}, false
+			}
+			return *n, true
+		},
+		connected: func(userID string) (user.UserState, bool) {
+			s, ok := p.userTracker.StateOf(userID)
+			return s, ok && s.IsConnected
+		},
+		migration: p.migration,
+	}
+
+	found := make(map[drift]string)
+	if d.check == safety.CheckUserOnTerminatedNode {
+		if s, ok := src.connected(d.userID); ok {
+			src.userDrifts(s, found)
+		}
+	} else if n, ok := src.node(d.nodeID); ok {
+		src.nodeDrifts(n, found)
+	}
+	_, ok := found[d]
+	return ok
+}
+
+// userDrifts adds to found a connected user's allocation of a node that is
+// gone or terminated
+func (src driftSource) userDrifts(s user.UserState, found map[drift]string) {
+	if s.AllocatedNodeID == "" {
+		return
+	}
+	n, ok := src.node(s.AllocatedNodeID)
+	switch {
+	case !ok:
+		found[drift{safety.CheckUserOnTerminatedNode, s.AllocatedNodeID, s.UserID}] = "node is not in the pool"
+	case n.Status == node.NodeStatusTerminated || n.Status == node.NodeStatusTerminating:
+		found[drift{safety.CheckUserOnTerminatedNode, n.ID, s.UserID}] = fmt.Sprintf("node is %s", n.Status)
+	}
+}
+
+// nodeDrifts adds to found the slots of an occupied node held for no user,
+// for users who are not connected, or for users allocated another node
+func (src driftSource) nodeDrifts(n node.Node, found map[drift]string) {
+	if !n.Occupied() {
+		return
+	}
+	if len(n.Users) == 0 {
+		found[drift{safety.CheckAllocatedWithoutUser, n.ID, ""}] = fmt.Sprintf("node is %s with no users", n.Status)
+		return
+	}
+	for _, userID := range n.Users {
+		s, ok := src.connected(userID)
+		m, isMigrating := src.migration(userID)
+		switch {
+		case ok && s.AllocatedNodeID == n.ID:
+		case isMigrating && (m.FromNodeID == n.ID || m.ToNodeID == n.ID):
+		case !ok:
+			found[drift{safety.CheckAllocatedWithoutUser, n.ID, userID}] = "user is not connected"
+		default:
+			found[drift{safety.CheckDuplicateAllocation, n.ID, userID}] = fmt.Sprintf("user is allocated node %s", s.AllocatedNodeID)
+		}
+	}
+}
+
+// lookup returns a function looking keys up in m
+func lookup[V any](m map[string]V) func(string) (V, bool) {
+	return func(key string) (V, bool) {
+		v, ok := m[key]
+		return v, ok
+	}
+}
+
+// repairDrift releases what a drift left behind, reporting whether the
+// drift was still present and whether it was repaired:
+//
+//   - a user connected to a terminated node is disconnected and their session
+//     ended
+//   - a slot held for a user who is not connected is released, returning the
+//     node to the pool once it is empty
+//   - a slot held for a user allocated another node is released and the node
+//     drained, since the state of the session left on it is unknown
+func (p *Provisioner) repairDrift(ctx context.Context, d drift) (present, repaired bool) {
+	unlock := p.locks.lock(d.nodeID)
+	defer unlock()
+
+	// Look again under the node's lock, in case the drift resolved itself
+	if !p.driftPresent(d) {
+		return false, false
+	}
+
+	switch d.check {
+	case safety.CheckUserOnTerminatedNode:
+		p.userTracker.MarkDisconnected(d.userID)
+		p.sessions.End(d.userID, session.EndTerminated, time.Now())
+	case safety.CheckAllocatedWithoutUser:
+		p.allocator.ReleaseSlot(ctx, d.nodeID, d.userID)
+	case safety.CheckDuplicateAllocation:
+		p.allocator.DrainSlot(ctx, d.nodeID, d.userID, node.TerminationDrift)
+	default:
+		return true, false
+	}
+	if d.userID != "" {
+		p.emitAllocation(feed.ActionRepaired, d.userID, d.nodeID, "")
+	}
+	return true, true
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/safety"
+)
+
+func TestConsistencyRepairsDrifts(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvisioner(Config{Consistency: Consistency{Repair: true}})
+	p.pool.Replace([]node.Node{*readyNode("n1", "u1"), *readyNode("n3", "u3")})
+	p.users.MarkConnected("u2", "gone")
+	p.users.MarkConnected("u3", "n3")
+
+	want := map[drift]bool{
+		{safety.CheckAllocatedWithoutUser, "n1", "u1"}:   true,
+		{safety.CheckUserOnTerminatedNode, "gone", "u2"}: true,
+	}
+	found := p.findDrifts()
+	if len(found) != len(want) {
+		t.Fatalf("found %v, want %v", found, want)
+	}
+	for d := range want {
+		if _, ok := found[d]; !ok || !p.driftPresent(d) {
+			t.Errorf("drift %+v not found", d)
+		}
+	}
+
+	// Drifts are repaired once seen by two checks in a row
+	p.checkConsistency(ctx)
+	p.checkConsistency(ctx)
+	if found := p.findDrifts(); len(found) != 0 {
+		t.Errorf("drifts left after repair: %v", found)
+	}
+	for d := range want {
+		if p.driftPresent(d) {
+			t.Errorf("drift %+v still present", d)
+		}
+	}
+	if n, _ := p.pool.Get("n1"); n.Status != node.NodeStatusReady || len(n.Users) != 0 {
+		t.Errorf("n1 = %s with %v, want it released", n.Status, n.Users)
+	}
+	if s, _ := p.users.StateOf("u2"); s.IsConnected {
+		t.Error("u2 still connected to a node not in the pool")
+	}
+	if s, _ := p.users.StateOf("u3"); !s.IsConnected || s.AllocatedNodeID != "n3" {
+		t.Errorf("u3 = %+v, want it left on n3", s)
+	}
+}
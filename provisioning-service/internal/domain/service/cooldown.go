@@ -0,0 +1,67 @@
+package service
+
+import (
+	"fmt"
+	"time"
+)
+
+// CooldownState describes the scaling cooldowns currently in effect
+type CooldownState struct {
+	LastScaleUp        time.Time
+	LastScaleDown      time.Time
+	ScaleUpRemaining   time.Duration
+	ScaleDownRemaining time.Duration
+}
+
+// CooldownState returns a snapshot of the scale-up and scale-down cooldowns
+func (p *Provisioner) CooldownState() CooldownState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cooldownStateLocked(time.Now())
+}
+
+func (p *Provisioner) cooldownStateLocked(now time.Time) CooldownState {
+	state := CooldownState{
+		LastScaleUp:   p.lastScaleUp,
+		LastScaleDown: p.lastScaleDown,
+	}
+
+	if !p.lastScaleUp.IsZero() {
+		state.ScaleUpRemaining = remaining(p.lastScaleUp.Add(p.config.ScaleUpCooldown), now)
+	}
+
+	// Scale-down waits for the cooldown after any scaling action so freshly
+	// provisioned nodes are not terminated before demand can materialize
+	lastAction := p.lastScaleUp
+	if p.lastScaleDown.After(lastAction) {
+		lastAction = p.lastScaleDown
+	}
+	if !lastAction.IsZero() {
+		state.ScaleDownRemaining = remaining(lastAction.Add(p.config.ScaleDownCooldown), now)
+	}
+
+	return state
+}
+
+func (p *Provisioner) recordScaleUp() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastScaleUp = time.Now()
+}
+
+func (p *Provisioner) recordScaleDown() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastScaleDown = time.Now()
+}
+
+func remaining(until, now time.Time) time.Duration {
+	if d := until.Sub(now); d > 0 {
+		return d
+	}
+	return 0
+}
+
+func cooldownReason(reason, kind string, left time.Duration) string {
+	return fmt.Sprintf("%s (%s cooldown, %s remaining)", reason, kind, left.Round(time.Second))
+}
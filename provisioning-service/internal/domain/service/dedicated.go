@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"go.uber.org/zap"
+)
+
+// holdDedicatedNodes keeps a warm node held for every dedicated user and
+// tenant, whatever their activity. A free ready node is taken where there is
+// one, and the scaling check that follows refills the pool it came from;
+// otherwise a node is provisioned and held while it boots. Dedicated nodes
+// are not subject to the scale-up cooldown or max_ready_nodes, but the spend
+// limits and the boot failure backoff still apply.
+func (p *Provisioner) holdDedicatedNodes(ctx context.Context) {
+	if p.Draining() {
+		return
+	}
+	for _, d := range p.predictor.MissingDedications() {
+		if n := p.nodePool.Dedicate(d); n != nil {
+			p.logger.Info("node held for dedicated capacity",
+				zap.String("dedicated", d.String()),
+				zap.String("node_id", n.ID),
+			)
+			continue
+		}
+
+		if p.BootFailureState().BackoffRemaining > 0 {
+			return
+		}
+		instanceType := p.predictor.Config().DefaultInstanceType
+		if p.budgetAllows(ctx, instanceType, 1) == 0 {
+			continue
+		}
+
+		nodeIDs, err := p.provisionNodes(ctx, instanceType, nil, "", 1, 1)
+		for _, nodeID := range nodeIDs {
+			p.nodePool.SetDedicated(nodeID, d)
+			p.logger.Info("node provisioned for dedicated capacity",
+				zap.String("dedicated", d.String()),
+				zap.String("node_id", nodeID),
+			)
+		}
+		if err != nil && !errors.Is(err, ErrDryRun) {
+			p.logger.Error("failed to provision node for dedicated capacity",
+				zap.String("dedicated", d.String()),
+				zap.String("code", string(errcode.Of(err))),
+				zap.Error(err),
+			)
+		}
+	}
+}
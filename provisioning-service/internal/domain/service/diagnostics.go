@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+const (
+	// diagnosticsTimeout bounds the diagnostics call for a failed node, so a
+	// slow provider does not hold up its termination for long
+	diagnosticsTimeout = 5 * time.Second
+
+	// maxConsoleOutput is how much of the end of a node's console output is
+	// kept with its boot failure
+	maxConsoleOutput = 4096
+)
+
+// collectDiagnostics fetches diagnostics for a node that failed to boot
+// from the provider that created it. It is called before the node is
+// terminated, while the provider still has them; failures are logged and
+// yield empty diagnostics.
+func (p *Provisioner) collectDiagnostics(ctx context.Context, n *node.Node) node.Diagnostics {
+	for _, provider := range p.providers {
+		if provider.Name != n.Provider {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, diagnosticsTimeout)
+		defer cancel()
+
+		diag, err := provider.Provider.GetNodeDiagnostics(ctx, n.ID)
+		if err != nil {
+			p.logger.Warn("failed to collect node diagnostics",
+				zap.String("node_id", n.ID),
+				zap.String("provider", provider.Name),
+				zap.Error(err),
+			)
+			return node.Diagnostics{}
+		}
+		if len(diag.ConsoleOutput) > maxConsoleOutput {
+			diag.ConsoleOutput = diag.ConsoleOutput[len(diag.ConsoleOutput)-maxConsoleOutput:]
+		}
+		return diag
+	}
+	return node.Diagnostics{}
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/requestid"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrDraining is returned for connects that need a node and for node
+	// creation while the service drains
+	ErrDraining = errcode.New(errcode.Draining, "service is draining")
+
+	// ErrNotDraining is returned when canceling a drain that was not started
+	ErrNotDraining = errcode.New(errcode.InvalidTransition, "service is not draining")
+)
+
+// DrainStatus is the progress of draining the whole service
+type DrainStatus struct {
+	Draining     bool
+	StartedAt    time.Time // Zero unless draining
+	NodesAtStart int       // Nodes not terminated when the drain started
+	Nodes        int       // Nodes not yet terminated
+	Occupied     int       // Nodes still hosting users
+	Users        int       // Users still on nodes
+}
+
+// Complete reports whether every node has been terminated
+func (s DrainStatus) Complete() bool {
+	return s.Draining && s.Nodes == 0
+}
+
+// Draining reports whether the service is draining
+func (p *Provisioner) Draining() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.drainStartedAt.IsZero()
+}
+
+// Drain decommissions the service: no user is given a node and no node is
+// created from now on, while users already on nodes keep them. Every node
+// is drained and terminated once free, within the per-tick termination
+// limit. Draining an already draining service only reports its progress.
+func (p *Provisioner) Drain(ctx context.Context) DrainStatus {
+	p.mu.Lock()
+	started := p.drainStartedAt.IsZero()
+	if started {
+		p.drainStartedAt = time.Now()
+		p.drainNodesAtStart = p.nodePool.Count() - p.nodePool.CountByStatus(node.NodeStatusTerminated)
+	}
+	p.mu.Unlock()
+
+	if started {
+		drained := p.drainPool()
+		p.logger.Warn("service draining",
+			zap.String("request_id", requestid.From(ctx)),
+			zap.Int("nodes", drained),
+		)
+		p.replicateChange(ctx)
+	}
+	return p.DrainStatus()
+}
+
+// CancelDrain stops draining the service and returns the nodes drained for
+// it that are not yet terminating to service, returning how many
+func (p *Provisioner) CancelDrain(ctx context.Context) (int, error) {
+	p.mu.Lock()
+	if p.drainStartedAt.IsZero() {
+		p.mu.Unlock()
+		return 0, ErrNotDraining
+	}
+	p.drainStartedAt = time.Time{}
+	p.drainNodesAtStart = 0
+	p.mu.Unlock()
+
+	restored := 0
+	for _, n := range p.nodePool.GetDrainingNodes() {
+		if n.TerminationReason != node.TerminationDecommission || n.Status == node.NodeStatusTerminating {
+			continue
+		}
+		if p.nodePool.SetCordoned(n.ID, false) {
+			restored++
+		}
+	}
+	p.logger.Warn("service drain canceled",
+		zap.String("request_id", requestid.From(ctx)),
+		zap.Int("nodes_restored", restored),
+	)
+	p.replicateChange(ctx)
+	return restored, nil
+}
+
+// drainState returns the drain state handed off and replicated, nil
+// unless draining
+func (p *Provisioner) drainState() *handoff.Drain {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.drainStartedAt.IsZero() {
+		return nil
+	}
+	return &handoff.Drain{StartedAt: p.drainStartedAt, NodesAtStart: p.drainNodesAtStart}
+}
+
+// restoreDrain takes over a handed off or replicated drain state, so a
+// service draining keeps draining on the replica or process succeeding it
+func (p *Provisioner) restoreDrain(drain *handoff.Drain) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if drain == nil {
+		p.drainStartedAt, p.drainNodesAtStart = time.Time{}, 0
+		return
+	}
+	p.drainStartedAt, p.drainNodesAtStart = drain.StartedAt, drain.NodesAtStart
+}
+
+// DrainStatus reports the progress of draining the service
+func (p *Provisioner) DrainStatus() DrainStatus {
+	p.mu.Lock()
+	status := DrainStatus{
+		Draining:     !p.drainStartedAt.IsZero(),
+		StartedAt:    p.drainStartedAt,
+		NodesAtStart: p.drainNodesAtStart,
+	}
+	p.mu.Unlock()
+
+	status.Nodes = p.nodePool.Count() - p.nodePool.CountByStatus(node.NodeStatusTerminated)
+	status.Occupied = p.nodePool.CountOccupiedWhere(nil)
+	status.Users = p.nodePool.CountUsersWhere(nil)
+	return status
+}
+
+// drainPool drains every node not yet draining, returning how many; nodes
+// already draining keep their reason. The loop calls it while the service
+// drains, so nodes that join later, such as ones reported by status events,
+// are drained too.
+func (p *Provisioner) drainPool() int {
+	drained := 0
+	for _, n := range p.nodePool.GetAll() {
+		if n.Status == node.NodeStatusTerminated || n.Draining {
+			continue
+		}
+		if p.nodePool.MarkDraining(n.ID, node.TerminationDecommission) {
+			drained++
+		}
+	}
+	return drained
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+// memoryHandoff keeps the last snapshot handed off
+type memoryHandoff struct {
+	snapshot *handoff.Snapshot
+}
+
+func (m *memoryHandoff) Save(ctx context.Context, snapshot handoff.Snapshot) error {
+	m.snapshot = &snapshot
+	return nil
+}
+
+func (m *memoryHandoff) Take(ctx context.Context) (handoff.Snapshot, bool, error) {
+	if m.snapshot == nil {
+		return handoff.Snapshot{}, false, nil
+	}
+	snapshot := *m.snapshot
+	m.snapshot = nil
+	return snapshot, true, nil
+}
+
+func TestDrainSurvivesHandOff(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryHandoff{}
+	config := Config{HandoffMaxAge: time.Hour}
+
+	old := newTestProvisioner(config)
+	old.handoff = store
+	old.pool.Replace([]node.Node{*readyNode("n1", "u1"), *readyNode("n2")})
+	started := old.Drain(ctx)
+	if err := old.HandOff(ctx); err != nil {
+		t.Fatalf("HandOff: %v", err)
+	}
+
+	next := newTestProvisioner(config)
+	next.handoff = store
+	if ok, err := next.TakeOver(ctx); !ok || err != nil {
+		t.Fatalf("TakeOver: ok=%v err=%v", ok, err)
+	}
+	status := next.DrainStatus()
+	if !status.Draining || !status.StartedAt.Equal(started.StartedAt) || status.NodesAtStart != 2 {
+		t.Errorf("status after takeover = %+v, want the drain started before", status)
+	}
+}
+
+func TestDrainReplicatedToStandby(t *testing.T) {
+	ctx := context.Background()
+	store := &handoff.Snapshot{}
+	config := Config{HandoffMaxAge: time.Hour, ReplicateChanges: true}
+
+	leader := newReplicaProvisioner(config, &fakeCluster{leader: true, store: store})
+	leader.pool.Replace([]node.Node{*readyNode("n1")})
+	standby := newReplicaProvisioner(config, &fakeCluster{store: store})
+
+	leader.Drain(ctx)
+	standby.follow(ctx)
+	if status := standby.DrainStatus(); !status.Draining || status.NodesAtStart != 1 {
+		t.Errorf("standby status = %+v, want draining", status)
+	}
+
+	if _, err := leader.CancelDrain(ctx); err != nil {
+		t.Fatalf("CancelDrain: %v", err)
+	}
+	standby.follow(ctx)
+	if standby.Draining() {
+		t.Error("standby still draining after the leader canceled")
+	}
+}
+
+func TestBootFailureReplacementSkippedWhileDraining(t *testing.T) {
+	for _, draining := range []bool{false, true} {
+		provider := &stubProvider{}
+		p := newTestProvisioner(Config{BootRetryBudget: 3, BootFailureThreshold: 10},
+			NamedProvider{Name: "stub", Provider: provider})
+		if draining {
+			p.Drain(context.Background())
+		}
+
+		n := readyNode("n1")
+		n.Status = node.NodeStatusBooting
+		p.handleBootFailure(context.Background(), n, "stuck booting", node.Diagnostics{})
+
+		if got := provider.provisions > 0; got == draining {
+			t.Errorf("draining=%v: replacement provisioned=%v", draining, got)
+		}
+	}
+}
+
+func TestTakeOverConsumesHandoff(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryHandoff{}
+	config := Config{HandoffMaxAge: time.Hour}
+
+	old := newTestProvisioner(config)
+	old.handoff = store
+	old.pool.Replace([]node.Node{*readyNode("n1")})
+	if err := old.HandOff(ctx); err != nil {
+		t.Fatalf("HandOff: %v", err)
+	}
+
+	for i, want := range []bool{true, false} {
+		next := newTestProvisioner(config)
+		next.handoff = store
+		if tookOver, err := next.TakeOver(ctx); err != nil || tookOver != want {
+			t.Fatalf("TakeOver %d = %v, %v, want %v", i+1, tookOver, err, want)
+		}
+	}
+}
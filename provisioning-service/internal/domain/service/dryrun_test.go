@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+func TestDryRunProvisionsNothing(t *testing.T) {
+	provider := &stubProvider{}
+	p := newTestProvisioner(Config{DryRun: true}, NamedProvider{Name: "a", Provider: provider})
+
+	if _, err := p.provisionNodes(context.Background(), "a100", nil, "", 2, 1); !errors.Is(err, ErrDryRun) {
+		t.Fatalf("err = %v, want the dry run", err)
+	}
+	if provider.provisions != 0 {
+		t.Errorf("provisioned %d times in a dry run", provider.provisions)
+	}
+}
+
+func TestDryRunTerminatesNothing(t *testing.T) {
+	provider := &stubProvider{}
+	p := newTestProvisioner(Config{DryRun: true}, NamedProvider{Name: "a", Provider: provider})
+	p.pool.Replace([]node.Node{*readyNode("n1")})
+
+	if err := p.TerminateNode(context.Background(), "n1", true); !errors.Is(err, ErrDryRun) {
+		t.Fatalf("err = %v, want the dry run", err)
+	}
+	if n, ok := p.pool.Get("n1"); !ok || n.Status != node.NodeStatusReady {
+		t.Errorf("node = %+v, want it still ready", n)
+	}
+	if len(provider.terminated) != 0 {
+		t.Errorf("terminated %v in a dry run", provider.terminated)
+	}
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+)
+
+// emitDecision publishes the outcome of a scaling check to the operations feed
+func (p *Provisioner) emitDecision(result ScalingCheckResult) {
+	d := feedDecision(result.Decision)
+	d.Deferred = result.Deferred
+	d.DryRun = result.DryRun
+	d.Provisioned = result.Provisioned
+	d.BudgetBlocked = result.BudgetBlocked
+	if result.Err != nil {
+		d.Error = result.Err.Error()
+	}
+
+	p.feed.Publish(feed.Event{
+		Type: feed.TypeScalingDecision,
+		Data: d,
+	})
+}
+
+func feedDecision(decision predictor.ScalingDecision) feed.Decision {
+	d := feed.Decision{
+		ShouldScaleUp:   decision.ShouldScaleUp,
+		ShouldScaleDown: decision.ShouldScaleDown,
+		TargetNodes:     decision.TargetNodes,
+		Reason:          decision.Reason,
+		InstanceType:    decision.InstanceType,
+		Labels:          decision.Labels,
+	}
+	for _, t := range decision.Types {
+		d.Types = append(d.Types, feedDecision(t))
+	}
+	return d
+}
+
+// emitAllocation publishes an allocation change to the operations feed
+func (p *Provisioner) emitAllocation(action, userID, nodeID, previousNodeID string) {
+	p.feed.Publish(feed.Event{
+		Type:     feed.TypeAllocation,
+		NodeID:   nodeID,
+		UserID:   userID,
+		TenantID: p.userTracker.TenantOf(userID),
+		Data: feed.Allocation{
+			Action:         action,
+			PreviousNodeID: previousNodeID,
+		},
+	})
+	p.followTransition(nodeID, action)
+	if previousNodeID != "" {
+		p.followTransition(previousNodeID, action)
+	}
+}
+
+// emitBootFailure records a node that failed to boot on the operations feed
+func (p *Provisioner) emitBootFailure(n *node.Node, reason string, diag node.Diagnostics) {
+	p.feed.Publish(feed.Event{
+		Type:   feed.TypeBootFailure,
+		NodeID: n.ID,
+		Data: feed.BootFailure{
+			InstanceType: n.InstanceType,
+			Provider:     n.Provider,
+			Attempt:      n.BootAttempt,
+			Reason:       reason,
+			Diagnostics:  diag,
+		},
+	})
+}
+
+// emitTransition publishes a node status change to the operations feed,
+// attributing it to the node's user if it has one
+func (p *Provisioner) emitTransition(n *node.Node, from, to node.NodeStatus, reason string) {
+	userID, _ := p.guard.InUse(n)
+
+	p.feed.Publish(feed.Event{
+		Type:     feed.TypeNodeTransition,
+		NodeID:   n.ID,
+		UserID:   userID,
+		TenantID: p.userTracker.TenantOf(userID),
+		Data: feed.NodeTransition{
+			From:         string(from),
+			To:           string(to),
+			InstanceType: n.InstanceType,
+			Reason:       reason,
+		},
+	})
+
+	p.statusesMu.Lock()
+	p.statuses[n.ID] = to
+	p.statusesMu.Unlock()
+}
+
+// publishedStatus returns the status a node was last published in, or
+// fallback if none was
+func (p *Provisioner) publishedStatus(nodeID string, fallback node.NodeStatus) node.NodeStatus {
+	p.statusesMu.Lock()
+	defer p.statusesMu.Unlock()
+
+	if status, ok := p.statuses[nodeID]; ok {
+		return status
+	}
+	return fallback
+}
+
+// followTransition publishes a node's status change that happened inside the
+// pool, such as an allocation, if it was not already published. A node never
+// published before is only recorded, since its previous status is unknown.
+func (p *Provisioner) followTransition(nodeID, reason string) {
+	n, ok := p.nodePool.Get(nodeID)
+	if !ok {
+		return
+	}
+	p.followNode(n, reason)
+}
+
+func (p *Provisioner) followNode(n *node.Node, reason string) {
+	p.statusesMu.Lock()
+	from, seen := p.statuses[n.ID]
+	if !seen {
+		p.statuses[n.ID] = n.Status
+	}
+	p.statusesMu.Unlock()
+
+	if seen && from != n.Status {
+		p.emitTransition(n, from, n.Status, reason)
+	}
+}
+
+// followTransitions publishes every status change no event path published,
+// like imported or restored nodes changing hands, and forgets purged nodes
+func (p *Provisioner) followTransitions() {
+	nodes := p.nodePool.Snapshot()
+	present := make(map[string]bool, len(nodes))
+	for i := range nodes {
+		present[nodes[i].ID] = true
+		p.followNode(&nodes[i], "observed")
+	}
+
+	p.statusesMu.Lock()
+	for id := range p.statuses {
+		if !present[id] {
+			delete(p.statuses, id)
+		}
+	}
+	p.statusesMu.Unlock()
+}
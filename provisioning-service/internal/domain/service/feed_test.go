@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+func TestEveryStatusChangeIsPublished(t *testing.T) {
+	p := newTestProvisioner(Config{}, NamedProvider{Name: "a", Provider: &stubProvider{}})
+	p.pool.Replace([]node.Node{*readyNode("idle"), *readyNode("busy", "u1"), *readyNode("taken")})
+	p.followTransitions()
+
+	sub := p.feed.Subscribe(feed.Filter{Types: []string{feed.TypeNodeTransition}})
+	defer sub.Close()
+
+	ctx := context.Background()
+	if ok, err := p.terminateNode(ctx, "idle", node.TerminationIdle, false, node.NodeStatusReady); !ok || err != nil {
+		t.Fatalf("terminate idle = %v, %v", ok, err)
+	}
+	if _, err := p.terminateNode(ctx, "busy", node.TerminationIdle, false, node.NodeStatusAllocated); err != ErrNodeAllocated {
+		t.Fatalf("terminate busy = %v, want ErrNodeAllocated", err)
+	}
+	// A change made inside the pool is caught by the next tick
+	p.pool.UpdateStatusIf("taken", node.NodeStatusReady, node.NodeStatusAllocated)
+	p.followTransitions()
+	p.followTransitions()
+
+	var got []string
+	for len(sub.Events()) > 0 {
+		e := <-sub.Events()
+		tr := e.Data.(feed.NodeTransition)
+		got = append(got, e.NodeID+":"+tr.From+">"+tr.To+":"+tr.Reason)
+	}
+	want := []string{
+		"idle:ready>terminating:idle",
+		"idle:terminating>terminated:idle",
+		"busy:allocated>terminating:idle",
+		"busy:terminating>allocated:node in use",
+		"taken:ready>allocated:observed",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("transitions =\n%v\nwant\n%v", got, want)
+	}
+}
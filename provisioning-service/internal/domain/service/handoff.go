@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// HandOff writes the pool and the connected users for the service replacing
+// this one. It is called on shutdown, once events are no longer consumed
+// and the scaling loop has stopped, so the snapshot is final. A standby
+// leaves it to the leader, whose state it only mirrors.
+func (p *Provisioner) HandOff(ctx context.Context) error {
+	if !p.IsLeader() {
+		return nil
+	}
+
+	snapshot := p.snapshot()
+	if err := p.handoff.Save(ctx, snapshot); err != nil {
+		return err
+	}
+	p.logger.Info("handed off pool",
+		zap.Int("nodes", len(snapshot.Nodes)),
+		zap.Int("users", len(snapshot.Users)),
+	)
+	return nil
+}
+
+// TakeOver loads the pool and connected users handed off by the previous
+// service, reporting false if there was no handoff or it is older than
+// HandoffMaxAge. The handoff is removed as it is read, so a later start
+// does not take it over again. Nodes keep the status, users and timestamps
+// they had, and their next status events bring them up to date.
+func (p *Provisioner) TakeOver(ctx context.Context) (bool, error) {
+	snapshot, ok, err := p.handoff.Take(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to load handoff: %w", err)
+	}
+	if !ok {
+		return false, nil
+	}
+	if age := time.Since(snapshot.WrittenAt); age > p.config.HandoffMaxAge {
+		p.logger.Warn("ignoring stale handoff",
+			zap.Duration("age", age),
+			zap.Duration("max_age", p.config.HandoffMaxAge),
+		)
+		return false, nil
+	}
+
+	nodes := p.nodePool.Restore(snapshot.Nodes)
+	p.userTracker.Restore(snapshot.Users)
+	p.restoreDrain(snapshot.Drain)
+	p.restoreSessions(snapshot.Sessions)
+
+	p.logger.Info("took over handed off pool",
+		zap.Int("nodes", nodes),
+		zap.Int("users", len(snapshot.Users)),
+		zap.Bool("draining", snapshot.Drain != nil),
+		zap.Duration("age", time.Since(snapshot.WrittenAt)),
+	)
+	return true, nil
+}
@@ -0,0 +1,256 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/allocator"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/session"
+	"go.uber.org/zap"
+)
+
+// Outcomes reported to an IdleObserver
+const (
+	IdleWarned    = "warned"    // A user was warned their idle node will be reclaimed
+	IdleReclaimed = "reclaimed" // An idle node was reclaimed from its user
+)
+
+// IdleReclaim reclaims nodes from users who stay connected but leave them idle
+type IdleReclaim struct {
+	// Timeout is how long an allocated node may report utilization below
+	// BusyThreshold before its users are warned; zero disables reclaiming
+	Timeout time.Duration
+
+	// BusyThreshold is the GPU utilization percent at or above which a
+	// sample counts as use
+	BusyThreshold float64
+
+	// Warnings is how many warnings, WarningInterval apart, a user gets
+	// before the node is reclaimed one WarningInterval after the last
+	Warnings        int
+	WarningInterval time.Duration
+
+	// SampleMaxAge is how old a node's latest sample may be for the node to
+	// be judged idle, so nodes that stop reporting are left alone
+	SampleMaxAge time.Duration
+}
+
+// IdleObserver is notified of idle warnings and reclaims
+type IdleObserver interface {
+	ObserveIdleReclaim(outcome string)
+}
+
+// idleWarning tracks the warnings sent to a user on an idle node
+type idleWarning struct {
+	nodeID    string
+	sent      int
+	nextAt    time.Time // When the next warning is due
+	reclaimAt time.Time // When the node is reclaimed once every warning is sent
+}
+
+// HandleNodeUtilization records a node's utilization sample, and counts it
+// towards the instance type recommendations of the node's users. Samples for
+// nodes not in the pool are ignored.
+func (p *Provisioner) HandleNodeUtilization(ctx context.Context, event events.NodeUtilizationEvent) error {
+	sample := utilizationOf(event)
+	if !p.nodePool.SetUtilization(event.NodeID, sample, event.GPUPercent >= p.config.IdleReclaim.BusyThreshold) {
+		p.logger.Debug("ignoring utilization of unknown node",
+			zap.String("node_id", event.NodeID),
+		)
+		return nil
+	}
+	if n, ok := p.nodePool.Get(event.NodeID); ok {
+		p.recordWorkload(ctx, n, sample)
+	}
+	return nil
+}
+
+// utilizationOf returns the sample a utilization event reports, taken now
+// if it carries no timestamp
+func utilizationOf(event events.NodeUtilizationEvent) node.Utilization {
+	at := time.Now()
+	if event.Timestamp > 0 {
+		at = time.Unix(event.Timestamp, 0)
+	}
+	return node.Utilization{
+		GPUPercent:    event.GPUPercent,
+		MemoryPercent: event.MemoryPercent,
+		ReportedAt:    at,
+	}
+}
+
+// reclaimIdleNodes warns the users of allocated nodes that have been idle
+// past the timeout, and reclaims the nodes of those still idle after the
+// last warning. Warnings are forgotten once a node is busy again.
+func (p *Provisioner) reclaimIdleNodes(ctx context.Context) {
+	if p.config.IdleReclaim.Timeout <= 0 {
+		return
+	}
+
+	now := time.Now()
+	idle := make(map[string]*node.Node) // Idle node by user ID
+	for _, n := range p.nodePool.GetAllByStatus(node.NodeStatusAllocated) {
+		if !p.isIdle(n, now) {
+			continue
+		}
+		for _, userID := range n.Users {
+			if _, migrating := p.migration(userID); !migrating && !n.IsPending(userID) {
+				idle[userID] = n
+			}
+		}
+	}
+	p.forgetIdleWarnings(idle)
+
+	for userID, n := range idle {
+		warning, reclaimAt, reclaim := p.nextIdleStep(userID, n.ID, now)
+		switch {
+		case reclaim:
+			p.reclaimNode(ctx, userID, n, now)
+		case warning > 0:
+			p.warnIdle(ctx, userID, n, warning, reclaimAt, now)
+		}
+	}
+}
+
+// isIdle reports whether a node has recent utilization samples and has not
+// been busy for the idle timeout
+func (p *Provisioner) isIdle(n *node.Node, now time.Time) bool {
+	cfg := p.config.IdleReclaim
+	reported := n.Utilization.ReportedAt
+	return !reported.IsZero() && now.Sub(reported) <= cfg.SampleMaxAge && now.Sub(n.BusyAt) >= cfg.Timeout
+}
+
+// forgetIdleWarnings drops the warnings of users no longer on an idle node
+func (p *Provisioner) forgetIdleWarnings(idle map[string]*node.Node) {
+	p.idleMu.Lock()
+	defer p.idleMu.Unlock()
+
+	for userID, w := range p.idleWarnings {
+		if n, ok := idle[userID]; !ok || n.ID != w.nodeID {
+			delete(p.idleWarnings, userID)
+		}
+	}
+}
+
+// nextIdleStep returns the warning due for a user on an idle node, if any,
+// with when the node will be reclaimed, or reports that it is reclaimed now
+func (p *Provisioner) nextIdleStep(userID, nodeID string, now time.Time) (int, time.Time, bool) {
+	cfg := p.config.IdleReclaim
+
+	p.idleMu.Lock()
+	defer p.idleMu.Unlock()
+
+	w, ok := p.idleWarnings[userID]
+	if !ok {
+		w = &idleWarning{nodeID: nodeID, nextAt: now}
+		p.idleWarnings[userID] = w
+	}
+	if w.sent < cfg.Warnings {
+		if now.Before(w.nextAt) {
+			return 0, time.Time{}, false
+		}
+		w.sent++
+		w.nextAt = now.Add(cfg.WarningInterval)
+		w.reclaimAt = now.Add(time.Duration(cfg.Warnings-w.sent+1) * cfg.WarningInterval)
+		return w.sent, w.reclaimAt, false
+	}
+	if now.Before(w.reclaimAt) {
+		return 0, time.Time{}, false
+	}
+	delete(p.idleWarnings, userID)
+	return 0, time.Time{}, true
+}
+
+// warnIdle tells a user their idle node will be reclaimed
+func (p *Provisioner) warnIdle(ctx context.Context, userID string, n *node.Node, warning int, reclaimAt, now time.Time) {
+	idleFor := now.Sub(n.BusyAt)
+	p.idleObserver.ObserveIdleReclaim(IdleWarned)
+	p.logger.Info("warning idle user",
+		zap.String("user_id", userID),
+		zap.String("node_id", n.ID),
+		zap.Int("warning", warning),
+		zap.Duration("idle_for", idleFor),
+		zap.Time("reclaim_at", reclaimAt),
+	)
+
+	p.feed.Publish(feed.Event{
+		Type:     feed.TypeIdleWarning,
+		NodeID:   n.ID,
+		UserID:   userID,
+		TenantID: p.userTracker.TenantOf(userID),
+		Data: feed.IdleWarning{
+			Warning:     warning,
+			Warnings:    p.config.IdleReclaim.Warnings,
+			IdleSeconds: idleFor.Seconds(),
+			GPUPercent:  n.Utilization.GPUPercent,
+			ReclaimAt:   reclaimAt.Unix(),
+		},
+	})
+
+	data, err := p.config.CloudEvents.Encode(events.ChannelUserIdleWarning, events.IdleWarningEvent{
+		SchemaVersion: events.CurrentSchemaVersion,
+		UserID:        userID,
+		NodeID:        n.ID,
+		Warning:       warning,
+		Warnings:      p.config.IdleReclaim.Warnings,
+		IdleSeconds:   int64(idleFor.Seconds()),
+		GPUPercent:    n.Utilization.GPUPercent,
+		ReclaimAt:     reclaimAt.Unix(),
+		Timestamp:     now.Unix(),
+	})
+	if err != nil {
+		p.logger.Error("failed to marshal idle warning", zap.Error(err))
+		return
+	}
+	if err := p.publisher.Publish(p.userContext(ctx, userID), events.ChannelUserIdleWarning, string(data)); err != nil {
+		p.logger.Error("failed to publish idle warning",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+	}
+}
+
+// reclaimNode tears down an idle user's allocation like an operator
+// deallocation, draining the node, and tells the user's client
+func (p *Provisioner) reclaimNode(ctx context.Context, userID string, n *node.Node, now time.Time) {
+	unlock := p.locks.lock(n.ID)
+	defer unlock()
+
+	// The user may have left or moved since the pool was read
+	if current, ok := p.allocator.GetAllocation(userID); !ok || current != n.ID {
+		return
+	}
+
+	nodeID, err := p.allocator.ForceDeallocate(ctx, userID, node.TerminationIdleReclaim)
+	if errors.Is(err, allocator.ErrUserNotFound) {
+		return
+	}
+	if err != nil {
+		p.logger.Error("failed to reclaim idle node",
+			zap.String("user_id", userID),
+			zap.String("node_id", n.ID),
+			zap.Error(err),
+		)
+		return
+	}
+
+	p.idleObserver.ObserveIdleReclaim(IdleReclaimed)
+	p.sessions.End(userID, session.EndReclaimed, now)
+	p.emitAllocation(feed.ActionReclaimed, userID, nodeID, "")
+
+	p.logger.Warn("idle node reclaimed",
+		zap.String("user_id", userID),
+		zap.String("node_id", nodeID),
+		zap.Duration("idle_for", now.Sub(n.BusyAt)),
+	)
+
+	p.publishAllocation(ctx, events.ChannelAllocationResult, events.AllocationResultEvent{
+		UserID:         userID,
+		PreviousNodeID: nodeID,
+		Status:         events.AllocationStatusReclaimed,
+	})
+}
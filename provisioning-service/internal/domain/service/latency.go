@@ -0,0 +1,312 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// Escalation steps tried for a user waiting past their tier's latency budget
+const (
+	// EscalateInstanceType provisions a node of the tier's fallback instance type
+	EscalateInstanceType = "instance_type"
+
+	// EscalateProvider provisions a node with the fallback providers,
+	// skipping the first in the chain
+	EscalateProvider = "provider"
+
+	// EscalateSharedNode places the user on a node already hosting others,
+	// whatever labels they asked for
+	EscalateSharedNode = "shared_node"
+)
+
+// errNoFallbackProvider fails the provider step without a second provider
+var errNoFallbackProvider = errors.New("no fallback provider configured")
+
+// errPoolFull fails a provisioning step for a pool at its node cap
+var errPoolFull = errcode.New(errcode.NoCapacity, "pool is at its node cap")
+
+// LatencyTier bounds how long users of a tier wait for a node
+type LatencyTier struct {
+	// MaxWait is the time-to-node budget from a user's first connect that
+	// found no warm node
+	MaxWait time.Duration
+
+	// Escalation lists the steps tried in order once the budget is exceeded,
+	// one more each further MaxWait the user waits
+	Escalation []string
+
+	// InstanceType is provisioned by the instance_type step
+	InstanceType string
+}
+
+// LatencyObserver is notified of latency budget breaches and the
+// escalations they trigger
+type LatencyObserver interface {
+	ObserveLatencyBreach(tier string)
+	ObserveEscalation(step, outcome string)
+}
+
+// TierMembers lists the users and tenants entitled to a tier
+type TierMembers struct {
+	Users   []string
+	Tenants []string
+}
+
+// entitledTier returns the tier a connect asks for if its user, or the
+// tenant it names, is listed for it, and "" otherwise. Connects come from
+// clients, so the tier they carry is only a request.
+func (p *Provisioner) entitledTier(event events.UserConnectEvent) string {
+	members := p.config.TierMembers[event.Tier]
+	if slices.Contains(members.Users, event.UserID) || event.TenantID != "" && slices.Contains(members.Tenants, event.TenantID) {
+		return event.Tier
+	}
+	p.logger.Warn("tier not granted to user",
+		zap.String("user_id", event.UserID),
+		zap.String("tenant_id", event.TenantID),
+		zap.String("tier", event.Tier),
+	)
+	return ""
+}
+
+// latencyBreach tracks the escalation of a user waiting past their budget
+type latencyBreach struct {
+	next   int       // Index of the next escalation step
+	nextAt time.Time // When the next step may be tried
+}
+
+// latencyTier returns the tier a user falls under and its budget
+func (p *Provisioner) latencyTier(userID string) (string, LatencyTier, bool) {
+	tier := p.userTracker.TierOf(userID)
+	if tier == "" {
+		tier = p.config.DefaultTier
+	}
+	budget, ok := p.config.LatencyTiers[tier]
+	return tier, budget, ok
+}
+
+// enforceLatencyBudgets records users waiting past their tier's budget as
+// breaches and escalates their wait one step at a time
+func (p *Provisioner) enforceLatencyBudgets(ctx context.Context) {
+	// A draining service gives no node to anyone, however long they wait
+	if len(p.config.LatencyTiers) == 0 || p.Draining() {
+		return
+	}
+
+	now := time.Now()
+	pending := p.slo.Pending()
+	p.forgetBreaches(pending)
+
+	for _, userID := range p.slo.Waiting() {
+		since, ok := pending[userID]
+		if !ok {
+			continue
+		}
+		tierName, tier, ok := p.latencyTier(userID)
+		waited := now.Sub(since)
+		if !ok || waited < tier.MaxWait {
+			continue
+		}
+
+		step, ok := p.nextEscalation(userID, tierName, tier, waited, now)
+		if !ok {
+			continue
+		}
+		p.escalate(ctx, userID, tierName, tier, step, waited)
+	}
+}
+
+// nextEscalation records a breach the first time a user is seen past their
+// budget and returns the escalation step due for them, if any
+func (p *Provisioner) nextEscalation(userID, tierName string, tier LatencyTier, waited time.Duration, now time.Time) (string, bool) {
+	p.breachesMu.Lock()
+	b, breached := p.breaches[userID]
+	if !breached {
+		b = &latencyBreach{nextAt: now}
+		p.breaches[userID] = b
+	}
+	var step string
+	due := b.next < len(tier.Escalation) && !now.Before(b.nextAt)
+	if due {
+		step = tier.Escalation[b.next]
+		b.next++
+		b.nextAt = now.Add(tier.MaxWait)
+	}
+	p.breachesMu.Unlock()
+
+	if !breached {
+		p.latencyObserver.ObserveLatencyBreach(tierName)
+		p.logger.Warn("latency budget exceeded",
+			zap.String("user_id", userID),
+			zap.String("tier", tierName),
+			zap.Duration("max_wait", tier.MaxWait),
+			zap.Duration("waited", waited),
+		)
+		p.emitLatency(feed.TypeLatencyBreach, userID, "", feed.LatencyBreach{
+			Tier:           tierName,
+			MaxWaitSeconds: tier.MaxWait.Seconds(),
+			WaitedSeconds:  waited.Seconds(),
+		})
+	}
+	return step, due
+}
+
+// forgetBreaches drops the escalations of users no longer waiting
+func (p *Provisioner) forgetBreaches(pending map[string]time.Time) {
+	p.breachesMu.Lock()
+	defer p.breachesMu.Unlock()
+
+	for userID := range p.breaches {
+		if _, ok := pending[userID]; !ok {
+			delete(p.breaches, userID)
+		}
+	}
+	for nodeID, userID := range p.escalatedNodes {
+		if _, ok := pending[userID]; !ok {
+			delete(p.escalatedNodes, nodeID)
+		}
+	}
+}
+
+// escalate takes one escalation step for a user and records its outcome
+func (p *Provisioner) escalate(ctx context.Context, userID, tierName string, tier LatencyTier, step string, waited time.Duration) {
+	labels := node.Labels(p.userTracker.SelectorOf(userID))
+	instanceType := p.predictor.Config().DefaultInstanceType
+
+	var nodeID string
+	var err error
+	switch step {
+	case EscalateInstanceType:
+		instanceType = tier.InstanceType
+		nodeID, err = p.provisionEscalation(ctx, userID, tierName, p.providers, instanceType, labels)
+	case EscalateProvider:
+		if len(p.providers) < 2 {
+			err = errNoFallbackProvider
+			break
+		}
+		nodeID, err = p.provisionEscalation(ctx, userID, tierName, p.providers[1:], instanceType, labels)
+	case EscalateSharedNode:
+		instanceType = ""
+		nodeID, err = p.allocateSharedNode(ctx, userID)
+	}
+
+	outcome := "ok"
+	data := feed.LatencyBreach{
+		Tier:           tierName,
+		MaxWaitSeconds: tier.MaxWait.Seconds(),
+		WaitedSeconds:  waited.Seconds(),
+		Step:           step,
+		InstanceType:   instanceType,
+	}
+	if err != nil {
+		outcome = "failed"
+		data.Error = err.Error()
+		p.logger.Warn("latency escalation failed",
+			zap.String("user_id", userID),
+			zap.String("tier", tierName),
+			zap.String("step", step),
+			zap.Error(err),
+		)
+	} else {
+		p.logger.Info("latency escalation",
+			zap.String("user_id", userID),
+			zap.String("tier", tierName),
+			zap.String("step", step),
+			zap.String("node_id", nodeID),
+		)
+	}
+	p.latencyObserver.ObserveEscalation(step, outcome)
+	p.emitLatency(feed.TypeEscalation, userID, nodeID, data)
+}
+
+// provisionEscalation provisions a node for a user of a tier past their
+// budget with the given providers, within the pool's node cap as a
+// demand-driven scale-up is. The node is offered to that user first once
+// it is ready.
+func (p *Provisioner) provisionEscalation(ctx context.Context, userID, tier string, providers []NamedProvider, instanceType string, labels node.Labels) (string, error) {
+	// The scaling check must not provision into the same room
+	p.scalingMu.Lock()
+	defer p.scalingMu.Unlock()
+
+	if p.predictor.Room(instanceType) == 0 {
+		return "", errPoolFull
+	}
+	if p.budgetAllows(ctx, instanceType, 1) == 0 {
+		return "", ErrBudgetExceeded
+	}
+
+	nodeIDs, err := p.provisionNodesWith(ctx, providers, instanceType, labels, tier, 1, 1)
+	if len(nodeIDs) == 0 {
+		return "", err
+	}
+
+	p.breachesMu.Lock()
+	p.escalatedNodes[nodeIDs[0]] = userID
+	p.breachesMu.Unlock()
+	return nodeIDs[0], nil
+}
+
+// takeEscalatedNode returns the user a node was provisioned for by an
+// escalation, forgetting it
+func (p *Provisioner) takeEscalatedNode(nodeID string) (string, bool) {
+	p.breachesMu.Lock()
+	defer p.breachesMu.Unlock()
+
+	userID, ok := p.escalatedNodes[nodeID]
+	delete(p.escalatedNodes, nodeID)
+	return userID, ok
+}
+
+// allocateSharedNode serves a waiting user from a node already hosting
+// others and tells them where to connect, answering the connect they wait
+// with on its reply channel and with its correlation ID
+func (p *Provisioner) allocateSharedNode(ctx context.Context, userID string) (string, error) {
+	nodeID, err := p.allocator.AllocateSharedNode(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	p.slo.ConnectServed(userID)
+	connect, waiting := p.takeWaitingConnect(userID)
+	if waiting {
+		p.openWaitingSession(connect, nodeID)
+	}
+	result := events.AllocationResultEvent{
+		CorrelationID: connect.CorrelationID,
+		UserID:        userID,
+		NodeID:        nodeID,
+		Status:        events.AllocationStatusAllocated,
+	}
+	publish := func(ctx context.Context) {
+		p.publishAllocation(ctx, cmp.Or(connect.ReplyChannel, events.ChannelAllocationResult), result)
+	}
+	if until, reserved := p.reservedUntil(nodeID, userID); reserved {
+		result.Status = events.AllocationStatusUnconfirmed
+		result.ReservedUntil = until.Unix()
+		p.emitAllocation(feed.ActionUnconfirmed, userID, nodeID, "")
+		publish(ctx)
+	} else {
+		p.emitAllocation(feed.ActionAllocated, userID, nodeID, "")
+		p.startAllocation(ctx, userID, nodeID, publish)
+	}
+	return nodeID, nil
+}
+
+// emitLatency records a latency breach or escalation on the operations feed
+func (p *Provisioner) emitLatency(eventType, userID, nodeID string, data feed.LatencyBreach) {
+	p.feed.Publish(feed.Event{
+		Type:     eventType,
+		NodeID:   nodeID,
+		UserID:   userID,
+		TenantID: p.userTracker.TenantOf(userID),
+		Data:     data,
+	})
+}
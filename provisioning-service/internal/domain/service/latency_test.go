@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+func TestConnectTierNeedsEntitlement(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvisioner(Config{TierMembers: map[string]TierMembers{
+		"premium": {Users: []string{"u1"}, Tenants: []string{"t1"}},
+	}})
+
+	p.HandleUserConnect(ctx, events.UserConnectEvent{UserID: "u1", Tier: "premium"})
+	p.HandleUserConnect(ctx, events.UserConnectEvent{UserID: "u2", Tier: "premium"})
+	p.HandleUserConnect(ctx, events.UserConnectEvent{UserID: "u3", TenantID: "t1", Tier: "premium"})
+
+	for userID, want := range map[string]string{"u1": "premium", "u2": "", "u3": "premium"} {
+		if got := p.users.TierOf(userID); got != want {
+			t.Errorf("tier of %s = %q, want %q", userID, got, want)
+		}
+	}
+}
+
+func TestSharedNodeAnswersWaitingConnect(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvisioner(Config{})
+
+	p.HandleUserConnect(ctx, events.UserConnectEvent{UserID: "u1", ReplyChannel: "reply:u1", CorrelationID: "c1"})
+	shared := readyNode("n1", "other")
+	shared.Capacity = 2
+	p.pool.Replace([]node.Node{*shared})
+
+	sent := len(p.publisher.on("reply:u1"))
+	if _, err := p.allocateSharedNode(ctx, "u1"); err != nil {
+		t.Fatalf("allocateSharedNode: %v", err)
+	}
+	replies := p.publisher.on("reply:u1")
+	if len(replies) != sent+1 {
+		t.Fatalf("sent %d replies on the reply channel, want the allocation", len(replies)-sent)
+	}
+	if reply := replies[len(replies)-1]; !strings.Contains(reply, `"correlation_id":"c1"`) || !strings.Contains(reply, `"node_id":"n1"`) {
+		t.Errorf("reply = %s, want the node with the connect's correlation ID", reply)
+	}
+}
+
+func TestEscalationRespectsPoolCap(t *testing.T) {
+	provider := &stubProvider{}
+	p := newTestProvisioner(Config{}, NamedProvider{Name: "a", Provider: provider})
+
+	// The test predictor allows no nodes at all
+	_, err := p.provisionEscalation(context.Background(), "u1", "premium", p.providers, "a100", nil)
+	if !errors.Is(err, errPoolFull) {
+		t.Fatalf("err = %v, want the pool full", err)
+	}
+	if provider.provisions != 0 {
+		t.Errorf("provisioned %d times past the cap", provider.provisions)
+	}
+}
@@ -0,0 +1,45 @@
+package service
+
+import "sync"
+
+// nodeLocks serializes lifecycle operations on individual nodes so that, for
+// example, idle cleanup and an operator terminate never act on the same node
+// at once. Entries are dropped once no operation holds or waits on them.
+type nodeLocks struct {
+	mu    sync.Mutex
+	locks map[string]*nodeLock
+}
+
+type nodeLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newNodeLocks() *nodeLocks {
+	return &nodeLocks{locks: make(map[string]*nodeLock)}
+}
+
+// lock acquires the lock for a node and returns the function releasing it
+func (l *nodeLocks) lock(nodeID string) func() {
+	l.mu.Lock()
+	nl, ok := l.locks[nodeID]
+	if !ok {
+		nl = &nodeLock{}
+		l.locks[nodeID] = nl
+	}
+	nl.refs++
+	l.mu.Unlock()
+
+	nl.mu.Lock()
+
+	return func() {
+		nl.mu.Unlock()
+
+		l.mu.Lock()
+		nl.refs--
+		if nl.refs == 0 {
+			delete(l.locks, nodeID)
+		}
+		l.mu.Unlock()
+	}
+}
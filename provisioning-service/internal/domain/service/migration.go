@@ -0,0 +1,323 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/allocator"
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/session"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrMigrationInProgress is returned when migrating a user who is already
+// being migrated
+var ErrMigrationInProgress = errcode.New(errcode.InvalidTransition, "user is already being migrated")
+
+// Migration is a user's move to another node awaiting the client's
+// acknowledgment. The user holds a slot on both nodes until it completes.
+type Migration = handoff.Migration
+
+// MigrateUser allocates another node to a connected user and asks their
+// client to move the session over on user:migrate. The previous node is
+// released once the client acknowledges or the migration timeout passes.
+// The migration is replicated straight away, so a replica taking over
+// completes it rather than leaving the user a slot on both nodes.
+func (p *Provisioner) MigrateUser(ctx context.Context, userID, reason string) (Migration, error) {
+	if _, ok := p.migration(userID); ok {
+		return Migration{}, ErrMigrationInProgress
+	}
+
+	if current, ok := p.allocator.GetAllocation(userID); ok {
+		unlock := p.locks.lock(current)
+		defer unlock()
+	}
+
+	fromID, toID, err := p.allocator.AllocateMigrationTarget(ctx, userID)
+	switch {
+	case errors.Is(err, allocator.ErrUserNotFound):
+		return Migration{}, ErrUserNotAllocated
+	case errors.Is(err, allocator.ErrNoReadyNode), errors.Is(err, allocator.ErrNodeNotReady):
+		return Migration{}, ErrNoReadyNode
+	case err != nil:
+		return Migration{}, err
+	}
+
+	now := time.Now()
+	m := Migration{
+		ID:         uuid.NewString(),
+		UserID:     userID,
+		FromNodeID: fromID,
+		ToNodeID:   toID,
+		Reason:     reason,
+		StartedAt:  now,
+		Deadline:   now.Add(p.config.MigrationTimeout),
+	}
+
+	p.migrationsMu.Lock()
+	if _, ok := p.migrations[userID]; ok {
+		p.migrationsMu.Unlock()
+		p.allocator.AbortMigration(ctx, userID, toID)
+		return Migration{}, ErrMigrationInProgress
+	}
+	p.migrations[userID] = m
+	p.migrationsMu.Unlock()
+
+	p.logger.Info("user migration started",
+		zap.String("migration_id", m.ID),
+		zap.String("user_id", userID),
+		zap.String("from_node_id", fromID),
+		zap.String("to_node_id", toID),
+		zap.String("reason", reason),
+	)
+	p.publishMigrate(ctx, m)
+	p.replicateChange(ctx)
+
+	return m, nil
+}
+
+// Migrations returns the migrations awaiting acknowledgment
+func (p *Provisioner) Migrations() []Migration {
+	p.migrationsMu.Lock()
+	defer p.migrationsMu.Unlock()
+
+	migrations := make([]Migration, 0, len(p.migrations))
+	for _, m := range p.migrations {
+		migrations = append(migrations, m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+	return migrations
+}
+
+// HandleUserMigrateAck completes or aborts a migration on the client's
+// reply. Replies to migrations that already completed, timed out or were
+// superseded are ignored.
+func (p *Provisioner) HandleUserMigrateAck(ctx context.Context, event events.UserMigrateAckEvent) error {
+	if err := p.checkTenant(event.UserID, event.TenantID); err != nil {
+		return err
+	}
+
+	m, ok := p.migration(event.UserID)
+	if !ok || m.ID != event.MigrationID {
+		p.logger.Info("ignoring acknowledgment of unknown migration",
+			zap.String("migration_id", event.MigrationID),
+			zap.String("user_id", event.UserID),
+		)
+		return nil
+	}
+
+	unlock := p.locks.lock(m.FromNodeID)
+	defer unlock()
+
+	if event.Status == events.MigrationStatusFailed {
+		p.abortMigration(ctx, m, event.Reason)
+		return nil
+	}
+	p.completeMigration(ctx, m, true)
+	return nil
+}
+
+// expireMigrations completes migrations the client has not acknowledged in
+// time. The user is moved to the new node regardless, since the previous
+// node may be about to go away; it is drained rather than returned to the
+// pool.
+func (p *Provisioner) expireMigrations(ctx context.Context) {
+	now := time.Now()
+	for _, m := range p.Migrations() {
+		if now.Before(m.Deadline) {
+			continue
+		}
+
+		p.logger.Warn("migration not acknowledged in time",
+			zap.String("migration_id", m.ID),
+			zap.String("user_id", m.UserID),
+		)
+
+		unlock := p.locks.lock(m.FromNodeID)
+		p.completeMigration(ctx, m, false)
+		unlock()
+	}
+}
+
+// migrateDrainingUsers starts migrations for users left on draining nodes,
+// so drained and rotated nodes are emptied without ending sessions. Users
+// stay put while no other node is free, and leave the node when they
+// disconnect as before.
+func (p *Provisioner) migrateDrainingUsers(ctx context.Context) {
+	if !p.config.MigrateOnDrain {
+		return
+	}
+
+	for _, n := range p.nodePool.GetDrainingNodes() {
+		if n.Status != node.NodeStatusAllocated {
+			continue
+		}
+		for _, userID := range n.Users {
+			if _, ok := p.migration(userID); ok {
+				continue
+			}
+			if nodeID, ok := p.allocator.GetAllocation(userID); !ok || nodeID != n.ID {
+				continue
+			}
+
+			_, err := p.MigrateUser(ctx, userID, events.MigrationReasonDrain)
+			if errors.Is(err, ErrNoReadyNode) {
+				p.logger.Debug("no node free to migrate user off draining node",
+					zap.String("user_id", userID),
+					zap.String("node_id", n.ID),
+				)
+				return
+			}
+			if err != nil {
+				p.logger.Error("failed to migrate user off draining node",
+					zap.String("user_id", userID),
+					zap.String("node_id", n.ID),
+					zap.Error(err),
+				)
+			}
+		}
+	}
+}
+
+// completeMigration moves the user to the migration target. The caller
+// holds the lock on the previous node.
+func (p *Provisioner) completeMigration(ctx context.Context, m Migration, acknowledged bool) {
+	if !p.takeMigration(m) {
+		return
+	}
+
+	if nodeID, ok := p.allocator.GetAllocation(m.UserID); !ok || nodeID != m.FromNodeID {
+		p.logger.Info("user left before migration completed",
+			zap.String("migration_id", m.ID),
+			zap.String("user_id", m.UserID),
+		)
+		p.allocator.AbortMigration(ctx, m.UserID, m.ToNodeID)
+		return
+	}
+	if n, ok := p.nodePool.Get(m.ToNodeID); !ok || n.Status != node.NodeStatusAllocated {
+		p.logger.Warn("migration target lost before migration completed",
+			zap.String("migration_id", m.ID),
+			zap.String("user_id", m.UserID),
+			zap.String("to_node_id", m.ToNodeID),
+		)
+		p.allocator.AbortMigration(ctx, m.UserID, m.ToNodeID)
+		return
+	}
+
+	p.allocator.CompleteMigration(ctx, m.UserID, m.FromNodeID, m.ToNodeID, acknowledged)
+
+	now := time.Now()
+	p.sessions.End(m.UserID, session.EndMigrated, now)
+	if n, ok := p.nodePool.Get(m.ToNodeID); ok {
+		p.sessions.Start(m.UserID, m.ToNodeID, n.InstanceType, now)
+	}
+	p.emitAllocation(feed.ActionMigrated, m.UserID, m.ToNodeID, m.FromNodeID)
+
+	p.logger.Info("user migration completed",
+		zap.String("migration_id", m.ID),
+		zap.String("user_id", m.UserID),
+		zap.String("from_node_id", m.FromNodeID),
+		zap.String("to_node_id", m.ToNodeID),
+		zap.Bool("acknowledged", acknowledged),
+	)
+
+	p.publishAllocation(ctx, events.ChannelAllocationResult, events.AllocationResultEvent{
+		UserID:         m.UserID,
+		NodeID:         m.ToNodeID,
+		PreviousNodeID: m.FromNodeID,
+		Status:         events.AllocationStatusMigrated,
+	})
+}
+
+// abortMigration releases the migration target; the user keeps their
+// current node
+func (p *Provisioner) abortMigration(ctx context.Context, m Migration, reason string) {
+	if !p.takeMigration(m) {
+		return
+	}
+
+	p.allocator.AbortMigration(ctx, m.UserID, m.ToNodeID)
+	p.logger.Warn("user migration aborted",
+		zap.String("migration_id", m.ID),
+		zap.String("user_id", m.UserID),
+		zap.String("to_node_id", m.ToNodeID),
+		zap.String("reason", reason),
+	)
+}
+
+// abortUserMigration aborts a user's pending migration, if any
+func (p *Provisioner) abortUserMigration(ctx context.Context, userID, reason string) {
+	if m, ok := p.migration(userID); ok {
+		p.abortMigration(ctx, m, reason)
+	}
+}
+
+// migration returns a user's pending migration
+func (p *Provisioner) migration(userID string) (Migration, bool) {
+	p.migrationsMu.Lock()
+	defer p.migrationsMu.Unlock()
+
+	m, ok := p.migrations[userID]
+	return m, ok
+}
+
+// takeMigration removes a pending migration, reporting false if it already
+// completed or was aborted
+func (p *Provisioner) takeMigration(m Migration) bool {
+	p.migrationsMu.Lock()
+	defer p.migrationsMu.Unlock()
+
+	if current, ok := p.migrations[m.UserID]; !ok || current.ID != m.ID {
+		return false
+	}
+	delete(p.migrations, m.UserID)
+	return true
+}
+
+// publishMigrate asks the user's client to move to the migration target
+func (p *Provisioner) publishMigrate(ctx context.Context, m Migration) {
+	event := events.UserMigrateEvent{
+		SchemaVersion: events.CurrentSchemaVersion,
+		MigrationID:   m.ID,
+		UserID:        m.UserID,
+		From:          events.MigrationEndpoint{NodeID: m.FromNodeID},
+		To:            events.MigrationEndpoint{NodeID: m.ToNodeID},
+		Reason:        m.Reason,
+		Deadline:      m.Deadline.Unix(),
+		Timestamp:     m.StartedAt.Unix(),
+	}
+	if n, ok := p.nodePool.Get(m.FromNodeID); ok {
+		event.From.Address = n.Endpoint.Address
+		event.From.Hostname = n.Endpoint.Hostname
+		event.From.Port = n.Endpoint.Port
+	}
+	if n, ok := p.nodePool.Get(m.ToNodeID); ok {
+		event.To.Address = n.Endpoint.Address
+		event.To.Hostname = n.Endpoint.Hostname
+		event.To.Port = n.Endpoint.Port
+		event.To.AuthToken = n.Endpoint.AuthToken
+	}
+
+	data, err := p.config.CloudEvents.Encode(events.ChannelUserMigrate, event)
+	if err != nil {
+		p.logger.Error("failed to marshal migrate event", zap.Error(err))
+		return
+	}
+
+	if err := p.publisher.Publish(p.userContext(ctx, m.UserID), events.ChannelUserMigrate, string(data)); err != nil {
+		p.logger.Error("failed to publish migrate event",
+			zap.String("migration_id", m.ID),
+			zap.String("user_id", m.UserID),
+			zap.Error(err),
+		)
+	}
+}
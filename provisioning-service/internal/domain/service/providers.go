@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/purchasing"
+	"go.uber.org/zap"
+)
+
+// NamedProvider is a node provider in the fallback chain
+type NamedProvider struct {
+	Name     string
+	Provider NodeProvider
+}
+
+// ProviderObserver is notified of capacity errors, and when a provider
+// without capacity passes a creation on to the next one
+type ProviderObserver interface {
+	ObserveCapacityError(provider, instanceType, zone string)
+	ObserveProviderFallback(provider string)
+}
+
+// provisionNodes creates count nodes of an instance type and adds them to
+// the pool as booting. Providers are tried in order. One that reports no
+// capacity is asked for the nodes it could not create in its other
+// placements, the spillover zones and instance types, and then passes them
+// on to the next. Each node records the provider that created it so it is
+// terminated there. Other provider failures are PROVIDER_UNAVAILABLE. tier
+// is that of the user the nodes are for, or empty for the pool.
+func (p *Provisioner) provisionNodes(ctx context.Context, instanceType string, labels node.Labels, tier string, count, attempt int) ([]string, error) {
+	return p.provisionNodesWith(ctx, p.providers, instanceType, labels, tier, count, attempt)
+}
+
+// provisionNodesWith is provisionNodes with the given providers in place of
+// the configured chain
+func (p *Provisioner) provisionNodesWith(ctx context.Context, providers []NamedProvider, instanceType string, labels node.Labels, tier string, count, attempt int) ([]string, error) {
+	if p.config.DryRun {
+		p.logger.Info("dry run: provisioning not applied",
+			zap.String("instance_type", instanceType),
+			zap.Stringer("labels", labels),
+			zap.String("tier", tier),
+			zap.Int("count", count),
+		)
+		return nil, ErrDryRun
+	}
+	if p.Draining() {
+		return nil, ErrDraining
+	}
+	if err := p.fence(ctx); err != nil {
+		return nil, err
+	}
+	defer p.replicateChange(ctx)
+
+	var created []string
+	var errs []error
+	for i, provider := range providers {
+		placements := p.placements(provider.Name, instanceType)
+		for j, at := range placements {
+			nodeIDs, err := p.provisionPurchased(ctx, provider, instanceType, at, labels, tier, count-len(created), attempt)
+			created = append(created, nodeIDs...)
+			if !errors.Is(err, node.ErrNoCapacity) {
+				return created, errcode.Wrap(errcode.ProviderUnavailable, err)
+			}
+
+			p.recordExhausted(provider.Name, at, err)
+			errs = append(errs, fmt.Errorf("%s: %w", provider.Name, err))
+			if j < len(placements)-1 {
+				p.logSpillover(provider.Name, at, placements[j+1], count-len(created), err)
+			}
+		}
+
+		if i == len(providers)-1 {
+			break
+		}
+		p.providerObserver.ObserveProviderFallback(provider.Name)
+		p.logger.Warn("node provider out of capacity, trying the next",
+			zap.String("provider", provider.Name),
+			zap.String("next", providers[i+1].Name),
+			zap.String("instance_type", instanceType),
+			zap.Int("remaining", count-len(created)),
+		)
+	}
+	return created, errors.Join(errs...)
+}
+
+// PurchasingSnapshot returns the achieved mix of purchase options
+func (p *Provisioner) PurchasingSnapshot() purchasing.Snapshot {
+	return p.purchasing.Snapshot()
+}
+
+// provisionPurchased creates nodes in one placement and adds them to the
+// pool of the instance type asked for. With the purchasing strategy enabled
+// they are split between the purchase options it chooses, each asked for
+// separately.
+func (p *Provisioner) provisionPurchased(ctx context.Context, provider NamedProvider, poolType string, at placement, labels node.Labels, tier string, count, attempt int) ([]string, error) {
+	plan := p.purchasing.Plan(at.instanceType, tier, count)
+	if plan == nil {
+		plan = []purchasing.Choice{{Count: count}}
+	}
+
+	var created []string
+	for _, choice := range plan {
+		nodeIDs, err := provision(ctx, provider.Provider, at.instanceType, at.zone, choice.Option, labels, choice.Count)
+		for _, nodeID := range nodeIDs {
+			p.addBootingNode(nodeID, provider.Name, poolType, at.instanceType, at.zone, choice.Option, labels, attempt)
+		}
+		p.purchasing.Launched(at.instanceType, choice, len(nodeIDs))
+		created = append(created, nodeIDs...)
+		if err != nil {
+			return created, err
+		}
+	}
+	return created, nil
+}
+
+// provision creates nodes with a single provider, using a batch request for
+// more than one
+func provision(ctx context.Context, provider NodeProvider, instanceType, zone, purchase string, labels node.Labels, count int) ([]string, error) {
+	if count > 1 {
+		return provider.ProvisionNodes(ctx, instanceType, zone, purchase, labels, count)
+	}
+	nodeID, err := provider.ProvisionNode(ctx, instanceType, zone, purchase, labels)
+	if err != nil {
+		return nil, err
+	}
+	return []string{nodeID}, nil
+}
+
+// terminate asks the provider that created a node to terminate it. A node
+// of unknown origin is offered to each provider in turn until one accepts.
+func (p *Provisioner) terminate(ctx context.Context, n *node.Node) error {
+	if err := p.fence(ctx); err != nil {
+		return err
+	}
+
+	for _, provider := range p.providers {
+		if provider.Name == n.Provider {
+			return errcode.Wrap(errcode.ProviderUnavailable, provider.Provider.TerminateNode(ctx, n.ID))
+		}
+	}
+
+	var errs []error
+	for _, provider := range p.providers {
+		err := provider.Provider.TerminateNode(ctx, n.ID)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name, err))
+	}
+	return errcode.Wrap(errcode.ProviderUnavailable, errors.Join(errs...))
+}
@@ -1,27 +1,265 @@
 package service
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/aos-cc/provisioning-service/internal/domain/access"
+	"github.com/aos-cc/provisioning-service/internal/domain/accuracy"
 	"github.com/aos-cc/provisioning-service/internal/domain/allocator"
+	"github.com/aos-cc/provisioning-service/internal/domain/budget"
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
 	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/forecast"
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/history"
+	"github.com/aos-cc/provisioning-service/internal/domain/lifecycle"
 	"github.com/aos-cc/provisioning-service/internal/domain/node"
 	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+	"github.com/aos-cc/provisioning-service/internal/domain/purchasing"
+	"github.com/aos-cc/provisioning-service/internal/domain/ratelimit"
+	"github.com/aos-cc/provisioning-service/internal/domain/replication"
+	"github.com/aos-cc/provisioning-service/internal/domain/requestid"
+	"github.com/aos-cc/provisioning-service/internal/domain/rightsizing"
+	"github.com/aos-cc/provisioning-service/internal/domain/safety"
+	"github.com/aos-cc/provisioning-service/internal/domain/session"
+	"github.com/aos-cc/provisioning-service/internal/domain/slo"
 	"github.com/aos-cc/provisioning-service/internal/domain/user"
-	"github.com/aos-cc/provisioning-service/internal/infra/nodeapi"
 	"go.uber.org/zap"
 )
 
+var (
+	// ErrNodeNotFound is returned by admin operations on unknown nodes
+	ErrNodeNotFound = errcode.New(errcode.NotFound, "node not found")
+
+	// ErrNodeTerminated is returned when terminating a node that is already
+	// terminated or being terminated
+	ErrNodeTerminated = errcode.New(errcode.InvalidTransition, "node is already terminated or terminating")
+
+	// ErrUserNotFound is returned when inspecting a user the service knows
+	// nothing of
+	ErrUserNotFound = errcode.New(errcode.NotFound, "user not found")
+
+	// ErrUserNotAllocated is returned by admin operations on users without a node
+	ErrUserNotAllocated = errcode.New(errcode.NotFound, "user has no allocated node")
+
+	// ErrNoReadyNode is returned when a user cannot be reassigned for lack of capacity
+	ErrNoReadyNode = errcode.New(errcode.NoCapacity, "no ready node available")
+
+	// ErrNodeAllocated is returned when terminating a node with a user on it
+	// without forcing
+	ErrNodeAllocated = errcode.New(errcode.InvalidTransition, "node is allocated to a user")
+
+	// ErrNotLeader is returned for changes to the pool sent to a standby replica
+	ErrNotLeader = errcode.New(errcode.NotLeader, "this replica is a standby; send changes to the leader")
+
+	// ErrDryRun is returned for nodes that would have been provisioned or
+	// terminated had the provisioner not run in dry-run mode
+	ErrDryRun = errcode.New(errcode.DryRun, "dry run: nodes are not provisioned or terminated")
+)
+
+// maxColdStartWait is how long a user without a warm node is tracked before
+// their connect attempt is considered abandoned
+const maxColdStartWait = 10 * time.Minute
+
+// NodeProvider creates and terminates nodes with the underlying
+// infrastructure. An empty zone or purchase option lets the provider choose
+// one; a provider out of capacity returns an error matching
+// node.ErrNoCapacity, preferably a *node.CapacityError naming the zone.
+type NodeProvider interface {
+	ProvisionNode(ctx context.Context, instanceType, zone, purchase string, labels map[string]string) (string, error)
+	ProvisionNodes(ctx context.Context, instanceType, zone, purchase string, labels map[string]string, count int) ([]string, error)
+	TerminateNode(ctx context.Context, nodeID string) error
+	GetNodeDiagnostics(ctx context.Context, nodeID string) (node.Diagnostics, error)
+	// NodeExists reports whether the provider still has a node; an error
+	// means it could not tell
+	NodeExists(ctx context.Context, nodeID string) (bool, error)
+}
+
+// EventPublisher publishes messages to a pub/sub channel
+type EventPublisher interface {
+	Publish(ctx context.Context, channel, message string) error
+}
+
+// Config holds tuning parameters for the provisioner
+type Config struct {
+	// CheckInterval is how often scaling decisions are evaluated
+	CheckInterval time.Duration
+
+	// DryRun makes and reports scaling decisions without provisioning or
+	// terminating any node; connects are still served from the pool
+	DryRun bool
+
+	// ScaleUpCooldown is the minimum time between predictive scale-ups
+	ScaleUpCooldown time.Duration
+
+	// ScaleDownCooldown is the minimum time after any scaling action before
+	// idle nodes may be terminated
+	ScaleDownCooldown time.Duration
+
+	// MaxTerminationsPerTick caps the healthy nodes terminated per tick as
+	// idle, surplus, incompatible or drained; the rest wait for later ticks.
+	// Zero is unlimited.
+	MaxTerminationsPerTick int
+
+	// ReservationsEnabled soft-reserves ready nodes for likely-to-connect users
+	ReservationsEnabled bool
+
+	// MaxNodeAge is how long a node may live before it is rotated; zero disables rotation
+	MaxNodeAge time.Duration
+
+	// TerminatedNodeRetention is how long terminated nodes stay in the pool
+	// before they are archived and purged
+	TerminatedNodeRetention time.Duration
+
+	// BootRetryBudget is how many nodes are tried in a row, counting the
+	// first, before a node that fails to boot is no longer replaced
+	BootRetryBudget int
+
+	// BootFailureThreshold is the number of consecutive boot failures after
+	// which provisioning backs off
+	BootFailureThreshold int
+
+	// BootFailureBackoff is the first backoff, doubled for each further failure
+	BootFailureBackoff time.Duration
+
+	// BootFailureMaxBackoff caps the backoff
+	BootFailureMaxBackoff time.Duration
+
+	// MigrationTimeout is how long a migrating user's client has to
+	// acknowledge before the previous node is released anyway
+	MigrationTimeout time.Duration
+
+	// MigrateOnDrain migrates users off draining nodes instead of waiting
+	// for them to disconnect
+	MigrateOnDrain bool
+
+	// CloudEvents controls the envelope around published events
+	CloudEvents events.CloudEvents
+
+	// LatencyTiers bound how long users of each tier wait for a node before
+	// their wait is escalated; users without a tier fall under DefaultTier
+	LatencyTiers map[string]LatencyTier
+	DefaultTier  string
+
+	// TierMembers lists the users and tenants entitled to each tier. The
+	// tier a connect asks for is ignored for anyone not listed for it, who
+	// falls under DefaultTier.
+	TierMembers map[string]TierMembers
+
+	// SessionLimit bounds the concurrent sessions of each user
+	SessionLimit SessionLimit
+
+	// IdleReclaim reclaims nodes left idle by connected users
+	IdleReclaim IdleReclaim
+
+	// RightsizingAutoSelect makes each connect prefer nodes of the instance
+	// type recommended for the user
+	RightsizingAutoSelect bool
+
+	// Spillover is where nodes are asked for when a provider has no
+	// capacity for the instance type wanted
+	Spillover Spillover
+
+	// Consistency compares the pool with the user tracker
+	Consistency Consistency
+
+	// ReplicateChanges replicates the state after every event that changes
+	// the pool and every node created, besides after each tick, for standby
+	// replicas to take over from
+	ReplicateChanges bool
+
+	// HandoffMaxAge is the oldest handoff taken over on startup, and the
+	// oldest replicated state a newly elected leader takes over; older
+	// handoffs fall back to restoring the stored users
+	HandoffMaxAge time.Duration
+}
+
 // Provisioner is the core service that orchestrates node provisioning
 type Provisioner struct {
-	nodePool      *node.NodePool
-	userTracker   *user.UserTracker
-	allocator     *allocator.NodeAllocator
-	predictor     *predictor.Predictor
-	nodeManager   *nodeapi.NodeManager
-	logger        *zap.Logger
-	checkInterval time.Duration
+	nodePool            *node.NodePool
+	userTracker         *user.UserTracker
+	userStore           user.Store
+	handoff             handoff.Store
+	cluster             replication.Cluster
+	allocator           *allocator.NodeAllocator
+	predictor           *predictor.Predictor
+	providers           []NamedProvider
+	publisher           EventPublisher
+	history             *history.History
+	forecaster          *forecast.Forecaster
+	slo                 *slo.Tracker
+	lifecycle           *lifecycle.Manager
+	sessions            *session.Recorder
+	guard               *safety.Guard
+	bootObserver        BootObserver
+	providerObserver    ProviderObserver
+	latencyObserver     LatencyObserver
+	idleObserver        IdleObserver
+	terminationObserver TerminationObserver
+	archive             NodeArchive
+	feed                *feed.Hub
+	budget              *budget.Tracker
+	access              *access.Controller
+	accuracy            *accuracy.Tracker
+	rightsizing         *rightsizing.Engine
+	purchasing          *purchasing.Strategy
+	limiter             *ratelimit.Limiter
+	locks               *nodeLocks
+	logger              *zap.Logger
+	config              Config
+
+	scalingMu sync.Mutex
+
+	mu             sync.Mutex
+	lastScaleUp    time.Time
+	lastScaleDown  time.Time
+	lastDecision   predictor.ScalingDecision
+	lastDecisionAt time.Time
+	lastCheckOK    time.Time // Last scaling check that completed without error, or mirror of the leader
+	bootTimeAvg    time.Duration
+
+	bootFailures     int // Consecutive nodes that failed to boot
+	bootBackoffUntil time.Time
+
+	drainStartedAt    time.Time // Zero unless the service is draining
+	drainNodesAtStart int
+
+	exhaustedMu sync.Mutex
+	exhausted   map[exhaustion]time.Time // When each placement out of capacity may be asked again
+
+	migrationsMu sync.Mutex
+	migrations   map[string]Migration // Pending migrations by user ID
+
+	sessionsMu      sync.Mutex
+	waitingConnects map[string]events.UserConnectEvent // Connect each waiting user waits with, by user ID
+
+	tokensMu sync.Mutex
+	tokens   map[string]string // Auth tokens by node ID, seen by a standby in status events
+
+	breachesMu     sync.Mutex
+	breaches       map[string]*latencyBreach // Users waiting past their latency budget
+	escalatedNodes map[string]string         // User ID by node provisioned for their escalation
+
+	idleMu       sync.Mutex
+	idleWarnings map[string]*idleWarning // Users warned that their idle node will be reclaimed
+
+	statusesMu sync.Mutex
+	statuses   map[string]node.NodeStatus // Status each node was last published in, by node ID
+
+	// Only used by the consistency checks
+	drifts map[drift]bool // Drifts seen by the last check, by whether they were reported
+
+	// Only used by the provisioner loop
+	leading              bool // Whether the last tick led
+	terminations         int  // Healthy nodes terminated this tick
+	deferredTerminations int  // Nodes left for a later tick by MaxTerminationsPerTick
 }
 
 // NewProvisioner creates a new provisioner service
@@ -30,18 +268,73 @@ func NewProvisioner(
 	userTracker *user.UserTracker,
 	alloc *allocator.NodeAllocator,
 	pred *predictor.Predictor,
-	nodeManager *nodeapi.NodeManager,
+	providers []NamedProvider,
+	publisher EventPublisher,
+	hist *history.History,
+	forecaster *forecast.Forecaster,
+	sloTracker *slo.Tracker,
+	lifecycleManager *lifecycle.Manager,
+	sessions *session.Recorder,
+	guard *safety.Guard,
+	bootObserver BootObserver,
+	hub *feed.Hub,
+	budgetTracker *budget.Tracker,
+	accessController *access.Controller,
+	accuracyTracker *accuracy.Tracker,
+	rightsizer *rightsizing.Engine,
+	purchaser *purchasing.Strategy,
+	limiter *ratelimit.Limiter,
+	providerObserver ProviderObserver,
+	latencyObserver LatencyObserver,
+	idleObserver IdleObserver,
+	terminationObserver TerminationObserver,
+	archive NodeArchive,
+	userStore user.Store,
+	handoffStore handoff.Store,
+	cluster replication.Cluster,
 	logger *zap.Logger,
-	checkInterval time.Duration,
+	config Config,
 ) *Provisioner {
 	return &Provisioner{
-		nodePool:      nodePool,
-		userTracker:   userTracker,
-		allocator:     alloc,
-		predictor:     pred,
-		nodeManager:   nodeManager,
-		logger:        logger,
-		checkInterval: checkInterval,
+		nodePool:            nodePool,
+		userTracker:         userTracker,
+		userStore:           userStore,
+		handoff:             handoffStore,
+		cluster:             cluster,
+		allocator:           alloc,
+		predictor:           pred,
+		providers:           providers,
+		publisher:           publisher,
+		history:             hist,
+		forecaster:          forecaster,
+		slo:                 sloTracker,
+		lifecycle:           lifecycleManager,
+		sessions:            sessions,
+		guard:               guard,
+		bootObserver:        bootObserver,
+		providerObserver:    providerObserver,
+		latencyObserver:     latencyObserver,
+		idleObserver:        idleObserver,
+		terminationObserver: terminationObserver,
+		archive:             archive,
+		feed:                hub,
+		budget:              budgetTracker,
+		access:              accessController,
+		accuracy:            accuracyTracker,
+		rightsizing:         rightsizer,
+		purchasing:          purchaser,
+		limiter:             limiter,
+		locks:               newNodeLocks(),
+		migrations:          make(map[string]Migration),
+		tokens:              make(map[string]string),
+		waitingConnects:     make(map[string]events.UserConnectEvent),
+		breaches:            make(map[string]*latencyBreach),
+		escalatedNodes:      make(map[string]string),
+		idleWarnings:        make(map[string]*idleWarning),
+		statuses:            make(map[string]node.NodeStatus),
+		exhausted:           make(map[exhaustion]time.Time),
+		logger:              logger,
+		config:              config,
 	}
 }
 
@@ -49,7 +342,7 @@ func NewProvisioner(
 func (p *Provisioner) Start(ctx context.Context) error {
 	p.logger.Info("provisioner service started")
 
-	ticker := time.NewTicker(p.checkInterval)
+	ticker := time.NewTicker(p.config.CheckInterval)
 	defer ticker.Stop()
 
 	for {
@@ -58,80 +351,556 @@ func (p *Provisioner) Start(ctx context.Context) error {
 			p.logger.Info("provisioner service stopping")
 			return ctx.Err()
 		case <-ticker.C:
-			p.performScalingCheck(ctx)
-			p.cleanupIdleNodes(ctx)
-			p.cleanupStuckNodes(ctx)
+			// Let an in-progress tick finish its Node API calls on shutdown
+			opCtx := context.WithoutCancel(ctx)
+			leader := p.lead(opCtx)
+			p.recordHistory()
+			if !leader {
+				continue
+			}
+			p.resetTerminations()
+			p.allocator.RenewClaims(opCtx)
+			p.recordPredictions()
+			p.slo.ExpirePending(maxColdStartWait)
+			p.rotateAgedNodes()
+			if p.Draining() {
+				p.drainPool()
+			}
+			p.holdDedicatedNodes(opCtx)
+			p.performScalingCheck(opCtx)
+			p.enforceLatencyBudgets(opCtx)
+			p.reserveNodes(opCtx)
+			p.retireSurplusNodes(opCtx)
+			p.retireOverBudget(opCtx)
+			p.settleBurstNodes()
+			p.cleanupIdleNodes(opCtx)
+			p.reclaimIdleNodes(opCtx)
+			p.cleanupStuckNodes(opCtx)
+			p.recycleIncompatibleNodes(opCtx)
+			p.expireReservations(opCtx)
+			p.expireMigrations(opCtx)
+			p.migrateDrainingUsers(opCtx)
+			p.drainNodes(opCtx)
+			p.purgeTerminatedNodes(opCtx)
+			p.followTransitions()
+			p.saveUsers(opCtx)
+			p.replicate(opCtx)
 		}
 	}
 }
 
-func (p *Provisioner) performScalingCheck(ctx context.Context) {
-	decision := p.predictor.CalculateScaling()
+// recordHistory snapshots pool state for the metrics history and the demand forecast
+func (p *Provisioner) recordHistory() {
+	now := time.Now()
+	connected := len(p.userTracker.GetConnectedUsers())
 
-	if decision.ShouldScaleUp {
-		p.logger.Info("scaling up nodes",
-			zap.Int("target_nodes", decision.TargetNodes),
-			zap.String("reason", decision.Reason),
-		)
+	p.history.Record(history.Sample{
+		Timestamp:      now,
+		Booting:        p.nodePool.CountByStatus(node.NodeStatusBooting),
+		Ready:          p.nodePool.CountByStatus(node.NodeStatusReady),
+		Allocated:      p.nodePool.CountOccupiedWhere(nil),
+		Terminated:     p.nodePool.CountByStatus(node.NodeStatusTerminated),
+		Demand:         len(p.predictor.LikelyToConnect()),
+		ConnectedUsers: connected,
+	})
+	p.forecaster.ObserveConcurrent(now, connected)
+}
+
+// recordPredictions opens predictions for users likely to connect so they
+// can be checked against actual connects
+func (p *Provisioner) recordPredictions() {
+	likely := p.predictor.LikelyToConnect()
+	userIDs := make([]string, 0, len(likely))
+	for _, u := range likely {
+		userIDs = append(userIDs, u.UserID)
+	}
+	p.accuracy.Predicted(userIDs, time.Now())
+}
+
+// ScalingCheckResult describes the outcome of a scaling evaluation
+type ScalingCheckResult struct {
+	Decision      predictor.ScalingDecision
+	DryRun        bool  // Requested as a dry run, or the provisioner runs in dry-run mode
+	Deferred      bool  // Scale-up skipped because of the scale-up cooldown
+	Provisioned   int   // Nodes created with the Node API
+	BudgetBlocked int   // Nodes not provisioned because of the spend limits
+	Err           error // Provisioning error, if any
+}
+
+// CheckScaling evaluates scaling immediately on operator request. A dry run
+// returns the decision without acting on it or recording it.
+func (p *Provisioner) CheckScaling(ctx context.Context, dryRun bool) ScalingCheckResult {
+	if dryRun {
+		decision := p.calculateScaling()
+		return ScalingCheckResult{
+			Decision: decision,
+			DryRun:   true,
+			Deferred: decision.ShouldScaleUp && p.CooldownState().ScaleUpRemaining > 0,
+		}
+	}
+
+	p.logger.Info("scaling check requested by operator",
+		zap.String("request_id", requestid.From(ctx)),
+	)
+	return p.performScalingCheck(ctx)
+}
+
+// calculateScaling adds users waiting for nodes with particular labels to
+// the predicted demand
+func (p *Provisioner) calculateScaling() predictor.ScalingDecision {
+	return p.predictor.AddQueuedDemand(p.predictor.CalculateScaling(), p.slo.Waiting())
+}
+
+func (p *Provisioner) performScalingCheck(ctx context.Context) ScalingCheckResult {
+	// Operator-triggered checks must not race the ticker into provisioning twice
+	p.scalingMu.Lock()
+	defer p.scalingMu.Unlock()
+
+	p.budget.Accrue(time.Now())
+
+	decision := p.calculateScaling()
+	result := ScalingCheckResult{Decision: decision, DryRun: p.config.DryRun}
+	defer func() { p.emitDecision(result) }()
+
+	p.mu.Lock()
+	p.lastDecision = decision
+	p.lastDecisionAt = time.Now()
+	p.mu.Unlock()
+
+	if decision.ShouldScaleUp && p.config.DryRun {
+		for _, up := range decision.ScaleUps() {
+			p.logger.Info("dry run: scale-up not applied",
+				zap.String("instance_type", up.InstanceType),
+				zap.Stringer("labels", up.Labels),
+				zap.Int("target_nodes", up.TargetNodes),
+				zap.String("reason", up.Reason),
+			)
+		}
+	} else if decision.ShouldScaleUp {
+		if p.Draining() {
+			p.logger.Debug("scale-up skipped while draining",
+				zap.Int("target_nodes", decision.TargetNodes),
+				zap.String("reason", decision.Reason),
+			)
+			result.Deferred = true
+			p.recordCheckOK()
+			return result
+		}
+		if left := p.CooldownState().ScaleUpRemaining; left > 0 {
+			p.logger.Info("scale-up deferred",
+				zap.Int("target_nodes", decision.TargetNodes),
+				zap.String("reason", cooldownReason(decision.Reason, "scale-up", left)),
+			)
+			result.Deferred = true
+			p.recordCheckOK()
+			return result
+		}
+		if left := p.BootFailureState().BackoffRemaining; left > 0 {
+			p.logger.Warn("scale-up deferred",
+				zap.Int("target_nodes", decision.TargetNodes),
+				zap.String("reason", cooldownReason(decision.Reason, "boot failure backoff", left)),
+			)
+			result.Deferred = true
+			p.recordCheckOK()
+			return result
+		}
 
-		for i := 0; i < decision.TargetNodes; i++ {
-			if err := p.provisionNode(ctx); err != nil {
-				p.logger.Error("failed to provision node", zap.Error(err))
+		var errs []error
+		for _, up := range decision.ScaleUps() {
+			count := p.budgetAllows(ctx, up.InstanceType, up.TargetNodes)
+			result.BudgetBlocked += up.TargetNodes - count
+			if count == 0 {
+				continue
+			}
+
+			// Nodes the budget holds back are taken from the burst first,
+			// as are nodes the providers fail to create
+			burst := max(up.BurstNodes-(up.TargetNodes-count), 0)
+
+			p.logger.Info("scaling up nodes",
+				zap.String("instance_type", up.InstanceType),
+				zap.Stringer("labels", up.Labels),
+				zap.Int("target_nodes", count),
+				zap.Int("burst_nodes", burst),
+				zap.String("reason", up.Reason),
+			)
+
+			nodeIDs, err := p.provisionNodes(ctx, up.InstanceType, up.Labels, "", count, 1)
+			result.Provisioned += len(nodeIDs)
+			burst = max(burst-(count-len(nodeIDs)), 0)
+			for _, nodeID := range nodeIDs[len(nodeIDs)-burst:] {
+				p.nodePool.MarkBurst(nodeID)
+			}
+			if err != nil {
+				p.logger.Error("failed to provision nodes",
+					zap.String("instance_type", up.InstanceType),
+					zap.Int("requested", count),
+					zap.Int("provisioned", len(nodeIDs)),
+					zap.String("code", string(errcode.Of(err))),
+					zap.Error(err),
+				)
+				errs = append(errs, err)
 			}
 		}
+		result.Err = errors.Join(errs...)
+	}
+
+	if result.Err == nil {
+		p.recordCheckOK()
 	}
 
 	if decision.ShouldScaleDown {
+		reason := decision.Reason
+		if left := p.CooldownState().ScaleDownRemaining; left > 0 {
+			reason = cooldownReason(reason, "scale-down", left)
+		}
 		p.logger.Info("scaling down consideration",
 			zap.Int("target_nodes", decision.TargetNodes),
-			zap.String("reason", decision.Reason),
+			zap.String("reason", reason),
 		)
-		// Scale down is handled by idle cleanup
+		// Scale down is handled by surplus retirement and idle cleanup
 	}
+
+	return result
 }
 
-func (p *Provisioner) provisionNode(ctx context.Context) error {
-	nodeID, err := p.nodeManager.ProvisionNode(ctx)
+// reserveNodes soft-reserves ready nodes for users predicted to connect so
+// their eventual connect is guaranteed a warm node
+func (p *Provisioner) reserveNodes(ctx context.Context) {
+	if !p.config.ReservationsEnabled {
+		return
+	}
+
+	until := time.Now().Add(p.reservationTTL())
+	for _, u := range p.predictor.LikelyToConnect() {
+		nodeID, created, err := p.allocator.ReserveNodeForUser(u.UserID, until)
+		if err != nil {
+			p.logger.Debug("no ready node left to reserve",
+				zap.String("user_id", u.UserID),
+			)
+			return
+		}
+
+		p.logger.Debug("node reserved for likely user",
+			zap.String("user_id", u.UserID),
+			zap.String("node_id", nodeID),
+			zap.Time("until", until),
+		)
+
+		if created {
+			p.notifyNodeReady(ctx, u.UserID, nodeID, until, events.NodeReadyReasonPredicted)
+		}
+	}
+}
+
+// reservationTTL is how long a soft reservation is held: the prediction
+// window in effect, including any runtime override
+func (p *Provisioner) reservationTTL() time.Duration {
+	return p.predictor.Config().PredictionWindow
+}
+
+// offerToWaitingUser reserves a node that just became ready for the
+// longest-waiting user without one and tells them it is ready
+func (p *Provisioner) offerToWaitingUser(ctx context.Context, nodeID string) {
+	n, ok := p.nodePool.Get(nodeID)
+	if !ok {
+		return
+	}
+
+	// A node provisioned to escalate a user's wait goes to that user first
+	waiting := p.slo.Waiting()
+	if userID, ok := p.takeEscalatedNode(nodeID); ok {
+		waiting = append([]string{userID}, waiting...)
+	}
+
+	until := time.Now().Add(p.reservationTTL())
+	for _, userID := range waiting {
+		if p.nodePool.HasReservation(userID) || !n.Labels.Matches(p.userTracker.SelectorOf(userID)) {
+			continue
+		}
+		if !p.nodePool.ReserveNode(nodeID, userID, until) {
+			return
+		}
+
+		p.logger.Info("node reserved for waiting user",
+			zap.String("user_id", userID),
+			zap.String("node_id", nodeID),
+		)
+		p.notifyNodeReady(ctx, userID, nodeID, until, events.NodeReadyReasonQueued)
+		return
+	}
+}
+
+// notifyNodeReady tells a user that a node is being held for them
+func (p *Provisioner) notifyNodeReady(ctx context.Context, userID, nodeID string, until time.Time, reason string) {
+	n, ok := p.nodePool.Get(nodeID)
+	if !ok {
+		return
+	}
+
+	data, err := p.config.CloudEvents.Encode(events.ChannelNodeReady, events.NodeReadyEvent{
+		SchemaVersion: events.CurrentSchemaVersion,
+		UserID:        userID,
+		NodeID:        nodeID,
+		Address:       n.Endpoint.Address,
+		Hostname:      n.Endpoint.Hostname,
+		Port:          n.Endpoint.Port,
+		AuthToken:     n.Endpoint.AuthToken,
+		Reason:        reason,
+		ReservedUntil: until.Unix(),
+		Timestamp:     time.Now().Unix(),
+	})
 	if err != nil {
-		return err
+		p.logger.Error("failed to marshal node ready event", zap.Error(err))
+		return
+	}
+
+	if err := p.publisher.Publish(p.userContext(ctx, userID), events.ChannelNodeReady, string(data)); err != nil {
+		p.logger.Error("failed to publish node ready event",
+			zap.String("user_id", userID),
+			zap.String("node_id", nodeID),
+			zap.Error(err),
+		)
+	}
+}
+
+// provisionNode provisions a single node for a user who found none ready:
+// of the instance type preferred for them if it has a pool, the default
+// one otherwise, with the labels they asked for, unless the spend limits
+// block it
+func (p *Provisioner) provisionNode(ctx context.Context, userID string, labels node.Labels, tier string) error {
+	instanceType := cmp.Or(p.preferredPool(userID), p.predictor.Config().DefaultInstanceType)
+	if p.budgetAllows(ctx, instanceType, 1) == 0 {
+		return ErrBudgetExceeded
+	}
+
+	_, err := p.provisionNodes(ctx, instanceType, labels, tier, 1, 1)
+	return err
+}
+
+// addBootingNode records a freshly provisioned node in the pool. Until the
+// node reports its own labels it is assumed to have the ones requested. A
+// node of another instance type than poolType's spilled over, and is
+// counted in poolType's pool.
+func (p *Provisioner) addBootingNode(nodeID, provider, poolType, instanceType, zone, purchase string, labels node.Labels, attempt int) {
+	if poolType == instanceType {
+		poolType = ""
 	}
 
 	// Add node to pool with booting status
 	n := &node.Node{
-		ID:        nodeID,
-		Status:    node.NodeStatusBooting,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		ID:           nodeID,
+		Status:       node.NodeStatusBooting,
+		InstanceType: instanceType,
+		PoolType:     poolType,
+		Labels:       maps.Clone(labels),
+		Provider:     provider,
+		Zone:         zone,
+		Purchase:     purchase,
+		BootAttempt:  attempt,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
 	}
+	n.Capacity = p.predictor.Capacity(n)
 	p.nodePool.Add(n)
+	p.recordScaleUp()
+	p.emitTransition(n, "", node.NodeStatusBooting, "provisioned")
 
 	p.logger.Info("node added to pool",
 		zap.String("node_id", nodeID),
+		zap.String("provider", provider),
+		zap.String("instance_type", instanceType),
+		zap.String("zone", zone),
+		zap.String("purchase", purchase),
 		zap.String("status", string(node.NodeStatusBooting)),
 	)
+}
 
-	return nil
+// terminateNode terminates a node for a reason if it is still in one of the
+// given statuses, returning false if it moved on since it was selected. The node is claimed as
+// terminating first so it cannot be allocated while the Node API call is in
+// flight, and restored if termination fails. Pre-terminate hook failures are
+// logged but never keep a node alive. Unless forced, a node with a user on it
+// is never terminated; finding one outside the allocated status is an
+// invariant violation.
+func (p *Provisioner) terminateNode(ctx context.Context, nodeID string, reason node.TerminationReason, force bool, from ...node.NodeStatus) (bool, error) {
+	if p.config.DryRun {
+		p.logger.Info("dry run: termination not applied",
+			zap.String("node_id", nodeID),
+			zap.String("reason", string(reason)),
+		)
+		return false, ErrDryRun
+	}
+
+	unlock := p.locks.lock(nodeID)
+	defer unlock()
+
+	n, ok := p.nodePool.Get(nodeID)
+	if !ok {
+		return false, ErrNodeNotFound
+	}
+
+	var prev node.NodeStatus
+	for _, status := range from {
+		if p.nodePool.UpdateStatusIf(nodeID, status, node.NodeStatusTerminating) {
+			prev = status
+			break
+		}
+	}
+	if prev == "" {
+		return false, nil
+	}
+
+	p.emitTransition(n, prev, node.NodeStatusTerminating, string(reason))
+
+	userID, inUse := p.guard.InUse(n)
+	if inUse && !force {
+		p.nodePool.UpdateStatusIf(nodeID, node.NodeStatusTerminating, prev)
+		p.emitTransition(n, node.NodeStatusTerminating, prev, "node in use")
+		if prev != node.NodeStatusAllocated && prev != node.NodeStatusUnconfirmed {
+			p.guard.Violation(safety.CheckTerminateInUse, n, userID)
+		}
+		return false, ErrNodeAllocated
+	}
+
+	if err := p.lifecycle.Run(ctx, lifecycle.StagePreTerminate, hookTarget(n, userID)); err != nil {
+		p.logger.Warn("pre-terminate hook failed",
+			zap.String("node_id", nodeID),
+			zap.Error(err),
+		)
+	}
+
+	if err := p.terminate(ctx, n); err != nil {
+		p.nodePool.UpdateStatusIf(nodeID, node.NodeStatusTerminating, prev)
+		p.emitTransition(n, node.NodeStatusTerminating, prev, "termination failed")
+		return false, err
+	}
+
+	p.nodePool.MarkTerminated(nodeID, reason)
+	p.allocator.ForgetNode(ctx, nodeID)
+	p.recordTermination(ctx, n, prev, reason)
+	return true, nil
+}
+
+// runPreReadyHooks promotes a booted node to ready once its pre-ready hooks
+// pass, and terminates it if they fail
+func (p *Provisioner) runPreReadyHooks(ctx context.Context, nodeID string) {
+	defer p.lifecycle.EndPreReady(nodeID)
+
+	n, ok := p.nodePool.Get(nodeID)
+	if !ok {
+		return
+	}
+
+	if err := p.lifecycle.Run(ctx, lifecycle.StagePreReady, hookTarget(n, "")); err != nil {
+		p.logger.Warn("node failed pre-ready checks",
+			zap.String("node_id", nodeID),
+			zap.Error(err),
+		)
+
+		diag := p.collectDiagnostics(ctx, n)
+		terminated, err := p.terminateNode(ctx, nodeID, node.TerminationFailedChecks, false, node.NodeStatusBooting)
+		if errors.Is(err, ErrDryRun) {
+			return
+		}
+		if err != nil {
+			p.logger.Error("failed to terminate node after pre-ready failure",
+				zap.String("node_id", nodeID),
+				zap.Error(err),
+			)
+			return
+		}
+		if terminated {
+			p.handleBootFailure(ctx, n, "failed pre-ready checks", diag)
+		}
+		return
+	}
+
+	// The node may have been terminated as stuck while the hooks ran
+	if p.nodePool.UpdateStatusIf(nodeID, node.NodeStatusBooting, node.NodeStatusReady) {
+		p.recordBootTime(n, time.Since(n.CreatedAt))
+		p.logger.Info("node passed pre-ready checks", zap.String("node_id", nodeID))
+		p.emitTransition(n, node.NodeStatusBooting, node.NodeStatusReady, "passed pre-ready checks")
+		p.offerToWaitingUser(ctx, nodeID)
+	}
+}
+
+// hookTarget describes a node and the user the hook concerns to lifecycle hooks
+func hookTarget(n *node.Node, userID string) lifecycle.Target {
+	return lifecycle.Target{
+		NodeID:   n.ID,
+		UserID:   userID,
+		Address:  n.Endpoint.Address,
+		Hostname: n.Endpoint.Hostname,
+		Port:     n.Endpoint.Port,
+	}
+}
+
+// settleBurstNodes clears the burst flag of nodes whose pool has shrunk back
+// toward max ready nodes, so they keep the pool's idle timeout from then on
+func (p *Provisioner) settleBurstNodes() {
+	for _, n := range p.predictor.SettledBurstNodes() {
+		p.nodePool.ClearBurst(n.ID)
+		p.logger.Debug("burst node settled into its pool",
+			zap.String("node_id", n.ID),
+			zap.String("instance_type", n.InstanceType),
+		)
+	}
 }
 
 func (p *Provisioner) cleanupIdleNodes(ctx context.Context) {
-	idleNodes := p.predictor.GetIdleNodes()
+	if left := p.CooldownState().ScaleDownRemaining; left > 0 {
+		p.logger.Debug("idle cleanup deferred by scale-down cooldown",
+			zap.Duration("remaining", left),
+		)
+		return
+	}
+
+	idleNodes, skipped := p.predictor.SimulateTermination(p.predictor.GetIdleNodes())
+
+	for _, s := range skipped {
+		p.logger.Info("skipping idle node termination",
+			zap.String("node_id", s.Node.ID),
+			zap.String("reason", s.Reason),
+		)
+	}
 
 	for _, n := range idleNodes {
+		reason := node.TerminationIdle
+		if n.Burst {
+			reason = node.TerminationBurstIdle
+		}
+		if p.terminationLimited() {
+			p.deferTermination(n, reason)
+			continue
+		}
+
 		p.logger.Info("terminating idle node",
 			zap.String("node_id", n.ID),
+			zap.Bool("burst", n.Burst),
 			zap.Duration("idle_duration", time.Since(n.UpdatedAt)),
 		)
 
-		if err := p.nodeManager.TerminateNode(ctx, n.ID); err != nil {
+		terminated, err := p.terminateNode(ctx, n.ID, reason, false, node.NodeStatusReady)
+		if errors.Is(err, ErrDryRun) {
+			continue
+		}
+		if err != nil {
 			p.logger.Error("failed to terminate idle node",
 				zap.String("node_id", n.ID),
 				zap.Error(err),
 			)
 			continue
 		}
+		if !terminated {
+			p.logger.Info("idle node was claimed before termination",
+				zap.String("node_id", n.ID),
+			)
+			continue
+		}
 
-		// Update status to terminated
-		p.nodePool.UpdateStatus(n.ID, node.NodeStatusTerminated)
+		p.terminations++
+		p.recordScaleDown()
 	}
 }
 
@@ -144,89 +913,708 @@ func (p *Provisioner) cleanupStuckNodes(ctx context.Context) {
 			zap.Duration("booting_duration", time.Since(n.CreatedAt)),
 		)
 
-		if err := p.nodeManager.TerminateNode(ctx, n.ID); err != nil {
+		diag := p.collectDiagnostics(ctx, n)
+		terminated, err := p.terminateNode(ctx, n.ID, node.TerminationStuck, false, node.NodeStatusBooting)
+		if errors.Is(err, ErrDryRun) {
+			continue
+		}
+		if err != nil {
 			p.logger.Error("failed to terminate stuck node",
 				zap.String("node_id", n.ID),
 				zap.Error(err),
 			)
 			continue
 		}
+		if !terminated {
+			continue
+		}
 
-		// Remove from pool
-		p.nodePool.Remove(n.ID)
+		// Kept in the pool as terminated, with why, until purged
+		p.handleBootFailure(ctx, n, "stuck booting", diag)
 	}
 }
 
+func (p *Provisioner) recycleIncompatibleNodes(ctx context.Context) {
+	for _, n := range p.nodePool.GetIncompatibleNodes() {
+		// Allocated nodes are recycled once their user disconnects
+		if n.Status != node.NodeStatusReady {
+			continue
+		}
+		if p.terminationLimited() {
+			p.deferTermination(n, node.TerminationIncompatible)
+			continue
+		}
+
+		p.logger.Warn("recycling node with incompatible agent version",
+			zap.String("node_id", n.ID),
+			zap.String("agent_version", n.AgentVersion),
+		)
+
+		terminated, err := p.terminateNode(ctx, n.ID, node.TerminationIncompatible, false, node.NodeStatusReady)
+		if errors.Is(err, ErrDryRun) {
+			continue
+		}
+		if err != nil {
+			p.logger.Error("failed to terminate incompatible node",
+				zap.String("node_id", n.ID),
+				zap.Error(err),
+			)
+			continue
+		}
+		if terminated {
+			p.terminations++
+		}
+	}
+}
+
+// drainNodes terminates draining nodes once their last user has disconnected
+func (p *Provisioner) drainNodes(ctx context.Context) {
+	for _, n := range p.nodePool.GetDrainingNodes() {
+		if n.Occupied() {
+			p.logger.Debug("waiting for users to leave draining node",
+				zap.String("node_id", n.ID),
+				zap.Strings("user_ids", n.Users),
+			)
+			continue
+		}
+
+		// Terminated for the reason it was drained for
+		reason := n.TerminationReason
+		if p.terminationLimited() {
+			p.deferTermination(n, reason)
+			continue
+		}
+
+		p.logger.Info("terminating drained node",
+			zap.String("node_id", n.ID),
+			zap.String("reason", string(reason)),
+		)
+
+		terminated, err := p.terminateNode(ctx, n.ID, reason, false, node.NodeStatusReady, node.NodeStatusBooting)
+		if errors.Is(err, ErrDryRun) {
+			continue
+		}
+		if err != nil {
+			p.logger.Error("failed to terminate drained node",
+				zap.String("node_id", n.ID),
+				zap.Error(err),
+			)
+			continue
+		}
+		if terminated {
+			p.terminations++
+		}
+	}
+}
+
+func (p *Provisioner) recordCheckOK() {
+	p.mu.Lock()
+	p.lastCheckOK = time.Now()
+	p.mu.Unlock()
+}
+
+// LastSuccessfulCheck returns when a scaling check last completed without error
+func (p *Provisioner) LastSuccessfulCheck() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastCheckOK
+}
+
+// LastDecision returns the most recent scaling decision and when it was made
+func (p *Provisioner) LastDecision() (predictor.ScalingDecision, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastDecision, p.lastDecisionAt
+}
+
+// DemandForecast returns the forecast for the end of the prediction window
+func (p *Provisioner) DemandForecast() forecast.Estimate {
+	return p.forecaster.Forecast(time.Now().Add(p.predictor.DemandWindow()))
+}
+
+// MetricsHistory returns pool samples recorded after the given time, oldest first
+func (p *Provisioner) MetricsHistory(since time.Time) []history.Sample {
+	return p.history.Since(since)
+}
+
+// PredictUser explains whether a user is predicted to connect
+func (p *Provisioner) PredictUser(userID string) predictor.UserPrediction {
+	return p.predictor.PredictUser(userID)
+}
+
+// SessionHistory returns a user's billed sessions recently ended, oldest
+// first, followed by the one still open, if any
+func (p *Provisioner) SessionHistory(userID string) []session.Record {
+	return p.sessions.Sessions(userID)
+}
+
+// WaitingSince returns when a user still waiting for a node first failed
+// to find a warm one
+func (p *Provisioner) WaitingSince(userID string) (time.Time, bool) {
+	since, ok := p.slo.Pending()[userID]
+	return since, ok
+}
+
+// SLOSnapshot returns rolling cold-start SLO compliance
+func (p *Provisioner) SLOSnapshot() slo.Snapshot {
+	return p.slo.Snapshot()
+}
+
+// PredictionAccuracy returns rolling precision and recall of connect predictions
+func (p *Provisioner) PredictionAccuracy() accuracy.Snapshot {
+	return p.accuracy.Snapshot()
+}
+
+// RecentPredictions returns up to limit resolved predictions, newest first
+func (p *Provisioner) RecentPredictions(limit int) []accuracy.Record {
+	return p.accuracy.Recent(limit)
+}
+
+// InvariantViolations returns safety invariant violations per check
+func (p *Provisioner) InvariantViolations() map[string]int64 {
+	return p.guard.Violations()
+}
+
+// SetReadyNodeLimits updates the pool size limits used by the predictor,
+// replicating them with the other prediction overrides
+func (p *Provisioner) SetReadyNodeLimits(ctx context.Context, minReady, maxReady int) error {
+	if err := p.predictor.SetReadyNodeLimits(minReady, maxReady); err != nil {
+		return err
+	}
+	p.logger.Info("ready node limits updated",
+		zap.Int("min_ready_nodes", minReady),
+		zap.Int("max_ready_nodes", maxReady),
+	)
+	p.replicateChange(ctx)
+	return nil
+}
+
+// ReadyNodeLimits returns the pool size limits currently used by the predictor
+func (p *Provisioner) ReadyNodeLimits() (int, int) {
+	return p.predictor.ReadyNodeLimits()
+}
+
+// PredictionTuning returns the prediction parameters currently used by the
+// predictor
+func (p *Provisioner) PredictionTuning() predictor.Tuning {
+	return p.predictor.Tuning()
+}
+
+// UpdatePredictionTuning changes prediction parameters until the next change
+// or deploy. The change is replicated, so a replica taking over keeps it.
+func (p *Provisioner) UpdatePredictionTuning(ctx context.Context, change func(t *predictor.Tuning)) (predictor.Tuning, error) {
+	tuning, err := p.predictor.UpdateTuning(change)
+	if err != nil {
+		return predictor.Tuning{}, err
+	}
+	p.logger.Info("prediction parameters updated",
+		zap.Duration("activity_window", tuning.ActivityWindow),
+		zap.Int("activity_threshold", tuning.ActivityThreshold),
+		zap.Duration("prediction_window", tuning.PredictionWindow),
+		zap.Float64("target_headroom", tuning.TargetHeadroom),
+		zap.Int("min_ready_nodes", tuning.MinReadyNodes),
+		zap.Int("max_ready_nodes", tuning.MaxReadyNodes),
+		zap.Int("burst_max_nodes", tuning.BurstMaxNodes),
+		zap.Duration("idle_termination_timeout", tuning.IdleTerminationTimeout),
+		zap.Float64("surplus_margin", tuning.SurplusMargin),
+	)
+	p.replicateChange(ctx)
+	return tuning, nil
+}
+
+// tuningOverrides returns the prediction parameters changed at runtime, or
+// nil if none were
+func (p *Provisioner) tuningOverrides() *predictor.Tuning {
+	tuning, ok := p.predictor.Overrides()
+	if !ok {
+		return nil
+	}
+	return &tuning
+}
+
+// restoreTuning applies the prediction parameters a previous leader was
+// changed to; without any, the ones in effect are kept
+func (p *Provisioner) restoreTuning(tuning *predictor.Tuning) {
+	if tuning == nil {
+		return
+	}
+	if _, err := p.predictor.UpdateTuning(func(t *predictor.Tuning) { *t = *tuning }); err != nil {
+		p.logger.Warn("prediction overrides not restored", zap.Error(err))
+	}
+}
+
+// TerminateNode terminates a node on operator request. A node with users on
+// it is refused with ErrNodeAllocated unless forced, in which case the users
+// are released.
+func (p *Provisioner) TerminateNode(ctx context.Context, nodeID string, force bool) error {
+	n, ok := p.nodePool.Get(nodeID)
+	if !ok {
+		return ErrNodeNotFound
+	}
+
+	p.logger.Info("terminating node on operator request",
+		zap.String("request_id", requestid.From(ctx)),
+		zap.String("node_id", nodeID),
+		zap.String("status", string(n.Status)),
+		zap.Bool("force", force),
+	)
+
+	users := p.userTracker.UsersOnNode(nodeID)
+	terminated, err := p.terminateNode(ctx, nodeID, node.TerminationAdmin, force,
+		node.NodeStatusBooting, node.NodeStatusReady, node.NodeStatusAllocated, node.NodeStatusUnconfirmed)
+	if err != nil {
+		return err
+	}
+	if !terminated {
+		return ErrNodeTerminated
+	}
+
+	for _, userID := range users {
+		p.userTracker.MarkDisconnected(userID)
+		p.sessions.End(userID, session.EndTerminated, time.Now())
+	}
+
+	return nil
+}
+
+// CordonNode excludes a node from new allocations
+func (p *Provisioner) CordonNode(nodeID string) error {
+	if !p.nodePool.SetCordoned(nodeID, true) {
+		return ErrNodeNotFound
+	}
+	p.logger.Info("node cordoned", zap.String("node_id", nodeID))
+	return nil
+}
+
+// UncordonNode makes a node available for allocation again and cancels any drain
+func (p *Provisioner) UncordonNode(nodeID string) error {
+	if !p.nodePool.SetCordoned(nodeID, false) {
+		return ErrNodeNotFound
+	}
+	p.logger.Info("node uncordoned", zap.String("node_id", nodeID))
+	return nil
+}
+
+// DrainNode cordons a node and terminates it once its current user disconnects
+func (p *Provisioner) DrainNode(nodeID string) error {
+	if !p.nodePool.MarkDraining(nodeID, node.TerminationAdmin) {
+		return ErrNodeNotFound
+	}
+	p.logger.Info("node draining", zap.String("node_id", nodeID))
+	return nil
+}
+
+// DeallocateUser tears down a user's allocation on operator request and
+// notifies subscribers of the allocation result channel
+func (p *Provisioner) DeallocateUser(ctx context.Context, userID string) (string, error) {
+	p.abortUserMigration(ctx, userID, "deallocated by operator")
+
+	if current, ok := p.allocator.GetAllocation(userID); ok {
+		unlock := p.locks.lock(current)
+		defer unlock()
+	}
+
+	nodeID, err := p.allocator.ForceDeallocate(ctx, userID, node.TerminationAdmin)
+	if errors.Is(err, allocator.ErrUserNotFound) {
+		return "", ErrUserNotAllocated
+	}
+	if err != nil {
+		return "", err
+	}
+
+	p.sessions.End(userID, session.EndDeallocated, time.Now())
+	p.emitAllocation(feed.ActionDeallocated, userID, nodeID, "")
+
+	p.logger.Info("user deallocated on operator request",
+		zap.String("request_id", requestid.From(ctx)),
+		zap.String("user_id", userID),
+		zap.String("node_id", nodeID),
+	)
+
+	p.publishAllocation(ctx, events.ChannelAllocationResult, events.AllocationResultEvent{
+		UserID:         userID,
+		PreviousNodeID: nodeID,
+		Status:         events.AllocationStatusDeallocated,
+	})
+
+	return nodeID, nil
+}
+
+// ReassignUser moves a user to another ready node on operator request and
+// notifies subscribers with the new node's connection details
+func (p *Provisioner) ReassignUser(ctx context.Context, userID string) (string, string, error) {
+	p.abortUserMigration(ctx, userID, "reassigned by operator")
+
+	if current, ok := p.allocator.GetAllocation(userID); ok {
+		unlock := p.locks.lock(current)
+		defer unlock()
+	}
+
+	fromID, toID, err := p.allocator.ReassignUser(ctx, userID)
+	switch {
+	case errors.Is(err, allocator.ErrUserNotFound):
+		return "", "", ErrUserNotAllocated
+	case errors.Is(err, allocator.ErrNoReadyNode), errors.Is(err, allocator.ErrNodeNotReady):
+		return fromID, "", ErrNoReadyNode
+	case err != nil:
+		return fromID, "", err
+	}
+
+	now := time.Now()
+	p.sessions.End(userID, session.EndReassigned, now)
+	if n, ok := p.nodePool.Get(toID); ok {
+		p.sessions.Start(userID, toID, n.InstanceType, now)
+	}
+	p.emitAllocation(feed.ActionReassigned, userID, toID, fromID)
+
+	p.logger.Info("user reassigned on operator request",
+		zap.String("request_id", requestid.From(ctx)),
+		zap.String("user_id", userID),
+		zap.String("from_node_id", fromID),
+		zap.String("to_node_id", toID),
+	)
+
+	p.publishAllocation(ctx, events.ChannelAllocationResult, events.AllocationResultEvent{
+		UserID:         userID,
+		NodeID:         toID,
+		PreviousNodeID: fromID,
+		Status:         events.AllocationStatusReassigned,
+	})
+
+	return fromID, toID, nil
+}
+
 // HandleUserActivity handles user activity events
 func (p *Provisioner) HandleUserActivity(ctx context.Context, event events.UserActivityEvent) error {
+	if err := p.checkTenant(event.UserID, event.TenantID); err != nil {
+		return err
+	}
 	timestamp := time.Unix(event.Timestamp, 0)
-	p.userTracker.RecordActivity(event.UserID, timestamp)
+	p.userTracker.RecordActivity(event.UserID, event.Type, timestamp)
+	p.rightsizing.RecordActivity(event.UserID, event.Type, timestamp)
+	p.announceRecommendation(ctx, event.UserID)
 
 	p.logger.Debug("user activity recorded",
 		zap.String("user_id", event.UserID),
+		zap.String("type", event.Type),
 		zap.Time("timestamp", timestamp),
 	)
 
 	return nil
 }
 
+// HandleUserActivityBatch handles batches of user activity events. Records
+// for users of another tenant than they name are dropped.
+func (p *Provisioner) HandleUserActivityBatch(ctx context.Context, event events.UserActivityBatchEvent) error {
+	event.Activities = slices.DeleteFunc(event.Activities, func(activity events.UserActivityEvent) bool {
+		return p.checkTenant(activity.UserID, activity.TenantID) != nil
+	})
+	activities := make([]user.UserActivity, len(event.Activities))
+	for i, activity := range event.Activities {
+		activities[i] = user.UserActivity{UserID: activity.UserID, Type: activity.Type, Timestamp: activity.Timestamp}
+	}
+	p.userTracker.RecordActivities(activities)
+	for _, activity := range event.Activities {
+		p.rightsizing.RecordActivity(activity.UserID, activity.Type, time.Unix(activity.Timestamp, 0))
+		p.announceRecommendation(ctx, activity.UserID)
+	}
+
+	p.logger.Debug("user activity batch recorded", zap.Int("count", len(activities)))
+
+	return nil
+}
+
 // HandleUserConnect handles user connect events
 func (p *Provisioner) HandleUserConnect(ctx context.Context, event events.UserConnectEvent) error {
 	p.logger.Info("user connect request",
 		zap.String("user_id", event.UserID),
+		zap.String("session_id", event.SessionID),
 	)
+	if p.throttleConnect(ctx, event) || p.denyUnauthorized(ctx, event) {
+		return nil
+	}
+	p.accuracy.Connected(event.UserID, time.Now())
+	p.forecaster.RecordConnect(time.Now())
+	if event.TenantID != "" {
+		p.userTracker.SetTenant(event.UserID, event.TenantID)
+	}
+	if event.Tier != "" {
+		p.userTracker.SetTier(event.UserID, p.entitledTier(event))
+	}
+	p.userTracker.SetSelector(event.UserID, event.Selector)
+	p.preferInstanceType(event.UserID)
 
-	nodeID, err := p.allocator.AllocateNodeToUser(event.UserID)
+	nodeID, err := p.allocator.AllocateNodeToUser(ctx, event.UserID)
 	if err != nil {
-		switch err {
-		case allocator.ErrNoReadyNode:
+		reason, retryAfter := events.FailureAllocationError, time.Duration(0)
+		code := errcode.Of(err)
+		switch {
+		case err == allocator.ErrNoReadyNode && p.Draining():
+			p.logger.Info("connect refused while draining",
+				zap.String("user_id", event.UserID),
+			)
+			err, code = ErrDraining, errcode.Draining
+			reason = events.FailureDraining
+		case err == allocator.ErrNoReadyNode:
 			p.logger.Error("CRITICAL: no ready node available for user",
 				zap.String("user_id", event.UserID),
 			)
+			p.slo.ConnectMissed(event.UserID)
+			p.waitConnect(event)
+			reason = events.FailureNoReadyNode
 			// Emergency provision
-			if provErr := p.provisionNode(ctx); provErr != nil {
-				p.logger.Error("failed to emergency provision node", zap.Error(provErr))
+			tier, _, _ := p.latencyTier(event.UserID)
+			if provErr := p.provisionNode(ctx, event.UserID, event.Selector, tier); errors.Is(provErr, ErrBudgetExceeded) {
+				reason, code = events.FailureBudgetExceeded, errcode.BudgetExceeded
+			} else if provErr != nil && !errors.Is(provErr, ErrDryRun) {
+				code = errcode.Of(provErr)
+				p.logger.Error("failed to emergency provision node",
+					zap.String("code", string(code)),
+					zap.Error(provErr),
+				)
+				reason = events.FailureProvisioningFailed
+			}
+			retryAfter = p.retryAfter()
+		case err == allocator.ErrAlreadyAllocated:
+			if !p.openSession(ctx, event, nodeID) {
+				return nil
 			}
-		case allocator.ErrAlreadyAllocated:
 			p.logger.Info("user already has allocated node",
 				zap.String("user_id", event.UserID),
 				zap.String("node_id", nodeID),
 			)
+			p.replyAllocation(ctx, event, events.AllocationResultEvent{
+				NodeID: nodeID,
+				Status: events.AllocationStatusAlreadyAllocated,
+			})
 			return nil
 		default:
 			p.logger.Error("failed to allocate node",
 				zap.String("user_id", event.UserID),
+				zap.String("code", string(code)),
 				zap.Error(err),
 			)
 		}
+		p.replyAllocation(ctx, event, events.AllocationResultEvent{
+			Status: events.AllocationStatusFailed,
+			Reason: err.Error(),
+			Code:   string(code),
+		})
+		p.publishAllocationFailed(ctx, event, reason, code, err, retryAfter)
 		return err
 	}
 
+	p.slo.ConnectServed(event.UserID)
+	p.openSession(ctx, event, nodeID)
+	tier, _, _ := p.latencyTier(event.UserID)
+	defer p.provisionPreferredType(ctx, event.UserID, nodeID, tier)
+
+	// The session starts once the user confirms attaching
+	if until, reserved := p.reservedUntil(nodeID, event.UserID); reserved {
+		p.logger.Info("node held for user until confirmed",
+			zap.String("user_id", event.UserID),
+			zap.String("node_id", nodeID),
+			zap.Time("until", until),
+		)
+		p.emitAllocation(feed.ActionUnconfirmed, event.UserID, nodeID, "")
+		p.replyAllocation(ctx, event, events.AllocationResultEvent{
+			NodeID:        nodeID,
+			Status:        events.AllocationStatusUnconfirmed,
+			ReservedUntil: until.Unix(),
+		})
+		return nil
+	}
+
 	p.logger.Info("node allocated to user",
 		zap.String("user_id", event.UserID),
 		zap.String("node_id", nodeID),
 	)
+	p.emitAllocation(feed.ActionAllocated, event.UserID, nodeID, "")
+	p.startAllocation(ctx, event.UserID, nodeID, func(ctx context.Context) {
+		p.replyAllocation(ctx, event, events.AllocationResultEvent{
+			NodeID: nodeID,
+			Status: events.AllocationStatusAllocated,
+		})
+	})
 
 	return nil
 }
 
+// replyAllocation publishes the allocation result for a connect request that
+// asked for a reply via a reply channel or correlation ID
+func (p *Provisioner) replyAllocation(ctx context.Context, event events.UserConnectEvent, result events.AllocationResultEvent) {
+	result.UserID = event.UserID
+	if p.replyToAllocate(ctx, result) {
+		return
+	}
+
+	channel := event.ReplyChannel
+	if channel == "" {
+		if event.CorrelationID == "" {
+			return
+		}
+		channel = events.ChannelAllocationResult
+	}
+
+	result.CorrelationID = event.CorrelationID
+
+	p.publishAllocation(ctx, channel, result)
+}
+
+// userContext returns ctx carrying the tenant of a user in place of any it
+// carried, so events for them are published on their tenant's channels
+func (p *Provisioner) userContext(ctx context.Context, userID string) context.Context {
+	return events.WithTenant(ctx, p.userTracker.TenantOf(userID))
+}
+
+// publishAllocationFailed publishes a structured failure with a retry hint so
+// clients can tell users their node is warming up
+func (p *Provisioner) publishAllocationFailed(ctx context.Context, event events.UserConnectEvent, reason string, code errcode.Code, cause error, retryAfter time.Duration) {
+	data, err := p.config.CloudEvents.Encode(events.ChannelAllocationFailed, events.AllocationFailedEvent{
+		SchemaVersion:     events.CurrentSchemaVersion,
+		CorrelationID:     event.CorrelationID,
+		UserID:            event.UserID,
+		Reason:            reason,
+		Message:           cause.Error(),
+		Code:              string(code),
+		RetryAfterSeconds: int(retryAfter.Round(time.Second).Seconds()),
+		NodesBooting:      p.nodePool.CountByStatus(node.NodeStatusBooting),
+		Timestamp:         time.Now().Unix(),
+	})
+	if err != nil {
+		p.logger.Error("failed to marshal allocation failure", zap.Error(err))
+		return
+	}
+
+	if err := p.publisher.Publish(events.WithTenant(ctx, event.TenantID), events.ChannelAllocationFailed, string(data)); err != nil {
+		p.logger.Error("failed to publish allocation failure",
+			zap.String("user_id", event.UserID),
+			zap.Error(err),
+		)
+	}
+}
+
+// publishAllocation fills in the node's connection details and publishes an
+// allocation result
+func (p *Provisioner) publishAllocation(ctx context.Context, channel string, result events.AllocationResultEvent) {
+	result = p.withEndpoint(result)
+
+	data, err := p.config.CloudEvents.Encode(events.ChannelAllocationResult, result)
+	if err != nil {
+		p.logger.Error("failed to marshal allocation result", zap.Error(err))
+		return
+	}
+
+	if err := p.publisher.Publish(p.userContext(ctx, result.UserID), channel, string(data)); err != nil {
+		p.logger.Error("failed to publish allocation result",
+			zap.String("user_id", result.UserID),
+			zap.String("channel", channel),
+			zap.Error(err),
+		)
+	}
+}
+
+// withEndpoint fills in the connection details of an allocation result's
+// node
+func (p *Provisioner) withEndpoint(result events.AllocationResultEvent) events.AllocationResultEvent {
+	result.SchemaVersion = events.CurrentSchemaVersion
+
+	if result.NodeID != "" {
+		if n, ok := p.nodePool.Get(result.NodeID); ok {
+			result.Address = n.Endpoint.Address
+			result.Hostname = n.Endpoint.Hostname
+			result.Port = n.Endpoint.Port
+			result.AuthToken = n.Endpoint.AuthToken
+		}
+	}
+	return result
+}
+
 // HandleUserDisconnect handles user disconnect events
 func (p *Provisioner) HandleUserDisconnect(ctx context.Context, event events.UserDisconnectEvent) error {
+	if err := p.checkTenant(event.UserID, event.TenantID); err != nil {
+		return err
+	}
 	p.logger.Info("user disconnect",
 		zap.String("user_id", event.UserID),
+		zap.String("session_id", event.SessionID),
 	)
+	p.countDisconnect(ctx, event)
+	if p.closeSession(event) {
+		return nil
+	}
+	p.slo.Abandon(event.UserID)
+	p.takeWaitingConnect(event.UserID)
+	p.accuracy.Disconnected(event.UserID)
+	p.abortUserMigration(ctx, event.UserID, "user disconnected")
+
+	nodeID, allocated := p.allocator.GetAllocation(event.UserID)
+	if allocated {
+		unlock := p.locks.lock(nodeID)
+		defer unlock()
+	}
 
-	if err := p.allocator.DeallocateNodeFromUser(event.UserID); err != nil {
+	if err := p.allocator.DeallocateNodeFromUser(ctx, event.UserID); err != nil {
 		p.logger.Error("failed to deallocate node",
 			zap.String("user_id", event.UserID),
 			zap.Error(err),
 		)
 		return err
 	}
+	p.sessions.End(event.UserID, session.EndDisconnect, time.Now())
+	if allocated {
+		p.emitAllocation(feed.ActionReleased, event.UserID, nodeID, "")
+	}
 
 	return nil
 }
 
+// updateNodeFields merges the fields of a status event without a status
+// into a known node; the caller holds the node's lock
+func (p *Provisioner) updateNodeFields(event events.NodeStatusEvent) {
+	n, ok := p.nodePool.Get(event.NodeID)
+	if !ok || n.Status == node.NodeStatusTerminating || n.Status == node.NodeStatusTerminated {
+		p.logger.Debug("ignoring field update for unknown or terminated node",
+			zap.String("node_id", event.NodeID),
+		)
+		return
+	}
+	p.mergeNodeFields(event, false)
+}
+
+// mergeNodeFields records the fields a status event carries on the node,
+// leaving the others as they were. A node's capacity is the one it last
+// reported, or else follows its instance type's policy when the node is new
+// or reports its type.
+func (p *Provisioner) mergeNodeFields(event events.NodeStatusEvent, isNew bool) {
+	if event.AgentVersion != "" {
+		p.nodePool.SetAgentVersion(event.NodeID, event.AgentVersion)
+	}
+
+	if event.InstanceType != "" {
+		p.nodePool.SetInstanceType(event.NodeID, event.InstanceType)
+	}
+
+	if len(event.Labels) > 0 {
+		p.nodePool.SetLabels(event.NodeID, event.Labels)
+	}
+
+	if event.Capacity > 0 {
+		p.nodePool.SetCapacity(event.NodeID, event.Capacity, true)
+	} else if n, ok := p.nodePool.Get(event.NodeID); ok && !n.CapacityReported && (isNew || event.InstanceType != "") {
+		p.nodePool.SetCapacity(event.NodeID, p.predictor.Capacity(n), false)
+	}
+
+	if event.HasEndpoint() {
+		p.nodePool.MergeEndpoint(event.NodeID, node.Endpoint{
+			Address:   event.Address,
+			Hostname:  event.Hostname,
+			Port:      event.Port,
+			AuthToken: event.AuthToken,
+		})
+	}
+}
+
 // HandleNodeStatus handles node status events
 func (p *Provisioner) HandleNodeStatus(ctx context.Context, event events.NodeStatusEvent) error {
 	p.logger.Info("node status update",
@@ -234,16 +1622,87 @@ func (p *Provisioner) HandleNodeStatus(ctx context.Context, event events.NodeSta
 		zap.String("status", event.Status),
 	)
 
-	if _, exists := p.nodePool.Get(event.NodeID); !exists {
+	unlock := p.locks.lock(event.NodeID)
+	defer unlock()
+
+	if event.Status == "" {
+		p.updateNodeFields(event)
+		return nil
+	}
+
+	status := node.NodeStatus(event.Status)
+	existing, exists := p.nodePool.Get(event.NodeID)
+
+	// Late events from a node being torn down must not bring it back
+	if exists && status != node.NodeStatusTerminated &&
+		(existing.Status == node.NodeStatusTerminating || existing.Status == node.NodeStatusTerminated) {
+		p.logger.Debug("ignoring status update for terminated node",
+			zap.String("node_id", event.NodeID),
+			zap.String("status", event.Status),
+		)
+		return nil
+	}
+	if _, archived := p.archive.ArchivedNode(event.NodeID); archived && !exists {
+		p.logger.Debug("ignoring status update for purged node",
+			zap.String("node_id", event.NodeID),
+			zap.String("status", event.Status),
+		)
+		return nil
+	}
+
+	// Nodes with pre-ready hooks stay booting until the hooks pass
+	gated := status == node.NodeStatusReady && p.lifecycle.Has(lifecycle.StagePreReady) &&
+		(!exists || existing.Status == node.NodeStatusBooting)
+	if gated {
+		status = node.NodeStatusBooting
+	}
+
+	becameReady := status == node.NodeStatusReady && (!exists || existing.Status == node.NodeStatusBooting)
+	if becameReady && exists {
+		p.recordBootTime(existing, time.Since(existing.CreatedAt))
+	}
+
+	var from node.NodeStatus
+	if exists {
+		from = existing.Status
+	}
+
+	if !exists {
 		n := &node.Node{
 			ID:        event.NodeID,
-			Status:    node.NodeStatus(event.Status),
+			Status:    status,
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		}
 		p.nodePool.Add(n)
 	} else {
-		p.nodePool.UpdateStatus(event.NodeID, node.NodeStatus(event.Status))
+		p.nodePool.UpdateStatus(event.NodeID, status)
+	}
+
+	p.mergeNodeFields(event, !exists)
+
+	// A node reporting itself terminated that was not being torn down went
+	// away on its own, e.g. a spot reclaim
+	interrupted := status == node.NodeStatusTerminated && exists &&
+		from != node.NodeStatusTerminated && from != node.NodeStatusTerminating
+	if interrupted {
+		p.nodePool.MarkTerminated(event.NodeID, node.TerminationInterruption)
+		p.recordTermination(ctx, existing, from, node.TerminationInterruption)
+		p.purchasing.Interrupted(existing)
+	} else if n, ok := p.nodePool.Get(event.NodeID); ok && from != status {
+		p.emitTransition(n, from, status, "node status event")
+	}
+
+	if status == node.NodeStatusTerminated && from != status {
+		p.allocator.ForgetNode(ctx, event.NodeID)
+	}
+
+	if gated && p.lifecycle.BeginPreReady(event.NodeID) {
+		go p.runPreReadyHooks(context.WithoutCancel(ctx), event.NodeID)
+	}
+
+	if becameReady {
+		p.offerToWaitingUser(ctx, event.NodeID)
 	}
 
 	return nil
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/access"
+	"github.com/aos-cc/provisioning-service/internal/domain/accuracy"
+	"github.com/aos-cc/provisioning-service/internal/domain/allocator"
+	"github.com/aos-cc/provisioning-service/internal/domain/boottime"
+	"github.com/aos-cc/provisioning-service/internal/domain/budget"
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/forecast"
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/history"
+	"github.com/aos-cc/provisioning-service/internal/domain/lifecycle"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+	"github.com/aos-cc/provisioning-service/internal/domain/purchasing"
+	"github.com/aos-cc/provisioning-service/internal/domain/ratelimit"
+	"github.com/aos-cc/provisioning-service/internal/domain/replication"
+	"github.com/aos-cc/provisioning-service/internal/domain/rightsizing"
+	"github.com/aos-cc/provisioning-service/internal/domain/safety"
+	"github.com/aos-cc/provisioning-service/internal/domain/session"
+	"github.com/aos-cc/provisioning-service/internal/domain/slo"
+	"github.com/aos-cc/provisioning-service/internal/domain/user"
+	"go.uber.org/zap"
+)
+
+// nopObserver ignores everything the provisioner and its parts observe
+type nopObserver struct{}
+
+func (nopObserver) ObserveUserEviction(reason string)                        {}
+func (nopObserver) ObserveViolation(check string)                            {}
+func (nopObserver) ObserveDrifts(check string, open int)                     {}
+func (nopObserver) ObserveConnect(warm bool)                                 {}
+func (nopObserver) ObserveWait(wait time.Duration)                           {}
+func (nopObserver) ObserveAbandon(reason string, wait time.Duration)         {}
+func (nopObserver) ObserveBootDuration(instanceType string, d time.Duration) {}
+func (nopObserver) ObserveBudgetBlock(limit string)                          {}
+func (nopObserver) ObserveAccessDenied(reason string)                        {}
+func (nopObserver) ObservePredictionOutcome(outcome string)                  {}
+func (nopObserver) ObservePurchases(option, reason string, count int)        {}
+func (nopObserver) ObserveThrottled(event string)                            {}
+func (nopObserver) ObserveBootFailure(instanceType string)                   {}
+func (nopObserver) ObserveIdleReclaim(outcome string)                        {}
+func (nopObserver) ObserveLatencyBreach(tier string)                         {}
+func (nopObserver) ObserveEscalation(step, outcome string)                   {}
+func (nopObserver) ObserveCapacityError(provider, instanceType, zone string) {}
+func (nopObserver) ObserveProviderFallback(provider string)                  {}
+func (nopObserver) ObserveNodeTermination(reason, instanceType string)       {}
+func (nopObserver) ObserveTerminationDeferred(reason string)                 {}
+func (nopObserver) ObserveNodesPurged(count int)                             {}
+
+// memoryArchive keeps archived nodes in a map
+type memoryArchive map[string]node.Node
+
+func (a memoryArchive) ArchiveNode(n node.Node) {
+	a[n.ID] = n
+}
+
+func (a memoryArchive) ArchivedNode(nodeID string) (node.Node, bool) {
+	n, ok := a[nodeID]
+	return n, ok
+}
+
+// testProvisioner is a provisioner wired to in-memory parts, with the
+// parts tests look at
+type testProvisioner struct {
+	*Provisioner
+	pool      *node.NodePool
+	users     *user.UserTracker
+	publisher *recordingPublisher
+	archive   memoryArchive
+}
+
+// newTestProvisioner creates a standalone provisioner whose nodes come from
+// the given providers
+func newTestProvisioner(config Config, providers ...NamedProvider) *testProvisioner {
+	return newReplicaProvisioner(config, replication.Standalone{}, providers...)
+}
+
+// newReplicaProvisioner creates a provisioner replicating through cluster
+func newReplicaProvisioner(config Config, cluster replication.Cluster, providers ...NamedProvider) *testProvisioner {
+	logger := zap.NewNop()
+	pool := node.NewNodePool(node.AgentCompatibility{})
+	users := user.NewUserTracker(time.Minute, user.Config{Horizon: time.Hour}, nopObserver{})
+	guard := safety.NewGuard(users, nopObserver{}, logger)
+	forecaster := forecast.NewForecaster(forecast.DefaultConfig())
+	pred := predictor.NewPredictor(predictor.PredictionConfig{}, users, pool, forecaster, guard, boottime.NewTracker(nopObserver{}))
+	pub := &recordingPublisher{}
+	archive := memoryArchive{}
+
+	p := NewProvisioner(
+		pool,
+		users,
+		allocator.NewNodeAllocator(pool, users, allocator.LocalClaims{}, time.Minute, nil, logger),
+		pred,
+		providers,
+		pub,
+		history.NewHistory(time.Hour, time.Minute),
+		forecaster,
+		slo.NewTracker(time.Hour, 0.99, nopObserver{}),
+		lifecycle.NewManager(nil, logger),
+		session.NewRecorder(session.NopSink{}, session.Config{}, logger),
+		guard,
+		nopObserver{},
+		feed.NewHub(),
+		budget.NewTracker(budget.Config{}, pool, nopObserver{}),
+		access.NewController(access.Config{}, nil, nopObserver{}),
+		accuracy.NewTracker(time.Minute, time.Hour, nopObserver{}),
+		rightsizing.New(rightsizing.Config{}),
+		purchasing.NewStrategy(purchasing.Config{}, pool, users, nopObserver{}),
+		ratelimit.NewLimiter(nil, ratelimit.Limit{}, nopObserver{}),
+		nopObserver{},
+		nopObserver{},
+		nopObserver{},
+		nopObserver{},
+		archive,
+		user.NopStore{},
+		handoff.NopStore{},
+		cluster,
+		logger,
+		config,
+	)
+	return &testProvisioner{Provisioner: p, pool: pool, users: users, publisher: pub, archive: archive}
+}
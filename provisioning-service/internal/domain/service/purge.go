@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// NodeArchive keeps the final records of terminated nodes purged from the
+// pool, so their history can still be inspected. It is per replica and
+// bounded by the journal's retention; the durable record is the event
+// published on ChannelNodeArchived.
+type NodeArchive interface {
+	ArchiveNode(n node.Node)
+	ArchivedNode(nodeID string) (node.Node, bool)
+}
+
+// purgeTerminatedNodes archives the nodes terminated for longer than
+// TerminatedNodeRetention and drops them from the pool, which would
+// otherwise keep every node it ever held
+func (p *Provisioner) purgeTerminatedNodes(ctx context.Context) {
+	purged := p.nodePool.PurgeTerminated(time.Now().Add(-p.config.TerminatedNodeRetention))
+	if len(purged) == 0 {
+		return
+	}
+
+	for _, n := range purged {
+		p.archive.ArchiveNode(n)
+		p.publishArchived(ctx, n)
+	}
+	p.terminationObserver.ObserveNodesPurged(len(purged))
+	p.logger.Debug("purged terminated nodes",
+		zap.Int("nodes", len(purged)),
+		zap.Duration("retention", p.config.TerminatedNodeRetention),
+	)
+}
+
+// publishArchived publishes the final record of a purged node for audit
+// stores, which keep it past a restart or failover
+func (p *Provisioner) publishArchived(ctx context.Context, n node.Node) {
+	var terminatedAt int64
+	if !n.TerminatedAt.IsZero() {
+		terminatedAt = n.TerminatedAt.Unix()
+	}
+	data, err := p.config.CloudEvents.Encode(events.ChannelNodeArchived, events.NodeArchivedEvent{
+		SchemaVersion:     events.CurrentSchemaVersion,
+		NodeID:            n.ID,
+		InstanceType:      n.InstanceType,
+		PoolType:          n.PoolType,
+		Provider:          n.Provider,
+		Zone:              n.Zone,
+		Purchase:          n.Purchase,
+		Labels:            n.Labels,
+		AgentVersion:      n.AgentVersion,
+		BootAttempt:       n.BootAttempt,
+		TerminationReason: string(n.TerminationReason),
+		CreatedAt:         n.CreatedAt.Unix(),
+		TerminatedAt:      terminatedAt,
+		Timestamp:         time.Now().Unix(),
+	})
+	if err != nil {
+		p.logger.Error("failed to marshal archived node", zap.Error(err))
+		return
+	}
+
+	if err := p.publisher.Publish(ctx, events.ChannelNodeArchived, string(data)); err != nil {
+		p.logger.Error("failed to publish archived node",
+			zap.String("node_id", n.ID),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+func TestPurgeTerminatedNodesPublishesArchive(t *testing.T) {
+	p := newTestProvisioner(Config{TerminatedNodeRetention: time.Hour})
+	terminatedAt := time.Now().Add(-2 * time.Hour)
+	p.pool.Replace([]node.Node{
+		{
+			ID:                "n1",
+			Status:            node.NodeStatusTerminated,
+			InstanceType:      "g5.xlarge",
+			Provider:          "aws",
+			Endpoint:          node.Endpoint{AuthToken: "secret-n1"},
+			TerminationReason: node.TerminationIdle,
+			CreatedAt:         terminatedAt.Add(-time.Hour),
+			TerminatedAt:      terminatedAt,
+			UpdatedAt:         terminatedAt,
+		},
+		*readyNode("n2"),
+	})
+
+	p.purgeTerminatedNodes(context.Background())
+
+	if _, ok := p.archive["n1"]; !ok {
+		t.Error("purged node not archived")
+	}
+	published := p.publisher.on(events.ChannelNodeArchived)
+	if len(published) != 1 {
+		t.Fatalf("published %d archive records, want 1", len(published))
+	}
+	var record events.NodeArchivedEvent
+	if err := json.Unmarshal([]byte(published[0]), &record); err != nil {
+		t.Fatalf("invalid archive record: %v", err)
+	}
+	if record.NodeID != "n1" || record.TerminationReason != string(node.TerminationIdle) ||
+		record.InstanceType != "g5.xlarge" || record.TerminatedAt != terminatedAt.Unix() {
+		t.Errorf("record = %+v, want n1's final state", record)
+	}
+	if strings.Contains(published[0], "secret-n1") {
+		t.Error("archive record carries the node's auth token")
+	}
+}
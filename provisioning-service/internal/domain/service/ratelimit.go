@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/ratelimit"
+	"go.uber.org/zap"
+)
+
+// ErrRateLimited is returned when a user connects more often than their
+// rate limit allows
+var ErrRateLimited = errcode.New(errcode.RateLimited, "rate limited")
+
+// throttleConnect counts a connect against the user's rate limit, refusing
+// it if the user is over the limit
+func (p *Provisioner) throttleConnect(ctx context.Context, event events.UserConnectEvent) bool {
+	decision := p.takeToken(ctx, event.UserID, ratelimit.EventConnect)
+	if decision.Allowed {
+		return false
+	}
+
+	p.logger.Warn("user connect rate limited",
+		zap.String("user_id", event.UserID),
+		zap.Duration("retry_after", decision.RetryAfter),
+	)
+
+	err := fmt.Errorf("%w: retry in %s", ErrRateLimited, decision.RetryAfter.Round(time.Second))
+	p.replyAllocation(ctx, event, events.AllocationResultEvent{
+		Status: events.AllocationStatusFailed,
+		Reason: err.Error(),
+		Code:   string(errcode.RateLimited),
+	})
+	p.publishAllocationFailed(ctx, event, events.FailureRateLimited, errcode.RateLimited, err, decision.RetryAfter)
+	if decision.Tripped {
+		p.publishThrottled(events.WithTenant(ctx, event.TenantID), event.UserID, ratelimit.EventConnect, decision.RetryAfter)
+	}
+	return true
+}
+
+// countDisconnect counts a disconnect against the user's rate limit. The
+// disconnect is handled either way, since ignoring it would leave the user's
+// node allocated; disconnects have their own bucket, so a flood of them is
+// only reported and never holds up the user's next connect.
+func (p *Provisioner) countDisconnect(ctx context.Context, event events.UserDisconnectEvent) {
+	decision := p.takeToken(ctx, event.UserID, ratelimit.EventDisconnect)
+	if decision.Tripped {
+		p.logger.Warn("user disconnect over rate limit",
+			zap.String("user_id", event.UserID),
+		)
+		p.publishThrottled(p.userContext(ctx, event.UserID), event.UserID, ratelimit.EventDisconnect, decision.RetryAfter)
+	}
+}
+
+// takeToken takes a token from a user's bucket, allowing the event if the
+// bucket store fails
+func (p *Provisioner) takeToken(ctx context.Context, userID, event string) ratelimit.Decision {
+	decision, err := p.limiter.Take(ctx, userID, event)
+	if err != nil {
+		p.logger.Warn("rate limit store failed, allowing event",
+			zap.String("user_id", userID),
+			zap.String("event", event),
+			zap.Error(err),
+		)
+	}
+	return decision
+}
+
+// publishThrottled tells clients that a user went over their rate limit
+func (p *Provisioner) publishThrottled(ctx context.Context, userID, event string, retryAfter time.Duration) {
+	limit := p.limiter.Limit()
+	data, err := p.config.CloudEvents.Encode(events.ChannelUserThrottled, events.UserThrottledEvent{
+		SchemaVersion:     events.CurrentSchemaVersion,
+		UserID:            userID,
+		Event:             event,
+		RetryAfterSeconds: int(retryAfter.Round(time.Second).Seconds()),
+		PerMinute:         limit.PerMinute,
+		Burst:             limit.Burst,
+		Timestamp:         time.Now().Unix(),
+	})
+	if err != nil {
+		p.logger.Error("failed to marshal throttle event", zap.Error(err))
+		return
+	}
+
+	if err := p.publisher.Publish(ctx, events.ChannelUserThrottled, string(data)); err != nil {
+		p.logger.Error("failed to publish throttle event",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+	}
+}
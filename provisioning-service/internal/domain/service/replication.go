@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// IsLeader reports whether this replica leads, and so scales, allocates and
+// accepts changes to the pool
+func (p *Provisioner) IsLeader() bool {
+	return p.cluster.IsLeader()
+}
+
+// ReplicaID identifies this replica; empty without replication
+func (p *Provisioner) ReplicaID() string {
+	return p.cluster.ID()
+}
+
+// lead reports whether this replica leads and should act this tick; one
+// that does not lead mirrors the leader's state instead. Only called from
+// the provisioner loop.
+func (p *Provisioner) lead(ctx context.Context) bool {
+	leader := p.cluster.IsLeader()
+	if !leader && p.leading {
+		p.logger.Warn("no longer the leader; following", zap.String("replica", p.cluster.ID()))
+	}
+	p.leading = leader
+
+	if !leader {
+		p.follow(ctx)
+	}
+	return leader
+}
+
+// Promote takes over the state the previous leader replicated: the pool,
+// connected users and pending migrations, with the slot claims rebuilt from
+// the nodes' users. Without replicated state, or with state older than
+// HandoffMaxAge, the pool is kept as restored on startup. The cluster calls
+// it on election, before this replica is reported as the leader, so no
+// event or tick acts on the pool while it is being replaced.
+func (p *Provisioner) Promote(ctx context.Context) error {
+	snapshot, ok, err := p.cluster.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load replicated state: %w", err)
+	}
+	if !ok {
+		return nil
+	}
+	if age := time.Since(snapshot.WrittenAt); age > p.config.HandoffMaxAge {
+		p.logger.Warn("ignoring stale replicated state",
+			zap.Duration("age", age),
+			zap.Duration("max_age", p.config.HandoffMaxAge),
+		)
+		return nil
+	}
+
+	p.mirror(snapshot)
+	p.allocator.RestoreClaims(ctx, snapshot.Nodes)
+
+	p.logger.Info("took over replicated pool",
+		zap.String("replica", p.cluster.ID()),
+		zap.Int("nodes", len(snapshot.Nodes)),
+		zap.Int("users", len(snapshot.Users)),
+		zap.Int("migrations", len(snapshot.Migrations)),
+		zap.Duration("age", time.Since(snapshot.WrittenAt)),
+	)
+	return nil
+}
+
+// follow mirrors the leader's last replicated state, so a follower serves
+// reads of the pool and is ready to take over
+func (p *Provisioner) follow(ctx context.Context) {
+	snapshot, ok, err := p.cluster.Load(ctx)
+	if err != nil {
+		p.logger.Warn("failed to load replicated state", zap.Error(err))
+		return
+	}
+	if ok {
+		p.mirror(snapshot)
+	}
+	p.recordCheckOK()
+}
+
+// mirror replaces the pool, connected users, migrations, drain state,
+// pushed sessions and prediction overrides with replicated ones. Replicated nodes carry no auth
+// tokens; each keeps the token this replica last saw for it in a status
+// event, or already held.
+func (p *Provisioner) mirror(snapshot handoff.Snapshot) {
+	p.tokensMu.Lock()
+	present := make(map[string]bool, len(snapshot.Nodes))
+	for i := range snapshot.Nodes {
+		n := &snapshot.Nodes[i]
+		present[n.ID] = true
+		if n.Endpoint.AuthToken != "" {
+			continue
+		}
+		if token, ok := p.tokens[n.ID]; ok {
+			n.Endpoint.AuthToken = token
+		} else if current, ok := p.nodePool.Get(n.ID); ok {
+			n.Endpoint.AuthToken = current.Endpoint.AuthToken
+		}
+	}
+	for _, n := range p.nodePool.Snapshot() {
+		if !present[n.ID] {
+			delete(p.tokens, n.ID)
+		}
+	}
+	p.tokensMu.Unlock()
+
+	p.nodePool.Replace(snapshot.Nodes)
+	p.userTracker.Mirror(snapshot.Users)
+
+	p.migrationsMu.Lock()
+	clear(p.migrations)
+	for _, m := range snapshot.Migrations {
+		p.migrations[m.UserID] = m
+	}
+	p.migrationsMu.Unlock()
+
+	p.restoreDrain(snapshot.Drain)
+	p.restoreSessions(snapshot.Sessions)
+	p.restoreTuning(snapshot.Tuning)
+}
+
+// rememberToken keeps the auth token a status event reports for a node, so
+// a standby can hand it out once it leads; the replicated state leaves
+// tokens out
+func (p *Provisioner) rememberToken(event events.NodeStatusEvent) {
+	p.tokensMu.Lock()
+	defer p.tokensMu.Unlock()
+
+	switch {
+	case event.Status == string(node.NodeStatusTerminated):
+		delete(p.tokens, event.NodeID)
+	case event.AuthToken != "":
+		p.tokens[event.NodeID] = event.AuthToken
+	}
+}
+
+// Replicate saves the pool, connected users and migrations for the other
+// replicas, without node auth tokens. It runs after every tick of the
+// leader, every event that changed the pool and on shutdown, and does
+// nothing on a follower.
+func (p *Provisioner) Replicate(ctx context.Context) error {
+	if !p.cluster.IsLeader() {
+		return nil
+	}
+	return p.cluster.Save(ctx, p.snapshot().WithoutSecrets())
+}
+
+// replicateChange replicates the state after a change outside the tick, so
+// a replica taking over misses as little as possible; without other
+// replicas the tick's replication is enough
+func (p *Provisioner) replicateChange(ctx context.Context) {
+	if p.config.ReplicateChanges {
+		p.replicate(ctx)
+	}
+}
+
+// fence confirms that this replica still leads before a Node API call, so
+// a leader deposed without noticing yet cannot create or terminate nodes
+// its successor does not know about
+func (p *Provisioner) fence(ctx context.Context) error {
+	if err := p.cluster.Fence(ctx); err != nil {
+		return errcode.Wrap(errcode.NotLeader, err)
+	}
+	return nil
+}
+
+// replicate replicates the state at the end of a leader's tick
+func (p *Provisioner) replicate(ctx context.Context) {
+	if err := p.Replicate(ctx); err != nil {
+		p.logger.Warn("failed to replicate pool", zap.Error(err))
+	}
+}
+
+// snapshot captures every node not terminated or terminating, and the
+// connected users
+func (p *Provisioner) snapshot() handoff.Snapshot {
+	var nodes []node.Node
+	for _, n := range p.nodePool.Snapshot() {
+		if n.Status == node.NodeStatusTerminated || n.Status == node.NodeStatusTerminating {
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	return handoff.Snapshot{
+		Version:    handoff.Version,
+		WrittenAt:  time.Now(),
+		Nodes:      nodes,
+		Users:      p.userTracker.ConnectedStates(),
+		Migrations: p.Migrations(),
+		Drain:      p.drainState(),
+		Sessions:   p.apiSessions(),
+		Tuning:     p.tuningOverrides(),
+	}
+}
+
+// LeaderOnly returns a handler that passes events acting on the pool only
+// while this replica leads. Activity is recorded by every replica, so a
+// newly elected leader predicts from it straight away.
+func (p *Provisioner) LeaderOnly(next events.Handler) events.Handler {
+	return &leaderOnly{next: next, provisioner: p}
+}
+
+type leaderOnly struct {
+	next        events.Handler
+	provisioner *Provisioner
+}
+
+func (h *leaderOnly) HandleUserActivity(ctx context.Context, event events.UserActivityEvent) error {
+	return h.next.HandleUserActivity(ctx, event)
+}
+
+func (h *leaderOnly) HandleUserActivityBatch(ctx context.Context, event events.UserActivityBatchEvent) error {
+	return h.next.HandleUserActivityBatch(ctx, event)
+}
+
+func (h *leaderOnly) HandleUserConnect(ctx context.Context, event events.UserConnectEvent) error {
+	if !h.provisioner.IsLeader() {
+		return nil
+	}
+	defer h.provisioner.replicateChange(ctx)
+	return h.next.HandleUserConnect(ctx, event)
+}
+
+func (h *leaderOnly) HandleUserDisconnect(ctx context.Context, event events.UserDisconnectEvent) error {
+	if !h.provisioner.IsLeader() {
+		return nil
+	}
+	defer h.provisioner.replicateChange(ctx)
+	return h.next.HandleUserDisconnect(ctx, event)
+}
+
+func (h *leaderOnly) HandleNodeStatus(ctx context.Context, event events.NodeStatusEvent) error {
+	if !h.provisioner.IsLeader() {
+		h.provisioner.rememberToken(event)
+		return nil
+	}
+	defer h.provisioner.replicateChange(ctx)
+	return h.next.HandleNodeStatus(ctx, event)
+}
+
+func (h *leaderOnly) HandleUserMigrateAck(ctx context.Context, event events.UserMigrateAckEvent) error {
+	if !h.provisioner.IsLeader() {
+		return nil
+	}
+	defer h.provisioner.replicateChange(ctx)
+	return h.next.HandleUserMigrateAck(ctx, event)
+}
+
+func (h *leaderOnly) HandleUserConfirm(ctx context.Context, event events.UserConfirmEvent) error {
+	if !h.provisioner.IsLeader() {
+		return nil
+	}
+	defer h.provisioner.replicateChange(ctx)
+	return h.next.HandleUserConfirm(ctx, event)
+}
+
+func (h *leaderOnly) HandleNodeUtilization(ctx context.Context, event events.NodeUtilizationEvent) error {
+	if !h.provisioner.IsLeader() {
+		h.provisioner.followWorkload(event)
+		return nil
+	}
+	return h.next.HandleNodeUtilization(ctx, event)
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+	"github.com/aos-cc/provisioning-service/internal/domain/schedule"
+)
+
+// fakeCluster is a replica whose leadership the test sets, sharing the
+// replicated state with the other replicas given the same store
+type fakeCluster struct {
+	leader   bool
+	fenceErr error
+	store    *handoff.Snapshot
+}
+
+func (c *fakeCluster) Save(ctx context.Context, snapshot handoff.Snapshot) error {
+	*c.store = snapshot
+	return nil
+}
+
+func (c *fakeCluster) Load(ctx context.Context) (handoff.Snapshot, bool, error) {
+	return *c.store, c.store.Version != 0, nil
+}
+
+func (c *fakeCluster) IsLeader() bool {
+	return c.leader
+}
+
+func (c *fakeCluster) ID() string {
+	return "fake"
+}
+
+func (c *fakeCluster) Fence(ctx context.Context) error {
+	return c.fenceErr
+}
+
+func TestPromoteTakesOverReplicatedState(t *testing.T) {
+	ctx := context.Background()
+	store := &handoff.Snapshot{}
+	config := Config{HandoffMaxAge: time.Hour}
+
+	leader := newReplicaProvisioner(config, &fakeCluster{leader: true, store: store})
+	leader.pool.Replace([]node.Node{
+		*readyNode("n1", "u1"),
+		*readyNode("n2", "u1"),
+	})
+	leader.migrations["u1"] = Migration{ID: "m1", UserID: "u1", FromNodeID: "n1", ToNodeID: "n2"}
+	if err := leader.Replicate(ctx); err != nil {
+		t.Fatalf("Replicate: %v", err)
+	}
+	for _, n := range store.Nodes {
+		if n.Endpoint.AuthToken != "" {
+			t.Fatalf("node %s replicated with its auth token", n.ID)
+		}
+	}
+
+	// The standby learns the tokens from the status events it sees
+	standbyCluster := &fakeCluster{store: store}
+	standby := newReplicaProvisioner(config, standbyCluster)
+	handler := standby.LeaderOnly(nil)
+	for _, id := range []string{"n1", "n2"} {
+		event := events.NodeStatusEvent{NodeID: id, Status: string(node.NodeStatusReady), AuthToken: "token-" + id}
+		if err := handler.HandleNodeStatus(ctx, event); err != nil {
+			t.Fatalf("HandleNodeStatus: %v", err)
+		}
+	}
+
+	if err := standby.Promote(ctx); err != nil {
+		t.Fatalf("Promote: %v", err)
+	}
+	for _, id := range []string{"n1", "n2"} {
+		n, ok := standby.pool.Get(id)
+		if !ok {
+			t.Fatalf("node %s not taken over", id)
+		}
+		if n.Endpoint.AuthToken != "token-"+id {
+			t.Errorf("node %s token = %q, want the one from its status event", id, n.Endpoint.AuthToken)
+		}
+	}
+	if migrations := standby.Migrations(); len(migrations) != 1 || migrations[0].ID != "m1" {
+		t.Errorf("migrations = %v, want m1", migrations)
+	}
+}
+
+func TestPromoteTakesOverPushedSessions(t *testing.T) {
+	ctx := context.Background()
+	store := &handoff.Snapshot{}
+	config := Config{HandoffMaxAge: time.Hour}
+	start := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	leader := newReplicaProvisioner(config, &fakeCluster{leader: true, store: store})
+	leader.predictor.SetSchedule(schedule.New(schedule.Config{}))
+	pushed := []schedule.Session{{ID: "s1", Start: start, End: start.Add(time.Hour), Attendees: 20}}
+	if err := leader.SetSessions(ctx, pushed); err != nil {
+		t.Fatalf("SetSessions: %v", err)
+	}
+	if err := leader.Replicate(ctx); err != nil {
+		t.Fatalf("Replicate: %v", err)
+	}
+
+	standby := newReplicaProvisioner(config, &fakeCluster{store: store})
+	standby.predictor.SetSchedule(schedule.New(schedule.Config{}))
+	if err := standby.Promote(ctx); err != nil {
+		t.Fatalf("Promote: %v", err)
+	}
+	sessions := standby.predictor.Schedule().Sessions(schedule.SourceAPI)
+	if len(sessions) != 1 || sessions[0].ID != "s1" || sessions[0].Attendees != 20 {
+		t.Errorf("sessions = %+v, want the pushed one", sessions)
+	}
+}
+
+func TestPromoteTakesOverPredictionOverrides(t *testing.T) {
+	ctx := context.Background()
+	store := &handoff.Snapshot{}
+	config := Config{HandoffMaxAge: time.Hour}
+
+	leader := newReplicaProvisioner(config, &fakeCluster{leader: true, store: store})
+	leader.predictor = predictor.NewPredictor(predictor.DefaultPredictionConfig(), leader.users, leader.pool, nil, nil, nil)
+	if err := leader.Replicate(ctx); err != nil {
+		t.Fatalf("Replicate: %v", err)
+	}
+	if store.Tuning != nil {
+		t.Fatalf("Tuning = %+v replicated without an override", store.Tuning)
+	}
+	if _, err := leader.UpdatePredictionTuning(ctx, func(t *predictor.Tuning) { t.MinReadyNodes = 3 }); err != nil {
+		t.Fatalf("UpdatePredictionTuning: %v", err)
+	}
+	if err := leader.Replicate(ctx); err != nil {
+		t.Fatalf("Replicate: %v", err)
+	}
+
+	standby := newReplicaProvisioner(config, &fakeCluster{store: store})
+	standby.predictor = predictor.NewPredictor(predictor.DefaultPredictionConfig(), standby.users, standby.pool, nil, nil, nil)
+	if err := standby.Promote(ctx); err != nil {
+		t.Fatalf("Promote: %v", err)
+	}
+	if got := standby.PredictionTuning(); got != leader.PredictionTuning() {
+		t.Errorf("tuning = %+v, want the leader's %+v", got, leader.PredictionTuning())
+	}
+}
+
+func TestPromoteIgnoresStaleState(t *testing.T) {
+	store := &handoff.Snapshot{Version: handoff.Version, WrittenAt: time.Now().Add(-2 * time.Hour), Nodes: []node.Node{*readyNode("old")}}
+	p := newReplicaProvisioner(Config{HandoffMaxAge: time.Hour}, &fakeCluster{store: store})
+	p.pool.Replace([]node.Node{*readyNode("local")})
+
+	if err := p.Promote(context.Background()); err != nil {
+		t.Fatalf("Promote: %v", err)
+	}
+	if _, ok := p.pool.Get("local"); !ok {
+		t.Error("stale replicated state replaced the pool")
+	}
+}
+
+func TestFenceBlocksProviderCalls(t *testing.T) {
+	deposed := errors.New("leader key lost")
+	provider := &stubProvider{}
+	p := newReplicaProvisioner(Config{}, &fakeCluster{leader: true, fenceErr: deposed, store: &handoff.Snapshot{}},
+		NamedProvider{Name: "stub", Provider: provider})
+
+	n := readyNode("n1")
+	n.Provider = "stub"
+	err := p.terminate(context.Background(), n)
+	if !errors.Is(err, deposed) || errcode.Of(err) != errcode.NotLeader {
+		t.Fatalf("terminate = %v, want a NotLeader error", err)
+	}
+	if len(provider.terminated) != 0 {
+		t.Errorf("deposed leader terminated %v", provider.terminated)
+	}
+}
+
+func TestMigrationReplicatedWhenStarted(t *testing.T) {
+	ctx := context.Background()
+	store := &handoff.Snapshot{}
+	config := Config{HandoffMaxAge: time.Hour, MigrationTimeout: time.Minute, ReplicateChanges: true}
+
+	leader := newReplicaProvisioner(config, &fakeCluster{leader: true, store: store})
+	leader.pool.Replace([]node.Node{*readyNode("n1", "u1"), *readyNode("n2")})
+	leader.users.MarkConnected("u1", "n1")
+
+	m, err := leader.MigrateUser(ctx, "u1", events.MigrationReasonOperator)
+	if err != nil {
+		t.Fatalf("MigrateUser: %v", err)
+	}
+	if len(store.Migrations) != 1 || store.Migrations[0].ID != m.ID {
+		t.Fatalf("replicated migrations = %+v, want %s", store.Migrations, m.ID)
+	}
+
+	// A replica taking over before the next tick completes it
+	standby := newReplicaProvisioner(config, &fakeCluster{store: store})
+	if err := standby.Promote(ctx); err != nil {
+		t.Fatalf("Promote: %v", err)
+	}
+	if got, ok := standby.migration("u1"); !ok || got.ToNodeID != "n2" {
+		t.Errorf("standby migration = %+v, %v; want one to n2", got, ok)
+	}
+}
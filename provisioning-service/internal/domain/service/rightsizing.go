@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/rightsizing"
+	"go.uber.org/zap"
+)
+
+// recordWorkload attributes a node's utilization sample to the user on it,
+// announcing any recommendation it changes
+func (p *Provisioner) recordWorkload(ctx context.Context, n *node.Node, sample node.Utilization) {
+	if p.recordSample(n, sample) {
+		p.announceRecommendation(ctx, n.Users[0])
+	}
+}
+
+// followWorkload records a utilization sample on a follower, attributed as
+// the leader last replicated the node, so a replica taking over recommends
+// from the same samples. The leader announces any change.
+func (p *Provisioner) followWorkload(event events.NodeUtilizationEvent) {
+	n, ok := p.nodePool.Get(event.NodeID)
+	if ok && p.recordSample(n, utilizationOf(event)) {
+		p.rightsizing.Update(n.Users[0])
+	}
+}
+
+// recordSample attributes a sample to the one user on a node, reporting
+// whether it did. The sample of a node shared by several users mixes their
+// workloads, so it is attributed to none of them.
+func (p *Provisioner) recordSample(n *node.Node, sample node.Utilization) bool {
+	if !p.rightsizing.Enabled() || len(n.Users) != 1 {
+		return false
+	}
+	instanceType := n.InstanceType
+	if instanceType == "" {
+		instanceType = p.predictor.Config().TypeOf(n)
+	}
+	p.rightsizing.RecordUtilization(n.Users[0], instanceType, sample.GPUPercent, sample.MemoryPercent, sample.ReportedAt)
+	return true
+}
+
+// RecommendInstanceType returns the instance type recommended for a user's
+// next allocation, and false if nothing is recorded for them
+func (p *Provisioner) RecommendInstanceType(userID string) (rightsizing.Recommendation, bool) {
+	return p.rightsizing.Recommend(userID)
+}
+
+// preferInstanceType records the recommended instance type as the one the
+// user's next allocation prefers, when auto-selection is on
+func (p *Provisioner) preferInstanceType(userID string) {
+	if !p.config.RightsizingAutoSelect {
+		return
+	}
+	r, _ := p.rightsizing.Recommend(userID)
+	p.userTracker.SetInstanceType(userID, r.Recommended)
+}
+
+// preferredPool returns the instance type preferred for a user when it is
+// one with a pool of its own, or "" otherwise
+func (p *Provisioner) preferredPool(userID string) string {
+	instanceType := p.userTracker.InstanceTypeOf(userID)
+	if _, ok := p.predictor.Config().InstanceTypes[instanceType]; !ok {
+		return ""
+	}
+	return instanceType
+}
+
+// provisionPreferredType provisions a node of the type preferred for a user
+// served by a node of another, so their next allocation finds one. Nothing
+// is provisioned while a node of the type is booting, or when its pool or
+// the budget has no room.
+func (p *Provisioner) provisionPreferredType(ctx context.Context, userID, nodeID, tier string) {
+	instanceType := p.preferredPool(userID)
+	if instanceType == "" {
+		return
+	}
+	if n, ok := p.nodePool.Get(nodeID); !ok || n.InstanceType == instanceType {
+		return
+	}
+
+	// The scaling check must not provision into the same room
+	p.scalingMu.Lock()
+	defer p.scalingMu.Unlock()
+
+	if p.nodePool.CountByStatusOfType(node.NodeStatusBooting, instanceType) > 0 ||
+		p.predictor.Room(instanceType) == 0 || p.budgetAllows(ctx, instanceType, 1) == 0 {
+		return
+	}
+	nodeIDs, err := p.provisionNodes(ctx, instanceType, p.userTracker.SelectorOf(userID), tier, 1, 1)
+	if err != nil && !errors.Is(err, ErrDryRun) {
+		p.logger.Warn("failed to provision recommended instance type",
+			zap.String("user_id", userID),
+			zap.String("instance_type", instanceType),
+			zap.Error(err),
+		)
+		return
+	}
+	if len(nodeIDs) > 0 {
+		p.logger.Info("provisioned recommended instance type for user's next allocation",
+			zap.String("user_id", userID),
+			zap.String("instance_type", instanceType),
+			zap.String("node_id", nodeIDs[0]),
+		)
+	}
+}
+
+// announceRecommendation publishes a user's recommendation on
+// user:instance_recommendation if it changed since it was last published
+func (p *Provisioner) announceRecommendation(ctx context.Context, userID string) {
+	r, changed := p.rightsizing.Update(userID)
+	if !changed {
+		return
+	}
+	p.logger.Info("instance type recommended",
+		zap.String("user_id", userID),
+		zap.String("instance_type", r.InstanceType),
+		zap.String("recommended", r.Recommended),
+		zap.String("direction", r.Direction),
+		zap.String("reason", r.Reason),
+	)
+
+	data, err := p.config.CloudEvents.Encode(events.ChannelInstanceRecommendation, events.InstanceRecommendationEvent{
+		SchemaVersion: events.CurrentSchemaVersion,
+		UserID:        userID,
+		InstanceType:  r.InstanceType,
+		Recommended:   r.Recommended,
+		Direction:     r.Direction,
+		Reason:        r.Reason,
+		AutoSelect:    p.config.RightsizingAutoSelect,
+		AverageGPU:    r.AverageGPU,
+		PeakMemory:    r.PeakMemory,
+		Timestamp:     time.Now().Unix(),
+	})
+	if err != nil {
+		p.logger.Error("failed to marshal instance recommendation", zap.Error(err))
+		return
+	}
+	if err := p.publisher.Publish(p.userContext(ctx, userID), events.ChannelInstanceRecommendation, string(data)); err != nil {
+		p.logger.Error("failed to publish instance recommendation",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+	"github.com/aos-cc/provisioning-service/internal/domain/rightsizing"
+)
+
+// withRightsizing sizes t4 below a100, recommending from a single sample
+func withRightsizing(p *testProvisioner) {
+	p.rightsizing = rightsizing.New(rightsizing.Config{
+		Sizes:         []string{"t4", "a100"},
+		Window:        time.Hour,
+		MinSamples:    1,
+		DownsizeBelow: 20,
+		UpsizeAbove:   80,
+	})
+}
+
+func TestSharedNodeUtilizationNotAttributed(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvisioner(Config{})
+	withRightsizing(p)
+	shared := readyNode("shared", "u1", "u2")
+	shared.InstanceType = "a100"
+	own := readyNode("own", "u3")
+	own.InstanceType = "a100"
+	p.pool.Replace([]node.Node{*shared, *own})
+
+	for _, id := range []string{"shared", "own"} {
+		if err := p.HandleNodeUtilization(ctx, events.NodeUtilizationEvent{NodeID: id, GPUPercent: 5}); err != nil {
+			t.Fatalf("HandleNodeUtilization(%s): %v", id, err)
+		}
+	}
+	for _, userID := range []string{"u1", "u2"} {
+		if r, ok := p.RecommendInstanceType(userID); ok {
+			t.Errorf("%s on a shared node recommended %+v", userID, r)
+		}
+	}
+	if r, _ := p.RecommendInstanceType("u3"); r.Recommended != "t4" {
+		t.Errorf("u3 recommended %q, want t4", r.Recommended)
+	}
+	if sent := p.publisher.on(events.ChannelInstanceRecommendation); len(sent) != 1 {
+		t.Errorf("published %d recommendations, want u3's", len(sent))
+	}
+}
+
+func TestFollowerRecordsWorkloadSilently(t *testing.T) {
+	p := newReplicaProvisioner(Config{}, &fakeCluster{store: &handoff.Snapshot{}})
+	withRightsizing(p)
+	n := readyNode("n1", "u1")
+	n.InstanceType = "a100"
+	p.pool.Replace([]node.Node{*n})
+
+	p.followWorkload(events.NodeUtilizationEvent{NodeID: "n1", GPUPercent: 5})
+	if r, _ := p.RecommendInstanceType("u1"); r.Recommended != "t4" {
+		t.Errorf("follower recommended %q, want t4", r.Recommended)
+	}
+	if sent := p.publisher.on(events.ChannelInstanceRecommendation); len(sent) != 0 {
+		t.Errorf("follower published %v", sent)
+	}
+}
+
+func TestProvisionPreferredType(t *testing.T) {
+	ctx := context.Background()
+	provider := &typedProvider{}
+	p := newTestProvisioner(Config{RightsizingAutoSelect: true}, NamedProvider{Name: "a", Provider: provider})
+	config := predictor.DefaultPredictionConfig()
+	config.InstanceTypes = map[string]predictor.InstanceTypePolicy{
+		"a100": {MaxReadyNodes: 2},
+		"t4":   {MaxReadyNodes: 2},
+	}
+	config.DefaultInstanceType = "a100"
+	p.predictor = predictor.NewPredictor(config, p.users, p.pool, nil, nil, nil)
+	n := readyNode("n1", "u1")
+	n.InstanceType = "a100"
+	p.pool.Replace([]node.Node{*n})
+	p.users.SetInstanceType("u1", "t4")
+
+	p.provisionPreferredType(ctx, "u1", "n1", "")
+	if len(provider.asked) != 1 || provider.asked[0] != "t4" {
+		t.Fatalf("asked for %v, want one t4", provider.asked)
+	}
+
+	// One booting node of the type is enough for the next allocation
+	p.provisionPreferredType(ctx, "u1", "n1", "")
+	if len(provider.asked) != 1 {
+		t.Errorf("asked for %v while a t4 was booting", provider.asked)
+	}
+
+	// A user already on the type needs none
+	n2 := readyNode("n2", "u2")
+	n2.InstanceType = "t4"
+	p.pool.Replace([]node.Node{*n2})
+	p.users.SetInstanceType("u2", "t4")
+	p.provisionPreferredType(ctx, "u2", "n2", "")
+	if len(provider.asked) != 1 {
+		t.Errorf("asked for %v for a user on the type", provider.asked)
+	}
+}
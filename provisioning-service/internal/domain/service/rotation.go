@@ -0,0 +1,51 @@
+package service
+
+import (
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// rotateAgedNodes drains nodes older than MaxNodeAge so they are replaced
+// before drifting too far from the image they booted from. Ready nodes are
+// rotated one per tick, so a fleet provisioned together does not lose its
+// whole warm pool at once; allocated nodes are terminated once their user
+// disconnects. Runs before the scaling check, which sees drained nodes as
+// unschedulable and provisions their replacements in the same tick.
+func (p *Provisioner) rotateAgedNodes() {
+	if p.config.MaxNodeAge <= 0 {
+		return
+	}
+
+	cutoff := time.Now().Add(-p.config.MaxNodeAge)
+	readyRotated := false
+
+	for _, n := range p.nodePool.GetAll() {
+		if n.Draining || !n.CreatedAt.Before(cutoff) {
+			continue
+		}
+
+		switch n.Status {
+		case node.NodeStatusAllocated, node.NodeStatusUnconfirmed:
+		case node.NodeStatusReady:
+			// Let a pending reservation be claimed or lapse first
+			if readyRotated || n.IsReserved() {
+				continue
+			}
+			readyRotated = true
+		default:
+			continue
+		}
+
+		if !p.nodePool.MarkDraining(n.ID, node.TerminationRotated) {
+			continue
+		}
+
+		p.logger.Info("rotating node past max age",
+			zap.String("node_id", n.ID),
+			zap.String("status", string(n.Status)),
+			zap.Duration("age", time.Since(n.CreatedAt)),
+		)
+	}
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/schedule"
+	"go.uber.org/zap"
+)
+
+// ErrScheduleDisabled is returned when scheduled sessions are not planned for
+var ErrScheduleDisabled = errcode.New(errcode.NotFound, "scheduled sessions are not enabled")
+
+// ScheduleSnapshot is the upcoming sessions and the attendees they bring
+// within the prediction window
+type ScheduleSnapshot struct {
+	Sessions []schedule.Session
+	Expected schedule.Estimate
+}
+
+// Schedule returns the upcoming scheduled sessions
+func (p *Provisioner) Schedule() (ScheduleSnapshot, error) {
+	sched := p.predictor.Schedule()
+	if sched == nil {
+		return ScheduleSnapshot{}, ErrScheduleDisabled
+	}
+	now := time.Now()
+	return ScheduleSnapshot{
+		Sessions: sched.Upcoming(now),
+		Expected: sched.Expected(now.Add(p.predictor.PredictionWindow(""))),
+	}, nil
+}
+
+// SetSessions replaces the sessions pushed through the admin API; feed
+// sessions are kept. The sessions are replicated and handed off with the
+// pool, so they outlive this replica.
+func (p *Provisioner) SetSessions(ctx context.Context, sessions []schedule.Session) error {
+	sched := p.predictor.Schedule()
+	if sched == nil {
+		return ErrScheduleDisabled
+	}
+	if err := sched.Replace(schedule.SourceAPI, sessions); err != nil {
+		return errcode.Wrap(errcode.InvalidRequest, err)
+	}
+	p.logger.Info("scheduled sessions replaced", zap.Int("sessions", len(sessions)))
+	p.replicateChange(ctx)
+	return nil
+}
+
+// apiSessions returns the sessions pushed through the admin API, for the
+// replicated and handed off state
+func (p *Provisioner) apiSessions() []schedule.Session {
+	sched := p.predictor.Schedule()
+	if sched == nil {
+		return nil
+	}
+	return sched.Sessions(schedule.SourceAPI)
+}
+
+// restoreSessions takes over the sessions pushed through the admin API to a
+// previous leader or service
+func (p *Provisioner) restoreSessions(sessions []schedule.Session) {
+	sched := p.predictor.Schedule()
+	if sched == nil {
+		return
+	}
+	if err := sched.Replace(schedule.SourceAPI, sessions); err != nil {
+		p.logger.Warn("scheduled sessions not restored", zap.Error(err))
+	}
+}
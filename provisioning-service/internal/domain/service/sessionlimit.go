@@ -0,0 +1,132 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"go.uber.org/zap"
+)
+
+// ErrSessionLimit is returned for a connect that would open more sessions
+// than the user's limit allows
+var ErrSessionLimit = errcode.New(errcode.SessionLimit, "user has reached their concurrent session limit")
+
+// SessionLimit bounds the sessions a user holds at once, e.g. from several
+// devices. Sessions of one user share the node they hold.
+type SessionLimit struct {
+	MaxPerUser int            // Sessions a user holds at once; 0 for no limit
+	Tiers      map[string]int // In place of MaxPerUser for users of these tiers
+}
+
+// sessionLimit returns the sessions a user may hold at once, 0 for no
+// limit. Users without a tier fall under the default tier.
+func (p *Provisioner) sessionLimit(userID string) int {
+	tier := cmp.Or(p.userTracker.TierOf(userID), p.config.DefaultTier)
+	if limit, ok := p.config.SessionLimit.Tiers[tier]; ok {
+		return limit
+	}
+	return p.config.SessionLimit.MaxPerUser
+}
+
+// openSession records the session a connect carries for a user holding a
+// node, reporting whether the user holds it. A session past the user's
+// limit is refused, and the connect answered as failed.
+func (p *Provisioner) openSession(ctx context.Context, event events.UserConnectEvent, nodeID string) bool {
+	p.takeWaitingConnect(event.UserID)
+	limit := p.sessionLimit(event.UserID)
+	opened, held := p.userTracker.OpenSession(event.UserID, event.SessionID, limit, time.Now())
+	if opened {
+		p.logger.Debug("user session opened",
+			zap.String("user_id", event.UserID),
+			zap.String("session_id", event.SessionID),
+			zap.String("node_id", nodeID),
+		)
+	}
+	if held {
+		return true
+	}
+
+	p.logger.Warn("user session refused past limit",
+		zap.String("user_id", event.UserID),
+		zap.String("session_id", event.SessionID),
+		zap.Int("limit", limit),
+	)
+	p.replyAllocation(ctx, event, events.AllocationResultEvent{
+		Status: events.AllocationStatusFailed,
+		Reason: ErrSessionLimit.Error(),
+		Code:   string(errcode.SessionLimit),
+	})
+	p.publishAllocationFailed(ctx, event, events.FailureSessionLimit, errcode.SessionLimit, ErrSessionLimit, 0)
+	return false
+}
+
+// waitConnect remembers a connect left waiting for a node, so its session
+// is opened and its reply sent once the wait is served
+func (p *Provisioner) waitConnect(event events.UserConnectEvent) {
+	p.sessionsMu.Lock()
+	defer p.sessionsMu.Unlock()
+	p.waitingConnects[event.UserID] = event
+}
+
+// takeWaitingConnect returns and forgets the connect a user waits with
+func (p *Provisioner) takeWaitingConnect(userID string) (events.UserConnectEvent, bool) {
+	p.sessionsMu.Lock()
+	defer p.sessionsMu.Unlock()
+	event, ok := p.waitingConnects[userID]
+	delete(p.waitingConnects, userID)
+	return event, ok
+}
+
+// openWaitingSession opens the session of a connect left waiting and
+// served without a new one, as from a shared node, like a connect served at
+// once does. The user held no node while waiting, so the limit only refuses
+// a session opened meanwhile, such as by a reconnect.
+func (p *Provisioner) openWaitingSession(event events.UserConnectEvent, nodeID string) {
+	userID, sessionID := event.UserID, event.SessionID
+	opened, held := p.userTracker.OpenSession(userID, sessionID, p.sessionLimit(userID), time.Now())
+	switch {
+	case opened:
+		p.logger.Debug("user session opened",
+			zap.String("user_id", userID),
+			zap.String("session_id", sessionID),
+			zap.String("node_id", nodeID),
+		)
+	case !held:
+		p.logger.Warn("waiting user's session not opened past limit",
+			zap.String("user_id", userID),
+			zap.String("session_id", sessionID),
+		)
+	}
+}
+
+// closeSession ends the session a disconnect carries, reporting whether
+// the user keeps their node for their other sessions. A disconnect without
+// a session, or ending the user's last one, releases the node. One for a
+// session the user does not hold, such as a connect refused past the
+// limit, is ignored while they hold others.
+func (p *Provisioner) closeSession(event events.UserDisconnectEvent) bool {
+	if event.SessionID == "" {
+		return false
+	}
+	remaining, closed := p.userTracker.CloseSession(event.UserID, event.SessionID)
+	if remaining == 0 {
+		return false
+	}
+	if closed {
+		p.logger.Info("user session closed, node kept for other sessions",
+			zap.String("user_id", event.UserID),
+			zap.String("session_id", event.SessionID),
+			zap.Int("sessions", remaining),
+		)
+	} else {
+		p.logger.Info("ignoring disconnect of unknown session",
+			zap.String("user_id", event.UserID),
+			zap.String("session_id", event.SessionID),
+			zap.Int("sessions", remaining),
+		)
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+func TestWaitingSessionOpenedOnSharedNode(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvisioner(Config{SessionLimit: SessionLimit{MaxPerUser: 2}})
+
+	// No node is ready, so the phone's connect waits
+	p.HandleUserConnect(ctx, events.UserConnectEvent{UserID: "u1", SessionID: "phone"})
+	if _, ok := p.slo.Pending()["u1"]; !ok {
+		t.Fatal("connect did not wait for a node")
+	}
+
+	shared := readyNode("n1", "other")
+	shared.Capacity = 2
+	p.pool.Replace([]node.Node{*shared})
+	if _, err := p.allocateSharedNode(ctx, "u1"); err != nil {
+		t.Fatalf("allocateSharedNode: %v", err)
+	}
+	if sessions := p.users.SessionsOf("u1"); len(sessions) != 1 || sessions[0].ID != "phone" {
+		t.Fatalf("sessions = %v, want the waiting connect's", sessions)
+	}
+
+	// The disconnect of a session the user never opened keeps their node
+	if err := p.HandleUserDisconnect(ctx, events.UserDisconnectEvent{UserID: "u1", SessionID: "tablet"}); err != nil {
+		t.Fatalf("HandleUserDisconnect: %v", err)
+	}
+	if n, _ := p.pool.Get("n1"); !n.HasUser("u1") {
+		t.Error("node released while the phone's session is open")
+	}
+}
+
+func TestWaitingSessionForgottenOnDisconnect(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvisioner(Config{})
+
+	p.HandleUserConnect(ctx, events.UserConnectEvent{UserID: "u1", SessionID: "phone"})
+	p.HandleUserDisconnect(ctx, events.UserDisconnectEvent{UserID: "u1", SessionID: "phone"})
+	if _, ok := p.takeWaitingConnect("u1"); ok {
+		t.Error("session of a user who stopped waiting kept")
+	}
+}
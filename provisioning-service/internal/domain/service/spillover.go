@@ -0,0 +1,110 @@
+package service
+
+import (
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// Spillover is where nodes are asked for when a provider has no capacity
+type Spillover struct {
+	// Zones are asked in order for each instance type; empty lets the
+	// provider choose the zone
+	Zones []string
+
+	// InstanceTypes maps an instance type to alternatives asked in order,
+	// in every zone, once the type itself has no capacity in any
+	InstanceTypes map[string][]string
+
+	// ZoneCooldown is how long a provider's zone out of capacity for an
+	// instance type is passed over by later creations
+	ZoneCooldown time.Duration
+}
+
+// placement is an instance type and zone nodes are asked for
+type placement struct {
+	instanceType string
+	zone         string // Empty lets the provider choose
+}
+
+// exhaustion is a placement a provider reported out of capacity
+type exhaustion struct {
+	provider string
+	placement
+}
+
+// placements returns where a provider is asked for nodes of an instance
+// type, in order: each zone for the type, then for each alternative type.
+// Placements still cooling down from a capacity error are passed over,
+// unless that leaves none.
+func (p *Provisioner) placements(provider, instanceType string) []placement {
+	zones := p.config.Spillover.Zones
+	if len(zones) == 0 {
+		zones = []string{""}
+	}
+
+	var all []placement
+	for _, t := range append([]string{instanceType}, p.config.Spillover.InstanceTypes[instanceType]...) {
+		for _, zone := range zones {
+			all = append(all, placement{instanceType: t, zone: zone})
+		}
+	}
+
+	p.exhaustedMu.Lock()
+	defer p.exhaustedMu.Unlock()
+
+	now := time.Now()
+	available := slices.DeleteFunc(slices.Clone(all), func(at placement) bool {
+		return now.Before(p.exhausted[exhaustion{provider: provider, placement: at}])
+	})
+	if len(available) == 0 {
+		return all
+	}
+	return available
+}
+
+// recordExhausted passes over a placement a provider had no capacity for
+// until the zone cooldown ends. A zone the provider names in its error is
+// passed over too when it chose the zone itself.
+func (p *Provisioner) recordExhausted(provider string, at placement, err error) {
+	zone := at.zone
+	var capacityErr *node.CapacityError
+	if errors.As(err, &capacityErr) && capacityErr.Zone != "" {
+		zone = capacityErr.Zone
+	}
+	p.providerObserver.ObserveCapacityError(provider, at.instanceType, zone)
+
+	until := time.Now().Add(p.config.Spillover.ZoneCooldown)
+	p.exhaustedMu.Lock()
+	defer p.exhaustedMu.Unlock()
+
+	p.exhausted[exhaustion{provider: provider, placement: at}] = until
+	if zone != at.zone {
+		p.exhausted[exhaustion{provider: provider, placement: placement{instanceType: at.instanceType, zone: zone}}] = until
+	}
+
+	// Forget placements that cooled down
+	now := time.Now()
+	for key, until := range p.exhausted {
+		if !now.Before(until) {
+			delete(p.exhausted, key)
+		}
+	}
+}
+
+// logSpillover logs nodes being asked for in the next placement after a
+// capacity error
+func (p *Provisioner) logSpillover(provider string, from, to placement, remaining int, err error) {
+	p.logger.Warn("node provider out of capacity, spilling over",
+		zap.String("provider", provider),
+		zap.String("instance_type", from.instanceType),
+		zap.String("zone", from.zone),
+		zap.String("next_instance_type", to.instanceType),
+		zap.String("next_zone", to.zone),
+		zap.Int("remaining", remaining),
+		zap.Error(err),
+	)
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+)
+
+// typedProvider has no capacity for some instance types, and creates nodes
+// of the others
+type typedProvider struct {
+	stubProvider
+	exhausted []string
+	asked     []string // Instance types asked for, in order
+	created   int
+}
+
+func (p *typedProvider) ProvisionNode(ctx context.Context, instanceType, zone, purchase string, labels map[string]string) (string, error) {
+	p.asked = append(p.asked, instanceType)
+	if slices.Contains(p.exhausted, instanceType) {
+		return "", &node.CapacityError{InstanceType: instanceType, Zone: zone}
+	}
+	p.created++
+	return fmt.Sprintf("%s-%d", instanceType, p.created), nil
+}
+
+func TestSpilledNodeCountedInRequestingPool(t *testing.T) {
+	ctx := context.Background()
+	provider := &typedProvider{exhausted: []string{"a100"}}
+	p := newTestProvisioner(Config{
+		Spillover: Spillover{InstanceTypes: map[string][]string{"a100": {"h100"}}},
+	}, NamedProvider{Name: "a", Provider: provider})
+
+	nodeIDs, err := p.provisionNodes(ctx, "a100", nil, "", 1, 1)
+	if err != nil {
+		t.Fatalf("provisionNodes: %v", err)
+	}
+	if fmt.Sprint(provider.asked) != "[a100 h100]" {
+		t.Fatalf("asked for %v, want a100 then h100", provider.asked)
+	}
+	n, _ := p.pool.Get(nodeIDs[0])
+	if n.InstanceType != "h100" || n.PoolType != "a100" {
+		t.Errorf("spilled node has type %q in pool %q, want h100 in a100", n.InstanceType, n.PoolType)
+	}
+
+	// A node of the type asked for belongs to its own pool
+	provider.exhausted = nil
+	nodeIDs, err = p.provisionNodes(ctx, "a100", nil, "", 1, 1)
+	if err != nil {
+		t.Fatalf("provisionNodes: %v", err)
+	}
+	if n, _ := p.pool.Get(nodeIDs[0]); n.PoolType != "" {
+		t.Errorf("node of the type asked for has pool %q", n.PoolType)
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// Hydrate loads the state kept from before a restart: the pool and users
+// handed off by the previous service, or else the connected users saved
+func (p *Provisioner) Hydrate(ctx context.Context) error {
+	tookOver, err := p.TakeOver(ctx)
+	if err != nil {
+		p.logger.Error("handoff not taken over; restoring connected users", zap.Error(err))
+	}
+	if tookOver {
+		return nil
+	}
+	return p.RestoreUsers(ctx)
+}
+
+// Reconcile asks the providers about every node hydrated and terminates
+// those they no longer have, which went away while the service was down.
+// Nodes a provider could not be asked about are kept; their status events
+// or the stuck node cleanup settle them. A standby leaves its pool to the
+// leader's replicated state.
+func (p *Provisioner) Reconcile(ctx context.Context) error {
+	if !p.IsLeader() {
+		return nil
+	}
+
+	checked, vanished := 0, 0
+	for _, n := range p.nodePool.Snapshot() {
+		if n.Status == node.NodeStatusTerminating || n.Status == node.NodeStatusTerminated {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		checked++
+		if p.providerLost(ctx, &n) && p.terminateVanished(ctx, n.ID) {
+			vanished++
+		}
+	}
+
+	p.logger.Info("reconciled pool with providers",
+		zap.Int("checked", checked),
+		zap.Int("vanished", vanished),
+	)
+	return nil
+}
+
+// providerLost reports whether the provider that created a node, or every
+// provider for a node not known to come from one, says it has no such node.
+// Only an answer that the node is gone counts: a provider that fails or
+// cannot look nodes up keeps it.
+func (p *Provisioner) providerLost(ctx context.Context, n *node.Node) bool {
+	asked := 0
+	for _, provider := range p.providers {
+		if n.Provider != "" && provider.Name != n.Provider {
+			continue
+		}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, diagnosticsTimeout)
+		exists, err := provider.Provider.NodeExists(lookupCtx, n.ID)
+		cancel()
+		if err != nil {
+			p.logger.Warn("failed to reconcile node with provider; keeping it",
+				zap.String("node_id", n.ID),
+				zap.String("provider", provider.Name),
+				zap.Error(err),
+			)
+			return false
+		}
+		if exists {
+			return false
+		}
+		asked++
+	}
+	return asked > 0
+}
+
+// terminateVanished marks a node its provider no longer has as terminated,
+// reporting false if it was torn down in the meantime
+func (p *Provisioner) terminateVanished(ctx context.Context, nodeID string) bool {
+	unlock := p.locks.lock(nodeID)
+	defer unlock()
+
+	n, ok := p.nodePool.Get(nodeID)
+	if !ok || n.Status == node.NodeStatusTerminating || n.Status == node.NodeStatusTerminated {
+		return false
+	}
+	from := n.Status
+	p.nodePool.MarkTerminated(nodeID, node.TerminationVanished)
+	p.recordTermination(ctx, n, from, node.TerminationVanished)
+	p.allocator.ForgetNode(ctx, nodeID)
+	return true
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// stubProvider is a node provider that has the nodes in exists, and fails
+// lookups with err when set. It records the nodes it is asked to terminate.
+type stubProvider struct {
+	exists     map[string]bool
+	err        error
+	terminated []string
+	provisions int // Calls to create nodes, which all fail
+}
+
+func (s *stubProvider) ProvisionNode(ctx context.Context, instanceType, zone, purchase string, labels map[string]string) (string, error) {
+	s.provisions++
+	return "", errors.New("not implemented")
+}
+
+func (s *stubProvider) ProvisionNodes(ctx context.Context, instanceType, zone, purchase string, labels map[string]string, count int) ([]string, error) {
+	s.provisions++
+	return nil, errors.New("not implemented")
+}
+
+func (s *stubProvider) TerminateNode(ctx context.Context, nodeID string) error {
+	s.terminated = append(s.terminated, nodeID)
+	return nil
+}
+
+func (s *stubProvider) GetNodeDiagnostics(ctx context.Context, nodeID string) (node.Diagnostics, error) {
+	return node.Diagnostics{}, nil
+}
+
+func (s *stubProvider) NodeExists(ctx context.Context, nodeID string) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+	return s.exists[nodeID], nil
+}
+
+func TestProviderLost(t *testing.T) {
+	unsupported := errors.New("not supported by the node api")
+
+	tests := []struct {
+		name      string
+		providers []NamedProvider
+		node      node.Node
+		lost      bool
+	}{
+		{
+			name:      "found",
+			providers: []NamedProvider{{Name: "a", Provider: &stubProvider{exists: map[string]bool{"n1": true}}}},
+			node:      node.Node{ID: "n1", Provider: "a"},
+		},
+		{
+			name:      "not found",
+			providers: []NamedProvider{{Name: "a", Provider: &stubProvider{}}},
+			node:      node.Node{ID: "n1", Provider: "a"},
+			lost:      true,
+		},
+		{
+			name:      "lookup unsupported",
+			providers: []NamedProvider{{Name: "a", Provider: &stubProvider{err: unsupported}}},
+			node:      node.Node{ID: "n1", Provider: "a"},
+		},
+		{
+			name: "other provider not asked",
+			providers: []NamedProvider{
+				{Name: "a", Provider: &stubProvider{exists: map[string]bool{"n1": true}}},
+				{Name: "b", Provider: &stubProvider{}},
+			},
+			node: node.Node{ID: "n1", Provider: "a"},
+		},
+		{
+			name: "unknown provider, one has it",
+			providers: []NamedProvider{
+				{Name: "a", Provider: &stubProvider{}},
+				{Name: "b", Provider: &stubProvider{exists: map[string]bool{"n1": true}}},
+			},
+			node: node.Node{ID: "n1"},
+		},
+		{
+			name: "unknown provider, one cannot tell",
+			providers: []NamedProvider{
+				{Name: "a", Provider: &stubProvider{}},
+				{Name: "b", Provider: &stubProvider{err: unsupported}},
+			},
+			node: node.Node{ID: "n1"},
+		},
+		{
+			name: "unknown provider, none has it",
+			providers: []NamedProvider{
+				{Name: "a", Provider: &stubProvider{}},
+				{Name: "b", Provider: &stubProvider{}},
+			},
+			node: node.Node{ID: "n1"},
+			lost: true,
+		},
+		{
+			name:      "provider gone from config",
+			providers: []NamedProvider{{Name: "b", Provider: &stubProvider{}}},
+			node:      node.Node{ID: "n1", Provider: "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Provisioner{providers: tt.providers, logger: zap.NewNop()}
+			if got := p.providerLost(t.Context(), &tt.node); got != tt.lost {
+				t.Errorf("providerLost = %v, want %v", got, tt.lost)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// StateImport counts what an import replaced the pool and tracker with
+type StateImport struct {
+	Nodes     int
+	Users     int
+	Connected int
+	Replaced  int // Nodes left out of the import, terminated
+}
+
+// ExportState captures the pool, the allocations and every tracked user,
+// for another service to import. Node auth tokens are left out; the
+// importing service keeps the tokens of nodes it already has.
+func (p *Provisioner) ExportState() handoff.Export {
+	snapshot := p.snapshot()
+	for i := range snapshot.Nodes {
+		snapshot.Nodes[i].Endpoint.AuthToken = ""
+	}
+	return handoff.Export{
+		SchemaVersion: handoff.ExportVersion,
+		ExportedAt:    snapshot.WrittenAt,
+		Nodes:         snapshot.Nodes,
+		Users:         p.userTracker.States(),
+	}
+}
+
+// ImportState replaces the pool and the tracked users with exported state,
+// then persists and replicates it. Nodes keep the status, users and
+// timestamps they had, and their next status events bring them up to date.
+// Nodes of the current pool the export leaves out are terminated first, so
+// none is left running untracked; if one cannot be, nothing is imported.
+// The claims on the pool's slots are rebuilt from the imported users, and
+// migrations in flight and users waiting for a node are dropped, since
+// they describe the replaced allocations.
+func (p *Provisioner) ImportState(ctx context.Context, export handoff.Export) (StateImport, error) {
+	if !p.IsLeader() {
+		return StateImport{}, ErrNotLeader
+	}
+	if err := validateExport(export); err != nil {
+		return StateImport{}, errcode.Wrap(errcode.InvalidRequest, err)
+	}
+
+	replaced, err := p.terminateReplaced(ctx, export.Nodes)
+	if err != nil {
+		return StateImport{}, err
+	}
+
+	for i := range export.Nodes {
+		n := &export.Nodes[i]
+		if current, ok := p.nodePool.Get(n.ID); ok && n.Endpoint.AuthToken == "" {
+			n.Endpoint.AuthToken = current.Endpoint.AuthToken
+		}
+	}
+	p.nodePool.Replace(export.Nodes)
+	p.userTracker.Replace(export.Users)
+	p.allocator.RestoreClaims(ctx, export.Nodes)
+
+	p.migrationsMu.Lock()
+	migrations := len(p.migrations)
+	clear(p.migrations)
+	p.migrationsMu.Unlock()
+	p.slo.ForgetPending()
+
+	p.saveUsers(ctx)
+	p.replicate(ctx)
+
+	result := StateImport{Nodes: len(export.Nodes), Users: len(export.Users), Replaced: replaced}
+	for _, u := range export.Users {
+		if u.IsConnected {
+			result.Connected++
+		}
+	}
+	p.logger.Info("imported state",
+		zap.Int("nodes", result.Nodes),
+		zap.Int("users", result.Users),
+		zap.Int("connected", result.Connected),
+		zap.Int("replaced_nodes", result.Replaced),
+		zap.Int("dropped_migrations", migrations),
+		zap.Time("exported_at", export.ExportedAt),
+	)
+	return result, nil
+}
+
+// terminateReplaced terminates the nodes of the pool an import leaves out,
+// with the users still on them, returning how many it terminated
+func (p *Provisioner) terminateReplaced(ctx context.Context, imported []node.Node) (int, error) {
+	keep := make(map[string]bool, len(imported))
+	for _, n := range imported {
+		keep[n.ID] = true
+	}
+
+	replaced := 0
+	for _, n := range p.nodePool.Snapshot() {
+		if keep[n.ID] || n.Status == node.NodeStatusTerminating || n.Status == node.NodeStatusTerminated {
+			continue
+		}
+		terminated, err := p.terminateNode(ctx, n.ID, node.TerminationImport, true,
+			node.NodeStatusBooting, node.NodeStatusReady, node.NodeStatusUnconfirmed, node.NodeStatusAllocated)
+		if err != nil {
+			return replaced, fmt.Errorf("failed to terminate node %s left out of the import: %w", n.ID, err)
+		}
+		if terminated {
+			replaced++
+		}
+	}
+	return replaced, nil
+}
+
+// validateExport checks that nodes and users are identified once each and
+// that every connected user is allocated a node the state holds
+func validateExport(export handoff.Export) error {
+	nodes := make(map[string]bool, len(export.Nodes))
+	for _, n := range export.Nodes {
+		if n.ID == "" {
+			return fmt.Errorf("node without an id")
+		}
+		if nodes[n.ID] {
+			return fmt.Errorf("node %s appears more than once", n.ID)
+		}
+		nodes[n.ID] = true
+	}
+
+	users := make(map[string]bool, len(export.Users))
+	for _, u := range export.Users {
+		if u.UserID == "" {
+			return fmt.Errorf("user without an id")
+		}
+		if users[u.UserID] {
+			return fmt.Errorf("user %s appears more than once", u.UserID)
+		}
+		users[u.UserID] = true
+		if u.IsConnected && u.AllocatedNodeID != "" && !nodes[u.AllocatedNodeID] {
+			return fmt.Errorf("user %s is allocated node %s, which the state does not hold", u.UserID, u.AllocatedNodeID)
+		}
+	}
+	return nil
+}
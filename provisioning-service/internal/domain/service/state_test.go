@@ -0,0 +1,107 @@
+package service
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/user"
+)
+
+func readyNode(id string, users ...string) *node.Node {
+	n := &node.Node{
+		ID:        id,
+		Status:    node.NodeStatusReady,
+		Provider:  "a",
+		Users:     users,
+		Endpoint:  node.Endpoint{Address: "10.0.0.1", Port: 9000, AuthToken: "secret-" + id},
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if len(users) > 0 {
+		n.Status = node.NodeStatusAllocated
+	}
+	return n
+}
+
+func TestExportStateRedactsAuthTokens(t *testing.T) {
+	p := newTestProvisioner(Config{})
+	p.pool.Add(readyNode("n1"))
+
+	export := p.ExportState()
+	if len(export.Nodes) != 1 {
+		t.Fatalf("exported %d nodes, want 1", len(export.Nodes))
+	}
+	if token := export.Nodes[0].Endpoint.AuthToken; token != "" {
+		t.Errorf("exported auth token %q", token)
+	}
+	if n, _ := p.pool.Get("n1"); n.Endpoint.AuthToken != "secret-n1" {
+		t.Errorf("export cleared the pool's auth token")
+	}
+}
+
+func TestImportStateReplacesPool(t *testing.T) {
+	provider := &stubProvider{}
+	p := newTestProvisioner(Config{}, NamedProvider{Name: "a", Provider: provider})
+	p.pool.Add(readyNode("kept"))
+	p.pool.Add(readyNode("left-out", "u-old"))
+	p.users.MarkConnected("u-old", "left-out")
+	p.migrations["u-old"] = Migration{ID: "m1", UserID: "u-old", FromNodeID: "left-out", ToNodeID: "kept"}
+	p.slo.ConnectMissed("u-waiting")
+
+	kept := *readyNode("kept", "u1")
+	kept.Endpoint.AuthToken = ""
+	export := handoff.Export{
+		SchemaVersion: handoff.ExportVersion,
+		Nodes:         []node.Node{kept, *readyNode("new")},
+		Users:         []user.UserState{{UserID: "u1", IsConnected: true, AllocatedNodeID: "kept"}},
+	}
+
+	result, err := p.ImportState(t.Context(), export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Replaced != 1 || result.Nodes != 2 || result.Connected != 1 {
+		t.Errorf("result = %+v", result)
+	}
+	if !slices.Equal(provider.terminated, []string{"left-out"}) {
+		t.Errorf("terminated %v, want [left-out]", provider.terminated)
+	}
+	if _, ok := p.pool.Get("left-out"); ok {
+		t.Error("left-out node still in the pool")
+	}
+	if n, ok := p.pool.Get("kept"); !ok || n.Endpoint.AuthToken != "secret-kept" {
+		t.Errorf("kept node lost its auth token: %+v", n)
+	}
+	if got := p.Migrations(); len(got) != 0 {
+		t.Errorf("migrations survived the import: %+v", got)
+	}
+	if waiting := p.slo.Waiting(); len(waiting) != 0 {
+		t.Errorf("waiting users survived the import: %v", waiting)
+	}
+	if nodeID, ok := p.allocator.GetAllocation("u1"); !ok || nodeID != "kept" {
+		t.Errorf("u1 allocated %q, %v; want kept", nodeID, ok)
+	}
+}
+
+func TestImportStateRejectsUnknownAllocation(t *testing.T) {
+	provider := &stubProvider{}
+	p := newTestProvisioner(Config{}, NamedProvider{Name: "a", Provider: provider})
+	p.pool.Add(readyNode("n1"))
+
+	_, err := p.ImportState(t.Context(), handoff.Export{
+		SchemaVersion: handoff.ExportVersion,
+		Users:         []user.UserState{{UserID: "u1", IsConnected: true, AllocatedNodeID: "missing"}},
+	})
+	if err == nil {
+		t.Fatal("import succeeded")
+	}
+	if len(provider.terminated) != 0 {
+		t.Errorf("a rejected import terminated %v", provider.terminated)
+	}
+	if _, ok := p.pool.Get("n1"); !ok {
+		t.Error("a rejected import changed the pool")
+	}
+}
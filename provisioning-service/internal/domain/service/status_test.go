@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+)
+
+func TestReportedCapacityKept(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProvisioner(Config{})
+	config := predictor.DefaultPredictionConfig()
+	config.InstanceTypes = map[string]predictor.InstanceTypePolicy{
+		"a100": {MaxReadyNodes: 2, UsersPerNode: 4},
+	}
+	p.predictor = predictor.NewPredictor(config, p.users, p.pool, nil, nil, nil)
+	p.pool.Replace([]node.Node{*readyNode("reported"), *readyNode("typed")})
+
+	updates := []events.NodeStatusEvent{
+		{NodeID: "reported", Capacity: 2},
+		{NodeID: "reported", InstanceType: "a100"},
+		{NodeID: "typed", InstanceType: "a100"},
+	}
+	for _, event := range updates {
+		if err := p.HandleNodeStatus(ctx, event); err != nil {
+			t.Fatalf("HandleNodeStatus(%+v): %v", event, err)
+		}
+	}
+	if n, _ := p.pool.Get("reported"); n.Capacity != 2 {
+		t.Errorf("reported capacity = %d after the type was reported, want 2", n.Capacity)
+	}
+	if n, _ := p.pool.Get("typed"); n.Capacity != 4 {
+		t.Errorf("capacity = %d, want the type's 4", n.Capacity)
+	}
+
+	// A later report replaces the earlier one
+	if err := p.HandleNodeStatus(ctx, events.NodeStatusEvent{NodeID: "reported", Capacity: 3}); err != nil {
+		t.Fatalf("HandleNodeStatus: %v", err)
+	}
+	if n, _ := p.pool.Get("reported"); n.Capacity != 3 {
+		t.Errorf("capacity = %d, want the new report's 3", n.Capacity)
+	}
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// retireSurplusNodes terminates ready nodes the forecast says will not be
+// needed, ahead of their idle timeout. At most SurplusStep nodes go per
+// scale-down cooldown, so surplus capacity decays gradually and a forecast
+// that turns out low is caught by the next scale-up before the pool is gone.
+func (p *Provisioner) retireSurplusNodes(ctx context.Context) {
+	if p.CooldownState().ScaleDownRemaining > 0 {
+		return
+	}
+
+	retired := 0
+	for _, n := range p.predictor.SurplusNodes() {
+		if p.terminationLimited() {
+			p.deferTermination(n, node.TerminationScaleDown)
+			continue
+		}
+
+		p.logger.Info("retiring surplus node",
+			zap.String("node_id", n.ID),
+			zap.Duration("idle_duration", time.Since(n.UpdatedAt)),
+		)
+
+		terminated, err := p.terminateNode(ctx, n.ID, node.TerminationScaleDown, false, node.NodeStatusReady)
+		if errors.Is(err, ErrDryRun) {
+			continue
+		}
+		if err != nil {
+			p.logger.Error("failed to retire surplus node",
+				zap.String("node_id", n.ID),
+				zap.Error(err),
+			)
+			continue
+		}
+		if !terminated {
+			p.logger.Info("surplus node was claimed before termination",
+				zap.String("node_id", n.ID),
+			)
+			continue
+		}
+		retired++
+		p.terminations++
+	}
+
+	if retired > 0 {
+		p.recordScaleDown()
+	}
+}
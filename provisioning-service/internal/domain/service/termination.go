@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// TerminationObserver is notified of every node termination, e.g. to count
+// what drives churn
+type TerminationObserver interface {
+	ObserveNodeTermination(reason, instanceType string)
+	ObserveTerminationDeferred(reason string)
+	ObserveNodesPurged(count int)
+}
+
+// terminationLimited reports whether this tick has terminated as many
+// healthy nodes as MaxTerminationsPerTick allows. Only consulted from the
+// provisioner loop.
+func (p *Provisioner) terminationLimited() bool {
+	return p.config.MaxTerminationsPerTick > 0 && p.terminations >= p.config.MaxTerminationsPerTick
+}
+
+// deferTermination leaves a node due for termination to a later tick
+// because of the per-tick limit
+func (p *Provisioner) deferTermination(n *node.Node, reason node.TerminationReason) {
+	p.deferredTerminations++
+	p.terminationObserver.ObserveTerminationDeferred(string(reason))
+	p.logger.Debug("node termination deferred by per-tick limit",
+		zap.String("node_id", n.ID),
+		zap.String("reason", string(reason)),
+	)
+}
+
+// resetTerminations starts a tick's termination count, reporting the nodes
+// the previous tick deferred
+func (p *Provisioner) resetTerminations() {
+	if p.deferredTerminations > 0 {
+		p.logger.Warn("termination limit reached; deferred nodes to later ticks",
+			zap.Int("terminated", p.terminations),
+			zap.Int("deferred", p.deferredTerminations),
+			zap.Int("limit", p.config.MaxTerminationsPerTick),
+		)
+	}
+	p.terminations = 0
+	p.deferredTerminations = 0
+}
+
+// recordTermination reports a terminated node and why to the observer, the
+// operations feed and the node terminated channel
+func (p *Provisioner) recordTermination(ctx context.Context, n *node.Node, from node.NodeStatus, reason node.TerminationReason) {
+	p.terminationObserver.ObserveNodeTermination(string(reason), n.InstanceType)
+	p.emitTransition(n, p.publishedStatus(n.ID, from), node.NodeStatusTerminated, string(reason))
+
+	p.logger.Info("node terminated",
+		zap.String("node_id", n.ID),
+		zap.String("reason", string(reason)),
+		zap.String("previous_status", string(from)),
+	)
+
+	now := time.Now()
+	data, err := p.config.CloudEvents.Encode(events.ChannelNodeTerminated, events.NodeTerminatedEvent{
+		SchemaVersion:  events.CurrentSchemaVersion,
+		NodeID:         n.ID,
+		Reason:         string(reason),
+		PreviousStatus: string(from),
+		InstanceType:   n.InstanceType,
+		Provider:       n.Provider,
+		UserIDs:        slices.Clone(n.Users),
+		AgeSeconds:     now.Sub(n.CreatedAt).Seconds(),
+		Timestamp:      now.Unix(),
+	})
+	if err != nil {
+		p.logger.Error("failed to marshal node termination", zap.Error(err))
+		return
+	}
+
+	if err := p.publisher.Publish(ctx, events.ChannelNodeTerminated, string(data)); err != nil {
+		p.logger.Error("failed to publish node termination",
+			zap.String("node_id", n.ID),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/user"
+	"go.uber.org/zap"
+)
+
+// SaveUsers persists the connected users and their allocations, so a
+// restarted service can still deallocate their nodes on disconnect
+func (p *Provisioner) SaveUsers(ctx context.Context) error {
+	return p.userStore.Save(ctx, p.userTracker.ConnectedStates())
+}
+
+// saveUsers persists the connected users once per tick
+func (p *Provisioner) saveUsers(ctx context.Context) {
+	if err := p.SaveUsers(ctx); err != nil {
+		p.logger.Warn("failed to persist connected users", zap.Error(err))
+	}
+}
+
+// RestoreUsers reloads the users saved before a restart as connected. Their
+// nodes are added to the pool as allocated to them if the pool does not know
+// them yet; status events from the nodes fill in the rest.
+func (p *Provisioner) RestoreUsers(ctx context.Context) error {
+	states, err := p.userStore.Load(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load connected users: %w", err)
+	}
+	if len(states) == 0 {
+		return nil
+	}
+	slices.SortFunc(states, func(a, b user.UserState) int {
+		return strings.Compare(a.UserID, b.UserID)
+	})
+	p.userTracker.Restore(states)
+
+	usersByNode := make(map[string][]string)
+	for _, state := range states {
+		if state.AllocatedNodeID != "" {
+			usersByNode[state.AllocatedNodeID] = append(usersByNode[state.AllocatedNodeID], state.UserID)
+		}
+	}
+
+	restored := 0
+	now := time.Now()
+	for nodeID, users := range usersByNode {
+		if _, exists := p.nodePool.Get(nodeID); exists {
+			continue
+		}
+		n := &node.Node{
+			ID:        nodeID,
+			Status:    node.NodeStatusAllocated,
+			Users:     users,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		n.Capacity = max(p.predictor.Capacity(n), len(users))
+		p.nodePool.Add(n)
+		restored++
+	}
+
+	p.logger.Info("restored connected users",
+		zap.Int("users", len(states)),
+		zap.Int("nodes", restored),
+	)
+	return nil
+}
@@ -0,0 +1,244 @@
+package session
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Reasons a session ended
+const (
+	EndDisconnect  = "disconnect"  // The user disconnected
+	EndDeallocated = "deallocated" // An operator tore down the allocation
+	EndReassigned  = "reassigned"  // An operator moved the user to another node
+	EndTerminated  = "terminated"  // The node was terminated under the user
+	EndMigrated    = "migrated"    // The user's session was migrated to another node
+	EndReclaimed   = "reclaimed"   // The node was reclaimed from the idle user
+)
+
+// finalFlushTimeout bounds the export attempted on shutdown
+const finalFlushTimeout = 10 * time.Second
+
+// maxHistory bounds the ended sessions kept per user; the oldest are
+// dropped beyond it
+const maxHistory = 50
+
+// Record is an immutable billing record for one user's time on one node
+type Record struct {
+	ID              string    `json:"id"` // Unique per record, for idempotent ingestion
+	UserID          string    `json:"user_id"`
+	NodeID          string    `json:"node_id"`
+	InstanceType    string    `json:"instance_type,omitempty"`
+	StartedAt       time.Time `json:"started_at"`
+	EndedAt         time.Time `json:"ended_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	EndReason       string    `json:"end_reason"`
+}
+
+// Sink exports session records to billing
+type Sink interface {
+	Export(ctx context.Context, records []Record) error
+}
+
+// NopSink discards records when no sink is configured
+type NopSink struct{}
+
+// Export discards records
+func (NopSink) Export(context.Context, []Record) error { return nil }
+
+// Config holds recorder tuning parameters
+type Config struct {
+	// FlushInterval is how often buffered records are exported
+	FlushInterval time.Duration
+
+	// BatchSize triggers an early export once this many records are buffered
+	BatchSize int
+
+	// MaxBuffered bounds the records kept while the sink is failing; the
+	// oldest are dropped beyond it
+	MaxBuffered int
+
+	// History is how long ended sessions are kept in memory for Sessions,
+	// whether or not they were exported; zero keeps none
+	History time.Duration
+}
+
+type openSession struct {
+	nodeID       string
+	instanceType string
+	startedAt    time.Time
+}
+
+// Recorder tracks open sessions and exports a record for each one that ends.
+// Records are buffered and retried until the sink accepts them.
+type Recorder struct {
+	sink   Sink
+	config Config
+	logger *zap.Logger
+
+	mu      sync.Mutex
+	open    map[string]openSession // User ID -> session in progress
+	history map[string][]Record    // User ID -> ended sessions, oldest first
+	pending []Record
+	flushCh chan struct{}
+}
+
+// NewRecorder creates a new session recorder
+func NewRecorder(sink Sink, config Config, logger *zap.Logger) *Recorder {
+	return &Recorder{
+		sink:    sink,
+		config:  config,
+		logger:  logger,
+		open:    make(map[string]openSession),
+		history: make(map[string][]Record),
+		flushCh: make(chan struct{}, 1),
+	}
+}
+
+// Start opens a session for a user on a node
+func (r *Recorder) Start(userID, nodeID, instanceType string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.open[userID] = openSession{
+		nodeID:       nodeID,
+		instanceType: instanceType,
+		startedAt:    at,
+	}
+}
+
+// End closes a user's session and queues its record for export
+func (r *Recorder) End(userID, reason string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.open[userID]
+	if !ok {
+		return
+	}
+	delete(r.open, userID)
+
+	record := Record{
+		ID:              uuid.NewString(),
+		UserID:          userID,
+		NodeID:          s.nodeID,
+		InstanceType:    s.instanceType,
+		StartedAt:       s.startedAt,
+		EndedAt:         at,
+		DurationSeconds: at.Sub(s.startedAt).Seconds(),
+		EndReason:       reason,
+	}
+	r.pending = append(r.pending, record)
+	if r.config.History > 0 {
+		history := append(r.history[userID], record)
+		if over := len(history) - maxHistory; over > 0 {
+			history = history[over:]
+		}
+		r.history[userID] = history
+	}
+
+	if over := len(r.pending) - r.config.MaxBuffered; r.config.MaxBuffered > 0 && over > 0 {
+		r.logger.Error("session buffer full, dropping oldest records", zap.Int("dropped", over))
+		r.pending = r.pending[over:]
+	}
+
+	if len(r.pending) >= r.config.BatchSize {
+		select {
+		case r.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run exports buffered records until ctx is cancelled, then makes a final attempt
+func (r *Recorder) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Give buffered records a last chance on shutdown
+			flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finalFlushTimeout)
+			r.flush(flushCtx)
+			cancel()
+			return ctx.Err()
+		case <-ticker.C:
+			r.flush(ctx)
+		case <-r.flushCh:
+			r.flush(ctx)
+		}
+	}
+}
+
+// Sessions returns a user's sessions ended within the history, oldest
+// first, followed by the one still open with a zero EndedAt, if any
+func (r *Recorder) Sessions(userID string) []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cutoff := time.Now().Add(-r.config.History)
+	var sessions []Record
+	for _, record := range r.history[userID] {
+		if record.EndedAt.After(cutoff) {
+			sessions = append(sessions, record)
+		}
+	}
+	if s, ok := r.open[userID]; ok {
+		sessions = append(sessions, Record{
+			UserID:       userID,
+			NodeID:       s.nodeID,
+			InstanceType: s.instanceType,
+			StartedAt:    s.startedAt,
+		})
+	}
+	return sessions
+}
+
+// forgetHistory drops ended sessions older than the history; the caller
+// holds the lock
+func (r *Recorder) forgetHistory(now time.Time) {
+	cutoff := now.Add(-r.config.History)
+	for userID, history := range r.history {
+		i := 0
+		for i < len(history) && !history[i].EndedAt.After(cutoff) {
+			i++
+		}
+		if i == len(history) {
+			delete(r.history, userID)
+		} else if i > 0 {
+			r.history[userID] = slices.Clone(history[i:])
+		}
+	}
+}
+
+func (r *Recorder) flush(ctx context.Context) {
+	r.mu.Lock()
+	batch := r.pending
+	r.pending = nil
+	r.forgetHistory(time.Now())
+	r.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := r.sink.Export(ctx, batch); err != nil {
+		r.logger.Error("failed to export session records, will retry",
+			zap.Int("records", len(batch)),
+			zap.Error(err),
+		)
+
+		// Requeue ahead of records that ended during the export
+		r.mu.Lock()
+		r.pending = append(batch, r.pending...)
+		r.mu.Unlock()
+		return
+	}
+
+	r.logger.Debug("exported session records", zap.Int("records", len(batch)))
+}
@@ -0,0 +1,64 @@
+package session
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestSessionsKeepsHistory(t *testing.T) {
+	now := time.Now()
+	r := NewRecorder(NopSink{}, Config{BatchSize: 1000, History: time.Hour}, zap.NewNop())
+
+	r.Start("u1", "old", "a100", now.Add(-3*time.Hour))
+	r.End("u1", EndDisconnect, now.Add(-2*time.Hour))
+	r.Start("u1", "n1", "a100", now.Add(-30*time.Minute))
+	r.End("u1", EndTerminated, now.Add(-20*time.Minute))
+	r.Start("u1", "n2", "h100", now.Add(-10*time.Minute))
+
+	// Exporting the records keeps them in the history
+	r.flush(context.Background())
+
+	sessions := r.Sessions("u1")
+	if len(sessions) != 2 {
+		t.Fatalf("sessions = %+v, want the recent one and the open one", sessions)
+	}
+	if s := sessions[0]; s.NodeID != "n1" || s.EndReason != EndTerminated || s.DurationSeconds != 600 {
+		t.Errorf("ended session = %+v", s)
+	}
+	if s := sessions[1]; s.NodeID != "n2" || s.InstanceType != "h100" || !s.EndedAt.IsZero() {
+		t.Errorf("open session = %+v", s)
+	}
+	if _, ok := r.history["u1"]; !ok || len(r.history["u1"]) != 1 {
+		t.Errorf("history = %+v, want the expired session forgotten", r.history["u1"])
+	}
+	if sessions := r.Sessions("u2"); len(sessions) != 0 {
+		t.Errorf("unknown user has sessions %+v", sessions)
+	}
+}
+
+func TestSessionsBounded(t *testing.T) {
+	now := time.Now()
+	r := NewRecorder(NopSink{}, Config{BatchSize: 1000, History: time.Hour}, zap.NewNop())
+	for i := range maxHistory + 5 {
+		at := now.Add(time.Duration(i-maxHistory-5) * time.Second)
+		r.Start("u1", "n1", "", at)
+		r.End("u1", EndDisconnect, at)
+	}
+	sessions := r.Sessions("u1")
+	if len(sessions) != maxHistory {
+		t.Fatalf("kept %d sessions, want %d", len(sessions), maxHistory)
+	}
+	if first := sessions[0].EndedAt; !first.Equal(now.Add(-maxHistory * time.Second)) {
+		t.Errorf("oldest kept ended at %s, want the oldest dropped", first)
+	}
+
+	none := NewRecorder(NopSink{}, Config{BatchSize: 1000}, zap.NewNop())
+	none.Start("u1", "n1", "", now.Add(-time.Minute))
+	none.End("u1", EndDisconnect, now)
+	if sessions := none.Sessions("u1"); len(sessions) != 0 {
+		t.Errorf("sessions kept without a history: %+v", sessions)
+	}
+}
@@ -0,0 +1,259 @@
+package slo
+
+import (
+	"maps"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Observer receives cold-start measurements, e.g. to export them as metrics
+type Observer interface {
+	ObserveConnect(warm bool)
+	ObserveWait(wait time.Duration)
+	ObserveAbandon(reason string, wait time.Duration)
+}
+
+// Reasons a waiting user leaves the queue without a node
+const (
+	AbandonDisconnected = "disconnected" // The user disconnected while waiting
+	AbandonExpired      = "expired"      // The user waited past the longest wait tracked
+)
+
+// outcome records whether a connect request found a warm node
+type outcome struct {
+	at   time.Time
+	warm bool
+}
+
+// wait records how a user's wait for a node ended
+type wait struct {
+	at      time.Time
+	d       time.Duration
+	abandon string // Reason the user left without a node; empty if served
+}
+
+// Tracker measures whether connect requests were served by a warm node and
+// how long users without one waited
+type Tracker struct {
+	mu       sync.Mutex
+	pending  map[string]time.Time // User ID -> first connect attempt without a warm node
+	outcomes []outcome
+	waits    []wait
+	window   time.Duration
+	target   float64
+	observer Observer
+}
+
+// NewTracker creates a tracker computing compliance over a rolling window
+// against the given target warm-start ratio (e.g. 0.99)
+func NewTracker(window time.Duration, target float64, observer Observer) *Tracker {
+	return &Tracker{
+		pending:  make(map[string]time.Time),
+		window:   window,
+		target:   target,
+		observer: observer,
+	}
+}
+
+// ConnectServed records a connect request that was allocated a node. If the
+// user had been waiting for capacity, their wait time is observed.
+func (t *Tracker) ConnectServed(userID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if since, ok := t.pending[userID]; ok {
+		delete(t.pending, userID)
+		t.waits = append(t.waits, wait{at: now, d: now.Sub(since)})
+		t.observer.ObserveWait(now.Sub(since))
+		return
+	}
+
+	t.record(now, true)
+}
+
+// ConnectMissed records a connect request that found no warm node. Repeated
+// attempts by a user who is already waiting are not counted again.
+func (t *Tracker) ConnectMissed(userID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.pending[userID]; ok {
+		return
+	}
+
+	now := time.Now()
+	t.pending[userID] = now
+	t.record(now, false)
+}
+
+// Waiting returns users still waiting for a warm node, longest-waiting first
+func (t *Tracker) Waiting() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	users := make([]string, 0, len(t.pending))
+	for userID := range t.pending {
+		users = append(users, userID)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return t.pending[users[i]].Before(t.pending[users[j]])
+	})
+	return users
+}
+
+// Pending returns when each waiting user first failed to find a warm node
+func (t *Tracker) Pending() map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return maps.Clone(t.pending)
+}
+
+// Abandon stops waiting on a user who disconnected before being served
+func (t *Tracker) Abandon(userID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.abandon(userID, AbandonDisconnected, time.Now())
+}
+
+// ForgetPending stops waiting on every user without counting them abandoned,
+// for a tracker whose users were replaced
+func (t *Tracker) ForgetPending() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	clear(t.pending)
+}
+
+// ExpirePending abandons users who have waited longer than maxWait
+func (t *Tracker) ExpirePending(maxWait time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-maxWait)
+	for userID, since := range t.pending {
+		if since.Before(cutoff) {
+			t.abandon(userID, AbandonExpired, now)
+		}
+	}
+}
+
+// abandon stops waiting on a user for a reason, if they are waiting; the
+// caller holds the lock
+func (t *Tracker) abandon(userID, reason string, now time.Time) {
+	since, ok := t.pending[userID]
+	if !ok {
+		return
+	}
+	delete(t.pending, userID)
+	t.waits = append(t.waits, wait{at: now, d: now.Sub(since), abandon: reason})
+	t.observer.ObserveAbandon(reason, now.Sub(since))
+}
+
+// Snapshot describes cold-start SLO compliance over the rolling window
+type Snapshot struct {
+	Window     time.Duration
+	Target     float64
+	Connects   int
+	ColdStarts int
+	Compliance float64 // Warm-start ratio; 1 when there were no connects
+	Waiting    int     // Users currently waiting for a node
+	Queue      Queue
+}
+
+// Queue describes users waiting for a node: those waiting now, and the
+// waits that ended within the window
+type Queue struct {
+	OldestWait  time.Duration  // Of the longest-waiting user; 0 if none wait
+	Served      int            // Waits that ended with a node
+	Abandoned   map[string]int // Waits that ended without one, by reason
+	AverageWait time.Duration  // Of served waits
+	P95Wait     time.Duration  // Of served waits
+}
+
+// Snapshot returns the current compliance figures
+func (t *Tracker) Snapshot() Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.prune(now)
+
+	snap := Snapshot{
+		Window:     t.window,
+		Target:     t.target,
+		Connects:   len(t.outcomes),
+		Compliance: 1,
+		Waiting:    len(t.pending),
+		Queue:      t.queue(now),
+	}
+	for _, o := range t.outcomes {
+		if !o.warm {
+			snap.ColdStarts++
+		}
+	}
+	if snap.Connects > 0 {
+		snap.Compliance = float64(snap.Connects-snap.ColdStarts) / float64(snap.Connects)
+	}
+	return snap
+}
+
+// queue summarizes the waiting users and the waits in the window; the
+// caller holds the lock
+func (t *Tracker) queue(now time.Time) Queue {
+	q := Queue{Abandoned: map[string]int{AbandonDisconnected: 0, AbandonExpired: 0}}
+	for _, since := range t.pending {
+		q.OldestWait = max(q.OldestWait, now.Sub(since))
+	}
+
+	var served []time.Duration
+	var total time.Duration
+	for _, w := range t.waits {
+		if w.abandon != "" {
+			q.Abandoned[w.abandon]++
+			continue
+		}
+		served = append(served, w.d)
+		total += w.d
+	}
+	if len(served) > 0 {
+		slices.Sort(served)
+		q.Served = len(served)
+		q.AverageWait = total / time.Duration(len(served))
+		q.P95Wait = served[(len(served)*95+99)/100-1]
+	}
+	return q
+}
+
+// Met reports whether the snapshot satisfies its target
+func (s Snapshot) Met() bool {
+	return s.Compliance >= s.Target
+}
+
+func (t *Tracker) record(now time.Time, warm bool) {
+	t.outcomes = append(t.outcomes, outcome{at: now, warm: warm})
+	t.prune(now)
+	t.observer.ObserveConnect(warm)
+}
+
+// prune drops outcomes and waits that fell out of the rolling window; caller must hold the lock
+func (t *Tracker) prune(now time.Time) {
+	cutoff := now.Add(-t.window)
+	i := 0
+	for i < len(t.outcomes) && t.outcomes[i].at.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		t.outcomes = append(t.outcomes[:0], t.outcomes[i:]...)
+	}
+
+	i = 0
+	for i < len(t.waits) && t.waits[i].at.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		t.waits = append(t.waits[:0], t.waits[i:]...)
+	}
+}
@@ -0,0 +1,209 @@
+// Package startup brings the service up in ordered phases: state is
+// hydrated, then reconciled with the providers, then events are subscribed
+// to, and only then does the scaling loop start. Each phase is bounded by
+// a timeout; one that fails or runs out of time is reported and the next
+// begins anyway, so a slow dependency delays startup without blocking it.
+package startup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Phases, in the order they run
+const (
+	PhaseHydrate   = "hydrate"   // Take over the handed off pool or restore connected users
+	PhaseReconcile = "reconcile" // Check the nodes hydrated with the providers that created them
+	PhaseSubscribe = "subscribe" // Subscribe to inbound events
+	PhaseScaling   = "scaling"   // Start the scaling loop
+)
+
+// Phase states
+const (
+	StatePending  = "pending"
+	StateRunning  = "running"
+	StateDone     = "done"
+	StateFailed   = "failed"
+	StateTimedOut = "timed_out"
+)
+
+// Phase is a step of startup
+type Phase struct {
+	Name    string
+	Timeout time.Duration                   // Unused without Run
+	Run     func(ctx context.Context) error // nil for a phase that only releases what waits for it; see Attach
+}
+
+// PhaseStatus is the progress of a phase
+type PhaseStatus struct {
+	Name       string
+	State      string
+	Timeout    time.Duration
+	StartedAt  time.Time // Zero while pending
+	FinishedAt time.Time // Zero until done, failed or timed out
+	Error      string
+}
+
+// Sequence runs the startup phases in order
+type Sequence struct {
+	phases []Phase
+	logger *zap.Logger
+
+	started map[string]chan struct{}
+	done    chan struct{}
+
+	mu       sync.Mutex
+	statuses []PhaseStatus
+}
+
+// NewSequence creates a sequence of the given phases
+func NewSequence(logger *zap.Logger, phases ...Phase) *Sequence {
+	s := &Sequence{
+		phases:   phases,
+		logger:   logger,
+		started:  make(map[string]chan struct{}, len(phases)),
+		done:     make(chan struct{}),
+		statuses: make([]PhaseStatus, len(phases)),
+	}
+	for i, phase := range phases {
+		s.started[phase.Name] = make(chan struct{})
+		s.statuses[i] = PhaseStatus{Name: phase.Name, State: StatePending, Timeout: phase.Timeout}
+	}
+	return s
+}
+
+// Attach sets the work of the named phase while the service is wired,
+// before Run; naming a phase the sequence does not have is a wiring bug
+func (s *Sequence) Attach(name string, run func(ctx context.Context) error) {
+	for i := range s.phases {
+		if s.phases[i].Name == name {
+			s.phases[i].Run = run
+			return
+		}
+	}
+	panic(fmt.Sprintf("startup: unknown phase %q", name))
+}
+
+// Run runs each phase in turn, returning early only if ctx is canceled. A
+// phase cut short that way is left running.
+func (s *Sequence) Run(ctx context.Context) error {
+	begun := time.Now()
+	for i, phase := range s.phases {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.runPhase(ctx, i, phase)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	close(s.done)
+	s.logger.Info("startup complete", zap.Duration("duration", time.Since(begun)))
+	return nil
+}
+
+// runPhase runs one phase within its timeout and records how it ended
+func (s *Sequence) runPhase(ctx context.Context, i int, phase Phase) {
+	s.update(i, func(status *PhaseStatus) {
+		status.State = StateRunning
+		status.StartedAt = time.Now()
+	})
+	close(s.started[phase.Name])
+	s.logger.Info("startup phase started", zap.String("phase", phase.Name))
+
+	var err error
+	var timedOut bool
+	if phase.Run != nil {
+		phaseCtx, cancel := context.WithTimeout(ctx, phase.Timeout)
+		err = phase.Run(phaseCtx)
+		timedOut = errors.Is(phaseCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+		cancel()
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	state := StateDone
+	switch {
+	case timedOut:
+		state = StateTimedOut
+		s.logger.Warn("startup phase timed out; continuing",
+			zap.String("phase", phase.Name),
+			zap.Duration("timeout", phase.Timeout),
+		)
+	case err != nil:
+		state = StateFailed
+		s.logger.Error("startup phase failed; continuing",
+			zap.String("phase", phase.Name),
+			zap.Error(err),
+		)
+	}
+	s.update(i, func(status *PhaseStatus) {
+		status.State = state
+		status.FinishedAt = time.Now()
+		if err != nil {
+			status.Error = err.Error()
+		}
+	})
+	if state == StateDone {
+		status, _ := s.Phase(phase.Name)
+		s.logger.Info("startup phase done",
+			zap.String("phase", phase.Name),
+			zap.Duration("duration", status.FinishedAt.Sub(status.StartedAt)),
+		)
+	}
+}
+
+func (s *Sequence) update(i int, change func(status *PhaseStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(&s.statuses[i])
+}
+
+// Started returns a channel closed once the named phase begins, so what it
+// gates can wait for it; an unknown phase's channel never closes
+func (s *Sequence) Started(name string) <-chan struct{} {
+	return s.started[name]
+}
+
+// Complete reports whether every phase has ended
+func (s *Sequence) Complete() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Phase returns the progress of the named phase
+func (s *Sequence) Phase(name string) (PhaseStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, status := range s.statuses {
+		if status.Name == name {
+			return status, true
+		}
+	}
+	return PhaseStatus{}, false
+}
+
+// Ended reports whether the named phase is done, failed or timed out
+func (s *Sequence) Ended(name string) bool {
+	status, ok := s.Phase(name)
+	return ok && !status.FinishedAt.IsZero()
+}
+
+// Phases returns the progress of every phase, in order
+func (s *Sequence) Phases() []PhaseStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]PhaseStatus, len(s.statuses))
+	copy(statuses, s.statuses)
+	return statuses
+}
@@ -0,0 +1,112 @@
+package user
+
+import (
+	"sort"
+	"time"
+)
+
+// BucketWidth is the span of time one activity bucket counts
+const BucketWidth = time.Minute
+
+// ActivityBucket counts a user's activities within one minute
+type ActivityBucket struct {
+	Start time.Time // Start of the minute
+	Count int
+}
+
+// ActivityHistogram counts a user's activities per minute, oldest bucket
+// first. Minutes without activity have no bucket.
+type ActivityHistogram []ActivityBucket
+
+// add counts an activity at ts, dropping buckets that started more than
+// horizon before the newest one. Activities older than that are not counted.
+func (h ActivityHistogram) add(ts time.Time, horizon time.Duration) ActivityHistogram {
+	start := ts.Truncate(BucketWidth)
+	if n := len(h); n > 0 && start.Before(h[n-1].Start.Add(-horizon)) {
+		return h
+	}
+
+	i := sort.Search(len(h), func(i int) bool { return !h[i].Start.Before(start) })
+	switch {
+	case i < len(h) && h[i].Start.Equal(start):
+		h[i].Count++
+	case i == len(h):
+		h = append(h, ActivityBucket{Start: start, Count: 1})
+	default:
+		// Batched activities may arrive out of order
+		h = append(h, ActivityBucket{})
+		copy(h[i+1:], h[i:])
+		h[i] = ActivityBucket{Start: start, Count: 1}
+	}
+	return h.trim(h[len(h)-1].Start.Add(-horizon))
+}
+
+// trim drops the buckets that started before cutoff
+func (h ActivityHistogram) trim(cutoff time.Time) ActivityHistogram {
+	i := sort.Search(len(h), func(i int) bool { return !h[i].Start.Before(cutoff) })
+	if i == 0 {
+		return h
+	}
+	return append(h[:0], h[i:]...)
+}
+
+// Total returns the activities counted in every bucket
+func (h ActivityHistogram) Total() int {
+	total := 0
+	for _, b := range h {
+		total += b.Count
+	}
+	return total
+}
+
+// Since returns the buckets of the minutes that end after since
+func (h ActivityHistogram) Since(since time.Time) ActivityHistogram {
+	i := sort.Search(len(h), func(i int) bool { return h[i].Start.Add(BucketWidth).After(since) })
+	return h[i:]
+}
+
+// CountWithin returns the activities in the window ending at now, to the
+// minute: the minute now falls in counts whole
+func (h ActivityHistogram) CountWithin(window time.Duration, now time.Time) int {
+	return h.Since(now.Add(-window)).Total()
+}
+
+// Peak returns the busiest minute, the earliest of equally busy ones; the
+// zero bucket if there was no activity
+func (h ActivityHistogram) Peak() ActivityBucket {
+	var peak ActivityBucket
+	for _, b := range h {
+		if b.Count > peak.Count {
+			peak = b
+		}
+	}
+	return peak
+}
+
+// Burst compares the activities of the minute now falls in with the
+// activities per minute over the horizon before it
+type Burst struct {
+	Count    int     // Activities in the current minute
+	Baseline float64 // Activities per minute over the horizon before it, empty minutes included
+	Bursting bool    // Count is at least factor times the baseline, and at least factor
+}
+
+// Burst reports whether the user is far more active in the current minute
+// than over the horizon before it
+func (h ActivityHistogram) Burst(now time.Time, horizon time.Duration, factor float64) Burst {
+	current := now.Truncate(BucketWidth)
+	var burst Burst
+	before := 0
+	for _, b := range h.Since(current.Add(-horizon)) {
+		if b.Start.Equal(current) {
+			burst.Count = b.Count
+		} else if b.Start.Before(current) {
+			before += b.Count
+		}
+	}
+	if minutes := int(horizon / BucketWidth); minutes > 0 {
+		burst.Baseline = float64(before) / float64(minutes)
+	}
+	burst.Bursting = factor > 0 && float64(burst.Count) >= factor*max(burst.Baseline, 1)
+	return burst
+}
@@ -0,0 +1,153 @@
+package user
+
+import (
+	"maps"
+	"testing"
+	"time"
+)
+
+// minutes renders a histogram as the minute after base each bucket starts
+// at, with its count
+func minutes(h ActivityHistogram, base time.Time) map[int]int {
+	out := make(map[int]int, len(h))
+	for _, b := range h {
+		out[int(b.Start.Sub(base)/time.Minute)] = b.Count
+	}
+	return out
+}
+
+func TestHistogramAdd(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(minute int, second int) time.Time {
+		return base.Add(time.Duration(minute)*time.Minute + time.Duration(second)*time.Second)
+	}
+
+	var h ActivityHistogram
+	for _, ts := range []time.Time{
+		at(5, 10), at(5, 50), // Same minute
+		at(2, 0),  // Out of order, before every bucket
+		at(4, 30), // Out of order, between buckets
+		at(9, 0),
+		at(4, 0),
+	} {
+		h = h.add(ts, 10*time.Minute)
+	}
+	want := map[int]int{2: 1, 4: 2, 5: 2, 9: 1}
+	if got := minutes(h, base); !maps.Equal(got, want) {
+		t.Fatalf("buckets = %v, want %v", got, want)
+	}
+	for i := 1; i < len(h); i++ {
+		if !h[i-1].Start.Before(h[i].Start) {
+			t.Fatalf("buckets out of order: %v", h)
+		}
+	}
+
+	// A newer activity pushes the horizon past the oldest buckets
+	h = h.add(at(14, 0), 10*time.Minute)
+	want = map[int]int{4: 2, 5: 2, 9: 1, 14: 1}
+	if got := minutes(h, base); !maps.Equal(got, want) {
+		t.Errorf("after the horizon moved, buckets = %v, want %v", got, want)
+	}
+
+	// Activities older than the horizon are not counted
+	h = h.add(at(3, 0), 10*time.Minute)
+	if got := minutes(h, base); !maps.Equal(got, want) {
+		t.Errorf("activity beyond the horizon counted: %v", got)
+	}
+	if h.Total() != 6 {
+		t.Errorf("Total = %d, want 6", h.Total())
+	}
+}
+
+func TestHistogramTrim(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	h := ActivityHistogram{{Start: base, Count: 1}, {Start: base.Add(time.Minute), Count: 2}, {Start: base.Add(3 * time.Minute), Count: 3}}
+
+	tests := []struct {
+		cutoff time.Time
+		want   map[int]int
+	}{
+		{cutoff: base.Add(-time.Minute), want: map[int]int{0: 1, 1: 2, 3: 3}},
+		{cutoff: base.Add(time.Minute), want: map[int]int{1: 2, 3: 3}},
+		{cutoff: base.Add(90 * time.Second), want: map[int]int{3: 3}},
+		{cutoff: base.Add(time.Hour), want: map[int]int{}},
+	}
+	for _, tt := range tests {
+		got := minutes(append(ActivityHistogram(nil), h...).trim(tt.cutoff), base)
+		if !maps.Equal(got, tt.want) {
+			t.Errorf("trim(%s) = %v, want %v", tt.cutoff.Sub(base), got, tt.want)
+		}
+	}
+}
+
+func TestHistogramSince(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	h := ActivityHistogram{{Start: base, Count: 1}, {Start: base.Add(2 * time.Minute), Count: 2}, {Start: base.Add(5 * time.Minute), Count: 4}}
+
+	tests := []struct {
+		since time.Time
+		want  int
+	}{
+		{since: base.Add(-time.Hour), want: 7},
+		{since: base.Add(59 * time.Second), want: 7}, // The first minute ends after it
+		{since: base.Add(time.Minute), want: 6},
+		{since: base.Add(5*time.Minute + 30*time.Second), want: 4},
+		{since: base.Add(6 * time.Minute), want: 0},
+	}
+	for _, tt := range tests {
+		if got := h.Since(tt.since).Total(); got != tt.want {
+			t.Errorf("Since(%s) counts %d, want %d", tt.since.Sub(base), got, tt.want)
+		}
+	}
+	if got := h.CountWithin(3*time.Minute, base.Add(5*time.Minute)); got != 6 {
+		t.Errorf("CountWithin = %d, want the minute now falls in whole and the 2 before", got)
+	}
+	if peak := h.Peak(); peak.Count != 4 || !peak.Start.Equal(base.Add(5*time.Minute)) {
+		t.Errorf("Peak = %+v, want the 4 at minute 5", peak)
+	}
+}
+
+func TestHistogramBurst(t *testing.T) {
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := base.Add(10*time.Minute + 20*time.Second)
+	quiet := ActivityHistogram{{Start: base.Add(2 * time.Minute), Count: 5}, {Start: base.Add(9 * time.Minute), Count: 5}}
+
+	tests := []struct {
+		name    string
+		h       ActivityHistogram
+		horizon time.Duration
+		want    Burst
+	}{
+		{
+			name:    "burst over a quiet horizon",
+			h:       append(quiet, ActivityBucket{Start: base.Add(10 * time.Minute), Count: 6}),
+			horizon: 10 * time.Minute,
+			want:    Burst{Count: 6, Baseline: 1, Bursting: true},
+		},
+		{
+			name:    "activity before the horizon ignored",
+			h:       append(quiet, ActivityBucket{Start: base.Add(10 * time.Minute), Count: 3}),
+			horizon: 5 * time.Minute,
+			want:    Burst{Count: 3, Baseline: 1, Bursting: true},
+		},
+		{
+			name:    "steady activity",
+			h:       append(quiet, ActivityBucket{Start: base.Add(10 * time.Minute), Count: 2}),
+			horizon: 10 * time.Minute,
+			want:    Burst{Count: 2, Baseline: 1},
+		},
+		{
+			name:    "no activity this minute",
+			h:       quiet,
+			horizon: 10 * time.Minute,
+			want:    Burst{Baseline: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.h.Burst(now, tt.horizon, 3); got != tt.want {
+				t.Errorf("Burst = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package user
+
+import (
+	"container/list"
+	"context"
+	"maps"
+	"slices"
+)
+
+// Store persists the connected users across restarts
+type Store interface {
+	// Save replaces the stored users with the given ones
+	Save(ctx context.Context, states []UserState) error
+
+	// Load returns the stored users
+	Load(ctx context.Context) ([]UserState, error)
+}
+
+// NopStore keeps nothing, so connected users are forgotten on restart
+type NopStore struct{}
+
+func (NopStore) Save(ctx context.Context, states []UserState) error {
+	return nil
+}
+
+func (NopStore) Load(ctx context.Context) ([]UserState, error) {
+	return nil, nil
+}
+
+// ConnectedStates returns copies of the connected users' states
+func (t *UserTracker) ConnectedStates() []UserState {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var states []UserState
+	for _, state := range t.users {
+		if state.IsConnected {
+			states = append(states, state.clone())
+		}
+	}
+	return states
+}
+
+// States returns copies of every tracked user's state, connected or not
+func (t *UserTracker) States() []UserState {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	states := make([]UserState, 0, len(t.users))
+	for _, state := range t.users {
+		states = append(states, state.clone())
+	}
+	return states
+}
+
+// Replace makes the tracker hold exactly the given users, as when importing
+// another service's state. Disconnected users become evictable in the
+// order given, the first the least recently seen.
+func (t *UserTracker) Replace(states []UserState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.users = make(map[string]*UserState, len(states))
+	t.idle = list.New()
+	t.seen = make(map[string]*list.Element)
+	for _, s := range states {
+		s.Selector = maps.Clone(s.Selector)
+		s.Activities = maps.Clone(s.Activities)
+		s.Sessions = slices.Clone(s.Sessions)
+		s.Histogram = slices.Clone(s.Histogram)
+		if !s.IsConnected {
+			s.AllocatedNodeID = ""
+			s.Sessions = nil
+		}
+		t.users[s.UserID] = &s
+		if !s.IsConnected {
+			t.markIdle(s.UserID)
+		}
+	}
+	t.evictOverflow("")
+}
+
+// Restore records users loaded from a store as connected to their nodes
+func (t *UserTracker) Restore(states []UserState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.restore(states)
+}
+
+// Mirror makes exactly the given users connected to their nodes, as when
+// mirroring the users replicated by the leading replica. Other users are
+// marked disconnected; recorded activity is kept.
+func (t *UserTracker) Mirror(states []UserState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	connected := make(map[string]bool, len(states))
+	for _, s := range states {
+		connected[s.UserID] = true
+	}
+	for userID, state := range t.users {
+		if state.IsConnected && !connected[userID] {
+			state.IsConnected = false
+			state.AllocatedNodeID = ""
+			state.Sessions = nil
+			t.markIdle(userID)
+		}
+	}
+	t.restore(states)
+}
+
+func (t *UserTracker) restore(states []UserState) {
+	for _, s := range states {
+		state, exists := t.users[s.UserID]
+		if !exists {
+			state = &UserState{
+				UserID:           s.UserID,
+				LastActivityTime: s.LastActivityTime,
+			}
+			t.users[s.UserID] = state
+		}
+		state.IsConnected = true
+		state.AllocatedNodeID = s.AllocatedNodeID
+		state.LastNodeID = s.AllocatedNodeID
+		t.markBusy(s.UserID)
+		state.TenantID = s.TenantID
+		state.Tier = s.Tier
+		state.Selector = maps.Clone(s.Selector)
+		state.Sessions = slices.Clone(s.Sessions)
+	}
+}
@@ -1,56 +1,227 @@
 package user
 
 import (
+	"container/list"
+	"context"
+	"maps"
+	"slices"
 	"sync"
 	"time"
 )
 
+// Eviction reasons
+const (
+	EvictExpired  = "expired"  // Not seen within the retention period
+	EvictCapacity = "capacity" // Least recently seen when the tracker was full
+)
+
+// Observer is notified of evicted users, e.g. to export them as metrics
+type Observer interface {
+	ObserveUserEviction(reason string)
+}
+
+// Config bounds the users a tracker keeps. Connected users are never
+// evicted; the bounds apply to the disconnected users kept for prediction.
+type Config struct {
+	Retention       time.Duration // Disconnected users not seen for this long are dropped
+	MaxUsers        int           // Users kept at most; 0 for no limit
+	CleanupInterval time.Duration // How often expired users are dropped
+
+	// Horizon is how long per-minute activity buckets are kept, and
+	// BurstFactor how many times the usual activity per minute makes a
+	// minute a burst
+	Horizon     time.Duration
+	BurstFactor float64
+
+	// Weights score activities by type as connect signals; untyped
+	// activities and types without a weight score 1
+	Weights map[string]float64
+}
+
+// weight returns how strongly an activity type signals a connect
+func (c Config) weight(activityType string) float64 {
+	if w, ok := c.Weights[activityType]; ok {
+		return w
+	}
+	return 1
+}
+
+// OtherActivity is the type activities of types without a configured
+// weight are counted under, so clients cannot grow a user's counts with
+// types of their own
+const OtherActivity = "other"
+
 // UserActivity represents a user activity event
 type UserActivity struct {
 	UserID    string
+	Type      string // Empty if untyped
 	Timestamp int64
 }
 
 // UserState tracks the activity state of a user
 type UserState struct {
 	UserID           string
-	LastActivityTime time.Time
-	ActivityCount    int // Count of activities in the prediction window
+	LastActivityTime time.Time         // Of the last activity with a positive weight
+	Histogram        ActivityHistogram // Activities of every type per minute, over the horizon
+	ActivityScore    float64           // Weighted sum of activities, compared with the activity threshold
+	Activities       map[string]int    // Count of activities by weighted type; "" for untyped ones, OtherActivity for the rest
 	IsConnected      bool
 	AllocatedNodeID  string
+	Sessions         []Session         // Open sessions of the connected user, oldest first; empty if their connects carried none
+	LastNodeID       string            // Node the user was last allocated, kept after they disconnect
+	TenantID         string            // Empty if the user's connects carry no tenant
+	Tier             string            // Empty if the user's connects carry no tier
+	Selector         map[string]string // Node labels the user's last connect asked for
+	InstanceType     string            // Instance type preferred for the user's next allocation; "" for any
+}
+
+// Session is one device of a connected user attached to their node
+type Session struct {
+	ID          string // From the connect event; "" for a connect without one
+	ConnectedAt time.Time
 }
 
 // UserTracker tracks user activities and states
 type UserTracker struct {
-	mu     sync.RWMutex
-	users  map[string]*UserState
-	window time.Duration // Time window for tracking activity
+	mu       sync.RWMutex
+	users    map[string]*UserState
+	idle     *list.List               // Disconnected users, most recently seen first
+	seen     map[string]*list.Element // Entries of idle by user ID
+	window   time.Duration            // Time window for tracking activity
+	config   Config
+	observer Observer
+}
+
+// idleUser is an evictable user and when the tracker last heard of them
+type idleUser struct {
+	userID string
+	seenAt time.Time
 }
 
 // NewUserTracker creates a new user tracker
-func NewUserTracker(activityWindow time.Duration) *UserTracker {
+func NewUserTracker(activityWindow time.Duration, config Config, observer Observer) *UserTracker {
 	return &UserTracker{
-		users:  make(map[string]*UserState),
-		window: activityWindow,
+		users:    make(map[string]*UserState),
+		idle:     list.New(),
+		seen:     make(map[string]*list.Element),
+		window:   activityWindow,
+		config:   config,
+		observer: observer,
 	}
 }
 
-// RecordActivity records a user activity
-func (t *UserTracker) RecordActivity(userID string, timestamp time.Time) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+// Run drops expired users every cleanup interval until ctx is cancelled
+func (t *UserTracker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(t.config.CleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			t.CleanupOldActivity(time.Now().Add(-t.config.Retention))
+		}
+	}
+}
+
+// Count returns the number of tracked users
+func (t *UserTracker) Count() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.users)
+}
 
+// get returns a user's state, creating it if needed, and marks the user as
+// seen; the caller holds the lock
+func (t *UserTracker) get(userID string) *UserState {
 	state, exists := t.users[userID]
 	if !exists {
-		state = &UserState{
-			UserID:           userID,
-			LastActivityTime: timestamp,
-			ActivityCount:    1,
-		}
+		state = &UserState{UserID: userID}
 		t.users[userID] = state
+	}
+	if !state.IsConnected {
+		t.markIdle(userID)
+	}
+	if !exists {
+		t.evictOverflow(userID)
+	}
+	return state
+}
+
+// markIdle makes a disconnected user evictable, as the most recently seen
+func (t *UserTracker) markIdle(userID string) {
+	if e, ok := t.seen[userID]; ok {
+		e.Value.(*idleUser).seenAt = time.Now()
+		t.idle.MoveToFront(e)
+		return
+	}
+	t.seen[userID] = t.idle.PushFront(&idleUser{userID: userID, seenAt: time.Now()})
+}
+
+// markBusy keeps a connected user from being evicted
+func (t *UserTracker) markBusy(userID string) {
+	if e, ok := t.seen[userID]; ok {
+		t.idle.Remove(e)
+		delete(t.seen, userID)
+	}
+}
+
+// evictOverflow drops the least recently seen disconnected users, other
+// than keep, while the tracker holds more than MaxUsers
+func (t *UserTracker) evictOverflow(keep string) {
+	for t.config.MaxUsers > 0 && len(t.users) > t.config.MaxUsers {
+		e := t.idle.Back()
+		if e == nil || e.Value.(*idleUser).userID == keep {
+			return
+		}
+		t.evict(e, EvictCapacity)
+	}
+}
+
+func (t *UserTracker) evict(e *list.Element, reason string) {
+	userID := e.Value.(*idleUser).userID
+	t.idle.Remove(e)
+	delete(t.seen, userID)
+	delete(t.users, userID)
+	t.observer.ObserveUserEviction(reason)
+}
+
+// RecordActivity records a user activity of the given type
+func (t *UserTracker) RecordActivity(userID, activityType string, timestamp time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.record(userID, activityType, timestamp)
+}
+
+// RecordActivities records a batch of activities under a single lock
+func (t *UserTracker) RecordActivities(activities []UserActivity) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, activity := range activities {
+		t.record(activity.UserID, activity.Type, time.Unix(activity.Timestamp, 0))
+	}
+}
+
+// record records one activity; the caller holds the lock. An activity
+// weighing nothing is counted but does not keep the user likely to connect.
+func (t *UserTracker) record(userID, activityType string, timestamp time.Time) {
+	state := t.get(userID)
+	if state.Activities == nil {
+		state.Activities = make(map[string]int)
+	}
+	if _, weighted := t.config.Weights[activityType]; weighted || activityType == "" {
+		state.Activities[activityType]++
 	} else {
+		state.Activities[OtherActivity]++
+	}
+	state.Histogram = state.Histogram.add(timestamp, t.config.Horizon)
+
+	if w := t.config.weight(activityType); w > 0 {
 		state.LastActivityTime = timestamp
-		state.ActivityCount++
+		state.ActivityScore += w
 	}
 }
 
@@ -63,21 +234,43 @@ func (t *UserTracker) GetUserState(userID string) (*UserState, bool) {
 	return state, ok
 }
 
-// MarkConnected marks a user as connected
+// StateOf returns a copy of a user's state
+func (t *UserTracker) StateOf(userID string) (UserState, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	state, ok := t.users[userID]
+	if !ok {
+		return UserState{}, false
+	}
+	return state.clone(), true
+}
+
+// clone copies a user's state, with its own maps and sessions
+func (s *UserState) clone() UserState {
+	c := *s
+	c.Selector = maps.Clone(s.Selector)
+	c.Activities = maps.Clone(s.Activities)
+	c.Sessions = slices.Clone(s.Sessions)
+	c.Histogram = slices.Clone(s.Histogram)
+	return c
+}
+
+// MarkConnected marks a user as connected. A user who was disconnected
+// starts without sessions; a connected user moved to another node keeps
+// theirs.
 func (t *UserTracker) MarkConnected(userID, nodeID string) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	state, exists := t.users[userID]
-	if !exists {
-		state = &UserState{
-			UserID: userID,
-		}
-		t.users[userID] = state
+	state := t.get(userID)
+	if !state.IsConnected {
+		state.Sessions = nil
 	}
-
 	state.IsConnected = true
 	state.AllocatedNodeID = nodeID
+	state.LastNodeID = nodeID
+	t.markBusy(userID)
 }
 
 // MarkDisconnected marks a user as disconnected
@@ -88,7 +281,100 @@ func (t *UserTracker) MarkDisconnected(userID string) {
 	if state, exists := t.users[userID]; exists {
 		state.IsConnected = false
 		state.AllocatedNodeID = ""
+		state.Sessions = nil
+		t.markIdle(userID)
+	}
+}
+
+// OpenSession records a session of a connected user, returning whether it
+// is new and whether the user holds it; a session already open is kept as
+// it is. A new session is refused once the user holds limit sessions, 0
+// for no limit.
+func (t *UserTracker) OpenSession(userID, sessionID string, limit int, now time.Time) (bool, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, exists := t.users[userID]
+	if !exists || !state.IsConnected {
+		return false, false
+	}
+	if slices.ContainsFunc(state.Sessions, func(s Session) bool { return s.ID == sessionID }) {
+		return false, true
+	}
+	if limit > 0 && len(state.Sessions) >= limit {
+		return false, false
+	}
+	state.Sessions = append(state.Sessions, Session{ID: sessionID, ConnectedAt: now})
+	return true, true
+}
+
+// CloseSession drops a session of a connected user, returning how many
+// sessions they still hold and whether the session was open
+func (t *UserTracker) CloseSession(userID, sessionID string) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, exists := t.users[userID]
+	if !exists || !state.IsConnected {
+		return 0, false
+	}
+	i := slices.IndexFunc(state.Sessions, func(s Session) bool { return s.ID == sessionID })
+	if i < 0 {
+		return len(state.Sessions), false
+	}
+	state.Sessions = slices.Delete(state.Sessions, i, i+1)
+	return len(state.Sessions), true
+}
+
+// SessionsOf returns a copy of the open sessions of a user
+func (t *UserTracker) SessionsOf(userID string) []Session {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if state, exists := t.users[userID]; exists {
+		return slices.Clone(state.Sessions)
+	}
+	return nil
+}
+
+// Horizon returns how long activity is kept per minute
+func (t *UserTracker) Horizon() time.Duration {
+	return t.config.Horizon
+}
+
+// ActivityCountOf returns a user's activities of every type within the
+// window ending now, to the minute; at most the horizon is kept
+func (t *UserTracker) ActivityCountOf(userID string, window time.Duration) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if state, ok := t.users[userID]; ok {
+		return state.Histogram.CountWithin(window, time.Now())
 	}
+	return 0
+}
+
+// BurstOf reports whether a user is far more active in the current minute
+// than over the horizon before it
+func (t *UserTracker) BurstOf(userID string, now time.Time) Burst {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if state, ok := t.users[userID]; ok {
+		return state.Histogram.Burst(now, t.config.Horizon, t.config.BurstFactor)
+	}
+	return Burst{}
+}
+
+// ActivitiesOf returns a copy of a user's activity counts by type
+func (t *UserTracker) ActivitiesOf(userID string) map[string]int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if state, ok := t.users[userID]; ok {
+		return maps.Clone(state.Activities)
+	}
+	return nil
 }
 
 // GetActiveUsers returns users who have been active recently
@@ -105,7 +391,9 @@ func (t *UserTracker) GetActiveUsers(since time.Time) []*UserState {
 	return active
 }
 
-// GetLikelyToConnect returns users who are likely to connect based on activity
+// GetLikelyToConnect returns users who are likely to connect based on
+// activity: disconnected users active within the window whose weighted
+// activity reaches the threshold
 func (t *UserTracker) GetLikelyToConnect(threshold int, within time.Duration) []*UserState {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
@@ -116,23 +404,25 @@ func (t *UserTracker) GetLikelyToConnect(threshold int, within time.Duration) []
 	for _, state := range t.users {
 		if !state.IsConnected &&
 			state.LastActivityTime.After(cutoff) &&
-			state.ActivityCount >= threshold {
+			state.ActivityScore >= float64(threshold) {
 			likely = append(likely, state)
 		}
 	}
 	return likely
 }
 
-// CleanupOldActivity removes old activity records
-func (t *UserTracker) CleanupOldActivity(before time.Time) {
+// CleanupOldActivity removes disconnected users not seen since before,
+// returning how many were removed
+func (t *UserTracker) CleanupOldActivity(before time.Time) int {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	for userID, state := range t.users {
-		if !state.IsConnected && state.LastActivityTime.Before(before) {
-			delete(t.users, userID)
-		}
+	removed := 0
+	for e := t.idle.Back(); e != nil && e.Value.(*idleUser).seenAt.Before(before); e = t.idle.Back() {
+		t.evict(e, EvictExpired)
+		removed++
 	}
+	return removed
 }
 
 // GetConnectedUsers returns all currently connected users
@@ -149,12 +439,148 @@ func (t *UserTracker) GetConnectedUsers() []*UserState {
 	return connected
 }
 
+// ConnectedInTiers returns the connected users in any of the given tiers,
+// and all connected users
+func (t *UserTracker) ConnectedInTiers(tiers []string) (int, int) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	in, total := 0, 0
+	for _, state := range t.users {
+		if !state.IsConnected {
+			continue
+		}
+		total++
+		if state.Tier != "" && slices.Contains(tiers, state.Tier) {
+			in++
+		}
+	}
+	return in, total
+}
+
 // ResetActivityCount resets the activity count for a user
 func (t *UserTracker) ResetActivityCount(userID string) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
 	if state, exists := t.users[userID]; exists {
-		state.ActivityCount = 0
+		state.Histogram = nil
+		state.ActivityScore = 0
+		state.Activities = nil
+	}
+}
+
+// UserOnNode returns the connected user allocated to a node, if any
+func (t *UserTracker) UserOnNode(nodeID string) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, state := range t.users {
+		if state.IsConnected && state.AllocatedNodeID == nodeID {
+			return state.UserID, true
+		}
+	}
+	return "", false
+}
+
+// UsersOnNode returns the connected users allocated to a node
+func (t *UserTracker) UsersOnNode(nodeID string) []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var users []string
+	for _, state := range t.users {
+		if state.IsConnected && state.AllocatedNodeID == nodeID {
+			users = append(users, state.UserID)
+		}
+	}
+	return users
+}
+
+// SetTenant records the tenant a user belongs to
+func (t *UserTracker) SetTenant(userID, tenantID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.get(userID).TenantID = tenantID
+}
+
+// TenantOf returns the tenant a user belongs to, or "" if unknown
+func (t *UserTracker) TenantOf(userID string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if state, exists := t.users[userID]; exists {
+		return state.TenantID
+	}
+	return ""
+}
+
+// SetTier records the service tier a user's connects asked for
+func (t *UserTracker) SetTier(userID, tier string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.get(userID).Tier = tier
+}
+
+// TierOf returns the service tier of a user, or "" if unknown
+func (t *UserTracker) TierOf(userID string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if state, exists := t.users[userID]; exists {
+		return state.Tier
+	}
+	return ""
+}
+
+// SetSelector records the node labels a user's last connect asked for
+func (t *UserTracker) SetSelector(userID string, selector map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.get(userID).Selector = selector
+}
+
+// SetInstanceType records the instance type preferred for a user's next
+// allocation, or "" for any
+func (t *UserTracker) SetInstanceType(userID, instanceType string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.get(userID).InstanceType = instanceType
+}
+
+// InstanceTypeOf returns the instance type preferred for a user, or "" if any
+func (t *UserTracker) InstanceTypeOf(userID string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if state, exists := t.users[userID]; exists {
+		return state.InstanceType
+	}
+	return ""
+}
+
+// LastNodeOf returns the node a user was last allocated, or "" if none
+func (t *UserTracker) LastNodeOf(userID string) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if state, exists := t.users[userID]; exists {
+		return state.LastNodeID
+	}
+	return ""
+}
+
+// SelectorOf returns the node labels a user asked for, or nil if none
+func (t *UserTracker) SelectorOf(userID string) map[string]string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if state, exists := t.users[userID]; exists {
+		return state.Selector
 	}
+	return nil
 }
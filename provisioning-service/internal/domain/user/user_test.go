@@ -0,0 +1,106 @@
+package user
+
+import (
+	"fmt"
+	"maps"
+	"testing"
+	"time"
+)
+
+type nopObserver struct{}
+
+func (nopObserver) ObserveUserEviction(reason string) {}
+
+func newTestTracker() *UserTracker {
+	return NewUserTracker(time.Minute, Config{Horizon: time.Hour}, nopObserver{})
+}
+
+func sessionIDs(t *UserTracker, userID string) []string {
+	var ids []string
+	for _, s := range t.SessionsOf(userID) {
+		ids = append(ids, s.ID)
+	}
+	return ids
+}
+
+func TestOpenSession(t *testing.T) {
+	now := time.Now()
+	tracker := newTestTracker()
+
+	if opened, held := tracker.OpenSession("u1", "phone", 0, now); opened || held {
+		t.Errorf("session of a user not connected: opened=%v held=%v", opened, held)
+	}
+
+	tracker.MarkConnected("u1", "n1")
+	tests := []struct {
+		name      string
+		sessionID string
+		limit     int
+		opened    bool
+		held      bool
+	}{
+		{name: "first", sessionID: "phone", limit: 2, opened: true, held: true},
+		{name: "repeated", sessionID: "phone", limit: 2, opened: false, held: true},
+		{name: "second", sessionID: "tablet", limit: 2, opened: true, held: true},
+		{name: "past limit", sessionID: "laptop", limit: 2, opened: false, held: false},
+		{name: "no limit", sessionID: "laptop", limit: 0, opened: true, held: true},
+	}
+	for _, tt := range tests {
+		opened, held := tracker.OpenSession("u1", tt.sessionID, tt.limit, now)
+		if opened != tt.opened || held != tt.held {
+			t.Errorf("%s: opened=%v held=%v, want %v %v", tt.name, opened, held, tt.opened, tt.held)
+		}
+	}
+	if got := sessionIDs(tracker, "u1"); len(got) != 3 || got[0] != "phone" || got[2] != "laptop" {
+		t.Errorf("sessions = %v, want phone, tablet, laptop in order", got)
+	}
+}
+
+func TestCloseSession(t *testing.T) {
+	now := time.Now()
+	tracker := newTestTracker()
+	tracker.MarkConnected("u1", "n1")
+	tracker.OpenSession("u1", "phone", 0, now)
+	tracker.OpenSession("u1", "tablet", 0, now)
+
+	if remaining, closed := tracker.CloseSession("u1", "laptop"); remaining != 2 || closed {
+		t.Errorf("unknown session: remaining=%d closed=%v", remaining, closed)
+	}
+	if remaining, closed := tracker.CloseSession("u1", "phone"); remaining != 1 || !closed {
+		t.Errorf("first session: remaining=%d closed=%v", remaining, closed)
+	}
+	if remaining, closed := tracker.CloseSession("u1", "tablet"); remaining != 0 || !closed {
+		t.Errorf("last session: remaining=%d closed=%v", remaining, closed)
+	}
+	if remaining, closed := tracker.CloseSession("u2", "phone"); remaining != 0 || closed {
+		t.Errorf("user not connected: remaining=%d closed=%v", remaining, closed)
+	}
+
+	// A user connecting again starts without the sessions they had
+	tracker.OpenSession("u1", "phone", 0, now)
+	tracker.MarkDisconnected("u1")
+	tracker.MarkConnected("u1", "n2")
+	if got := sessionIDs(tracker, "u1"); len(got) != 0 {
+		t.Errorf("sessions after reconnecting = %v, want none", got)
+	}
+}
+
+func TestRecordActivityCountsUnweightedTypesTogether(t *testing.T) {
+	now := time.Now()
+	tracker := NewUserTracker(time.Minute, Config{Horizon: time.Hour, Weights: map[string]float64{"job_submit": 2}}, nopObserver{})
+
+	for i := range 100 {
+		tracker.RecordActivity("u1", fmt.Sprintf("made-up-%d", i), now)
+	}
+	tracker.RecordActivity("u1", "job_submit", now)
+	tracker.RecordActivity("u1", "", now)
+
+	state, _ := tracker.StateOf("u1")
+	want := map[string]int{OtherActivity: 100, "job_submit": 1, "": 1}
+	if !maps.Equal(state.Activities, want) {
+		t.Errorf("activities = %v, want %v", state.Activities, want)
+	}
+	if state.ActivityScore != 103 {
+		t.Errorf("score = %g, want unweighted types still scoring 1", state.ActivityScore)
+	}
+}
@@ -0,0 +1,56 @@
+package authz
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"resty.dev/v3"
+)
+
+// request is the JSON body posted to the authorization service
+type request struct {
+	UserID   string `json:"user_id"`
+	TenantID string `json:"tenant_id,omitempty"`
+}
+
+// response is the JSON body the authorization service answers with
+type response struct {
+	Allowed bool `json:"allowed"`
+}
+
+// Client asks an external HTTP service whether a user may be allocated a
+// node. The service answers 2xx with {"allowed": true|false}; any other
+// response is an error.
+type Client struct {
+	url   string
+	resty *resty.Client
+}
+
+// NewClient creates an authorization client
+func NewClient(url string, timeout time.Duration) *Client {
+	return &Client{
+		url: url,
+		resty: resty.New().
+			SetTimeout(timeout).
+			SetHeader("Content-Type", "application/json"),
+	}
+}
+
+// Authorize implements access.Authorizer
+func (c *Client) Authorize(ctx context.Context, userID, tenantID string) (bool, error) {
+	var result response
+	resp, err := c.resty.R().
+		SetContext(ctx).
+		SetBody(request{UserID: userID, TenantID: tenantID}).
+		SetResult(&result).
+		Post(c.url)
+	if err != nil {
+		return false, fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.IsError() {
+		return false, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode(), strings.TrimSpace(resp.String()))
+	}
+	return result.Allowed, nil
+}
@@ -0,0 +1,143 @@
+package calendar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/schedule"
+	"go.uber.org/zap"
+	"resty.dev/v3"
+)
+
+// Feed formats
+const (
+	FormatICal = "ical" // An iCalendar (RFC 5545) feed of VEVENTs
+	FormatJSON = "json" // A schedule API answering {"sessions": [...]}
+)
+
+// Options configures a calendar feed
+type Options struct {
+	URL             string
+	Format          string        // FormatICal or FormatJSON
+	RefreshInterval time.Duration // How often the feed is fetched
+	Timeout         time.Duration
+	Horizon         time.Duration // How far ahead recurring events are expanded
+	// DefaultAttendees is assumed for events that give no attendee count
+	DefaultAttendees int
+}
+
+// SessionList is the body a FormatJSON feed answers with
+type SessionList struct {
+	Sessions []schedule.Session `json:"sessions"`
+}
+
+// Outcomes reported to an Observer
+const (
+	RefreshOK    = "ok"
+	RefreshError = "error"
+)
+
+// Observer is notified of feed refreshes
+type Observer interface {
+	ObserveScheduleRefresh(outcome string)
+}
+
+// Feed polls a calendar feed and replaces the schedule's feed sessions with
+// what it lists. A failed fetch keeps the sessions from the last good one.
+type Feed struct {
+	options  Options
+	resty    *resty.Client
+	schedule *schedule.Schedule
+	observer Observer
+	logger   *zap.Logger
+}
+
+// NewFeed creates a feed poller
+func NewFeed(options Options, s *schedule.Schedule, observer Observer, logger *zap.Logger) *Feed {
+	return &Feed{
+		options:  options,
+		resty:    resty.New().SetTimeout(options.Timeout),
+		schedule: s,
+		observer: observer,
+		logger:   logger,
+	}
+}
+
+// Run refreshes the schedule now and then every RefreshInterval until ctx
+// is done
+func (f *Feed) Run(ctx context.Context) error {
+	defer f.resty.Close()
+
+	ticker := time.NewTicker(f.options.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		f.refresh(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (f *Feed) refresh(ctx context.Context) {
+	sessions, err := f.Fetch(ctx, time.Now())
+	if err == nil {
+		err = f.schedule.Replace(schedule.SourceFeed, sessions)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return // Shutting down mid-fetch
+		}
+		f.observer.ObserveScheduleRefresh(RefreshError)
+		f.logger.Warn("calendar feed refresh failed; keeping the last sessions",
+			zap.String("url", f.options.URL),
+			zap.Error(err),
+		)
+		return
+	}
+
+	f.observer.ObserveScheduleRefresh(RefreshOK)
+	f.logger.Debug("calendar feed refreshed", zap.Int("sessions", len(sessions)))
+}
+
+// Fetch reads the sessions the feed lists that have not ended by now
+func (f *Feed) Fetch(ctx context.Context, now time.Time) ([]schedule.Session, error) {
+	resp, err := f.resty.R().
+		SetContext(ctx).
+		Get(f.options.URL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode(), strings.TrimSpace(resp.String()))
+	}
+
+	var sessions []schedule.Session
+	switch f.options.Format {
+	case FormatJSON:
+		var list SessionList
+		if err := json.Unmarshal(resp.Bytes(), &list); err != nil {
+			return nil, fmt.Errorf("invalid schedule: %w", err)
+		}
+		sessions = list.Sessions
+	default:
+		sessions, err = ParseICal(bytes.NewReader(resp.Bytes()), now, now.Add(f.options.Horizon), f.options.DefaultAttendees)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	current := sessions[:0]
+	for _, s := range sessions {
+		if s.End.After(now) {
+			current = append(current, s)
+		}
+	}
+	return current, nil
+}
@@ -0,0 +1,447 @@
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/schedule"
+)
+
+// maxOccurrences bounds how many occurrences of one recurring event are
+// walked, so a runaway rule cannot stall a refresh
+const maxOccurrences = 10000
+
+// property is one content line of an iCalendar feed
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// event is the part of a VEVENT the parser reads
+type event struct {
+	uid          string
+	summary      string
+	start, end   time.Time
+	duration     time.Duration
+	allDay       bool
+	cancelled    bool
+	rrule        string
+	exdates      []time.Time
+	recurrenceID time.Time
+	expected     int // X-EXPECTED-ATTENDEES; -1 if absent
+	attendees    int // ATTENDEE properties
+}
+
+// ParseICal reads the VEVENTs of an iCalendar feed as sessions, keeping
+// those that overlap [from, until). An event's attendee count is its
+// X-EXPECTED-ATTENDEES property, else the number of its ATTENDEEs, else
+// defaultAttendees. Daily and weekly recurrences (INTERVAL, COUNT, UNTIL,
+// BYDAY and EXDATE) are expanded, and occurrences moved by a RECURRENCE-ID
+// replace the ones they move; other recurrences only keep their first
+// occurrence. Cancelled events are left out.
+func ParseICal(r io.Reader, from, until time.Time, defaultAttendees int) ([]schedule.Session, error) {
+	events, err := readEvents(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Occurrences moved by an override are replaced by it
+	moved := make(map[string][]time.Time)
+	for _, e := range events {
+		if !e.recurrenceID.IsZero() {
+			moved[e.uid] = append(moved[e.uid], e.recurrenceID)
+		}
+	}
+
+	var sessions []schedule.Session
+	for _, e := range events {
+		if e.cancelled || e.start.IsZero() {
+			continue
+		}
+		attendees := defaultAttendees
+		if e.expected >= 0 {
+			attendees = e.expected
+		} else if e.attendees > 0 {
+			attendees = e.attendees
+		}
+		length := e.length()
+
+		starts := []time.Time{e.start}
+		if e.rrule != "" && e.recurrenceID.IsZero() {
+			starts = expand(e.start, e.rrule, until)
+		}
+		for _, start := range starts {
+			end := start.Add(length)
+			if !end.After(from) || !start.Before(until) || containsTime(e.exdates, start) {
+				continue
+			}
+			if e.recurrenceID.IsZero() && containsTime(moved[e.uid], start) {
+				continue
+			}
+
+			id := e.uid
+			if e.rrule != "" || !e.recurrenceID.IsZero() {
+				id += "@" + start.UTC().Format("20060102T150405Z")
+			}
+			sessions = append(sessions, schedule.Session{
+				ID:        id,
+				Title:     e.summary,
+				Start:     start,
+				End:       end,
+				Attendees: attendees,
+			})
+		}
+	}
+	return sessions, nil
+}
+
+// length returns how long an event's occurrences last
+func (e event) length() time.Duration {
+	switch {
+	case !e.end.IsZero() && e.end.After(e.start):
+		return e.end.Sub(e.start)
+	case e.duration > 0:
+		return e.duration
+	case e.allDay:
+		return 24 * time.Hour
+	default:
+		// An event without an end is a point in time; give it a minute so
+		// its attendees still count around its start
+		return time.Minute
+	}
+}
+
+// readEvents reads the VEVENTs of a feed, ignoring components nested in them
+func readEvents(r io.Reader) ([]event, error) {
+	var events []event
+	var current *event
+	nested := 0
+
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	for i, line := range lines {
+		p, ok := parseProperty(line)
+		if !ok {
+			continue
+		}
+
+		switch {
+		case p.name == "BEGIN" && strings.EqualFold(p.value, "VEVENT"):
+			current = &event{expected: -1}
+			nested = 0
+			continue
+		case current == nil:
+			continue
+		case p.name == "BEGIN":
+			nested++
+			continue
+		case p.name == "END" && nested > 0:
+			nested--
+			continue
+		case p.name == "END" && strings.EqualFold(p.value, "VEVENT"):
+			if current.uid == "" {
+				current.uid = fmt.Sprintf("line-%d", i+1)
+			}
+			events = append(events, *current)
+			current = nil
+			continue
+		case nested > 0:
+			continue
+		}
+
+		if err := current.set(p); err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", i+1, p.name, err)
+		}
+	}
+	return events, nil
+}
+
+// set applies one property of a VEVENT
+func (e *event) set(p property) error {
+	var err error
+	switch p.name {
+	case "UID":
+		e.uid = p.value
+	case "SUMMARY":
+		e.summary = unescape(p.value)
+	case "DTSTART":
+		e.start, e.allDay, err = parseTime(p)
+	case "DTEND":
+		e.end, _, err = parseTime(p)
+	case "DURATION":
+		e.duration, err = parseDuration(p.value)
+	case "STATUS":
+		e.cancelled = strings.EqualFold(p.value, "CANCELLED")
+	case "RRULE":
+		e.rrule = p.value
+	case "EXDATE":
+		for _, v := range strings.Split(p.value, ",") {
+			var t time.Time
+			t, _, err = parseTime(property{params: p.params, value: v})
+			if err != nil {
+				return err
+			}
+			e.exdates = append(e.exdates, t)
+		}
+	case "RECURRENCE-ID":
+		e.recurrenceID, _, err = parseTime(p)
+	case "X-EXPECTED-ATTENDEES":
+		e.expected, err = strconv.Atoi(strings.TrimSpace(p.value))
+		if err == nil && e.expected < 0 {
+			err = fmt.Errorf("negative count %d", e.expected)
+		}
+	case "ATTENDEE":
+		e.attendees++
+	}
+	return err
+}
+
+// unfold reads the content lines of a feed, joining folded lines
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read feed: %w", err)
+	}
+	return lines, nil
+}
+
+// parseProperty splits a content line into its name, parameters and value;
+// colons and semicolons inside quoted parameter values are not separators
+func parseProperty(line string) (property, bool) {
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		} else if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return property{}, false
+	}
+
+	p := property{value: line[colon+1:], params: make(map[string]string)}
+	head := line[:colon]
+	parts := splitUnquoted(head, ';')
+	p.name = strings.ToUpper(parts[0])
+	for _, param := range parts[1:] {
+		key, value, _ := strings.Cut(param, "=")
+		p.params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+	}
+	return p, true
+}
+
+// splitUnquoted splits s at sep outside double quotes
+func splitUnquoted(s string, sep rune) []string {
+	var parts []string
+	quoted := false
+	start := 0
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseTime reads a DATE or DATE-TIME value, in UTC, in its TZID zone or,
+// for floating times and zones this host does not know, in local time
+func parseTime(p property) (time.Time, bool, error) {
+	value := strings.TrimSpace(p.value)
+	loc := time.Local
+	if tzid := p.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+
+	if p.params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseDuration reads an iCalendar DURATION such as PT1H30M or P1D
+func parseDuration(value string) (time.Duration, error) {
+	m := durationPattern.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil || strings.Join(m[2:], "") == "" {
+		// A duration needs at least one unit; P and PT alone match too
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var d time.Duration
+	for i, unit := range units {
+		if m[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(m[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		d += time.Duration(n) * unit
+	}
+	if m[1] == "-" {
+		d = -d
+	}
+	return d, nil
+}
+
+// unescape undoes the escaping of TEXT values
+func unescape(s string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(s)
+}
+
+// rule is the part of an RRULE that is expanded
+type rule struct {
+	freq     string
+	interval int
+	count    int
+	until    time.Time
+	byDay    []time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseRule reads an RRULE; unsupported parts leave it unexpandable
+func parseRule(value string, loc *time.Location) (rule, bool) {
+	r := rule{interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		key, v, _ := strings.Cut(part, "=")
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			r.freq = strings.ToUpper(v)
+		case "INTERVAL":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return rule{}, false
+			}
+			r.interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return rule{}, false
+			}
+			r.count = n
+		case "UNTIL":
+			t, _, err := parseTime(property{value: v})
+			if err != nil {
+				return rule{}, false
+			}
+			if !strings.HasSuffix(v, "Z") {
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, loc)
+				if len(v) == len("20060102") {
+					// A date bound includes the whole day
+					t = t.AddDate(0, 0, 1).Add(-time.Second)
+				}
+			}
+			r.until = t
+		case "BYDAY":
+			for _, day := range strings.Split(v, ",") {
+				wd, ok := weekdays[strings.ToUpper(day)]
+				if !ok {
+					// Ordinal days such as 1MO only occur in monthly rules
+					return rule{}, false
+				}
+				r.byDay = append(r.byDay, wd)
+			}
+		case "WKST", "":
+		default:
+			return rule{}, false
+		}
+	}
+	return r, r.freq == "DAILY" || r.freq == "WEEKLY"
+}
+
+// expand returns the occurrence starts of a recurring event before horizon;
+// a rule that cannot be expanded keeps only the first occurrence
+func expand(start time.Time, value string, horizon time.Time) []time.Time {
+	r, ok := parseRule(value, start.Location())
+	if !ok {
+		return []time.Time{start}
+	}
+
+	var candidates func(step int) []time.Time
+	switch {
+	case r.freq == "DAILY":
+		candidates = func(step int) []time.Time {
+			return []time.Time{start.AddDate(0, 0, step*r.interval)}
+		}
+	case len(r.byDay) == 0:
+		candidates = func(step int) []time.Time {
+			return []time.Time{start.AddDate(0, 0, 7*step*r.interval)}
+		}
+	default:
+		// Weeks run from Monday
+		monday := start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+		days := slices.Clone(r.byDay)
+		slices.SortFunc(days, func(a, b time.Weekday) int {
+			return (int(a)+6)%7 - (int(b)+6)%7
+		})
+		candidates = func(step int) []time.Time {
+			week := monday.AddDate(0, 0, 7*step*r.interval)
+			occurrences := make([]time.Time, 0, len(days))
+			for _, day := range days {
+				occurrences = append(occurrences, week.AddDate(0, 0, (int(day)+6)%7))
+			}
+			return occurrences
+		}
+	}
+
+	var starts []time.Time
+	seen := 0
+	for step := 0; seen < maxOccurrences; step++ {
+		for _, t := range candidates(step) {
+			if t.Before(start) {
+				continue
+			}
+			if !t.Before(horizon) || !r.until.IsZero() && t.After(r.until) || r.count > 0 && seen >= r.count {
+				return starts
+			}
+			seen++
+			starts = append(starts, t)
+		}
+	}
+	return starts
+}
+
+func containsTime(times []time.Time, t time.Time) bool {
+	return slices.ContainsFunc(times, t.Equal)
+}
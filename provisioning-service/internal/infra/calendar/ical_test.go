@@ -0,0 +1,220 @@
+package calendar
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/schedule"
+)
+
+// calendar wraps VEVENT lines in a VCALENDAR with CRLF line endings, as
+// feeds are served
+func calendar(lines ...string) string {
+	all := append([]string{"BEGIN:VCALENDAR", "VERSION:2.0"}, lines...)
+	all = append(all, "END:VCALENDAR", "")
+	return strings.Join(all, "\r\n")
+}
+
+// describe formats sessions as "id start-end attendees", in UTC
+func describe(sessions []schedule.Session) []string {
+	var out []string
+	for _, s := range sessions {
+		out = append(out, fmt.Sprintf("%s %s-%s %d", s.ID,
+			s.Start.UTC().Format("01-02T15:04"), s.End.UTC().Format("01-02T15:04"), s.Attendees))
+	}
+	return out
+}
+
+func TestParseICal(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	until := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		feed string
+		want []string
+	}{
+		{
+			name: "single event",
+			feed: calendar(
+				"BEGIN:VEVENT",
+				"UID:intro",
+				"DTSTART:20260105T090000Z",
+				"DTEND:20260105T103000Z",
+				"X-EXPECTED-ATTENDEES:30",
+				"END:VEVENT",
+			),
+			want: []string{"intro 01-05T09:00-01-05T10:30 30"},
+		},
+		{
+			name: "folded lines",
+			feed: calendar(
+				"BEGIN:VEVENT",
+				"UID:fol",
+				" ded",
+				"DTSTART:20260105T0900",
+				"\t00Z",
+				"DURATION:PT45M",
+				"END:VEVENT",
+			),
+			want: []string{"folded 01-05T09:00-01-05T09:45 5"},
+		},
+		{
+			name: "tzid",
+			feed: calendar(
+				"BEGIN:VEVENT",
+				"UID:berlin",
+				"DTSTART;TZID=Europe/Berlin:20260105T090000",
+				"DTEND;TZID=\"Europe/Berlin\":20260105T100000",
+				"END:VEVENT",
+				"BEGIN:VEVENT",
+				"UID:summer",
+				"DTSTART;TZID=America/New_York:20260601T090000",
+				"DURATION:PT1H",
+				"END:VEVENT",
+			),
+			want: []string{"berlin 01-05T08:00-01-05T09:00 5"},
+		},
+		{
+			name: "weekly by day with exdate and moved occurrence",
+			feed: calendar(
+				"BEGIN:VEVENT",
+				"UID:lab",
+				"DTSTART:20260105T090000Z",
+				"DTEND:20260105T100000Z",
+				"RRULE:FREQ=WEEKLY;BYDAY=WE,MO;COUNT=4",
+				"EXDATE:20260107T090000Z",
+				"END:VEVENT",
+				"BEGIN:VEVENT",
+				"UID:lab",
+				"RECURRENCE-ID:20260112T090000Z",
+				"DTSTART:20260112T130000Z",
+				"DTEND:20260112T140000Z",
+				"END:VEVENT",
+			),
+			want: []string{
+				"lab@20260105T090000Z 01-05T09:00-01-05T10:00 5",
+				"lab@20260114T090000Z 01-14T09:00-01-14T10:00 5",
+				"lab@20260112T130000Z 01-12T13:00-01-12T14:00 5",
+			},
+		},
+		{
+			name: "daily until a date in the event's zone",
+			feed: calendar(
+				"BEGIN:VEVENT",
+				"UID:standup",
+				"DTSTART;TZID=Europe/Berlin:20260105T180000",
+				"DURATION:PT15M",
+				"RRULE:FREQ=DAILY;UNTIL=20260107",
+				"END:VEVENT",
+			),
+			want: []string{
+				"standup@20260105T170000Z 01-05T17:00-01-05T17:15 5",
+				"standup@20260106T170000Z 01-06T17:00-01-06T17:15 5",
+				"standup@20260107T170000Z 01-07T17:00-01-07T17:15 5",
+			},
+		},
+		{
+			name: "daily interval stops at the horizon",
+			feed: calendar(
+				"BEGIN:VEVENT",
+				"UID:gym",
+				"DTSTART:20260125T100000Z",
+				"DTEND:20260125T110000Z",
+				"RRULE:FREQ=DAILY;INTERVAL=3",
+				"END:VEVENT",
+			),
+			want: []string{
+				"gym@20260125T100000Z 01-25T10:00-01-25T11:00 5",
+				"gym@20260128T100000Z 01-28T10:00-01-28T11:00 5",
+				"gym@20260131T100000Z 01-31T10:00-01-31T11:00 5",
+			},
+		},
+		{
+			name: "unsupported rule keeps the first occurrence",
+			feed: calendar(
+				"BEGIN:VEVENT",
+				"UID:review",
+				"DTSTART:20260110T100000Z",
+				"DTEND:20260110T110000Z",
+				"RRULE:FREQ=MONTHLY;BYDAY=2SA",
+				"END:VEVENT",
+			),
+			want: []string{"review@20260110T100000Z 01-10T10:00-01-10T11:00 5"},
+		},
+		{
+			name: "attendees and cancelled events",
+			feed: calendar(
+				"BEGIN:VEVENT",
+				"UID:listed",
+				"DTSTART:20260105T090000Z",
+				"DTEND:20260105T100000Z",
+				"ATTENDEE;CN=\"Doe; Jane\":mailto:jane@example.com",
+				"ATTENDEE:mailto:joe@example.com",
+				"BEGIN:VALARM",
+				"ATTENDEE:mailto:alarm@example.com",
+				"END:VALARM",
+				"END:VEVENT",
+				"BEGIN:VEVENT",
+				"UID:cancelled",
+				"DTSTART:20260105T090000Z",
+				"STATUS:CANCELLED",
+				"END:VEVENT",
+				"BEGIN:VEVENT",
+				"UID:past",
+				"DTSTART:20251201T090000Z",
+				"DTEND:20251201T100000Z",
+				"END:VEVENT",
+			),
+			want: []string{"listed 01-05T09:00-01-05T10:00 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions, err := ParseICal(strings.NewReader(tt.feed), from, until, 5)
+			if err != nil {
+				t.Fatalf("ParseICal: %v", err)
+			}
+			got := describe(sessions)
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("sessions =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseICalRejectsInvalidValues(t *testing.T) {
+	for _, line := range []string{
+		"DTSTART:2026-01-05",
+		"DURATION:1H",
+		"X-EXPECTED-ATTENDEES:-3",
+	} {
+		feed := calendar("BEGIN:VEVENT", "UID:bad", line, "END:VEVENT")
+		if _, err := ParseICal(strings.NewReader(feed), time.Time{}, time.Now(), 1); err == nil {
+			t.Errorf("%s: parsed without error", line)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"PT1H30M": 90 * time.Minute,
+		"P1D":     24 * time.Hour,
+		"P1W":     7 * 24 * time.Hour,
+		"P1DT2S":  24*time.Hour + 2*time.Second,
+		"-PT15M":  -15 * time.Minute,
+	}
+	for value, want := range tests {
+		if got, err := parseDuration(value); err != nil || got != want {
+			t.Errorf("parseDuration(%q) = %v, %v; want %v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"P", "PT", "1H", "PT1.5H"} {
+		if _, err := parseDuration(value); err == nil {
+			t.Errorf("parseDuration(%q) succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+// Faults injected
+const (
+	FaultNodeAPIDelay   = "node_api_delay"
+	FaultNodeAPIFailure = "node_api_failure"
+	FaultEventDrop      = "event_drop"
+	FaultStatusFlip     = "status_flip"
+)
+
+// ErrInjected is returned by Node API calls failed on purpose
+var ErrInjected = errors.New("chaos: injected node api failure")
+
+// Config sets the probability of each fault, from 0 to 1
+type Config struct {
+	// Seed makes the sequence of faults reproducible for the same sequence
+	// of calls
+	Seed uint64
+
+	NodeAPIDelayRate   float64
+	NodeAPIMaxDelay    time.Duration // Delays are uniform up to this
+	NodeAPIFailureRate float64
+	EventDropRate      float64
+	StatusFlipRate     float64
+}
+
+// Observer is notified of injected faults
+type Observer interface {
+	ObserveFault(fault string)
+}
+
+// Injector decides which calls and events to disturb
+type Injector struct {
+	config   Config
+	observer Observer
+	logger   *zap.Logger
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// NewInjector creates a fault injector
+func NewInjector(config Config, observer Observer, logger *zap.Logger) *Injector {
+	return &Injector{
+		config:   config,
+		observer: observer,
+		logger:   logger,
+		rng:      rand.New(rand.NewPCG(config.Seed, config.Seed)),
+	}
+}
+
+// roll reports whether a fault with the given rate fires
+func (i *Injector) roll(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rng.Float64() < rate
+}
+
+// pick returns a random index below n
+func (i *Injector) pick(n int) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rng.IntN(n)
+}
+
+// delay returns a random delay up to the configured maximum
+func (i *Injector) delay() time.Duration {
+	if i.config.NodeAPIMaxDelay <= 0 {
+		return 0
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return time.Duration(i.rng.Int64N(int64(i.config.NodeAPIMaxDelay)))
+}
+
+func (i *Injector) record(fault string, fields ...zap.Field) {
+	i.observer.ObserveFault(fault)
+	i.logger.Warn("CHAOS: fault injected", append([]zap.Field{zap.String("fault", fault)}, fields...)...)
+}
+
+// nodeAPICall delays or fails a Node API call before it is made
+func (i *Injector) nodeAPICall(ctx context.Context, op string) error {
+	if i.roll(i.config.NodeAPIDelayRate) {
+		d := i.delay()
+		i.record(FaultNodeAPIDelay, zap.String("op", op), zap.Duration("delay", d))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+
+	if i.roll(i.config.NodeAPIFailureRate) {
+		i.record(FaultNodeAPIFailure, zap.String("op", op))
+		return ErrInjected
+	}
+	return nil
+}
+
+// NodeProvider creates and terminates nodes
+type NodeProvider interface {
+	ProvisionNode(ctx context.Context, instanceType, zone, purchase string, labels map[string]string) (string, error)
+	ProvisionNodes(ctx context.Context, instanceType, zone, purchase string, labels map[string]string, count int) ([]string, error)
+	TerminateNode(ctx context.Context, nodeID string) error
+	GetNodeDiagnostics(ctx context.Context, nodeID string) (node.Diagnostics, error)
+	// NodeExists reports whether the provider still has a node; an error
+	// means it could not tell
+	NodeExists(ctx context.Context, nodeID string) (bool, error)
+}
+
+// WrapProvider returns a provider whose calls may be delayed or failed
+func (i *Injector) WrapProvider(next NodeProvider) NodeProvider {
+	return &provider{next: next, injector: i}
+}
+
+type provider struct {
+	next     NodeProvider
+	injector *Injector
+}
+
+func (p *provider) ProvisionNode(ctx context.Context, instanceType, zone, purchase string, labels map[string]string) (string, error) {
+	if err := p.injector.nodeAPICall(ctx, "provision"); err != nil {
+		return "", err
+	}
+	return p.next.ProvisionNode(ctx, instanceType, zone, purchase, labels)
+}
+
+func (p *provider) ProvisionNodes(ctx context.Context, instanceType, zone, purchase string, labels map[string]string, count int) ([]string, error) {
+	if err := p.injector.nodeAPICall(ctx, "provision_batch"); err != nil {
+		return nil, err
+	}
+	return p.next.ProvisionNodes(ctx, instanceType, zone, purchase, labels, count)
+}
+
+func (p *provider) TerminateNode(ctx context.Context, nodeID string) error {
+	if err := p.injector.nodeAPICall(ctx, "terminate"); err != nil {
+		return err
+	}
+	return p.next.TerminateNode(ctx, nodeID)
+}
+
+func (p *provider) GetNodeDiagnostics(ctx context.Context, nodeID string) (node.Diagnostics, error) {
+	if err := p.injector.nodeAPICall(ctx, "diagnostics"); err != nil {
+		return node.Diagnostics{}, err
+	}
+	return p.next.GetNodeDiagnostics(ctx, nodeID)
+}
+
+func (p *provider) NodeExists(ctx context.Context, nodeID string) (bool, error) {
+	if err := p.injector.nodeAPICall(ctx, "lookup"); err != nil {
+		return false, err
+	}
+	return p.next.NodeExists(ctx, nodeID)
+}
+
+// WrapHandler returns a handler that may drop inbound events and flip the
+// status reported in node status events
+func (i *Injector) WrapHandler(next events.Handler) events.Handler {
+	return &handler{next: next, injector: i}
+}
+
+type handler struct {
+	next     events.Handler
+	injector *Injector
+}
+
+// drop reports whether an event is dropped
+func (h *handler) drop(channel string) bool {
+	if !h.injector.roll(h.injector.config.EventDropRate) {
+		return false
+	}
+	h.injector.record(FaultEventDrop, zap.String("channel", channel))
+	return true
+}
+
+func (h *handler) HandleUserActivity(ctx context.Context, event events.UserActivityEvent) error {
+	if h.drop(events.ChannelUserActivity) {
+		return nil
+	}
+	return h.next.HandleUserActivity(ctx, event)
+}
+
+func (h *handler) HandleUserActivityBatch(ctx context.Context, event events.UserActivityBatchEvent) error {
+	if h.drop(events.ChannelUserActivityBatch) {
+		return nil
+	}
+	return h.next.HandleUserActivityBatch(ctx, event)
+}
+
+func (h *handler) HandleUserConnect(ctx context.Context, event events.UserConnectEvent) error {
+	if h.drop(events.ChannelUserConnect) {
+		return nil
+	}
+	return h.next.HandleUserConnect(ctx, event)
+}
+
+func (h *handler) HandleUserDisconnect(ctx context.Context, event events.UserDisconnectEvent) error {
+	if h.drop(events.ChannelUserDisconnect) {
+		return nil
+	}
+	return h.next.HandleUserDisconnect(ctx, event)
+}
+
+func (h *handler) HandleUserMigrateAck(ctx context.Context, event events.UserMigrateAckEvent) error {
+	if h.drop(events.ChannelUserMigrateAck) {
+		return nil
+	}
+	return h.next.HandleUserMigrateAck(ctx, event)
+}
+
+func (h *handler) HandleUserConfirm(ctx context.Context, event events.UserConfirmEvent) error {
+	if h.drop(events.ChannelUserConfirm) {
+		return nil
+	}
+	return h.next.HandleUserConfirm(ctx, event)
+}
+
+func (h *handler) HandleNodeUtilization(ctx context.Context, event events.NodeUtilizationEvent) error {
+	if h.drop(events.ChannelNodeUtilization) {
+		return nil
+	}
+	return h.next.HandleNodeUtilization(ctx, event)
+}
+
+// nodeStatuses are the statuses a flipped event may report
+var nodeStatuses = []string{"booting", "ready", "terminated"}
+
+func (h *handler) HandleNodeStatus(ctx context.Context, event events.NodeStatusEvent) error {
+	if h.drop(events.ChannelNodeStatus) {
+		return nil
+	}
+
+	if event.Status != "" && h.injector.roll(h.injector.config.StatusFlipRate) {
+		// Pick one of the other statuses
+		var others []string
+		for _, status := range nodeStatuses {
+			if status != event.Status {
+				others = append(others, status)
+			}
+		}
+		flipped := others[h.injector.pick(len(others))]
+		h.injector.record(FaultStatusFlip,
+			zap.String("node_id", event.NodeID),
+			zap.String("status", event.Status),
+			zap.String("flipped_to", flipped),
+		)
+		event.Status = flipped
+	}
+
+	return h.next.HandleNodeStatus(ctx, event)
+}
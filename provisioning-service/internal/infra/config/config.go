@@ -1,10 +1,21 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	stdjson "encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/aos-cc/provisioning-service/internal/domain/policy"
 	"github.com/knadh/koanf/parsers/json"
+	"github.com/knadh/koanf/parsers/toml/v2"
+	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/env"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/v2"
@@ -12,50 +23,568 @@ import (
 
 // Config holds all configuration for the provisioning service
 type Config struct {
+	Dev        bool             `koanf:"dev"` // Run without external dependencies; see EnableDevMode
+	Profile    string           `koanf:"-"`   // Deployment profile whose defaults were applied; set by Load
 	Server     ServerConfig     `koanf:"server"`
 	Redis      RedisConfig      `koanf:"redis"`
 	NodeAPI    NodeAPIConfig    `koanf:"node_api"`
 	Prediction PredictionConfig `koanf:"prediction"`
+	Allocation AllocationConfig `koanf:"allocation"`
+	Budget     BudgetConfig     `koanf:"budget"`
+	Purchasing PurchasingConfig `koanf:"purchasing"`
+	Access     AccessConfig     `koanf:"access"`
+	Agent      AgentConfig      `koanf:"agent"`
+	Metrics    MetricsConfig    `koanf:"metrics"`
+	Events     EventsConfig     `koanf:"events"`
+	NATS       NATSConfig       `koanf:"nats"`
+	HA         HAConfig         `koanf:"ha"`
+	Hooks      HooksConfig      `koanf:"hooks"`
+	Sessions   SessionsConfig   `koanf:"sessions"`
+	Log        LogConfig        `koanf:"log"`
+	Health     HealthConfig     `koanf:"health"`
+	Startup    StartupConfig    `koanf:"startup"`
+	Chaos      ChaosConfig      `koanf:"chaos"`
 }
 
 // ServerConfig holds HTTP server configuration
 type ServerConfig struct {
-	Port int `koanf:"port"`
+	Port       int       `koanf:"port"`
+	AdminToken string    `koanf:"admin_token"` // Bearer token required for /admin routes; empty disables auth
+	JWT        JWTConfig `koanf:"jwt"`         // Role-based access to /admin routes with signed tokens
+}
+
+// JWTConfig holds how admin API tokens are verified and mapped to roles.
+// Setting a secret or public key file enables JWT authentication.
+type JWTConfig struct {
+	Secret        string        `koanf:"secret"`          // HS256 signing secret
+	PublicKeyFile string        `koanf:"public_key_file"` // PEM RSA public key for RS256 tokens
+	Issuer        string        `koanf:"issuer"`          // Required iss claim; empty accepts any
+	Audience      string        `koanf:"audience"`        // Required aud claim; empty accepts any
+	RoleClaim     string        `koanf:"role_claim"`      // Claim holding viewer|operator|admin, as a string or a list
+	TenantClaim   string        `koanf:"tenant_claim"`    // Claim limiting a caller to one tenant's users and nodes
+	Leeway        time.Duration `koanf:"leeway"`          // Clock skew allowed on exp and nbf
+}
+
+// Enabled reports whether admin API tokens are verified as JWTs
+func (j JWTConfig) Enabled() bool {
+	return j.Secret != "" || j.PublicKeyFile != ""
 }
 
 // RedisConfig holds Redis connection configuration
 type RedisConfig struct {
-	Addr     string `koanf:"addr"`
-	Password string `koanf:"password"`
-	DB       int    `koanf:"db"`
+	Mode             string   `koanf:"mode"` // standalone|sentinel|cluster
+	Addr             string   `koanf:"addr"`
+	Addrs            []string `koanf:"addrs"` // Sentinel addresses or cluster seed nodes
+	MasterName       string   `koanf:"master_name"`
+	Password         string   `koanf:"password"`
+	SentinelPassword string   `koanf:"sentinel_password"`
+	DB               int      `koanf:"db"`
+
+	TLS RedisTLSConfig `koanf:"tls"`
+
+	// Pool and timeout tuning; zero keeps the client default
+	PoolSize        int           `koanf:"pool_size"`         // Connections per node; 10 per CPU by default
+	MinIdleConns    int           `koanf:"min_idle_conns"`    // Idle connections kept open
+	DialTimeout     time.Duration `koanf:"dial_timeout"`      // 5s by default
+	ReadTimeout     time.Duration `koanf:"read_timeout"`      // 3s by default
+	WriteTimeout    time.Duration `koanf:"write_timeout"`     // read_timeout by default
+	PoolTimeout     time.Duration `koanf:"pool_timeout"`      // Wait for a free connection; read_timeout + 1s by default
+	MaxRetries      int           `koanf:"max_retries"`       // 3 by default; -1 disables retries
+	MinRetryBackoff time.Duration `koanf:"min_retry_backoff"` // 8ms by default
+	MaxRetryBackoff time.Duration `koanf:"max_retry_backoff"` // 512ms by default
+}
+
+// RedisTLSConfig holds TLS settings for Redis connections
+type RedisTLSConfig struct {
+	Enabled            bool   `koanf:"enabled"`
+	CAFile             string `koanf:"ca_file"`              // PEM bundle verifying the server; the system pool when empty
+	CertFile           string `koanf:"cert_file"`            // Client certificate for mutual TLS
+	KeyFile            string `koanf:"key_file"`             // Key of the client certificate
+	ServerName         string `koanf:"server_name"`          // Overrides the name verified against the server certificate
+	InsecureSkipVerify bool   `koanf:"insecure_skip_verify"` // Skip server verification; for testing only
 }
 
 // NodeAPIConfig holds Node Management API configuration
 type NodeAPIConfig struct {
-	BaseURL string        `koanf:"base_url"`
-	Timeout time.Duration `koanf:"timeout"`
+	Name           string        `koanf:"name"`     // Names the provider in logs, metrics and node status
+	Provider       string        `koanf:"provider"` // http|fake
+	BaseURL        string        `koanf:"base_url"`
+	Timeout        time.Duration `koanf:"timeout"`
+	CreateKeyTTL   time.Duration `koanf:"create_key_ttl"`   // How long the key of an unresolved creation is reused
+	FakeBootDelay  time.Duration `koanf:"fake_boot_delay"`  // Simulated boot time of fake nodes
+	FakeBootJitter time.Duration `koanf:"fake_boot_jitter"` // Random extra boot time of fake nodes, up to this much
+	FakeCapacity   int           `koanf:"fake_capacity"`    // Fake nodes that may exist at once; 0 for no limit
+	FakeNoCapacity []string      `koanf:"fake_no_capacity"` // Zones where the fake provider has no capacity
+
+	// Fallbacks are tried in order when the provider above has no capacity
+	Fallbacks []ProviderConfig `koanf:"fallbacks"`
+
+	// Spillover is where each provider is asked for nodes it has no
+	// capacity for, before the next provider
+	Spillover SpilloverConfig `koanf:"spillover"`
+}
+
+// SpilloverConfig holds the zones and instance types asked in turn when a
+// provider has no capacity
+type SpilloverConfig struct {
+	Zones         []string            `koanf:"zones"`          // Asked in order; empty lets the provider choose
+	InstanceTypes map[string][]string `koanf:"instance_types"` // Alternatives asked in order, by instance type
+	ZoneCooldown  time.Duration       `koanf:"zone_cooldown"`  // How long a zone out of capacity is passed over
+}
+
+// ProviderConfig holds a fallback node provider. Unset fields other than
+// the name take the node_api value.
+type ProviderConfig struct {
+	Name           string        `koanf:"name"`
+	Provider       string        `koanf:"provider"` // http|fake
+	BaseURL        string        `koanf:"base_url"`
+	Timeout        time.Duration `koanf:"timeout"`
+	FakeCapacity   int           `koanf:"fake_capacity"`
+	FakeNoCapacity []string      `koanf:"fake_no_capacity"`
 }
 
 // PredictionConfig holds prediction algorithm configuration
 type PredictionConfig struct {
-	ActivityWindow         time.Duration `koanf:"activity_window"`
-	ActivityThreshold      int           `koanf:"activity_threshold"`
-	PredictionWindow       time.Duration `koanf:"prediction_window"`
-	MinReadyNodes          int           `koanf:"min_ready_nodes"`
-	MaxReadyNodes          int           `koanf:"max_ready_nodes"`
+	ScalingMode             string             `koanf:"scaling_mode"`
+	TargetHeadroom          float64            `koanf:"target_headroom"`
+	ActivityWindow          time.Duration      `koanf:"activity_window"`
+	ActivityThreshold       int                `koanf:"activity_threshold"`
+	ActivityWeights         map[string]float64 `koanf:"activity_weights"`      // Score of each activity type towards activity_threshold; untyped and unlisted types score 1
+	ActivityHorizon         time.Duration      `koanf:"activity_horizon"`      // How long each user's activity is kept per minute
+	ActivityBurstFactor     float64            `koanf:"activity_burst_factor"` // Times a user's usual activity per minute that makes a minute a burst
+	PredictionWindow        time.Duration      `koanf:"prediction_window"`
+	MinReadyNodes           int                `koanf:"min_ready_nodes"`
+	MaxReadyNodes           int                `koanf:"max_ready_nodes"`
+	BurstMaxNodes           int                `koanf:"burst_max_nodes"`    // Hard cap demand spikes may take the pool to above max_ready_nodes; 0 disables bursting
+	BurstIdleTimeout        time.Duration      `koanf:"burst_idle_timeout"` // Idle timeout of nodes provisioned above max_ready_nodes
+	IdleTerminationTimeout  time.Duration      `koanf:"idle_termination_timeout"`
+	BootingNodeTimeout      time.Duration      `koanf:"booting_node_timeout"`
+	ScalingCheckInterval    time.Duration      `koanf:"scaling_check_interval"`
+	ScaleUpCooldown         time.Duration      `koanf:"scale_up_cooldown"`
+	ScaleDownCooldown       time.Duration      `koanf:"scale_down_cooldown"`
+	MaxTerminationsPerTick  int                `koanf:"max_terminations_per_tick"` // Healthy nodes terminated per scaling check at most; 0 is unlimited
+	ReservationEnabled      bool               `koanf:"reservation_enabled"`
+	ForecastEnabled         bool               `koanf:"forecast_enabled"`
+	LeadTimeEnabled         bool               `koanf:"lead_time_enabled"` // Stretch the prediction window to the p90 observed boot time
+	ForecastBucket          time.Duration      `koanf:"forecast_bucket"`
+	ForecastSeason          time.Duration      `koanf:"forecast_season"`
+	ForecastAlpha           float64            `koanf:"forecast_alpha"`
+	MaxNodeAge              time.Duration      `koanf:"max_node_age"`              // 0 disables rotation
+	TerminatedNodeRetention time.Duration      `koanf:"terminated_node_retention"` // How long terminated nodes stay in the pool before they are archived and purged
+	BootRetryBudget         int                `koanf:"boot_retry_budget"`         // Nodes tried in a row before a failed boot is not replaced
+	BootFailureThreshold    int                `koanf:"boot_failure_threshold"`    // Consecutive boot failures before provisioning backs off
+	BootFailureBackoff      time.Duration      `koanf:"boot_failure_backoff"`      // First backoff, doubled per further failure
+	BootFailureMaxBackoff   time.Duration      `koanf:"boot_failure_max_backoff"`  // Backoff cap
+	UsersPerNode            int                `koanf:"users_per_node"`            // Users sharing a node; 1 gives each user a dedicated node
+	UserRetention           time.Duration      `koanf:"user_retention"`            // Disconnected users not seen for this long are forgotten
+	MaxTrackedUsers         int                `koanf:"max_tracked_users"`         // Users tracked at most; the least recently seen disconnected ones go first
+	UserCleanupInterval     time.Duration      `koanf:"user_cleanup_interval"`     // How often forgotten users are dropped
+	SurplusScaleDown        bool               `koanf:"surplus_scale_down"`        // Retire ready nodes beyond forecast demand before their idle timeout
+	SurplusMargin           float64            `koanf:"surplus_margin"`            // Fraction of demand kept on top of it
+	SurplusStep             int                `koanf:"surplus_step"`              // Nodes retired per scale-down cooldown
+	DryRun                  bool               `koanf:"dry_run"`                   // Make and report scaling decisions without provisioning or retiring nodes for them
+
+	// Per-type pools; when set, default_instance_type must be one of them
+	InstanceTypes       map[string]InstanceTypeConfig `koanf:"instance_types"`
+	DefaultInstanceType string                        `koanf:"default_instance_type"`
+
+	// Rules run in order before the built-in demand rules, in demand mode
+	ScalingPolicy         []ScalingRuleConfig `koanf:"scaling_policy"`
+	ScalingPolicyTimezone string              `koanf:"scaling_policy_timezone"` // IANA zone the rules read hour and weekday in
+
+	Plugin   PredictorPluginConfig `koanf:"plugin"`
+	Schedule ScheduleConfig        `koanf:"schedule"`
+}
+
+// ScalingRuleConfig is one scaling rule: when the if condition holds, the
+// then action is taken, e.g. if "hour in 22-06" then "max_ready = 1"
+type ScalingRuleConfig struct {
+	If            string   `koanf:"if"`
+	Then          string   `koanf:"then"`           // hold, scale_up N, burst N, scale_down N, or min_ready|max_ready|burst_max = N
+	Reason        string   `koanf:"reason"`         // Given for the decision; defaults to the condition
+	InstanceTypes []string `koanf:"instance_types"` // Pools the rule applies to; empty for all
+}
+
+// Rule returns the rule for the policy compiler
+func (r ScalingRuleConfig) Rule() policy.Rule {
+	return policy.Rule{If: r.If, Then: r.Then, Reason: r.Reason, InstanceTypes: r.InstanceTypes}
+}
+
+// ScheduleConfig adds the attendees of scheduled classes and workshops to
+// predicted demand, ahead of their start
+type ScheduleConfig struct {
+	Enabled          bool          `koanf:"enabled"`           // Plan for sessions pushed to /admin/schedule or listed by url
+	URL              string        `koanf:"url"`               // Calendar feed polled for sessions; empty takes pushed sessions only
+	Format           string        `koanf:"format"`            // ical|json
+	RefreshInterval  time.Duration `koanf:"refresh_interval"`  // How often the feed is fetched
+	Timeout          time.Duration `koanf:"timeout"`           // How long a fetch may take
+	Horizon          time.Duration `koanf:"horizon"`           // How far ahead recurring feed events are expanded
+	Lead             time.Duration `koanf:"lead"`              // How long before a session starts its attendees count, on top of the prediction window
+	Attendance       float64       `koanf:"attendance"`        // Fraction of attendees expected to connect
+	DefaultAttendees int           `koanf:"default_attendees"` // Assumed for feed events that give no attendee count
+}
+
+// PredictorPluginConfig points scaling decisions at an out-of-process
+// predictor serving the PredictorPlugin gRPC contract
+type PredictorPluginConfig struct {
+	Address string        `koanf:"address"` // host:port of the plugin; empty keeps the built-in prediction only
+	Timeout time.Duration `koanf:"timeout"` // How long a scaling check waits for decisions before falling back
+	TLS     bool          `koanf:"tls"`     // Dial with TLS verified against the system pool
+}
+
+// InstanceTypeConfig overrides pool limits and timeouts for one instance type;
+// unset fields fall back to the shared prediction settings
+type InstanceTypeConfig struct {
+	MinReadyNodes          *int          `koanf:"min_ready_nodes"`
+	MaxReadyNodes          *int          `koanf:"max_ready_nodes"`
+	BurstMaxNodes          *int          `koanf:"burst_max_nodes"`
+	BurstIdleTimeout       time.Duration `koanf:"burst_idle_timeout"`
 	IdleTerminationTimeout time.Duration `koanf:"idle_termination_timeout"`
 	BootingNodeTimeout     time.Duration `koanf:"booting_node_timeout"`
-	ScalingCheckInterval   time.Duration `koanf:"scaling_check_interval"`
+	UsersPerNode           int           `koanf:"users_per_node"`
+}
+
+// AllocationConfig holds allocation coordination configuration
+type AllocationConfig struct {
+	Claims         string        `koanf:"claims"`           // local|redis; redis coordinates replicas handling the same events
+	ClaimKeyPrefix string        `koanf:"claim_key_prefix"` // Prefix of the per-node claim hashes in Redis
+	ClaimTTL       time.Duration `koanf:"claim_ttl"`        // Claims not renewed by a scaling check for this long lapse
+	UserStore      string        `koanf:"user_store"`       // none|redis; redis restores connected users after a restart
+	UserStoreKey   string        `koanf:"user_store_key"`   // Redis hash holding the connected users
+
+	// Handing the pool off on shutdown lets the replacing service start
+	// with every node and allocation instead of rebuilding them from events
+	Handoff       string        `koanf:"handoff"`         // none|file|redis
+	HandoffFile   string        `koanf:"handoff_file"`    // Path written with handoff: file, e.g. on a volume the next pod mounts
+	HandoffKey    string        `koanf:"handoff_key"`     // Redis key written with handoff: redis, after events.channel_prefix
+	HandoffMaxAge time.Duration `koanf:"handoff_max_age"` // Older handoffs are ignored for a cold restore
+	HandoffSecret string        `koanf:"handoff_secret"`  // Base64 32-byte key node auth tokens are encrypted with; empty leaves them out
+
+	MigrationTimeout time.Duration `koanf:"migration_timeout"` // Time a client has to acknowledge a migration
+	MigrateOnDrain   bool          `koanf:"migrate_on_drain"`  // Migrate users off draining nodes instead of waiting for them to leave
+
+	// Time a connecting user has to confirm attaching before their reserved
+	// slot returns to the pool; zero allocates on connect without confirmation
+	ConfirmTTL time.Duration `koanf:"confirm_ttl"`
+
+	// Users and tenants that always have a warm node held for them
+	DedicatedUsers   []string `koanf:"dedicated_users"`
+	DedicatedTenants []string `koanf:"dedicated_tenants"`
+
+	// Time-to-node budgets by the tier user connects carry; users without a
+	// tier fall under default_tier, and users of unlisted tiers have none
+	Tiers       map[string]TierConfig `koanf:"tiers"`
+	DefaultTier string                `koanf:"default_tier"`
+
+	// Users and tenants entitled to each tier; the tier a connect carries
+	// is ignored for anyone not listed for it
+	TierMembers map[string]TierMembersConfig `koanf:"tier_members"`
+
+	// Reclaiming nodes from users who stay connected but leave them idle
+	IdleReclaim IdleReclaimConfig `koanf:"idle_reclaim"`
+
+	// Recommending smaller or larger instance types from how users use theirs
+	Rightsizing RightsizingConfig `koanf:"rightsizing"`
+
+	// Comparing the pool with the user tracker for drift
+	Consistency ConsistencyConfig `koanf:"consistency"`
+
+	// Limiting how often each user's connects and disconnects are handled
+	RateLimit RateLimitConfig `koanf:"rate_limit"`
+
+	// Bounding the sessions each user holds at once, e.g. from several devices
+	SessionLimit SessionLimitConfig `koanf:"session_limit"`
+}
+
+// SessionLimitConfig bounds the concurrent sessions of each user; sessions
+// of one user share their node
+type SessionLimitConfig struct {
+	MaxPerUser int            `koanf:"max_per_user"` // Sessions a user holds at once; 0 for no limit
+	Tiers      map[string]int `koanf:"tiers"`        // In place of max_per_user for users of these tiers
+}
+
+// RateLimitConfig holds the token bucket each user's connect and disconnect
+// events are counted against
+type RateLimitConfig struct {
+	PerMinute float64 `koanf:"per_minute"` // Tokens refilled per minute; 0 disables limiting
+	Burst     int     `koanf:"burst"`      // Tokens a full bucket holds
+	Store     string  `koanf:"store"`      // local|redis; redis shares buckets across replicas
+	KeyPrefix string  `koanf:"key_prefix"` // Prefix of the per-user bucket hashes in Redis
+}
+
+// ConsistencyConfig holds how often the pool and the user tracker are
+// compared, and what is done about a mismatch
+type ConsistencyConfig struct {
+	Interval time.Duration `koanf:"interval"` // 0 disables the checks
+	Policy   string        `koanf:"policy"`   // alert|repair
+}
+
+// IdleReclaimConfig bounds how long a connected user may leave their node idle
+type IdleReclaimConfig struct {
+	Timeout         time.Duration `koanf:"timeout"`          // Time below busy_threshold before the user is warned; 0 disables reclaiming
+	BusyThreshold   float64       `koanf:"busy_threshold"`   // GPU utilization percent that counts as use
+	Warnings        int           `koanf:"warnings"`         // Warnings sent, warning_interval apart, before the node is reclaimed
+	WarningInterval time.Duration `koanf:"warning_interval"` // Time between warnings, and from the last warning to the reclaim
+	SampleMaxAge    time.Duration `koanf:"sample_max_age"`   // Nodes without a newer utilization sample are not judged idle
+}
+
+// RightsizingConfig holds when a user is recommended another instance type.
+// Listing sizes enables recommendations.
+type RightsizingConfig struct {
+	Sizes             []string          `koanf:"sizes"`               // Instance types from smallest to largest
+	AutoSelect        bool              `koanf:"auto_select"`         // Prefer nodes of the recommended type at the user's next connect
+	Window            time.Duration     `koanf:"window"`              // How far back utilization samples and activities count
+	MinSamples        int               `koanf:"min_samples"`         // Samples needed before a change is recommended from utilization
+	DownsizeBelow     float64           `koanf:"downsize_below"`      // Average GPU percent below which the next smaller type is recommended
+	UpsizeAbove       float64           `koanf:"upsize_above"`        // Average GPU percent at or above which the next larger type is recommended
+	MemoryUpsizeAbove float64           `koanf:"memory_upsize_above"` // Peak GPU memory percent at or above which the next larger type is recommended; 0 ignores memory
+	ActivitySizes     map[string]string `koanf:"activity_sizes"`      // Smallest size each activity type needs, e.g. {"train": "gpu-large"}
+}
+
+// TierConfig bounds how long users of a tier wait for a node
+type TierConfig struct {
+	MaxWait      time.Duration `koanf:"max_wait"`      // Budget from the first connect that found no warm node
+	Escalation   []string      `koanf:"escalation"`    // instance_type|provider|shared_node, tried in order, one per further max_wait
+	InstanceType string        `koanf:"instance_type"` // Provisioned by the instance_type step
+}
+
+// TierMembersConfig lists the users and tenants entitled to a tier
+type TierMembersConfig struct {
+	Users   []string `koanf:"users"`
+	Tenants []string `koanf:"tenants"`
+}
+
+// BudgetConfig holds node prices and spend limits; zero limits are not enforced
+type BudgetConfig struct {
+	MaxHourlySpend float64            `koanf:"max_hourly_spend"` // Cap on the hourly price of running nodes
+	MaxDailySpend  float64            `koanf:"max_daily_spend"`  // Cap on spend per UTC day
+	HourlyPrice    float64            `koanf:"hourly_price"`     // Price of instance types without their own
+	Prices         map[string]float64 `koanf:"prices"`           // Hourly price by instance type
+}
+
+// PurchasingConfig holds how nodes are bought: from reserved capacity, as
+// spot or on demand
+type PurchasingConfig struct {
+	Enabled             bool           `koanf:"enabled"`               // Ask providers for a purchase option per node; off lets them choose
+	TargetSpotRatio     float64        `koanf:"target_spot_ratio"`     // Share of nodes not on reserved capacity bought as spot
+	Reserved            map[string]int `koanf:"reserved"`              // Reserved capacity, as nodes by instance type
+	MaxInterruptionRate float64        `koanf:"max_interruption_rate"` // Spot interruptions per spot launch above which a type is bought on demand; 0 never backs off
+	InterruptionWindow  time.Duration  `koanf:"interruption_window"`   // Time over which interruptions and launches are counted
+	OnDemandTiers       []string       `koanf:"on_demand_tiers"`       // Tiers never placed on spot nodes
+}
+
+// AccessConfig holds who may be allocated a node
+type AccessConfig struct {
+	Mode          string        `koanf:"mode"`            // open|allowlist
+	Blocklist     []string      `koanf:"blocklist"`       // Users never allocated a node
+	Allowlist     []string      `koanf:"allowlist"`       // Users allowed a node in allowlist mode
+	AuthzURL      string        `koanf:"authz_url"`       // External authorization service; empty disables it
+	AuthzTimeout  time.Duration `koanf:"authz_timeout"`   // Timeout of each authorization request
+	AuthzFailOpen bool          `koanf:"authz_fail_open"` // Allow users when the authorization service fails
+}
+
+// AgentConfig holds node agent compatibility configuration
+type AgentConfig struct {
+	MinVersion      string   `koanf:"min_version"`
+	BlockedVersions []string `koanf:"blocked_versions"`
+}
+
+// MetricsConfig holds metrics collection configuration
+type MetricsConfig struct {
+	HistoryRetention time.Duration `koanf:"history_retention"`
+	SLOWindow        time.Duration `koanf:"slo_window"`         // Rolling window for cold-start compliance
+	SLOTarget        float64       `koanf:"slo_target"`         // Target share of warm starts
+	AccuracyWindow   time.Duration `koanf:"accuracy_window"`    // Rolling window for prediction precision and recall
+	JournalRetention time.Duration `koanf:"journal_retention"`  // How long node and user history is kept
+	JournalMaxEvents int           `koanf:"journal_max_events"` // Events kept per node and per user
+}
+
+// EventsConfig selects the inbound event transport and the outbound envelope
+type EventsConfig struct {
+	Transport             string `koanf:"transport"`               // redis|nats|memory
+	CloudEvents           bool   `koanf:"cloudevents"`             // Wrap outbound events in a CloudEvents envelope
+	CloudEventsSource     string `koanf:"cloudevents_source"`      // Envelope source attribute
+	CloudEventsTypePrefix string `koanf:"cloudevents_type_prefix"` // Prepended to the channel to form the type
+
+	// Node status webhooks from the Node API or cloud provider
+	WebhookSecret    string        `koanf:"webhook_secret"`    // HMAC key webhooks are signed with; empty disables them
+	WebhookTolerance time.Duration `koanf:"webhook_tolerance"` // Accepted clock skew of a webhook's timestamp
+
+	// Poison messages: a payload rejected as invalid this many times is
+	// dead-lettered once and its copies dropped for PoisonTTL; 0 disables
+	PoisonThreshold int           `koanf:"poison_threshold"`
+	PoisonTTL       time.Duration `koanf:"poison_ttl"`
+
+	// Node status from Redis key-space notifications on node state keys
+	Keyspace KeyspaceConfig `koanf:"keyspace"`
+
+	// Channel names on Redis: a prefix for every channel, and the pattern
+	// naming a tenant's channels, e.g. "{tenant}:{channel}"
+	ChannelPrefix        string `koanf:"channel_prefix"`
+	TenantChannelPattern string `koanf:"tenant_channel_pattern"`
+
+	// Sealing events on a transport shared with less-trusted services
+	Encryption EncryptionConfig `koanf:"encryption"`
+}
+
+// EncryptionConfig seals outbound events and opens inbound ones with shared
+// keys, named by ID so they can be rotated
+type EncryptionConfig struct {
+	Mode      string            `koanf:"mode"`       // off|sign|encrypt
+	Keys      map[string]string `koanf:"keys"`       // Key ID -> base64 32-byte key, or its KMS ciphertext blob with key_source kms
+	ActiveKey string            `koanf:"active_key"` // Seals outbound events; every key opens inbound ones
+	Require   bool              `koanf:"require"`    // Reject inbound events that are not sealed
+	KeySource string            `koanf:"key_source"` // config|kms
+	KMSRegion string            `koanf:"kms_region"` // Empty uses the environment's
+
+	// MaxSkew is how far from now an inbound event's issue time may be;
+	// older ones are rejected as replays
+	MaxSkew time.Duration `koanf:"max_skew"`
+}
+
+// KeyspaceConfig selects the node state keys whose writes are turned into
+// node status events
+type KeyspaceConfig struct {
+	Enabled   bool   `koanf:"enabled"`
+	Pattern   string `koanf:"pattern"`   // A single * stands for the node ID
+	Configure bool   `koanf:"configure"` // Enable key-space notifications on the server at startup
+}
+
+// NATSConfig holds NATS JetStream configuration
+type NATSConfig struct {
+	URL           string `koanf:"url"`
+	Stream        string `koanf:"stream"`
+	Durable       string `koanf:"durable"`
+	SubjectPrefix string `koanf:"subject_prefix"`
+	MaxDeliver    int    `koanf:"max_deliver"`
+}
+
+// HAConfig holds leader election and state replication between replicas
+type HAConfig struct {
+	Mode        string        `koanf:"mode"` // none|etcd; etcd elects one replica to scale and allocate
+	Endpoints   []string      `koanf:"endpoints"`
+	Username    string        `koanf:"username"`
+	Password    string        `koanf:"password"`
+	DialTimeout time.Duration `koanf:"dial_timeout"`
+	Prefix      string        `koanf:"prefix"`     // Namespaces the leader key and replicated state
+	ReplicaID   string        `koanf:"replica_id"` // Identifies this replica; the hostname when empty
+	LeaseTTL    time.Duration `koanf:"lease_ttl"`  // How long a leader that stopped responding keeps leading
+}
+
+// SessionsConfig holds billing session export configuration
+type SessionsConfig struct {
+	Sink          string        `koanf:"sink"` // none|redis|kafka|s3
+	FlushInterval time.Duration `koanf:"flush_interval"`
+	BatchSize     int           `koanf:"batch_size"`
+	MaxBuffered   int           `koanf:"max_buffered"` // Records kept while the sink is failing
+	RedisStream   string        `koanf:"redis_stream"`
+	RedisMaxLen   int64         `koanf:"redis_max_len"`
+	KafkaBrokers  []string      `koanf:"kafka_brokers"`
+	KafkaTopic    string        `koanf:"kafka_topic"`
+	S3Bucket      string        `koanf:"s3_bucket"`
+	S3Prefix      string        `koanf:"s3_prefix"`
+	S3Region      string        `koanf:"s3_region"` // Empty uses the AWS environment's region
+}
+
+// HealthConfig holds dependency check configuration for /health
+type HealthConfig struct {
+	Timeout         time.Duration `koanf:"timeout"`            // Per-check timeout
+	NodeAPICacheTTL time.Duration `koanf:"node_api_cache_ttl"` // How long a Node API check result is reused
+	MaxCheckAge     time.Duration `koanf:"max_check_age"`      // Oldest acceptable successful scaling check; 0 means 3 check intervals
+}
+
+// StartupConfig bounds the startup phases and the application lifecycle
+type StartupConfig struct {
+	HydrateTimeout   time.Duration `koanf:"hydrate_timeout"`   // Taking over the handed off pool or restoring connected users
+	ReconcileTimeout time.Duration `koanf:"reconcile_timeout"` // Checking the hydrated nodes with their providers
+	SubscribeTimeout time.Duration `koanf:"subscribe_timeout"` // Waiting for the event subscription
+	StartTimeout     time.Duration `koanf:"start_timeout"`     // Running every component's start hook
+	StopTimeout      time.Duration `koanf:"stop_timeout"`      // Draining and stopping every component on shutdown
+}
+
+// LogConfig holds logger configuration
+type LogConfig struct {
+	Level              string            `koanf:"level"`  // debug|info|warn|error
+	Format             string            `koanf:"format"` // json|console
+	Sampling           bool              `koanf:"sampling"`
+	SamplingInitial    int               `koanf:"sampling_initial"`    // Entries per second logged for each message before sampling
+	SamplingThereafter int               `koanf:"sampling_thereafter"` // Every Nth entry logged after that
+	File               string            `koanf:"file"`                // Also append logs to this file
+	LokiURL            string            `koanf:"loki_url"`            // Also push logs to Loki
+	LokiLabels         map[string]string `koanf:"loki_labels"`
+	LokiBatchWait      time.Duration     `koanf:"loki_batch_wait"`
+}
+
+// HooksConfig holds node lifecycle hooks, run in order within each stage
+type HooksConfig struct {
+	PreReady     []HookConfig `koanf:"pre_ready"`     // Must pass before a node is offered to users
+	PostAllocate []HookConfig `koanf:"post_allocate"` // Best-effort warmup after allocation
+	PreTerminate []HookConfig `koanf:"pre_terminate"` // Best-effort cleanup before termination
+
+	// Probe checks a booted node's health endpoint before any pre_ready hook
+	Probe ProbeConfig `koanf:"probe"`
+}
+
+// ProbeConfig describes the readiness probe of booted nodes
+type ProbeConfig struct {
+	Enabled  bool          `koanf:"enabled"`
+	Scheme   string        `koanf:"scheme"` // http|https
+	Path     string        `koanf:"path"`
+	Port     int           `koanf:"port"` // 0 probes the port the node reported
+	Attempts int           `koanf:"attempts"`
+	Interval time.Duration `koanf:"interval"` // Wait between attempts
+	Timeout  time.Duration `koanf:"timeout"`  // Timeout of each attempt
+}
+
+// HookConfig describes a single lifecycle hook
+type HookConfig struct {
+	Name    string        `koanf:"name"`
+	Type    string        `koanf:"type"`    // http|script
+	URL     string        `koanf:"url"`     // http hooks
+	Command []string      `koanf:"command"` // script hooks
+	Timeout time.Duration `koanf:"timeout"`
+}
+
+// ChaosConfig configures fault injection for resilience testing. Rates are
+// probabilities from 0 to 1.
+type ChaosConfig struct {
+	Enabled            bool          `koanf:"enabled"`
+	Seed               uint64        `koanf:"seed"` // 0 picks a random seed, which is logged
+	NodeAPIDelayRate   float64       `koanf:"node_api_delay_rate"`
+	NodeAPIMaxDelay    time.Duration `koanf:"node_api_max_delay"`
+	NodeAPIFailureRate float64       `koanf:"node_api_failure_rate"`
+	EventDropRate      float64       `koanf:"event_drop_rate"`
+	StatusFlipRate     float64       `koanf:"status_flip_rate"`
 }
 
-// Load loads configuration from environment variables and optional config file
-func Load(configPath string) (*Config, error) {
+// Load loads configuration from the given files, in order, followed by
+// environment variables. Files may be JSON, YAML or TOML, chosen by extension,
+// and later files override earlier ones. When environment is set, an overlay
+// named <name>.<environment><ext> next to each file is applied after it if it
+// exists, e.g. config.yaml followed by config.production.yaml. A profile, if
+// given, supplies defaults beneath all of them.
+func Load(profile, environment string, paths ...string) (*Config, error) {
 	k := koanf.New(".")
 
-	// Load from config file if provided
-	if configPath != "" {
-		if err := k.Load(file.Provider(configPath), json.Parser()); err != nil {
-			return nil, fmt.Errorf("error loading config file: %w", err)
+	if err := loadProfile(k, profile); err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		if err := loadFile(k, path); err != nil {
+			return nil, err
+		}
+
+		if environment == "" {
+			continue
+		}
+		overlay := overlayPath(path, environment)
+		if _, err := os.Stat(overlay); err == nil {
+			if err := loadFile(k, overlay); err != nil {
+				return nil, err
+			}
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("error reading config overlay: %w", err)
 		}
 	}
 
@@ -73,15 +602,109 @@ func Load(configPath string) (*Config, error) {
 	if err := k.Unmarshal("", &cfg); err != nil {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
+	cfg.Profile = profile
+
+	if cfg.Dev {
+		cfg.EnableDevMode()
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
 
+// EnableDevMode replaces the Node API with the fake provider and Redis with
+// the in-process event bus, so the service runs with no external dependencies
+func (c *Config) EnableDevMode() {
+	c.Dev = true
+	c.NodeAPI.Provider = "fake"
+	c.Events.Transport = "memory"
+	c.Allocation.Claims = "local"
+	c.Allocation.RateLimit.Store = "local"
+	if c.Allocation.UserStore == "redis" {
+		c.Allocation.UserStore = "none"
+	}
+	if c.Allocation.Handoff == "redis" {
+		c.Allocation.Handoff = "none"
+	}
+	c.HA.Mode = "none"
+}
+
+// Hash returns a short fingerprint of the effective configuration, so
+// replicas running different settings can be told apart. Secrets are left
+// out; changing only a secret keeps the hash.
+func (c *Config) Hash() string {
+	redacted := *c
+	redacted.Server.AdminToken = ""
+	redacted.Server.JWT.Secret = ""
+	redacted.Redis.Password = ""
+	redacted.Redis.SentinelPassword = ""
+	redacted.Events.WebhookSecret = ""
+	redacted.Events.Encryption.Keys = nil
+	redacted.Allocation.HandoffSecret = ""
+	redacted.HA.Password = ""
+	redacted.HA.ReplicaID = ""
+
+	data, err := stdjson.Marshal(redacted)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// loadFile merges a config file into k using the parser for its extension
+func loadFile(k *koanf.Koanf, path string) error {
+	var parser koanf.Parser
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		parser = json.Parser()
+	case ".yaml", ".yml":
+		parser = yaml.Parser()
+	case ".toml":
+		parser = toml.Parser()
+	default:
+		return fmt.Errorf("unsupported config file format: %s", path)
+	}
+
+	if err := k.Load(file.Provider(path), parser); err != nil {
+		return fmt.Errorf("error loading config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// overlayPath returns the environment overlay for a config file
+func overlayPath(path, environment string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + environment + ext
+}
+
+// defaultActivityWeights make opening the editor or submitting a job enough
+// on its own to predict a connect, and heartbeats from a background tab
+// nearly worthless; each can be overridden separately
+var defaultActivityWeights = map[string]float64{
+	"page_view":   1,
+	"editor_open": 3,
+	"job_submit":  3,
+	"heartbeat":   0.1,
+}
+
 func setDefaults(k *koanf.Koanf) {
 	// Server defaults
 	k.Set("server.port", 8081)
+	if k.String("server.jwt.role_claim") == "" {
+		k.Set("server.jwt.role_claim", "role")
+	}
+	if k.String("server.jwt.tenant_claim") == "" {
+		k.Set("server.jwt.tenant_claim", "tenant_id")
+	}
 
 	// Redis defaults
+	if k.String("redis.mode") == "" {
+		k.Set("redis.mode", "standalone")
+	}
 	if k.String("redis.addr") == "" {
 		k.Set("redis.addr", "localhost:6379")
 	}
@@ -92,21 +715,283 @@ func setDefaults(k *koanf.Koanf) {
 		k.Set("redis.db", 0)
 	}
 
+	// Allocation defaults
+	if k.String("allocation.claims") == "" {
+		k.Set("allocation.claims", "local")
+	}
+	if k.String("allocation.claim_key_prefix") == "" {
+		k.Set("allocation.claim_key_prefix", "provisioning:claims:")
+	}
+	if k.Duration("allocation.claim_ttl") == 0 {
+		k.Set("allocation.claim_ttl", 2*time.Minute)
+	}
+	if k.String("allocation.user_store") == "" {
+		k.Set("allocation.user_store", "none")
+	}
+	if k.String("allocation.user_store_key") == "" {
+		k.Set("allocation.user_store_key", "provisioning:users")
+	}
+	if k.String("allocation.handoff") == "" {
+		k.Set("allocation.handoff", "none")
+	}
+	if k.String("allocation.handoff_file") == "" {
+		k.Set("allocation.handoff_file", "/var/lib/provisioning/handoff.json")
+	}
+	if k.String("allocation.handoff_key") == "" {
+		k.Set("allocation.handoff_key", "provisioning:handoff")
+	}
+	if k.Duration("allocation.handoff_max_age") == 0 {
+		k.Set("allocation.handoff_max_age", 5*time.Minute)
+	}
+	if k.Duration("allocation.migration_timeout") == 0 {
+		k.Set("allocation.migration_timeout", 30*time.Second)
+	}
+	if k.Float64("allocation.idle_reclaim.busy_threshold") == 0 {
+		k.Set("allocation.idle_reclaim.busy_threshold", 5.0)
+	}
+	if k.Duration("allocation.rightsizing.window") == 0 {
+		k.Set("allocation.rightsizing.window", 24*time.Hour)
+	}
+	if !k.Exists("allocation.rightsizing.min_samples") {
+		k.Set("allocation.rightsizing.min_samples", 20)
+	}
+	if !k.Exists("allocation.rightsizing.downsize_below") {
+		k.Set("allocation.rightsizing.downsize_below", 20.0)
+	}
+	if k.Float64("allocation.rightsizing.upsize_above") == 0 {
+		k.Set("allocation.rightsizing.upsize_above", 85.0)
+	}
+	if !k.Exists("allocation.rightsizing.memory_upsize_above") {
+		k.Set("allocation.rightsizing.memory_upsize_above", 90.0)
+	}
+	if !k.Exists("allocation.idle_reclaim.warnings") {
+		k.Set("allocation.idle_reclaim.warnings", 2)
+	}
+	if k.Duration("allocation.idle_reclaim.warning_interval") == 0 {
+		k.Set("allocation.idle_reclaim.warning_interval", 5*time.Minute)
+	}
+	if k.Duration("allocation.idle_reclaim.sample_max_age") == 0 {
+		k.Set("allocation.idle_reclaim.sample_max_age", 2*time.Minute)
+	}
+	if !k.Exists("allocation.consistency.interval") {
+		k.Set("allocation.consistency.interval", time.Minute)
+	}
+	if k.String("allocation.consistency.policy") == "" {
+		k.Set("allocation.consistency.policy", "alert")
+	}
+	if k.Int("allocation.rate_limit.burst") == 0 {
+		k.Set("allocation.rate_limit.burst", 5)
+	}
+	// Buckets are shared through Redis whenever events already go through
+	// it, so a user is held to one limit however many replicas see them
+	if k.String("allocation.rate_limit.store") == "" {
+		store := "local"
+		if transport := k.String("events.transport"); transport == "" || transport == "redis" {
+			store = "redis"
+		}
+		k.Set("allocation.rate_limit.store", store)
+	}
+	if k.String("allocation.rate_limit.key_prefix") == "" {
+		k.Set("allocation.rate_limit.key_prefix", "provisioning:ratelimit:")
+	}
+
+	// Purchasing defaults
+	if k.Duration("purchasing.interruption_window") == 0 {
+		k.Set("purchasing.interruption_window", time.Hour)
+	}
+
+	// Access defaults
+	if k.String("access.mode") == "" {
+		k.Set("access.mode", "open")
+	}
+	if k.Duration("access.authz_timeout") == 0 {
+		k.Set("access.authz_timeout", 2*time.Second)
+	}
+
+	// Event transport defaults
+	if k.String("events.transport") == "" {
+		k.Set("events.transport", "redis")
+	}
+	if k.String("events.cloudevents_source") == "" {
+		k.Set("events.cloudevents_source", "/provisioning-service")
+	}
+	if k.String("events.cloudevents_type_prefix") == "" {
+		k.Set("events.cloudevents_type_prefix", "com.aos-cc.provisioning")
+	}
+	if k.Duration("events.webhook_tolerance") == 0 {
+		k.Set("events.webhook_tolerance", 5*time.Minute)
+	}
+	if !k.Exists("events.poison_threshold") {
+		k.Set("events.poison_threshold", 5)
+	}
+	if k.Duration("events.poison_ttl") == 0 {
+		k.Set("events.poison_ttl", time.Hour)
+	}
+	if k.String("events.encryption.mode") == "" {
+		k.Set("events.encryption.mode", "off")
+	}
+	if k.String("events.encryption.key_source") == "" {
+		k.Set("events.encryption.key_source", "config")
+	}
+	if k.Duration("events.encryption.max_skew") == 0 {
+		k.Set("events.encryption.max_skew", 5*time.Minute)
+	}
+	if k.String("events.keyspace.pattern") == "" {
+		k.Set("events.keyspace.pattern", "node:*:status")
+	}
+	if k.String("nats.url") == "" {
+		k.Set("nats.url", "nats://localhost:4222")
+	}
+	if k.String("nats.stream") == "" {
+		k.Set("nats.stream", "PROVISIONING_EVENTS")
+	}
+	if k.String("nats.durable") == "" {
+		k.Set("nats.durable", "provisioning-service")
+	}
+	if k.Int("nats.max_deliver") == 0 {
+		k.Set("nats.max_deliver", 5)
+	}
+
+	// Health check defaults
+	if k.Duration("health.timeout") == 0 {
+		k.Set("health.timeout", 2*time.Second)
+	}
+	if k.Duration("health.node_api_cache_ttl") == 0 {
+		k.Set("health.node_api_cache_ttl", 30*time.Second)
+	}
+
+	// Startup defaults
+	if k.Duration("startup.hydrate_timeout") == 0 {
+		k.Set("startup.hydrate_timeout", 30*time.Second)
+	}
+	if k.Duration("startup.reconcile_timeout") == 0 {
+		k.Set("startup.reconcile_timeout", time.Minute)
+	}
+	if k.Duration("startup.subscribe_timeout") == 0 {
+		k.Set("startup.subscribe_timeout", 30*time.Second)
+	}
+	if k.Duration("startup.start_timeout") == 0 {
+		k.Set("startup.start_timeout", 15*time.Second)
+	}
+	if k.Duration("startup.stop_timeout") == 0 {
+		k.Set("startup.stop_timeout", 30*time.Second)
+	}
+
+	// HA defaults
+	if k.String("ha.mode") == "" {
+		k.Set("ha.mode", "none")
+	}
+	if k.Duration("ha.dial_timeout") == 0 {
+		k.Set("ha.dial_timeout", 5*time.Second)
+	}
+	if k.String("ha.prefix") == "" {
+		k.Set("ha.prefix", "/provisioning-service/")
+	}
+	if k.Duration("ha.lease_ttl") == 0 {
+		k.Set("ha.lease_ttl", 10*time.Second)
+	}
+
+	// Chaos defaults
+	if k.Duration("chaos.node_api_max_delay") == 0 {
+		k.Set("chaos.node_api_max_delay", 5*time.Second)
+	}
+
+	// Logging defaults
+	if k.String("log.level") == "" {
+		k.Set("log.level", "info")
+	}
+	if k.String("log.format") == "" {
+		k.Set("log.format", "json")
+	}
+	if !k.Exists("log.sampling") {
+		k.Set("log.sampling", true)
+	}
+	if k.Int("log.sampling_initial") == 0 {
+		k.Set("log.sampling_initial", 100)
+	}
+	if k.Int("log.sampling_thereafter") == 0 {
+		k.Set("log.sampling_thereafter", 100)
+	}
+	if k.Duration("log.loki_batch_wait") == 0 {
+		k.Set("log.loki_batch_wait", time.Second)
+	}
+
+	// Session export defaults
+	if k.String("sessions.sink") == "" {
+		k.Set("sessions.sink", "none")
+	}
+	if k.Duration("sessions.flush_interval") == 0 {
+		k.Set("sessions.flush_interval", 10*time.Second)
+	}
+	if k.Int("sessions.batch_size") == 0 {
+		k.Set("sessions.batch_size", 100)
+	}
+	if k.Int("sessions.max_buffered") == 0 {
+		k.Set("sessions.max_buffered", 10000)
+	}
+	if k.String("sessions.redis_stream") == "" {
+		k.Set("sessions.redis_stream", "sessions")
+	}
+	if k.Int64("sessions.redis_max_len") == 0 {
+		k.Set("sessions.redis_max_len", 1000000)
+	}
+	if k.String("sessions.kafka_topic") == "" {
+		k.Set("sessions.kafka_topic", "provisioning.sessions")
+	}
+	if k.String("sessions.s3_prefix") == "" {
+		k.Set("sessions.s3_prefix", "sessions")
+	}
+
 	// Node API defaults
+	if k.String("node_api.name") == "" {
+		k.Set("node_api.name", "primary")
+	}
+	if k.String("node_api.provider") == "" {
+		k.Set("node_api.provider", "http")
+	}
+	if k.Duration("node_api.fake_boot_delay") == 0 {
+		k.Set("node_api.fake_boot_delay", 5*time.Second)
+	}
 	if k.String("node_api.base_url") == "" {
 		k.Set("node_api.base_url", "http://localhost:8080")
 	}
 	if k.Duration("node_api.timeout") == 0 {
 		k.Set("node_api.timeout", 10*time.Second)
 	}
+	if k.Duration("node_api.create_key_ttl") == 0 {
+		k.Set("node_api.create_key_ttl", 10*time.Minute)
+	}
+	if k.Duration("node_api.spillover.zone_cooldown") == 0 {
+		k.Set("node_api.spillover.zone_cooldown", 5*time.Minute)
+	}
 
 	// Prediction defaults
+	if k.String("prediction.scaling_mode") == "" {
+		k.Set("prediction.scaling_mode", "demand")
+	}
+	if k.String("prediction.scaling_policy_timezone") == "" {
+		k.Set("prediction.scaling_policy_timezone", "UTC")
+	}
+	if k.Float64("prediction.target_headroom") == 0 {
+		k.Set("prediction.target_headroom", 0.2)
+	}
 	if k.Duration("prediction.activity_window") == 0 {
 		k.Set("prediction.activity_window", 2*time.Minute)
 	}
 	if k.Int("prediction.activity_threshold") == 0 {
 		k.Set("prediction.activity_threshold", 3)
 	}
+	if k.Duration("prediction.activity_horizon") == 0 {
+		k.Set("prediction.activity_horizon", 1*time.Hour)
+	}
+	if k.Float64("prediction.activity_burst_factor") == 0 {
+		k.Set("prediction.activity_burst_factor", 3.0)
+	}
+	for activityType, weight := range defaultActivityWeights {
+		if key := "prediction.activity_weights." + activityType; !k.Exists(key) {
+			k.Set(key, weight)
+		}
+	}
 	if k.Duration("prediction.prediction_window") == 0 {
 		k.Set("prediction.prediction_window", 1*time.Minute)
 	}
@@ -119,10 +1004,119 @@ func setDefaults(k *koanf.Koanf) {
 	if k.Duration("prediction.idle_termination_timeout") == 0 {
 		k.Set("prediction.idle_termination_timeout", 5*time.Minute)
 	}
+	if k.Duration("prediction.burst_idle_timeout") == 0 {
+		k.Set("prediction.burst_idle_timeout", time.Minute)
+	}
 	if k.Duration("prediction.booting_node_timeout") == 0 {
 		k.Set("prediction.booting_node_timeout", 2*time.Minute)
 	}
 	if k.Duration("prediction.scaling_check_interval") == 0 {
 		k.Set("prediction.scaling_check_interval", 10*time.Second)
 	}
+	if k.Duration("prediction.scale_up_cooldown") == 0 {
+		k.Set("prediction.scale_up_cooldown", 15*time.Second)
+	}
+	if k.Duration("prediction.scale_down_cooldown") == 0 {
+		k.Set("prediction.scale_down_cooldown", 2*time.Minute)
+	}
+	if k.Int("prediction.boot_retry_budget") == 0 {
+		k.Set("prediction.boot_retry_budget", 3)
+	}
+	if k.Int("prediction.users_per_node") == 0 {
+		k.Set("prediction.users_per_node", 1)
+	}
+	if k.Int("prediction.boot_failure_threshold") == 0 {
+		k.Set("prediction.boot_failure_threshold", 3)
+	}
+	if k.Duration("prediction.boot_failure_backoff") == 0 {
+		k.Set("prediction.boot_failure_backoff", 30*time.Second)
+	}
+	if k.Duration("prediction.boot_failure_max_backoff") == 0 {
+		k.Set("prediction.boot_failure_max_backoff", 10*time.Minute)
+	}
+	if k.Duration("prediction.forecast_bucket") == 0 {
+		k.Set("prediction.forecast_bucket", 15*time.Minute)
+	}
+	if k.Duration("prediction.forecast_season") == 0 {
+		k.Set("prediction.forecast_season", 24*time.Hour)
+	}
+	if k.Duration("prediction.user_retention") == 0 {
+		k.Set("prediction.user_retention", time.Hour)
+	}
+	if k.Duration("prediction.terminated_node_retention") == 0 {
+		k.Set("prediction.terminated_node_retention", time.Hour)
+	}
+	if k.Int("prediction.max_tracked_users") == 0 {
+		k.Set("prediction.max_tracked_users", 100000)
+	}
+	if k.Duration("prediction.user_cleanup_interval") == 0 {
+		k.Set("prediction.user_cleanup_interval", time.Minute)
+	}
+	if k.Float64("prediction.surplus_margin") == 0 {
+		k.Set("prediction.surplus_margin", 0.2)
+	}
+	if k.Int("prediction.surplus_step") == 0 {
+		k.Set("prediction.surplus_step", 1)
+	}
+	if k.Float64("prediction.forecast_alpha") == 0 {
+		k.Set("prediction.forecast_alpha", 0.3)
+	}
+	if k.Duration("prediction.plugin.timeout") == 0 {
+		k.Set("prediction.plugin.timeout", time.Second)
+	}
+	if k.String("prediction.schedule.format") == "" {
+		k.Set("prediction.schedule.format", "ical")
+	}
+	if k.Duration("prediction.schedule.refresh_interval") == 0 {
+		k.Set("prediction.schedule.refresh_interval", 5*time.Minute)
+	}
+	if k.Duration("prediction.schedule.timeout") == 0 {
+		k.Set("prediction.schedule.timeout", 10*time.Second)
+	}
+	if k.Duration("prediction.schedule.horizon") == 0 {
+		k.Set("prediction.schedule.horizon", 7*24*time.Hour)
+	}
+	if !k.Exists("prediction.schedule.lead") {
+		k.Set("prediction.schedule.lead", 10*time.Minute)
+	}
+	if k.Float64("prediction.schedule.attendance") == 0 {
+		k.Set("prediction.schedule.attendance", 1.0)
+	}
+
+	// Metrics defaults
+	if k.Duration("metrics.history_retention") == 0 {
+		k.Set("metrics.history_retention", 24*time.Hour)
+	}
+	if k.Duration("metrics.slo_window") == 0 {
+		k.Set("metrics.slo_window", 1*time.Hour)
+	}
+	if k.Float64("metrics.slo_target") == 0 {
+		k.Set("metrics.slo_target", 0.99)
+	}
+	if k.Duration("metrics.accuracy_window") == 0 {
+		k.Set("metrics.accuracy_window", 1*time.Hour)
+	}
+	if k.Duration("metrics.journal_retention") == 0 {
+		k.Set("metrics.journal_retention", 24*time.Hour)
+	}
+	if k.Int("metrics.journal_max_events") == 0 {
+		k.Set("metrics.journal_max_events", 200)
+	}
+
+	// Readiness probe defaults
+	if k.String("hooks.probe.scheme") == "" {
+		k.Set("hooks.probe.scheme", "http")
+	}
+	if k.String("hooks.probe.path") == "" {
+		k.Set("hooks.probe.path", "/health")
+	}
+	if k.Int("hooks.probe.attempts") == 0 {
+		k.Set("hooks.probe.attempts", 5)
+	}
+	if k.Duration("hooks.probe.interval") == 0 {
+		k.Set("hooks.probe.interval", 5*time.Second)
+	}
+	if k.Duration("hooks.probe.timeout") == 0 {
+		k.Set("hooks.probe.timeout", 5*time.Second)
+	}
 }
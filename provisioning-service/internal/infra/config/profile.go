@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/knadh/koanf/v2"
+)
+
+// Deployment profiles
+const (
+	ProfileDev     = "dev"
+	ProfileStaging = "staging"
+	ProfileProd    = "prod"
+)
+
+// profiles holds the defaults of each profile. They are layered under the
+// config files and environment variables, so any of them can still be
+// overridden, and over the built-in defaults.
+var profiles = map[string]map[string]any{
+	// No external dependencies and verbose logs
+	ProfileDev: {
+		"node_api.provider": "fake",
+		"events.transport":  "memory",
+		"allocation.claims": "local",
+		"log.level":         "debug",
+		"log.format":        "console",
+		"log.sampling":      false,
+	},
+
+	// Scaling decisions are made and reported but not acted on
+	ProfileStaging: {
+		"prediction.dry_run": true,
+	},
+
+	// Every optional capacity and resilience feature on, with replicas
+	// coordinating through Redis
+	ProfileProd: {
+		"prediction.reservation_enabled":       true,
+		"prediction.forecast_enabled":          true,
+		"prediction.lead_time_enabled":         true,
+		"prediction.surplus_scale_down":        true,
+		"prediction.max_terminations_per_tick": 10,
+		"allocation.claims":                    "redis",
+		"allocation.user_store":                "redis",
+		"allocation.handoff":                   "redis",
+		"allocation.migrate_on_drain":          true,
+		"log.format":                           "json",
+		"log.sampling":                         true,
+	},
+}
+
+// Profiles returns the names of the known profiles
+func Profiles() []string {
+	return slices.Sorted(maps.Keys(profiles))
+}
+
+// loadProfile seeds k with the defaults of a profile; an empty profile
+// seeds nothing
+func loadProfile(k *koanf.Koanf, profile string) error {
+	if profile == "" {
+		return nil
+	}
+
+	defaults, ok := profiles[profile]
+	if !ok {
+		return fmt.Errorf("unknown profile %q, expected one of %s", profile, strings.Join(Profiles(), ", "))
+	}
+	for key, value := range defaults {
+		k.Set(key, value)
+	}
+	return nil
+}
@@ -0,0 +1,707 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/policy"
+	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+)
+
+// ValidationError lists every invalid setting found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration (%d problems):\n  - %s",
+		len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// maxTierWait is how long the provisioner tracks a user waiting for a node
+const maxTierWait = 10 * time.Minute
+
+// problems collects validation failures, keyed by their config path
+type problems []string
+
+func (p *problems) addf(key, format string, args ...any) {
+	*p = append(*p, key+": "+fmt.Sprintf(format, args...))
+}
+
+// oneOf requires value to be one of allowed
+func (p *problems) oneOf(key, value string, allowed ...string) {
+	if !slices.Contains(allowed, value) {
+		p.addf(key, "%q is not one of %s", value, strings.Join(allowed, ", "))
+	}
+}
+
+// positive requires a duration above zero
+func (p *problems) positive(key string, d time.Duration) {
+	if d <= 0 {
+		p.addf(key, "must be positive, got %s", d)
+	}
+}
+
+// nonNegative requires a duration of zero or more
+func (p *problems) nonNegative(key string, d time.Duration) {
+	if d < 0 {
+		p.addf(key, "must not be negative, got %s", d)
+	}
+}
+
+// atLeast requires an integer of at least min
+func (p *problems) atLeast(key string, n, min int) {
+	if n < min {
+		p.addf(key, "must be at least %d, got %d", min, n)
+	}
+}
+
+// ratio requires a value from 0 to 1
+func (p *problems) ratio(key string, r float64) {
+	if r < 0 || r > 1 {
+		p.addf(key, "must be between 0 and 1, got %g", r)
+	}
+}
+
+// url requires an absolute URL with one of the given schemes
+func (p *problems) url(key, raw string, schemes ...string) {
+	u, err := url.Parse(raw)
+	switch {
+	case err != nil:
+		p.addf(key, "invalid URL %q: %v", raw, err)
+	case !slices.Contains(schemes, u.Scheme):
+		p.addf(key, "URL %q must use %s", raw, strings.Join(schemes, " or "))
+	case u.Host == "":
+		p.addf(key, "URL %q has no host", raw)
+	}
+}
+
+// Validate checks settings that defaults cannot fix and the invariants
+// between them, returning a *ValidationError listing every violation
+func (c *Config) Validate() error {
+	var p problems
+
+	c.validateServer(&p)
+	c.validateNodeAPI(&p)
+	c.validatePrediction(&p)
+	c.validateAllocation(&p)
+	c.validatePurchasing(&p)
+	c.validateAccess(&p)
+	c.validateEvents(&p)
+	c.validateEncryption(&p)
+	c.validateHA(&p)
+	c.validateHooks(&p)
+	c.validateSessions(&p)
+	c.validateObservability(&p)
+
+	if len(p) > 0 {
+		return &ValidationError{Problems: p}
+	}
+	return nil
+}
+
+func (c *Config) validateServer(p *problems) {
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		p.addf("server.port", "must be between 1 and 65535, got %d", c.Server.Port)
+	}
+	j := c.Server.JWT
+	p.nonNegative("server.jwt.leeway", j.Leeway)
+	if (j.Issuer != "" || j.Audience != "") && !j.Enabled() {
+		p.addf("server.jwt", "issuer and audience require a secret or public_key_file")
+	}
+
+	p.oneOf("redis.mode", c.Redis.Mode, "standalone", "sentinel", "cluster")
+	switch c.Redis.Mode {
+	case "sentinel":
+		if c.Redis.MasterName == "" {
+			p.addf("redis.master_name", "is required in sentinel mode")
+		}
+		if len(c.Redis.Addrs) == 0 {
+			p.addf("redis.addrs", "sentinel mode needs at least one sentinel address")
+		}
+	case "cluster":
+		if len(c.Redis.Addrs) == 0 {
+			p.addf("redis.addrs", "cluster mode needs at least one seed address")
+		}
+	}
+
+	if t := c.Redis.TLS; t.Enabled && (t.CertFile == "") != (t.KeyFile == "") {
+		p.addf("redis.tls", "cert_file and key_file must be set together")
+	}
+	p.atLeast("redis.pool_size", c.Redis.PoolSize, 0)
+	p.atLeast("redis.min_idle_conns", c.Redis.MinIdleConns, 0)
+	if c.Redis.PoolSize > 0 && c.Redis.MinIdleConns > c.Redis.PoolSize {
+		p.addf("redis.min_idle_conns", "%d exceeds redis.pool_size (%d)", c.Redis.MinIdleConns, c.Redis.PoolSize)
+	}
+	p.nonNegative("redis.dial_timeout", c.Redis.DialTimeout)
+	p.nonNegative("redis.read_timeout", c.Redis.ReadTimeout)
+	p.nonNegative("redis.write_timeout", c.Redis.WriteTimeout)
+	p.nonNegative("redis.pool_timeout", c.Redis.PoolTimeout)
+	p.atLeast("redis.max_retries", c.Redis.MaxRetries, -1)
+	p.nonNegative("redis.min_retry_backoff", c.Redis.MinRetryBackoff)
+	p.nonNegative("redis.max_retry_backoff", c.Redis.MaxRetryBackoff)
+	if c.Redis.MaxRetryBackoff > 0 && c.Redis.MinRetryBackoff > c.Redis.MaxRetryBackoff {
+		p.addf("redis.min_retry_backoff", "%s exceeds redis.max_retry_backoff (%s)", c.Redis.MinRetryBackoff, c.Redis.MaxRetryBackoff)
+	}
+}
+
+func (c *Config) validateNodeAPI(p *problems) {
+	n := c.NodeAPI
+	p.oneOf("node_api.provider", n.Provider, "http", "fake")
+	if n.Provider == "http" {
+		p.url("node_api.base_url", n.BaseURL, "http", "https")
+	}
+	p.positive("node_api.timeout", n.Timeout)
+	p.positive("node_api.create_key_ttl", n.CreateKeyTTL)
+	p.nonNegative("node_api.fake_boot_delay", n.FakeBootDelay)
+	p.nonNegative("node_api.fake_boot_jitter", n.FakeBootJitter)
+	p.atLeast("node_api.fake_capacity", n.FakeCapacity, 0)
+
+	names := map[string]bool{n.Name: true}
+	for i, f := range n.Fallbacks {
+		key := fmt.Sprintf("node_api.fallbacks[%d]", i)
+		switch {
+		case f.Name == "":
+			p.addf(key+".name", "is required")
+		case names[f.Name]:
+			p.addf(key+".name", "%q is already used by another provider", f.Name)
+		}
+		names[f.Name] = true
+
+		if f.Provider != "" {
+			p.oneOf(key+".provider", f.Provider, "http", "fake")
+		}
+		if f.BaseURL != "" {
+			p.url(key+".base_url", f.BaseURL, "http", "https")
+		}
+		p.nonNegative(key+".timeout", f.Timeout)
+		p.atLeast(key+".fake_capacity", f.FakeCapacity, 0)
+	}
+
+	s := n.Spillover
+	for i, zone := range s.Zones {
+		key := fmt.Sprintf("node_api.spillover.zones[%d]", i)
+		switch {
+		case zone == "":
+			p.addf(key, "is empty")
+		case slices.Index(s.Zones, zone) != i:
+			p.addf(key, "%q is listed more than once", zone)
+		}
+	}
+	for _, instanceType := range slices.Sorted(maps.Keys(s.InstanceTypes)) {
+		key := "node_api.spillover.instance_types." + instanceType
+		if _, ok := c.Prediction.InstanceTypes[instanceType]; !ok {
+			p.addf(key, "%q is not one of prediction.instance_types", instanceType)
+		}
+		for i, alternative := range s.InstanceTypes[instanceType] {
+			switch _, ok := c.Prediction.InstanceTypes[alternative]; {
+			case !ok:
+				p.addf(fmt.Sprintf("%s[%d]", key, i), "%q is not one of prediction.instance_types", alternative)
+			case alternative == instanceType:
+				p.addf(fmt.Sprintf("%s[%d]", key, i), "%q cannot spill over to itself", alternative)
+			}
+		}
+	}
+	p.positive("node_api.spillover.zone_cooldown", s.ZoneCooldown)
+}
+
+func (c *Config) validatePrediction(p *problems) {
+	pr := c.Prediction
+	p.oneOf("prediction.scaling_mode", pr.ScalingMode, "demand", "target_utilization")
+
+	// The settings PUT /admin/prediction/config changes are held to the
+	// same rules there
+	tuning := predictor.Tuning{
+		ActivityHorizon:        pr.ActivityHorizon,
+		UserRetention:          pr.UserRetention,
+		ActivityWindow:         pr.ActivityWindow,
+		ActivityThreshold:      pr.ActivityThreshold,
+		PredictionWindow:       pr.PredictionWindow,
+		TargetHeadroom:         pr.TargetHeadroom,
+		MinReadyNodes:          pr.MinReadyNodes,
+		MaxReadyNodes:          pr.MaxReadyNodes,
+		BurstMaxNodes:          pr.BurstMaxNodes,
+		IdleTerminationTimeout: pr.IdleTerminationTimeout,
+		SurplusMargin:          pr.SurplusMargin,
+	}
+	for _, problem := range tuning.Problems() {
+		*p = append(*p, "prediction."+problem)
+	}
+
+	for _, activityType := range slices.Sorted(maps.Keys(pr.ActivityWeights)) {
+		if w := pr.ActivityWeights[activityType]; w < 0 {
+			p.addf("prediction.activity_weights."+activityType, "must not be negative, got %g", w)
+		}
+	}
+	if pr.ActivityHorizon < time.Minute {
+		p.addf("prediction.activity_horizon", "must be at least a minute, got %s", pr.ActivityHorizon)
+	}
+	if pr.ActivityBurstFactor <= 1 {
+		p.addf("prediction.activity_burst_factor", "must exceed 1, got %g", pr.ActivityBurstFactor)
+	}
+
+	p.atLeast("prediction.users_per_node", pr.UsersPerNode, 1)
+	p.positive("prediction.burst_idle_timeout", pr.BurstIdleTimeout)
+
+	p.positive("prediction.scaling_check_interval", pr.ScalingCheckInterval)
+	p.positive("prediction.booting_node_timeout", pr.BootingNodeTimeout)
+	if pr.BootingNodeTimeout > 0 && pr.BootingNodeTimeout <= pr.ScalingCheckInterval {
+		p.addf("prediction.booting_node_timeout", "%s must exceed prediction.scaling_check_interval (%s), or every booting node is stuck by its first check",
+			pr.BootingNodeTimeout, pr.ScalingCheckInterval)
+	}
+	p.nonNegative("prediction.scale_up_cooldown", pr.ScaleUpCooldown)
+	p.nonNegative("prediction.scale_down_cooldown", pr.ScaleDownCooldown)
+	p.atLeast("prediction.max_terminations_per_tick", pr.MaxTerminationsPerTick, 0)
+	p.nonNegative("prediction.max_node_age", pr.MaxNodeAge)
+	p.positive("prediction.terminated_node_retention", pr.TerminatedNodeRetention)
+	if pr.MaxNodeAge > 0 && pr.MaxNodeAge <= pr.BootingNodeTimeout {
+		p.addf("prediction.max_node_age", "%s must exceed prediction.booting_node_timeout (%s), or nodes are rotated as they boot",
+			pr.MaxNodeAge, pr.BootingNodeTimeout)
+	}
+
+	p.atLeast("prediction.boot_retry_budget", pr.BootRetryBudget, 1)
+	p.atLeast("prediction.boot_failure_threshold", pr.BootFailureThreshold, 1)
+	p.positive("prediction.boot_failure_backoff", pr.BootFailureBackoff)
+	if pr.BootFailureMaxBackoff < pr.BootFailureBackoff {
+		p.addf("prediction.boot_failure_max_backoff", "%s is below prediction.boot_failure_backoff (%s)", pr.BootFailureMaxBackoff, pr.BootFailureBackoff)
+	}
+
+	p.positive("prediction.forecast_bucket", pr.ForecastBucket)
+	if pr.ForecastBucket > 0 && (pr.ForecastSeason < pr.ForecastBucket || pr.ForecastSeason%pr.ForecastBucket != 0) {
+		p.addf("prediction.forecast_season", "%s must be a whole number of prediction.forecast_bucket (%s)", pr.ForecastSeason, pr.ForecastBucket)
+	}
+	if pr.ForecastAlpha <= 0 || pr.ForecastAlpha > 1 {
+		p.addf("prediction.forecast_alpha", "must be above 0 and at most 1, got %g", pr.ForecastAlpha)
+	}
+
+	p.atLeast("prediction.max_tracked_users", pr.MaxTrackedUsers, 1)
+	p.positive("prediction.user_cleanup_interval", pr.UserCleanupInterval)
+
+	p.atLeast("prediction.surplus_step", pr.SurplusStep, 1)
+
+	for i, rule := range pr.ScalingPolicy {
+		key := fmt.Sprintf("prediction.scaling_policy[%d]", i)
+		if _, err := policy.Compile([]policy.Rule{rule.Rule()}); err != nil {
+			// Compile names the rule by its index in the list given
+			p.addf(key, "%s", strings.TrimPrefix(err.Error(), "rule 0: "))
+		}
+		for _, instanceType := range rule.InstanceTypes {
+			if _, ok := pr.InstanceTypes[instanceType]; !ok {
+				p.addf(key+".instance_types", "%q is not one of prediction.instance_types", instanceType)
+			}
+		}
+	}
+	if len(pr.ScalingPolicy) > 0 && pr.ScalingMode != "demand" {
+		p.addf("prediction.scaling_policy", "only applies with prediction.scaling_mode: demand")
+	}
+	if _, err := time.LoadLocation(pr.ScalingPolicyTimezone); err != nil {
+		p.addf("prediction.scaling_policy_timezone", "unknown zone %q", pr.ScalingPolicyTimezone)
+	}
+
+	if pr.Plugin.Address != "" {
+		p.positive("prediction.plugin.timeout", pr.Plugin.Timeout)
+		if pr.Plugin.Timeout >= pr.ScalingCheckInterval {
+			p.addf("prediction.plugin.timeout", "%s must be below prediction.scaling_check_interval (%s), or checks would queue behind a slow plugin",
+				pr.Plugin.Timeout, pr.ScalingCheckInterval)
+		}
+	}
+
+	if s := pr.Schedule; s.Enabled {
+		p.oneOf("prediction.schedule.format", s.Format, "ical", "json")
+		if s.URL != "" {
+			p.url("prediction.schedule.url", s.URL, "http", "https")
+		}
+		p.positive("prediction.schedule.refresh_interval", s.RefreshInterval)
+		p.positive("prediction.schedule.timeout", s.Timeout)
+		p.positive("prediction.schedule.horizon", s.Horizon)
+		p.nonNegative("prediction.schedule.lead", s.Lead)
+		if s.Attendance <= 0 || s.Attendance > 1 {
+			p.addf("prediction.schedule.attendance", "must be above 0 and at most 1, got %g", s.Attendance)
+		}
+		p.atLeast("prediction.schedule.default_attendees", s.DefaultAttendees, 0)
+	}
+
+	for _, instanceType := range slices.Sorted(maps.Keys(pr.InstanceTypes)) {
+		t := pr.InstanceTypes[instanceType]
+		key := "prediction.instance_types." + instanceType
+		if t.BootingNodeTimeout > 0 && t.BootingNodeTimeout <= pr.ScalingCheckInterval {
+			p.addf(key+".booting_node_timeout", "%s must exceed prediction.scaling_check_interval (%s)", t.BootingNodeTimeout, pr.ScalingCheckInterval)
+		}
+		p.nonNegative(key+".idle_termination_timeout", t.IdleTerminationTimeout)
+		p.nonNegative(key+".burst_idle_timeout", t.BurstIdleTimeout)
+		if t.BurstMaxNodes != nil {
+			p.atLeast(key+".burst_max_nodes", *t.BurstMaxNodes, 0)
+		}
+		p.atLeast(key+".users_per_node", t.UsersPerNode, 0)
+	}
+	if len(pr.InstanceTypes) > 0 {
+		if _, ok := pr.InstanceTypes[pr.DefaultInstanceType]; !ok {
+			p.addf("prediction.default_instance_type", "%q is not one of prediction.instance_types", pr.DefaultInstanceType)
+		}
+	}
+}
+
+func (c *Config) validateAllocation(p *problems) {
+	a := c.Allocation
+	p.oneOf("allocation.claims", a.Claims, "local", "redis")
+	if a.Claims == "redis" && a.ClaimTTL <= 2*c.Prediction.ScalingCheckInterval {
+		p.addf("allocation.claim_ttl", "%s must exceed twice prediction.scaling_check_interval (%s), or claims lapse between the checks renewing them",
+			a.ClaimTTL, c.Prediction.ScalingCheckInterval)
+	}
+	p.oneOf("allocation.user_store", a.UserStore, "none", "redis")
+	if a.UserStore == "redis" && c.Events.Transport == "memory" {
+		p.addf("allocation.user_store", "redis requires events.transport redis or nats; memory runs without a Redis server")
+	}
+	p.oneOf("allocation.handoff", a.Handoff, "none", "file", "redis")
+	if a.Handoff == "redis" && c.Events.Transport == "memory" {
+		p.addf("allocation.handoff", "redis requires events.transport redis or nats; memory runs without a Redis server")
+	}
+	if a.Handoff != "none" {
+		p.positive("allocation.handoff_max_age", a.HandoffMaxAge)
+	}
+	if a.HandoffSecret != "" {
+		key, err := base64.StdEncoding.DecodeString(a.HandoffSecret)
+		switch {
+		case err != nil:
+			p.addf("allocation.handoff_secret", "is not valid base64")
+		case len(key) != 32:
+			p.addf("allocation.handoff_secret", "must be 32 bytes, got %d", len(key))
+		}
+	}
+	p.positive("allocation.migration_timeout", a.MigrationTimeout)
+	p.nonNegative("allocation.confirm_ttl", a.ConfirmTTL)
+
+	ir := a.IdleReclaim
+	p.nonNegative("allocation.idle_reclaim.timeout", ir.Timeout)
+	if ir.BusyThreshold <= 0 || ir.BusyThreshold > 100 {
+		p.addf("allocation.idle_reclaim.busy_threshold", "must be above 0 and at most 100, got %g", ir.BusyThreshold)
+	}
+	p.atLeast("allocation.idle_reclaim.warnings", ir.Warnings, 0)
+	p.positive("allocation.idle_reclaim.warning_interval", ir.WarningInterval)
+	p.positive("allocation.idle_reclaim.sample_max_age", ir.SampleMaxAge)
+
+	p.nonNegative("allocation.consistency.interval", a.Consistency.Interval)
+	p.oneOf("allocation.consistency.policy", a.Consistency.Policy, "alert", "repair")
+
+	rl := a.RateLimit
+	if rl.PerMinute < 0 {
+		p.addf("allocation.rate_limit.per_minute", "must not be negative, got %g", rl.PerMinute)
+	}
+	p.atLeast("allocation.rate_limit.burst", rl.Burst, 1)
+	p.oneOf("allocation.rate_limit.store", rl.Store, "local", "redis")
+
+	p.atLeast("allocation.session_limit.max_per_user", a.SessionLimit.MaxPerUser, 0)
+	for _, tier := range slices.Sorted(maps.Keys(a.SessionLimit.Tiers)) {
+		p.atLeast("allocation.session_limit.tiers."+tier, a.SessionLimit.Tiers[tier], 0)
+	}
+
+	rs := a.Rightsizing
+	for i, size := range rs.Sizes {
+		key := fmt.Sprintf("allocation.rightsizing.sizes[%d]", i)
+		switch {
+		case size == "":
+			p.addf(key, "is empty")
+		case slices.Index(rs.Sizes, size) != i:
+			p.addf(key, "%q is listed more than once", size)
+		default:
+			if _, ok := c.Prediction.InstanceTypes[size]; len(c.Prediction.InstanceTypes) > 0 && !ok {
+				p.addf(key, "%q is not one of prediction.instance_types", size)
+			}
+		}
+	}
+	if rs.AutoSelect && len(rs.Sizes) == 0 {
+		p.addf("allocation.rightsizing.auto_select", "requires allocation.rightsizing.sizes")
+	}
+	p.positive("allocation.rightsizing.window", rs.Window)
+	p.atLeast("allocation.rightsizing.min_samples", rs.MinSamples, 1)
+	if rs.DownsizeBelow < 0 || rs.DownsizeBelow >= rs.UpsizeAbove {
+		p.addf("allocation.rightsizing.downsize_below", "must be at least 0 and below upsize_above (%g), got %g", rs.UpsizeAbove, rs.DownsizeBelow)
+	}
+	if rs.UpsizeAbove <= 0 || rs.UpsizeAbove > 100 {
+		p.addf("allocation.rightsizing.upsize_above", "must be above 0 and at most 100, got %g", rs.UpsizeAbove)
+	}
+	if rs.MemoryUpsizeAbove < 0 || rs.MemoryUpsizeAbove > 100 {
+		p.addf("allocation.rightsizing.memory_upsize_above", "must be between 0 and 100, got %g", rs.MemoryUpsizeAbove)
+	}
+	for _, activityType := range slices.Sorted(maps.Keys(rs.ActivitySizes)) {
+		if size := rs.ActivitySizes[activityType]; !slices.Contains(rs.Sizes, size) {
+			p.addf("allocation.rightsizing.activity_sizes."+activityType, "%q is not one of allocation.rightsizing.sizes", size)
+		}
+	}
+
+	for i, userID := range a.DedicatedUsers {
+		if userID == "" {
+			p.addf(fmt.Sprintf("allocation.dedicated_users[%d]", i), "is empty")
+		}
+	}
+	for i, tenantID := range a.DedicatedTenants {
+		if tenantID == "" {
+			p.addf(fmt.Sprintf("allocation.dedicated_tenants[%d]", i), "is empty")
+		}
+	}
+
+	if _, ok := a.Tiers[a.DefaultTier]; a.DefaultTier != "" && !ok {
+		p.addf("allocation.default_tier", "%q is not one of allocation.tiers", a.DefaultTier)
+	}
+	for _, tier := range slices.Sorted(maps.Keys(a.Tiers)) {
+		t := a.Tiers[tier]
+		key := "allocation.tiers." + tier
+		p.positive(key+".max_wait", t.MaxWait)
+		if t.MaxWait >= maxTierWait {
+			p.addf(key+".max_wait", "%s must be below %s, after which waiting users are given up", t.MaxWait, maxTierWait)
+		}
+		for i, step := range t.Escalation {
+			p.oneOf(fmt.Sprintf("%s.escalation[%d]", key, i), step, "instance_type", "provider", "shared_node")
+			if step != "instance_type" {
+				continue
+			}
+			if t.InstanceType == "" {
+				p.addf(key+".instance_type", "is required by the instance_type escalation step")
+			} else if _, ok := c.Prediction.InstanceTypes[t.InstanceType]; len(c.Prediction.InstanceTypes) > 0 && !ok {
+				p.addf(key+".instance_type", "%q is not one of prediction.instance_types", t.InstanceType)
+			}
+		}
+	}
+
+	for _, tier := range slices.Sorted(maps.Keys(a.TierMembers)) {
+		members := a.TierMembers[tier]
+		key := "allocation.tier_members." + tier
+		for i, userID := range members.Users {
+			if userID == "" {
+				p.addf(fmt.Sprintf("%s.users[%d]", key, i), "is empty")
+			}
+		}
+		for i, tenantID := range members.Tenants {
+			if tenantID == "" {
+				p.addf(fmt.Sprintf("%s.tenants[%d]", key, i), "is empty")
+			}
+		}
+	}
+
+	if c.Budget.MaxHourlySpend < 0 {
+		p.addf("budget.max_hourly_spend", "must not be negative, got %g", c.Budget.MaxHourlySpend)
+	}
+	if c.Budget.MaxDailySpend < 0 {
+		p.addf("budget.max_daily_spend", "must not be negative, got %g", c.Budget.MaxDailySpend)
+	}
+	if c.Budget.HourlyPrice < 0 {
+		p.addf("budget.hourly_price", "must not be negative, got %g", c.Budget.HourlyPrice)
+	}
+}
+
+func (c *Config) validatePurchasing(p *problems) {
+	pu := c.Purchasing
+	p.ratio("purchasing.target_spot_ratio", pu.TargetSpotRatio)
+	for instanceType, count := range pu.Reserved {
+		p.atLeast("purchasing.reserved."+instanceType, count, 0)
+		if _, ok := c.Prediction.InstanceTypes[instanceType]; len(c.Prediction.InstanceTypes) > 0 && !ok {
+			p.addf("purchasing.reserved."+instanceType, "%q is not one of prediction.instance_types", instanceType)
+		}
+	}
+	if pu.MaxInterruptionRate < 0 {
+		p.addf("purchasing.max_interruption_rate", "must not be negative, got %g", pu.MaxInterruptionRate)
+	}
+	p.positive("purchasing.interruption_window", pu.InterruptionWindow)
+}
+
+func (c *Config) validateAccess(p *problems) {
+	p.oneOf("access.mode", c.Access.Mode, "open", "allowlist")
+	if c.Access.AuthzURL != "" {
+		p.url("access.authz_url", c.Access.AuthzURL, "http", "https")
+	}
+	p.positive("access.authz_timeout", c.Access.AuthzTimeout)
+}
+
+func (c *Config) validateEvents(p *problems) {
+	p.oneOf("events.transport", c.Events.Transport, "redis", "nats", "memory")
+	if c.Events.Transport == "nats" {
+		p.url("nats.url", c.NATS.URL, "nats", "tls")
+		if c.NATS.Stream == "" {
+			p.addf("nats.stream", "is required")
+		}
+		if c.NATS.Durable == "" {
+			p.addf("nats.durable", "is required")
+		}
+		p.atLeast("nats.max_deliver", c.NATS.MaxDeliver, 1)
+	}
+	if c.Events.WebhookSecret != "" {
+		p.positive("events.webhook_tolerance", c.Events.WebhookTolerance)
+	}
+	if ks := c.Events.Keyspace; ks.Enabled {
+		if strings.Count(ks.Pattern, "*") != 1 {
+			p.addf("events.keyspace.pattern", "must contain exactly one * for the node ID, got %q", ks.Pattern)
+		}
+		if c.Redis.Mode == "cluster" {
+			// Notifications are only delivered by the node holding the key
+			p.addf("events.keyspace.enabled", "is not supported with redis.mode cluster")
+		}
+	}
+	if c.Events.ChannelPrefix != "" && c.Events.Transport != "redis" {
+		p.addf("events.channel_prefix", "requires events.transport redis, got %q", c.Events.Transport)
+	}
+	if pattern := c.Events.TenantChannelPattern; pattern != "" {
+		if c.Events.Transport != "redis" {
+			p.addf("events.tenant_channel_pattern", "requires events.transport redis, got %q", c.Events.Transport)
+		}
+		tenant, channel := events.PlaceholderTenant, events.PlaceholderChannel
+		if strings.Count(pattern, tenant) != 1 || strings.Count(pattern, channel) != 1 {
+			p.addf("events.tenant_channel_pattern", "must contain %s and %s once each, got %q", tenant, channel, pattern)
+		} else if strings.Contains(pattern, tenant+channel) || strings.Contains(pattern, channel+tenant) {
+			p.addf("events.tenant_channel_pattern", "needs a separator between %s and %s, got %q", tenant, channel, pattern)
+		}
+	}
+	p.atLeast("events.poison_threshold", c.Events.PoisonThreshold, 0)
+	if c.Events.PoisonThreshold > 0 {
+		p.positive("events.poison_ttl", c.Events.PoisonTTL)
+	}
+}
+
+// validateEncryption checks the event sealing keys; keys from KMS are only
+// checked once decrypted at startup
+func (c *Config) validateEncryption(p *problems) {
+	e := c.Events.Encryption
+	p.oneOf("events.encryption.mode", e.Mode, "off", "sign", "encrypt")
+	p.oneOf("events.encryption.key_source", e.KeySource, "config", "kms")
+	if e.Mode == "off" {
+		return
+	}
+	if len(e.Keys) == 0 {
+		p.addf("events.encryption.keys", "is required with mode %s", e.Mode)
+	}
+	p.positive("events.encryption.max_skew", e.MaxSkew)
+	if _, ok := e.Keys[e.ActiveKey]; !ok {
+		p.addf("events.encryption.active_key", "%q is not one of events.encryption.keys", e.ActiveKey)
+	}
+	for _, id := range slices.Sorted(maps.Keys(e.Keys)) {
+		key, err := base64.StdEncoding.DecodeString(e.Keys[id])
+		switch {
+		case err != nil:
+			p.addf("events.encryption.keys."+id, "is not valid base64")
+		case e.KeySource == "config" && len(key) != 32:
+			p.addf("events.encryption.keys."+id, "must be 32 bytes, got %d", len(key))
+		}
+	}
+}
+
+func (c *Config) validateHA(p *problems) {
+	p.oneOf("ha.mode", c.HA.Mode, "none", "etcd")
+	if c.HA.Mode != "etcd" {
+		return
+	}
+	if len(c.HA.Endpoints) == 0 {
+		p.addf("ha.endpoints", "is required")
+	}
+	if c.HA.Prefix == "" {
+		p.addf("ha.prefix", "is required")
+	}
+	p.positive("ha.dial_timeout", c.HA.DialTimeout)
+	if c.HA.LeaseTTL < time.Second {
+		p.addf("ha.lease_ttl", "must be at least 1s, got %s", c.HA.LeaseTTL)
+	}
+	// Followers drop the events the leader acts on, so each must reach every replica
+	if c.Events.Transport != "redis" {
+		p.addf("ha.mode", "etcd requires events.transport redis, so every replica receives every event; got %q", c.Events.Transport)
+	}
+}
+
+func (c *Config) validateHooks(p *problems) {
+	stages := []struct {
+		key   string
+		hooks []HookConfig
+	}{
+		{"hooks.pre_ready", c.Hooks.PreReady},
+		{"hooks.post_allocate", c.Hooks.PostAllocate},
+		{"hooks.pre_terminate", c.Hooks.PreTerminate},
+	}
+	for _, stage := range stages {
+		for i, h := range stage.hooks {
+			key := fmt.Sprintf("%s[%d]", stage.key, i)
+			switch h.Type {
+			case "http":
+				p.url(key+".url", h.URL, "http", "https")
+			case "script":
+				if len(h.Command) == 0 {
+					p.addf(key+".command", "is required for script hooks")
+				}
+			default:
+				p.addf(key+".type", "%q is not one of http, script", h.Type)
+			}
+			p.nonNegative(key+".timeout", h.Timeout)
+		}
+	}
+
+	probe := c.Hooks.Probe
+	if probe.Enabled {
+		p.oneOf("hooks.probe.scheme", probe.Scheme, "http", "https")
+		if !strings.HasPrefix(probe.Path, "/") {
+			p.addf("hooks.probe.path", "%q must start with /", probe.Path)
+		}
+		if probe.Port < 0 || probe.Port > 65535 {
+			p.addf("hooks.probe.port", "must be between 0 and 65535, got %d", probe.Port)
+		}
+		p.atLeast("hooks.probe.attempts", probe.Attempts, 1)
+		p.nonNegative("hooks.probe.interval", probe.Interval)
+		p.positive("hooks.probe.timeout", probe.Timeout)
+	}
+}
+
+func (c *Config) validateSessions(p *problems) {
+	s := c.Sessions
+	p.oneOf("sessions.sink", s.Sink, "none", "redis", "kafka", "s3")
+	switch s.Sink {
+	case "kafka":
+		if len(s.KafkaBrokers) == 0 {
+			p.addf("sessions.kafka_brokers", "the kafka sink needs at least one broker")
+		}
+	case "s3":
+		if s.S3Bucket == "" {
+			p.addf("sessions.s3_bucket", "is required for the s3 sink")
+		}
+	}
+	p.positive("sessions.flush_interval", s.FlushInterval)
+	p.atLeast("sessions.batch_size", s.BatchSize, 1)
+	if s.MaxBuffered < s.BatchSize {
+		p.addf("sessions.max_buffered", "%d is below sessions.batch_size (%d)", s.MaxBuffered, s.BatchSize)
+	}
+}
+
+func (c *Config) validateObservability(p *problems) {
+	p.oneOf("log.level", c.Log.Level, "debug", "info", "warn", "error", "dpanic", "panic", "fatal")
+	p.oneOf("log.format", c.Log.Format, "json", "console")
+	if c.Log.LokiURL != "" {
+		p.url("log.loki_url", c.Log.LokiURL, "http", "https")
+	}
+
+	p.positive("metrics.history_retention", c.Metrics.HistoryRetention)
+	p.positive("metrics.slo_window", c.Metrics.SLOWindow)
+	if c.Metrics.SLOTarget <= 0 || c.Metrics.SLOTarget > 1 {
+		p.addf("metrics.slo_target", "must be above 0 and at most 1, got %g", c.Metrics.SLOTarget)
+	}
+	p.positive("metrics.accuracy_window", c.Metrics.AccuracyWindow)
+	p.positive("metrics.journal_retention", c.Metrics.JournalRetention)
+	p.atLeast("metrics.journal_max_events", c.Metrics.JournalMaxEvents, 1)
+
+	p.positive("health.timeout", c.Health.Timeout)
+	p.positive("health.node_api_cache_ttl", c.Health.NodeAPICacheTTL)
+	p.nonNegative("health.max_check_age", c.Health.MaxCheckAge)
+
+	p.positive("startup.hydrate_timeout", c.Startup.HydrateTimeout)
+	p.positive("startup.reconcile_timeout", c.Startup.ReconcileTimeout)
+	p.positive("startup.subscribe_timeout", c.Startup.SubscribeTimeout)
+	p.positive("startup.start_timeout", c.Startup.StartTimeout)
+	p.positive("startup.stop_timeout", c.Startup.StopTimeout)
+
+	if c.Chaos.Enabled {
+		p.ratio("chaos.node_api_delay_rate", c.Chaos.NodeAPIDelayRate)
+		p.ratio("chaos.node_api_failure_rate", c.Chaos.NodeAPIFailureRate)
+		p.ratio("chaos.event_drop_rate", c.Chaos.EventDropRate)
+		p.ratio("chaos.status_flip_rate", c.Chaos.StatusFlipRate)
+		p.nonNegative("chaos.node_api_max_delay", c.Chaos.NodeAPIMaxDelay)
+	}
+}
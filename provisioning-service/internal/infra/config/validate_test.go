@@ -0,0 +1,182 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadYAML loads a configuration from YAML on top of dev mode
+func loadYAML(t *testing.T, yaml string) (*Config, error) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("dev: true\n"+yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load("", "", path)
+}
+
+func TestValidateCompilesScalingPolicy(t *testing.T) {
+	_, err := loadYAML(t, `
+prediction:
+  scaling_policy_timezone: Mars/Olympus
+  scaling_policy:
+    - if: "hour in 22-06"
+      then: "max_ready = 1"
+    - if: "ready >"
+      then: "hold"
+    - if: "demand > available"
+      then: "scale_up nodes(demand, 2)"
+    - if: "queue > 0"
+      then: "hold"
+`)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("err = %v, want a validation error", err)
+	}
+	want := []string{
+		"prediction.scaling_policy[1]: if: unexpected end",
+		"prediction.scaling_policy[2]: then: nodes takes 1 argument(s), got 2",
+		`prediction.scaling_policy[3]: if: unknown variable "queue"`,
+		`prediction.scaling_policy_timezone: unknown zone "Mars/Olympus"`,
+	}
+	for _, problem := range want {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("problems do not include %q:\n%v", problem, err)
+		}
+	}
+	if strings.Contains(err.Error(), "scaling_policy[0]") {
+		t.Errorf("valid rule reported:\n%v", err)
+	}
+}
+
+func TestValidateDefaultsPolicyZoneToUTC(t *testing.T) {
+	cfg, err := loadYAML(t, "")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Prediction.ScalingPolicyTimezone != "UTC" {
+		t.Errorf("scaling_policy_timezone = %q, want UTC", cfg.Prediction.ScalingPolicyTimezone)
+	}
+}
+
+func TestValidateChecksTuningLikeRuntimeChanges(t *testing.T) {
+	_, err := loadYAML(t, `
+prediction:
+  max_ready_nodes: 4
+  burst_max_nodes: 3
+  activity_window: 2h
+  activity_horizon: 90m
+  user_retention: 1h
+`)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("err = %v, want a validation error", err)
+	}
+	want := []string{
+		"prediction.burst_max_nodes: 3 must exceed max_ready_nodes (4)",
+		"prediction.activity_window: 2h0m0s exceeds activity_horizon (1h30m0s)",
+		"prediction.activity_window: 2h0m0s exceeds user_retention (1h0m0s)",
+	}
+	for _, problem := range want {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("problems do not include %q:\n%v", problem, err)
+		}
+	}
+}
+
+func TestValidateClaimTTLOutlastsRenewals(t *testing.T) {
+	// Dev mode keeps claims local, so these load without it
+	load := func(yaml string) (*Config, error) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return Load("", "", path)
+	}
+
+	_, err := load(`
+allocation:
+  claims: redis
+  claim_ttl: 15s
+prediction:
+  scaling_check_interval: 10s
+`)
+	if err == nil || !strings.Contains(err.Error(), "allocation.claim_ttl: 15s must exceed twice prediction.scaling_check_interval (10s)") {
+		t.Errorf("err = %v, want claim_ttl rejected", err)
+	}
+
+	cfg, err := load("allocation:\n  claims: redis\n")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Allocation.ClaimTTL != 2*time.Minute {
+		t.Errorf("claim_ttl = %s, want 2m", cfg.Allocation.ClaimTTL)
+	}
+}
+
+func TestValidateUserStoreNeedsRedis(t *testing.T) {
+	// Dev mode turns the Redis user store off, so this loads without it
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("events:\n  transport: memory\nallocation:\n  user_store: redis\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Load("", "", path)
+	if err == nil || !strings.Contains(err.Error(), "allocation.user_store: redis requires events.transport redis or nats") {
+		t.Errorf("err = %v, want user_store rejected under the memory transport", err)
+	}
+}
+
+func TestDevModeKeepsStateLocal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("dev: true\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load("prod", "", path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Allocation.UserStore != "none" || cfg.Allocation.Handoff != "none" {
+		t.Errorf("user_store = %q, handoff = %q in dev mode, want none", cfg.Allocation.UserStore, cfg.Allocation.Handoff)
+	}
+
+	path = filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("events:\n  transport: memory\nallocation:\n  handoff: redis\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load("", "", path); err == nil || !strings.Contains(err.Error(), "allocation.handoff: redis requires events.transport redis or nats") {
+		t.Errorf("err = %v, want handoff rejected under the memory transport", err)
+	}
+}
+
+func TestRateLimitStoreFollowsTransport(t *testing.T) {
+	tests := map[string]string{
+		"":      "redis",
+		"redis": "redis",
+		"nats":  "local",
+	}
+	for transport, want := range tests {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte("events:\n  transport: \""+transport+"\"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := Load("", "", path)
+		if err != nil {
+			t.Fatalf("Load with transport %q: %v", transport, err)
+		}
+		if cfg.Allocation.RateLimit.Store != want {
+			t.Errorf("transport %q: rate_limit.store = %q, want %s", transport, cfg.Allocation.RateLimit.Store, want)
+		}
+	}
+
+	// Dev mode keeps everything in process
+	cfg, err := loadYAML(t, "")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Allocation.RateLimit.Store != "local" {
+		t.Errorf("dev mode rate_limit.store = %q, want local", cfg.Allocation.RateLimit.Store)
+	}
+}
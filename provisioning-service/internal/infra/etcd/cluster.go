@@ -0,0 +1,431 @@
+// Package etcd elects the leading replica and replicates its pool through
+// etcd.
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.uber.org/zap"
+)
+
+// ErrNotLeader is returned when saving state on a replica that does not lead
+var ErrNotLeader = errors.New("this replica is not the leader")
+
+// resignTimeout bounds giving up leadership on shutdown
+const resignTimeout = 5 * time.Second
+
+// maxTxnOps bounds the writes of one transaction, below etcd's default
+// limit of 128
+const maxTxnOps = 100
+
+// Options configures the etcd connection and the election
+type Options struct {
+	Endpoints   []string
+	Username    string
+	Password    string
+	DialTimeout time.Duration
+
+	// Prefix namespaces the election and the replicated state, so several
+	// pools can share an etcd cluster
+	Prefix string
+
+	// ID identifies this replica; it is the value of the leader key
+	ID string
+
+	// LeaseTTL is how long a leader that stopped responding keeps leading
+	LeaseTTL time.Duration
+}
+
+// Cluster campaigns for leadership among the replicas sharing a prefix and
+// stores the leader's state under it
+type Cluster struct {
+	client *clientv3.Client
+	opts   Options
+	logger *zap.Logger
+
+	onElected func(ctx context.Context) error // Run on election, before leading
+
+	mu       sync.RWMutex
+	election *concurrency.Election // Set while this replica leads
+
+	saveMu   sync.Mutex
+	savedRev int64             // Election revision the saved keys were read or written under
+	saved    map[string]string // Values of the replicated state keys, by key
+}
+
+// NewCluster connects to etcd; leadership is campaigned for by Run
+func NewCluster(opts Options, logger *zap.Logger) (*Cluster, error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   opts.Endpoints,
+		Username:    opts.Username,
+		Password:    opts.Password,
+		DialTimeout: opts.DialTimeout,
+		Logger:      logger.Named("etcd"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to etcd: %w", err)
+	}
+	return &Cluster{
+		client: client,
+		opts:   opts,
+		logger: logger,
+	}, nil
+}
+
+// OnElected sets a function run once this replica is elected, before it is
+// reported as the leader; if it fails, the replica resigns and campaigns
+// again. It must be set before Run.
+func (c *Cluster) OnElected(fn func(ctx context.Context) error) {
+	c.onElected = fn
+}
+
+// Run campaigns for leadership until ctx is done, campaigning again
+// whenever leadership is lost. A replica leading when ctx is done resigns,
+// so a standby takes over without waiting for the lease to expire.
+func (c *Cluster) Run(ctx context.Context) error {
+	for {
+		err := c.campaign(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		c.logger.Warn("leader election interrupted; campaigning again",
+			zap.String("replica", c.opts.ID),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.opts.LeaseTTL / 2):
+		}
+	}
+}
+
+// campaign waits to be elected, then leads until ctx is done or the lease
+// is lost
+func (c *Cluster) campaign(ctx context.Context) error {
+	session, err := concurrency.NewSession(c.client,
+		concurrency.WithTTL(int(c.opts.LeaseTTL.Seconds())),
+		concurrency.WithContext(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to open etcd session: %w", err)
+	}
+	defer session.Close()
+
+	election := concurrency.NewElection(session, c.electionKey())
+	if err := election.Campaign(ctx, c.opts.ID); err != nil {
+		return fmt.Errorf("campaign failed: %w", err)
+	}
+
+	if c.onElected != nil {
+		if err := c.onElected(ctx); err != nil {
+			c.resign(ctx, election)
+			return fmt.Errorf("failed to take over after election: %w", err)
+		}
+	}
+
+	c.setElection(election)
+	defer c.setElection(nil)
+	c.logger.Info("elected leader", zap.String("replica", c.opts.ID))
+
+	select {
+	case <-ctx.Done():
+		c.resign(ctx, election)
+		return ctx.Err()
+	case <-session.Done():
+		return errors.New("etcd lease expired; leadership lost")
+	}
+}
+
+// resign gives up leadership, so a standby takes over without waiting for
+// the lease to expire
+func (c *Cluster) resign(ctx context.Context, election *concurrency.Election) {
+	resignCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resignTimeout)
+	defer cancel()
+	if err := election.Resign(resignCtx); err != nil {
+		c.logger.Warn("failed to resign leadership; standby takes over once the lease expires", zap.Error(err))
+	} else {
+		c.logger.Info("resigned leadership", zap.String("replica", c.opts.ID))
+	}
+}
+
+func (c *Cluster) setElection(election *concurrency.Election) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.election = election
+}
+
+func (c *Cluster) leading() *concurrency.Election {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.election
+}
+
+// IsLeader reports whether this replica currently leads
+func (c *Cluster) IsLeader() bool {
+	return c.leading() != nil
+}
+
+// ID identifies this replica
+func (c *Cluster) ID() string {
+	return c.opts.ID
+}
+
+// Fence confirms that this replica still holds the leader key it was
+// elected with, failing once another replica has been elected even if this
+// one has not noticed yet
+func (c *Cluster) Fence(ctx context.Context) error {
+	election := c.leading()
+	if election == nil {
+		return ErrNotLeader
+	}
+	resp, err := c.client.Txn(ctx).If(leaderHeld(election)).Commit()
+	if err != nil {
+		return fmt.Errorf("failed to confirm leadership: %w", err)
+	}
+	if !resp.Succeeded {
+		return ErrNotLeader
+	}
+	return nil
+}
+
+// Save replicates the state, provided this replica still holds the leader
+// key it was elected with. Each node, user and migration has its own key,
+// and only the keys that changed since the last save are written, in
+// transactions of at most maxTxnOps; the metadata key is written last.
+func (c *Cluster) Save(ctx context.Context, snapshot handoff.Snapshot) error {
+	election := c.leading()
+	if election == nil {
+		return ErrNotLeader
+	}
+	next, err := encodeState(snapshot)
+	if err != nil {
+		return err
+	}
+
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	// The keys another leader wrote are read again after every election
+	if c.savedRev != election.Rev() {
+		saved, err := c.loadKeys(ctx)
+		if err != nil {
+			return err
+		}
+		c.saved = saved
+		c.savedRev = election.Rev()
+	}
+
+	for _, ops := range chunk(c.stateOps(c.saved, next), maxTxnOps) {
+		resp, err := c.client.Txn(ctx).If(leaderHeld(election)).Then(ops...).Commit()
+		if err == nil && !resp.Succeeded {
+			err = ErrNotLeader
+		}
+		if err != nil {
+			// Some transactions may have been applied; read the keys again
+			c.savedRev = 0
+			return err
+		}
+	}
+	c.saved = next
+	return nil
+}
+
+// Load returns the replicated state, reporting false if none was saved
+func (c *Cluster) Load(ctx context.Context) (handoff.Snapshot, bool, error) {
+	keys, err := c.loadKeys(ctx)
+	if err != nil {
+		return handoff.Snapshot{}, false, err
+	}
+	return decodeState(keys)
+}
+
+// loadKeys reads the replicated state keys, relative to the state prefix
+func (c *Cluster) loadKeys(ctx context.Context) (map[string]string, error) {
+	resp, err := c.client.Get(ctx, c.stateKey(), clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		keys[strings.TrimPrefix(string(kv.Key), c.stateKey())] = string(kv.Value)
+	}
+	return keys, nil
+}
+
+// stateOps returns the writes turning the saved state keys into next,
+// deletes first and the metadata key last
+func (c *Cluster) stateOps(saved, next map[string]string) []clientv3.Op {
+	var ops []clientv3.Op
+	for _, key := range sortedKeys(saved) {
+		if _, ok := next[key]; !ok {
+			ops = append(ops, clientv3.OpDelete(c.stateKey()+key))
+		}
+	}
+	for _, key := range sortedKeys(next) {
+		if key != metaKey && saved[key] != next[key] {
+			ops = append(ops, clientv3.OpPut(c.stateKey()+key, next[key]))
+		}
+	}
+	return append(ops, clientv3.OpPut(c.stateKey()+metaKey, next[metaKey]))
+}
+
+// Ping checks that etcd can be reached
+func (c *Cluster) Ping(ctx context.Context) error {
+	_, err := c.client.Get(ctx, c.electionKey(), clientv3.WithCountOnly())
+	return err
+}
+
+// Close closes the etcd connection
+func (c *Cluster) Close() error {
+	return c.client.Close()
+}
+
+func (c *Cluster) electionKey() string {
+	return c.opts.Prefix + "leader"
+}
+
+func (c *Cluster) stateKey() string {
+	return c.opts.Prefix + "state/"
+}
+
+// leaderHeld compares true while the election's leader key is the one this
+// replica created
+func leaderHeld(election *concurrency.Election) clientv3.Cmp {
+	return clientv3.Compare(clientv3.CreateRevision(election.Key()), "=", election.Rev())
+}
+
+// Keys of the replicated state, relative to the state prefix
+const (
+	metaKey          = "meta"
+	sessionsKey      = "sessions"
+	tuningKey        = "tuning"
+	nodesPrefix      = "nodes/"
+	usersPrefix      = "users/"
+	migrationsPrefix = "migrations/"
+)
+
+// stateMeta is the value of the metadata key
+type stateMeta struct {
+	Version   int
+	WrittenAt time.Time
+	Drain     *handoff.Drain `json:",omitempty"`
+}
+
+// encodeState splits a snapshot into the values of its keys
+func encodeState(snapshot handoff.Snapshot) (map[string]string, error) {
+	keys := make(map[string]string, 1+len(snapshot.Nodes)+len(snapshot.Users)+len(snapshot.Migrations))
+	put := func(key string, v any) error {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", key, err)
+		}
+		keys[key] = string(data)
+		return nil
+	}
+
+	if err := put(metaKey, stateMeta{Version: snapshot.Version, WrittenAt: snapshot.WrittenAt, Drain: snapshot.Drain}); err != nil {
+		return nil, err
+	}
+	if len(snapshot.Sessions) > 0 {
+		if err := put(sessionsKey, snapshot.Sessions); err != nil {
+			return nil, err
+		}
+	}
+	if snapshot.Tuning != nil {
+		if err := put(tuningKey, snapshot.Tuning); err != nil {
+			return nil, err
+		}
+	}
+	for _, n := range snapshot.Nodes {
+		if err := put(nodesPrefix+n.ID, n); err != nil {
+			return nil, err
+		}
+	}
+	for _, u := range snapshot.Users {
+		if err := put(usersPrefix+u.UserID, u); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range snapshot.Migrations {
+		if err := put(migrationsPrefix+m.UserID, m); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// decodeState assembles a snapshot from the values of its keys, reporting
+// false if no state was saved
+func decodeState(keys map[string]string) (handoff.Snapshot, bool, error) {
+	data, ok := keys[metaKey]
+	if !ok {
+		return handoff.Snapshot{}, false, nil
+	}
+	var meta stateMeta
+	if err := json.Unmarshal([]byte(data), &meta); err != nil {
+		return handoff.Snapshot{}, false, fmt.Errorf("invalid replicated state: %w", err)
+	}
+	if meta.Version != handoff.Version {
+		return handoff.Snapshot{}, false, fmt.Errorf("unsupported replicated state version %d", meta.Version)
+	}
+
+	snapshot := handoff.Snapshot{Version: meta.Version, WrittenAt: meta.WrittenAt, Drain: meta.Drain}
+	for _, key := range sortedKeys(keys) {
+		var err error
+		switch {
+		case key == sessionsKey:
+			err = json.Unmarshal([]byte(keys[key]), &snapshot.Sessions)
+		case key == tuningKey:
+			err = json.Unmarshal([]byte(keys[key]), &snapshot.Tuning)
+		case strings.HasPrefix(key, nodesPrefix):
+			snapshot.Nodes, err = appendDecoded(snapshot.Nodes, keys[key])
+		case strings.HasPrefix(key, usersPrefix):
+			snapshot.Users, err = appendDecoded(snapshot.Users, keys[key])
+		case strings.HasPrefix(key, migrationsPrefix):
+			snapshot.Migrations, err = appendDecoded(snapshot.Migrations, keys[key])
+		}
+		if err != nil {
+			return handoff.Snapshot{}, false, fmt.Errorf("invalid replicated state key %s: %w", key, err)
+		}
+	}
+	return snapshot, true, nil
+}
+
+func appendDecoded[T any](s []T, data string) ([]T, error) {
+	var v T
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		return s, err
+	}
+	return append(s, v), nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// chunk splits ops into runs of at most size
+func chunk(ops []clientv3.Op, size int) [][]clientv3.Op {
+	var chunks [][]clientv3.Op
+	for len(ops) > size {
+		chunks = append(chunks, ops[:size])
+		ops = ops[size:]
+	}
+	return append(chunks, ops)
+}
@@ -0,0 +1,285 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+	"github.com/aos-cc/provisioning-service/internal/domain/schedule"
+	"github.com/aos-cc/provisioning-service/internal/domain/user"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"go.uber.org/zap"
+)
+
+// startEtcd runs a single-member etcd for the test and returns its client URL
+func startEtcd(t *testing.T) string {
+	t.Helper()
+
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	client, _ := url.Parse("http://127.0.0.1:0")
+	peer, _ := url.Parse("http://127.0.0.1:0")
+	cfg.ListenClientUrls = []url.URL{*client}
+	cfg.AdvertiseClientUrls = []url.URL{*client}
+	cfg.ListenPeerUrls = []url.URL{*peer}
+	cfg.AdvertisePeerUrls = []url.URL{*peer}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("failed to start etcd: %v", err)
+	}
+	t.Cleanup(e.Close)
+
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd did not start")
+	}
+	return e.Clients[0].Addr().String()
+}
+
+func newTestCluster(t *testing.T, endpoint, id string) *Cluster {
+	t.Helper()
+
+	c, err := NewCluster(Options{
+		Endpoints:   []string{endpoint},
+		DialTimeout: 5 * time.Second,
+		Prefix:      "/test/",
+		ID:          id,
+		LeaseTTL:    5 * time.Second,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// run campaigns until the test ends, returning a function that stops
+// campaigning and waits for the replica to resign
+func run(t *testing.T, c *Cluster) func() {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(ctx)
+	}()
+	stop := func() {
+		cancel()
+		<-done
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func waitLeader(t *testing.T, c *Cluster) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !c.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s was not elected", c.ID())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testSnapshot(nodes ...string) handoff.Snapshot {
+	snapshot := handoff.Snapshot{
+		Version:   handoff.Version,
+		WrittenAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+	for _, id := range nodes {
+		snapshot.Nodes = append(snapshot.Nodes, node.Node{
+			ID:       id,
+			Status:   node.NodeStatusReady,
+			Users:    []string{"user-" + id},
+			Endpoint: node.Endpoint{Hostname: id + ".example", AuthToken: "secret-" + id},
+		})
+		snapshot.Users = append(snapshot.Users, user.UserState{
+			UserID:          "user-" + id,
+			IsConnected:     true,
+			AllocatedNodeID: id,
+		})
+	}
+	return snapshot
+}
+
+func TestEncodeDecodeState(t *testing.T) {
+	snapshot := testSnapshot("n1", "n2")
+	snapshot.Migrations = []handoff.Migration{{ID: "m1", UserID: "user-n1", FromNodeID: "n1", ToNodeID: "n2"}}
+	snapshot.Drain = &handoff.Drain{StartedAt: snapshot.WrittenAt, NodesAtStart: 2}
+	snapshot.Sessions = []schedule.Session{{ID: "s1", Start: snapshot.WrittenAt, End: snapshot.WrittenAt.Add(time.Hour), Attendees: 20}}
+	snapshot.Tuning = &predictor.Tuning{MinReadyNodes: 3, MaxReadyNodes: 6, ActivityWindow: time.Minute}
+
+	keys, err := encodeState(snapshot)
+	if err != nil {
+		t.Fatalf("encodeState: %v", err)
+	}
+	for _, key := range []string{"meta", "sessions", "tuning", "nodes/n1", "nodes/n2", "users/user-n1", "users/user-n2", "migrations/user-n1"} {
+		if _, ok := keys[key]; !ok {
+			t.Errorf("missing key %s", key)
+		}
+	}
+
+	decoded, ok, err := decodeState(keys)
+	if err != nil || !ok {
+		t.Fatalf("decodeState: ok=%v err=%v", ok, err)
+	}
+	if len(decoded.Nodes) != 2 || len(decoded.Users) != 2 || len(decoded.Migrations) != 1 {
+		t.Fatalf("decoded %d nodes, %d users, %d migrations", len(decoded.Nodes), len(decoded.Users), len(decoded.Migrations))
+	}
+	if decoded.Drain == nil || decoded.Drain.NodesAtStart != 2 {
+		t.Errorf("Drain = %v, want the drain state", decoded.Drain)
+	}
+	if len(decoded.Sessions) != 1 || decoded.Sessions[0].ID != "s1" {
+		t.Errorf("Sessions = %v, want the pushed session", decoded.Sessions)
+	}
+	if decoded.Tuning == nil || *decoded.Tuning != *snapshot.Tuning {
+		t.Errorf("Tuning = %+v, want the overrides", decoded.Tuning)
+	}
+	if !decoded.WrittenAt.Equal(snapshot.WrittenAt) {
+		t.Errorf("WrittenAt = %v, want %v", decoded.WrittenAt, snapshot.WrittenAt)
+	}
+
+	if _, ok, err := decodeState(map[string]string{"nodes/n1": keys["nodes/n1"]}); ok || err != nil {
+		t.Errorf("state without metadata: ok=%v err=%v", ok, err)
+	}
+	if _, _, err := decodeState(map[string]string{"meta": `{"Version":99}`}); err == nil {
+		t.Error("unsupported version decoded")
+	}
+}
+
+func TestStateOps(t *testing.T) {
+	c := &Cluster{opts: Options{Prefix: "/p/"}}
+	saved := map[string]string{"meta": "1", "nodes/a": "a", "nodes/b": "b"}
+	next := map[string]string{"meta": "2", "nodes/a": "a", "nodes/c": "c"}
+
+	ops := c.stateOps(saved, next)
+	var got []string
+	for _, op := range ops {
+		kind := "put"
+		if op.IsDelete() {
+			kind = "delete"
+		}
+		got = append(got, kind+" "+string(op.KeyBytes()))
+	}
+	want := []string{"delete /p/state/nodes/b", "put /p/state/nodes/c", "put /p/state/meta"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("ops = %v, want %v", got, want)
+	}
+
+	if chunks := chunk(make([]clientv3.Op, 250), maxTxnOps); len(chunks) != 3 || len(chunks[2]) != 50 {
+		t.Errorf("250 ops split into %d chunks", len(chunks))
+	}
+}
+
+func TestClusterSaveLoad(t *testing.T) {
+	endpoint := startEtcd(t)
+	ctx := context.Background()
+
+	leader := newTestCluster(t, endpoint, "a")
+	if err := leader.Save(ctx, testSnapshot("n1")); !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("Save before election = %v, want ErrNotLeader", err)
+	}
+	run(t, leader)
+	waitLeader(t, leader)
+
+	// More nodes than one transaction writes
+	ids := make([]string, 150)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("n%03d", i)
+	}
+	if err := leader.Save(ctx, testSnapshot(ids...).WithoutSecrets()); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	follower := newTestCluster(t, endpoint, "b")
+	loaded, ok, err := follower.Load(ctx)
+	if err != nil || !ok {
+		t.Fatalf("Load: ok=%v err=%v", ok, err)
+	}
+	if len(loaded.Nodes) != 150 || len(loaded.Users) != 150 {
+		t.Fatalf("loaded %d nodes and %d users, want 150", len(loaded.Nodes), len(loaded.Users))
+	}
+	for _, n := range loaded.Nodes {
+		if n.Endpoint.AuthToken != "" {
+			t.Fatalf("node %s replicated with its auth token", n.ID)
+		}
+	}
+
+	// Nodes gone from the pool are deleted
+	if err := leader.Save(ctx, testSnapshot("n001")); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, _, err = follower.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(loaded.Nodes) != 1 || loaded.Nodes[0].ID != "n001" {
+		t.Fatalf("loaded nodes %v after the pool shrank", loaded.Nodes)
+	}
+}
+
+func TestClusterFence(t *testing.T) {
+	endpoint := startEtcd(t)
+	ctx := context.Background()
+
+	a := newTestCluster(t, endpoint, "a")
+	if err := a.Fence(ctx); !errors.Is(err, ErrNotLeader) {
+		t.Fatalf("Fence before election = %v, want ErrNotLeader", err)
+	}
+	run(t, a)
+	waitLeader(t, a)
+	if err := a.Fence(ctx); err != nil {
+		t.Fatalf("Fence while leading: %v", err)
+	}
+	election := a.leading()
+
+	// Another replica is elected once the leader key is gone, before a
+	// notices it lost the lease
+	if _, err := a.client.Delete(ctx, election.Key()); err != nil {
+		t.Fatalf("delete leader key: %v", err)
+	}
+	b := newTestCluster(t, endpoint, "b")
+	run(t, b)
+	waitLeader(t, b)
+
+	if !a.IsLeader() {
+		t.Fatal("a noticed losing leadership; the test needs it not to")
+	}
+	if err := a.Fence(ctx); !errors.Is(err, ErrNotLeader) {
+		t.Errorf("Fence of a deposed leader = %v, want ErrNotLeader", err)
+	}
+	if err := a.Save(ctx, testSnapshot("stale")); !errors.Is(err, ErrNotLeader) {
+		t.Errorf("Save of a deposed leader = %v, want ErrNotLeader", err)
+	}
+}
+
+func TestClusterOnElected(t *testing.T) {
+	endpoint := startEtcd(t)
+
+	c := newTestCluster(t, endpoint, "a")
+	leadingDuringHook := make(chan bool, 1)
+	c.OnElected(func(ctx context.Context) error {
+		leadingDuringHook <- c.IsLeader()
+		return nil
+	})
+	run(t, c)
+	waitLeader(t, c)
+
+	if <-leadingDuringHook {
+		t.Error("replica reported leading before OnElected returned")
+	}
+}
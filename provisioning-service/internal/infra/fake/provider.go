@@ -0,0 +1,217 @@
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// ErrUnknownNode is returned when asked about or terminating a node the
+// provider did not create
+var ErrUnknownNode = node.ErrNotFound
+
+// Publisher publishes messages to a pub/sub channel
+type Publisher interface {
+	Publish(ctx context.Context, channel, message string) error
+}
+
+// Options configures the fake provider
+type Options struct {
+	BootDelay  time.Duration // How long a node takes to become ready
+	BootJitter time.Duration // Up to this much is added to each boot at random
+	Capacity   int           // Nodes that may exist at once; 0 for no limit
+
+	// ExhaustedZones have no capacity at all, to exercise zone spillover
+	ExhaustedZones []string
+}
+
+// Provider is an in-process NodeProvider for local development and demos.
+// Nodes report ready on the event bus after a simulated boot delay, the way
+// nodes managed by the Node API do.
+type Provider struct {
+	publisher Publisher
+	opts      Options
+	logger    *zap.Logger
+
+	mu     sync.Mutex
+	nodes  map[string]*time.Timer // Pending ready transitions by node; nil once ready
+	ports  int
+	closed bool
+}
+
+// NewProvider creates a fake provider that publishes node status on publisher
+func NewProvider(publisher Publisher, opts Options, logger *zap.Logger) *Provider {
+	return &Provider{
+		publisher: publisher,
+		opts:      opts,
+		logger:    logger,
+		nodes:     make(map[string]*time.Timer),
+	}
+}
+
+// ProvisionNode starts booting a simulated node that reports the given labels
+func (p *Provider) ProvisionNode(ctx context.Context, instanceType, zone, purchase string, labels map[string]string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return "", errors.New("fake provider is stopped")
+	}
+	if zone != "" && slices.Contains(p.opts.ExhaustedZones, zone) {
+		return "", &node.CapacityError{InstanceType: instanceType, Zone: zone, Message: "fake zone is exhausted"}
+	}
+	if p.opts.Capacity > 0 && len(p.nodes) >= p.opts.Capacity {
+		return "", &node.CapacityError{
+			InstanceType: instanceType,
+			Zone:         zone,
+			Message:      fmt.Sprintf("fake provider is at its capacity of %d", p.opts.Capacity),
+		}
+	}
+
+	nodeID := "fake-" + uuid.NewString()[:8]
+	p.ports++
+	port := 9000 + p.ports
+
+	delay := p.opts.BootDelay
+	if p.opts.BootJitter > 0 {
+		delay += rand.N(p.opts.BootJitter)
+	}
+
+	p.nodes[nodeID] = time.AfterFunc(delay, func() {
+		p.markReady(nodeID, instanceType, labels, port)
+	})
+
+	p.logger.Info("fake node booting",
+		zap.String("node_id", nodeID),
+		zap.String("instance_type", instanceType),
+		zap.String("zone", zone),
+		zap.String("purchase_option", purchase),
+		zap.Duration("boot_delay", delay),
+	)
+
+	return nodeID, nil
+}
+
+// ProvisionNodes starts booting count simulated nodes
+func (p *Provider) ProvisionNodes(ctx context.Context, instanceType, zone, purchase string, labels map[string]string, count int) ([]string, error) {
+	nodeIDs := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		nodeID, err := p.ProvisionNode(ctx, instanceType, zone, purchase, labels)
+		if err != nil {
+			return nodeIDs, err
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	return nodeIDs, nil
+}
+
+// TerminateNode stops a simulated node, cancelling its boot if it is still booting
+func (p *Provider) TerminateNode(ctx context.Context, nodeID string) error {
+	p.mu.Lock()
+	timer, ok := p.nodes[nodeID]
+	if ok {
+		delete(p.nodes, nodeID)
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownNode, nodeID)
+	}
+
+	p.logger.Info("fake node terminated", zap.String("node_id", nodeID))
+	p.publish(ctx, events.NodeStatusEvent{
+		NodeID: nodeID,
+		Status: "terminated",
+	})
+	return nil
+}
+
+// GetNodeDiagnostics reports whether a simulated node is still booting
+func (p *Provider) GetNodeDiagnostics(ctx context.Context, nodeID string) (node.Diagnostics, error) {
+	p.mu.Lock()
+	timer, ok := p.nodes[nodeID]
+	p.mu.Unlock()
+
+	if !ok {
+		return node.Diagnostics{}, fmt.Errorf("%w: %s", ErrUnknownNode, nodeID)
+	}
+	if timer != nil {
+		return node.Diagnostics{Status: "booting", StatusMessage: "simulated boot in progress"}, nil
+	}
+	return node.Diagnostics{Status: "running", StatusMessage: "simulated node ready"}, nil
+}
+
+// NodeExists reports whether a simulated node is booting or running
+func (p *Provider) NodeExists(ctx context.Context, nodeID string) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, ok := p.nodes[nodeID]
+	return ok, nil
+}
+
+// Stop cancels pending boots
+func (p *Provider) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, timer := range p.nodes {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (p *Provider) markReady(nodeID, instanceType string, labels map[string]string, port int) {
+	p.mu.Lock()
+	if _, ok := p.nodes[nodeID]; !ok || p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.nodes[nodeID] = nil
+	p.mu.Unlock()
+
+	p.logger.Info("fake node ready", zap.String("node_id", nodeID))
+	p.publish(context.Background(), events.NodeStatusEvent{
+		NodeID:       nodeID,
+		Status:       "ready",
+		InstanceType: instanceType,
+		Address:      "127.0.0.1",
+		Hostname:     nodeID + ".fake.local",
+		Port:         port,
+		AuthToken:    uuid.NewString(),
+		Labels:       labels,
+	})
+}
+
+func (p *Provider) publish(ctx context.Context, event events.NodeStatusEvent) {
+	event.SchemaVersion = events.CurrentSchemaVersion
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		p.logger.Error("failed to marshal fake node status", zap.Error(err))
+		return
+	}
+
+	if err := p.publisher.Publish(ctx, events.ChannelNodeStatus, string(data)); err != nil {
+		p.logger.Error("failed to publish fake node status",
+			zap.String("node_id", event.NodeID),
+			zap.String("status", event.Status),
+			zap.Error(err),
+		)
+	}
+}
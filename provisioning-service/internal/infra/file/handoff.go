@@ -0,0 +1,73 @@
+// Package file keeps service state on the local filesystem
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+)
+
+// HandoffStore keeps the handoff in a file, e.g. on a volume the replacing
+// pod mounts. The file is readable only by the service's user.
+type HandoffStore struct {
+	path string
+}
+
+// NewHandoffStore creates a handoff store at the given path
+func NewHandoffStore(path string) *HandoffStore {
+	return &HandoffStore{path: path}
+}
+
+// Save writes the snapshot to a temporary file and renames it into place,
+// so a crash mid-write leaves the previous handoff intact
+func (s *HandoffStore) Save(ctx context.Context, snapshot handoff.Snapshot) error {
+	data, err := handoff.Encode(snapshot)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create handoff: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write handoff: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write handoff: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write handoff: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Take reads the snapshot and removes the file, reporting false if it does
+// not exist
+func (s *HandoffStore) Take(ctx context.Context) (handoff.Snapshot, bool, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return handoff.Snapshot{}, false, nil
+	}
+	if err != nil {
+		return handoff.Snapshot{}, false, err
+	}
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return handoff.Snapshot{}, false, fmt.Errorf("failed to remove handoff: %w", err)
+	}
+
+	snapshot, err := handoff.Decode(data)
+	if err != nil {
+		return handoff.Snapshot{}, false, err
+	}
+	return snapshot, true, nil
+}
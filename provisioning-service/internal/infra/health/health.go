@@ -0,0 +1,96 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Check is a named dependency check
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+
+	// CacheTTL reuses the last result for this long, for checks too costly
+	// to run on every probe; zero runs the check every time
+	CacheTTL time.Duration
+}
+
+// Result is the outcome of a single check
+type Result struct {
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Checker runs dependency checks concurrently, each bounded by a timeout
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+
+	mu     sync.Mutex
+	cached map[string]Result
+}
+
+// NewChecker creates a checker for the given checks
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{
+		checks:  checks,
+		timeout: timeout,
+		cached:  make(map[string]Result),
+	}
+}
+
+// Run executes all checks and reports whether every one passed
+func (c *Checker) Run(ctx context.Context) (bool, map[string]Result) {
+	results := make(map[string]Result, len(c.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := c.run(ctx, check)
+
+			mu.Lock()
+			results[check.Name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	healthy := true
+	for _, result := range results {
+		healthy = healthy && result.Healthy
+	}
+	return healthy, results
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	now := time.Now()
+	if check.CacheTTL > 0 {
+		c.mu.Lock()
+		cached, ok := c.cached[check.Name]
+		c.mu.Unlock()
+		if ok && now.Sub(cached.CheckedAt) < check.CacheTTL {
+			return cached
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	result := Result{Healthy: true, CheckedAt: now}
+	if err := check.Run(ctx); err != nil {
+		result.Healthy = false
+		result.Error = err.Error()
+	}
+
+	if check.CacheTTL > 0 {
+		c.mu.Lock()
+		c.cached[check.Name] = result
+		c.mu.Unlock()
+	}
+	return result
+}
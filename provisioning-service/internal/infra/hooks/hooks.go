@@ -0,0 +1,223 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/lifecycle"
+	"resty.dev/v3"
+)
+
+// Spec describes a configured hook
+type Spec struct {
+	Name    string
+	Type    string   // http|script
+	URL     string   // http hooks
+	Command []string // script hooks; argv, not passed through a shell
+	Timeout time.Duration
+}
+
+// Build creates hooks from their specs
+func Build(specs []Spec) ([]lifecycle.Hook, error) {
+	hooks := make([]lifecycle.Hook, 0, len(specs))
+	for i, spec := range specs {
+		if spec.Name == "" {
+			spec.Name = fmt.Sprintf("%s-%d", spec.Type, i)
+		}
+		if spec.Timeout <= 0 {
+			spec.Timeout = 30 * time.Second
+		}
+
+		switch spec.Type {
+		case "http":
+			if spec.URL == "" {
+				return nil, fmt.Errorf("hook %q: url is required", spec.Name)
+			}
+			hooks = append(hooks, NewHTTPHook(spec.Name, spec.URL, spec.Timeout))
+		case "script":
+			if len(spec.Command) == 0 {
+				return nil, fmt.Errorf("hook %q: command is required", spec.Name)
+			}
+			hooks = append(hooks, NewScriptHook(spec.Name, spec.Command, spec.Timeout))
+		default:
+			return nil, fmt.Errorf("hook %q: unknown type %q", spec.Name, spec.Type)
+		}
+	}
+	return hooks, nil
+}
+
+// hookRequest is the JSON body posted to HTTP hooks
+type hookRequest struct {
+	Stage lifecycle.Stage `json:"stage"`
+	lifecycle.Target
+}
+
+// HTTPHook posts the stage and node to a URL; any non-2xx response fails the hook
+type HTTPHook struct {
+	name  string
+	url   string
+	resty *resty.Client
+}
+
+// NewHTTPHook creates an HTTP hook
+func NewHTTPHook(name, url string, timeout time.Duration) *HTTPHook {
+	return &HTTPHook{
+		name: name,
+		url:  url,
+		resty: resty.New().
+			SetTimeout(timeout).
+			SetHeader("Content-Type", "application/json"),
+	}
+}
+
+// Name returns the hook name
+func (h *HTTPHook) Name() string {
+	return h.name
+}
+
+// Run calls the hook URL
+func (h *HTTPHook) Run(ctx context.Context, stage lifecycle.Stage, target lifecycle.Target) error {
+	resp, err := h.resty.R().
+		SetContext(ctx).
+		SetBody(hookRequest{Stage: stage, Target: target}).
+		Post(h.url)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.IsError() {
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode(), strings.TrimSpace(resp.String()))
+	}
+	return nil
+}
+
+// ScriptHook runs a local command with the node described in HOOK_* environment
+// variables; a non-zero exit fails the hook
+type ScriptHook struct {
+	name    string
+	command []string
+	timeout time.Duration
+}
+
+// NewScriptHook creates a script hook
+func NewScriptHook(name string, command []string, timeout time.Duration) *ScriptHook {
+	return &ScriptHook{
+		name:    name,
+		command: command,
+		timeout: timeout,
+	}
+}
+
+// Name returns the hook name
+func (h *ScriptHook) Name() string {
+	return h.name
+}
+
+// Run executes the command
+func (h *ScriptHook) Run(ctx context.Context, stage lifecycle.Stage, target lifecycle.Target) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, h.command[0], h.command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"HOOK_STAGE="+string(stage),
+		"HOOK_NODE_ID="+target.NodeID,
+		"HOOK_USER_ID="+target.UserID,
+		"HOOK_NODE_ADDRESS="+target.Address,
+		"HOOK_NODE_HOSTNAME="+target.Hostname,
+		"HOOK_NODE_PORT="+strconv.Itoa(target.Port),
+	)
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// ProbeSpec configures the readiness probe of booted nodes
+type ProbeSpec struct {
+	Scheme   string // http|https
+	Path     string // e.g. /health
+	Port     int    // 0 uses the port the node reported
+	Attempts int
+	Interval time.Duration // Wait between attempts
+	Timeout  time.Duration // Timeout of each attempt
+}
+
+// ProbeHook checks that a node's agent is actually serving by calling its
+// health endpoint at the address the node reported, retrying until it
+// answers with a 2xx or the attempts run out
+type ProbeHook struct {
+	spec  ProbeSpec
+	resty *resty.Client
+}
+
+// NewProbeHook creates a probe hook
+func NewProbeHook(spec ProbeSpec) *ProbeHook {
+	if spec.Scheme == "" {
+		spec.Scheme = "http"
+	}
+	if spec.Attempts < 1 {
+		spec.Attempts = 1
+	}
+	return &ProbeHook{
+		spec:  spec,
+		resty: resty.New().SetTimeout(spec.Timeout),
+	}
+}
+
+// Name returns the hook name
+func (h *ProbeHook) Name() string {
+	return "probe"
+}
+
+// Run probes the node
+func (h *ProbeHook) Run(ctx context.Context, stage lifecycle.Stage, target lifecycle.Target) error {
+	host := target.Address
+	if host == "" {
+		host = target.Hostname
+	}
+	if host == "" {
+		return fmt.Errorf("node reported no address to probe")
+	}
+	port := h.spec.Port
+	if port == 0 {
+		port = target.Port
+	}
+	if port != 0 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	url := h.spec.Scheme + "://" + host + h.spec.Path
+
+	var lastErr error
+	for attempt := 1; attempt <= h.spec.Attempts; attempt++ {
+		resp, err := h.resty.R().SetContext(ctx).Get(url)
+		switch {
+		case err != nil:
+			lastErr = err
+		case resp.IsError():
+			lastErr = fmt.Errorf("unexpected status code %d", resp.StatusCode())
+		default:
+			return nil
+		}
+
+		if attempt < h.spec.Attempts {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(h.spec.Interval):
+			}
+		}
+	}
+	return fmt.Errorf("%s not healthy after %d attempts: %w", url, h.spec.Attempts, lastErr)
+}
@@ -0,0 +1,73 @@
+package http
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/requestid"
+	"github.com/gofiber/fiber/v3"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// probePaths are polled by load balancers and scrapers; their access logs
+// are only written at debug level
+var probePaths = map[string]bool{
+	"/health":             true,
+	"/readyz":             true,
+	"/metrics/prometheus": true,
+}
+
+// requestID takes the caller's X-Request-ID, or assigns one if it is missing
+// or malformed, echoes it on the response and puts it on the request
+// context, so node API calls made on the request's behalf carry it too
+func (s *Server) requestID(c fiber.Ctx) error {
+	id := c.Get(requestid.Header)
+	if !requestid.Valid(id) {
+		id = requestid.New()
+	}
+	c.Set(requestid.Header, id)
+	c.SetContext(requestid.With(c.Context(), id))
+	return c.Next()
+}
+
+// accessLog logs every request with its outcome and latency
+func (s *Server) accessLog(c fiber.Ctx) error {
+	start := time.Now()
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		status = fiber.StatusInternalServerError
+		var fe *fiber.Error
+		if errors.As(err, &fe) {
+			status = fe.Code
+		}
+	}
+
+	level := zapcore.InfoLevel
+	switch {
+	case status >= fiber.StatusInternalServerError:
+		level = zapcore.ErrorLevel
+	case probePaths[c.Path()] && status < fiber.StatusBadRequest:
+		level = zapcore.DebugLevel
+	}
+	if ce := s.logger.Check(level, "http request"); ce != nil {
+		fields := []zap.Field{
+			zap.String("request_id", requestid.From(c.Context())),
+			zap.String("method", c.Method()),
+			zap.String("path", c.Path()),
+			zap.Int("status", status),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("remote_ip", c.IP()),
+		}
+		if subject := principalOf(c).Subject; subject != "" {
+			fields = append(fields, zap.String("subject", subject))
+		}
+		if err != nil {
+			fields = append(fields, zap.Error(err))
+		}
+		ce.Write(fields...)
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package http
+
+import (
+	"crypto/subtle"
+	"strings"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/rbac"
+	"github.com/gofiber/fiber/v3"
+	"go.uber.org/zap"
+)
+
+// principalKey holds the authenticated caller in the request locals
+type principalKey struct{}
+
+// unrestricted is the caller when no authentication is configured, and the
+// one the admin token authenticates
+var unrestricted = rbac.Principal{Subject: "admin-token", Role: rbac.RoleAdmin}
+
+// TokenVerifier verifies bearer tokens and returns the caller they name
+type TokenVerifier interface {
+	Verify(token string, now time.Time) (rbac.Principal, error)
+}
+
+// EnableJWT authenticates admin API callers with signed tokens carrying
+// their role and, for a tenant's operators, their tenant. The admin token,
+// if configured, keeps full access.
+func (s *Server) EnableJWT(verifier TokenVerifier) {
+	s.verifier = verifier
+}
+
+// authRequired reports whether admin routes need credentials
+func (s *Server) authRequired() bool {
+	return s.adminToken != "" || s.verifier != nil
+}
+
+// adminAuth authenticates the caller by the admin token or a signed token
+// and records them for the role and tenant checks that follow
+func (s *Server) adminAuth(c fiber.Ctx) error {
+	principal, err := s.authenticate(c)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	fiber.Locals(c, principalKey{}, principal)
+	return c.Next()
+}
+
+func (s *Server) authenticate(c fiber.Ctx) (rbac.Principal, error) {
+	if !s.authRequired() {
+		return unrestricted, nil
+	}
+
+	token := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if s.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1 {
+		return unrestricted, nil
+	}
+	if s.verifier != nil && token != "" {
+		principal, err := s.verifier.Verify(token, time.Now())
+		if err == nil {
+			return principal, nil
+		}
+		s.logger.Debug("rejected admin token", zap.String("path", c.Path()), zap.Error(err))
+	}
+	return rbac.Principal{}, errcode.New(errcode.Unauthorized, "unauthorized")
+}
+
+// principalOf returns the caller adminAuth authenticated
+func principalOf(c fiber.Ctx) rbac.Principal {
+	return fiber.Locals[rbac.Principal](c, principalKey{})
+}
+
+// requireRole admits callers whose role includes the given one; a tenant's
+// callers are admitted too, and limited to their tenant by the handler
+func (s *Server) requireRole(role rbac.Role) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if !principalOf(c).Allows(role) {
+			return errorResponse(c, rbac.ErrForbidden)
+		}
+		return c.Next()
+	}
+}
+
+// requirePool admits callers whose role includes the given one and who are
+// not limited to a tenant, for actions on the whole pool
+func (s *Server) requirePool(role rbac.Role) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		principal := principalOf(c)
+		if !principal.Allows(role) {
+			return errorResponse(c, rbac.ErrForbidden)
+		}
+		if principal.Scoped() {
+			return errorResponse(c, rbac.ErrOutsideTenant)
+		}
+		return c.Next()
+	}
+}
+
+// userInTenant limits a tenant's callers to their own users
+func (s *Server) userInTenant(c fiber.Ctx) error {
+	if !principalOf(c).CanAccessTenant(s.userTracker.TenantOf(c.Params("id"))) {
+		return errorResponse(c, rbac.ErrOutsideTenant)
+	}
+	return c.Next()
+}
+
+// nodeInTenant limits a tenant's callers to their own nodes; unknown nodes
+// are left to the handler to report
+func (s *Server) nodeInTenant(c fiber.Ctx) error {
+	n, ok := s.nodePool.Get(c.Params("id"))
+	if ok && !s.canAccessNode(principalOf(c), n) {
+		return errorResponse(c, rbac.ErrOutsideTenant)
+	}
+	return c.Next()
+}
+
+// canAccessNode reports whether a principal may see and manage a node. A
+// tenant's node is one held for the tenant or one of its users, or one
+// hosting only the tenant's users.
+func (s *Server) canAccessNode(p rbac.Principal, n *node.Node) bool {
+	if !p.Scoped() {
+		return true
+	}
+
+	if d := n.Dedicated; !d.IsZero() {
+		return d.TenantID == p.TenantID ||
+			d.UserID != "" && s.userTracker.TenantOf(d.UserID) == p.TenantID
+	}
+	if len(n.Users) == 0 {
+		return false
+	}
+	for _, userID := range n.Users {
+		if s.userTracker.TenantOf(userID) != p.TenantID {
+			return false
+		}
+	}
+	return true
+}
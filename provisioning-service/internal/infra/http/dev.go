@@ -0,0 +1,37 @@
+package http
+
+import (
+	"context"
+	"slices"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/rbac"
+	"github.com/gofiber/fiber/v3"
+	"go.uber.org/zap"
+)
+
+// EventPublisher publishes messages to a pub/sub channel
+type EventPublisher interface {
+	Publish(ctx context.Context, channel, message string) error
+}
+
+// EnableEventInjection adds POST /admin/dev/events/:channel, which publishes
+// the request body on an inbound channel. With the in-process event bus it is
+// the only way to send the service events.
+func (s *Server) EnableEventInjection(publisher EventPublisher) {
+	s.app.Post("/admin/dev/events/:channel", s.adminAuth, s.requirePool(rbac.RoleOperator), func(c fiber.Ctx) error {
+		channel := param(c, "channel")
+		if !slices.Contains(events.InboundChannels(), channel) {
+			return errorResponse(c, errcode.New(errcode.NotFound, "unknown channel "+channel))
+		}
+
+		if err := publisher.Publish(c.Context(), channel, string(c.Body())); err != nil {
+			return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error(), "code": errcode.Internal})
+		}
+
+		s.logger.Debug("event injected", zap.String("channel", channel))
+		// Events are handled asynchronously; invalid ones are logged and dropped
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"channel": channel})
+	})
+}
@@ -0,0 +1,37 @@
+package http
+
+import (
+	_ "embed"
+
+	"github.com/gofiber/fiber/v3"
+)
+
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// swaggerUIPage renders Swagger UI against the embedded OpenAPI document
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Provisioning Service API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({ url: "/openapi.yaml", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>`
+
+func (s *Server) openAPIHandler(c fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "application/yaml")
+	return c.Send(openAPISpec)
+}
+
+func (s *Server) docsHandler(c fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.SendString(swaggerUIPage)
+}
@@ -0,0 +1,51 @@
+package http
+
+import (
+	"errors"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/rbac"
+	"github.com/gofiber/fiber/v3"
+	"go.uber.org/zap"
+)
+
+// EnableEventIngestion adds POST /events/* endpoints that pass inbound
+// events straight to the handler the event subscriber feeds, for
+// environments without access to the event transport and for tests.
+// Events are decoded and validated exactly as on the transport, and only
+// reach the replica that serves the request; a standby only accepts
+// activity. Reply channels are checked against the transport's namespace.
+func (s *Server) EnableEventIngestion(handler events.Handler, namespace events.Namespace) {
+	group := s.app.Group("/events", s.adminAuth, s.requirePool(rbac.RoleOperator))
+	group.Post("/activity", s.ingest(handler, namespace, ""))
+	group.Post("/connect", s.requireLeader, s.ingest(handler, namespace, events.ChannelUserConnect))
+	group.Post("/disconnect", s.requireLeader, s.ingest(handler, namespace, events.ChannelUserDisconnect))
+	group.Post("/node-status", s.requireLeader, s.ingest(handler, namespace, events.ChannelNodeStatus))
+	group.Post("/migrate-ack", s.requireLeader, s.ingest(handler, namespace, events.ChannelUserMigrateAck))
+	group.Post("/confirm", s.requireLeader, s.ingest(handler, namespace, events.ChannelUserConfirm))
+	group.Post("/utilization", s.requireLeader, s.ingest(handler, namespace, events.ChannelNodeUtilization))
+}
+
+// ingest dispatches the request body as an event on channel; an empty
+// channel takes a single activity or a batch, depending on the body
+func (s *Server) ingest(handler events.Handler, namespace events.Namespace, channel string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		ch := channel
+		if ch == "" {
+			ch = events.ActivityChannel(c.Body())
+		}
+
+		err := events.Dispatch(events.WithNamespace(c.Context(), namespace), handler, ch, c.Body())
+		var decodeErr *events.DecodeError
+		if errors.As(err, &decodeErr) {
+			return errorResponse(c, errcode.Wrap(errcode.InvalidRequest, err))
+		}
+		if err != nil {
+			return errorResponse(c, err)
+		}
+
+		s.logger.Debug("event ingested over HTTP", zap.String("channel", ch))
+		return c.JSON(fiber.Map{"channel": ch})
+	}
+}
@@ -0,0 +1,254 @@
+package http
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/rbac"
+	"github.com/aos-cc/provisioning-service/internal/domain/service"
+	"github.com/gofiber/fiber/v3"
+)
+
+// nodeHistoryHandler returns a node's current state alongside the
+// transitions, allocations and boot failures journaled for it. A node that
+// has left the pool is still described from its journal, and its final
+// record once purged, until they expire.
+func (s *Server) nodeHistoryHandler(c fiber.Ctx) error {
+	id := c.Params("id")
+	p := principalOf(c)
+
+	n, inPool := s.nodePool.Get(id)
+	archived := false
+	if !inPool {
+		if record, ok := s.journal.ArchivedNode(id); ok {
+			n, archived = &record, true
+		}
+	}
+	events, served := tenantEvents(p, s.journal.Node(id))
+	// A node gone from the pool is only shown to the tenants it served
+	if !inPool && !served {
+		return errorResponse(c, service.ErrNodeNotFound)
+	}
+
+	transitions := make([]fiber.Map, 0)
+	allocations := make([]fiber.Map, 0)
+	bootFailures := make([]fiber.Map, 0)
+	provisioning := fiber.Map{}
+	for _, e := range events {
+		switch data := e.Data.(type) {
+		case feed.NodeTransition:
+			transitions = append(transitions, fiber.Map{
+				"from":      data.From,
+				"to":        data.To,
+				"reason":    data.Reason,
+				"user_id":   e.UserID,
+				"timestamp": e.Timestamp,
+			})
+			if data.InstanceType != "" {
+				provisioning["instance_type"] = data.InstanceType
+			}
+		case feed.Allocation:
+			allocations = append(allocations, fiber.Map{
+				"action":           data.Action,
+				"user_id":          e.UserID,
+				"node_id":          e.NodeID,
+				"previous_node_id": data.PreviousNodeID,
+				"timestamp":        e.Timestamp,
+			})
+		case feed.BootFailure:
+			bootFailures = append(bootFailures, fiber.Map{
+				"attempt":     data.Attempt,
+				"reason":      data.Reason,
+				"diagnostics": data.Diagnostics,
+				"timestamp":   e.Timestamp,
+			})
+			provisioning["provider"] = data.Provider
+		}
+	}
+
+	var current fiber.Map
+	if inPool || archived {
+		current = nodeView(n)
+		provisioning["provider"] = n.Provider
+		provisioning["instance_type"] = n.InstanceType
+		provisioning["boot_attempt"] = n.BootAttempt
+		provisioning["created_at"] = n.CreatedAt.Unix()
+	}
+	provisioning["boot_failures"] = bootFailures
+
+	if events == nil {
+		events = []feed.Event{}
+	}
+	return c.JSON(fiber.Map{
+		"id":           id,
+		"in_pool":      inPool,
+		"archived":     archived,
+		"node":         current,
+		"transitions":  transitions,
+		"allocations":  allocations,
+		"provisioning": provisioning,
+		"events":       events,
+	})
+}
+
+// tenantEvents returns the journaled events of a node a principal may see:
+// those of the pool, which name no tenant, and those of the principal's
+// tenant, leaving out other tenants' on a node that served several. It
+// reports whether any event was the principal's tenant's.
+func tenantEvents(p rbac.Principal, journaled []feed.Event) ([]feed.Event, bool) {
+	var events []feed.Event
+	served := false
+	for _, e := range journaled {
+		switch {
+		case !p.Scoped() || e.TenantID == p.TenantID:
+			events = append(events, e)
+			served = true
+		case e.TenantID == "":
+			events = append(events, e)
+		}
+	}
+	return events, served
+}
+
+// userHistoryHandler explains a user's standing with the predictor and the
+// instance type recommended for them, and lists their allocations and
+// sessions, so support can tell why a user did or did not find a warm
+// node. Their activity per minute is shown for ?window=<duration>, the
+// whole activity horizon by default.
+func (s *Server) userHistoryHandler(c fiber.Ctx) error {
+	id := c.Params("id")
+
+	horizon := s.userTracker.Horizon()
+	window := horizon
+	if raw := c.Query("window"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			return errorResponse(c, errcode.New(errcode.InvalidRequest, "invalid window duration"))
+		}
+		if parsed > horizon {
+			return errorResponse(c, errcode.New(errcode.InvalidRequest, fmt.Sprintf("window exceeds the activity horizon (%s)", horizon)))
+		}
+		window = parsed
+	}
+
+	state, known := s.userTracker.StateOf(id)
+	events := s.journal.User(id)
+	if !known && len(events) == 0 {
+		return errorResponse(c, service.ErrUserNotFound)
+	}
+
+	allocations := make([]fiber.Map, 0)
+	for _, e := range events {
+		if data, ok := e.Data.(feed.Allocation); ok {
+			allocations = append(allocations, fiber.Map{
+				"action":           data.Action,
+				"node_id":          e.NodeID,
+				"previous_node_id": data.PreviousNodeID,
+				"timestamp":        e.Timestamp,
+			})
+		}
+	}
+
+	sessions := make([]fiber.Map, 0)
+	for _, session := range s.provisioner.SessionHistory(id) {
+		endedAt, duration := int64(0), time.Since(session.StartedAt).Seconds()
+		if !session.EndedAt.IsZero() {
+			endedAt, duration = session.EndedAt.UnixMilli(), session.DurationSeconds
+		}
+		sessions = append(sessions, fiber.Map{
+			"node_id":          session.NodeID,
+			"instance_type":    session.InstanceType,
+			"started_at":       session.StartedAt.UnixMilli(),
+			"ended_at":         endedAt,
+			"end_reason":       session.EndReason,
+			"duration_seconds": duration,
+		})
+	}
+
+	openSessions := make([]fiber.Map, 0, len(state.Sessions))
+	for _, session := range state.Sessions {
+		openSessions = append(openSessions, fiber.Map{
+			"session_id":   session.ID,
+			"connected_at": session.ConnectedAt.Unix(),
+		})
+	}
+
+	now := time.Now()
+	buckets := state.Histogram.Since(now.Add(-window))
+	histogram := make([]fiber.Map, 0, len(buckets))
+	for _, b := range buckets {
+		histogram = append(histogram, fiber.Map{
+			"start": b.Start.Unix(),
+			"count": b.Count,
+		})
+	}
+	peak := buckets.Peak()
+	burst := s.userTracker.BurstOf(id, now)
+
+	prediction := s.provisioner.PredictUser(id)
+	var recommendation fiber.Map
+	if r, ok := s.provisioner.RecommendInstanceType(id); ok {
+		recommendation = fiber.Map{
+			"instance_type":       r.InstanceType,
+			"recommended":         r.Recommended,
+			"direction":           r.Direction,
+			"reason":              r.Reason,
+			"samples":             r.Samples,
+			"average_gpu_percent": r.AverageGPU,
+			"peak_gpu_percent":    r.PeakGPU,
+			"peak_memory_percent": r.PeakMemory,
+		}
+	}
+	var waitingSince int64
+	if since, ok := s.provisioner.WaitingSince(id); ok {
+		waitingSince = since.Unix()
+	}
+
+	if events == nil {
+		events = []feed.Event{}
+	}
+	return c.JSON(fiber.Map{
+		"user_id":                 id,
+		"known":                   known,
+		"tenant_id":               state.TenantID,
+		"tier":                    state.Tier,
+		"selector":                state.Selector,
+		"preferred_instance_type": state.InstanceType,
+		"connected":               state.IsConnected,
+		"allocated_node_id":       state.AllocatedNodeID,
+		"open_sessions":           openSessions,
+		"last_node_id":            state.LastNodeID,
+		"waiting_since":           waitingSince,
+		"activity": fiber.Map{
+			"count":           buckets.Total(),
+			"score":           state.ActivityScore,
+			"activities":      state.Activities,
+			"last_activity":   unixOrZero(state.LastActivityTime),
+			"window_seconds":  window.Seconds(),
+			"horizon_seconds": horizon.Seconds(),
+			"histogram":       histogram,
+			"peak": fiber.Map{
+				"start": unixOrZero(peak.Start),
+				"count": peak.Count,
+			},
+			"burst": fiber.Map{
+				"bursting": burst.Bursting,
+				"count":    burst.Count,
+				"baseline": burst.Baseline,
+			},
+		},
+		"prediction": fiber.Map{
+			"likely":                  prediction.Likely,
+			"reason":                  prediction.Reason,
+			"score":                   prediction.Score,
+			"threshold":               prediction.Threshold,
+			"activity_window_seconds": prediction.Window.Seconds(),
+		},
+		"recommendation": recommendation,
+		"allocations":    allocations,
+		"sessions":       sessions,
+		"events":         events,
+	})
+}
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/rbac"
+)
+
+func TestTenantEvents(t *testing.T) {
+	journaled := []feed.Event{
+		{Type: "node", NodeID: "n1"},
+		{Type: "allocation", NodeID: "n1", UserID: "u1", TenantID: "acme"},
+		{Type: "allocation", NodeID: "n1", UserID: "u2", TenantID: "globex"},
+	}
+
+	tests := []struct {
+		name      string
+		principal rbac.Principal
+		users     []string
+		served    bool
+	}{
+		{name: "operator", principal: rbac.Principal{}, users: []string{"", "u1", "u2"}, served: true},
+		{name: "tenant served", principal: rbac.Principal{TenantID: "acme"}, users: []string{"", "u1"}, served: true},
+		{name: "tenant not served", principal: rbac.Principal{TenantID: "initech"}, users: []string{""}, served: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, served := tenantEvents(tt.principal, journaled)
+			if served != tt.served {
+				t.Errorf("served = %v, want %v", served, tt.served)
+			}
+			var users []string
+			for _, e := range events {
+				users = append(users, e.UserID)
+			}
+			if len(users) != len(tt.users) {
+				t.Fatalf("users = %q, want %q", users, tt.users)
+			}
+			for i := range users {
+				if users[i] != tt.users[i] {
+					t.Fatalf("users = %q, want %q", users, tt.users)
+				}
+			}
+		})
+	}
+}
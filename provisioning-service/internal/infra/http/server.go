@@ -1,35 +1,91 @@
 package http
 
 import (
+	"cmp"
 	"context"
 	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/aos-cc/provisioning-service/internal/buildinfo"
+	"github.com/aos-cc/provisioning-service/internal/domain/accuracy"
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/aos-cc/provisioning-service/internal/domain/journal"
 	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/predictor"
+	"github.com/aos-cc/provisioning-service/internal/domain/rbac"
+	"github.com/aos-cc/provisioning-service/internal/domain/schedule"
+	"github.com/aos-cc/provisioning-service/internal/domain/service"
+	"github.com/aos-cc/provisioning-service/internal/domain/startup"
 	"github.com/aos-cc/provisioning-service/internal/domain/user"
+	"github.com/aos-cc/provisioning-service/internal/infra/health"
+	"github.com/aos-cc/provisioning-service/internal/infra/metrics"
 	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/adaptor"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
+// SubscriptionStatus reports the health of the inbound event subscription
+type SubscriptionStatus interface {
+	Subscribed() bool
+	Reconnects() int64
+}
+
+// StartupStatus reports the progress of the startup phases
+type StartupStatus interface {
+	Complete() bool
+	Phases() []startup.PhaseStatus
+}
+
 // Server is the HTTP server for health checks and metrics
 type Server struct {
-	app        *fiber.App
-	port       int
-	logger     *zap.Logger
-	nodePool   *node.NodePool
+	app         *fiber.App
+	port        int
+	adminToken  string
+	verifier    TokenVerifier // Nil unless JWT authentication is enabled
+	logger      *zap.Logger
+	logLevel    zap.AtomicLevel
+	nodePool    *node.NodePool
 	userTracker *user.UserTracker
+	provisioner *service.Provisioner
+	subscriber  SubscriptionStatus
+	startup     StartupStatus
+	health      *health.Checker
+	feed        *feed.Hub
+	journal     *journal.Journal
+	prometheus  *metrics.Prometheus
+	configHash  string
+	profile     string
 }
 
 // NewServer creates a new HTTP server
-func NewServer(port int, logger *zap.Logger, nodePool *node.NodePool, userTracker *user.UserTracker) *Server {
+func NewServer(port int, adminToken string, logger *zap.Logger, logLevel zap.AtomicLevel, nodePool *node.NodePool, userTracker *user.UserTracker, provisioner *service.Provisioner, subscriber SubscriptionStatus, sequence StartupStatus, checker *health.Checker, hub *feed.Hub, j *journal.Journal, prom *metrics.Prometheus, configHash, profile string) *Server {
 	app := fiber.New()
 
 	s := &Server{
 		app:         app,
 		port:        port,
+		adminToken:  adminToken,
 		logger:      logger,
+		logLevel:    logLevel,
 		nodePool:    nodePool,
 		userTracker: userTracker,
+		provisioner: provisioner,
+		subscriber:  subscriber,
+		startup:     sequence,
+		health:      checker,
+		feed:        hub,
+		journal:     j,
+		prometheus:  prom,
+		configHash:  configHash,
+		profile:     profile,
 	}
 
 	s.setupRoutes()
@@ -38,68 +94,909 @@ func NewServer(port int, logger *zap.Logger, nodePool *node.NodePool, userTracke
 }
 
 func (s *Server) setupRoutes() {
+	s.app.Use(s.requestID, s.accessLog)
+
 	s.app.Get("/health", s.healthHandler)
+	s.app.Get("/readyz", s.readyHandler)
+	s.app.Get("/version", s.versionHandler)
 	s.app.Get("/metrics", s.metricsHandler)
-	s.app.Get("/status", s.statusHandler)
+	s.app.Get("/metrics/history", s.metricsHistoryHandler)
+	s.app.Get("/metrics/prometheus", adaptor.HTTPHandler(s.prometheus.Handler()))
+	s.app.Get("/openapi.yaml", s.openAPIHandler)
+	s.app.Get("/docs", s.docsHandler)
+	s.app.Get("/ws", s.wsAuth, s.requireRole(rbac.RoleViewer), s.wsHandler)
+
+	// Viewers read, operators manage nodes and users, and admins change the
+	// pool's configuration. A tenant's callers only reach their own users
+	// and nodes.
+	viewer, operator := s.requireRole(rbac.RoleViewer), s.requireRole(rbac.RoleOperator)
+	admin := s.app.Group("/admin", s.adminAuth)
+
+	// The pool-wide status and prediction outcomes name every tenant's users
+	s.app.Get("/status", s.adminAuth, s.requirePool(rbac.RoleViewer), s.statusHandler)
+	s.app.Get("/metrics/predictions", s.adminAuth, s.requirePool(rbac.RoleViewer), s.predictionsHandler)
+	admin.Get("/status", viewer, s.adminStatusHandler)
+	admin.Get("/decision", s.requirePool(rbac.RoleViewer), s.decisionHandler)
+	admin.Put("/scale", s.requirePool(rbac.RoleAdmin), s.requireLeader, s.scaleHandler)
+	admin.Post("/scale/check", s.requirePool(rbac.RoleOperator), s.requireLeader, s.scaleCheckHandler)
+	admin.Get("/prediction/config", s.requirePool(rbac.RoleViewer), s.predictionConfigHandler)
+	admin.Put("/prediction/config", s.requirePool(rbac.RoleAdmin), s.requireLeader, s.setPredictionConfigHandler)
+	admin.Get("/drain", s.requirePool(rbac.RoleViewer), s.drainStatusHandler)
+	admin.Post("/drain", s.requirePool(rbac.RoleAdmin), s.requireLeader, s.startDrainHandler)
+	admin.Delete("/drain", s.requirePool(rbac.RoleAdmin), s.requireLeader, s.cancelDrainHandler)
+	admin.Get("/nodes/:id", viewer, s.nodeInTenant, s.nodeHistoryHandler)
+	admin.Post("/nodes/:id/terminate", operator, s.nodeInTenant, s.requireLeader, s.terminateHandler)
+	admin.Post("/nodes/:id/cordon", operator, s.nodeInTenant, s.requireLeader, s.cordonHandler)
+	admin.Post("/nodes/:id/uncordon", operator, s.nodeInTenant, s.requireLeader, s.uncordonHandler)
+	admin.Post("/nodes/:id/drain", operator, s.nodeInTenant, s.requireLeader, s.drainHandler)
+	admin.Get("/users/:id", viewer, s.userInTenant, s.userHistoryHandler)
+	admin.Post("/users/:id/allocate", s.requirePool(rbac.RoleOperator), s.requireLeader, s.allocateUserHandler)
+	admin.Post("/users/:id/deallocate", operator, s.userInTenant, s.requireLeader, s.deallocateUserHandler)
+	admin.Post("/users/:id/reassign", operator, s.userInTenant, s.requireLeader, s.reassignUserHandler)
+	admin.Post("/users/:id/migrate", operator, s.userInTenant, s.requireLeader, s.migrateUserHandler)
+	admin.Get("/access", s.requirePool(rbac.RoleViewer), s.accessHandler)
+	admin.Put("/access/:list/:user", s.requirePool(rbac.RoleAdmin), s.requireLeader, s.grantAccessHandler)
+	admin.Delete("/access/:list/:user", s.requirePool(rbac.RoleAdmin), s.requireLeader, s.revokeAccessHandler)
+	admin.Get("/schedule", s.requirePool(rbac.RoleViewer), s.scheduleHandler)
+	admin.Put("/schedule", s.requirePool(rbac.RoleAdmin), s.requireLeader, s.setScheduleHandler)
+	admin.Get("/state/export", s.requirePool(rbac.RoleAdmin), s.exportStateHandler)
+	admin.Post("/state/import", s.requirePool(rbac.RoleAdmin), s.requireLeader, s.importStateHandler)
+	admin.Get("/loglevel", s.requirePool(rbac.RoleViewer), s.logLevelHandler)
+	admin.Put("/loglevel", s.requirePool(rbac.RoleAdmin), s.setLogLevelHandler)
 }
 
+// requireLeader rejects changes to the pool on a standby replica, whose
+// pool mirrors the leader's and would be overwritten
+func (s *Server) requireLeader(c fiber.Ctx) error {
+	if !s.provisioner.IsLeader() {
+		return errorResponse(c, service.ErrNotLeader)
+	}
+	return c.Next()
+}
+
+// healthHandler checks downstream dependencies and reports 503 if any fails,
+// so load balancers stop routing to an instance that can no longer work
 func (s *Server) healthHandler(c fiber.Ctx) error {
-	return c.JSON(fiber.Map{
-		"status": "healthy",
+	healthy, checks := s.health.Run(c.Context())
+
+	status, code := "healthy", fiber.StatusOK
+	if !healthy {
+		status, code = "degraded", fiber.StatusServiceUnavailable
+	}
+
+	return c.Status(code).JSON(fiber.Map{
+		"status": status,
+		"checks": checks,
 		"time":   time.Now().Unix(),
 	})
 }
 
+// versionHandler reports the running build, so behaviour changes can be
+// matched to deploys
+func (s *Server) versionHandler(c fiber.Ctx) error {
+	build := buildinfo.Get()
+	return c.JSON(fiber.Map{
+		"version":        build.Version,
+		"commit":         build.Commit,
+		"build_time":     build.BuildTime,
+		"go_version":     build.GoVersion,
+		"config_hash":    s.configHash,
+		"profile":        s.profile,
+		"started_at":     buildinfo.StartedAt().Unix(),
+		"uptime_seconds": int64(buildinfo.Uptime().Seconds()),
+	})
+}
+
+// readyHandler reports not ready until every startup phase has ended, and
+// while the event subscription is down
+func (s *Server) readyHandler(c fiber.Ctx) error {
+	phases := s.startup.Phases()
+	views := make([]fiber.Map, 0, len(phases))
+	for _, phase := range phases {
+		view := fiber.Map{
+			"name":            phase.Name,
+			"state":           phase.State,
+			"timeout_seconds": phase.Timeout.Seconds(),
+			"started_at":      unixOrZero(phase.StartedAt),
+			"finished_at":     unixOrZero(phase.FinishedAt),
+		}
+		if phase.Error != "" {
+			view["error"] = phase.Error
+		}
+		views = append(views, view)
+	}
+
+	subscribed := s.subscriber.Subscribed()
+	started := s.startup.Complete()
+	status, code := "ready", fiber.StatusOK
+	if !subscribed || !started {
+		status, code = "not_ready", fiber.StatusServiceUnavailable
+	}
+
+	return c.Status(code).JSON(fiber.Map{
+		"status":     status,
+		"subscribed": subscribed,
+		"started":    started,
+		"startup":    views,
+		"time":       time.Now().Unix(),
+	})
+}
+
 func (s *Server) metricsHandler(c fiber.Ctx) error {
+	cooldown := s.provisioner.CooldownState()
+	sloSnapshot := s.provisioner.SLOSnapshot()
+	demandForecast := s.provisioner.DemandForecast()
+	bootFailures := s.provisioner.BootFailureState()
+	spend := s.provisioner.BudgetSnapshot()
+	mix := s.provisioner.PurchasingSnapshot()
+	predictions := s.provisioner.PredictionAccuracy()
+
 	metrics := fiber.Map{
 		"nodes": fiber.Map{
-			"total":      s.nodePool.Count(),
-			"booting":    s.nodePool.CountByStatus(node.NodeStatusBooting),
-			"ready":      s.nodePool.CountByStatus(node.NodeStatusReady),
-			"allocated":  s.nodePool.CountByStatus(node.NodeStatusAllocated),
-			"terminated": s.nodePool.CountByStatus(node.NodeStatusTerminated),
+			"total":              s.nodePool.Count(),
+			"booting":            s.nodePool.CountByStatus(node.NodeStatusBooting),
+			"ready":              s.nodePool.CountByStatus(node.NodeStatusReady),
+			"allocated":          s.nodePool.CountByStatus(node.NodeStatusAllocated),
+			"unconfirmed":        s.nodePool.CountByStatus(node.NodeStatusUnconfirmed),
+			"terminated":         s.nodePool.CountByStatus(node.NodeStatusTerminated),
+			"incompatible_agent": s.nodePool.CountIncompatible(),
+			"reserved":           s.nodePool.CountReserved(),
+			"burst":              s.nodePool.CountBurst(),
+			"by_instance_type":   s.nodesByType(),
 		},
 		"users": fiber.Map{
 			"connected": len(s.userTracker.GetConnectedUsers()),
 		},
-		"timestamp": time.Now().Unix(),
+		"subscriber": fiber.Map{
+			"subscribed": s.subscriber.Subscribed(),
+			"reconnects": s.subscriber.Reconnects(),
+		},
+		"scaling": fiber.Map{
+			"last_scale_up":                         unixOrZero(cooldown.LastScaleUp),
+			"last_scale_down":                       unixOrZero(cooldown.LastScaleDown),
+			"scale_up_cooldown_remaining_seconds":   cooldown.ScaleUpRemaining.Seconds(),
+			"scale_down_cooldown_remaining_seconds": cooldown.ScaleDownRemaining.Seconds(),
+			"estimated_boot_seconds":                s.provisioner.EstimatedBootTime().Seconds(),
+			"consecutive_boot_failures":             bootFailures.Consecutive,
+			"boot_backoff_remaining_seconds":        bootFailures.BackoffRemaining.Seconds(),
+			"prediction_window_seconds":             s.provisioner.PredictionWindow().Seconds(),
+			"boot_times":                            s.bootTimes(),
+		},
+		"forecast": fiber.Map{
+			"concurrent":   demandForecast.Concurrent,
+			"connect_rate": demandForecast.ConnectRate,
+			"samples":      demandForecast.Samples,
+		},
+		"slo": fiber.Map{
+			"window_seconds": sloSnapshot.Window.Seconds(),
+			"target":         sloSnapshot.Target,
+			"connects":       sloSnapshot.Connects,
+			"cold_starts":    sloSnapshot.ColdStarts,
+			"compliance":     sloSnapshot.Compliance,
+			"met":            sloSnapshot.Met(),
+			"users_waiting":  sloSnapshot.Waiting,
+		},
+		"queue": fiber.Map{
+			"depth":                sloSnapshot.Waiting,
+			"oldest_wait_seconds":  sloSnapshot.Queue.OldestWait.Seconds(),
+			"served":               sloSnapshot.Queue.Served,
+			"abandoned":            sloSnapshot.Queue.Abandoned,
+			"average_wait_seconds": sloSnapshot.Queue.AverageWait.Seconds(),
+			"p95_wait_seconds":     sloSnapshot.Queue.P95Wait.Seconds(),
+		},
+		"budget": fiber.Map{
+			"enabled":         spend.Enabled,
+			"hourly_run_rate": spend.HourlyRunRate,
+			"spent_today":     spend.SpentToday,
+			"max_hourly":      spend.MaxHourly,
+			"max_daily":       spend.MaxDaily,
+			"blocked_nodes":   spend.Blocked,
+		},
+		"purchasing": fiber.Map{
+			"enabled":            mix.Enabled,
+			"target_spot_ratio":  mix.TargetSpotRatio,
+			"spot_ratio":         mix.SpotRatio,
+			"nodes":              mix.Nodes,
+			"reserved":           mix.Reserved,
+			"interruption_rates": mix.Interruptions,
+		},
+		"predictions":          accuracyMap(predictions),
+		"invariant_violations": s.provisioner.InvariantViolations(),
+		"timestamp":            time.Now().Unix(),
 	}
 
 	return c.JSON(metrics)
 }
 
+// metricsHistoryHandler returns pool samples for ?window=<duration> (default 1h)
+func (s *Server) metricsHistoryHandler(c fiber.Ctx) error {
+	window := time.Hour
+	if raw := c.Query("window"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			return errorResponse(c, errcode.New(errcode.InvalidRequest, "invalid window duration"))
+		}
+		window = parsed
+	}
+
+	samples := s.provisioner.MetricsHistory(time.Now().Add(-window))
+	points := make([]fiber.Map, 0, len(samples))
+	for _, sample := range samples {
+		points = append(points, fiber.Map{
+			"timestamp":       sample.Timestamp.Unix(),
+			"booting":         sample.Booting,
+			"ready":           sample.Ready,
+			"allocated":       sample.Allocated,
+			"terminated":      sample.Terminated,
+			"demand":          sample.Demand,
+			"connected_users": sample.ConnectedUsers,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"window":  window.String(),
+		"samples": points,
+	})
+}
+
+// predictionsHandler returns prediction accuracy and up to ?limit=<n>
+// (default 100) recent outcomes, newest first
+func (s *Server) predictionsHandler(c fiber.Ctx) error {
+	limit := 100
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			return errorResponse(c, errcode.New(errcode.InvalidRequest, "invalid limit"))
+		}
+		limit = parsed
+	}
+
+	records := s.provisioner.RecentPredictions(limit)
+	outcomes := make([]fiber.Map, 0, len(records))
+	for _, r := range records {
+		outcome := fiber.Map{
+			"user_id":     r.UserID,
+			"outcome":     r.Outcome,
+			"resolved_at": r.ResolvedAt.Unix(),
+		}
+		if !r.PredictedAt.IsZero() {
+			outcome["predicted_at"] = r.PredictedAt.Unix()
+		}
+		if r.Outcome == accuracy.OutcomeHit {
+			outcome["lead_seconds"] = r.ResolvedAt.Sub(r.PredictedAt).Seconds()
+		}
+		outcomes = append(outcomes, outcome)
+	}
+
+	response := accuracyMap(s.provisioner.PredictionAccuracy())
+	response["outcomes"] = outcomes
+	return c.JSON(response)
+}
+
+func accuracyMap(snapshot accuracy.Snapshot) fiber.Map {
+	return fiber.Map{
+		"window_seconds": snapshot.Window.Seconds(),
+		"open":           snapshot.Open,
+		"hits":           snapshot.Hits,
+		"expired":        snapshot.Expired,
+		"unpredicted":    snapshot.Unpredicted,
+		"precision":      snapshot.Precision,
+		"recall":         snapshot.Recall,
+	}
+}
+
 func (s *Server) statusHandler(c fiber.Ctx) error {
+	return c.JSON(s.status(unrestricted))
+}
+
+// adminStatusHandler is the status limited to what the caller may see
+func (s *Server) adminStatusHandler(c fiber.Ctx) error {
+	return c.JSON(s.status(principalOf(c)))
+}
+
+// nodeView describes a node's current state
+func nodeView(n *node.Node) fiber.Map {
+	return fiber.Map{
+		"id":            n.ID,
+		"status":        n.Status,
+		"user_id":       n.User(),
+		"users":         n.Users,
+		"capacity":      n.Slots(),
+		"agent_version": n.AgentVersion,
+		"instance_type": n.InstanceType,
+		"pool_type":     n.PoolType,
+		"labels":        n.Labels,
+		"provider":      n.Provider,
+		"zone":          n.Zone,
+		"purchase":      n.Purchase,
+		"address":       n.Endpoint.Address,
+		"hostname":      n.Endpoint.Hostname,
+		"port":          n.Endpoint.Port,
+		"cordoned":      n.Cordoned,
+		"draining":      n.Draining,
+		"burst":         n.Burst,
+		"reserved_for":  reservedFor(n),
+		"dedicated_to":  n.Dedicated.String(),
+		"unconfirmed":   slices.Sorted(maps.Keys(n.Pending)),
+		"utilization":   utilization(n),
+		"busy_at":       unixOrZero(n.BusyAt),
+		"created_at":    n.CreatedAt.Unix(),
+		"updated_at":    n.UpdatedAt.Unix(),
+
+		"termination_reason": n.TerminationReason,
+		"terminated_at":      unixOrZero(n.TerminatedAt),
+	}
+}
+
+// status lists the nodes, connected users and migrations a principal may see
+func (s *Server) status(p rbac.Principal) fiber.Map {
 	nodes := s.nodePool.GetAll()
 	connectedUsers := s.userTracker.GetConnectedUsers()
 
 	nodeDetails := make([]fiber.Map, 0, len(nodes))
 	for _, node := range nodes {
-		nodeDetails = append(nodeDetails, fiber.Map{
-			"id":         node.ID,
-			"status":     node.Status,
-			"user_id":    node.UserID,
-			"created_at": node.CreatedAt.Unix(),
-			"updated_at": node.UpdatedAt.Unix(),
-		})
+		if !s.canAccessNode(p, node) {
+			continue
+		}
+		nodeDetails = append(nodeDetails, nodeView(node))
 	}
 
 	userDetails := make([]fiber.Map, 0, len(connectedUsers))
 	for _, user := range connectedUsers {
+		if !p.CanAccessTenant(user.TenantID) {
+			continue
+		}
 		userDetails = append(userDetails, fiber.Map{
 			"user_id":           user.UserID,
 			"allocated_node_id": user.AllocatedNodeID,
+			"open_sessions":     len(s.userTracker.SessionsOf(user.UserID)),
 			"last_activity":     user.LastActivityTime.Unix(),
-			"activity_count":    user.ActivityCount,
+			"activity_count":    s.userTracker.ActivityCountOf(user.UserID, s.userTracker.Horizon()),
+			"activity_score":    user.ActivityScore,
+			"activities":        s.userTracker.ActivitiesOf(user.UserID),
+		})
+	}
+
+	migrations := s.provisioner.Migrations()
+	migrationDetails := make([]fiber.Map, 0, len(migrations))
+	for _, m := range migrations {
+		if !p.CanAccessTenant(s.userTracker.TenantOf(m.UserID)) {
+			continue
+		}
+		migrationDetails = append(migrationDetails, migrationResponse(m))
+	}
+
+	return fiber.Map{
+		"nodes":      nodeDetails,
+		"users":      userDetails,
+		"migrations": migrationDetails,
+		"replica":    s.provisioner.ReplicaID(),
+		"leader":     s.provisioner.IsLeader(),
+		"timestamp":  time.Now().Unix(),
+	}
+}
+
+func (s *Server) decisionHandler(c fiber.Ctx) error {
+	decision, at := s.provisioner.LastDecision()
+	return c.JSON(fiber.Map{
+		"should_scale_up":   decision.ShouldScaleUp,
+		"should_scale_down": decision.ShouldScaleDown,
+		"target_nodes":      decision.TargetNodes,
+		"burst_nodes":       burstNodes(decision),
+		"reason":            decision.Reason,
+		"instance_types":    typeDecisions(decision),
+		"decided_at":        unixOrZero(at),
+	})
+}
+
+// bootTimes lists recent boot time percentiles and the resulting prediction
+// window by instance type pool; "" is the pool when types are not configured
+func (s *Server) bootTimes() fiber.Map {
+	result := fiber.Map{}
+	for instanceType, stats := range s.provisioner.BootTimes() {
+		result[instanceType] = fiber.Map{
+			"samples":                   stats.Samples,
+			"p50_seconds":               stats.P50.Seconds(),
+			"p90_seconds":               stats.P90.Seconds(),
+			"p99_seconds":               stats.P99.Seconds(),
+			"prediction_window_seconds": stats.PredictionWindow.Seconds(),
+		}
+	}
+	return result
+}
+
+// nodesByType counts the nodes not terminated by reported instance type and
+// status, under "unknown" for nodes that reported none
+func (s *Server) nodesByType() fiber.Map {
+	result := fiber.Map{}
+	for _, instanceType := range s.nodePool.InstanceTypes() {
+		result[cmp.Or(instanceType, "unknown")] = fiber.Map{
+			"booting":     s.nodePool.CountByStatusOfType(node.NodeStatusBooting, instanceType),
+			"ready":       s.nodePool.CountByStatusOfType(node.NodeStatusReady, instanceType),
+			"allocated":   s.nodePool.CountByStatusOfType(node.NodeStatusAllocated, instanceType),
+			"unconfirmed": s.nodePool.CountByStatusOfType(node.NodeStatusUnconfirmed, instanceType),
+			"terminating": s.nodePool.CountByStatusOfType(node.NodeStatusTerminating, instanceType),
+		}
+	}
+	return result
+}
+
+// typeDecisions lists the per-instance-type decisions, empty when types are not configured
+func typeDecisions(decision predictor.ScalingDecision) []fiber.Map {
+	types := make([]fiber.Map, 0, len(decision.Types))
+	for _, t := range decision.Types {
+		types = append(types, fiber.Map{
+			"instance_type":     t.InstanceType,
+			"labels":            t.Labels,
+			"should_scale_up":   t.ShouldScaleUp,
+			"should_scale_down": t.ShouldScaleDown,
+			"target_nodes":      t.TargetNodes,
+			"burst_nodes":       t.BurstNodes,
+			"reason":            t.Reason,
 		})
 	}
+	return types
+}
+
+// burstNodes returns how many of the nodes a decision provisions take their
+// pool past its max ready nodes
+func burstNodes(decision predictor.ScalingDecision) int {
+	total := 0
+	for _, up := range decision.ScaleUps() {
+		total += up.BurstNodes
+	}
+	return total
+}
+
+// scaleRequest updates pool size limits; omitted fields keep their current value
+type scaleRequest struct {
+	MinReadyNodes *int `json:"min_ready_nodes"`
+	MaxReadyNodes *int `json:"max_ready_nodes"`
+}
+
+func (s *Server) scaleHandler(c fiber.Ctx) error {
+	var req scaleRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		return errorResponse(c, errcode.Wrap(errcode.InvalidRequest, err))
+	}
+
+	minReady, maxReady := s.provisioner.ReadyNodeLimits()
+	if req.MinReadyNodes != nil {
+		minReady = *req.MinReadyNodes
+	}
+	if req.MaxReadyNodes != nil {
+		maxReady = *req.MaxReadyNodes
+	}
+
+	if err := s.provisioner.SetReadyNodeLimits(c.Context(), minReady, maxReady); err != nil {
+		return errorResponse(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"min_ready_nodes": minReady,
+		"max_ready_nodes": maxReady,
+	})
+}
+
+// predictionConfigRequest updates prediction parameters; omitted fields keep
+// their current value
+type predictionConfigRequest struct {
+	ActivityWindowSeconds         *float64 `json:"activity_window_seconds"`
+	ActivityThreshold             *int     `json:"activity_threshold"`
+	PredictionWindowSeconds       *float64 `json:"prediction_window_seconds"`
+	TargetHeadroom                *float64 `json:"target_headroom"`
+	MinReadyNodes                 *int     `json:"min_ready_nodes"`
+	MaxReadyNodes                 *int     `json:"max_ready_nodes"`
+	BurstMaxNodes                 *int     `json:"burst_max_nodes"`
+	IdleTerminationTimeoutSeconds *float64 `json:"idle_termination_timeout_seconds"`
+	SurplusMargin                 *float64 `json:"surplus_margin"`
+}
+
+// apply sets the fields present in the request
+func (r predictionConfigRequest) apply(t *predictor.Tuning) {
+	setDuration(&t.ActivityWindow, r.ActivityWindowSeconds)
+	setValue(&t.ActivityThreshold, r.ActivityThreshold)
+	setDuration(&t.PredictionWindow, r.PredictionWindowSeconds)
+	setValue(&t.TargetHeadroom, r.TargetHeadroom)
+	setValue(&t.MinReadyNodes, r.MinReadyNodes)
+	setValue(&t.MaxReadyNodes, r.MaxReadyNodes)
+	setValue(&t.BurstMaxNodes, r.BurstMaxNodes)
+	setDuration(&t.IdleTerminationTimeout, r.IdleTerminationTimeoutSeconds)
+	setValue(&t.SurplusMargin, r.SurplusMargin)
+}
+
+func setValue[T any](field *T, value *T) {
+	if value != nil {
+		*field = *value
+	}
+}
+
+func setDuration(field *time.Duration, seconds *float64) {
+	if seconds != nil {
+		*field = time.Duration(*seconds * float64(time.Second))
+	}
+}
+
+// tuningView renders prediction parameters as the admin API reports them
+func tuningView(t predictor.Tuning) fiber.Map {
+	return fiber.Map{
+		"activity_window_seconds":          t.ActivityWindow.Seconds(),
+		"activity_threshold":               t.ActivityThreshold,
+		"prediction_window_seconds":        t.PredictionWindow.Seconds(),
+		"target_headroom":                  t.TargetHeadroom,
+		"min_ready_nodes":                  t.MinReadyNodes,
+		"max_ready_nodes":                  t.MaxReadyNodes,
+		"burst_max_nodes":                  t.BurstMaxNodes,
+		"idle_termination_timeout_seconds": t.IdleTerminationTimeout.Seconds(),
+		"surplus_margin":                   t.SurplusMargin,
+	}
+}
+
+// predictionConfigHandler reports the prediction parameters in effect
+func (s *Server) predictionConfigHandler(c fiber.Ctx) error {
+	return c.JSON(tuningView(s.provisioner.PredictionTuning()))
+}
+
+// setPredictionConfigHandler changes prediction parameters live, validating
+// the result as a whole so a partial update cannot leave them inconsistent
+func (s *Server) setPredictionConfigHandler(c fiber.Ctx) error {
+	var req predictionConfigRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		return errorResponse(c, errcode.Wrap(errcode.InvalidRequest, err))
+	}
+
+	tuning, err := s.provisioner.UpdatePredictionTuning(c.Context(), req.apply)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	return c.JSON(tuningView(tuning))
+}
+
+// drainView renders the progress of a service drain
+func drainView(status service.DrainStatus) fiber.Map {
+	return fiber.Map{
+		"draining":       status.Draining,
+		"started_at":     unixOrZero(status.StartedAt),
+		"nodes_at_start": status.NodesAtStart,
+		"nodes":          status.Nodes,
+		"occupied_nodes": status.Occupied,
+		"users":          status.Users,
+		"complete":       status.Complete(),
+	}
+}
+
+func (s *Server) drainStatusHandler(c fiber.Ctx) error {
+	return c.JSON(drainView(s.provisioner.DrainStatus()))
+}
+
+// startDrainHandler starts draining the whole service, or reports the
+// progress of the drain already under way
+func (s *Server) startDrainHandler(c fiber.Ctx) error {
+	return c.Status(fiber.StatusAccepted).JSON(drainView(s.provisioner.Drain(c.Context())))
+}
+
+// cancelDrainHandler stops draining and returns the nodes not yet
+// terminating to service
+func (s *Server) cancelDrainHandler(c fiber.Ctx) error {
+	restored, err := s.provisioner.CancelDrain(c.Context())
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	return c.JSON(fiber.Map{"draining": false, "nodes_restored": restored})
+}
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+func (s *Server) logLevelHandler(c fiber.Ctx) error {
+	return c.JSON(fiber.Map{"level": s.logLevel.String()})
+}
+
+// setLogLevelHandler changes log verbosity until the next change or restart
+func (s *Server) setLogLevelHandler(c fiber.Ctx) error {
+	var req logLevelRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		return errorResponse(c, errcode.Wrap(errcode.InvalidRequest, err))
+	}
+
+	level, err := zapcore.ParseLevel(req.Level)
+	if err != nil {
+		return errorResponse(c, errcode.Wrap(errcode.InvalidRequest, err))
+	}
+
+	previous := s.logLevel.Level()
+	s.logLevel.SetLevel(level)
+	s.logger.Info("log level changed",
+		zap.String("from", previous.String()),
+		zap.String("to", level.String()),
+	)
+
+	return c.JSON(fiber.Map{"level": level.String()})
+}
+
+// scaleCheckHandler runs a scaling evaluation now; ?dry_run=true only reports the decision
+func (s *Server) scaleCheckHandler(c fiber.Ctx) error {
+	dryRun := fiber.Query[bool](c, "dry_run")
+	result := s.provisioner.CheckScaling(c.Context(), dryRun)
+
+	response := fiber.Map{
+		"should_scale_up":   result.Decision.ShouldScaleUp,
+		"should_scale_down": result.Decision.ShouldScaleDown,
+		"target_nodes":      result.Decision.TargetNodes,
+		"burst_nodes":       burstNodes(result.Decision),
+		"reason":            result.Decision.Reason,
+		"instance_types":    typeDecisions(result.Decision),
+		"dry_run":           result.DryRun,
+		"deferred":          result.Deferred,
+		"provisioned":       result.Provisioned,
+		"budget_blocked":    result.BudgetBlocked,
+	}
+	if result.Err != nil {
+		response["error"] = result.Err.Error()
+		response["code"] = errcode.Of(result.Err)
+		return c.Status(fiber.StatusBadGateway).JSON(response)
+	}
+	return c.JSON(response)
+}
+
+// terminateHandler terminates a node; ?force=true is required if a user is on it
+func (s *Server) terminateHandler(c fiber.Ctx) error {
+	force := fiber.Query[bool](c, "force")
+	return s.nodeActionResponse(c, "terminated", s.provisioner.TerminateNode(c.Context(), param(c, "id"), force))
+}
+
+func (s *Server) cordonHandler(c fiber.Ctx) error {
+	return s.nodeActionResponse(c, "cordoned", s.provisioner.CordonNode(param(c, "id")))
+}
+
+func (s *Server) uncordonHandler(c fiber.Ctx) error {
+	return s.nodeActionResponse(c, "uncordoned", s.provisioner.UncordonNode(param(c, "id")))
+}
+
+func (s *Server) drainHandler(c fiber.Ctx) error {
+	return s.nodeActionResponse(c, "draining", s.provisioner.DrainNode(param(c, "id")))
+}
+
+func (s *Server) nodeActionResponse(c fiber.Ctx, state string, err error) error {
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"node_id": c.Params("id"),
+		"state":   state,
+	})
+}
+
+// allocateUserHandler connects a user and answers with the node serving
+// them, in the shape of an allocation result event
+func (s *Server) allocateUserHandler(c fiber.Ctx) error {
+	var event events.UserConnectEvent
+	if len(c.Body()) > 0 {
+		if err := c.Bind().JSON(&event); err != nil {
+			return errorResponse(c, errcode.Wrap(errcode.InvalidRequest, err))
+		}
+	}
+	event.UserID = param(c, "id")
+	if err := event.Validate(); err != nil {
+		return errorResponse(c, errcode.Wrap(errcode.InvalidRequest, err))
+	}
+
+	result, err := s.provisioner.Allocate(c.Context(), event)
+	if err == nil && result.Status == events.AllocationStatusFailed {
+		err = errcode.New(errcode.Code(result.Code), result.Reason)
+	}
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	return c.JSON(result)
+}
 
+func (s *Server) deallocateUserHandler(c fiber.Ctx) error {
+	nodeID, err := s.provisioner.DeallocateUser(c.Context(), param(c, "id"))
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"user_id":          c.Params("id"),
+		"previous_node_id": nodeID,
+		"state":            "deallocated",
+	})
+}
+
+func (s *Server) reassignUserHandler(c fiber.Ctx) error {
+	fromID, toID, err := s.provisioner.ReassignUser(c.Context(), param(c, "id"))
+	if err != nil {
+		return errorResponse(c, err)
+	}
 	return c.JSON(fiber.Map{
-		"nodes":     nodeDetails,
-		"users":     userDetails,
-		"timestamp": time.Now().Unix(),
+		"user_id":          c.Params("id"),
+		"previous_node_id": fromID,
+		"node_id":          toID,
+		"state":            "reassigned",
 	})
 }
 
+// migrateUserHandler starts a graceful migration; the user keeps their
+// current node until their client acknowledges or the migration times out
+func (s *Server) migrateUserHandler(c fiber.Ctx) error {
+	m, err := s.provisioner.MigrateUser(c.Context(), param(c, "id"), events.MigrationReasonOperator)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(migrationResponse(m))
+}
+
+func migrationResponse(m service.Migration) fiber.Map {
+	return fiber.Map{
+		"migration_id":     m.ID,
+		"user_id":          m.UserID,
+		"previous_node_id": m.FromNodeID,
+		"node_id":          m.ToNodeID,
+		"reason":           m.Reason,
+		"started_at":       m.StartedAt.Unix(),
+		"deadline":         m.Deadline.Unix(),
+		"state":            "migrating",
+	}
+}
+
+func (s *Server) accessHandler(c fiber.Ctx) error {
+	snapshot := s.provisioner.AccessSnapshot()
+	return c.JSON(fiber.Map{
+		"mode":       snapshot.Mode,
+		"blocklist":  snapshot.Blocklist,
+		"allowlist":  snapshot.Allowlist,
+		"authorizer": snapshot.Authorizer,
+	})
+}
+
+func (s *Server) grantAccessHandler(c fiber.Ctx) error {
+	if err := s.provisioner.GrantAccess(param(c, "list"), param(c, "user")); err != nil {
+		return errorResponse(c, err)
+	}
+	return s.accessHandler(c)
+}
+
+func (s *Server) revokeAccessHandler(c fiber.Ctx) error {
+	if err := s.provisioner.RevokeAccess(param(c, "list"), param(c, "user")); err != nil {
+		return errorResponse(c, err)
+	}
+	return s.accessHandler(c)
+}
+
+// scheduleRequest replaces the sessions pushed through the admin API
+type scheduleRequest struct {
+	Sessions []schedule.Session `json:"sessions"`
+}
+
+// scheduleHandler lists upcoming sessions and the attendees expected within
+// the prediction window
+func (s *Server) scheduleHandler(c fiber.Ctx) error {
+	snapshot, err := s.provisioner.Schedule()
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	sessions := snapshot.Sessions
+	if sessions == nil {
+		sessions = []schedule.Session{}
+	}
+	expected := make([]string, 0, len(snapshot.Expected.Sessions))
+	for _, session := range snapshot.Expected.Sessions {
+		expected = append(expected, session.ID)
+	}
+	return c.JSON(fiber.Map{
+		"sessions":           sessions,
+		"expected_attendees": snapshot.Expected.Attendees,
+		"expected_sessions":  expected,
+	})
+}
+
+func (s *Server) setScheduleHandler(c fiber.Ctx) error {
+	var req scheduleRequest
+	if err := c.Bind().JSON(&req); err != nil {
+		return errorResponse(c, errcode.Wrap(errcode.InvalidRequest, err))
+	}
+	if err := s.provisioner.SetSessions(c.Context(), req.Sessions); err != nil {
+		return errorResponse(c, err)
+	}
+	return s.scheduleHandler(c)
+}
+
+// exportStateHandler returns the pool, allocations and tracked users for
+// another service to import
+func (s *Server) exportStateHandler(c fiber.Ctx) error {
+	return c.JSON(s.provisioner.ExportState())
+}
+
+// importStateHandler replaces the pool, allocations and tracked users with
+// an export's
+func (s *Server) importStateHandler(c fiber.Ctx) error {
+	export, err := handoff.DecodeExport(c.Body())
+	if err != nil {
+		return errorResponse(c, errcode.Wrap(errcode.InvalidRequest, err))
+	}
+	result, err := s.provisioner.ImportState(c.Context(), export)
+	if err != nil {
+		return errorResponse(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"schema_version":  export.SchemaVersion,
+		"nodes":           result.Nodes,
+		"users":           result.Users,
+		"connected_users": result.Connected,
+		"replaced_nodes":  result.Replaced,
+	})
+}
+
+// errorStatus maps error codes to HTTP statuses; other codes are a 500
+var errorStatus = map[errcode.Code]int{
+	errcode.NoCapacity:          fiber.StatusConflict,
+	errcode.AlreadyAllocated:    fiber.StatusConflict,
+	errcode.InvalidTransition:   fiber.StatusConflict,
+	errcode.BudgetExceeded:      fiber.StatusConflict,
+	errcode.SessionLimit:        fiber.StatusConflict,
+	errcode.DryRun:              fiber.StatusConflict,
+	errcode.NotFound:            fiber.StatusNotFound,
+	errcode.InvalidRequest:      fiber.StatusBadRequest,
+	errcode.Unauthorized:        fiber.StatusUnauthorized,
+	errcode.AccessDenied:        fiber.StatusForbidden,
+	errcode.RateLimited:         fiber.StatusTooManyRequests,
+	errcode.ProviderUnavailable: fiber.StatusBadGateway,
+	errcode.NotLeader:           fiber.StatusServiceUnavailable,
+	errcode.Draining:            fiber.StatusServiceUnavailable,
+}
+
+// param returns a path parameter copied out of the request buffer, which
+// fasthttp reuses once the handler returns, for values the service keeps:
+// user IDs held by the node pool, the access lists and pending migrations,
+// and IDs recorded in the journal
+func param(c fiber.Ctx, name string) string {
+	return strings.Clone(c.Params(name))
+}
+
+// errorResponse writes an error and its code, with the status the code maps to
+func errorResponse(c fiber.Ctx, err error) error {
+	code := errcode.Of(err)
+	status, ok := errorStatus[code]
+	if !ok {
+		status = fiber.StatusInternalServerError
+	}
+	return c.Status(status).JSON(fiber.Map{
+		"error": err.Error(),
+		"code":  code,
+	})
+}
+
+// reservedFor returns the user holding an active reservation on n, if any
+func reservedFor(n *node.Node) string {
+	if !n.IsReserved() {
+		return ""
+	}
+	return n.ReservedFor
+}
+
+// utilization returns a node's latest utilization sample, or nil if it
+// reports none
+func utilization(n *node.Node) fiber.Map {
+	u := n.Utilization
+	if u.ReportedAt.IsZero() {
+		return nil
+	}
+	return fiber.Map{
+		"gpu_percent":    u.GPUPercent,
+		"memory_percent": u.MemoryPercent,
+		"reported_at":    u.ReportedAt.Unix(),
+	}
+}
+
+// unixOrZero returns the Unix timestamp of t, or 0 if t is unset
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
 // Start starts the HTTP server
 func (s *Server) Start() error {
 	addr := fmt.Sprintf(":%d", s.port)
@@ -110,5 +1007,7 @@ func (s *Server) Start() error {
 // Shutdown gracefully shuts down the HTTP server
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.logger.Info("shutting down HTTP server")
+	// Feed streams run on hijacked connections the server does not wait for
+	s.feed.Close()
 	return s.app.ShutdownWithContext(ctx)
 }
@@ -0,0 +1,42 @@
+package http
+
+import (
+	"net/http/httptest"
+	"testing"
+	"unsafe"
+
+	"github.com/aos-cc/provisioning-service/internal/infra/metrics"
+	"github.com/gofiber/fiber/v3"
+	"go.uber.org/zap"
+)
+
+func TestParamIsCopied(t *testing.T) {
+	app := fiber.New()
+	var kept string
+	app.Get("/users/:id", func(c fiber.Ctx) error {
+		kept = param(c, "id")
+		if unsafe.StringData(kept) == unsafe.StringData(c.Params("id")) {
+			t.Error("param shares the request buffer")
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	})
+
+	if _, err := app.Test(httptest.NewRequest("GET", "/users/u-1", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if kept != "u-1" {
+		t.Errorf("param = %q, want u-1", kept)
+	}
+}
+
+func TestPredictionsNeedAdmin(t *testing.T) {
+	s := NewServer(0, "secret", zap.NewNop(), zap.NewAtomicLevel(), nil, nil, nil, nil, nil, nil, nil, nil, metrics.NewPrometheus(), "", "")
+
+	resp, err := s.app.Test(httptest.NewRequest("GET", "/metrics/predictions", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusUnauthorized {
+		t.Errorf("status = %d without a token, want 401", resp.StatusCode)
+	}
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/gofiber/fiber/v3"
+	"go.uber.org/zap"
+)
+
+// Webhook signature headers. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" under the shared secret, prefixed with "sha256=".
+const (
+	HeaderWebhookTimestamp = "X-Webhook-Timestamp"
+	HeaderWebhookSignature = "X-Webhook-Signature"
+)
+
+// EnableNodeStatusWebhook adds POST /webhooks/node-status, through which the
+// Node API or a cloud provider reports node state changes directly, so a
+// missed node:status message does not leave a node in the wrong state. The
+// body is a node:status event, handled exactly as if it arrived on the
+// transport. Requests must be signed with secret and are rejected when
+// their timestamp is more than tolerance away from now, so a captured
+// request cannot be replayed later.
+func (s *Server) EnableNodeStatusWebhook(handler events.Handler, secret string, tolerance time.Duration) {
+	s.app.Post("/webhooks/node-status", s.webhookAuth(secret, tolerance), s.requireLeader, s.ingest(handler, events.Namespace{}, events.ChannelNodeStatus))
+}
+
+// webhookAuth verifies a webhook's signature and timestamp
+func (s *Server) webhookAuth(secret string, tolerance time.Duration) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		timestamp := c.Get(HeaderWebhookTimestamp)
+		sent, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return s.rejectWebhook(c, "missing or malformed timestamp")
+		}
+		if skew := time.Now().Unix() - sent; math.Abs(float64(skew)) > tolerance.Seconds() {
+			return s.rejectWebhook(c, "timestamp outside tolerance")
+		}
+
+		signature, ok := strings.CutPrefix(c.Get(HeaderWebhookSignature), "sha256=")
+		got, err := hex.DecodeString(signature)
+		if !ok || err != nil {
+			return s.rejectWebhook(c, "missing or malformed signature")
+		}
+
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp))
+		mac.Write([]byte("."))
+		mac.Write(c.Body())
+		if !hmac.Equal(got, mac.Sum(nil)) {
+			return s.rejectWebhook(c, "signature mismatch")
+		}
+
+		return c.Next()
+	}
+}
+
+func (s *Server) rejectWebhook(c fiber.Ctx, reason string) error {
+	s.logger.Warn("rejected webhook",
+		zap.String("path", c.Path()),
+		zap.String("reason", reason),
+	)
+	return errorResponse(c, errcode.New(errcode.Unauthorized, "invalid webhook signature"))
+}
@@ -0,0 +1,215 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/errcode"
+	"github.com/aos-cc/provisioning-service/internal/domain/feed"
+	"github.com/aos-cc/provisioning-service/internal/domain/rbac"
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v3"
+	"github.com/valyala/fasthttp"
+	"go.uber.org/zap"
+)
+
+const (
+	wsWriteTimeout   = 10 * time.Second
+	wsPingInterval   = 30 * time.Second
+	wsPongWait       = 2 * wsPingInterval
+	wsMaxMessageSize = 4096 // Filter updates are small
+)
+
+// wsAuth is adminAuth for WebSocket clients, which cannot set headers from
+// a browser and may pass the token as ?token= instead
+func (s *Server) wsAuth(c fiber.Ctx) error {
+	if token := c.Query("token"); token != "" && c.Get(fiber.HeaderAuthorization) == "" {
+		c.Request().Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	}
+	return s.adminAuth(c)
+}
+
+// wsHandler streams the operations feed over a WebSocket. The initial filter
+// is taken from ?types=, ?tenant_id= and ?node_id=; clients replace it at any
+// time by sending a JSON filter. A tenant's callers only receive their
+// tenant's events.
+func (s *Server) wsHandler(c fiber.Ctx) error {
+	if !websocket.FastHTTPIsWebSocketUpgrade(c.RequestCtx()) {
+		return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{"error": "websocket upgrade required", "code": errcode.InvalidRequest})
+	}
+
+	// The filter outlives the request, so it is copied out of its buffer
+	filter := feed.Filter{
+		TenantID: strings.Clone(c.Query("tenant_id")),
+		NodeID:   strings.Clone(c.Query("node_id")),
+	}
+	if types := strings.Clone(c.Query("types")); types != "" {
+		filter.Types = strings.Split(types, ",")
+	}
+	if err := validateFilter(filter); err != nil {
+		return errorResponse(c, errcode.Wrap(errcode.InvalidRequest, err))
+	}
+	tenantID := principalOf(c).TenantID
+	if err := pinTenant(&filter, tenantID); err != nil {
+		return errorResponse(c, err)
+	}
+
+	upgrader := websocket.FastHTTPUpgrader{
+		// The admin token guards the feed when set; otherwise only the
+		// service's own origin may open it from a browser
+		CheckOrigin: func(ctx *fasthttp.RequestCtx) bool {
+			return s.authRequired() || sameOrigin(ctx)
+		},
+	}
+
+	// The upgrader writes its own error response on failure
+	if err := upgrader.Upgrade(c.RequestCtx(), func(conn *websocket.Conn) {
+		s.streamFeed(conn, filter, tenantID)
+	}); err != nil {
+		s.logger.Debug("websocket upgrade failed", zap.Error(err))
+	}
+	return nil
+}
+
+// streamFeed writes feed events to conn until the client goes away or the
+// feed shuts down
+func (s *Server) streamFeed(conn *websocket.Conn, filter feed.Filter, tenantID string) {
+	defer conn.Close()
+
+	sub := s.feed.Subscribe(filter)
+	defer sub.Close()
+
+	s.logger.Info("feed client connected",
+		zap.String("remote_addr", conn.RemoteAddr().String()),
+	)
+
+	replies := make(chan fiber.Map, 1)
+	readerDone := make(chan struct{})
+	writerDone := make(chan struct{})
+	defer close(writerDone)
+
+	go func() {
+		defer close(readerDone)
+		s.readFilters(conn, sub, tenantID, replies, writerDone)
+	}()
+
+	if err := writeJSON(conn, fiber.Map{"type": "subscribed", "filter": filter}); err != nil {
+		return
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case e, ok := <-sub.Events():
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "shutting down"),
+					time.Now().Add(wsWriteTimeout))
+				return
+			}
+			err = writeJSON(conn, e)
+		case reply := <-replies:
+			err = writeJSON(conn, reply)
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		case <-readerDone:
+			s.logger.Info("feed client disconnected",
+				zap.String("remote_addr", conn.RemoteAddr().String()),
+				zap.Int64("dropped_events", sub.Dropped()),
+			)
+			return
+		}
+		if err != nil {
+			s.logger.Debug("feed write failed", zap.Error(err))
+			return
+		}
+	}
+}
+
+// readFilters applies filter updates sent by the client, replying through
+// replies since only the writer may write to conn. Filters stay pinned to
+// the caller's tenant, if any.
+func (s *Server) readFilters(conn *websocket.Conn, sub *feed.Subscription, tenantID string, replies chan<- fiber.Map, writerDone <-chan struct{}) {
+	conn.SetReadLimit(wsMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var filter feed.Filter
+		reply := fiber.Map{"type": "subscribed"}
+		if err := json.Unmarshal(data, &filter); err != nil {
+			reply = fiber.Map{"type": "error", "error": "invalid filter: " + err.Error(), "code": errcode.InvalidRequest}
+		} else if err := validateFilter(filter); err != nil {
+			reply = fiber.Map{"type": "error", "error": err.Error(), "code": errcode.InvalidRequest}
+		} else if err := pinTenant(&filter, tenantID); err != nil {
+			reply = fiber.Map{"type": "error", "error": err.Error(), "code": errcode.Of(err)}
+		} else {
+			sub.SetFilter(filter)
+			reply["filter"] = filter
+		}
+
+		select {
+		case replies <- reply:
+		case <-writerDone:
+			return
+		}
+	}
+}
+
+// validateFilter rejects filters on unknown event types
+func validateFilter(f feed.Filter) error {
+	for _, t := range f.Types {
+		switch t {
+		case feed.TypeScalingDecision, feed.TypeAllocation, feed.TypeNodeTransition, feed.TypeBootFailure,
+			feed.TypeLatencyBreach, feed.TypeEscalation, feed.TypeIdleWarning:
+		default:
+			return fmt.Errorf("unknown event type %q", t)
+		}
+	}
+	return nil
+}
+
+// pinTenant limits a filter to a tenant's events, refusing another tenant's;
+// an empty tenant leaves the filter as it is
+func pinTenant(f *feed.Filter, tenantID string) error {
+	if tenantID == "" {
+		return nil
+	}
+	if f.TenantID != "" && f.TenantID != tenantID {
+		return rbac.ErrOutsideTenant
+	}
+	f.TenantID = tenantID
+	return nil
+}
+
+func writeJSON(conn *websocket.Conn, v any) error {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteJSON(v)
+}
+
+// sameOrigin reports whether a request has no Origin header or one matching
+// its Host
+func sameOrigin(ctx *fasthttp.RequestCtx) bool {
+	origin := string(ctx.Request.Header.Peek(fiber.HeaderOrigin))
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, string(ctx.Host()))
+}
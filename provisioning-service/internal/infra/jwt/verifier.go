@@ -0,0 +1,208 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/rbac"
+)
+
+// ErrInvalidToken is returned for tokens that are malformed, badly signed,
+// expired or issued for someone else
+var ErrInvalidToken = errors.New("invalid token")
+
+// Config configures how bearer tokens are verified and mapped to roles
+type Config struct {
+	Secret        string        // Verifies HS256 tokens; empty rejects them
+	PublicKeyFile string        // PEM RSA public key verifying RS256 tokens; empty rejects them
+	Issuer        string        // Required iss claim; empty accepts any
+	Audience      string        // Required aud claim; empty accepts any
+	RoleClaim     string        // Claim naming the role, as a string or a list
+	TenantClaim   string        // Claim naming the tenant a caller is limited to
+	Leeway        time.Duration // Clock skew allowed on exp and nbf
+}
+
+// Verifier verifies signed JWTs and maps their claims to a principal
+type Verifier struct {
+	config    Config
+	publicKey *rsa.PublicKey
+}
+
+// NewVerifier creates a verifier, reading the public key file if one is configured
+func NewVerifier(config Config) (*Verifier, error) {
+	v := &Verifier{config: config}
+	if config.PublicKeyFile == "" {
+		return v, nil
+	}
+
+	data, err := os.ReadFile(config.PublicKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("public key %s is not PEM encoded", config.PublicKeyFile)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is not an RSA key", config.PublicKeyFile)
+	}
+	v.publicKey = rsaKey
+	return v, nil
+}
+
+// header is the part of a JOSE header the verifier reads
+type header struct {
+	Alg string `json:"alg"`
+}
+
+// Verify checks a token's signature and registered claims and returns the
+// principal it was issued to. A token without a known role is valid but
+// grants nothing.
+func (v *Verifier) Verify(token string, now time.Time) (rbac.Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return rbac.Principal{}, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return rbac.Principal{}, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return rbac.Principal{}, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+	if err := v.verifySignature(h.Alg, parts[0]+"."+parts[1], signature); err != nil {
+		return rbac.Principal{}, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return rbac.Principal{}, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := v.checkClaims(claims, now); err != nil {
+		return rbac.Principal{}, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	tenantID, _ := claims[v.config.TenantClaim].(string)
+	return rbac.Principal{
+		Subject:  subject,
+		Role:     roleOf(claims[v.config.RoleClaim]),
+		TenantID: tenantID,
+	}, nil
+}
+
+// verifySignature checks the signature with the key for the token's
+// algorithm; algorithms without a configured key are rejected
+func (v *Verifier) verifySignature(alg, signed string, signature []byte) error {
+	switch {
+	case alg == "HS256" && v.config.Secret != "":
+		mac := hmac.New(sha256.New, []byte(v.config.Secret))
+		mac.Write([]byte(signed))
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+	case alg == "RS256" && v.publicKey != nil:
+		digest := sha256.Sum256([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(v.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("%w: signature mismatch", ErrInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, alg)
+	}
+	return nil
+}
+
+// checkClaims requires an unexpired token and checks nbf, iss and aud
+func (v *Verifier) checkClaims(claims map[string]any, now time.Time) error {
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if !now.Before(exp.Add(v.config.Leeway)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(v.config.Leeway).Before(nbf) {
+		return fmt.Errorf("%w: not yet valid", ErrInvalidToken)
+	}
+
+	if v.config.Issuer != "" && claims["iss"] != v.config.Issuer {
+		return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+	}
+	if v.config.Audience != "" && !slices.Contains(stringList(claims["aud"]), v.config.Audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	return nil
+}
+
+// roleOf returns the highest known role named by a role claim
+func roleOf(claim any) rbac.Role {
+	role := rbac.RoleNone
+	for _, name := range stringList(claim) {
+		if r, ok := rbac.ParseRole(name); ok && r > role {
+			role = r
+		}
+	}
+	return role
+}
+
+// stringList reads a claim that is a string or a list of strings
+func stringList(claim any) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		list := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	default:
+		return nil
+	}
+}
+
+// numericDate reads a claim holding seconds since the epoch
+func numericDate(claim any) (time.Time, bool) {
+	n, ok := claim.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(seconds*float64(time.Second))), true
+}
+
+// decodeSegment decodes a base64url JSON segment, keeping numbers exact
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
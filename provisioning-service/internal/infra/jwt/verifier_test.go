@@ -0,0 +1,203 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/rbac"
+)
+
+const secret = "test-secret"
+
+var now = time.Unix(1_700_000_000, 0)
+
+func segment(t *testing.T, v any) string {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// hs256 signs claims with the secret
+func hs256(t *testing.T, key string, claims map[string]any) string {
+	signed := segment(t, map[string]string{"alg": "HS256", "typ": "JWT"}) + "." + segment(t, claims)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func claims(extra map[string]any) map[string]any {
+	c := map[string]any{"sub": "alice", "exp": now.Add(time.Hour).Unix(), "role": "operator"}
+	for k, v := range extra {
+		c[k] = v
+	}
+	return c
+}
+
+func TestVerifyHS256(t *testing.T) {
+	config := Config{Secret: secret, Issuer: "idp", Audience: "provisioning", RoleClaim: "role", TenantClaim: "tenant", Leeway: time.Minute}
+
+	tests := []struct {
+		name  string
+		token string
+		want  rbac.Principal
+		fails bool
+	}{
+		{
+			name:  "valid",
+			token: hs256(t, secret, claims(map[string]any{"iss": "idp", "aud": "provisioning"})),
+			want:  rbac.Principal{Subject: "alice", Role: rbac.RoleOperator},
+		},
+		{
+			name:  "tenant",
+			token: hs256(t, secret, claims(map[string]any{"iss": "idp", "aud": "provisioning", "tenant": "acme"})),
+			want:  rbac.Principal{Subject: "alice", Role: rbac.RoleOperator, TenantID: "acme"},
+		},
+		{
+			name:  "highest of several roles",
+			token: hs256(t, secret, claims(map[string]any{"iss": "idp", "aud": []string{"other", "provisioning"}, "role": []string{"viewer", "admin", "root"}})),
+			want:  rbac.Principal{Subject: "alice", Role: rbac.RoleAdmin},
+		},
+		{
+			name:  "unknown role grants nothing",
+			token: hs256(t, secret, claims(map[string]any{"iss": "idp", "aud": "provisioning", "role": "root"})),
+			want:  rbac.Principal{Subject: "alice", Role: rbac.RoleNone},
+		},
+		{
+			name:  "expired within leeway",
+			token: hs256(t, secret, claims(map[string]any{"iss": "idp", "aud": "provisioning", "exp": now.Add(-30 * time.Second).Unix()})),
+			want:  rbac.Principal{Subject: "alice", Role: rbac.RoleOperator},
+		},
+		{
+			name:  "expired",
+			token: hs256(t, secret, claims(map[string]any{"iss": "idp", "aud": "provisioning", "exp": now.Add(-2 * time.Minute).Unix()})),
+			fails: true,
+		},
+		{
+			name:  "missing exp",
+			token: hs256(t, secret, map[string]any{"sub": "alice", "iss": "idp", "aud": "provisioning", "role": "admin"}),
+			fails: true,
+		},
+		{
+			name:  "not yet valid",
+			token: hs256(t, secret, claims(map[string]any{"iss": "idp", "aud": "provisioning", "nbf": now.Add(2 * time.Minute).Unix()})),
+			fails: true,
+		},
+		{
+			name:  "wrong issuer",
+			token: hs256(t, secret, claims(map[string]any{"iss": "other", "aud": "provisioning"})),
+			fails: true,
+		},
+		{
+			name:  "wrong audience",
+			token: hs256(t, secret, claims(map[string]any{"iss": "idp", "aud": []string{"other"}})),
+			fails: true,
+		},
+		{
+			name:  "wrong secret",
+			token: hs256(t, "other-secret", claims(map[string]any{"iss": "idp", "aud": "provisioning"})),
+			fails: true,
+		},
+		{
+			name:  "unsigned",
+			token: segment(t, map[string]string{"alg": "none"}) + "." + segment(t, claims(map[string]any{"iss": "idp", "aud": "provisioning"})) + ".",
+			fails: true,
+		},
+		{
+			name:  "not a JWT",
+			token: "admin-token",
+			fails: true,
+		},
+	}
+
+	v, err := NewVerifier(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := v.Verify(tt.token, now)
+			if tt.fails {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("err = %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("principal = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyRS256(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	keyFile := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(keyFile, pemData, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewVerifier(Config{PublicKeyFile: keyFile, RoleClaim: "role"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := segment(t, map[string]string{"alg": "RS256"}) + "." + segment(t, claims(nil))
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := v.Verify(signed+"."+base64.RawURLEncoding.EncodeToString(signature), now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (rbac.Principal{Subject: "alice", Role: rbac.RoleOperator}); got != want {
+		t.Errorf("principal = %+v, want %+v", got, want)
+	}
+
+	// An HS256 token keyed with the public key must not pass for RS256
+	if _, err := v.Verify(hs256(t, string(pemData), claims(nil)), now); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("HS256 token accepted without a secret: %v", err)
+	}
+}
+
+func TestNewVerifierRejectsBadKeys(t *testing.T) {
+	dir := t.TempDir()
+	notPEM := filepath.Join(dir, "key.txt")
+	if err := os.WriteFile(notPEM, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range []string{filepath.Join(dir, "missing.pem"), notPEM} {
+		if _, err := NewVerifier(Config{PublicKeyFile: file}); err == nil {
+			t.Errorf("NewVerifier(%s) succeeded", file)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/session"
+	"github.com/segmentio/kafka-go"
+)
+
+// SessionSink exports session records to a Kafka topic, keyed by user ID so a
+// user's sessions stay ordered within a partition
+type SessionSink struct {
+	writer *kafka.Writer
+}
+
+// NewSessionSink creates a sink writing to topic on the given brokers
+func NewSessionSink(brokers []string, topic string) *SessionSink {
+	return &SessionSink{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		},
+	}
+}
+
+// Export writes records as JSON messages
+func (s *SessionSink) Export(ctx context.Context, records []session.Record) error {
+	messages := make([]kafka.Message, 0, len(records))
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal session record: %w", err)
+		}
+		messages = append(messages, kafka.Message{
+			Key:   []byte(record.UserID),
+			Value: data,
+		})
+	}
+
+	return s.writer.WriteMessages(ctx, messages...)
+}
+
+// Close flushes and closes the writer
+func (s *SessionSink) Close() error {
+	return s.writer.Close()
+}
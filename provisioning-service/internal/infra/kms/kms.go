@@ -0,0 +1,46 @@
+// Package kms decrypts data keys wrapped by AWS KMS, so event sealing keys
+// need not be kept in plain text in the configuration
+package kms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// Decrypter calls the KMS Decrypt API with the default AWS credential chain.
+// The SDK resolves the endpoint, so partitions other than aws and FIPS
+// endpoints (AWS_USE_FIPS_ENDPOINT) work as configured for the environment.
+type Decrypter struct {
+	client *kms.Client
+}
+
+// NewDecrypter creates a decrypter for a region; empty uses the
+// environment's
+func NewDecrypter(ctx context.Context, region string) (*Decrypter, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("no AWS region configured for KMS")
+	}
+	return &Decrypter{client: kms.NewFromConfig(cfg)}, nil
+}
+
+// Decrypt returns the plain text of a ciphertext blob KMS encrypted, such
+// as a data key from GenerateDataKey
+func (d *Decrypter) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	out, err := d.client.Decrypt(ctx, &kms.DecryptInput{CiphertextBlob: ciphertext})
+	if err != nil {
+		return nil, fmt.Errorf("KMS decrypt failed: %w", err)
+	}
+	return out.Plaintext, nil
+}
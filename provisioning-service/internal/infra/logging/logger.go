@@ -0,0 +1,91 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Config describes how logs are encoded and where they are written
+type Config struct {
+	Level              string // debug|info|warn|error
+	Format             string // json|console
+	Sampling           bool
+	SamplingInitial    int // Entries per second logged for each message before sampling
+	SamplingThereafter int // Every Nth entry logged after that
+	File               string
+	LokiURL            string
+	LokiLabels         map[string]string
+	LokiBatchWait      time.Duration
+}
+
+// New builds a logger writing to stderr and any configured sinks. The returned
+// level changes verbosity at runtime, and stop flushes and closes the sinks.
+func New(cfg Config) (logger *zap.Logger, level zap.AtomicLevel, stop func(), err error) {
+	level = zap.NewAtomicLevel()
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, level, nil, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+		}
+	}
+
+	encoder, err := newEncoder(cfg.Format)
+	if err != nil {
+		return nil, level, nil, err
+	}
+
+	cores := []zapcore.Core{zapcore.NewCore(encoder, zapcore.Lock(os.Stderr), level)}
+	var closers []func()
+
+	if cfg.File != "" {
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, level, nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		cores = append(cores, zapcore.NewCore(encoder.Clone(), zapcore.Lock(f), level))
+		closers = append(closers, func() { f.Close() })
+	}
+
+	if cfg.LokiURL != "" {
+		loki := newLokiWriter(cfg.LokiURL, cfg.LokiLabels, cfg.LokiBatchWait)
+		// Loki always receives JSON so fields stay queryable
+		cores = append(cores, zapcore.NewCore(zapcore.NewJSONEncoder(encoderConfig()), loki, level))
+		closers = append(closers, loki.Stop)
+	}
+
+	core := zapcore.NewTee(cores...)
+	if cfg.Sampling {
+		core = zapcore.NewSamplerWithOptions(core, time.Second, cfg.SamplingInitial, cfg.SamplingThereafter)
+	}
+
+	logger = zap.New(core, zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
+	stop = func() {
+		_ = logger.Sync()
+		for _, closeSink := range closers {
+			closeSink()
+		}
+	}
+	return logger, level, stop, nil
+}
+
+func newEncoder(format string) (zapcore.Encoder, error) {
+	switch format {
+	case "", "json":
+		return zapcore.NewJSONEncoder(encoderConfig()), nil
+	case "console":
+		cfg := zap.NewDevelopmentEncoderConfig()
+		cfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		return zapcore.NewConsoleEncoder(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+func encoderConfig() zapcore.EncoderConfig {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	return cfg
+}
@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"resty.dev/v3"
+)
+
+// maxLokiBuffered bounds the entries held while Loki is unreachable
+const maxLokiBuffered = 10000
+
+// lokiWriter batches log lines and pushes them to Loki's push API. Push
+// failures are reported on stderr, since logging them would feed them back
+// into the same writer.
+type lokiWriter struct {
+	url    string
+	labels map[string]string
+	resty  *resty.Client
+
+	mu      sync.Mutex
+	entries [][2]string // [unix nanos, line]
+
+	stopOnce sync.Once
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func newLokiWriter(baseURL string, labels map[string]string, batchWait time.Duration) *lokiWriter {
+	if len(labels) == 0 {
+		labels = map[string]string{"service": "provisioning-service"}
+	}
+	if batchWait <= 0 {
+		batchWait = time.Second
+	}
+
+	w := &lokiWriter{
+		url:    baseURL + "/loki/api/v1/push",
+		labels: labels,
+		resty: resty.New().
+			SetTimeout(5*time.Second).
+			SetHeader("Content-Type", "application/json"),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.run(batchWait)
+	return w
+}
+
+// Write buffers a single encoded entry
+func (w *lokiWriter) Write(p []byte) (int, error) {
+	line := string(bytes.TrimRight(p, "\n"))
+	ts := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	w.mu.Lock()
+	w.entries = append(w.entries, [2]string{ts, line})
+	if over := len(w.entries) - maxLokiBuffered; over > 0 {
+		w.entries = w.entries[over:]
+	}
+	w.mu.Unlock()
+
+	return len(p), nil
+}
+
+// Sync pushes buffered entries
+func (w *lokiWriter) Sync() error {
+	return w.push()
+}
+
+// Stop pushes remaining entries and stops the background flush
+func (w *lokiWriter) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+		<-w.stopped
+	})
+}
+
+func (w *lokiWriter) run(interval time.Duration) {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			w.report(w.push())
+			return
+		case <-ticker.C:
+			w.report(w.push())
+		}
+	}
+}
+
+func (w *lokiWriter) push() error {
+	w.mu.Lock()
+	batch := w.entries
+	w.entries = nil
+	w.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+
+	resp, err := w.resty.R().
+		SetBody(lokiPush{Streams: []lokiStream{{Stream: w.labels, Values: batch}}}).
+		Post(w.url)
+	if err != nil {
+		return fmt.Errorf("failed to push %d log entries to loki: %w", len(batch), err)
+	}
+	if resp.IsError() {
+		return fmt.Errorf("failed to push %d log entries to loki: status %d", len(batch), resp.StatusCode())
+	}
+	return nil
+}
+
+func (w *lokiWriter) report(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
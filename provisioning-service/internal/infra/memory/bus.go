@@ -0,0 +1,109 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// subscriberBuffer bounds the messages queued for a subscriber. Publishing
+// never blocks, since handlers publish onto the bus themselves.
+const subscriberBuffer = 1024
+
+// deadLetterMaxLen bounds the rejected payloads the bus keeps, as the Redis
+// dead-letter list is capped
+const deadLetterMaxLen = 1000
+
+// Message is a message published on the bus
+type Message struct {
+	Channel string
+	Payload string
+}
+
+// DeadLetter is a rejected payload kept by the bus
+type DeadLetter struct {
+	Channel    string
+	Payload    string
+	Reason     string
+	ReceivedAt time.Time
+}
+
+// Bus is an in-process pub/sub bus that stands in for Redis in dev mode.
+// Like Redis pub/sub, messages on channels without subscribers are dropped.
+type Bus struct {
+	mu          sync.RWMutex
+	subs        map[string][]chan Message
+	deadLetters []DeadLetter // Newest first
+}
+
+// NewBus creates a new in-process bus
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[string][]chan Message),
+	}
+}
+
+// Publish delivers a message to every subscriber of a channel
+func (b *Bus) Publish(ctx context.Context, channel, message string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, ch := range b.subs[channel] {
+		select {
+		case ch <- Message{Channel: channel, Payload: message}:
+		default:
+			return fmt.Errorf("subscriber of %s is full", channel)
+		}
+	}
+	return nil
+}
+
+// PushDeadLetter keeps a rejected payload, forgetting the oldest beyond
+// the cap
+func (b *Bus) PushDeadLetter(letter DeadLetter) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.deadLetters = append([]DeadLetter{letter}, b.deadLetters...)
+	if len(b.deadLetters) > deadLetterMaxLen {
+		b.deadLetters = b.deadLetters[:deadLetterMaxLen]
+	}
+}
+
+// DeadLetters returns the rejected payloads kept, newest first
+func (b *Bus) DeadLetters() []DeadLetter {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return slices.Clone(b.deadLetters)
+}
+
+// Subscribe returns a channel receiving messages published on the given
+// channels, and a function that ends the subscription
+func (b *Bus) Subscribe(channels ...string) (<-chan Message, func()) {
+	ch := make(chan Message, subscriberBuffer)
+
+	b.mu.Lock()
+	for _, channel := range channels {
+		b.subs[channel] = append(b.subs[channel], ch)
+	}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			for _, channel := range channels {
+				subs := b.subs[channel]
+				for i, sub := range subs {
+					if sub == ch {
+						b.subs[channel] = append(subs[:i:i], subs[i+1:]...)
+						break
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"go.uber.org/zap"
+)
+
+// Subscriber consumes inbound events from the in-process bus
+type Subscriber struct {
+	bus     *Bus
+	handler events.Handler
+	guard   events.Dispatcher
+	logger  *zap.Logger
+
+	subscribed atomic.Bool
+	backlog    atomic.Int64
+}
+
+// NewSubscriber creates a new in-process subscriber
+func NewSubscriber(bus *Bus, handler events.Handler, guard events.Dispatcher, logger *zap.Logger) *Subscriber {
+	return &Subscriber{
+		bus:     bus,
+		handler: handler,
+		guard:   guard,
+		logger:  logger,
+	}
+}
+
+// Start consumes events until ctx is cancelled
+func (s *Subscriber) Start(ctx context.Context) error {
+	channels := events.InboundChannels()
+	msgs, unsubscribe := s.bus.Subscribe(channels...)
+	defer unsubscribe()
+
+	s.subscribed.Store(true)
+	defer s.subscribed.Store(false)
+	s.logger.Info("subscribed to in-process channels", zap.Strings("channels", channels))
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("subscriber stopping")
+			return ctx.Err()
+		case msg := <-msgs:
+			s.backlog.Store(int64(len(msgs)))
+			// Handlers run to completion even if shutdown begins mid-message
+			s.handleMessage(context.WithoutCancel(ctx), msg)
+		}
+	}
+}
+
+// Subscribed reports whether the subscriber is consuming events
+func (s *Subscriber) Subscribed() bool {
+	return s.subscribed.Load()
+}
+
+// Lag returns how many published messages were waiting to be handled when
+// the last one was taken
+func (s *Subscriber) Lag() int64 {
+	return s.backlog.Load()
+}
+
+// Reconnects always returns 0; the in-process subscription cannot be lost
+func (s *Subscriber) Reconnects() int64 {
+	return 0
+}
+
+func (s *Subscriber) handleMessage(ctx context.Context, msg Message) {
+	s.logger.Debug("received message",
+		zap.String("channel", msg.Channel),
+		zap.String("payload", msg.Payload),
+	)
+
+	err := s.guard.Dispatch(ctx, s.handler, msg.Channel, []byte(msg.Payload))
+
+	var poisonErr *events.PoisonError
+	var decodeErr *events.DecodeError
+	switch {
+	case err == nil:
+	case errors.As(err, &poisonErr):
+		if !poisonErr.Repeat {
+			s.rejectMessage(msg, poisonErr)
+		}
+	case errors.As(err, &decodeErr):
+		s.rejectMessage(msg, decodeErr.Err)
+	case errors.Is(err, events.ErrUnknownChannel):
+		s.logger.Warn("unknown channel", zap.String("channel", msg.Channel))
+	default:
+		s.logger.Error("failed to handle message",
+			zap.String("channel", msg.Channel),
+			zap.Error(err),
+		)
+	}
+}
+
+// rejectMessage moves an invalid or poison payload to the bus's dead-letter
+// list
+func (s *Subscriber) rejectMessage(msg Message, reason error) {
+	s.logger.Warn("rejecting event",
+		zap.String("channel", msg.Channel),
+		zap.Error(reason),
+	)
+	s.bus.PushDeadLetter(DeadLetter{
+		Channel:    msg.Channel,
+		Payload:    msg.Payload,
+		Reason:     reason.Error(),
+		ReceivedAt: time.Now(),
+	})
+}
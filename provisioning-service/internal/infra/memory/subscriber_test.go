@@ -0,0 +1,51 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"go.uber.org/zap"
+)
+
+type nopObserver struct{}
+
+func (nopObserver) ObserveMessage(channel, outcome string, d time.Duration) {}
+
+func (nopObserver) ObserveConsecutiveFailures(channel string, failures int) {}
+
+func (nopObserver) ObservePoisonMessage(channel string, repeat bool) {}
+
+func TestSubscriberDeadLettersRejectedEvents(t *testing.T) {
+	bus := NewBus()
+	guard := events.NewPoisonGuard(2, time.Hour, nopObserver{}, nopObserver{})
+	s := NewSubscriber(bus, nil, guard, zap.NewNop())
+
+	// Invalid, then found poison, then dropped as a known poison copy
+	for i := 0; i < 3; i++ {
+		s.handleMessage(context.Background(), Message{Channel: events.ChannelUserConnect, Payload: "not json"})
+	}
+
+	letters := bus.DeadLetters()
+	if len(letters) != 2 {
+		t.Fatalf("dead-lettered %d payloads, want 2", len(letters))
+	}
+	if !strings.HasPrefix(letters[0].Reason, "poison") {
+		t.Errorf("newest reason = %q, want a poison one", letters[0].Reason)
+	}
+	if letters[1].Channel != events.ChannelUserConnect || letters[1].Payload != "not json" {
+		t.Errorf("oldest dead letter = %+v", letters[1])
+	}
+}
+
+func TestBusCapsDeadLetters(t *testing.T) {
+	bus := NewBus()
+	for i := 0; i < deadLetterMaxLen+1; i++ {
+		bus.PushDeadLetter(DeadLetter{Channel: "c"})
+	}
+	if got := len(bus.DeadLetters()); got != deadLetterMaxLen {
+		t.Fatalf("kept %d dead letters, want %d", got, deadLetterMaxLen)
+	}
+}
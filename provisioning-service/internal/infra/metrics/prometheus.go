@@ -0,0 +1,557 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/accuracy"
+	"github.com/aos-cc/provisioning-service/internal/domain/budget"
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/aos-cc/provisioning-service/internal/domain/purchasing"
+	"github.com/aos-cc/provisioning-service/internal/domain/schedule"
+	"github.com/aos-cc/provisioning-service/internal/domain/service"
+	"github.com/aos-cc/provisioning-service/internal/domain/slo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// BootFailureSource reports the current run of boot failures
+type BootFailureSource interface {
+	BootFailureState() service.BootFailureState
+}
+
+// LeaderSource reports whether this replica leads
+type LeaderSource interface {
+	IsLeader() bool
+}
+
+// Prometheus holds the service's Prometheus collectors
+type Prometheus struct {
+	registry    *prometheus.Registry
+	connects    *prometheus.CounterVec
+	waitSeconds prometheus.Histogram
+	abandoned   *prometheus.HistogramVec
+	violations  *prometheus.CounterVec
+	drifts      *prometheus.GaugeVec
+	bootFails   *prometheus.CounterVec
+	chaosFaults *prometheus.CounterVec
+	budgetBlock *prometheus.CounterVec
+	accessDeny  *prometheus.CounterVec
+	throttled   *prometheus.CounterVec
+	purchases   *prometheus.CounterVec
+	predictions *prometheus.CounterVec
+	fallbacks   *prometheus.CounterVec
+	capacity    *prometheus.CounterVec
+	bootTimes   *prometheus.HistogramVec
+	received    *prometheus.CounterVec
+	decodeFails *prometheus.CounterVec
+	handleFails *prometheus.CounterVec
+	handleTimes *prometheus.HistogramVec
+	failStreaks *prometheus.GaugeVec
+	poison      *prometheus.CounterVec
+	sealRejects *prometheus.CounterVec
+	evictions   *prometheus.CounterVec
+	pluginCalls *prometheus.CounterVec
+	breaches    *prometheus.CounterVec
+	escalations *prometheus.CounterVec
+	idleReclaim *prometheus.CounterVec
+	schedules   *prometheus.CounterVec
+	terminated  *prometheus.CounterVec
+	deferred    *prometheus.CounterVec
+	purged      prometheus.Counter
+	pluginTimes prometheus.Histogram
+}
+
+// NewPrometheus creates a registry with the service collectors registered
+func NewPrometheus() *Prometheus {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	p := &Prometheus{
+		registry: registry,
+		connects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_connects_total",
+			Help: "Connect requests by whether a warm node was available.",
+		}, []string{"start"}),
+		waitSeconds: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "provisioning_cold_start_wait_seconds",
+			Help:    "Time users without a warm node waited until they were allocated one.",
+			Buckets: []float64{1, 2.5, 5, 10, 15, 20, 30, 45, 60, 90, 120, 300},
+		}),
+		abandoned: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "provisioning_queue_abandoned_wait_seconds",
+			Help:    "Time users without a warm node waited before leaving without one, by reason.",
+			Buckets: []float64{1, 2.5, 5, 10, 15, 20, 30, 45, 60, 90, 120, 300, 600},
+		}, []string{"reason"}),
+		violations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_invariant_violations_total",
+			Help: "Safety invariant violations by check; any increase should alert.",
+		}, []string{"check"}),
+		drifts: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "provisioning_consistency_drifts",
+			Help: "Mismatches between the pool and the user tracker left in place by the last consistency check, by check.",
+		}, []string{"check"}),
+		bootFails: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_boot_failures_total",
+			Help: "Nodes terminated without becoming ready, by instance type.",
+		}, []string{"instance_type"}),
+		chaosFaults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_chaos_faults_total",
+			Help: "Faults injected by the chaos injector, by fault.",
+		}, []string{"fault"}),
+		budgetBlock: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_budget_blocks_total",
+			Help: "Scale-ups cut short by the spend limits, by limit.",
+		}, []string{"limit"}),
+		accessDeny: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_access_denied_total",
+			Help: "Connect requests rejected by access control, by reason.",
+		}, []string{"reason"}),
+		throttled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_events_throttled_total",
+			Help: "User connects and disconnects over the user's rate limit, by event.",
+		}, []string{"event"}),
+		purchases: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_node_purchases_total",
+			Help: "Nodes provisioned with a chosen purchase option, by option and reason.",
+		}, []string{"option", "reason"}),
+		predictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_prediction_outcomes_total",
+			Help: "Resolved connect predictions and unpredicted connects, by outcome.",
+		}, []string{"outcome"}),
+		fallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_provider_fallbacks_total",
+			Help: "Node creations passed to the next provider, by the provider that had no capacity.",
+		}, []string{"provider"}),
+		capacity: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_provider_capacity_errors_total",
+			Help: "Node creations a provider had no capacity for, by provider, instance type and the zone out of capacity.",
+		}, []string{"provider", "instance_type", "zone"}),
+		bootTimes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "provisioning_boot_duration_seconds",
+			Help:    "Time nodes took from provisioning to ready, by instance type pool.",
+			Buckets: []float64{10, 20, 30, 45, 60, 90, 120, 180, 300, 600},
+		}, []string{"instance_type"}),
+		received: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_events_received_total",
+			Help: "Inbound messages taken off the event transport, by channel.",
+		}, []string{"channel"}),
+		decodeFails: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_event_decode_failures_total",
+			Help: "Inbound messages rejected as malformed, invalid or on an unknown channel, by channel.",
+		}, []string{"channel"}),
+		handleFails: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_event_handler_errors_total",
+			Help: "Inbound messages whose handler returned an error, by channel.",
+		}, []string{"channel"}),
+		failStreaks: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "provisioning_event_consecutive_failures",
+			Help: "Inbound messages rejected or failed in a row since the last handled one, by channel.",
+		}, []string{"channel"}),
+		poison: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_event_poison_messages_total",
+			Help: "Inbound messages found to be poison, by channel; action is dead_lettered the first time and dropped for later copies.",
+		}, []string{"channel", "action"}),
+		sealRejects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_event_seal_rejections_total",
+			Help: "Inbound messages rejected by events.encryption, by channel and reason: unsealed, unknown_key, stale or invalid.",
+		}, []string{"channel", "reason"}),
+		handleTimes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "provisioning_event_handler_duration_seconds",
+			Help:    "Time taken to decode and handle inbound messages, by channel.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		}, []string{"channel"}),
+		evictions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_user_evictions_total",
+			Help: "Disconnected users dropped by the user tracker, by reason (expired or capacity).",
+		}, []string{"reason"}),
+		pluginCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_predictor_plugin_requests_total",
+			Help: "Snapshots sent to the predictor plugin, by outcome (ok, error or timeout).",
+		}, []string{"outcome"}),
+		breaches: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_latency_budget_breaches_total",
+			Help: "Users who waited for a node past their tier's latency budget, by tier.",
+		}, []string{"tier"}),
+		escalations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_latency_escalations_total",
+			Help: "Escalation steps taken for users past their latency budget, by step and outcome (ok or failed).",
+		}, []string{"step", "outcome"}),
+		idleReclaim: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_idle_reclaims_total",
+			Help: "Idle nodes of connected users, by outcome (warned or reclaimed).",
+		}, []string{"outcome"}),
+		schedules: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_schedule_refreshes_total",
+			Help: "Calendar feed fetches, by outcome (ok or error).",
+		}, []string{"outcome"}),
+		terminated: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_node_terminations_total",
+			Help: "Nodes terminated, by reason and instance type.",
+		}, []string{"reason", "instance_type"}),
+		deferred: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "provisioning_node_terminations_deferred_total",
+			Help: "Node terminations left for a later tick by the per-tick limit, by reason; a node deferred over several ticks counts each time.",
+		}, []string{"reason"}),
+		purged: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "provisioning_terminated_nodes_purged_total",
+			Help: "Terminated nodes archived and dropped from the pool once past their retention.",
+		}),
+		pluginTimes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "provisioning_predictor_plugin_duration_seconds",
+			Help:    "Time the predictor plugin took to answer a snapshot, including timeouts.",
+			Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}),
+	}
+	registry.MustRegister(p.connects, p.waitSeconds, p.abandoned, p.violations, p.drifts, p.bootFails, p.chaosFaults, p.budgetBlock, p.accessDeny, p.throttled, p.purchases, p.predictions, p.fallbacks, p.capacity, p.bootTimes,
+		p.received, p.decodeFails, p.handleFails, p.handleTimes, p.failStreaks, p.poison, p.sealRejects, p.evictions, p.pluginCalls, p.pluginTimes,
+		p.breaches, p.escalations, p.idleReclaim, p.schedules, p.terminated, p.deferred, p.purged)
+
+	return p
+}
+
+// Register adds further collectors to the registry
+func (p *Prometheus) Register(cs ...prometheus.Collector) {
+	p.registry.MustRegister(cs...)
+}
+
+// Handler returns an http.Handler serving the registry in exposition format
+func (p *Prometheus) Handler() http.Handler {
+	return promhttp.HandlerFor(p.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveConnect implements slo.Observer
+func (p *Prometheus) ObserveConnect(warm bool) {
+	start := "cold"
+	if warm {
+		start = "warm"
+	}
+	p.connects.WithLabelValues(start).Inc()
+}
+
+// ObserveWait implements slo.Observer
+func (p *Prometheus) ObserveWait(wait time.Duration) {
+	p.waitSeconds.Observe(wait.Seconds())
+}
+
+// ObserveAbandon implements slo.Observer
+func (p *Prometheus) ObserveAbandon(reason string, wait time.Duration) {
+	p.abandoned.WithLabelValues(reason).Observe(wait.Seconds())
+}
+
+// ObserveViolation implements safety.Observer
+func (p *Prometheus) ObserveViolation(check string) {
+	p.violations.WithLabelValues(check).Inc()
+}
+
+// ObserveDrifts implements safety.Observer
+func (p *Prometheus) ObserveDrifts(check string, open int) {
+	p.drifts.WithLabelValues(check).Set(float64(open))
+}
+
+// ObserveBootFailure implements service.BootObserver
+func (p *Prometheus) ObserveBootFailure(instanceType string) {
+	p.bootFails.WithLabelValues(instanceType).Inc()
+}
+
+// ObserveBootDuration implements boottime.Observer
+func (p *Prometheus) ObserveBootDuration(instanceType string, d time.Duration) {
+	p.bootTimes.WithLabelValues(instanceType).Observe(d.Seconds())
+}
+
+// ObserveMessage implements events.Observer
+func (p *Prometheus) ObserveMessage(channel, outcome string, d time.Duration) {
+	p.received.WithLabelValues(channel).Inc()
+	switch outcome {
+	case events.OutcomeInvalid, events.OutcomeUnknownChannel:
+		p.decodeFails.WithLabelValues(channel).Inc()
+	case events.OutcomeFailed:
+		p.handleFails.WithLabelValues(channel).Inc()
+	}
+	p.handleTimes.WithLabelValues(channel).Observe(d.Seconds())
+}
+
+// ObserveConsecutiveFailures implements events.PoisonObserver
+func (p *Prometheus) ObserveConsecutiveFailures(channel string, n int) {
+	p.failStreaks.WithLabelValues(channel).Set(float64(n))
+}
+
+// ObservePoisonMessage implements events.PoisonObserver
+func (p *Prometheus) ObservePoisonMessage(channel string, repeat bool) {
+	action := "dead_lettered"
+	if repeat {
+		action = "dropped"
+	}
+	p.poison.WithLabelValues(channel, action).Inc()
+}
+
+// ObserveSealRejected implements events.SealObserver
+func (p *Prometheus) ObserveSealRejected(channel, reason string) {
+	p.sealRejects.WithLabelValues(channel, reason).Inc()
+}
+
+// ObserveUserEviction implements user.Observer
+func (p *Prometheus) ObserveUserEviction(reason string) {
+	p.evictions.WithLabelValues(reason).Inc()
+}
+
+// ObservePluginRequest implements predictor.PluginObserver
+func (p *Prometheus) ObservePluginRequest(outcome string, d time.Duration) {
+	p.pluginCalls.WithLabelValues(outcome).Inc()
+	p.pluginTimes.Observe(d.Seconds())
+}
+
+// ObserveLatencyBreach implements service.LatencyObserver
+func (p *Prometheus) ObserveLatencyBreach(tier string) {
+	p.breaches.WithLabelValues(tier).Inc()
+}
+
+// ObserveIdleReclaim implements service.IdleObserver
+func (p *Prometheus) ObserveIdleReclaim(outcome string) {
+	p.idleReclaim.WithLabelValues(outcome).Inc()
+}
+
+// ObserveNodeTermination implements service.TerminationObserver
+func (p *Prometheus) ObserveNodeTermination(reason, instanceType string) {
+	p.terminated.WithLabelValues(reason, instanceType).Inc()
+}
+
+// ObserveTerminationDeferred implements service.TerminationObserver
+func (p *Prometheus) ObserveTerminationDeferred(reason string) {
+	p.deferred.WithLabelValues(reason).Inc()
+}
+
+// ObserveNodesPurged implements service.TerminationObserver
+func (p *Prometheus) ObserveNodesPurged(count int) {
+	p.purged.Add(float64(count))
+}
+
+// ObserveScheduleRefresh implements calendar.Observer
+func (p *Prometheus) ObserveScheduleRefresh(outcome string) {
+	p.schedules.WithLabelValues(outcome).Inc()
+}
+
+// ObserveEscalation implements service.LatencyObserver
+func (p *Prometheus) ObserveEscalation(step, outcome string) {
+	p.escalations.WithLabelValues(step, outcome).Inc()
+}
+
+// ObserveProviderFallback implements service.ProviderObserver
+func (p *Prometheus) ObserveProviderFallback(provider string) {
+	p.fallbacks.WithLabelValues(provider).Inc()
+}
+
+// ObserveCapacityError implements service.ProviderObserver
+func (p *Prometheus) ObserveCapacityError(provider, instanceType, zone string) {
+	p.capacity.WithLabelValues(provider, instanceType, zone).Inc()
+}
+
+// ObserveFault implements chaos.Observer
+func (p *Prometheus) ObserveFault(fault string) {
+	p.chaosFaults.WithLabelValues(fault).Inc()
+}
+
+// ObserveBudgetBlock implements budget.Observer
+func (p *Prometheus) ObserveBudgetBlock(limit string) {
+	p.budgetBlock.WithLabelValues(limit).Inc()
+}
+
+// ObserveAccessDenied implements access.Observer
+func (p *Prometheus) ObserveAccessDenied(reason string) {
+	p.accessDeny.WithLabelValues(reason).Inc()
+}
+
+// ObservePurchases implements purchasing.Observer
+func (p *Prometheus) ObservePurchases(option, reason string, count int) {
+	p.purchases.WithLabelValues(option, reason).Add(float64(count))
+}
+
+// ObserveThrottled implements ratelimit.Observer
+func (p *Prometheus) ObserveThrottled(event string) {
+	p.throttled.WithLabelValues(event).Inc()
+}
+
+// ObservePredictionOutcome implements accuracy.Observer
+func (p *Prometheus) ObservePredictionOutcome(outcome string) {
+	p.predictions.WithLabelValues(outcome).Inc()
+}
+
+// RegisterAccuracy exposes rolling prediction precision and recall as gauges
+func (p *Prometheus) RegisterAccuracy(tracker *accuracy.Tracker) {
+	p.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "provisioning_prediction_precision",
+			Help: "Share of resolved connect predictions whose user connected in time, over the accuracy window.",
+		}, func() float64 {
+			return tracker.Snapshot().Precision
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "provisioning_prediction_recall",
+			Help: "Share of connects that had been predicted, over the accuracy window.",
+		}, func() float64 {
+			return tracker.Snapshot().Recall
+		}),
+	)
+}
+
+// RegisterBudget exposes spend against the limits as gauges
+func (p *Prometheus) RegisterBudget(tracker *budget.Tracker) {
+	p.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "provisioning_budget_hourly_run_rate",
+			Help: "Hourly price of every running node.",
+		}, func() float64 {
+			return tracker.Snapshot().HourlyRunRate
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "provisioning_budget_spent_today",
+			Help: "Spend accrued since UTC midnight.",
+		}, func() float64 {
+			return tracker.Snapshot().SpentToday
+		}),
+	)
+}
+
+// RegisterPurchasing exposes the achieved mix of purchase options as gauges
+func (p *Prometheus) RegisterPurchasing(strategy *purchasing.Strategy) {
+	for _, option := range purchasing.Options {
+		p.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "provisioning_purchase_mix_nodes",
+			Help:        "Nodes not terminated, by the purchase option they were bought with.",
+			ConstLabels: prometheus.Labels{"option": option},
+		}, func() float64 {
+			return float64(strategy.Snapshot().Nodes[option])
+		}))
+	}
+	p.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "provisioning_purchase_spot_ratio",
+		Help: "Spot share of nodes not on reserved capacity.",
+	}, func() float64 {
+		return strategy.Snapshot().SpotRatio
+	}))
+}
+
+// PendingCreationSource reports node creations that are not resolved yet
+type PendingCreationSource interface {
+	PendingCreations() int
+}
+
+// RegisterNodeAPI exposes unresolved node creations across the Node API
+// clients as a gauge
+func (p *Prometheus) RegisterNodeAPI(sources ...PendingCreationSource) {
+	p.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "provisioning_node_api_pending_creations",
+		Help: "Node creations in flight or whose outcome is unknown after a timeout.",
+	}, func() float64 {
+		pending := 0
+		for _, source := range sources {
+			pending += source.PendingCreations()
+		}
+		return float64(pending)
+	}))
+}
+
+// LagSource reports the inbound messages an event subscriber has yet to handle
+type LagSource interface {
+	Lag() int64
+}
+
+// RegisterSubscriberLag exposes the event subscriber's backlog as a gauge
+func (p *Prometheus) RegisterSubscriberLag(source LagSource) {
+	p.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "provisioning_event_consumer_lag",
+		Help: "Inbound messages waiting to be handled: the JetStream consumer's pending count, or the local buffer for Redis pub/sub.",
+	}, func() float64 {
+		return float64(source.Lag())
+	}))
+}
+
+// UserCountSource reports the number of users tracked
+type UserCountSource interface {
+	Count() int
+}
+
+// RegisterUserTracker exposes the number of tracked users as a gauge
+func (p *Prometheus) RegisterUserTracker(source UserCountSource) {
+	p.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "provisioning_tracked_users",
+		Help: "Users held by the user tracker, connected or kept for prediction.",
+	}, func() float64 {
+		return float64(source.Count())
+	}))
+}
+
+// RegisterBootFailures exposes the boot failure run and backoff as gauges
+func (p *Prometheus) RegisterBootFailures(source BootFailureSource) {
+	p.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "provisioning_consecutive_boot_failures",
+			Help: "Nodes in a row that failed to boot; reset by the next successful boot.",
+		}, func() float64 {
+			return float64(source.BootFailureState().Consecutive)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "provisioning_boot_backoff_seconds",
+			Help: "Time left before provisioning resumes after repeated boot failures.",
+		}, func() float64 {
+			return source.BootFailureState().BackoffRemaining.Seconds()
+		}),
+	)
+}
+
+// RegisterLeader exposes whether this replica leads as a gauge
+func (p *Prometheus) RegisterLeader(source LeaderSource) {
+	p.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "provisioning_leader",
+		Help: "1 while this replica leads and scales the pool, 0 while it stands by.",
+	}, func() float64 {
+		if source.IsLeader() {
+			return 1
+		}
+		return 0
+	}))
+}
+
+// RegisterSchedule exposes the attendees scheduled sessions bring now as a gauge
+func (p *Prometheus) RegisterSchedule(s *schedule.Schedule) {
+	p.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "provisioning_scheduled_attendees",
+		Help: "Attendees expected from scheduled sessions running or about to start, scaled by the expected attendance.",
+	}, func() float64 {
+		return s.Expected(time.Now()).Attendees
+	}))
+}
+
+// RegisterSLO exposes rolling cold-start SLO figures as gauges
+func (p *Prometheus) RegisterSLO(tracker *slo.Tracker) {
+	p.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "provisioning_slo_warm_start_ratio",
+			Help: "Share of connect requests served by a warm node over the SLO window.",
+		}, func() float64 {
+			return tracker.Snapshot().Compliance
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "provisioning_slo_warm_start_target",
+			Help: "Target share of connect requests served by a warm node.",
+		}, func() float64 {
+			return tracker.Snapshot().Target
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "provisioning_users_waiting",
+			Help: "Users currently waiting for a node after a cold start.",
+		}, func() float64 {
+			return float64(tracker.Snapshot().Waiting)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "provisioning_queue_oldest_wait_seconds",
+			Help: "How long the longest-waiting user has waited for a node.",
+		}, func() float64 {
+			return tracker.Snapshot().Queue.OldestWait.Seconds()
+		}),
+	)
+}
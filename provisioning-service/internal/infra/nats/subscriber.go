@@ -0,0 +1,267 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+)
+
+// Options configures the JetStream subscriber
+type Options struct {
+	URL           string
+	Stream        string
+	Durable       string
+	SubjectPrefix string // Prepended to channel-derived subjects, e.g. "aos."
+	MaxDeliver    int
+}
+
+// publisher publishes to a JetStream stream
+type publisher interface {
+	Publish(ctx context.Context, subject string, data []byte, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error)
+}
+
+// Subscriber consumes events from a durable JetStream consumer
+type Subscriber struct {
+	opts    Options
+	handler events.Handler
+	guard   events.Dispatcher
+	logger  *zap.Logger
+
+	conn       atomic.Pointer[nats.Conn]
+	js         publisher
+	subscribed atomic.Bool
+	pending    atomic.Int64
+}
+
+// NewSubscriber creates a new JetStream subscriber
+func NewSubscriber(opts Options, handler events.Handler, guard events.Dispatcher, logger *zap.Logger) *Subscriber {
+	return &Subscriber{
+		opts:    opts,
+		handler: handler,
+		guard:   guard,
+		logger:  logger,
+	}
+}
+
+// SubjectForChannel maps a channel name such as "user:connect" to its
+// JetStream subject, e.g. "aos.user.connect"
+func (s *Subscriber) SubjectForChannel(channel string) string {
+	return s.opts.SubjectPrefix + strings.ReplaceAll(channel, ":", ".")
+}
+
+func (s *Subscriber) channelForSubject(subject string) string {
+	return strings.ReplaceAll(strings.TrimPrefix(subject, s.opts.SubjectPrefix), ".", ":")
+}
+
+// Start connects to NATS and consumes events until ctx is cancelled.
+// Reconnection is handled by the NATS client.
+func (s *Subscriber) Start(ctx context.Context) error {
+	nc, err := nats.Connect(s.opts.URL,
+		nats.Name("provisioning-service"),
+		nats.MaxReconnects(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			s.subscribed.Store(false)
+			s.logger.Warn("nats disconnected", zap.Error(err))
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			s.subscribed.Store(true)
+			s.logger.Info("nats reconnected", zap.String("url", nc.ConnectedUrl()))
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	defer nc.Drain()
+	s.conn.Store(nc)
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return fmt.Errorf("failed to create jetstream context: %w", err)
+	}
+	s.js = js
+
+	channels := events.InboundChannels()
+	subjects := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		subjects = append(subjects, s.SubjectForChannel(channel))
+	}
+
+	// Dead letters are kept in the stream, outside the consumer's filter
+	if _, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     s.opts.Stream,
+		Subjects: append(slices.Clone(subjects), s.SubjectForChannel(deadLetterChannel)),
+	}); err != nil {
+		return fmt.Errorf("failed to ensure stream %s: %w", s.opts.Stream, err)
+	}
+
+	consumer, err := js.CreateOrUpdateConsumer(ctx, s.opts.Stream, jetstream.ConsumerConfig{
+		Durable:        s.opts.Durable,
+		AckPolicy:      jetstream.AckExplicitPolicy,
+		FilterSubjects: subjects,
+		MaxDeliver:     s.opts.MaxDeliver,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to ensure consumer %s: %w", s.opts.Durable, err)
+	}
+
+	consumeCtx, err := consumer.Consume(func(msg jetstream.Msg) {
+		// Handlers run to completion even if shutdown begins mid-message
+		s.handleMessage(context.WithoutCancel(ctx), msg)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start consuming: %w", err)
+	}
+	defer consumeCtx.Drain()
+
+	s.subscribed.Store(true)
+	s.logger.Info("consuming jetstream subjects",
+		zap.String("stream", s.opts.Stream),
+		zap.String("durable", s.opts.Durable),
+		zap.Strings("subjects", subjects),
+	)
+
+	<-ctx.Done()
+	s.subscribed.Store(false)
+	s.logger.Info("subscriber stopping")
+	return ctx.Err()
+}
+
+// Subscribed reports whether the subscriber is connected and consuming
+func (s *Subscriber) Subscribed() bool {
+	return s.subscribed.Load()
+}
+
+// Reconnects returns how many times the NATS connection has been re-established
+func (s *Subscriber) Reconnects() int64 {
+	nc := s.conn.Load()
+	if nc == nil {
+		return 0
+	}
+	return int64(nc.Stats().Reconnects)
+}
+
+// Lag returns the consumer's pending count reported with the last message
+// delivered: messages in the stream not yet delivered to this consumer
+func (s *Subscriber) Lag() int64 {
+	return s.pending.Load()
+}
+
+// Rejected event payloads are published to the dead-letter subject, kept
+// in the stream
+const deadLetterChannel = "events:dead_letter"
+
+// deadLetter is the record published for a rejected payload
+type deadLetter struct {
+	Subject    string `json:"subject"`
+	Payload    string `json:"payload"`
+	Reason     string `json:"reason"`
+	Deliveries uint64 `json:"deliveries"`
+	ReceivedAt int64  `json:"received_at"`
+}
+
+// handleMessage acks a handled message and naks one whose handler failed,
+// so it is redelivered. Invalid and poison payloads, and messages whose
+// handler failed on their last delivery, are dead-lettered and terminated.
+func (s *Subscriber) handleMessage(ctx context.Context, msg jetstream.Msg) {
+	channel := s.channelForSubject(msg.Subject())
+	var delivered uint64
+	if md, err := msg.Metadata(); err == nil {
+		s.pending.Store(int64(md.NumPending))
+		delivered = md.NumDelivered
+	}
+
+	s.logger.Debug("received message",
+		zap.String("subject", msg.Subject()),
+		zap.ByteString("payload", msg.Data()),
+	)
+
+	err := s.guard.Dispatch(ctx, s.handler, channel, msg.Data())
+
+	var poisonErr *events.PoisonError
+	var decodeErr *events.DecodeError
+	switch {
+	case err == nil:
+		if ackErr := msg.Ack(); ackErr != nil {
+			s.logger.Error("failed to ack message",
+				zap.String("subject", msg.Subject()),
+				zap.Error(ackErr),
+			)
+		}
+	case errors.As(err, &poisonErr):
+		// Copies of a poison payload are terminated unhandled; only the
+		// first is dead-lettered
+		if !poisonErr.Repeat {
+			s.rejectMessage(ctx, msg, delivered, poisonErr)
+		}
+		s.term(msg, poisonErr)
+	case errors.As(err, &decodeErr), errors.Is(err, events.ErrUnknownChannel):
+		// Invalid payloads are never redelivered
+		s.rejectMessage(ctx, msg, delivered, err)
+		s.term(msg, err)
+	case s.opts.MaxDeliver > 0 && delivered >= uint64(s.opts.MaxDeliver):
+		s.logger.Error("failed to handle message on its last delivery",
+			zap.String("subject", msg.Subject()),
+			zap.Uint64("deliveries", delivered),
+			zap.Error(err),
+		)
+		s.rejectMessage(ctx, msg, delivered, err)
+		s.term(msg, err)
+	default:
+		s.logger.Warn("failed to handle message; redelivering",
+			zap.String("subject", msg.Subject()),
+			zap.Uint64("deliveries", delivered),
+			zap.Error(err),
+		)
+		if nakErr := msg.Nak(); nakErr != nil {
+			s.logger.Error("failed to nak message", zap.Error(nakErr))
+		}
+	}
+}
+
+// term stops a message from being redelivered; JetStream advisories record
+// the reason
+func (s *Subscriber) term(msg jetstream.Msg, reason error) {
+	if err := msg.TermWithReason(reason.Error()); err != nil {
+		s.logger.Error("failed to terminate message",
+			zap.String("subject", msg.Subject()),
+			zap.Error(err),
+		)
+	}
+}
+
+// rejectMessage publishes a rejected payload to the dead-letter subject
+func (s *Subscriber) rejectMessage(ctx context.Context, msg jetstream.Msg, delivered uint64, reason error) {
+	s.logger.Warn("rejecting event",
+		zap.String("subject", msg.Subject()),
+		zap.Error(reason),
+	)
+
+	data, err := json.Marshal(deadLetter{
+		Subject:    msg.Subject(),
+		Payload:    string(msg.Data()),
+		Reason:     reason.Error(),
+		Deliveries: delivered,
+		ReceivedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		s.logger.Error("failed to marshal dead letter", zap.Error(err))
+		return
+	}
+
+	if _, err := s.js.Publish(ctx, s.SubjectForChannel(deadLetterChannel), data); err != nil {
+		s.logger.Error("failed to store dead letter",
+			zap.String("subject", msg.Subject()),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,87 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/nats-io/nats.go/jetstream"
+	"go.uber.org/zap"
+)
+
+// fakeMsg records how a message was settled
+type fakeMsg struct {
+	jetstream.Msg
+	delivered uint64
+	settled   string
+}
+
+func (m *fakeMsg) Subject() string { return "user.connect" }
+func (m *fakeMsg) Data() []byte    { return []byte(`{"user_id":"u1"}`) }
+
+func (m *fakeMsg) Metadata() (*jetstream.MsgMetadata, error) {
+	return &jetstream.MsgMetadata{NumDelivered: m.delivered}, nil
+}
+
+func (m *fakeMsg) Ack() error                         { m.settled = "ack"; return nil }
+func (m *fakeMsg) Nak() error                         { m.settled = "nak"; return nil }
+func (m *fakeMsg) TermWithReason(reason string) error { m.settled = "term"; return nil }
+
+// fakePublisher records the subjects published to
+type fakePublisher struct {
+	subjects []string
+}
+
+func (p *fakePublisher) Publish(ctx context.Context, subject string, data []byte, opts ...jetstream.PublishOpt) (*jetstream.PubAck, error) {
+	p.subjects = append(p.subjects, subject)
+	return &jetstream.PubAck{}, nil
+}
+
+// failingDispatcher fails every dispatch with err
+type failingDispatcher struct {
+	err error
+}
+
+func (d failingDispatcher) Dispatch(ctx context.Context, h events.Handler, channel string, payload []byte) error {
+	return d.err
+}
+
+func TestHandleMessageSettles(t *testing.T) {
+	handlerErr := errors.New("redis unavailable")
+	tests := []struct {
+		name         string
+		err          error
+		delivered    uint64
+		settled      string
+		deadLettered bool
+	}{
+		{name: "handled", settled: "ack"},
+		{name: "handler failed", err: handlerErr, delivered: 1, settled: "nak"},
+		{name: "handler failed on last delivery", err: handlerErr, delivered: 3, settled: "term", deadLettered: true},
+		{name: "invalid payload", err: &events.DecodeError{Channel: "user:connect", Err: errors.New("bad json")}, delivered: 1, settled: "term", deadLettered: true},
+		{name: "unknown channel", err: events.ErrUnknownChannel, delivered: 1, settled: "term", deadLettered: true},
+		{name: "poison", err: &events.PoisonError{Channel: "user:connect", Err: handlerErr}, delivered: 1, settled: "term", deadLettered: true},
+		{name: "poison copy", err: &events.PoisonError{Channel: "user:connect", Repeat: true, Err: handlerErr}, delivered: 1, settled: "term"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pub := &fakePublisher{}
+			s := NewSubscriber(Options{SubjectPrefix: "aos.", MaxDeliver: 3}, nil, failingDispatcher{tt.err}, zap.NewNop())
+			s.js = pub
+			msg := &fakeMsg{delivered: tt.delivered}
+
+			s.handleMessage(context.Background(), msg)
+
+			if msg.settled != tt.settled {
+				t.Errorf("settled with %q, want %q", msg.settled, tt.settled)
+			}
+			if deadLettered := len(pub.subjects) > 0; deadLettered != tt.deadLettered {
+				t.Fatalf("dead-lettered = %v, want %v", deadLettered, tt.deadLettered)
+			}
+			if tt.deadLettered && pub.subjects[0] != "aos.events.dead_letter" {
+				t.Errorf("dead letter published to %q", pub.subjects[0])
+			}
+		})
+	}
+}
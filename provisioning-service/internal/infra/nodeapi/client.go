@@ -1,67 +1,239 @@
 package nodeapi
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"time"
 
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"github.com/aos-cc/provisioning-service/internal/domain/requestid"
 	"go.uber.org/zap"
 	"resty.dev/v3"
 )
 
+// ErrUnsupported is returned for a request the Node API has no route for
+var ErrUnsupported = errors.New("not supported by the node api")
+
 // Client is an HTTP client for the Node Management API
 type Client struct {
-	baseURL string
-	resty   *resty.Client
-	logger  *zap.Logger
+	baseURL   string
+	resty     *resty.Client
+	creations *creations
+	logger    *zap.Logger
 }
 
 // NewClient creates a new Node API client
-func NewClient(baseURL string, timeout time.Duration, logger *zap.Logger) *Client {
+func NewClient(baseURL string, timeout time.Duration, create CreateOptions, logger *zap.Logger) *Client {
 	restyClient := resty.New().
 		SetBaseURL(baseURL).
 		SetTimeout(timeout).
 		SetHeader("Content-Type", "application/json")
 
 	return &Client{
-		baseURL: baseURL,
-		resty:   restyClient,
-		logger:  logger,
+		baseURL:   baseURL,
+		resty:     restyClient,
+		creations: newCreations(create.KeyTTL),
+		logger:    logger,
 	}
 }
 
-// CreateNode creates a new node
-func (c *Client) CreateNode(ctx context.Context) (string, error) {
-	var result CreateNodeResponse
-	var errResp ErrorResponse
+// request starts a Node API request that carries the ID of the request
+// that caused it, if any
+func (c *Client) request(ctx context.Context) *resty.Request {
+	req := c.resty.R().SetContext(ctx)
+	if id := requestid.From(ctx); id != "" {
+		req.SetHeader(requestid.Header, id)
+	}
+	return req
+}
 
-	resp, err := c.resty.R().
-		SetContext(ctx).
-		SetResult(&result).
-		SetError(&errResp).
-		Post("/api/nodes")
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
+// PendingCreations returns the number of node creations in flight or whose
+// outcome is unknown
+func (c *Client) PendingCreations() int {
+	return c.creations.count()
+}
+
+// postCreation sends a creation request under its idempotency key. It is
+// not retried here, so callers are not held up by backoffs; a failure that
+// may have created nodes leaves the key to the next creation of the same
+// shape. Only the status codes in ok count as success.
+func (c *Client) postCreation(ctx context.Context, cr *creation, body, result any, ok ...int) (*resty.Response, error) {
+	var resp *resty.Response
+	err := func() error {
+		var errResp ErrorResponse
+		var err error
+		resp, err = c.request(ctx).
+			SetHeader(IdempotencyHeader, cr.key).
+			SetBody(body).
+			SetResult(result).
+			SetError(&errResp).
+			Post(cr.path)
+		if err != nil {
+			c.logger.Warn("node creation request failed",
+				zap.String("idempotency_key", cr.key),
+				zap.String("request_id", requestid.From(ctx)),
+				zap.Error(err),
+			)
+			return fmt.Errorf("%w: failed to send request: %w", errUnresolved, err)
+		}
+
+		code := resp.StatusCode()
+		if slices.Contains(ok, code) {
+			return nil
+		}
+		if errResp.Error == ErrorNoCapacity {
+			return fmt.Errorf("status code %d: %w", code, &node.CapacityError{
+				InstanceType: cr.instanceType,
+				Zone:         cmp.Or(errResp.Zone, cr.zone),
+				Message:      errResp.Message,
+			})
+		}
+		if code >= http.StatusInternalServerError {
+			return fmt.Errorf("%w: unexpected status code %d: %s", errUnresolved, code, errResp.Error)
+		}
+		return fmt.Errorf("unexpected status code %d: %s", code, errResp.Error)
+	}()
+
+	resolved := !errors.Is(err, errUnresolved)
+	c.creations.end(cr, resolved)
+	if !resolved {
+		c.logger.Warn("node creation outcome unknown; its key is reused by the next matching creation",
+			zap.String("idempotency_key", cr.key),
+			zap.String("instance_type", cr.instanceType),
+			zap.String("zone", cr.zone),
+			zap.Int("count", cr.count),
+		)
 	}
+	return resp, err
+}
+
+// CreateNode creates a new node of the given instance type in the given
+// zone, asking for the given labels and purchase option; an empty zone or
+// option lets the Node API choose
+func (c *Client) CreateNode(ctx context.Context, instanceType, zone, purchase string, labels map[string]string) (string, error) {
+	var result CreateNodeResponse
 
-	if resp.StatusCode() != http.StatusAccepted && resp.StatusCode() != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code %d: %s", resp.StatusCode(), errResp.Error)
+	cr := c.creations.begin("/api/nodes", instanceType, zone, purchase, labels, 1)
+	_, err := c.postCreation(ctx, cr,
+		CreateNodeRequest{InstanceType: instanceType, Zone: zone, Labels: labels, PurchaseOption: purchase, IdempotencyKey: cr.key},
+		&result, http.StatusAccepted, http.StatusOK)
+	if err != nil {
+		return "", err
 	}
 
 	c.logger.Info("node created",
 		zap.String("node_id", result.ID),
+		zap.String("request_id", requestid.From(ctx)),
+		zap.String("instance_type", instanceType),
+		zap.String("zone", zone),
+		zap.String("purchase_option", purchase),
 	)
 
 	return result.ID, nil
 }
 
+// maxBatchSize is the most nodes the Node API creates in one batch request
+const maxBatchSize = 50
+
+// CreateNodes creates up to count nodes in batch requests of at most
+// maxBatchSize nodes each. On partial failure it returns the IDs that were
+// created along with an error, without sending the remaining batches. If
+// the API does not support batching, it falls back to sequential
+// CreateNode calls.
+func (c *Client) CreateNodes(ctx context.Context, instanceType, zone, purchase string, labels map[string]string, count int) ([]string, error) {
+	ids := make([]string, 0, count)
+	for len(ids) < count {
+		remaining := count - len(ids)
+		created, err := c.createBatch(ctx, instanceType, zone, purchase, labels, min(remaining, maxBatchSize))
+		if errors.Is(err, ErrUnsupported) {
+			c.logger.Debug("batch node creation unsupported, falling back to sequential requests")
+			created, err = c.createNodesSequential(ctx, instanceType, zone, purchase, labels, remaining)
+			return append(ids, created...), err
+		}
+		ids = append(ids, created...)
+		if err != nil {
+			if count > maxBatchSize {
+				err = fmt.Errorf("created %d of %d nodes: %w", len(ids), count, err)
+			}
+			return ids, err
+		}
+	}
+	return ids, nil
+}
+
+// createBatch creates up to count nodes in a single batch request,
+// returning ErrUnsupported if the API has no batch route
+func (c *Client) createBatch(ctx context.Context, instanceType, zone, purchase string, labels map[string]string, count int) ([]string, error) {
+	var result CreateNodesResponse
+
+	cr := c.creations.begin("/api/nodes/batch", instanceType, zone, purchase, labels, count)
+	resp, err := c.postCreation(ctx, cr,
+		CreateNodesRequest{Count: count, InstanceType: instanceType, Zone: zone, Labels: labels, PurchaseOption: purchase, IdempotencyKey: cr.key},
+		&result, http.StatusAccepted, http.StatusOK, http.StatusMultiStatus)
+	if resp != nil && (resp.StatusCode() == http.StatusNotFound || resp.StatusCode() == http.StatusMethodNotAllowed) {
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.logger.Info("nodes created",
+		zap.Strings("node_ids", result.IDs),
+		zap.Int("requested", count),
+	)
+
+	if len(result.IDs) < count && result.Error == ErrorNoCapacity {
+		return result.IDs, fmt.Errorf("batch partially failed: created %d of %d nodes: %w",
+			len(result.IDs), count, &node.CapacityError{InstanceType: instanceType, Zone: cmp.Or(result.Zone, zone)})
+	}
+	if len(result.IDs) < count {
+		return result.IDs, fmt.Errorf("batch partially failed: created %d of %d nodes: %s",
+			len(result.IDs), count, result.Error)
+	}
+
+	return result.IDs, nil
+}
+
+func (c *Client) createNodesSequential(ctx context.Context, instanceType, zone, purchase string, labels map[string]string, count int) ([]string, error) {
+	ids := make([]string, 0, count)
+	var errs []error
+	for i := 0; i < count; i++ {
+		id, err := c.CreateNode(ctx, instanceType, zone, purchase, labels)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	if len(errs) > 0 {
+		return ids, fmt.Errorf("created %d of %d nodes: %w", len(ids), count, errors.Join(errs...))
+	}
+	return ids, nil
+}
+
+// Ping checks that the Node API is reachable and answering
+func (c *Client) Ping(ctx context.Context) error {
+	resp, err := c.request(ctx).
+		Get("/")
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	if resp.StatusCode() >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode())
+	}
+	return nil
+}
+
 // DeleteNode terminates a node
 func (c *Client) DeleteNode(ctx context.Context, nodeID string) error {
 	var errResp ErrorResponse
 
-	resp, err := c.resty.R().
-		SetContext(ctx).
+	resp, err := c.request(ctx).
 		SetError(&errResp).
 		SetPathParam("nodeID", nodeID).
 		Delete("/api/nodes/{nodeID}")
@@ -77,11 +249,67 @@ func (c *Client) DeleteNode(ctx context.Context, nodeID string) error {
 
 	c.logger.Info("node deletion requested",
 		zap.String("node_id", nodeID),
+		zap.String("request_id", requestid.From(ctx)),
 	)
 
 	return nil
 }
 
+// GetNodeDiagnostics fetches the provider's status and console output for a node
+func (c *Client) GetNodeDiagnostics(ctx context.Context, nodeID string) (*NodeDiagnosticsResponse, error) {
+	var result NodeDiagnosticsResponse
+	var errResp ErrorResponse
+
+	resp, err := c.request(ctx).
+		SetResult(&result).
+		SetError(&errResp).
+		SetPathParam("nodeID", nodeID).
+		Get("/api/nodes/{nodeID}/diagnostics")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return nil, lookupError(resp.StatusCode(), errResp, nodeID)
+	}
+
+	return &result, nil
+}
+
+// GetNode looks up a node
+func (c *Client) GetNode(ctx context.Context, nodeID string) (*NodeResponse, error) {
+	var result NodeResponse
+	var errResp ErrorResponse
+
+	resp, err := c.request(ctx).
+		SetResult(&result).
+		SetError(&errResp).
+		SetPathParam("nodeID", nodeID).
+		Get("/api/nodes/{nodeID}")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode() != http.StatusOK {
+		return nil, lookupError(resp.StatusCode(), errResp, nodeID)
+	}
+
+	return &result, nil
+}
+
+// lookupError returns node.ErrNotFound only when the API says it has no such
+// node. A 404 without that error, or a 405, comes from an API without the
+// route and says nothing about the node.
+func lookupError(status int, errResp ErrorResponse, nodeID string) error {
+	switch {
+	case status == http.StatusNotFound && errResp.Error == ErrorNodeNotFound:
+		return fmt.Errorf("%w: %s", node.ErrNotFound, nodeID)
+	case status == http.StatusNotFound || status == http.StatusMethodNotAllowed:
+		return fmt.Errorf("%w: status code %d", ErrUnsupported, status)
+	}
+	return fmt.Errorf("unexpected status code %d: %s", status, errResp.Error)
+}
+
 // NodeManager handles node lifecycle operations
 type NodeManager struct {
 	client *Client
@@ -97,10 +325,14 @@ func NewNodeManager(client *Client, logger *zap.Logger) *NodeManager {
 }
 
 // ProvisionNode provisions a new node
-func (m *NodeManager) ProvisionNode(ctx context.Context) (string, error) {
-	m.logger.Info("provisioning new node")
+func (m *NodeManager) ProvisionNode(ctx context.Context, instanceType, zone, purchase string, labels map[string]string) (string, error) {
+	m.logger.Info("provisioning new node",
+		zap.String("instance_type", instanceType),
+		zap.String("zone", zone),
+		zap.Any("labels", labels),
+	)
 
-	nodeID, err := m.client.CreateNode(ctx)
+	nodeID, err := m.client.CreateNode(ctx, instanceType, zone, purchase, labels)
 	if err != nil {
 		m.logger.Error("failed to provision node", zap.Error(err))
 		return "", err
@@ -113,6 +345,33 @@ func (m *NodeManager) ProvisionNode(ctx context.Context) (string, error) {
 	return nodeID, nil
 }
 
+// ProvisionNodes provisions a batch of nodes, returning the IDs that were
+// created even when part of the batch fails
+func (m *NodeManager) ProvisionNodes(ctx context.Context, instanceType, zone, purchase string, labels map[string]string, count int) ([]string, error) {
+	m.logger.Info("provisioning node batch",
+		zap.String("instance_type", instanceType),
+		zap.String("zone", zone),
+		zap.Any("labels", labels),
+		zap.Int("count", count),
+	)
+
+	nodeIDs, err := m.client.CreateNodes(ctx, instanceType, zone, purchase, labels, count)
+	if err != nil {
+		m.logger.Error("failed to provision full node batch",
+			zap.Int("requested", count),
+			zap.Int("created", len(nodeIDs)),
+			zap.Error(err),
+		)
+		return nodeIDs, err
+	}
+
+	m.logger.Info("node batch provisioned successfully",
+		zap.Strings("node_ids", nodeIDs),
+	)
+
+	return nodeIDs, nil
+}
+
 // TerminateNode terminates a node
 func (m *NodeManager) TerminateNode(ctx context.Context, nodeID string) error {
 	m.logger.Info("terminating node",
@@ -133,3 +392,30 @@ func (m *NodeManager) TerminateNode(ctx context.Context, nodeID string) error {
 
 	return nil
 }
+
+// NodeExists reports whether the Node API still has a node; a terminated
+// node is gone
+func (m *NodeManager) NodeExists(ctx context.Context, nodeID string) (bool, error) {
+	resp, err := m.client.GetNode(ctx, nodeID)
+	if errors.Is(err, node.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return resp.Status != "terminated", nil
+}
+
+// GetNodeDiagnostics returns the diagnostics the Node API reports for a node
+func (m *NodeManager) GetNodeDiagnostics(ctx context.Context, nodeID string) (node.Diagnostics, error) {
+	resp, err := m.client.GetNodeDiagnostics(ctx, nodeID)
+	if err != nil {
+		return node.Diagnostics{}, err
+	}
+
+	return node.Diagnostics{
+		Status:        resp.Status,
+		StatusMessage: resp.StatusMessage,
+		ConsoleOutput: resp.ConsoleOutput,
+	}, nil
+}
@@ -0,0 +1,152 @@
+package nodeapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/node"
+	"go.uber.org/zap"
+)
+
+func TestNodeExists(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		exists  bool
+		wantErr error
+	}{
+		{name: "running", status: http.StatusOK, body: `{"node_id":"n1","status":"ready"}`, exists: true},
+		{name: "terminated", status: http.StatusOK, body: `{"node_id":"n1","status":"terminated"}`},
+		{name: "not found", status: http.StatusNotFound, body: `{"error":"node_not_found"}`},
+		{name: "no route", status: http.StatusNotFound, body: "404 page not found", wantErr: ErrUnsupported},
+		{name: "method not allowed", status: http.StatusMethodNotAllowed, wantErr: ErrUnsupported},
+		{name: "server error", status: http.StatusInternalServerError, body: `{"error":"boom"}`, wantErr: errAny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet || r.URL.Path != "/api/nodes/n1" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				if tt.body != "" && tt.body[0] == '{' {
+					w.Header().Set("Content-Type", "application/json")
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			m := NewNodeManager(NewClient(srv.URL, time.Second, CreateOptions{}, zap.NewNop()), zap.NewNop())
+			exists, err := m.NodeExists(t.Context(), "n1")
+			switch {
+			case tt.wantErr == errAny:
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil && errors.Is(err, node.ErrNotFound) {
+				t.Fatalf("err = %v must not report the node gone", err)
+			}
+			if exists != tt.exists {
+				t.Errorf("exists = %v, want %v", exists, tt.exists)
+			}
+		})
+	}
+}
+
+var errAny = errors.New("any error")
+
+func TestCreateNodeReusesUnresolvedKey(t *testing.T) {
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(IdempotencyHeader))
+		w.Header().Set("Content-Type", "application/json")
+		if len(keys) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(`{"error":"upstream"}`))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"id":"n1"}`))
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, time.Second, CreateOptions{KeyTTL: time.Minute}, zap.NewNop())
+	if _, err := c.CreateNode(t.Context(), "a100", "", "", nil); err == nil {
+		t.Fatal("expected the server error")
+	}
+	if len(keys) != 1 {
+		t.Fatalf("sent %d requests, want the creation not retried on the spot", len(keys))
+	}
+	if c.PendingCreations() != 1 {
+		t.Fatalf("pending creations = %d, want the unresolved one", c.PendingCreations())
+	}
+
+	// A creation of another shape gets a key of its own; one of the same
+	// shape resends the unresolved key
+	if _, err := c.CreateNode(t.Context(), "h100", "", "", nil); err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	if _, err := c.CreateNode(t.Context(), "a100", "", "", nil); err != nil {
+		t.Fatalf("CreateNode: %v", err)
+	}
+	if keys[1] == keys[0] || keys[2] != keys[0] {
+		t.Errorf("keys = %v, want the first resent by the matching creation only", keys)
+	}
+	if c.PendingCreations() != 0 {
+		t.Errorf("pending creations = %d after the key resolved", c.PendingCreations())
+	}
+}
+
+func TestCreateNodesChunksBatches(t *testing.T) {
+	var counts []int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req CreateNodesRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode request: %v", err)
+		}
+		counts = append(counts, req.Count)
+
+		resp := CreateNodesResponse{}
+		for i := range req.Count {
+			resp.IDs = append(resp.IDs, fmt.Sprintf("n%d-%d", len(counts), i))
+		}
+		// The third batch runs out of capacity halfway
+		if len(counts) == 3 {
+			resp.IDs, resp.Error = resp.IDs[:req.Count/2], ErrorNoCapacity
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, time.Second, CreateOptions{KeyTTL: time.Minute}, zap.NewNop())
+	ids, err := c.CreateNodes(t.Context(), "a100", "", "", nil, 3*maxBatchSize+20)
+
+	// The batch that failed partway leaves the last one unsent
+	if !slices.Equal(counts, []int{maxBatchSize, maxBatchSize, maxBatchSize}) {
+		t.Errorf("batch sizes = %v, want %d per batch until one fails", counts, maxBatchSize)
+	}
+	if len(ids) != 2*maxBatchSize+maxBatchSize/2 {
+		t.Errorf("created %d nodes, want those of every batch sent", len(ids))
+	}
+	var capErr *node.CapacityError
+	if !errors.As(err, &capErr) {
+		t.Errorf("err = %v, want the capacity error", err)
+	}
+}
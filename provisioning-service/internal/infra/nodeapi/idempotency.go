@@ -0,0 +1,111 @@
+package nodeapi
+
+import (
+	"errors"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IdempotencyHeader carries the key identifying a creation request
+const IdempotencyHeader = "Idempotency-Key"
+
+// CreateOptions configures how node creations whose outcome is unknown
+// are resent
+type CreateOptions struct {
+	// KeyTTL is how long the key of a creation whose outcome is unknown is
+	// reused by later creations of the same shape; it should not exceed the
+	// time the Node API remembers keys for
+	KeyTTL time.Duration
+}
+
+// creation is a node creation request identified by its idempotency key
+type creation struct {
+	key          string
+	path         string
+	instanceType string
+	zone         string
+	purchase     string
+	labels       map[string]string
+	count        int
+	started      time.Time
+	inFlight     bool
+}
+
+// creations tracks node creations by idempotency key. A creation that timed
+// out or failed with a server error may still have created nodes, so its key
+// is kept and sent again by the next creation of the same shape; the Node
+// API then returns the nodes it created instead of creating more.
+type creations struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*creation
+}
+
+func newCreations(ttl time.Duration) *creations {
+	return &creations{
+		ttl:     ttl,
+		pending: make(map[string]*creation),
+	}
+}
+
+// begin returns the creation to send, reusing the key of an unresolved
+// creation of the same shape if there is one
+func (c *creations) begin(path, instanceType, zone, purchase string, labels map[string]string, count int) *creation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, cr := range c.pending {
+		if cr.inFlight {
+			continue
+		}
+		if now.Sub(cr.started) > c.ttl {
+			delete(c.pending, key)
+			continue
+		}
+		if cr.path == path && cr.instanceType == instanceType && cr.zone == zone && cr.purchase == purchase && cr.count == count && maps.Equal(cr.labels, labels) {
+			cr.inFlight = true
+			return cr
+		}
+	}
+
+	cr := &creation{
+		key:          uuid.NewString(),
+		path:         path,
+		instanceType: instanceType,
+		zone:         zone,
+		purchase:     purchase,
+		labels:       labels,
+		count:        count,
+		started:      now,
+		inFlight:     true,
+	}
+	c.pending[cr.key] = cr
+	return cr
+}
+
+// end forgets a creation whose outcome is known, or keeps it for reuse
+func (c *creations) end(cr *creation, resolved bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if resolved {
+		delete(c.pending, cr.key)
+		return
+	}
+	cr.inFlight = false
+}
+
+// count returns the number of creations in flight or with an unknown outcome
+func (c *creations) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// errUnresolved marks a creation failure that may still have created nodes
+var errUnresolved = errors.New("creation outcome unknown")
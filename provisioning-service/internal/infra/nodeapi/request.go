@@ -2,5 +2,19 @@ package nodeapi
 
 // CreateNodeRequest represents the request for creating a node
 type CreateNodeRequest struct {
-	// Add fields as needed for node creation
+	InstanceType   string            `json:"instance_type,omitempty"`   // Empty lets the API choose
+	Zone           string            `json:"zone,omitempty"`            // Empty lets the API choose
+	Labels         map[string]string `json:"labels,omitempty"`          // Labels the node should report
+	PurchaseOption string            `json:"purchase_option,omitempty"` // reserved|spot|on_demand; empty lets the API choose
+	IdempotencyKey string            `json:"idempotency_key"`           // Also sent as the Idempotency-Key header
+}
+
+// CreateNodesRequest represents the request for creating a batch of nodes
+type CreateNodesRequest struct {
+	Count          int               `json:"count"`
+	InstanceType   string            `json:"instance_type,omitempty"`
+	Zone           string            `json:"zone,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	PurchaseOption string            `json:"purchase_option,omitempty"`
+	IdempotencyKey string            `json:"idempotency_key"`
 }
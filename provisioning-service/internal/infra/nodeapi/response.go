@@ -5,14 +5,44 @@ type CreateNodeResponse struct {
 	ID string `json:"id"`
 }
 
+// CreateNodesResponse represents the response from creating a batch of nodes.
+// IDs may hold fewer entries than requested when the batch partially fails.
+type CreateNodesResponse struct {
+	IDs    []string `json:"ids"`
+	Failed int      `json:"failed,omitempty"`
+	Error  string   `json:"error,omitempty"`
+	Zone   string   `json:"zone,omitempty"` // Zone out of capacity, with error insufficient_capacity
+}
+
+// NodeResponse represents a node as the API reports it
+type NodeResponse struct {
+	NodeID string `json:"node_id"`
+	Status string `json:"status"`
+}
+
+// NodeDiagnosticsResponse represents the diagnostics the API reports for a node
+type NodeDiagnosticsResponse struct {
+	Status        string `json:"status,omitempty"`
+	StatusMessage string `json:"status_message,omitempty"`
+	ConsoleOutput string `json:"console_output,omitempty"`
+}
+
 // DeleteNodeResponse represents the response from deleting a node
 type DeleteNodeResponse struct {
 	Message string `json:"message,omitempty"`
 }
 
+// ErrorNoCapacity is the error the API reports when it has no capacity for
+// the nodes asked for, in an error response or a partially failed batch
+const ErrorNoCapacity = "insufficient_capacity"
+
+// ErrorNodeNotFound is the error the API reports for a node it does not have
+const ErrorNodeNotFound = "node_not_found"
+
 // ErrorResponse represents an error response from the API
 type ErrorResponse struct {
 	Error   string `json:"error"`
 	Message string `json:"message,omitempty"`
 	Code    int    `json:"code,omitempty"`
+	Zone    string `json:"zone,omitempty"` // Zone out of capacity, with error insufficient_capacity
 }
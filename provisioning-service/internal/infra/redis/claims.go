@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// claimScript takes a slot in a node's claim hash unless every slot is
+// taken, in one atomic step. Claims older than the TTL are dropped first,
+// and a user who already holds a slot has their claim renewed.
+// KEYS[1] claim hash; ARGV[1] user; ARGV[2] capacity; ARGV[3] claim time;
+// ARGV[4] oldest claim time still held; ARGV[5] TTL in milliseconds
+var claimScript = redis.NewScript(`
+local claims = redis.call('HGETALL', KEYS[1])
+for i = 1, #claims, 2 do
+	if tonumber(claims[i + 1]) < tonumber(ARGV[4]) then
+		redis.call('HDEL', KEYS[1], claims[i])
+	end
+end
+if redis.call('HEXISTS', KEYS[1], ARGV[1]) == 0 and redis.call('HLEN', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return 1
+`)
+
+// Claims coordinates node slots between replicas through a hash per node,
+// mapping each user holding a slot to the time they last claimed it. Claims
+// not renewed within the TTL lapse, so a replica that dies between claiming
+// a slot and allocating it does not hold the slot for the node's lifetime.
+type Claims struct {
+	client    *Client
+	keyPrefix string
+	ttl       time.Duration
+}
+
+// NewClaims creates claims stored under keys starting with keyPrefix,
+// lapsing after ttl unless renewed
+func NewClaims(client *Client, keyPrefix string, ttl time.Duration) *Claims {
+	return &Claims{
+		client:    client,
+		keyPrefix: keyPrefix,
+		ttl:       ttl,
+	}
+}
+
+func (c *Claims) key(nodeID string) string {
+	return c.keyPrefix + nodeID
+}
+
+// Claim takes one of a node's capacity slots for a user
+func (c *Claims) Claim(ctx context.Context, nodeID, userID string, capacity int) (bool, error) {
+	now := time.Now()
+	claimed, err := claimScript.Run(ctx, c.client.rdb, []string{c.key(nodeID)},
+		userID, capacity, now.Unix(), now.Add(-c.ttl).Unix(), c.ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return claimed == 1, nil
+}
+
+// Release gives up a user's slot on a node
+func (c *Claims) Release(ctx context.Context, nodeID, userID string) error {
+	return c.client.rdb.HDel(ctx, c.key(nodeID), userID).Err()
+}
+
+// Forget drops every claim on a node
+func (c *Claims) Forget(ctx context.Context, nodeID string) error {
+	return c.client.rdb.Del(ctx, c.key(nodeID)).Err()
+}
+
+// Renew refreshes the claims of the users on a node
+func (c *Claims) Renew(ctx context.Context, nodeID string, userIDs []string) error {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	values := make([]any, 0, 2*len(userIDs))
+	for _, userID := range userIDs {
+		values = append(values, userID, now)
+	}
+
+	_, err := c.client.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, c.key(nodeID), values...)
+		pipe.PExpire(ctx, c.key(nodeID), c.ttl)
+		return nil
+	})
+	return err
+}
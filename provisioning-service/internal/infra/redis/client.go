@@ -2,34 +2,191 @@ package redis
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
+// Connection modes supported by NewClient
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// Options configures the Redis connection
+type Options struct {
+	// Mode is one of standalone, sentinel or cluster (defaults to standalone)
+	Mode string
+
+	// Addr is the server address in standalone mode
+	Addr string
+
+	// Addrs lists sentinel addresses in sentinel mode or seed nodes in cluster mode
+	Addrs []string
+
+	// MasterName is the name of the master monitored by sentinel
+	MasterName string
+
+	Password         string
+	SentinelPassword string
+	DB               int
+
+	// TLS encrypts connections when set
+	TLS *TLSOptions
+
+	// Pool and timeout settings; zero values keep the go-redis defaults
+	PoolSize        int           // Connections per node; 10 per CPU by default
+	MinIdleConns    int           // Idle connections kept open
+	DialTimeout     time.Duration // 5s by default
+	ReadTimeout     time.Duration // 3s by default
+	WriteTimeout    time.Duration // ReadTimeout by default
+	PoolTimeout     time.Duration // Wait for a free connection; ReadTimeout + 1s by default
+	MaxRetries      int           // 3 by default; -1 disables retries
+	MinRetryBackoff time.Duration // 8ms by default
+	MaxRetryBackoff time.Duration // 512ms by default
+}
+
+// TLSOptions configures TLS for Redis connections
+type TLSOptions struct {
+	CAFile             string // PEM bundle verifying the server; the system pool when empty
+	CertFile           string // Client certificate for mutual TLS
+	KeyFile            string // Key of the client certificate
+	ServerName         string // Overrides the name verified against the server certificate
+	InsecureSkipVerify bool   // Skip server verification; for testing only
+}
+
+// config builds the tls.Config described by the options
+func (o *TLSOptions) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in redis CA file %s", o.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, errors.New("redis client certificate and key must be set together")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
 // Client wraps the Redis client
 type Client struct {
-	rdb    *redis.Client
+	rdb    redis.UniversalClient
 	logger *zap.Logger
 }
 
 // NewClient creates a new Redis client
-func NewClient(addr, password string, db int, logger *zap.Logger) (*Client, error) {
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
-	})
+func NewClient(opts Options, logger *zap.Logger) (*Client, error) {
+	var tlsConfig *tls.Config
+	if opts.TLS != nil {
+		var err error
+		if tlsConfig, err = opts.TLS.config(); err != nil {
+			return nil, err
+		}
+	}
+
+	var rdb redis.UniversalClient
+
+	switch opts.Mode {
+	case "", ModeStandalone:
+		rdb = redis.NewClient(&redis.Options{
+			Addr:            opts.Addr,
+			Password:        opts.Password,
+			DB:              opts.DB,
+			TLSConfig:       tlsConfig,
+			PoolSize:        opts.PoolSize,
+			MinIdleConns:    opts.MinIdleConns,
+			DialTimeout:     opts.DialTimeout,
+			ReadTimeout:     opts.ReadTimeout,
+			WriteTimeout:    opts.WriteTimeout,
+			PoolTimeout:     opts.PoolTimeout,
+			MaxRetries:      opts.MaxRetries,
+			MinRetryBackoff: opts.MinRetryBackoff,
+			MaxRetryBackoff: opts.MaxRetryBackoff,
+		})
+	case ModeSentinel:
+		if opts.MasterName == "" || len(opts.Addrs) == 0 {
+			return nil, fmt.Errorf("sentinel mode requires master name and sentinel addresses")
+		}
+		rdb = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       opts.MasterName,
+			SentinelAddrs:    opts.Addrs,
+			SentinelPassword: opts.SentinelPassword,
+			Password:         opts.Password,
+			DB:               opts.DB,
+			TLSConfig:        tlsConfig,
+			PoolSize:         opts.PoolSize,
+			MinIdleConns:     opts.MinIdleConns,
+			DialTimeout:      opts.DialTimeout,
+			ReadTimeout:      opts.ReadTimeout,
+			WriteTimeout:     opts.WriteTimeout,
+			PoolTimeout:      opts.PoolTimeout,
+			MaxRetries:       opts.MaxRetries,
+			MinRetryBackoff:  opts.MinRetryBackoff,
+			MaxRetryBackoff:  opts.MaxRetryBackoff,
+		})
+	case ModeCluster:
+		if len(opts.Addrs) == 0 {
+			return nil, fmt.Errorf("cluster mode requires at least one seed address")
+		}
+		rdb = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:           opts.Addrs,
+			Password:        opts.Password,
+			TLSConfig:       tlsConfig,
+			PoolSize:        opts.PoolSize,
+			MinIdleConns:    opts.MinIdleConns,
+			DialTimeout:     opts.DialTimeout,
+			ReadTimeout:     opts.ReadTimeout,
+			WriteTimeout:    opts.WriteTimeout,
+			PoolTimeout:     opts.PoolTimeout,
+			MaxRetries:      opts.MaxRetries,
+			MinRetryBackoff: opts.MinRetryBackoff,
+			MaxRetryBackoff: opts.MaxRetryBackoff,
+		})
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", opts.Mode)
+	}
 
 	// Test connection
 	ctx := context.Background()
 	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
 		return nil, err
 	}
 
 	logger.Info("connected to redis",
-		zap.String("addr", addr),
-		zap.Int("db", db),
+		zap.String("mode", modeOrDefault(opts.Mode)),
+		zap.String("addr", opts.Addr),
+		zap.Strings("addrs", opts.Addrs),
+		zap.Int("db", opts.DB),
+		zap.Bool("tls", tlsConfig != nil),
 	)
 
 	return &Client{
@@ -38,16 +195,28 @@ func NewClient(addr, password string, db int, logger *zap.Logger) (*Client, erro
 	}, nil
 }
 
+func modeOrDefault(mode string) string {
+	if mode == "" {
+		return ModeStandalone
+	}
+	return mode
+}
+
 // Close closes the Redis connection
 func (c *Client) Close() error {
 	return c.rdb.Close()
 }
 
 // GetClient returns the underlying Redis client
-func (c *Client) GetClient() *redis.Client {
+func (c *Client) GetClient() redis.UniversalClient {
 	return c.rdb
 }
 
+// Ping checks that Redis is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	return c.rdb.Ping(ctx).Err()
+}
+
 // Subscribe subscribes to a Redis channel
 func (c *Client) Subscribe(ctx context.Context, channel string) *redis.PubSub {
 	return c.rdb.Subscribe(ctx, channel)
@@ -57,3 +226,12 @@ func (c *Client) Subscribe(ctx context.Context, channel string) *redis.PubSub {
 func (c *Client) Publish(ctx context.Context, channel, message string) error {
 	return c.rdb.Publish(ctx, channel, message).Err()
 }
+
+// PushDeadLetter prepends a record to a capped dead-letter list
+func (c *Client) PushDeadLetter(ctx context.Context, key, record string, maxLen int64) error {
+	pipe := c.rdb.TxPipeline()
+	pipe.LPush(ctx, key, record)
+	pipe.LTrim(ctx, key, 0, maxLen-1)
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,54 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/handoff"
+	"github.com/redis/go-redis/v9"
+)
+
+// HandoffStore keeps the handoff in a key that expires once it is too old
+// to be taken over
+type HandoffStore struct {
+	client *Client
+	key    string
+	ttl    time.Duration
+}
+
+// NewHandoffStore creates a handoff store under the given key
+func NewHandoffStore(client *Client, key string, ttl time.Duration) *HandoffStore {
+	return &HandoffStore{
+		client: client,
+		key:    key,
+		ttl:    ttl,
+	}
+}
+
+// Save replaces the stored snapshot
+func (s *HandoffStore) Save(ctx context.Context, snapshot handoff.Snapshot) error {
+	data, err := handoff.Encode(snapshot)
+	if err != nil {
+		return err
+	}
+	return s.client.rdb.Set(ctx, s.key, data, s.ttl).Err()
+}
+
+// Take returns the stored snapshot and deletes the key in one command, so
+// only one service takes it over, reporting false if there is none
+func (s *HandoffStore) Take(ctx context.Context) (handoff.Snapshot, bool, error) {
+	data, err := s.client.rdb.GetDel(ctx, s.key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return handoff.Snapshot{}, false, nil
+	}
+	if err != nil {
+		return handoff.Snapshot{}, false, err
+	}
+
+	snapshot, err := handoff.Decode(data)
+	if err != nil {
+		return handoff.Snapshot{}, false, err
+	}
+	return snapshot, true, nil
+}
@@ -0,0 +1,220 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// keyspaceFlags are the notify-keyspace-events classes the watcher needs:
+// key-space notifications (K) for string commands ($)
+const keyspaceFlags = "K$"
+
+// KeyspaceOptions configures the node state key watcher
+type KeyspaceOptions struct {
+	// Pattern matches the node state keys, with a single * standing for the
+	// node ID, e.g. "node:*:status"
+	Pattern string
+
+	// DB is the database the keys live in
+	DB int
+
+	// Configure enables key-space notifications on the server at startup,
+	// which requires the CONFIG command
+	Configure bool
+}
+
+// KeyspaceWatcher turns writes to node state keys into node status events,
+// for producers that SET a key per node rather than publishing on
+// node:status. A key holds either the bare status, e.g. "ready", or a
+// node:status payload; the node ID always comes from the key.
+type KeyspaceWatcher struct {
+	client  *Client
+	handler events.Handler
+	guard   *events.PoisonGuard
+	opts    KeyspaceOptions
+	logger  *zap.Logger
+
+	checkInterval  time.Duration
+	prefix, suffix string // Of the keys around the node ID
+}
+
+// NewKeyspaceWatcher creates a watcher for keys matching opts.Pattern
+func NewKeyspaceWatcher(client *Client, handler events.Handler, guard *events.PoisonGuard, opts KeyspaceOptions, logger *zap.Logger) (*KeyspaceWatcher, error) {
+	prefix, suffix, ok := strings.Cut(opts.Pattern, "*")
+	if !ok || strings.Contains(suffix, "*") {
+		return nil, fmt.Errorf("key pattern %q must contain exactly one *", opts.Pattern)
+	}
+	return &KeyspaceWatcher{
+		client:        client,
+		handler:       handler,
+		guard:         guard,
+		opts:          opts,
+		logger:        logger,
+		checkInterval: defaultCheckInterval,
+		prefix:        prefix,
+		suffix:        suffix,
+	}, nil
+}
+
+// Start watches the keys until ctx is cancelled, resubscribing with
+// exponential backoff whenever the subscription is lost. Writes made while
+// the subscription is down are missed; the key is read again on its next
+// write.
+func (w *KeyspaceWatcher) Start(ctx context.Context) error {
+	if w.opts.Configure {
+		if err := w.configure(ctx); err != nil {
+			w.logger.Warn("failed to enable key-space notifications; enable them on the server",
+				zap.String("flags", keyspaceFlags),
+				zap.Error(err),
+			)
+		}
+	}
+
+	delay := minResubscribeDelay
+	for {
+		established, err := w.watch(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if established {
+			delay = minResubscribeDelay
+		}
+
+		w.logger.Warn("key-space subscription lost, resubscribing",
+			zap.Duration("delay", delay),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxResubscribeDelay {
+			delay = maxResubscribeDelay
+		}
+	}
+}
+
+// configure adds the classes the watcher needs to the server's
+// notify-keyspace-events, keeping those already enabled
+func (w *KeyspaceWatcher) configure(ctx context.Context) error {
+	rdb := w.client.GetClient()
+	current, err := rdb.ConfigGet(ctx, "notify-keyspace-events").Result()
+	if err != nil {
+		return err
+	}
+
+	flags := current["notify-keyspace-events"]
+	missing := false
+	for _, f := range keyspaceFlags {
+		// A enables every command class, including $
+		if !strings.ContainsRune(flags, f) && (f != '$' || !strings.ContainsRune(flags, 'A')) {
+			flags += string(f)
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+	if err := rdb.ConfigSet(ctx, "notify-keyspace-events", flags).Err(); err != nil {
+		return err
+	}
+	w.logger.Info("enabled key-space notifications", zap.String("flags", flags))
+	return nil
+}
+
+// watch handles notifications from one subscription until it fails or ctx
+// is cancelled, reporting whether it was established
+func (w *KeyspaceWatcher) watch(ctx context.Context) (bool, error) {
+	channel := w.channelPrefix() + w.opts.Pattern
+	pubsub := w.client.GetClient().PSubscribe(ctx, channel)
+	defer pubsub.Close()
+
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return false, err
+	}
+	w.logger.Info("watching node state keys", zap.String("pattern", w.opts.Pattern))
+
+	err := receive(ctx, w.client, pubsub, 1, w.checkInterval, func(msg *redis.Message, pending int) {
+		// Handlers run to completion even if shutdown begins mid-message
+		w.handleNotification(context.WithoutCancel(ctx), msg)
+	})
+	return true, err
+}
+
+func (w *KeyspaceWatcher) channelPrefix() string {
+	return fmt.Sprintf("__keyspace@%d__:", w.opts.DB)
+}
+
+// handleNotification reads a key that was set and dispatches its value as
+// a node status event. Deleted and expired keys are ignored: a node is only
+// terminated by a status saying so.
+func (w *KeyspaceWatcher) handleNotification(ctx context.Context, msg *redis.Message) {
+	if msg.Payload != "set" {
+		return
+	}
+	key := strings.TrimPrefix(msg.Channel, w.channelPrefix())
+	nodeID := strings.TrimSuffix(strings.TrimPrefix(key, w.prefix), w.suffix)
+	if nodeID == "" {
+		return
+	}
+
+	value, err := w.client.GetClient().Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return // Deleted since it was set
+	}
+	if err != nil {
+		w.logger.Error("failed to read node state key", zap.String("key", key), zap.Error(err))
+		return
+	}
+
+	err = w.guard.Dispatch(ctx, w.handler, events.ChannelNodeStatus, nodeStatusPayload(nodeID, value))
+
+	var poisonErr *events.PoisonError
+	var decodeErr *events.DecodeError
+	switch {
+	case err == nil:
+	case errors.As(err, &poisonErr):
+		if !poisonErr.Repeat {
+			rejectPayload(ctx, w.client, w.logger, key, value, poisonErr)
+		}
+	case errors.As(err, &decodeErr):
+		rejectPayload(ctx, w.client, w.logger, key, value, decodeErr.Err)
+	default:
+		w.logger.Error("failed to handle node state",
+			zap.String("key", key),
+			zap.String("value", value),
+			zap.Error(err),
+		)
+	}
+}
+
+// nodeStatusPayload builds a node:status payload from a node state key's
+// value: a node:status payload, or the bare status. A malformed payload is
+// returned as is, to be rejected like any other.
+func nodeStatusPayload(nodeID, value string) []byte {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "{") {
+		payload, _ := json.Marshal(events.NodeStatusEvent{NodeID: nodeID, Status: value})
+		return payload
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return []byte(value)
+	}
+	fields["node_id"], _ = json.Marshal(nodeID)
+	payload, _ := json.Marshal(fields)
+	return payload
+}
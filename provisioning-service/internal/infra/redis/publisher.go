@@ -0,0 +1,28 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+)
+
+// Publisher publishes events on Redis under a channel namespace. The
+// service's own channels are named for the tenant the context carries;
+// reply channels named by clients are used as they are.
+type Publisher struct {
+	client    *Client
+	namespace events.Namespace
+}
+
+// NewPublisher creates a publisher naming channels through namespace
+func NewPublisher(client *Client, namespace events.Namespace) *Publisher {
+	return &Publisher{client: client, namespace: namespace}
+}
+
+// Publish publishes a message on a channel
+func (p *Publisher) Publish(ctx context.Context, channel, message string) error {
+	if p.namespace.Named(channel) {
+		channel = p.namespace.Name(channel, events.TenantFrom(ctx))
+	}
+	return p.client.Publish(ctx, channel, message)
+}
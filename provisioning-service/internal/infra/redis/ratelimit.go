@@ -0,0 +1,62 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/ratelimit"
+	"github.com/redis/go-redis/v9"
+)
+
+// takeScript refills a token bucket for the time since it was last taken
+// from and takes a token if one is left, in one atomic step. Time is read
+// from Redis, so replicas with skewed clocks share buckets correctly.
+// KEYS[1] bucket hash; ARGV[1] tokens per second; ARGV[2] burst.
+// Returns {1, 0} if a token was taken, else {0, milliseconds to the next}.
+var takeScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+
+local b = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+local tokens = tonumber(b[1]) or burst
+local at = tonumber(b[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - at) * rate)
+
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'at', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000))
+return {allowed, wait}
+`)
+
+// RateLimits keeps each token bucket in a hash, shared by every replica;
+// buckets expire once they would have refilled
+type RateLimits struct {
+	client    *Client
+	keyPrefix string
+}
+
+// NewRateLimits creates buckets stored under keys starting with keyPrefix
+func NewRateLimits(client *Client, keyPrefix string) *RateLimits {
+	return &RateLimits{
+		client:    client,
+		keyPrefix: keyPrefix,
+	}
+}
+
+// Take takes a token from the bucket at key
+func (r *RateLimits) Take(ctx context.Context, key string, limit ratelimit.Limit) (bool, time.Duration, error) {
+	result, err := takeScript.Run(ctx, r.client.rdb, []string{r.keyPrefix + key}, limit.PerMinute/60, limit.Burst).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
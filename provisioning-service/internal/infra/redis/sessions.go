@@ -0,0 +1,51 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/session"
+	"github.com/redis/go-redis/v9"
+)
+
+// SessionSink exports session records to a Redis stream. A retried export
+// may add a record twice; consumers deduplicate on the record ID.
+type SessionSink struct {
+	client *Client
+	stream string
+	maxLen int64
+}
+
+// NewSessionSink creates a sink appending to stream, trimmed to roughly maxLen entries
+func NewSessionSink(client *Client, stream string, maxLen int64) *SessionSink {
+	return &SessionSink{
+		client: client,
+		stream: stream,
+		maxLen: maxLen,
+	}
+}
+
+// Export appends records to the stream in one pipeline
+func (s *SessionSink) Export(ctx context.Context, records []session.Record) error {
+	pipe := s.client.rdb.Pipeline()
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal session record: %w", err)
+		}
+
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: s.stream,
+			MaxLen: s.maxLen,
+			Approx: true,
+			Values: map[string]any{
+				"id":     record.ID,
+				"record": string(data),
+			},
+		})
+	}
+
+	_, err := pipe.Exec(ctx)
+	return err
+}
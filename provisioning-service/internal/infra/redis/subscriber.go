@@ -3,6 +3,10 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/aos-cc/provisioning-service/internal/domain/events"
 	"github.com/redis/go-redis/v9"
@@ -10,38 +14,102 @@ import (
 )
 
 // EventHandler handles different types of events
-type EventHandler interface {
-	HandleUserActivity(ctx context.Context, event events.UserActivityEvent) error
-	HandleUserConnect(ctx context.Context, event events.UserConnectEvent) error
-	HandleUserDisconnect(ctx context.Context, event events.UserDisconnectEvent) error
-	HandleNodeStatus(ctx context.Context, event events.NodeStatusEvent) error
-}
+type EventHandler = events.Handler
 
-// Subscriber listens to Redis pub/sub channels
+// Subscriber listens to Redis pub/sub channels, and to every tenant's
+// channels when its namespace has a tenant pattern
 type Subscriber struct {
-	client  *Client
-	handler EventHandler
-	logger  *zap.Logger
+	client    *Client
+	handler   EventHandler
+	guard     events.Dispatcher
+	namespace events.Namespace
+	logger    *zap.Logger
+
+	checkInterval time.Duration
+
+	subscribed    atomic.Bool
+	connectedOnce atomic.Bool
+	reconnects    atomic.Int64
+	backlog       atomic.Int64
 }
 
 // NewSubscriber creates a new Redis subscriber
-func NewSubscriber(client *Client, handler EventHandler, logger *zap.Logger) *Subscriber {
+func NewSubscriber(client *Client, handler EventHandler, guard events.Dispatcher, namespace events.Namespace, logger *zap.Logger) *Subscriber {
 	return &Subscriber{
-		client:  client,
-		handler: handler,
-		logger:  logger,
+		client:        client,
+		handler:       handler,
+		guard:         guard,
+		namespace:     namespace,
+		logger:        logger,
+		checkInterval: defaultCheckInterval,
 	}
 }
 
-// Start starts listening to all channels
+// Backoff bounds for resubscribing after the subscription is lost
+// (e.g. during a sentinel failover or a dropped connection)
+const (
+	minResubscribeDelay = time.Second
+	maxResubscribeDelay = 30 * time.Second
+)
+
+// Start starts listening to all channels, resubscribing with exponential
+// backoff whenever the subscription is lost until ctx is cancelled
 func (s *Subscriber) Start(ctx context.Context) error {
-	channels := []string{
-		events.ChannelUserActivity,
-		events.ChannelUserConnect,
-		events.ChannelUserDisconnect,
-		events.ChannelNodeStatus,
+	channels, patterns := s.namespace.Subscriptions(events.InboundChannels())
+
+	delay := minResubscribeDelay
+	for {
+		err := s.subscribe(ctx, channels, patterns)
+		s.subscribed.Store(false)
+		if ctx.Err() != nil {
+			s.logger.Info("subscriber stopping")
+			return ctx.Err()
+		}
+
+		// A subscription that was established resets the backoff
+		if s.connectedOnce.Swap(false) {
+			delay = minResubscribeDelay
+		}
+		s.reconnects.Add(1)
+
+		s.logger.Warn("subscription lost, resubscribing",
+			zap.Duration("delay", delay),
+			zap.Error(err),
+		)
+
+		select {
+		case <-ctx.Done():
+			s.logger.Info("subscriber stopping")
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
+		if delay > maxResubscribeDelay {
+			delay = maxResubscribeDelay
+		}
 	}
+}
+
+// Subscribed reports whether the subscriber currently holds a live subscription
+func (s *Subscriber) Subscribed() bool {
+	return s.subscribed.Load()
+}
+
+// Reconnects returns how many times the subscription has been re-established
+func (s *Subscriber) Reconnects() int64 {
+	return s.reconnects.Load()
+}
+
+// Lag returns how many received messages were waiting to be handled when
+// the last one was taken. Pub/sub keeps no backlog on the server, so once
+// the client buffer fills, further messages are dropped by Redis.
+func (s *Subscriber) Lag() int64 {
+	return s.backlog.Load()
+}
 
+// subscribe consumes messages from a single subscription until it fails or ctx is cancelled
+func (s *Subscriber) subscribe(ctx context.Context, channels, patterns []string) error {
 	pubsub := s.client.GetClient().Subscribe(ctx, channels...)
 	defer pubsub.Close()
 
@@ -50,67 +118,163 @@ func (s *Subscriber) Start(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	if len(patterns) > 0 {
+		if err := pubsub.PSubscribe(ctx, patterns...); err != nil {
+			return err
+		}
+	}
+
+	s.subscribed.Store(true)
+	s.connectedOnce.Store(true)
+	s.logger.Info("subscribed to channels",
+		zap.Strings("channels", channels),
+		zap.Strings("patterns", patterns),
+	)
+
+	return receive(ctx, s.client, pubsub, len(channels)+len(patterns), s.checkInterval, func(msg *redis.Message, pending int) {
+		s.backlog.Store(int64(pending))
+		// Handlers run to completion even if shutdown begins mid-message
+		s.handleMessage(context.WithoutCancel(ctx), msg)
+	})
+}
 
-	s.logger.Info("subscribed to channels", zap.Strings("channels", channels))
+// defaultCheckInterval is how often a live subscription's connection is
+// checked
+const defaultCheckInterval = 5 * time.Second
 
-	// Listen for messages
-	ch := pubsub.Channel()
+// errResubscribed is returned once go-redis has replaced a dropped
+// subscription connection on its own, which it does silently, so the loss
+// is reported and counted like any other
+var errResubscribed = errors.New("subscription connection dropped and re-established")
+
+// receive passes the messages of a subscription to handle, with how many
+// more are waiting, until ctx is cancelled or the subscription is lost:
+// Redis stops answering pings every interval, or go-redis resubscribes on
+// a new connection. subscriptions is how many channels and patterns were
+// subscribed, the first confirmation having been received already.
+func receive(ctx context.Context, client *Client, pubsub *redis.PubSub, subscriptions int, interval time.Duration, handle func(msg *redis.Message, pending int)) error {
+	ch := pubsub.ChannelWithSubscriptions()
+	confirmed := 1
+
+	check := time.NewTicker(interval)
+	defer check.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			s.logger.Info("subscriber stopping")
 			return ctx.Err()
-		case msg := <-ch:
-			if msg == nil {
-				continue
+		case <-check.C:
+			if err := checkSubscription(ctx, client, pubsub, interval); err != nil {
+				return err
+			}
+		case m, ok := <-ch:
+			if !ok {
+				return errors.New("subscription channel closed")
+			}
+			switch msg := m.(type) {
+			case *redis.Subscription:
+				if msg.Kind != "subscribe" && msg.Kind != "psubscribe" {
+					continue
+				}
+				if confirmed++; confirmed > subscriptions {
+					return errResubscribed
+				}
+			case *redis.Message:
+				handle(msg, len(ch))
 			}
-			s.handleMessage(ctx, msg)
 		}
 	}
 }
 
+// checkSubscription pings Redis over the subscription's own connection and
+// over a pooled one, failing if the server does not answer within timeout
+func checkSubscription(ctx context.Context, client *Client, pubsub *redis.PubSub, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := pubsub.Ping(ctx); err != nil {
+		return fmt.Errorf("subscription ping failed: %w", err)
+	}
+	if err := client.Ping(ctx); err != nil {
+		return fmt.Errorf("redis ping failed: %w", err)
+	}
+	return nil
+}
+
+// Rejected event payloads are stored in a capped Redis list
+const (
+	deadLetterKey    = "events:dead_letter"
+	deadLetterMaxLen = 1000
+)
+
+// deadLetter is the record stored for a rejected payload
+type deadLetter struct {
+	Channel    string `json:"channel"`
+	Payload    string `json:"payload"`
+	Reason     string `json:"reason"`
+	ReceivedAt int64  `json:"received_at"`
+}
+
 func (s *Subscriber) handleMessage(ctx context.Context, msg *redis.Message) {
 	s.logger.Debug("received message",
 		zap.String("channel", msg.Channel),
 		zap.String("payload", msg.Payload),
 	)
 
-	var err error
+	channel, tenantID, ok := s.namespace.Parse(msg.Channel, events.InboundChannels())
+	switch {
+	case !ok:
+		channel = msg.Channel
+	case msg.Pattern != "" && tenantID == "":
+		// Also received on the channel's own subscription
+		return
+	}
+	ctx = events.WithNamespace(events.WithTenant(ctx, tenantID), s.namespace)
 
-	switch msg.Channel {
-	case events.ChannelUserActivity:
-		var event events.UserActivityEvent
-		if err = json.Unmarshal([]byte(msg.Payload), &event); err == nil {
-			err = s.handler.HandleUserActivity(ctx, event)
-		}
+	err := s.guard.Dispatch(ctx, s.handler, channel, []byte(msg.Payload))
 
-	case events.ChannelUserConnect:
-		var event events.UserConnectEvent
-		if err = json.Unmarshal([]byte(msg.Payload), &event); err == nil {
-			err = s.handler.HandleUserConnect(ctx, event)
-		}
-
-	case events.ChannelUserDisconnect:
-		var event events.UserDisconnectEvent
-		if err = json.Unmarshal([]byte(msg.Payload), &event); err == nil {
-			err = s.handler.HandleUserDisconnect(ctx, event)
+	var poisonErr *events.PoisonError
+	var decodeErr *events.DecodeError
+	switch {
+	case err == nil:
+	case errors.As(err, &poisonErr):
+		if !poisonErr.Repeat {
+			rejectPayload(ctx, s.client, s.logger, msg.Channel, msg.Payload, poisonErr)
 		}
+	case errors.As(err, &decodeErr):
+		rejectPayload(ctx, s.client, s.logger, msg.Channel, msg.Payload, decodeErr.Err)
+	case errors.Is(err, events.ErrUnknownChannel):
+		s.logger.Warn("unknown channel", zap.String("channel", msg.Channel))
+	default:
+		s.logger.Error("failed to handle message",
+			zap.String("channel", msg.Channel),
+			zap.Error(err),
+		)
+	}
+}
 
-	case events.ChannelNodeStatus:
-		var event events.NodeStatusEvent
-		if err = json.Unmarshal([]byte(msg.Payload), &event); err == nil {
-			err = s.handler.HandleNodeStatus(ctx, event)
-		}
+// rejectPayload moves an invalid or poison payload received on channel to
+// the dead-letter list
+func rejectPayload(ctx context.Context, client *Client, logger *zap.Logger, channel, payload string, reason error) {
+	logger.Warn("rejecting event",
+		zap.String("channel", channel),
+		zap.Error(reason),
+	)
 
-	default:
-		s.logger.Warn("unknown channel", zap.String("channel", msg.Channel))
+	data, err := json.Marshal(deadLetter{
+		Channel:    channel,
+		Payload:    payload,
+		Reason:     reason.Error(),
+		ReceivedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		logger.Error("failed to marshal dead letter", zap.Error(err))
 		return
 	}
 
-	if err != nil {
-		s.logger.Error("failed to handle message",
-			zap.String("channel", msg.Channel),
+	if err := client.PushDeadLetter(ctx, deadLetterKey, string(data), deadLetterMaxLen); err != nil {
+		logger.Error("failed to store dead letter",
+			zap.String("channel", channel),
 			zap.Error(err),
 		)
 	}
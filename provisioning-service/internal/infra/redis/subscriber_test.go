@@ -0,0 +1,169 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/events"
+	"go.uber.org/zap"
+)
+
+// fakeServer speaks just enough RESP2 for a client to connect, ping and
+// subscribe, and can drop its connections or stop answering
+type fakeServer struct {
+	ln net.Listener
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{ln: ln}
+	go s.serve()
+	t.Cleanup(s.stop)
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	subscribed := 0
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToLower(args[0]); cmd {
+		case "ping":
+			if subscribed > 0 {
+				fmt.Fprint(conn, "*2\r\n$4\r\npong\r\n$0\r\n\r\n")
+			} else {
+				fmt.Fprint(conn, "+PONG\r\n")
+			}
+		case "subscribe", "psubscribe":
+			for _, name := range args[1:] {
+				subscribed++
+				fmt.Fprintf(conn, "*3\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n:%d\r\n", len(cmd), cmd, len(name), name, subscribed)
+			}
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+	}
+}
+
+// drop closes every open connection, as a network failure would
+func (s *fakeServer) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *fakeServer) stop() {
+	s.ln.Close()
+	s.drop()
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func startSubscriber(t *testing.T, server *fakeServer) *Subscriber {
+	client, err := NewClient(Options{Addr: server.ln.Addr().String(), DialTimeout: time.Second, ReadTimeout: time.Second}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	s := NewSubscriber(client, nil, nil, events.Namespace{}, zap.NewNop())
+	s.checkInterval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitFor(t, "subscription", s.Subscribed)
+	return s
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubscriberReportsDroppedConnection(t *testing.T) {
+	server := newFakeServer(t)
+	s := startSubscriber(t, server)
+
+	// go-redis reconnects and resubscribes on its own; the loss must still
+	// be counted
+	server.drop()
+	waitFor(t, "reconnect", func() bool { return s.Reconnects() == 1 })
+	waitFor(t, "resubscription", s.Subscribed)
+}
+
+func TestSubscriberReportsUnreachableServer(t *testing.T) {
+	server := newFakeServer(t)
+	s := startSubscriber(t, server)
+
+	server.stop()
+	waitFor(t, "subscription loss", func() bool { return !s.Subscribed() })
+}
@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/user"
+	"github.com/redis/go-redis/v9"
+)
+
+// storedUser is the persisted form of a connected user
+type storedUser struct {
+	UserID          string            `json:"user_id"`
+	AllocatedNodeID string            `json:"allocated_node_id"`
+	TenantID        string            `json:"tenant_id,omitempty"`
+	Selector        map[string]string `json:"selector,omitempty"`
+	LastActivity    int64             `json:"last_activity,omitempty"` // Unix milliseconds
+	Sessions        []storedSession   `json:"sessions,omitempty"`
+}
+
+// storedSession is the persisted form of a session of a connected user
+type storedSession struct {
+	ID          string `json:"id"`
+	ConnectedAt int64  `json:"connected_at"` // Unix milliseconds
+}
+
+// UserStore keeps the connected users in a hash mapping user IDs to their
+// JSON encoded state
+type UserStore struct {
+	client *Client
+	key    string
+}
+
+// NewUserStore creates a user store under the given key
+func NewUserStore(client *Client, key string) *UserStore {
+	return &UserStore{
+		client: client,
+		key:    key,
+	}
+}
+
+// Save replaces the stored users in one transaction
+func (s *UserStore) Save(ctx context.Context, states []user.UserState) error {
+	fields := make([]any, 0, 2*len(states))
+	for _, state := range states {
+		stored := storedUser{
+			UserID:          state.UserID,
+			AllocatedNodeID: state.AllocatedNodeID,
+			TenantID:        state.TenantID,
+			Selector:        state.Selector,
+		}
+		if !state.LastActivityTime.IsZero() {
+			stored.LastActivity = state.LastActivityTime.UnixMilli()
+		}
+		for _, s := range state.Sessions {
+			stored.Sessions = append(stored.Sessions, storedSession{ID: s.ID, ConnectedAt: s.ConnectedAt.UnixMilli()})
+		}
+		data, err := json.Marshal(stored)
+		if err != nil {
+			return fmt.Errorf("failed to encode user %s: %w", state.UserID, err)
+		}
+		fields = append(fields, state.UserID, data)
+	}
+
+	_, err := s.client.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.key)
+		if len(fields) > 0 {
+			pipe.HSet(ctx, s.key, fields...)
+		}
+		return nil
+	})
+	return err
+}
+
+// Load returns the stored users
+func (s *UserStore) Load(ctx context.Context) ([]user.UserState, error) {
+	values, err := s.client.rdb.HGetAll(ctx, s.key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]user.UserState, 0, len(values))
+	for userID, value := range values {
+		var stored storedUser
+		if err := json.Unmarshal([]byte(value), &stored); err != nil {
+			return nil, fmt.Errorf("failed to decode user %s: %w", userID, err)
+		}
+		state := user.UserState{
+			UserID:          userID,
+			IsConnected:     true,
+			AllocatedNodeID: stored.AllocatedNodeID,
+			TenantID:        stored.TenantID,
+			Selector:        stored.Selector,
+		}
+		if stored.LastActivity > 0 {
+			state.LastActivityTime = time.UnixMilli(stored.LastActivity)
+		}
+		for _, s := range stored.Sessions {
+			state.Sessions = append(state.Sessions, user.Session{ID: s.ID, ConnectedAt: time.UnixMilli(s.ConnectedAt)})
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
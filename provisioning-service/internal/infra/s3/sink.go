@@ -0,0 +1,69 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/aos-cc/provisioning-service/internal/domain/session"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+)
+
+// SessionSink exports each batch of session records as a JSONL object,
+// partitioned by day: <prefix>/YYYY/MM/DD/<unix>-<uuid>.jsonl
+type SessionSink struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// NewSessionSink creates a sink writing to bucket using the default AWS
+// credential chain. Region may be empty to use the environment's.
+func NewSessionSink(ctx context.Context, bucket, prefix, region string) (*SessionSink, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	return &SessionSink{
+		client: s3.NewFromConfig(cfg),
+		bucket: bucket,
+		prefix: prefix,
+	}, nil
+}
+
+// Export uploads records as one JSONL object
+func (s *SessionSink) Export(ctx context.Context, records []session.Record) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("failed to marshal session record: %w", err)
+		}
+	}
+
+	now := time.Now().UTC()
+	key := path.Join(s.prefix, now.Format("2006/01/02"), fmt.Sprintf("%d-%s.jsonl", now.Unix(), uuid.NewString()))
+
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// TerminateNode terminates a node; force is required if a user is on it.
+// Requires the operator role.
+func (c *Client) TerminateNode(ctx context.Context, nodeID string, force bool) error {
+	r := nodeAction(nodeID, "terminate")
+	if force {
+		r.query = map[string]string{"force": "true"}
+	}
+	return c.do(ctx, r, nil)
+}
+
+// CordonNode excludes a node from new allocations. Requires the operator role.
+func (c *Client) CordonNode(ctx context.Context, nodeID string) error {
+	return c.do(ctx, nodeAction(nodeID, "cordon"), nil)
+}
+
+// UncordonNode returns a cordoned node to service. Requires the operator role.
+func (c *Client) UncordonNode(ctx context.Context, nodeID string) error {
+	return c.do(ctx, nodeAction(nodeID, "uncordon"), nil)
+}
+
+// DrainNode terminates a node once its users disconnect. Requires the
+// operator role.
+func (c *Client) DrainNode(ctx context.Context, nodeID string) error {
+	return c.do(ctx, nodeAction(nodeID, "drain"), nil)
+}
+
+func nodeAction(nodeID, action string) request {
+	return request{
+		method: http.MethodPost,
+		path:   "/admin/nodes/{nodeID}/" + action,
+		params: map[string]string{"nodeID": nodeID},
+	}
+}
+
+// DeallocateUser tears down a user's allocation, draining their node.
+// Requires the operator role.
+func (c *Client) DeallocateUser(ctx context.Context, userID string) (UserAction, error) {
+	return c.userAction(ctx, userID, "deallocate")
+}
+
+// ReassignUser moves a user to another ready node, draining their previous
+// one. Requires the operator role.
+func (c *Client) ReassignUser(ctx context.Context, userID string) (UserAction, error) {
+	return c.userAction(ctx, userID, "reassign")
+}
+
+// MigrateUser starts migrating a user's session to another ready node; the
+// user keeps their node until their client acknowledges. Requires the
+// operator role.
+func (c *Client) MigrateUser(ctx context.Context, userID string) (UserAction, error) {
+	return c.userAction(ctx, userID, "migrate")
+}
+
+func (c *Client) userAction(ctx context.Context, userID, action string) (UserAction, error) {
+	var result UserAction
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/admin/users/{userID}/" + action,
+		params: map[string]string{"userID": userID},
+	}, &result)
+	return result, err
+}
+
+// Decision returns the most recent scaling decision. Requires a pool-wide
+// viewer.
+func (c *Client) Decision(ctx context.Context) (Decision, error) {
+	var decision Decision
+	err := c.do(ctx, request{method: http.MethodGet, path: "/admin/decision"}, &decision)
+	return decision, err
+}
+
+// CheckScaling runs a scaling evaluation now; dryRun only reports the
+// decision. If provisioning fails the check is returned along with an
+// *Error. Requires a pool-wide operator.
+func (c *Client) CheckScaling(ctx context.Context, dryRun bool) (ScalingCheck, error) {
+	var result struct {
+		ScalingCheck
+		Code string `json:"code"`
+	}
+	resp, err := c.resty.R().
+		SetContext(ctx).
+		SetQueryParam("dry_run", strconv.FormatBool(dryRun)).
+		SetResult(&result).
+		SetError(&result).
+		Post("/admin/scale/check")
+	if err != nil {
+		return ScalingCheck{}, fmt.Errorf("POST /admin/scale/check: %w", err)
+	}
+	if resp.IsError() {
+		return result.ScalingCheck, &Error{StatusCode: resp.StatusCode(), Code: result.Code, Message: result.Error}
+	}
+	return result.ScalingCheck, nil
+}
+
+// SetMinReadyNodes sets the minimum number of ready nodes and returns the
+// resulting limits. Requires a pool-wide admin.
+func (c *Client) SetMinReadyNodes(ctx context.Context, n int) (ScaleLimits, error) {
+	return c.setScaleLimits(ctx, map[string]int{"min_ready_nodes": n})
+}
+
+// SetMaxReadyNodes sets the maximum number of nodes and returns the
+// resulting limits. Requires a pool-wide admin.
+func (c *Client) SetMaxReadyNodes(ctx context.Context, n int) (ScaleLimits, error) {
+	return c.setScaleLimits(ctx, map[string]int{"max_ready_nodes": n})
+}
+
+func (c *Client) setScaleLimits(ctx context.Context, body map[string]int) (ScaleLimits, error) {
+	var limits ScaleLimits
+	err := c.do(ctx, request{method: http.MethodPut, path: "/admin/scale", body: body}, &limits)
+	return limits, err
+}
+
+// PredictionConfig returns the prediction parameters in effect. Requires a
+// pool-wide viewer.
+func (c *Client) PredictionConfig(ctx context.Context) (PredictionConfig, error) {
+	var config PredictionConfig
+	err := c.do(ctx, request{method: http.MethodGet, path: "/admin/prediction/config"}, &config)
+	return config, err
+}
+
+// UpdatePredictionConfig changes the prediction parameters named in changes,
+// keyed by their JSON names, until the next change or restart, and returns
+// those in effect. Requires a pool-wide admin.
+func (c *Client) UpdatePredictionConfig(ctx context.Context, changes map[string]any) (PredictionConfig, error) {
+	var config PredictionConfig
+	err := c.do(ctx, request{method: http.MethodPut, path: "/admin/prediction/config", body: changes}, &config)
+	return config, err
+}
+
+// Drain returns the progress of draining the whole service. Requires a
+// pool-wide viewer.
+func (c *Client) Drain(ctx context.Context) (Drain, error) {
+	var drain Drain
+	err := c.do(ctx, request{method: http.MethodGet, path: "/admin/drain"}, &drain)
+	return drain, err
+}
+
+// StartDrain starts draining the whole service: no more nodes are given
+// out or created, and each node is terminated once its users leave. A drain
+// under way is left as it is. Requires a pool-wide admin.
+func (c *Client) StartDrain(ctx context.Context) (Drain, error) {
+	var drain Drain
+	err := c.do(ctx, request{method: http.MethodPost, path: "/admin/drain"}, &drain)
+	return drain, err
+}
+
+// CancelDrain stops draining the service and returns how many nodes went
+// back into service, or an *Error with CodeInvalidTransition if it was not
+// draining. Requires a pool-wide admin.
+func (c *Client) CancelDrain(ctx context.Context) (int, error) {
+	var result struct {
+		NodesRestored int `json:"nodes_restored"`
+	}
+	err := c.do(ctx, request{method: http.MethodDelete, path: "/admin/drain"}, &result)
+	return result.NodesRestored, err
+}
+
+// Access returns the access mode and lists. Requires a pool-wide viewer.
+func (c *Client) Access(ctx context.Context) (Access, error) {
+	var access Access
+	err := c.do(ctx, request{method: http.MethodGet, path: "/admin/access"}, &access)
+	return access, err
+}
+
+// AddToList adds a user to the Blocklist or Allowlist and returns the
+// resulting access. Requires a pool-wide admin.
+func (c *Client) AddToList(ctx context.Context, list, userID string) (Access, error) {
+	return c.editList(ctx, http.MethodPut, list, userID)
+}
+
+// RemoveFromList takes a user off the Blocklist or Allowlist and returns
+// the resulting access. Requires a pool-wide admin.
+func (c *Client) RemoveFromList(ctx context.Context, list, userID string) (Access, error) {
+	return c.editList(ctx, http.MethodDelete, list, userID)
+}
+
+func (c *Client) editList(ctx context.Context, method, list, userID string) (Access, error) {
+	var access Access
+	err := c.do(ctx, request{
+		method: method,
+		path:   "/admin/access/{list}/{userID}",
+		params: map[string]string{"list": list, "userID": userID},
+	}, &access)
+	return access, err
+}
+
+// LogLevel returns the service's log level. Requires a pool-wide viewer.
+func (c *Client) LogLevel(ctx context.Context) (string, error) {
+	var result struct {
+		Level string `json:"level"`
+	}
+	err := c.do(ctx, request{method: http.MethodGet, path: "/admin/loglevel"}, &result)
+	return result.Level, err
+}
+
+// SetLogLevel changes the service's log level (debug|info|warn|error) until
+// the next change or restart. Requires a pool-wide admin.
+func (c *Client) SetLogLevel(ctx context.Context, level string) (string, error) {
+	var result struct {
+		Level string `json:"level"`
+	}
+	err := c.do(ctx, request{method: http.MethodPut, path: "/admin/loglevel", body: map[string]string{"level": level}}, &result)
+	return result.Level, err
+}
+
+// Schedule returns the upcoming scheduled sessions. Requires a pool-wide
+// viewer; fails with CodeNotFound if the service does not plan for them.
+func (c *Client) Schedule(ctx context.Context) (Schedule, error) {
+	var schedule Schedule
+	err := c.do(ctx, request{method: http.MethodGet, path: "/admin/schedule"}, &schedule)
+	return schedule, err
+}
+
+// SetSessions replaces the sessions pushed through the admin API, keeping
+// those read from the calendar feed, and returns the resulting schedule.
+// Requires a pool-wide admin.
+func (c *Client) SetSessions(ctx context.Context, sessions []Session) (Schedule, error) {
+	if sessions == nil {
+		sessions = []Session{}
+	}
+	var schedule Schedule
+	err := c.do(ctx, request{
+		method: http.MethodPut,
+		path:   "/admin/schedule",
+		body:   map[string][]Session{"sessions": sessions},
+	}, &schedule)
+	return schedule, err
+}
+
+// ExportState returns the pool, allocations and tracked users as a
+// versioned JSON document for ImportState on another service, without node
+// auth tokens. Requires a pool-wide admin.
+func (c *Client) ExportState(ctx context.Context) (json.RawMessage, error) {
+	var state json.RawMessage
+	err := c.do(ctx, request{method: http.MethodGet, path: "/admin/state/export"}, &state)
+	return state, err
+}
+
+// ImportState replaces the pool, allocations and tracked users with a
+// document returned by ExportState. Requires a pool-wide admin on the
+// leader; fails with CodeInvalidRequest for a document of another schema
+// version.
+func (c *Client) ImportState(ctx context.Context, state json.RawMessage) (StateImport, error) {
+	var result StateImport
+	err := c.do(ctx, request{method: http.MethodPost, path: "/admin/state/import", body: state}, &result)
+	return result, err
+}
@@ -0,0 +1,180 @@
+// Package client is a typed Go client for the provisioning service's HTTP
+// API: pool status, node allocation, admin actions and the operations feed.
+//
+// The service has no gRPC API of its own (its only gRPC surface is the
+// predictor plugin protocol it calls out to), so the client speaks HTTP and,
+// for Watch, WebSocket.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"resty.dev/v3"
+)
+
+// Error codes the service returns; see "Error Codes" in the service README
+const (
+	CodeNoCapacity          = "NO_CAPACITY"
+	CodeAlreadyAllocated    = "ALREADY_ALLOCATED"
+	CodeProviderUnavailable = "PROVIDER_UNAVAILABLE"
+	CodeInvalidTransition   = "INVALID_TRANSITION"
+	CodeNotFound            = "NOT_FOUND"
+	CodeInvalidRequest      = "INVALID_REQUEST"
+	CodeAccessDenied        = "ACCESS_DENIED"
+	CodeBudgetExceeded      = "BUDGET_EXCEEDED"
+	CodeRateLimited         = "RATE_LIMITED"
+	CodeUnauthorized        = "UNAUTHORIZED"
+	CodeNotLeader           = "NOT_LEADER"
+	CodeDraining            = "DRAINING"
+	CodeSessionLimit        = "SESSION_LIMIT"
+	CodeDryRun              = "DRY_RUN"
+	CodeInternal            = "INTERNAL"
+)
+
+// Error is an error response from the service
+type Error struct {
+	StatusCode int    `json:"-"`     // HTTP status; zero for errors sent on the feed
+	Code       string `json:"code"`  // One of the Code constants; empty if the service sent none
+	Message    string `json:"error"` // Human-readable reason
+	RequestID  string `json:"-"`     // X-Request-ID the service logged the request under
+}
+
+// Error returns the message prefixed with its status and code, if known
+func (e *Error) Error() string {
+	msg := e.Message
+	if e.Code != "" {
+		msg = e.Code + ": " + msg
+	}
+	if e.StatusCode != 0 {
+		msg = fmt.Sprintf("%d: %s", e.StatusCode, msg)
+	}
+	return msg
+}
+
+// IsCode reports whether err is an error response carrying the given code
+func IsCode(err error, code string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// requestIDHeader correlates a request with the service's logs
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a context whose requests carry the given
+// X-Request-ID, so the service's logs and node API calls for them can be
+// matched to the caller's. Without one the service assigns an ID.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// Client calls the provisioning service. It is safe for concurrent use.
+type Client struct {
+	baseURL string
+	token   string
+	resty   *resty.Client
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithToken authenticates requests with a bearer token: the service's admin
+// token or a signed token carrying a role (see "Admin Roles" in the service
+// README)
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithTimeout bounds each request attempt; the default is 10s
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.resty.SetTimeout(timeout)
+	}
+}
+
+// WithRetries retries requests that fail to send or get a 429 or 5xx
+// response up to count times, backing off from wait. Only idempotent
+// requests (GET, PUT, DELETE) are retried, so connects and node or user
+// actions are never applied twice. The default is 2 retries from 200ms.
+func WithRetries(count int, wait time.Duration) Option {
+	return func(c *Client) {
+		c.resty.SetRetryCount(count).SetRetryWaitTime(wait)
+	}
+}
+
+// New creates a client for the service at baseURL, e.g. http://localhost:8081
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL: baseURL,
+		resty: resty.New().
+			SetBaseURL(baseURL).
+			SetTimeout(10*time.Second).
+			SetRetryCount(2).
+			SetRetryWaitTime(200*time.Millisecond).
+			SetHeader("Content-Type", "application/json"),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.token != "" {
+		// In-cluster callers commonly reach the service over plain HTTP,
+		// which resty would otherwise warn about on every request
+		c.resty.SetAuthToken(c.token).SetDisableWarn(true)
+	}
+	return c
+}
+
+// Close releases the client's idle connections
+func (c *Client) Close() error {
+	return c.resty.Close()
+}
+
+// request describes one API call
+type request struct {
+	method string
+	path   string
+	params map[string]string // Path parameters
+	query  map[string]string
+	body   any
+}
+
+// do sends a request, decoding a 2xx response into result and any other
+// into an *Error
+func (c *Client) do(ctx context.Context, r request, result any) error {
+	errResp := &Error{}
+	req := c.resty.R().
+		SetContext(ctx).
+		SetPathParams(r.params).
+		SetQueryParams(r.query).
+		SetError(errResp)
+	if id, _ := ctx.Value(requestIDKey{}).(string); id != "" {
+		req.SetHeader(requestIDHeader, id)
+	}
+	if r.body != nil {
+		req.SetBody(r.body)
+	}
+	if result != nil {
+		req.SetResult(result)
+	}
+
+	resp, err := req.Execute(r.method, r.path)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", r.method, r.path, err)
+	}
+	if resp.IsError() {
+		errResp.StatusCode = resp.StatusCode()
+		errResp.RequestID = resp.Header().Get(requestIDHeader)
+		if errResp.Message == "" {
+			errResp.Message = http.StatusText(resp.StatusCode())
+		}
+		return errResp
+	}
+	return nil
+}
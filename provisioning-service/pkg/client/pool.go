@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// Status returns the nodes, connected users and migrations the caller may
+// see. Requires the viewer role.
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	var status Status
+	err := c.do(ctx, request{method: http.MethodGet, path: "/admin/status"}, &status)
+	return status, err
+}
+
+// Nodes returns the nodes the caller may see. Requires the viewer role.
+func (c *Client) Nodes(ctx context.Context) ([]Node, error) {
+	status, err := c.Status(ctx)
+	return status.Nodes, err
+}
+
+// Users returns the connected users the caller may see. Requires the viewer
+// role.
+func (c *Client) Users(ctx context.Context) ([]User, error) {
+	status, err := c.Status(ctx)
+	return status.Users, err
+}
+
+// Node returns a node by ID, or an *Error with CodeNotFound if the caller
+// cannot see it
+func (c *Client) Node(ctx context.Context, nodeID string) (Node, error) {
+	nodes, err := c.Nodes(ctx)
+	if err != nil {
+		return Node{}, err
+	}
+	i := slices.IndexFunc(nodes, func(n Node) bool { return n.ID == nodeID })
+	if i < 0 {
+		return Node{}, &Error{StatusCode: http.StatusNotFound, Code: CodeNotFound, Message: "node not found: " + nodeID}
+	}
+	return nodes[i], nil
+}
+
+// NodeHistory returns a node's current state with its status transitions,
+// allocations and boot failures. Nodes that left the pool are described
+// until their history expires. Requires the viewer role.
+func (c *Client) NodeHistory(ctx context.Context, nodeID string) (NodeHistory, error) {
+	var history NodeHistory
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/admin/nodes/{nodeID}",
+		params: map[string]string{"nodeID": nodeID},
+	}, &history)
+	return history, err
+}
+
+// UserHistory returns a user's activity, prediction status, allocations
+// and sessions. Requires the viewer role.
+func (c *Client) UserHistory(ctx context.Context, userID string) (UserHistory, error) {
+	var history UserHistory
+	err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/admin/users/{userID}",
+		params: map[string]string{"userID": userID},
+	}, &history)
+	return history, err
+}
+
+// UserActivity returns a user's activity per minute within window, or over
+// the whole activity horizon when window is 0. Requires the viewer role.
+func (c *Client) UserActivity(ctx context.Context, userID string, window time.Duration) (ActivityStats, error) {
+	r := request{
+		method: http.MethodGet,
+		path:   "/admin/users/{userID}",
+		params: map[string]string{"userID": userID},
+	}
+	if window > 0 {
+		r.query = map[string]string{"window": window.String()}
+	}
+	var history UserHistory
+	err := c.do(ctx, r, &history)
+	return history.Activity, err
+}
+
+// Allocate connects a user and returns the node serving them, with the
+// token to attach with. A user who already holds a node gets that node.
+// Allocation failures are returned as an *Error, with CodeNoCapacity when
+// no node is free; the service starts a node in that case, so the connect
+// is worth retrying shortly. Requires a pool-wide operator.
+func (c *Client) Allocate(ctx context.Context, req AllocateRequest) (Allocation, error) {
+	var allocation Allocation
+	err := c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/admin/users/{userID}/allocate",
+		params: map[string]string{"userID": req.UserID},
+		body:   req,
+	}, &allocation)
+	return allocation, err
+}
+
+// Confirm confirms a user attached to the node reserved for them
+func (c *Client) Confirm(ctx context.Context, userID, nodeID string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/events/confirm",
+		body:   map[string]string{"user_id": userID, "node_id": nodeID},
+	}, nil)
+}
+
+// Release disconnects a user, returning their node to the pool
+func (c *Client) Release(ctx context.Context, userID string) error {
+	return c.do(ctx, request{
+		method: http.MethodPost,
+		path:   "/events/disconnect",
+		body:   map[string]string{"user_id": userID},
+	}, nil)
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/admin/users/u1/allocate" {
+			t.Errorf("request = %s %s, want the user's allocate", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q", got)
+		}
+		var req AllocateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.TenantID != "acme" {
+			t.Errorf("body = %+v (%v), want the request", req, err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"user_id":"u1","node_id":"n1","status":"unconfirmed","address":"10.0.0.1","port":9000,"auth_token":"tok","reserved_until":1700000000}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL, WithToken("secret"))
+	defer c.Close()
+
+	allocation, err := c.Allocate(context.Background(), AllocateRequest{UserID: "u1", TenantID: "acme"})
+	if err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	want := Allocation{UserID: "u1", NodeID: "n1", Status: AllocationUnconfirmed, Address: "10.0.0.1", Port: 9000, AuthToken: "tok", ReservedUntil: 1700000000}
+	if allocation != want {
+		t.Errorf("allocation = %+v, want %+v", allocation, want)
+	}
+}
+
+func TestAllocateNoCapacity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"error":"no ready node available","code":"NO_CAPACITY"}`))
+	}))
+	defer server.Close()
+
+	c := New(server.URL)
+	defer c.Close()
+
+	if _, err := c.Allocate(context.Background(), AllocateRequest{UserID: "u1"}); !IsCode(err, CodeNoCapacity) {
+		t.Errorf("err = %v, want no capacity", err)
+	}
+}
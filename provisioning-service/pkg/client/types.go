@@ -0,0 +1,391 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Termination reasons
+const (
+	TerminationIdle         = "idle"          // Ready past its idle timeout
+	TerminationBurstIdle    = "burst_idle"    // A burst node ready past its shorter idle timeout
+	TerminationScaleDown    = "scale_down"    // Retired as surplus to forecast demand
+	TerminationBudget       = "budget"        // Retired while the pool ran above max_hourly_spend
+	TerminationStuck        = "stuck"         // Still booting past its boot timeout
+	TerminationFailedChecks = "failed_checks" // Failed its pre-ready hooks
+	TerminationIncompatible = "incompatible"  // Runs an agent version that is not allowed
+	TerminationRotated      = "rotated"       // Reached the maximum node age
+	TerminationAdmin        = "admin"         // Terminated or drained by an operator, or left by a user they deallocated or reassigned
+	TerminationIdleReclaim  = "idle_reclaim"  // Left by a user whose idle allocation was reclaimed
+	TerminationMigrated     = "migrated"      // Left by a user whose migration was not acknowledged
+	TerminationInterruption = "interruption"  // Reported terminated without being asked
+	TerminationDrift        = "drift"         // Left by a user the consistency check found allocated another node
+	TerminationVanished     = "vanished"      // Gone from its provider while the service was down
+	TerminationDecommission = "decommission"  // Drained with the whole service
+	TerminationImport       = "import"        // Left out of an imported state
+)
+
+// Node statuses
+const (
+	NodeBooting     = "booting"
+	NodeReady       = "ready"
+	NodeUnconfirmed = "unconfirmed" // Every user on the node has yet to confirm
+	NodeAllocated   = "allocated"
+	NodeTerminating = "terminating"
+	NodeTerminated  = "terminated"
+)
+
+// Allocation statuses
+const (
+	AllocationAllocated        = "allocated"         // The user holds the node
+	AllocationUnconfirmed      = "unconfirmed"       // The node is held until the user confirms attaching
+	AllocationAlreadyAllocated = "already_allocated" // The user held the node before the connect
+)
+
+// Status is the pool as the caller may see it; a tenant's callers only see
+// their tenant's nodes, users and migrations
+type Status struct {
+	Nodes      []Node      `json:"nodes"`
+	Users      []User      `json:"users"`
+	Migrations []Migration `json:"migrations"`
+	Replica    string      `json:"replica"`   // Replica that answered; empty without replication
+	Leader     bool        `json:"leader"`    // Whether the replica that answered leads
+	Timestamp  int64       `json:"timestamp"` // Unix seconds
+}
+
+// Node is a node in the pool. Timestamps are Unix seconds, zero if unset.
+type Node struct {
+	ID           string            `json:"id"`
+	Status       string            `json:"status"`
+	Users        []string          `json:"users"`
+	Capacity     int               `json:"capacity"`
+	AgentVersion string            `json:"agent_version"`
+	InstanceType string            `json:"instance_type"`
+	Labels       map[string]string `json:"labels"`
+	Provider     string            `json:"provider"`
+	Zone         string            `json:"zone"`     // Requested zone; empty if the provider chose
+	Purchase     string            `json:"purchase"` // reserved|spot|on_demand as requested; empty if the provider chose
+	Address      string            `json:"address"`
+	Hostname     string            `json:"hostname"`
+	Port         int               `json:"port"`
+	Cordoned     bool              `json:"cordoned"`
+	Draining     bool              `json:"draining"`
+	Burst        bool              `json:"burst"` // Provisioned above its pool's max ready nodes; retired first
+	ReservedFor  string            `json:"reserved_for"`
+	DedicatedTo  string            `json:"dedicated_to"`
+	Unconfirmed  []string          `json:"unconfirmed"` // Users the node is reserved for until they confirm
+	Utilization  *Utilization      `json:"utilization"` // Nil if the node reports none
+	BusyAt       int64             `json:"busy_at"`
+	CreatedAt    int64             `json:"created_at"`
+	UpdatedAt    int64             `json:"updated_at"`
+
+	// One of the Termination constants for terminated and draining nodes
+	TerminationReason string `json:"termination_reason"`
+	TerminatedAt      int64  `json:"terminated_at"`
+}
+
+// NodeHistory is a node's current state and the history the service has
+// journaled for it. Timestamps of journaled entries are Unix milliseconds.
+type NodeHistory struct {
+	ID           string             `json:"id"`
+	InPool       bool               `json:"in_pool"`  // False once the node has left the pool
+	Archived     bool               `json:"archived"` // The node was terminated and purged from the pool; Node is its final record
+	Node         *Node              `json:"node"`     // Nil unless InPool or Archived
+	Transitions  []StatusChange     `json:"transitions"`
+	Allocations  []AllocationRecord `json:"allocations"`
+	Provisioning Provisioning       `json:"provisioning"`
+	Events       []FeedEvent        `json:"events"` // Every journaled event, oldest first
+}
+
+// StatusChange is a node moving from one status to another
+type StatusChange struct {
+	From      string `json:"from"` // Empty when the node was provisioned
+	To        string `json:"to"`
+	Reason    string `json:"reason"`
+	UserID    string `json:"user_id"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// AllocationRecord is a user being allocated to or leaving a node
+type AllocationRecord struct {
+	Action         string `json:"action"` // One of the actions of an AllocationChange
+	UserID         string `json:"user_id"`
+	NodeID         string `json:"node_id"`
+	PreviousNodeID string `json:"previous_node_id"`
+	Timestamp      int64  `json:"timestamp"`
+}
+
+// Provisioning describes how a node was started
+type Provisioning struct {
+	Provider     string        `json:"provider"`
+	InstanceType string        `json:"instance_type"`
+	BootAttempt  int           `json:"boot_attempt"` // Nodes tried in a row for this one to boot
+	CreatedAt    int64         `json:"created_at"`   // Unix seconds; zero once the node left the pool
+	BootFailures []BootFailure `json:"boot_failures"`
+}
+
+// BootFailure is a node failing to boot
+type BootFailure struct {
+	Attempt     int             `json:"attempt"`
+	Reason      string          `json:"reason"`
+	Diagnostics json.RawMessage `json:"diagnostics"`
+	Timestamp   int64           `json:"timestamp"`
+}
+
+// Utilization is a node's latest resource usage sample
+type Utilization struct {
+	GPUPercent    float64 `json:"gpu_percent"`
+	MemoryPercent float64 `json:"memory_percent"`
+	ReportedAt    int64   `json:"reported_at"`
+}
+
+// User is a connected user
+type User struct {
+	UserID          string `json:"user_id"`
+	AllocatedNodeID string `json:"allocated_node_id"`
+	OpenSessions    int    `json:"open_sessions"` // Sessions of the user sharing their node
+	LastActivity    int64  `json:"last_activity"`
+	ActivityCount   int    `json:"activity_count"`
+
+	// Activities weighted by type, and the count of each weighted type;
+	// untyped activities are counted under "", types without a weight
+	// under "other"
+	ActivityScore float64        `json:"activity_score"`
+	Activities    map[string]int `json:"activities"`
+}
+
+// UserHistory is what the service knows of a user: their activity, whether
+// they are predicted to connect, and their journaled allocations and
+// sessions. Timestamps of journaled entries are Unix milliseconds.
+type UserHistory struct {
+	UserID          string             `json:"user_id"`
+	Known           bool               `json:"known"` // False once the user is no longer tracked
+	TenantID        string             `json:"tenant_id"`
+	Tier            string             `json:"tier"`
+	Selector        map[string]string  `json:"selector"`
+	InstanceType    string             `json:"preferred_instance_type"` // Preferred by the user's last connect; empty for any
+	Connected       bool               `json:"connected"`
+	AllocatedNodeID string             `json:"allocated_node_id"`
+	OpenSessions    []OpenSession      `json:"open_sessions"` // Sessions of the connected user, oldest first
+	LastNodeID      string             `json:"last_node_id"`  // Node the user last had, preferred on their next connect while ready
+	WaitingSince    int64              `json:"waiting_since"` // Unix seconds the user has waited for a node since; zero if not waiting
+	Activity        ActivityStats      `json:"activity"`
+	Prediction      UserPrediction     `json:"prediction"`
+	Recommendation  *Recommendation    `json:"recommendation"` // Nil when no utilization or sized activity is recorded
+	Allocations     []AllocationRecord `json:"allocations"`
+	Sessions        []UserSession      `json:"sessions"`
+	Events          []FeedEvent        `json:"events"` // Every journaled event, oldest first
+}
+
+// OpenSession is one device of a connected user attached to their node
+type OpenSession struct {
+	SessionID   string `json:"session_id"`   // Empty for a connect that carried none
+	ConnectedAt int64  `json:"connected_at"` // Unix seconds
+}
+
+// ActivityStats is a user's recorded activity
+type ActivityStats struct {
+	Count          int              `json:"count"` // Activities within the window
+	Score          float64          `json:"score"` // Weighted by activity type
+	Activities     map[string]int   `json:"activities"`
+	LastActivity   int64            `json:"last_activity"` // Unix seconds
+	WindowSeconds  float64          `json:"window_seconds"`
+	HorizonSeconds float64          `json:"horizon_seconds"` // How long activity is kept per minute
+	Histogram      []ActivityBucket `json:"histogram"`       // Minutes with activity within the window, oldest first
+	Peak           ActivityBucket   `json:"peak"`            // Busiest minute within the window
+	Burst          ActivityBurst    `json:"burst"`
+}
+
+// ActivityBucket counts a user's activities within one minute
+type ActivityBucket struct {
+	Start int64 `json:"start"` // Unix seconds
+	Count int   `json:"count"`
+}
+
+// ActivityBurst compares a user's activity in the current minute with their
+// activity per minute over the horizon before it
+type ActivityBurst struct {
+	Bursting bool    `json:"bursting"`
+	Count    int     `json:"count"`
+	Baseline float64 `json:"baseline"`
+}
+
+// UserPrediction is whether a user counts towards predicted demand, and why
+type UserPrediction struct {
+	Likely                bool    `json:"likely"`
+	Reason                string  `json:"reason"`
+	Score                 float64 `json:"score"`
+	Threshold             int     `json:"threshold"`
+	ActivityWindowSeconds float64 `json:"activity_window_seconds"`
+}
+
+// Recommendation is the instance type recommended for a user's next
+// allocation, and why
+type Recommendation struct {
+	InstanceType      string  `json:"instance_type"` // Of the node the user was last sampled on
+	Recommended       string  `json:"recommended"`   // Empty when there is nothing to go on
+	Direction         string  `json:"direction"`     // keep, smaller or larger; empty if InstanceType is not a listed size
+	Reason            string  `json:"reason"`
+	Samples           int     `json:"samples"`
+	AverageGPUPercent float64 `json:"average_gpu_percent"`
+	PeakGPUPercent    float64 `json:"peak_gpu_percent"`
+	PeakMemoryPercent float64 `json:"peak_memory_percent"`
+}
+
+// UserSession is a user's billed stay on one node
+type UserSession struct {
+	NodeID          string  `json:"node_id"`
+	InstanceType    string  `json:"instance_type"`
+	StartedAt       int64   `json:"started_at"`
+	EndedAt         int64   `json:"ended_at"`   // Zero while the session is open
+	EndReason       string  `json:"end_reason"` // Why it ended, e.g. "disconnect"; empty while open
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// Migration is a user's session moving between nodes
+type Migration struct {
+	MigrationID    string `json:"migration_id"`
+	UserID         string `json:"user_id"`
+	PreviousNodeID string `json:"previous_node_id"`
+	NodeID         string `json:"node_id"`
+	Reason         string `json:"reason"`
+	StartedAt      int64  `json:"started_at"`
+	Deadline       int64  `json:"deadline"`
+	State          string `json:"state"`
+}
+
+// AllocateRequest asks for a node for a user
+type AllocateRequest struct {
+	UserID    string            `json:"user_id"`
+	SessionID string            `json:"session_id,omitempty"` // Device session; sessions of one user share their node
+	TenantID  string            `json:"tenant_id,omitempty"`
+	Tier      string            `json:"tier,omitempty"`     // Service tier bounding how long the user waits
+	Selector  map[string]string `json:"selector,omitempty"` // Labels the node must have
+}
+
+// Allocation is the node serving a user
+type Allocation struct {
+	UserID        string `json:"user_id"`
+	NodeID        string `json:"node_id"`
+	Status        string `json:"status"` // AllocationAllocated, AllocationUnconfirmed or AllocationAlreadyAllocated
+	Address       string `json:"address"`
+	Hostname      string `json:"hostname"`
+	Port          int    `json:"port"`
+	AuthToken     string `json:"auth_token"`     // Token the user attaches to the node with
+	ReservedUntil int64  `json:"reserved_until"` // Unix seconds to Confirm by while unconfirmed
+}
+
+// UserAction is the outcome of a deallocate, reassign or migrate
+type UserAction struct {
+	UserID         string `json:"user_id"`
+	PreviousNodeID string `json:"previous_node_id"`
+	NodeID         string `json:"node_id"`      // Empty after a deallocate
+	MigrationID    string `json:"migration_id"` // Set by a migrate
+	State          string `json:"state"`
+}
+
+// Decision is a scaling decision
+type Decision struct {
+	ShouldScaleUp   bool           `json:"should_scale_up"`
+	ShouldScaleDown bool           `json:"should_scale_down"`
+	TargetNodes     int            `json:"target_nodes"`
+	BurstNodes      int            `json:"burst_nodes"` // Nodes of a scale-up above max ready nodes
+	Reason          string         `json:"reason"`
+	InstanceTypes   []TypeDecision `json:"instance_types"`
+	DecidedAt       int64          `json:"decided_at"` // Zero if no decision has been made yet
+}
+
+// TypeDecision is the scaling decision for one instance type pool
+type TypeDecision struct {
+	InstanceType    string            `json:"instance_type"`
+	Labels          map[string]string `json:"labels"`
+	ShouldScaleUp   bool              `json:"should_scale_up"`
+	ShouldScaleDown bool              `json:"should_scale_down"`
+	TargetNodes     int               `json:"target_nodes"`
+	BurstNodes      int               `json:"burst_nodes"`
+	Reason          string            `json:"reason"`
+}
+
+// ScalingCheck is the outcome of a scaling evaluation run on demand
+type ScalingCheck struct {
+	Decision
+	DryRun        bool   `json:"dry_run"`  // The decision was only reported
+	Deferred      bool   `json:"deferred"` // A scale-up was held back by the cooldown
+	Provisioned   int    `json:"provisioned"`
+	BudgetBlocked int    `json:"budget_blocked"`
+	Error         string `json:"error"` // Why provisioning failed, if it did
+}
+
+// ScaleLimits are the pool size limits
+type ScaleLimits struct {
+	MinReadyNodes int `json:"min_ready_nodes"`
+	MaxReadyNodes int `json:"max_ready_nodes"`
+}
+
+// PredictionConfig is the prediction parameters in effect. With instance
+// types configured, the pool limits and idle timeout are the default type's.
+type PredictionConfig struct {
+	ActivityWindowSeconds         float64 `json:"activity_window_seconds"`
+	ActivityThreshold             int     `json:"activity_threshold"`
+	PredictionWindowSeconds       float64 `json:"prediction_window_seconds"`
+	TargetHeadroom                float64 `json:"target_headroom"`
+	MinReadyNodes                 int     `json:"min_ready_nodes"`
+	MaxReadyNodes                 int     `json:"max_ready_nodes"`
+	BurstMaxNodes                 int     `json:"burst_max_nodes"`
+	IdleTerminationTimeoutSeconds float64 `json:"idle_termination_timeout_seconds"`
+	SurplusMargin                 float64 `json:"surplus_margin"`
+}
+
+// Drain is the progress of draining the whole service. StartedAt is Unix
+// seconds, zero unless draining.
+type Drain struct {
+	Draining      bool  `json:"draining"`
+	StartedAt     int64 `json:"started_at"`
+	NodesAtStart  int   `json:"nodes_at_start"` // Nodes not terminated when the drain started
+	Nodes         int   `json:"nodes"`          // Nodes not yet terminated
+	OccupiedNodes int   `json:"occupied_nodes"` // Nodes still hosting users
+	Users         int   `json:"users"`          // Users still on nodes
+	Complete      bool  `json:"complete"`       // Every node is terminated
+}
+
+// Session is a scheduled class or workshop the pool pre-provisions for
+type Session struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title,omitempty"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Attendees int       `json:"attendees"`
+	TenantID  string    `json:"tenant_id,omitempty"`
+	Source    string    `json:"source,omitempty"` // feed|api; set by the service
+}
+
+// Schedule is the upcoming sessions and the attendees expected within the
+// prediction window
+type Schedule struct {
+	Sessions          []Session `json:"sessions"`
+	ExpectedAttendees float64   `json:"expected_attendees"`
+	ExpectedSessions  []string  `json:"expected_sessions"` // IDs of the sessions counted
+}
+
+// Access lists
+const (
+	Blocklist = "blocklist"
+	Allowlist = "allowlist"
+)
+
+// Access is the access mode and lists
+type Access struct {
+	Mode       string   `json:"mode"`
+	Blocklist  []string `json:"blocklist"`
+	Allowlist  []string `json:"allowlist"`
+	Authorizer bool     `json:"authorizer"`
+}
+
+// StateImport is what an import replaced the pool and tracked users with
+type StateImport struct {
+	SchemaVersion  int `json:"schema_version"`
+	Nodes          int `json:"nodes"`
+	Users          int `json:"users"`
+	ConnectedUsers int `json:"connected_users"`
+	ReplacedNodes  int `json:"replaced_nodes"` // Nodes left out of the document, terminated
+}
@@ -0,0 +1,215 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/fasthttp/websocket"
+)
+
+// Feed event types
+const (
+	EventScalingDecision = "scaling_decision"
+	EventAllocation      = "allocation"
+	EventNodeTransition  = "node_transition"
+	EventBootFailure     = "boot_failure"
+	EventLatencyBreach   = "latency_breach"
+	EventEscalation      = "latency_escalation"
+	EventIdleWarning     = "idle_warning"
+)
+
+// WatchFilter selects the feed events a watch receives; empty fields match
+// everything. A tenant's callers only receive their tenant's events.
+type WatchFilter struct {
+	Types    []string `json:"types,omitempty"`
+	TenantID string   `json:"tenant_id,omitempty"`
+	NodeID   string   `json:"node_id,omitempty"`
+}
+
+// FeedEvent is an event on the operations feed. Data holds the payload for
+// the event's type, which Decode reads.
+type FeedEvent struct {
+	Type      string          `json:"type"`
+	NodeID    string          `json:"node_id"`
+	UserID    string          `json:"user_id"`
+	TenantID  string          `json:"tenant_id"`
+	Timestamp int64           `json:"timestamp"` // Unix milliseconds
+	Data      json.RawMessage `json:"data"`
+}
+
+// Decode reads the event's payload into v, e.g. an *AllocationChange for an
+// EventAllocation
+func (e FeedEvent) Decode(v any) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// AllocationChange is the payload of an EventAllocation
+type AllocationChange struct {
+	Action         string `json:"action"` // allocated|unconfirmed|confirmed|expired|released|deallocated|reassigned|migrated|reclaimed
+	PreviousNodeID string `json:"previous_node_id"`
+}
+
+// NodeTransition is the payload of an EventNodeTransition
+type NodeTransition struct {
+	From         string `json:"from"` // Empty for a node new to the pool
+	To           string `json:"to"`
+	InstanceType string `json:"instance_type"`
+	Reason       string `json:"reason"` // One of the Termination constants when To is terminated
+}
+
+// IdleWarning is the payload of an EventIdleWarning
+type IdleWarning struct {
+	Warning     int     `json:"warning"`
+	Warnings    int     `json:"warnings"`
+	IdleSeconds float64 `json:"idle_seconds"`
+	GPUPercent  float64 `json:"gpu_percent"`
+	ReclaimAt   int64   `json:"reclaim_at"` // Unix seconds
+}
+
+// Watch streams the operations feed until it is closed, its context is
+// done or the connection drops
+type Watch struct {
+	conn   *websocket.Conn
+	events chan FeedEvent
+	done   chan struct{}
+
+	closeOnce sync.Once
+	writeMu   sync.Mutex
+	err       error // Set before events is closed
+}
+
+// Watch opens the operations feed with an initial filter. Requires the
+// viewer role.
+func (c *Client) Watch(ctx context.Context, filter WatchFilter) (*Watch, error) {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse base URL: %w", err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/ws"
+	query := url.Values{}
+	if len(filter.Types) > 0 {
+		query.Set("types", strings.Join(filter.Types, ","))
+	}
+	if filter.TenantID != "" {
+		query.Set("tenant_id", filter.TenantID)
+	}
+	if filter.NodeID != "" {
+		query.Set("node_id", filter.NodeID)
+	}
+	u.RawQuery = query.Encode()
+
+	header := http.Header{}
+	if c.token != "" {
+		header.Set("Authorization", "Bearer "+c.token)
+	}
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			defer resp.Body.Close()
+			errResp := &Error{StatusCode: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+			json.NewDecoder(resp.Body).Decode(errResp)
+			return nil, errResp
+		}
+		return nil, fmt.Errorf("dial feed: %w", err)
+	}
+
+	w := &Watch{
+		conn:   conn,
+		events: make(chan FeedEvent, 64),
+		done:   make(chan struct{}),
+	}
+	go w.read()
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.Close()
+		case <-w.done:
+		}
+	}()
+	return w, nil
+}
+
+// Events returns the feed's events; it is closed when the watch ends, after
+// which Err reports why
+func (w *Watch) Events() <-chan FeedEvent {
+	return w.events
+}
+
+// Err returns the error that ended the watch, or nil if it was closed. It
+// is only meaningful once Events is closed.
+func (w *Watch) Err() error {
+	return w.err
+}
+
+// SetFilter replaces the watch's filter
+func (w *Watch) SetFilter(filter WatchFilter) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	return w.conn.WriteJSON(filter)
+}
+
+// Close ends the watch
+func (w *Watch) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		w.writeMu.Lock()
+		w.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		w.writeMu.Unlock()
+		err = w.conn.Close()
+	})
+	return err
+}
+
+// read delivers feed events until the connection ends. Filter
+// acknowledgements are dropped; a rejected filter ends the watch.
+func (w *Watch) read() {
+	defer close(w.events)
+
+	for {
+		_, data, err := w.conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-w.done:
+			default:
+				w.err = fmt.Errorf("read feed: %w", err)
+				w.Close()
+			}
+			return
+		}
+
+		var e FeedEvent
+		if err := json.Unmarshal(data, &e); err != nil {
+			w.err = fmt.Errorf("decode feed event: %w", err)
+			w.Close()
+			return
+		}
+		switch e.Type {
+		case "subscribed":
+			continue
+		case "error":
+			errResp := &Error{}
+			json.Unmarshal(data, errResp)
+			w.err = errResp
+			w.Close()
+			return
+		}
+
+		select {
+		case w.events <- e:
+		case <-w.done:
+			return
+		}
+	}
+}
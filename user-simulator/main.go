@@ -44,9 +44,10 @@ type User struct {
 }
 
 type UserSimulator struct {
-	redisClient *redis.Client
-	ctx         context.Context
-	users       []*User
+	redisClient   *redis.Client
+	ctx           context.Context
+	users         []*User
+	channelPrefix string // Prefix of the channels the provisioning service listens on
 }
 
 func NewUserSimulator() *UserSimulator {
@@ -86,9 +87,10 @@ func NewUserSimulator() *UserSimulator {
 	}
 
 	return &UserSimulator{
-		redisClient: rdb,
-		ctx:         context.Background(),
-		users:       users,
+		redisClient:   rdb,
+		ctx:           context.Background(),
+		users:         users,
+		channelPrefix: os.Getenv("CHANNEL_PREFIX"),
 	}
 }
 
@@ -121,7 +123,7 @@ func (us *UserSimulator) simulateUserActivity() {
 			}
 
 			data, _ := json.Marshal(activity)
-			err := us.redisClient.Publish(us.ctx, "user:activity", data).Err()
+			err := us.redisClient.Publish(us.ctx, us.channelPrefix+"user:activity", data).Err()
 			if err != nil {
 				log.Printf("Failed to publish user activity: %v", err)
 			} else {
@@ -206,7 +208,7 @@ func (us *UserSimulator) connectUser(user *User) {
 	}
 
 	data, _ := json.Marshal(connect)
-	err := us.redisClient.Publish(us.ctx, "user:connect", data).Err()
+	err := us.redisClient.Publish(us.ctx, us.channelPrefix+"user:connect", data).Err()
 	if err != nil {
 		log.Printf("Failed to publish user connect: %v", err)
 	} else {
@@ -237,7 +239,7 @@ func (us *UserSimulator) disconnectUser(user *User) {
 	}
 
 	data, _ := json.Marshal(disconnect)
-	err = us.redisClient.Publish(us.ctx, "user:disconnect", data).Err()
+	err = us.redisClient.Publish(us.ctx, us.channelPrefix+"user:disconnect", data).Err()
 	if err != nil {
 		log.Printf("Failed to publish user disconnect: %v", err)
 	} else {